@@ -1,6 +1,7 @@
 package auth
 
 import (
+	"context"
 	"fmt"
 	"kiro2api/logger"
 	"kiro2api/types"
@@ -57,6 +58,14 @@ func (as *AuthService) GetToken() (types.TokenInfo, error) {
 	return as.tokenManager.getBestToken()
 }
 
+// GetTokenContext 获取可用的token，ctx 用于关联追踪
+func (as *AuthService) GetTokenContext(ctx context.Context) (types.TokenInfo, error) {
+	if as.tokenManager == nil {
+		return types.TokenInfo{}, fmt.Errorf("token管理器未初始化")
+	}
+	return as.tokenManager.getBestTokenContext(ctx)
+}
+
 // GetTokenWithUsage 获取可用的token（包含使用信息）
 func (as *AuthService) GetTokenWithUsage() (*types.TokenWithUsage, error) {
 	if as.tokenManager == nil {
@@ -65,6 +74,14 @@ func (as *AuthService) GetTokenWithUsage() (*types.TokenWithUsage, error) {
 	return as.tokenManager.GetBestTokenWithUsage()
 }
 
+// GetTokenWithUsageContext 获取可用的token（包含使用信息），ctx 用于关联追踪
+func (as *AuthService) GetTokenWithUsageContext(ctx context.Context) (*types.TokenWithUsage, error) {
+	if as.tokenManager == nil {
+		return nil, fmt.Errorf("token管理器未初始化")
+	}
+	return as.tokenManager.GetBestTokenWithUsageContext(ctx)
+}
+
 // GetTokenManager 获取底层的TokenManager（用于高级操作）
 func (as *AuthService) GetTokenManager() *TokenManager {
 	return as.tokenManager
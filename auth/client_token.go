@@ -9,6 +9,7 @@ import (
 	"time"
 
 	"kiro2api/logger"
+	"kiro2api/utils"
 )
 
 // ClientToken 客户端认证令牌
@@ -17,6 +18,8 @@ type ClientToken struct {
 	Name      string    `json:"name,omitempty"`     // 可选名称/标签
 	Disabled  bool      `json:"disabled,omitempty"` // 是否禁用
 	CreatedAt time.Time `json:"createdAt"`          // 创建时间
+	// DebugCapture 为 true 时该令牌的每个请求都保存调试捕获包
+	DebugCapture bool `json:"debugCapture,omitempty"`
 }
 
 // ClientTokenStats 客户端令牌运行时统计
@@ -27,6 +30,7 @@ type ClientTokenStats struct {
 	CreatedAt    time.Time `json:"createdAt"`    // 创建时间
 	RequestCount int64     `json:"requestCount"` // 请求次数
 	LastUsedAt   *time.Time `json:"lastUsedAt"`  // 最后使用时间（可能为空）
+	DebugCapture bool      `json:"debugCapture"` // 是否开启调试捕获
 }
 
 // ClientTokenManager 客户端令牌管理器
@@ -54,8 +58,8 @@ func NewClientTokenManager() (*ClientTokenManager, error) {
 		stats:  make(map[string]*tokenStats),
 	}
 
-	// 确定配置文件路径
-	manager.configFile = clientTokenConfigFile
+	// 确定配置文件路径（可通过 CLIENT_TOKENS_FILE 配置）
+	manager.configFile = utils.GetEnvWithDefault("CLIENT_TOKENS_FILE", clientTokenConfigFile)
 
 	// 尝试加载配置
 	if err := manager.loadConfig(); err != nil {
@@ -74,6 +78,11 @@ func NewClientTokenManager() (*ClientTokenManager, error) {
 		}
 	}
 
+	// 客户端令牌没有固定格式，登记后由日志统一脱敏
+	for _, t := range manager.tokens {
+		logger.RegisterSecret(t.Token)
+	}
+
 	logger.Info("ClientTokenManager 初始化完成",
 		logger.Int("token_count", len(manager.tokens)))
 
@@ -140,6 +149,19 @@ func (m *ClientTokenManager) ValidateToken(token string) bool {
 	return false
 }
 
+// TokenName 返回令牌的名称（未找到或未命名时返回空串）
+func (m *ClientTokenManager) TokenName(token string) string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, t := range m.tokens {
+		if t.Token == token {
+			return t.Name
+		}
+	}
+	return ""
+}
+
 // HasTokens 检查是否有可用的令牌
 func (m *ClientTokenManager) HasTokens() bool {
 	m.mu.RLock()
@@ -159,6 +181,7 @@ func (m *ClientTokenManager) GetAllStats() []ClientTokenStats {
 			Name:      t.Name,
 			Disabled:  t.Disabled,
 			CreatedAt: t.CreatedAt,
+			DebugCapture: t.DebugCapture,
 		}
 
 		if s, ok := m.stats[t.Token]; ok {
@@ -207,6 +230,7 @@ func (m *ClientTokenManager) AddToken(token, name string) error {
 		return fmt.Errorf("保存配置失败: %w", err)
 	}
 
+	logger.RegisterSecret(token)
 	logger.Info("添加客户端令牌",
 		logger.String("name", name),
 		logger.Int("total_count", len(m.tokens)))
@@ -270,6 +294,44 @@ func (m *ClientTokenManager) ToggleToken(index int) error {
 	return nil
 }
 
+// SetDebugCapture 设置令牌的调试捕获开关
+func (m *ClientTokenManager) SetDebugCapture(index int, enabled bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if index < 0 || index >= len(m.tokens) {
+		return fmt.Errorf("无效的索引: %d", index)
+	}
+
+	previous := m.tokens[index].DebugCapture
+	m.tokens[index].DebugCapture = enabled
+
+	// 持久化
+	if err := m.saveConfig(); err != nil {
+		m.tokens[index].DebugCapture = previous // 回滚
+		return fmt.Errorf("保存配置失败: %w", err)
+	}
+
+	logger.Info("设置客户端令牌调试捕获",
+		logger.Int("index", index),
+		logger.Bool("debug_capture", enabled))
+
+	return nil
+}
+
+// DebugCaptureEnabled 判断令牌是否开启了调试捕获
+func (m *ClientTokenManager) DebugCaptureEnabled(token string) bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, t := range m.tokens {
+		if t.Token == token {
+			return t.DebugCapture
+		}
+	}
+	return false
+}
+
 // GetTokenCount 获取令牌数量
 func (m *ClientTokenManager) GetTokenCount() int {
 	m.mu.RLock()
@@ -34,9 +34,25 @@ var (
 	configMutex    sync.RWMutex
 )
 
-// loadConfigs 从环境变量或默认配置文件加载配置
-// 配置加载优先级: 环境变量文件 > 默认配置文件 > 环境变量 JSON
+// loadConfigs 从环境变量或默认配置文件加载配置，过滤无效和禁用的配置
 func loadConfigs() ([]AuthConfig, error) {
+	configs, err := LoadStoredConfigs()
+	if err != nil || len(configs) == 0 {
+		return configs, err
+	}
+
+	validConfigs := processConfigsForRuntime(configs)
+
+	logger.Info("成功加载认证配置",
+		logger.Int("总配置数", len(configs)),
+		logger.Int("有效配置数", len(validConfigs)))
+
+	return validConfigs, nil
+}
+
+// LoadStoredConfigs 加载配置中的全部账号（包括禁用和不完整的），供命令行工具修改后通过 SaveConfigs 写回
+// 配置加载优先级: 环境变量文件 > 默认配置文件 > 环境变量 JSON
+func LoadStoredConfigs() ([]AuthConfig, error) {
 	// 检测并警告弃用的环境变量
 	deprecatedVars := []string{
 		"REFRESH_TOKEN",
@@ -120,15 +136,16 @@ func loadConfigs() ([]AuthConfig, error) {
 		return []AuthConfig{}, nil
 	}
 
-	// processConfigs 只过滤无效配置，保留 disabled 配置用于持久化
-	validConfigs := processConfigsForRuntime(configs)
-
-	logger.Info("成功加载认证配置",
-		logger.Int("总配置数", len(configs)),
-		logger.Int("有效配置数", len(validConfigs)),
+	logger.Debug("读取认证配置",
+		logger.Int("配置数", len(configs)),
 		logger.Bool("从文件加载", loadedFromFile))
 
-	return validConfigs, nil
+	return configs, nil
+}
+
+// ConfigFilePath 当前账号配置的持久化路径（加载配置后有效）
+func ConfigFilePath() string {
+	return getConfigFilePath()
 }
 
 // getDefaultConfigPath 获取默认配置文件路径
@@ -216,6 +233,12 @@ func processConfigsForRuntime(configs []AuthConfig) []AuthConfig {
 	return validConfigs
 }
 
+// registerConfigSecrets 登记账号配置中的密钥，避免在日志中明文输出
+func registerConfigSecrets(cfg AuthConfig) {
+	logger.RegisterSecret(cfg.RefreshToken)
+	logger.RegisterSecret(cfg.ClientSecret)
+}
+
 // GetConfigs 公开的配置获取函数，供其他包调用
 func GetConfigs() ([]AuthConfig, error) {
 	return loadConfigs()
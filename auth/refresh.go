@@ -2,9 +2,14 @@ package auth
 
 import (
 	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"io"
 	"kiro2api/config"
+	"kiro2api/metrics"
+	"kiro2api/tracing"
 	"kiro2api/types"
 	"kiro2api/utils"
 	"net/http"
@@ -12,7 +17,20 @@ import (
 )
 
 // refreshSingleToken 刷新单个token
-func (tm *TokenManager) refreshSingleToken(authConfig AuthConfig) (types.TokenInfo, error) {
+func (tm *TokenManager) refreshSingleToken(ctx context.Context, authConfig AuthConfig) (token types.TokenInfo, err error) {
+	_, span := tracing.Start(ctx, "account.refresh", tracing.SpanKindClient,
+		tracing.String("kiro.account.id", AccountID(authConfig.RefreshToken)),
+		tracing.String("kiro.auth_type", authConfig.AuthType))
+	defer func() {
+		result := "success"
+		if err != nil {
+			result = "failure"
+		}
+		metrics.TokenRefreshTotal.Inc(authConfig.AuthType, result)
+		span.RecordError(err)
+		span.End()
+	}()
+
 	switch authConfig.AuthType {
 	case AuthMethodSocial:
 		return refreshSocialToken(authConfig.RefreshToken)
@@ -150,3 +168,24 @@ func RefreshSocialToken(refreshToken string) (types.TokenInfo, error) {
 func RefreshIdCToken(authConfig AuthConfig) (types.TokenInfo, error) {
 	return refreshIdCToken(authConfig)
 }
+
+// RefreshAccount 按认证类型直接刷新账号（不经过缓存，供命令行工具使用）
+func RefreshAccount(authConfig AuthConfig) (types.TokenInfo, error) {
+	switch authConfig.AuthType {
+	case AuthMethodSocial, "":
+		return refreshSocialToken(authConfig.RefreshToken)
+	case AuthMethodIdC:
+		return refreshIdCToken(authConfig)
+	default:
+		return types.TokenInfo{}, fmt.Errorf("不支持的认证类型: %s", authConfig.AuthType)
+	}
+}
+
+// AccountID 由 refresh token 派生的账号标识（SHA-256 前16位），用于追踪和日志，不暴露凭据
+func AccountID(refreshToken string) string {
+	if refreshToken == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(refreshToken))
+	return hex.EncodeToString(sum[:8])
+}
@@ -0,0 +1,92 @@
+package auth
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// DefaultSSOCacheDir Kiro IDE 保存登录凭据的目录（相对用户主目录）
+const DefaultSSOCacheDir = ".aws/sso/cache"
+
+// ssoCacheToken SSO 缓存文件中用到的字段
+// kiro-auth-token.json 保存 refreshToken，IdC 登录时通过 clientIdHash 指向保存 clientId/clientSecret 的注册文件
+type ssoCacheToken struct {
+	RefreshToken string `json:"refreshToken"`
+	AuthMethod   string `json:"authMethod"`
+	Provider     string `json:"provider"`
+	ClientIDHash string `json:"clientIdHash"`
+	ClientID     string `json:"clientId"`
+	ClientSecret string `json:"clientSecret"`
+}
+
+// SSOImportResult 单个缓存文件的导入结果
+type SSOImportResult struct {
+	File   string
+	Config AuthConfig
+	Err    error // 文件包含 refreshToken 但无法转换为账号配置时的原因
+}
+
+// ScanSSOCache 扫描 SSO 缓存目录，将包含 refreshToken 的文件转换为账号配置
+// 只保存客户端注册信息（clientId/clientSecret）的文件会被跳过
+func ScanSSOCache(dir string) ([]SSOImportResult, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("读取SSO缓存目录失败: %w", err)
+	}
+
+	files := make(map[string]ssoCacheToken)
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), ".json") {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(dir, e.Name()))
+		if err != nil {
+			continue
+		}
+		var token ssoCacheToken
+		if json.Unmarshal(data, &token) != nil {
+			continue
+		}
+		files[e.Name()] = token
+	}
+
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var results []SSOImportResult
+	for _, name := range names {
+		token := files[name]
+		if token.RefreshToken == "" {
+			continue
+		}
+		result := SSOImportResult{File: name}
+
+		if strings.EqualFold(token.AuthMethod, AuthMethodIdC) || token.ClientIDHash != "" {
+			// IdC：clientId/clientSecret 可能在同一文件，也可能在 <clientIdHash>.json 中
+			clientID, clientSecret := token.ClientID, token.ClientSecret
+			if reg, ok := files[token.ClientIDHash+".json"]; ok && clientID == "" {
+				clientID, clientSecret = reg.ClientID, reg.ClientSecret
+			}
+			if clientID == "" || clientSecret == "" {
+				result.Err = fmt.Errorf("IdC 凭据缺少 clientId/clientSecret（未找到 %s.json）", token.ClientIDHash)
+			}
+			result.Config = AuthConfig{
+				AuthType:     AuthMethodIdC,
+				RefreshToken: token.RefreshToken,
+				ClientID:     clientID,
+				ClientSecret: clientSecret,
+			}
+		} else {
+			result.Config = AuthConfig{AuthType: AuthMethodSocial, RefreshToken: token.RefreshToken}
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
@@ -0,0 +1,47 @@
+package auth
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestScanSSOCache(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0600))
+	}
+	write("kiro-auth-token.json", `{"accessToken":"a","refreshToken":"social-refresh","authMethod":"social","provider":"Github"}`)
+	write("idc-token.json", `{"refreshToken":"idc-refresh","authMethod":"IdC","clientIdHash":"abc123"}`)
+	write("abc123.json", `{"clientId":"client-id","clientSecret":"client-secret","expiresAt":"2030-01-01T00:00:00Z"}`)
+	write("broken-idc.json", `{"refreshToken":"idc-orphan","clientIdHash":"missing"}`)
+	write("notes.txt", `{"refreshToken":"ignored"}`)
+	write("invalid.json", `{`)
+
+	results, err := ScanSSOCache(dir)
+	require.NoError(t, err)
+	require.Len(t, results, 3)
+
+	byFile := make(map[string]SSOImportResult)
+	for _, r := range results {
+		byFile[r.File] = r
+	}
+
+	social := byFile["kiro-auth-token.json"]
+	assert.NoError(t, social.Err)
+	assert.Equal(t, AuthConfig{AuthType: AuthMethodSocial, RefreshToken: "social-refresh"}, social.Config)
+
+	idc := byFile["idc-token.json"]
+	assert.NoError(t, idc.Err)
+	assert.Equal(t, AuthMethodIdC, idc.Config.AuthType)
+	assert.Equal(t, "client-id", idc.Config.ClientID)
+	assert.Equal(t, "client-secret", idc.Config.ClientSecret)
+
+	assert.Error(t, byFile["broken-idc.json"].Err)
+
+	_, err = ScanSSOCache(filepath.Join(dir, "missing"))
+	assert.Error(t, err)
+}
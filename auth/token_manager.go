@@ -1,6 +1,7 @@
 package auth
 
 import (
+	"context"
 	"fmt"
 	"kiro2api/config"
 	"kiro2api/logger"
@@ -18,6 +19,7 @@ type TokenManager struct {
 	currentIndex int             // 当前使用的token索引
 	exhausted    map[string]bool // 已耗尽的token记录
 	refreshing   map[string]bool // 正在刷新的token记录
+	strategy     string          // 账号选择策略（config.SelectionSequential/SelectionRoundRobin）
 }
 
 // SimpleTokenCache 简化的token缓存（纯数据结构，无锁）
@@ -50,6 +52,10 @@ func NewTokenManager(configs []AuthConfig) *TokenManager {
 	configsCopy := make([]AuthConfig, len(configs))
 	copy(configsCopy, configs)
 
+	for _, cfg := range configsCopy {
+		registerConfigSecrets(cfg)
+	}
+
 	// 生成配置顺序
 	configOrder := generateConfigOrder(configsCopy)
 
@@ -58,29 +64,58 @@ func NewTokenManager(configs []AuthConfig) *TokenManager {
 		logger.Int("config_order_count", len(configOrder)))
 
 	return &TokenManager{
-		cache:        NewSimpleTokenCache(config.TokenCacheTTL),
+		cache:        NewSimpleTokenCache(config.GetTokenCacheTTL()),
 		configs:      configsCopy,
 		configOrder:  configOrder,
 		currentIndex: 0,
 		exhausted:    make(map[string]bool),
 		refreshing:   make(map[string]bool),
+		strategy:     config.GetSelectionStrategy(),
+	}
+}
+
+// SetCacheTTL 在线调整缓存生存时间，对已缓存的token立即生效
+func (tm *TokenManager) SetCacheTTL(ttl time.Duration) {
+	tm.mutex.Lock()
+	defer tm.mutex.Unlock()
+	tm.cache.ttl = ttl
+}
+
+// SetSelectionStrategy 在线切换账号选择策略，调用方应先校验策略名称
+func (tm *TokenManager) SetSelectionStrategy(strategy string) {
+	tm.mutex.Lock()
+	defer tm.mutex.Unlock()
+	tm.strategy = strategy
+}
+
+// advanceAfterSelectUnlocked 轮询策略下选中后移动到下一个账号
+// 内部方法：调用者必须持有 tm.mutex
+func (tm *TokenManager) advanceAfterSelectUnlocked() {
+	if tm.strategy == config.SelectionRoundRobin && len(tm.configOrder) > 0 {
+		tm.currentIndex = (tm.currentIndex + 1) % len(tm.configOrder)
 	}
 }
 
 // getBestToken 获取最优可用token
 // 按需刷新：只刷新当前选中的token，不刷新全部
 func (tm *TokenManager) getBestToken() (types.TokenInfo, error) {
+	return tm.getBestTokenContext(context.Background())
+}
+
+// getBestTokenContext 同 getBestToken，ctx 用于关联追踪
+func (tm *TokenManager) getBestTokenContext(ctx context.Context) (types.TokenInfo, error) {
 	tm.mutex.Lock()
 	defer tm.mutex.Unlock()
 
 	// 选择最优token（内部方法，不加锁）
-	bestToken := tm.selectBestTokenUnlocked()
+	bestToken := tm.selectBestTokenUnlocked(ctx)
 	if bestToken == nil {
 		return types.TokenInfo{}, fmt.Errorf("没有可用的token")
 	}
 
 	// 更新最后使用时间（在锁内，安全）
 	bestToken.LastUsed = time.Now()
+	tm.advanceAfterSelectUnlocked()
 	if bestToken.Available > 0 {
 		bestToken.Available--
 	}
@@ -91,17 +126,23 @@ func (tm *TokenManager) getBestToken() (types.TokenInfo, error) {
 // GetBestTokenWithUsage 获取最优可用token（包含使用信息）
 // 按需刷新：只刷新当前选中的token，不刷新全部
 func (tm *TokenManager) GetBestTokenWithUsage() (*types.TokenWithUsage, error) {
+	return tm.GetBestTokenWithUsageContext(context.Background())
+}
+
+// GetBestTokenWithUsageContext 同 GetBestTokenWithUsage，ctx 用于关联追踪（懒加载刷新作为子 span）
+func (tm *TokenManager) GetBestTokenWithUsageContext(ctx context.Context) (*types.TokenWithUsage, error) {
 	tm.mutex.Lock()
 	defer tm.mutex.Unlock()
 
 	// 选择最优token（内部方法，不加锁）
-	bestToken := tm.selectBestTokenUnlocked()
+	bestToken := tm.selectBestTokenUnlocked(ctx)
 	if bestToken == nil {
 		return nil, fmt.Errorf("没有可用的token")
 	}
 
 	// 更新最后使用时间（在锁内，安全）
 	bestToken.LastUsed = time.Now()
+	tm.advanceAfterSelectUnlocked()
 	available := bestToken.Available
 	if bestToken.Available > 0 {
 		bestToken.Available--
@@ -126,7 +167,7 @@ func (tm *TokenManager) GetBestTokenWithUsage() (*types.TokenWithUsage, error) {
 // selectBestTokenUnlocked 按配置顺序选择下一个可用token
 // 内部方法：调用者必须持有 tm.mutex
 // 懒加载策略：当选中的token缓存不存在或过期时，同步刷新并等待结果
-func (tm *TokenManager) selectBestTokenUnlocked() *CachedToken {
+func (tm *TokenManager) selectBestTokenUnlocked(ctx context.Context) *CachedToken {
 	// 调用者已持有 tm.mutex，无需额外加锁
 
 	// 如果没有配置顺序，返回nil
@@ -168,7 +209,7 @@ func (tm *TokenManager) selectBestTokenUnlocked() *CachedToken {
 					}
 					tm.refreshing[currentKey] = true
 					// 同步刷新（释放锁后执行网络请求）
-					refreshed := tm.refreshSingleTokenSyncUnlock(currentIdx, cfg, currentKey)
+					refreshed := tm.refreshSingleTokenSyncUnlock(ctx, currentIdx, cfg, currentKey)
 					if refreshed != nil && refreshed.IsUsable() {
 						logger.Debug("同步刷新后使用token",
 							logger.String("cache_key", currentKey),
@@ -198,7 +239,7 @@ func (tm *TokenManager) selectBestTokenUnlocked() *CachedToken {
 				}
 				tm.refreshing[currentKey] = true
 				// 同步刷新（释放锁后执行网络请求）
-				refreshed := tm.refreshSingleTokenSyncUnlock(currentIdx, cfg, currentKey)
+				refreshed := tm.refreshSingleTokenSyncUnlock(ctx, currentIdx, cfg, currentKey)
 				if refreshed != nil && refreshed.IsUsable() {
 					logger.Debug("懒加载刷新后使用token",
 						logger.String("cache_key", currentKey),
@@ -291,7 +332,7 @@ func (tm *TokenManager) refreshSingleTokenAsync(index int, cfg AuthConfig) {
 	tm.mutex.RUnlock()
 
 	// 刷新token
-	token, err := tm.refreshSingleToken(cfg)
+	token, err := tm.refreshSingleToken(context.Background(), cfg)
 	if err != nil {
 		logger.Warn("刷新单个token失败",
 			logger.Int("config_index", index),
@@ -340,7 +381,7 @@ func (tm *TokenManager) refreshSingleTokenSync(index int, cfg AuthConfig) *Cache
 		logger.String("auth_type", cfg.AuthType))
 
 	// 刷新token
-	token, err := tm.refreshSingleToken(cfg)
+	token, err := tm.refreshSingleToken(context.Background(), cfg)
 	if err != nil {
 		logger.Warn("同步刷新token失败",
 			logger.Int("config_index", index),
@@ -385,7 +426,7 @@ func (tm *TokenManager) refreshSingleTokenSync(index int, cfg AuthConfig) *Cache
 // refreshSingleTokenSyncUnlock 同步刷新token，在网络请求期间释放锁
 // 调用者必须持有 tm.mutex，此方法会临时释放锁执行网络请求，然后重新获取锁
 // 返回刷新后的 CachedToken，失败返回 nil
-func (tm *TokenManager) refreshSingleTokenSyncUnlock(index int, cfg AuthConfig, cacheKey string) *CachedToken {
+func (tm *TokenManager) refreshSingleTokenSyncUnlock(ctx context.Context, index int, cfg AuthConfig, cacheKey string) *CachedToken {
 	logger.Info("同步刷新token（懒加载，释放锁）",
 		logger.Int("index", index),
 		logger.String("auth_type", cfg.AuthType))
@@ -394,7 +435,7 @@ func (tm *TokenManager) refreshSingleTokenSyncUnlock(index int, cfg AuthConfig,
 	tm.mutex.Unlock()
 
 	// 刷新token（网络请求）
-	token, err := tm.refreshSingleToken(cfg)
+	token, err := tm.refreshSingleToken(ctx, cfg)
 
 	// 检查使用限制（也是网络请求）
 	var usageInfo *types.UsageLimits
@@ -455,14 +496,17 @@ func (ct *CachedToken) IsUsable() bool {
 
 // TokenCacheStatus 缓存状态信息（用于 Dashboard 显示）
 type TokenCacheStatus struct {
-	Index     int
-	Cached    bool                // 是否有缓存
-	Token     types.TokenInfo     // Token 信息
-	UsageInfo *types.UsageLimits  // 使用限制信息
-	Available float64             // 可用次数
-	CachedAt  time.Time           // 缓存时间
-	LastUsed  time.Time           // 最后使用时间
-	Error     string              // 错误信息（如果有）
+	Index      int
+	Cached     bool               // 是否有缓存
+	Token      types.TokenInfo    // Token 信息
+	UsageInfo  *types.UsageLimits // 使用限制信息
+	Available  float64            // 可用次数
+	CachedAt   time.Time          // 缓存时间
+	LastUsed   time.Time          // 最后使用时间
+	Error      string             // 错误信息（如果有）
+	Disabled   bool               // 是否已禁用
+	Exhausted  bool               // 是否被标记为耗尽（轮换时跳过，刷新成功后清除）
+	Refreshing bool               // 是否正在刷新
 }
 
 // GetAllCacheStatus 获取所有 Token 的缓存状态（只读，不触发刷新）
@@ -475,8 +519,11 @@ func (tm *TokenManager) GetAllCacheStatus() []TokenCacheStatus {
 	for i := range tm.configs {
 		cacheKey := fmt.Sprintf(config.TokenCacheKeyFormat, i)
 		status := TokenCacheStatus{
-			Index:  i,
-			Cached: false,
+			Index:      i,
+			Cached:     false,
+			Disabled:   tm.configs[i].Disabled,
+			Exhausted:  tm.exhausted[cacheKey],
+			Refreshing: tm.refreshing[cacheKey],
 		}
 
 		if cached, exists := tm.cache.tokens[cacheKey]; exists {
@@ -547,6 +594,7 @@ func (tm *TokenManager) AddConfig(cfg AuthConfig) {
 	defer tm.mutex.Unlock()
 
 	// 添加到配置列表
+	registerConfigSecrets(cfg)
 	tm.configs = append(tm.configs, cfg)
 
 	// 更新配置顺序
@@ -235,3 +235,45 @@ func TestTokenManager_SequentialSelection(t *testing.T) {
 
 	t.Logf("✅ 顺序选择策略验证通过：粘性策略正确工作")
 }
+
+// TestTokenManager_RoundRobinSelection 测试轮询策略：每次请求后切换到下一个账号
+func TestTokenManager_RoundRobinSelection(t *testing.T) {
+	configs := []AuthConfig{
+		{AuthType: AuthMethodSocial, RefreshToken: "token1"},
+		{AuthType: AuthMethodSocial, RefreshToken: "token2"},
+		{AuthType: AuthMethodSocial, RefreshToken: "token3"},
+	}
+
+	tm := NewTokenManager(configs)
+	tm.SetSelectionStrategy(config.SelectionRoundRobin)
+
+	tm.mutex.Lock()
+	for i := range configs {
+		tm.cache.tokens[fmt.Sprintf(config.TokenCacheKeyFormat, i)] = &CachedToken{
+			Token: types.TokenInfo{
+				AccessToken: fmt.Sprintf("access_%d", i),
+				ExpiresAt:   time.Now().Add(1 * time.Hour),
+			},
+			CachedAt:  time.Now(),
+			Available: 5.0,
+		}
+	}
+	tm.mutex.Unlock()
+
+	var order []string
+	for i := 0; i < 4; i++ {
+		token, err := tm.getBestToken()
+		if err != nil {
+			t.Fatalf("获取token失败: %v", err)
+		}
+		order = append(order, token.AccessToken)
+	}
+
+	expected := []string{"access_0", "access_1", "access_2", "access_0"}
+	for i := range expected {
+		if order[i] != expected[i] {
+			t.Errorf("期望选择顺序 %v，实际 %v", expected, order)
+			break
+		}
+	}
+}
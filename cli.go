@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+
+	"kiro2api/logger"
+)
+
+// initCLI 为管理命令加载配置并初始化日志，出错时原因输出到 errOut
+// 未显式设置 LOG_LEVEL 时只输出警告及以上，避免日志混入命令输出
+func initCLI(errOut io.Writer) bool {
+	if _, err := loadConfig(""); err != nil {
+		fmt.Fprintf(errOut, "❌ %v\n", err)
+		return false
+	}
+	logger.Reinitialize()
+	if os.Getenv("LOG_LEVEL") == "" && os.Getenv("DEBUG") == "" {
+		logger.SetLevel(logger.WARN)
+	}
+	return true
+}
+
+// newFlagSet 创建子命令参数解析器，错误信息输出到 errOut
+func newFlagSet(name string, errOut io.Writer) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(errOut)
+	return fs
+}
+
+// parseFlags 解析参数，允许位置参数出现在选项之前（如 accounts usage 0 -json）
+func parseFlags(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		if fs.NArg() == 0 {
+			return positional, nil
+		}
+		positional = append(positional, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+}
+
+// parseIndex 解析序号参数
+func parseIndex(arg string, count int) (int, error) {
+	index, err := strconv.Atoi(arg)
+	if err != nil {
+		return 0, fmt.Errorf("无效的序号: %s", arg)
+	}
+	if index < 0 || index >= count {
+		return 0, fmt.Errorf("序号超出范围: %d（共 %d 项）", index, count)
+	}
+	return index, nil
+}
+
+// printJSON 以缩进 JSON 输出到 out，失败原因输出到 errOut
+func printJSON(out, errOut io.Writer, v any) int {
+	enc := json.NewEncoder(out)
+	enc.SetIndent("", "  ")
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		fmt.Fprintf(errOut, "❌ 序列化输出失败: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// maskSecret 脱敏展示（保留前4位和后4位）
+func maskSecret(s string) string {
+	if len(s) <= 12 {
+		return "****"
+	}
+	return s[:4] + "****" + s[len(s)-4:]
+}
@@ -0,0 +1,402 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+	"time"
+
+	"kiro2api/auth"
+	"kiro2api/types"
+)
+
+// accountView 账号展示信息（不包含凭据）
+type accountView struct {
+	Index     int    `json:"index"`
+	AuthType  string `json:"auth"`
+	AccountID string `json:"accountId"`
+	ClientID  string `json:"clientId,omitempty"`
+	Disabled  bool   `json:"disabled"`
+}
+
+// accountUsageView 账号额度信息
+type accountUsageView struct {
+	accountView
+	Email          string  `json:"email,omitempty"`
+	Available      float64 `json:"available"`
+	Used           float64 `json:"used"`
+	Limit          float64 `json:"limit"`
+	DaysUntilReset int     `json:"daysUntilReset"`
+	Error          string  `json:"error,omitempty"`
+}
+
+func newAccountView(index int, cfg auth.AuthConfig) accountView {
+	view := accountView{
+		Index:     index,
+		AuthType:  cfg.AuthType,
+		AccountID: auth.AccountID(cfg.RefreshToken),
+		Disabled:  cfg.Disabled,
+	}
+	if view.AuthType == "" {
+		view.AuthType = auth.AuthMethodSocial
+	}
+	if cfg.ClientID != "" {
+		view.ClientID = maskSecret(cfg.ClientID)
+	}
+	return view
+}
+
+// runAccountsCommand 处理 accounts 子命令
+func runAccountsCommand(args []string, out, errOut io.Writer) int {
+	if len(args) == 0 {
+		fmt.Fprint(errOut, usage)
+		return 2
+	}
+	if !initCLI(errOut) {
+		return 1
+	}
+
+	configs, err := auth.LoadStoredConfigs()
+	if err != nil {
+		fmt.Fprintf(errOut, "❌ %v\n", err)
+		return 1
+	}
+
+	switch args[0] {
+	case "list":
+		return accountsList(configs, args[1:], out, errOut)
+	case "add":
+		return accountsAdd(configs, args[1:], out, errOut)
+	case "remove":
+		return accountsRemove(configs, args[1:], out, errOut)
+	case "refresh":
+		return accountsRefresh(configs, args[1:], out, errOut)
+	case "usage":
+		return accountsUsage(configs, args[1:], out, errOut)
+	default:
+		fmt.Fprintf(errOut, "未知的 accounts 子命令: %s\n\n%s", args[0], usage)
+		return 2
+	}
+}
+
+func accountsList(configs []auth.AuthConfig, args []string, out, errOut io.Writer) int {
+	fs := newFlagSet("accounts list", errOut)
+	asJSON := fs.Bool("json", false, "以 JSON 输出")
+	if _, err := parseFlags(fs, args); err != nil {
+		return 2
+	}
+
+	views := make([]accountView, 0, len(configs))
+	for i, cfg := range configs {
+		views = append(views, newAccountView(i, cfg))
+	}
+	if *asJSON {
+		return printJSON(out, errOut, views)
+	}
+
+	if path := auth.ConfigFilePath(); path != "" {
+		fmt.Fprintf(out, "配置文件: %s\n\n", path)
+	} else {
+		fmt.Fprintf(out, "账号来自 KIRO_AUTH_TOKEN 环境变量（JSON 字符串）\n\n")
+	}
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "序号\t类型\t账号ID\tClientID\t状态")
+	for _, v := range views {
+		status := "启用"
+		if v.Disabled {
+			status = "禁用"
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\n", v.Index, v.AuthType, v.AccountID, orDash(v.ClientID), status)
+	}
+	w.Flush()
+	fmt.Fprintf(out, "\n共 %d 个账号\n", len(views))
+	return 0
+}
+
+func accountsAdd(configs []auth.AuthConfig, args []string, out, errOut io.Writer) int {
+	fs := newFlagSet("accounts add", errOut)
+	authType := fs.String("auth", auth.AuthMethodSocial, "认证方式 (Social/IdC)")
+	refreshToken := fs.String("refresh-token", "", "refreshToken（必需）")
+	clientID := fs.String("client-id", "", "IdC 客户端ID")
+	clientSecret := fs.String("client-secret", "", "IdC 客户端密钥")
+	verify := fs.Bool("verify", false, "添加前先刷新一次，确认凭据有效")
+	if _, err := parseFlags(fs, args); err != nil {
+		return 2
+	}
+
+	cfg := auth.AuthConfig{
+		AuthType:     *authType,
+		RefreshToken: *refreshToken,
+		ClientID:     *clientID,
+		ClientSecret: *clientSecret,
+	}
+	if err := validateAccount(cfg); err != nil {
+		fmt.Fprintf(errOut, "❌ %v\n", err)
+		return 2
+	}
+	for i, existing := range configs {
+		if existing.RefreshToken == cfg.RefreshToken {
+			fmt.Fprintf(errOut, "❌ 账号已存在（序号 %d）\n", i)
+			return 1
+		}
+	}
+	if *verify {
+		if _, err := auth.RefreshAccount(cfg); err != nil {
+			fmt.Fprintf(errOut, "❌ 凭据校验失败: %v\n", err)
+			return 1
+		}
+	}
+
+	if err := auth.SaveConfigs(append(configs, cfg)); err != nil {
+		fmt.Fprintf(errOut, "❌ %v\n", err)
+		return 1
+	}
+	fmt.Fprintf(out, "✅ 已添加账号 %s（序号 %d），写入 %s\n", auth.AccountID(cfg.RefreshToken), len(configs), auth.ConfigFilePath())
+	return 0
+}
+
+// validateAccount 校验账号配置（与管理面板添加账号的规则一致）
+func validateAccount(cfg auth.AuthConfig) error {
+	if cfg.RefreshToken == "" {
+		return fmt.Errorf("RefreshToken 不能为空")
+	}
+	switch cfg.AuthType {
+	case auth.AuthMethodSocial:
+	case auth.AuthMethodIdC:
+		if cfg.ClientID == "" || cfg.ClientSecret == "" {
+			return fmt.Errorf("IdC 认证需要 ClientID 和 ClientSecret")
+		}
+	default:
+		return fmt.Errorf("不支持的认证类型: %s（可选 Social、IdC）", cfg.AuthType)
+	}
+	return nil
+}
+
+func accountsRemove(configs []auth.AuthConfig, args []string, out, errOut io.Writer) int {
+	fs := newFlagSet("accounts remove", errOut)
+	positional, err := parseFlags(fs, args)
+	if err != nil {
+		return 2
+	}
+	if len(positional) != 1 {
+		fmt.Fprintln(errOut, "用法: kiro2api accounts remove <序号>")
+		return 2
+	}
+	index, err := parseIndex(positional[0], len(configs))
+	if err != nil {
+		fmt.Fprintf(errOut, "❌ %v\n", err)
+		return 1
+	}
+
+	removed := configs[index]
+	remaining := append(configs[:index:index], configs[index+1:]...)
+	if err := auth.SaveConfigs(remaining); err != nil {
+		fmt.Fprintf(errOut, "❌ %v\n", err)
+		return 1
+	}
+	fmt.Fprintf(out, "✅ 已删除账号 %s（序号 %d）\n", auth.AccountID(removed.RefreshToken), index)
+	return 0
+}
+
+// selectAccounts 按序号选择账号，未指定时选择全部启用的账号
+func selectAccounts(configs []auth.AuthConfig, positional []string) ([]int, error) {
+	if len(positional) > 0 {
+		index, err := parseIndex(positional[0], len(configs))
+		if err != nil {
+			return nil, err
+		}
+		return []int{index}, nil
+	}
+	var indexes []int
+	for i, cfg := range configs {
+		if !cfg.Disabled {
+			indexes = append(indexes, i)
+		}
+	}
+	return indexes, nil
+}
+
+func accountsRefresh(configs []auth.AuthConfig, args []string, out, errOut io.Writer) int {
+	fs := newFlagSet("accounts refresh", errOut)
+	positional, err := parseFlags(fs, args)
+	if err != nil {
+		return 2
+	}
+	indexes, err := selectAccounts(configs, positional)
+	if err != nil {
+		fmt.Fprintf(errOut, "❌ %v\n", err)
+		return 1
+	}
+
+	failed := 0
+	for _, i := range indexes {
+		id := auth.AccountID(configs[i].RefreshToken)
+		token, err := auth.RefreshAccount(configs[i])
+		if err != nil {
+			failed++
+			fmt.Fprintf(errOut, "❌ [%d] %s 刷新失败: %v\n", i, id, err)
+			continue
+		}
+		fmt.Fprintf(out, "✅ [%d] %s 刷新成功，有效期至 %s\n", i, id, token.ExpiresAt.Format(time.DateTime))
+	}
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
+
+func accountsUsage(configs []auth.AuthConfig, args []string, out, errOut io.Writer) int {
+	fs := newFlagSet("accounts usage", errOut)
+	asJSON := fs.Bool("json", false, "以 JSON 输出")
+	positional, err := parseFlags(fs, args)
+	if err != nil {
+		return 2
+	}
+	indexes, err := selectAccounts(configs, positional)
+	if err != nil {
+		fmt.Fprintf(errOut, "❌ %v\n", err)
+		return 1
+	}
+
+	checker := auth.NewUsageLimitsChecker()
+	views := make([]accountUsageView, 0, len(indexes))
+	failed := 0
+	for _, i := range indexes {
+		view := accountUsageView{accountView: newAccountView(i, configs[i])}
+		limits, err := fetchUsage(checker, configs[i])
+		if err != nil {
+			failed++
+			view.Error = err.Error()
+		} else {
+			view.Email = limits.UserInfo.Email
+			view.Available = auth.CalculateAvailableCount(limits)
+			view.Used, view.Limit = creditUsage(limits)
+			view.DaysUntilReset = limits.DaysUntilReset
+		}
+		views = append(views, view)
+	}
+
+	if *asJSON {
+		if code := printJSON(out, errOut, views); code != 0 {
+			return code
+		}
+	} else {
+		w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "序号\t账号ID\t邮箱\t剩余\t已用/总额\t重置天数")
+		for _, v := range views {
+			if v.Error != "" {
+				fmt.Fprintf(w, "%d\t%s\t查询失败: %s\t\t\t\n", v.Index, v.AccountID, v.Error)
+				continue
+			}
+			fmt.Fprintf(w, "%d\t%s\t%s\t%.2f\t%.2f/%.2f\t%d\n",
+				v.Index, v.AccountID, orDash(v.Email), v.Available, v.Used, v.Limit, v.DaysUntilReset)
+		}
+		w.Flush()
+	}
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
+
+// fetchUsage 刷新访问令牌并查询额度
+func fetchUsage(checker *auth.UsageLimitsChecker, cfg auth.AuthConfig) (*types.UsageLimits, error) {
+	token, err := auth.RefreshAccount(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("刷新失败: %w", err)
+	}
+	return checker.CheckUsageLimits(token)
+}
+
+// creditUsage 汇总 CREDIT 类型的已用额度和总额度（含有效的免费试用额度）
+func creditUsage(limits *types.UsageLimits) (used, limit float64) {
+	for _, b := range limits.UsageBreakdownList {
+		if b.ResourceType != "CREDIT" {
+			continue
+		}
+		used += b.CurrentUsageWithPrecision
+		limit += b.UsageLimitWithPrecision
+		if b.FreeTrialInfo != nil && b.FreeTrialInfo.FreeTrialStatus == "ACTIVE" {
+			used += b.FreeTrialInfo.CurrentUsageWithPrecision
+			limit += b.FreeTrialInfo.UsageLimitWithPrecision
+		}
+	}
+	return used, limit
+}
+
+// runImportSSOCommand 从 SSO 缓存目录导入账号
+func runImportSSOCommand(args []string, out, errOut io.Writer) int {
+	fs := newFlagSet("import-sso", errOut)
+	dryRun := fs.Bool("dry-run", false, "只显示将导入的账号，不写入配置")
+	positional, err := parseFlags(fs, args)
+	if err != nil {
+		return 2
+	}
+	if !initCLI(errOut) {
+		return 1
+	}
+
+	dir := ""
+	if len(positional) > 0 {
+		dir = positional[0]
+	} else if home, err := os.UserHomeDir(); err == nil {
+		dir = filepath.Join(home, auth.DefaultSSOCacheDir)
+	}
+
+	results, err := auth.ScanSSOCache(dir)
+	if err != nil {
+		fmt.Fprintf(errOut, "❌ %v\n", err)
+		return 1
+	}
+	configs, err := auth.LoadStoredConfigs()
+	if err != nil {
+		fmt.Fprintf(errOut, "❌ %v\n", err)
+		return 1
+	}
+
+	existing := make(map[string]bool, len(configs))
+	for _, cfg := range configs {
+		existing[cfg.RefreshToken] = true
+	}
+
+	added := 0
+	for _, r := range results {
+		id := auth.AccountID(r.Config.RefreshToken)
+		switch {
+		case r.Err != nil:
+			fmt.Fprintf(errOut, "⚠️  %s: 跳过，%v\n", r.File, r.Err)
+		case existing[r.Config.RefreshToken]:
+			fmt.Fprintf(out, "·  %s: 账号 %s 已存在\n", r.File, id)
+		default:
+			existing[r.Config.RefreshToken] = true
+			configs = append(configs, r.Config)
+			added++
+			fmt.Fprintf(out, "+  %s: %s 账号 %s\n", r.File, r.Config.AuthType, id)
+		}
+	}
+
+	if added == 0 {
+		fmt.Fprintf(out, "\n没有可导入的新账号（目录: %s）\n", dir)
+		return 0
+	}
+	if *dryRun {
+		fmt.Fprintf(out, "\n将导入 %d 个账号（-dry-run，未写入）\n", added)
+		return 0
+	}
+	if err := auth.SaveConfigs(configs); err != nil {
+		fmt.Fprintf(errOut, "❌ %v\n", err)
+		return 1
+	}
+	fmt.Fprintf(out, "\n✅ 已导入 %d 个账号，写入 %s\n", added, auth.ConfigFilePath())
+	return 0
+}
+
+// orDash 空值显示为 -
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
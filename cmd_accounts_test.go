@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"kiro2api/auth"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// cliStep 依次执行的命令及期望结果
+type cliStep struct {
+	name       string
+	args       []string
+	wantCode   int
+	wantStdout string // 标准输出应包含的内容
+	wantStderr string // 标准错误应包含的内容，为空时要求没有错误输出
+	wantJSON   bool   // 标准输出应为合法 JSON
+}
+
+// newCLITestDir 切换到临时目录作为配置目录，屏蔽环境中的账号和密钥配置
+func newCLITestDir(t *testing.T) string {
+	dir := t.TempDir()
+	t.Chdir(dir)
+	for _, key := range []string{"KIRO2API_CONFIG", "KIRO_AUTH_TOKEN", "KIRO_CLIENT_TOKEN", "CLIENT_TOKENS_FILE"} {
+		t.Setenv(key, "")
+	}
+	return dir
+}
+
+// runCLISteps 按顺序执行命令并校验退出码和输出
+func runCLISteps(t *testing.T, run func(args []string, out, errOut *bytes.Buffer) int, steps []cliStep) {
+	for _, step := range steps {
+		var stdout, stderr bytes.Buffer
+		code := run(step.args, &stdout, &stderr)
+
+		assert.Equal(t, step.wantCode, code, "%s: stderr=%s", step.name, stderr.String())
+		assert.Contains(t, stdout.String(), step.wantStdout, step.name)
+		if step.wantStderr == "" {
+			assert.Empty(t, stderr.String(), step.name)
+		} else {
+			assert.Contains(t, stderr.String(), step.wantStderr, step.name)
+			assert.NotContains(t, stdout.String(), "❌", step.name)
+		}
+		if step.wantJSON {
+			assert.True(t, json.Valid(stdout.Bytes()), "%s: %s", step.name, stdout.String())
+		}
+	}
+}
+
+func TestAccountsCommand(t *testing.T) {
+	dir := newCLITestDir(t)
+
+	runCLISteps(t, func(args []string, out, errOut *bytes.Buffer) int {
+		return runAccountsCommand(args, out, errOut)
+	}, []cliStep{
+		{name: "空列表", args: []string{"list", "-json"}, wantStdout: "[]", wantJSON: true},
+		{name: "缺少 refreshToken", args: []string{"add"}, wantCode: 2, wantStderr: "RefreshToken 不能为空"},
+		{name: "IdC 缺少客户端凭据", args: []string{"add", "-auth", "IdC", "-refresh-token", "rt-idc"}, wantCode: 2, wantStderr: "ClientID"},
+		{name: "添加", args: []string{"add", "-refresh-token", "rt-social-1"}, wantStdout: "已添加账号"},
+		{name: "重复添加", args: []string{"add", "-refresh-token", "rt-social-1"}, wantCode: 1, wantStderr: "账号已存在"},
+		{name: "列表", args: []string{"list", "-json"}, wantStdout: `"auth": "Social"`, wantJSON: true},
+		{name: "未知参数", args: []string{"list", "-bogus"}, wantCode: 2, wantStderr: "bogus"},
+		{name: "删除越界", args: []string{"remove", "3"}, wantCode: 1, wantStderr: "序号超出范围"},
+		{name: "删除缺少序号", args: []string{"remove"}, wantCode: 2, wantStderr: "用法"},
+		{name: "删除", args: []string{"remove", "0"}, wantStdout: "已删除账号"},
+		{name: "未知子命令", args: []string{"rename"}, wantCode: 2, wantStderr: "未知的 accounts 子命令"},
+	})
+
+	data, err := os.ReadFile(filepath.Join(dir, auth.DefaultConfigFileName))
+	require.NoError(t, err)
+	var configs []auth.AuthConfig
+	require.NoError(t, json.Unmarshal(data, &configs))
+	assert.Empty(t, configs)
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"kiro2api/config"
+
+	"github.com/joho/godotenv"
+)
+
+// loadConfig 加载 .env 和统一配置文件，写入环境变量
+// path 为空时按 KIRO2API_CONFIG 和默认文件名查找，未找到配置文件时返回 nil
+func loadConfig(path string) (*config.File, error) {
+	// .env 不覆盖已有的环境变量，配置文件只填补两者都未设置的项
+	_ = godotenv.Load()
+
+	if path == "" {
+		path = config.FindConfigFile()
+	}
+	if path == "" {
+		config.RefreshFromEnv()
+		return nil, nil
+	}
+
+	file, err := config.LoadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	file.Apply()
+	return file, nil
+}
+
+// runConfigCommand 处理 config 子命令，返回进程退出码
+func runConfigCommand(args []string, out, errOut io.Writer) int {
+	if len(args) == 0 || args[0] != "check" {
+		fmt.Fprintln(errOut, "用法: kiro2api config check [-config 配置文件路径]")
+		return 2
+	}
+
+	fs := flag.NewFlagSet("config check", flag.ContinueOnError)
+	fs.SetOutput(errOut)
+	path := fs.String("config", "", "配置文件路径（默认读取 KIRO2API_CONFIG 或工作目录中的 kiro2api.yaml/.yml/.toml）")
+	if err := fs.Parse(args[1:]); err != nil {
+		return 2
+	}
+
+	file, err := loadConfig(*path)
+	if err != nil {
+		fmt.Fprintf(errOut, "❌ %v\n", err)
+		return 1
+	}
+
+	if file != nil {
+		fmt.Fprintf(out, "配置文件: %s\n\n", file.Path)
+	} else {
+		fmt.Fprintf(out, "未使用配置文件（仅环境变量和 .env）\n\n")
+	}
+	printEffectiveConfig(out, config.Effective(file))
+
+	if err := config.Validate(); err != nil {
+		fmt.Fprintf(errOut, "\n❌ 配置校验失败:\n")
+		for _, line := range strings.Split(err.Error(), "\n") {
+			fmt.Fprintf(errOut, "  - %s\n", line)
+		}
+		return 1
+	}
+	fmt.Fprintf(out, "\n✅ 配置校验通过\n")
+	return 0
+}
+
+// printEffectiveConfig 按分组输出生效的配置（密钥已脱敏）
+func printEffectiveConfig(out io.Writer, values []config.EffectiveValue) {
+	width := 0
+	for _, v := range values {
+		width = max(width, len(v.Key))
+	}
+
+	group := ""
+	for _, v := range values {
+		if g, _, _ := strings.Cut(v.Key, "."); g != group {
+			if group != "" {
+				fmt.Fprintln(out)
+			}
+			group = g
+			fmt.Fprintf(out, "[%s]\n", group)
+		}
+		value := v.DisplayValue()
+		if value == "" {
+			value = "-"
+		}
+		fmt.Fprintf(out, "  %-*s = %s  # %s，来源: %s\n", width, v.Key, value, v.Env, v.Source)
+	}
+}
+
+// exitOnConfigError 配置有误时输出原因并退出
+func exitOnConfigError(err error) {
+	if err == nil {
+		return
+	}
+	fmt.Fprintf(os.Stderr, "配置有误，请修正后重新启动（可运行 kiro2api config check 查看生效配置）:\n%v\n", err)
+	os.Exit(1)
+}
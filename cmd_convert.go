@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"kiro2api/converter"
+	"kiro2api/types"
+	"kiro2api/utils"
+)
+
+// runConvertCommand 将 Anthropic/OpenAI 请求转换为 CodeWhisperer 请求并打印，用于排查转换问题
+// 会话ID等按请求随机生成，与服务端实际发送的值不同
+func runConvertCommand(args []string, out, errOut io.Writer) int {
+	fs := newFlagSet("convert", errOut)
+	in := fs.String("in", "", "请求文件路径（- 表示标准输入）")
+	format := fs.String("format", "anthropic", "请求格式 (anthropic/openai)")
+	if _, err := parseFlags(fs, args); err != nil {
+		return 2
+	}
+	if *in == "" {
+		fmt.Fprintln(errOut, "用法: kiro2api convert -in request.json [-format anthropic|openai]")
+		return 2
+	}
+	if !initCLI(errOut) {
+		return 1
+	}
+
+	var data []byte
+	var err error
+	if *in == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(*in)
+	}
+	if err != nil {
+		fmt.Fprintf(errOut, "❌ 读取请求失败: %v\n", err)
+		return 1
+	}
+
+	var anthropicReq types.AnthropicRequest
+	switch *format {
+	case "anthropic":
+		if err := utils.SafeUnmarshal(data, &anthropicReq); err != nil {
+			fmt.Fprintf(errOut, "❌ 解析 Anthropic 请求失败: %v\n", err)
+			return 1
+		}
+	case "openai":
+		var openaiReq types.OpenAIRequest
+		if err := utils.SafeUnmarshal(data, &openaiReq); err != nil {
+			fmt.Fprintf(errOut, "❌ 解析 OpenAI 请求失败: %v\n", err)
+			return 1
+		}
+		anthropicReq = converter.ConvertOpenAIToAnthropic(openaiReq)
+	default:
+		fmt.Fprintf(errOut, "❌ 不支持的请求格式: %s（可选 anthropic、openai）\n", *format)
+		return 2
+	}
+	if len(anthropicReq.Messages) == 0 {
+		fmt.Fprintln(errOut, "❌ messages 数组不能为空")
+		return 1
+	}
+
+	cwReq, err := converter.BuildCodeWhispererRequest(anthropicReq, nil)
+	if err != nil {
+		if modelErr, ok := err.(*types.ModelNotFoundErrorType); ok {
+			fmt.Fprintf(errOut, "❌ %s\n", modelErr.Error())
+			return 1
+		}
+		fmt.Fprintf(errOut, "❌ 构建CodeWhisperer请求失败: %v\n", err)
+		return 1
+	}
+	return printJSON(out, errOut, cwReq)
+}
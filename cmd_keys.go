@@ -0,0 +1,146 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	"kiro2api/auth"
+)
+
+// clientKeyPrefix 生成的客户端API密钥前缀
+const clientKeyPrefix = "sk-kiro-"
+
+// keyView 客户端API密钥展示信息（令牌脱敏）
+type keyView struct {
+	Index        int       `json:"index"`
+	Name         string    `json:"name"`
+	Token        string    `json:"token"`
+	Disabled     bool      `json:"disabled"`
+	DebugCapture bool      `json:"debugCapture"`
+	CreatedAt    time.Time `json:"createdAt"`
+}
+
+// runKeysCommand 处理 keys 子命令（管理客户端API密钥，即 client_tokens.json）
+func runKeysCommand(args []string, out, errOut io.Writer) int {
+	if len(args) == 0 {
+		fmt.Fprint(errOut, usage)
+		return 2
+	}
+	if !initCLI(errOut) {
+		return 1
+	}
+
+	manager, err := auth.NewClientTokenManager()
+	if err != nil {
+		fmt.Fprintf(errOut, "❌ %v\n", err)
+		return 1
+	}
+
+	switch args[0] {
+	case "create":
+		return keysCreate(manager, args[1:], out, errOut)
+	case "list":
+		return keysList(manager, args[1:], out, errOut)
+	case "revoke":
+		return keysRevoke(manager, args[1:], out, errOut)
+	default:
+		fmt.Fprintf(errOut, "未知的 keys 子命令: %s\n\n%s", args[0], usage)
+		return 2
+	}
+}
+
+func keysCreate(manager *auth.ClientTokenManager, args []string, out, errOut io.Writer) int {
+	fs := newFlagSet("keys create", errOut)
+	name := fs.String("name", "命令行创建", "密钥名称")
+	token := fs.String("token", "", "指定密钥（不指定时随机生成）")
+	if _, err := parseFlags(fs, args); err != nil {
+		return 2
+	}
+
+	if *token == "" {
+		buf := make([]byte, 24)
+		if _, err := rand.Read(buf); err != nil {
+			fmt.Fprintf(errOut, "❌ 生成密钥失败: %v\n", err)
+			return 1
+		}
+		*token = clientKeyPrefix + hex.EncodeToString(buf)
+	}
+	if err := manager.AddToken(*token, *name); err != nil {
+		fmt.Fprintf(errOut, "❌ %v\n", err)
+		return 1
+	}
+
+	fmt.Fprintf(out, "✅ 已创建客户端API密钥 %q（序号 %d），请妥善保存，之后不再完整显示:\n%s\n",
+		*name, manager.GetTokenCount()-1, *token)
+	return 0
+}
+
+func keysList(manager *auth.ClientTokenManager, args []string, out, errOut io.Writer) int {
+	fs := newFlagSet("keys list", errOut)
+	asJSON := fs.Bool("json", false, "以 JSON 输出")
+	if _, err := parseFlags(fs, args); err != nil {
+		return 2
+	}
+
+	stats := manager.GetAllStats()
+	views := make([]keyView, 0, len(stats))
+	for i, s := range stats {
+		views = append(views, keyView{
+			Index:        i,
+			Name:         s.Name,
+			Token:        maskSecret(s.Token),
+			Disabled:     s.Disabled,
+			DebugCapture: s.DebugCapture,
+			CreatedAt:    s.CreatedAt,
+		})
+	}
+	if *asJSON {
+		return printJSON(out, errOut, views)
+	}
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "序号\t名称\t密钥\t状态\t创建时间")
+	for _, v := range views {
+		status := "启用"
+		if v.Disabled {
+			status = "禁用"
+		}
+		created := "-"
+		if !v.CreatedAt.IsZero() {
+			created = v.CreatedAt.Local().Format(time.DateTime)
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\n", v.Index, orDash(v.Name), v.Token, status, created)
+	}
+	w.Flush()
+	fmt.Fprintf(out, "\n共 %d 个密钥\n", len(views))
+	return 0
+}
+
+func keysRevoke(manager *auth.ClientTokenManager, args []string, out, errOut io.Writer) int {
+	fs := newFlagSet("keys revoke", errOut)
+	positional, err := parseFlags(fs, args)
+	if err != nil {
+		return 2
+	}
+	if len(positional) != 1 {
+		fmt.Fprintln(errOut, "用法: kiro2api keys revoke <序号>")
+		return 2
+	}
+	index, err := parseIndex(positional[0], manager.GetTokenCount())
+	if err != nil {
+		fmt.Fprintf(errOut, "❌ %v\n", err)
+		return 1
+	}
+
+	name := manager.GetAllStats()[index].Name
+	if err := manager.RemoveToken(index); err != nil {
+		fmt.Fprintf(errOut, "❌ %v\n", err)
+		return 1
+	}
+	fmt.Fprintf(out, "✅ 已吊销客户端API密钥 %q（序号 %d）\n", name, index)
+	return 0
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"kiro2api/auth"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeysCommand(t *testing.T) {
+	dir := newCLITestDir(t)
+
+	runCLISteps(t, func(args []string, out, errOut *bytes.Buffer) int {
+		return runKeysCommand(args, out, errOut)
+	}, []cliStep{
+		{name: "空列表", args: []string{"list", "-json"}, wantStdout: "[]", wantJSON: true},
+		{name: "创建", args: []string{"create", "-name", "ci", "-token", "sk-kiro-test-0123456789"}, wantStdout: "sk-kiro-test-0123456789"},
+		{name: "随机生成", args: []string{"create"}, wantStdout: clientKeyPrefix},
+		{name: "重复创建", args: []string{"create", "-token", "sk-kiro-test-0123456789"}, wantCode: 1, wantStderr: "令牌已存在"},
+		{name: "列表脱敏", args: []string{"list", "-json"}, wantStdout: `"token": "sk-k****6789"`, wantJSON: true},
+		{name: "吊销越界", args: []string{"revoke", "5"}, wantCode: 1, wantStderr: "序号超出范围"},
+		{name: "吊销无效序号", args: []string{"revoke", "abc"}, wantCode: 1, wantStderr: "无效的序号"},
+		{name: "吊销缺少序号", args: []string{"revoke"}, wantCode: 2, wantStderr: "用法"},
+		{name: "吊销", args: []string{"revoke", "0"}, wantStdout: `已吊销客户端API密钥 "ci"`},
+		{name: "未知子命令", args: []string{"rotate"}, wantCode: 2, wantStderr: "未知的 keys 子命令"},
+	})
+
+	data, err := os.ReadFile(filepath.Join(dir, "client_tokens.json"))
+	require.NoError(t, err)
+	var tokens []auth.ClientToken
+	require.NoError(t, json.Unmarshal(data, &tokens))
+	require.Len(t, tokens, 1)
+	assert.NotEqual(t, "ci", tokens[0].Name)
+}
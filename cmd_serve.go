@@ -0,0 +1,65 @@
+package main
+
+import (
+	"os"
+
+	"kiro2api/auth"
+	"kiro2api/config"
+	"kiro2api/logger"
+	"kiro2api/server"
+)
+
+// runServe 启动代理服务，args 可包含端口（PORT 环境变量优先）
+func runServe(args []string) {
+	// 加载.env文件和统一配置文件（环境变量优先）
+	configFile, err := loadConfig("")
+	exitOnConfigError(err)
+	exitOnConfigError(config.Validate())
+
+	// 重新初始化logger以使用.env文件和配置文件中的配置
+	logger.Reinitialize()
+	logger.WatchReopenSignal()
+	if configFile != nil {
+		logger.Info("已加载配置文件", logger.String("path", configFile.Path))
+	}
+
+	// 显示当前日志级别设置（仅在DEBUG级别时显示详细信息）
+	// 注意：移除重复的系统字段，这些信息已包含在日志结构中
+	logger.Debug("日志系统初始化完成",
+		logger.String("config_level", os.Getenv("LOG_LEVEL")),
+		logger.String("config_file", os.Getenv("LOG_FILE")))
+
+	// 🚀 创建AuthService实例（使用依赖注入）
+	logger.Info("正在创建AuthService...")
+	authService, err := auth.NewAuthService()
+	if err != nil {
+		logger.Error("AuthService创建失败", logger.Err(err))
+		logger.Error("请检查token配置后重新启动服务器")
+		os.Exit(1)
+	}
+
+	port := "8080" // 默认端口
+	if len(args) > 0 {
+		port = args[0]
+	}
+	// 从环境变量获取端口，覆盖命令行参数
+	if envPort := os.Getenv("PORT"); envPort != "" {
+		port = envPort
+	}
+
+	// 创建客户端令牌管理器（支持多令牌）
+	logger.Info("正在创建ClientTokenManager...")
+	clientTokenManager, err := auth.NewClientTokenManager()
+	if err != nil {
+		logger.Error("ClientTokenManager创建失败", logger.Err(err))
+		os.Exit(1)
+	}
+
+	// 检查是否有可用的客户端令牌
+	if !clientTokenManager.HasTokens() {
+		logger.Warn("未配置任何客户端令牌，API 端点将无法访问")
+		logger.Warn("请通过 Dashboard 或 kiro2api keys create 添加客户端令牌")
+	}
+
+	server.StartServer(port, clientTokenManager, authService)
+}
@@ -149,10 +149,6 @@ const IdcRefreshTokenURL = "https://oidc.us-east-1.amazonaws.com/token"
 // CodeWhispererURL CodeWhisperer API的URL
 const CodeWhispererURL = "https://codewhisperer.us-east-1.amazonaws.com/generateAssistantResponse"
 
-// MaxToolDescriptionLength 工具描述的最大长度（字符数）
-// 可通过环境变量 MAX_TOOL_DESCRIPTION_LENGTH 配置，默认 10000
-var MaxToolDescriptionLength = getEnvIntWithDefault("MAX_TOOL_DESCRIPTION_LENGTH", 10000)
-
 // getEnvIntWithDefault 获取整数类型环境变量（带默认值）
 func getEnvIntWithDefault(key string, defaultValue int) int {
 	if value := os.Getenv(key); value != "" {
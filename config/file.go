@@ -0,0 +1,567 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// 统一配置文件：YAML 或 TOML，按分组组织所有配置项
+// 每个配置项对应一个环境变量，优先级为 环境变量（含 .env） > 配置文件 > 默认值
+// 加载时将文件中的值写入尚未设置的环境变量，各模块仍通过环境变量读取，无需感知配置来源
+
+// ConfigFileEnv 指定配置文件路径的环境变量
+const ConfigFileEnv = "KIRO2API_CONFIG"
+
+// DefaultConfigFiles 未指定路径时在工作目录中依次查找的配置文件
+var DefaultConfigFiles = []string{"kiro2api.yaml", "kiro2api.yml", "kiro2api.toml"}
+
+// 配置值来源
+const (
+	SourceEnv     = "env"
+	SourceFile    = "file"
+	SourceDefault = "default"
+)
+
+// optionKind 配置项类型
+type optionKind int
+
+const (
+	kindString optionKind = iota
+	kindInt
+	kindBool
+	kindDuration
+	kindFloat
+	kindEnum
+	kindList // 列表，写入环境变量时按 sep 拼接
+	kindJSON // 字符串原样使用，对象/数组序列化为 JSON
+)
+
+// Option 配置项定义
+type Option struct {
+	Key         string // 配置文件中的键（分组.名称）
+	Env         string // 对应的环境变量
+	Default     string // 默认值（仅用于展示，实际默认值由各模块决定）
+	Description string
+	Secret      bool // 展示时脱敏
+
+	kind     optionKind
+	min, max float64  // kindInt/kindFloat 的取值范围（min==max 时不限制）
+	options  []string // kindEnum 的可选值
+	sep      string   // kindList 的分隔符
+	validate func(value string) error
+}
+
+// Options 所有配置项（按配置文件中的分组排列）
+var Options = []Option{
+	{Key: "server.port", Env: "PORT", Default: "8080", Description: "服务端口", kind: kindInt, min: 1, max: 65535},
+	{Key: "server.gin_mode", Env: "GIN_MODE", Default: "release", Description: "Gin 运行模式", kind: kindEnum, options: []string{"debug", "release", "test"}},
+	{Key: "server.secure_cookie", Env: "SECURE_COOKIE", Default: "false", Description: "会话 cookie 仅通过 HTTPS 发送", kind: kindBool},
+
+	{Key: "auth.kiro_auth_token", Env: "KIRO_AUTH_TOKEN", Description: "账号配置（JSON、文件路径，或在配置文件中直接写账号列表）", Secret: true, kind: kindJSON, validate: validateAuthToken},
+	{Key: "auth.client_token", Env: "KIRO_CLIENT_TOKEN", Description: "客户端API密钥（客户端令牌文件为空时导入）", Secret: true},
+	{Key: "auth.client_tokens_file", Env: "CLIENT_TOKENS_FILE", Default: "client_tokens.json", Description: "客户端令牌存储文件"},
+
+	{Key: "upstream.region", Env: "AWS_REGION", Default: "us-east-1", Description: "AWS 区域"},
+	{Key: "upstream.kiro_version", Env: "KIRO_VERSION", Default: "0.8.0", Description: "上报的 Kiro IDE 版本"},
+	{Key: "upstream.node_version", Env: "NODE_VERSION", Default: "22.21.1", Description: "上报的 Node.js 版本"},
+	{Key: "upstream.system_version", Env: "SYSTEM_VERSION", Description: "上报的系统版本（不设置时随机选择）"},
+	{Key: "upstream.machine_id", Env: "MACHINE_ID", Description: "固定的 machine_id（64 位十六进制）", Secret: true, validate: validateMachineID},
+
+	{Key: "dashboard.admin_username", Env: "ADMIN_USERNAME", Default: "admin", Description: "管理面板用户名"},
+	{Key: "dashboard.admin_password", Env: "ADMIN_PASSWORD", Description: "管理面板密码", Secret: true},
+	{Key: "dashboard.session_store", Env: "SESSION_STORE", Default: "file", Description: "会话存储类型", kind: kindEnum, options: []string{"memory", "file"}},
+	{Key: "dashboard.session_store_file", Env: "SESSION_STORE_FILE", Default: "sessions.json", Description: "会话与登录锁定状态文件"},
+	{Key: "dashboard.totp_config_file", Env: "TOTP_CONFIG_FILE", Default: "totp_config.json", Description: "两步验证配置文件"},
+	{Key: "dashboard.totp_issuer", Env: "TOTP_ISSUER", Default: "Kiro2API", Description: "认证器App中显示的发行方"},
+	{Key: "dashboard.admin_api_keys_file", Env: "ADMIN_API_KEYS_FILE", Default: "admin_api_keys.json", Description: "管理API密钥存储文件"},
+	{Key: "dashboard.audit_log_file", Env: "AUDIT_LOG_FILE", Default: "audit_log.jsonl", Description: "审计日志文件"},
+
+	{Key: "oidc.issuer", Env: "OIDC_ISSUER", Description: "OIDC 发行方地址", validate: validateURL},
+	{Key: "oidc.client_id", Env: "OIDC_CLIENT_ID", Description: "OIDC 客户端ID"},
+	{Key: "oidc.client_secret", Env: "OIDC_CLIENT_SECRET", Description: "OIDC 客户端密钥", Secret: true},
+	{Key: "oidc.redirect_url", Env: "OIDC_REDIRECT_URL", Description: "OIDC 回调地址", validate: validateURL},
+	{Key: "oidc.scopes", Env: "OIDC_SCOPES", Default: "openid,profile,email", Description: "请求的 scope", kind: kindList, sep: ","},
+	{Key: "oidc.display_name", Env: "OIDC_DISPLAY_NAME", Default: "SSO", Description: "登录页按钮名称"},
+	{Key: "oidc.username_claim", Env: "OIDC_USERNAME_CLAIM", Default: "email", Description: "作为用户名的 claim"},
+	{Key: "oidc.groups_claim", Env: "OIDC_GROUPS_CLAIM", Default: "groups", Description: "用户组 claim"},
+	{Key: "oidc.admin_groups", Env: "OIDC_ADMIN_GROUPS", Description: "映射为 admin 的用户组（启用 OIDC 时必填）", kind: kindList, sep: ","},
+	{Key: "oidc.viewer_groups", Env: "OIDC_VIEWER_GROUPS", Description: "映射为 viewer 的用户组", kind: kindList, sep: ","},
+
+	{Key: "logging.level", Env: "LOG_LEVEL", Default: "info", Description: "日志级别", kind: kindEnum, options: []string{"debug", "info", "warn", "error", "fatal"}},
+	{Key: "logging.debug", Env: "DEBUG", Default: "false", Description: "调试模式（等同 debug 级别）", kind: kindBool},
+	{Key: "logging.file", Env: "LOG_FILE", Description: "日志文件"},
+	{Key: "logging.access_file", Env: "LOG_ACCESS_FILE", Description: "访问日志文件"},
+	{Key: "logging.console", Env: "LOG_CONSOLE", Default: "true", Description: "设置日志文件时是否同时输出到控制台", kind: kindBool},
+	{Key: "logging.enable_caller", Env: "LOG_ENABLE_CALLER", Default: "false", Description: "记录调用位置", kind: kindBool},
+	{Key: "logging.caller_skip", Env: "LOG_CALLER_SKIP", Default: "3", Description: "调用栈跳过层数", kind: kindInt, min: 1, max: 64},
+	{Key: "logging.max_size_mb", Env: "LOG_MAX_SIZE_MB", Default: "100", Description: "单个日志文件最大大小（MB，0 不按大小轮转）", kind: kindInt, min: 0, max: 1 << 20},
+	{Key: "logging.rotate_interval", Env: "LOG_ROTATE_INTERVAL", Description: "按时间轮转的周期", kind: kindDuration},
+	{Key: "logging.max_backups", Env: "LOG_MAX_BACKUPS", Default: "7", Description: "轮转文件保留数量（0 不限制）", kind: kindInt, min: 0, max: 1 << 20},
+	{Key: "logging.max_age_days", Env: "LOG_MAX_AGE_DAYS", Default: "0", Description: "轮转文件保留天数（0 不限制）", kind: kindInt, min: 0, max: 1 << 20},
+	{Key: "logging.compress", Env: "LOG_COMPRESS", Default: "true", Description: "gzip 压缩轮转文件", kind: kindBool},
+	{Key: "logging.redact", Env: "LOG_REDACT", Default: "true", Description: "日志统一脱敏", kind: kindBool},
+	{Key: "logging.max_field_bytes", Env: "LOG_MAX_FIELD_BYTES", Default: "8192", Description: "单个日志字段的长度上限（字节，0 不限制）", kind: kindInt, min: 0, max: 1 << 30},
+	{Key: "logging.redact_patterns", Env: "LOG_REDACT_PATTERNS", Description: "额外的脱敏正则", kind: kindList, sep: ";;", validate: validateRedactPatterns},
+
+	{Key: "metrics.enabled", Env: "METRICS_ENABLED", Default: "true", Description: "启用 Prometheus /metrics 端点", kind: kindBool},
+	{Key: "metrics.port", Env: "METRICS_PORT", Description: "在独立端口暴露 /metrics", kind: kindInt, min: 1, max: 65535},
+	{Key: "metrics.token", Env: "METRICS_TOKEN", Description: "抓取 /metrics 所需的 Bearer 令牌", Secret: true},
+
+	{Key: "health.verbose_public", Env: "HEALTH_VERBOSE_PUBLIC", Default: "false", Description: "允许未登录请求获取 /readyz 检查详情", kind: kindBool},
+
+	{Key: "tracing.endpoint", Env: "OTEL_EXPORTER_OTLP_ENDPOINT", Description: "OTLP/HTTP 收集器地址", validate: validateURL},
+	{Key: "tracing.traces_endpoint", Env: "OTEL_EXPORTER_OTLP_TRACES_ENDPOINT", Description: "完整的 traces 接收地址", validate: validateURL},
+	{Key: "tracing.headers", Env: "OTEL_EXPORTER_OTLP_HEADERS", Description: "导出请求头（key=value）", Secret: true, kind: kindList, sep: ","},
+	{Key: "tracing.service_name", Env: "OTEL_SERVICE_NAME", Default: "kiro2api", Description: "上报的服务名"},
+	{Key: "tracing.sample_ratio", Env: "OTEL_TRACES_SAMPLER_ARG", Default: "1", Description: "根 span 采样率", kind: kindFloat, min: 0, max: 1},
+	{Key: "tracing.disabled", Env: "OTEL_SDK_DISABLED", Default: "false", Description: "关闭追踪", kind: kindBool},
+
+	{Key: "inspector.enabled", Env: "INSPECTOR_ENABLED", Default: "true", Description: "启用实时请求检查器", kind: kindBool},
+	{Key: "inspector.buffer_size", Env: "INSPECTOR_BUFFER_SIZE", Default: "200", Description: "保留的最近完成请求数", kind: kindInt, min: 1, max: 100000},
+	{Key: "inspector.max_payload_bytes", Env: "INSPECTOR_MAX_PAYLOAD_BYTES", Default: "65536", Description: "上游请求体和单个事件的记录上限（字节）", kind: kindInt, min: 0, max: 1 << 30},
+	{Key: "inspector.max_events", Env: "INSPECTOR_MAX_EVENTS", Default: "500", Description: "单个请求记录的事件数上限", kind: kindInt, min: 0, max: 1 << 20},
+
+	{Key: "storage.usage_stats_file", Env: "USAGE_STATS_FILE", Default: "usage_stats.json", Description: "用量统计持久化文件"},
+	{Key: "storage.settings_file", Env: "SETTINGS_FILE", Default: "settings.json", Description: "运行时设置持久化文件"},
+
+	{Key: "tuning.max_tool_description_length", Env: "MAX_TOOL_DESCRIPTION_LENGTH", Default: "10000", Description: "工具描述的最大长度（字符数）", kind: kindInt, min: 100, max: 1 << 20},
+	{Key: "tuning.parser_max_errors", Env: "PARSER_MAX_ERRORS", Default: strconv.Itoa(ParserMaxErrors), Description: "事件流解析器容忍的最大错误次数", kind: kindInt, min: 0, max: 1000},
+	{Key: "tuning.token_cache_ttl", Env: "TOKEN_CACHE_TTL", Default: TokenCacheTTL.String(), Description: "账号Token缓存的生存时间", kind: kindDuration},
+	{Key: "tuning.token_selection_strategy", Env: "TOKEN_SELECTION_STRATEGY", Default: SelectionSequential, Description: "账号选择策略", kind: kindEnum, options: SelectionStrategies},
+
+	{Key: "images.max_long_edge", Env: "IMAGE_MAX_LONG_EDGE", Default: strconv.Itoa(ImageMaxLongEdge), Description: "图片长边上限（像素，超出等比缩小，0 不限制）", kind: kindInt, min: 0, max: 1 << 16},
+	{Key: "images.max_pixels", Env: "IMAGE_MAX_PIXELS", Default: strconv.Itoa(ImageMaxPixels), Description: "图片像素总数上限（超出等比缩小，0 不限制）", kind: kindInt, min: 0, max: 1 << 30},
+	{Key: "images.max_bytes", Env: "IMAGE_MAX_BYTES", Default: strconv.Itoa(ImageMaxBytes), Description: "JPEG 图片大小上限（字节，超出重新编码，0 不限制）", kind: kindInt, min: 0, max: 1 << 30},
+	{Key: "images.max_per_request", Env: "IMAGE_MAX_PER_REQUEST", Default: strconv.Itoa(ImageMaxPerRequest), Description: "单个请求的图片数量上限（0 不限制）", kind: kindInt, min: 0, max: 1000},
+	{Key: "images.transcode_gif", Env: "IMAGE_TRANSCODE_GIF", Default: "false", Description: "将 GIF 转为 PNG（只保留第一帧）", kind: kindBool},
+	{Key: "images.url_fetch_enabled", Env: "IMAGE_URL_FETCH_ENABLED", Default: "false", Description: "获取 URL 类型的图片（Anthropic source.type=url、OpenAI http(s) image_url）", kind: kindBool},
+	{Key: "images.url_allowed_hosts", Env: "IMAGE_URL_ALLOWED_HOSTS", Description: "允许获取图片的主机（为空时不限制，*.example.com 匹配子域名）", kind: kindList, sep: ","},
+	{Key: "images.url_denied_hosts", Env: "IMAGE_URL_DENIED_HOSTS", Description: "禁止获取图片的主机（优先于允许列表）", kind: kindList, sep: ","},
+	{Key: "images.url_allow_private", Env: "IMAGE_URL_ALLOW_PRIVATE", Default: "false", Description: "允许获取内网、回环等私有地址的图片", kind: kindBool},
+	{Key: "images.url_timeout", Env: "IMAGE_URL_TIMEOUT", Default: ImageFetchTimeout.String(), Description: "获取远程图片的超时时间", kind: kindDuration},
+	{Key: "images.url_max_bytes", Env: "IMAGE_URL_MAX_BYTES", Default: strconv.Itoa(ImageFetchMaxBytes), Description: "远程图片的大小上限（字节）", kind: kindInt, min: 1, max: 1 << 30},
+	{Key: "images.url_cache_size", Env: "IMAGE_URL_CACHE_SIZE", Default: strconv.Itoa(ImageFetchCacheSize), Description: "远程图片缓存的条目数（0 不缓存）", kind: kindInt, min: 0, max: 10000},
+
+	{Key: "web_search.backend", Env: "WEB_SEARCH_BACKEND", Default: WebSearchBackendNone, Description: "web_search 工具的搜索后端（none 时过滤该工具）", kind: kindEnum, options: WebSearchBackends},
+	{Key: "web_search.searxng_url", Env: "WEB_SEARCH_SEARXNG_URL", Description: "SearXNG 服务地址（需开启 JSON 输出）", validate: validateURL},
+	{Key: "web_search.static_file", Env: "WEB_SEARCH_STATIC_FILE", Description: "static 后端的搜索结果文件（JSON）"},
+	{Key: "web_search.max_results", Env: "WEB_SEARCH_MAX_RESULTS", Default: strconv.Itoa(WebSearchMaxResults), Description: "每次搜索返回的结果数", kind: kindInt, min: 1, max: 50},
+	{Key: "web_search.max_uses", Env: "WEB_SEARCH_MAX_USES", Default: strconv.Itoa(WebSearchMaxUses), Description: "单个请求的最大搜索次数", kind: kindInt, min: 1, max: 20},
+	{Key: "web_search.timeout", Env: "WEB_SEARCH_TIMEOUT", Default: WebSearchTimeout.String(), Description: "单次搜索的超时时间", kind: kindDuration},
+
+	{Key: "debug_capture.dir", Env: "DEBUG_CAPTURE_DIR", Default: "debug_captures", Description: "调试捕获包存储目录"},
+	{Key: "debug_capture.max_bundles", Env: "DEBUG_CAPTURE_MAX_BUNDLES", Default: "50", Description: "保留的调试捕获包数量", kind: kindInt, min: 0, max: 100000},
+	{Key: "debug_capture.max_bytes", Env: "DEBUG_CAPTURE_MAX_BYTES", Default: "16777216", Description: "捕获包中每部分内容的记录上限（字节）", kind: kindInt, min: 0, max: 1 << 30},
+}
+
+// findOption 按配置文件键查找配置项
+func findOption(key string) *Option {
+	for i := range Options {
+		if Options[i].Key == key {
+			return &Options[i]
+		}
+	}
+	return nil
+}
+
+// File 已解析的配置文件
+type File struct {
+	Path   string
+	values map[string]string // 环境变量名 -> 值
+	// applied 由配置文件写入的环境变量（用于区分来源）
+	applied map[string]bool
+}
+
+// FindConfigFile 确定配置文件路径：KIRO2API_CONFIG > 工作目录中的默认文件名
+// 均不存在时返回空字符串
+func FindConfigFile() string {
+	if path := os.Getenv(ConfigFileEnv); path != "" {
+		return path
+	}
+	for _, name := range DefaultConfigFiles {
+		if info, err := os.Stat(name); err == nil && !info.IsDir() {
+			return name
+		}
+	}
+	return ""
+}
+
+// LoadFile 读取并解析配置文件（按扩展名识别 YAML/TOML）
+// 未知的键、类型不符的值会汇总为一个错误返回
+func LoadFile(path string) (*File, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("读取配置文件失败: %w", err)
+	}
+
+	raw := make(map[string]any)
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &raw)
+	case ".toml":
+		err = toml.Unmarshal(data, &raw)
+	default:
+		return nil, fmt.Errorf("不支持的配置文件格式: %s（支持 .yaml、.yml、.toml）", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("解析配置文件 %s 失败: %w", path, err)
+	}
+
+	f := &File{Path: path, values: make(map[string]string), applied: make(map[string]bool)}
+	var errs []error
+	flattenConfig("", raw, func(key string, value any) {
+		opt := findOption(key)
+		if opt == nil {
+			errs = append(errs, fmt.Errorf("%s: 未知的配置项", key))
+			return
+		}
+		s, err := opt.stringify(value)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", key, err))
+			return
+		}
+		f.values[opt.Env] = s
+	})
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("配置文件 %s 有误:\n%w", path, errors.Join(errs...))
+	}
+	return f, nil
+}
+
+// flattenConfig 将嵌套的分组展开为 分组.名称 形式的键
+// auth.kiro_auth_token 允许直接写账号列表，不再展开
+func flattenConfig(prefix string, m map[string]any, fn func(key string, value any)) {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		key := k
+		if prefix != "" {
+			key = prefix + "." + k
+		}
+		if nested, ok := m[k].(map[string]any); ok && findOption(key) == nil {
+			flattenConfig(key, nested, fn)
+			continue
+		}
+		fn(key, m[k])
+	}
+}
+
+// stringify 将配置文件中的值转换为环境变量字符串
+func (o *Option) stringify(value any) (string, error) {
+	if value == nil {
+		return "", nil
+	}
+
+	switch o.kind {
+	case kindJSON:
+		if s, ok := value.(string); ok {
+			return s, nil
+		}
+		data, err := json.Marshal(normalizeYAML(value))
+		if err != nil {
+			return "", fmt.Errorf("无法序列化为 JSON: %w", err)
+		}
+		return string(data), nil
+	case kindList:
+		switch v := value.(type) {
+		case string:
+			return v, nil
+		case []any:
+			items := make([]string, 0, len(v))
+			for _, item := range v {
+				items = append(items, fmt.Sprint(item))
+			}
+			return strings.Join(items, o.sep), nil
+		case map[string]any:
+			// 请求头等键值对写成 key=value
+			keys := make([]string, 0, len(v))
+			for k := range v {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			items := make([]string, 0, len(v))
+			for _, k := range keys {
+				items = append(items, k+"="+fmt.Sprint(v[k]))
+			}
+			return strings.Join(items, o.sep), nil
+		}
+	case kindBool:
+		if b, ok := value.(bool); ok {
+			return strconv.FormatBool(b), nil
+		}
+	case kindInt:
+		switch v := value.(type) {
+		case int, int64, uint64:
+			return fmt.Sprint(v), nil
+		case float64:
+			if v == float64(int64(v)) {
+				return strconv.FormatInt(int64(v), 10), nil
+			}
+		}
+	case kindFloat:
+		switch v := value.(type) {
+		case int, int64, uint64:
+			return fmt.Sprint(v), nil
+		case float64:
+			return strconv.FormatFloat(v, 'f', -1, 64), nil
+		}
+	case kindDuration:
+		switch v := value.(type) {
+		case time.Duration:
+			return v.String(), nil
+		case int, int64:
+			// 纯数字按秒处理
+			return fmt.Sprintf("%ds", v), nil
+		}
+	}
+
+	if s, ok := value.(string); ok {
+		return s, nil
+	}
+	return "", fmt.Errorf("类型不符: %v", value)
+}
+
+// normalizeYAML 将 YAML 解析出的 map[any]any 转换为可 JSON 序列化的结构
+func normalizeYAML(value any) any {
+	switch v := value.(type) {
+	case map[any]any:
+		m := make(map[string]any, len(v))
+		for k, item := range v {
+			m[fmt.Sprint(k)] = normalizeYAML(item)
+		}
+		return m
+	case map[string]any:
+		for k, item := range v {
+			v[k] = normalizeYAML(item)
+		}
+		return v
+	case []any:
+		for i, item := range v {
+			v[i] = normalizeYAML(item)
+		}
+		return v
+	default:
+		return v
+	}
+}
+
+// Apply 将配置文件中的值写入尚未设置的环境变量，并按新环境刷新本包中的初值
+func (f *File) Apply() {
+	if f != nil {
+		for env, value := range f.values {
+			if value == "" || os.Getenv(env) != "" {
+				continue
+			}
+			os.Setenv(env, value)
+			f.applied[env] = true
+		}
+	}
+	RefreshFromEnv()
+}
+
+// RefreshFromEnv 按当前环境变量重新读取包初始化时取得的配置
+// 包初始化早于 .env 和配置文件加载，启动时需调用一次
+func RefreshFromEnv() {
+	KiroVersion = getEnvWithDefault("KIRO_VERSION", "0.8.0")
+	NodeVersion = getEnvWithDefault("NODE_VERSION", "22.21.1")
+	Region = getEnvWithDefault("AWS_REGION", "us-east-1")
+	if v := os.Getenv("SYSTEM_VERSION"); v != "" {
+		SystemVersion = v
+	}
+	loadRuntimeFromEnv()
+	loadImageOptionsFromEnv()
+	loadWebSearchOptionsFromEnv()
+}
+
+// EffectiveValue 生效的配置值
+type EffectiveValue struct {
+	Option
+	Value  string
+	Source string
+}
+
+// Effective 列出所有配置项的生效值和来源（f 为 nil 表示未使用配置文件）
+func Effective(f *File) []EffectiveValue {
+	result := make([]EffectiveValue, 0, len(Options))
+	for _, opt := range Options {
+		ev := EffectiveValue{Option: opt, Value: os.Getenv(opt.Env), Source: SourceEnv}
+		switch {
+		case f != nil && f.applied[opt.Env]:
+			ev.Source = SourceFile
+		case ev.Value == "":
+			ev.Value = opt.Default
+			ev.Source = SourceDefault
+		}
+		result = append(result, ev)
+	}
+	return result
+}
+
+// DisplayValue 展示用的值（密钥脱敏）
+func (ev EffectiveValue) DisplayValue() string {
+	if ev.Value == "" {
+		return ""
+	}
+	if ev.Secret {
+		// 账号配置为文件路径时展示路径，便于确认加载的是哪个文件
+		if ev.Env == "KIRO_AUTH_TOKEN" && isExistingFile(ev.Value) {
+			return ev.Value
+		}
+		return "[REDACTED]"
+	}
+	return ev.Value
+}
+
+// Validate 校验当前生效的配置（环境变量与配置文件合并后），返回所有问题
+func Validate() error {
+	var errs []error
+	for _, opt := range Options {
+		value := os.Getenv(opt.Env)
+		if value == "" {
+			continue
+		}
+		if err := opt.check(value); err != nil {
+			errs = append(errs, fmt.Errorf("%s (%s): %w", opt.Key, opt.Env, err))
+		}
+	}
+
+	// OIDC 需要同时配置发行方、客户端ID和回调地址
+	if (os.Getenv("OIDC_ISSUER") != "") != (os.Getenv("OIDC_CLIENT_ID") != "") {
+		errs = append(errs, errors.New("oidc: OIDC_ISSUER 和 OIDC_CLIENT_ID 需同时设置"))
+	} else if os.Getenv("OIDC_ISSUER") != "" && os.Getenv("OIDC_REDIRECT_URL") == "" {
+		errs = append(errs, errors.New("oidc: 启用 OIDC 时必须设置 OIDC_REDIRECT_URL"))
+	}
+	if os.Getenv("OIDC_ISSUER") != "" && strings.TrimSpace(strings.ReplaceAll(os.Getenv("OIDC_ADMIN_GROUPS"), ",", "")) == "" {
+		errs = append(errs, errors.New("oidc: 启用 OIDC 时必须设置 OIDC_ADMIN_GROUPS（不属于映射用户组的账号会被拒绝登录）"))
+	}
+	if port, metricsPort := os.Getenv("PORT"), os.Getenv("METRICS_PORT"); port != "" && port == metricsPort {
+		errs = append(errs, errors.New("metrics.port (METRICS_PORT): 不能与服务端口相同"))
+	}
+
+	return errors.Join(errs...)
+}
+
+// check 按类型校验单个值
+func (o *Option) check(value string) error {
+	switch o.kind {
+	case kindInt:
+		n, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil {
+			return fmt.Errorf("需要整数，实际为 %q", value)
+		}
+		if o.min != o.max && (float64(n) < o.min || float64(n) > o.max) {
+			return fmt.Errorf("取值范围 %v ~ %v，实际为 %d", o.min, o.max, n)
+		}
+	case kindFloat:
+		n, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil {
+			return fmt.Errorf("需要数字，实际为 %q", value)
+		}
+		if o.min != o.max && (n < o.min || n > o.max) {
+			return fmt.Errorf("取值范围 %v ~ %v，实际为 %v", o.min, o.max, n)
+		}
+	case kindBool:
+		switch strings.ToLower(strings.TrimSpace(value)) {
+		case "true", "false", "1", "0", "yes", "no", "on", "off":
+		default:
+			return fmt.Errorf("需要布尔值 (true/false)，实际为 %q", value)
+		}
+	case kindDuration:
+		d, err := time.ParseDuration(strings.TrimSpace(value))
+		if err != nil {
+			return fmt.Errorf("需要时间长度（如 30s、5m、24h），实际为 %q", value)
+		}
+		if d <= 0 {
+			return fmt.Errorf("必须大于 0，实际为 %s", d)
+		}
+	case kindEnum:
+		normalized := strings.ToLower(strings.TrimSpace(value))
+		if normalized == "warning" && o.Env == "LOG_LEVEL" {
+			normalized = "warn"
+		}
+		found := false
+		for _, opt := range o.options {
+			if normalized == opt {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("无效的值 %q，可选 %s", value, strings.Join(o.options, "/"))
+		}
+	}
+	if o.validate != nil {
+		return o.validate(value)
+	}
+	return nil
+}
+
+// isExistingFile 判断路径是否为已存在的文件
+func isExistingFile(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && !info.IsDir()
+}
+
+// validateAuthToken KIRO_AUTH_TOKEN 需为存在的文件路径或合法 JSON
+func validateAuthToken(value string) error {
+	if isExistingFile(value) {
+		return nil
+	}
+	trimmed := strings.TrimSpace(value)
+	if strings.HasPrefix(trimmed, "[") || strings.HasPrefix(trimmed, "{") {
+		if !json.Valid([]byte(trimmed)) {
+			return errors.New("不是合法的 JSON")
+		}
+		return nil
+	}
+	return errors.New("既不是存在的文件，也不是 JSON 格式的账号配置")
+}
+
+// machineIDPattern machine_id 为 64 位十六进制
+var machineIDPattern = regexp.MustCompile(`^[0-9a-fA-F]{64}$`)
+
+// validateMachineID 校验 machine_id 格式（格式不符时会被忽略）
+func validateMachineID(value string) error {
+	if !machineIDPattern.MatchString(value) {
+		return fmt.Errorf("需要 64 位十六进制，实际长度 %d", len(value))
+	}
+	return nil
+}
+
+// validateURL 校验 http/https 地址
+func validateURL(value string) error {
+	u, err := url.Parse(value)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("需要 http(s) 地址，实际为 %q", value)
+	}
+	return nil
+}
+
+// validateRedactPatterns 校验脱敏正则能否编译
+func validateRedactPatterns(value string) error {
+	for _, p := range strings.Split(value, ";;") {
+		if p = strings.TrimSpace(p); p == "" {
+			continue
+		}
+		if _, err := regexp.Compile(p); err != nil {
+			return fmt.Errorf("无效的正则 %q: %w", p, err)
+		}
+	}
+	return nil
+}
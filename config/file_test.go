@@ -0,0 +1,148 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeConfigFile 在临时目录写入配置文件
+func writeConfigFile(t *testing.T, name, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	require.NoError(t, os.WriteFile(path, []byte(content), 0600))
+	return path
+}
+
+// clearEnv 清空配置项对应的环境变量（测试结束后恢复）
+func clearEnv(t *testing.T, envs ...string) {
+	for _, env := range envs {
+		t.Setenv(env, "")
+	}
+}
+
+func TestLoadFile_YAMLAppliesUnsetEnv(t *testing.T) {
+	clearEnv(t, "PORT", "LOG_LEVEL", "KIRO_AUTH_TOKEN", "OIDC_SCOPES", "TOKEN_CACHE_TTL", "LOG_COMPRESS")
+	t.Setenv("LOG_LEVEL", "warn") // 环境变量优先于配置文件
+
+	path := writeConfigFile(t, "kiro2api.yaml", `
+server:
+  port: 9090
+logging:
+  level: debug
+  compress: false
+auth:
+  kiro_auth_token:
+    - auth: Social
+      refreshToken: refresh-secret-value
+oidc:
+  scopes: [openid, email]
+tuning:
+  token_cache_ttl: 10m
+`)
+	f, err := LoadFile(path)
+	require.NoError(t, err)
+	f.Apply()
+	t.Cleanup(RefreshFromEnv)
+
+	assert.Equal(t, "9090", os.Getenv("PORT"))
+	assert.Equal(t, "warn", os.Getenv("LOG_LEVEL"))
+	assert.Equal(t, "false", os.Getenv("LOG_COMPRESS"))
+	assert.Equal(t, "openid,email", os.Getenv("OIDC_SCOPES"))
+	assert.JSONEq(t, `[{"auth":"Social","refreshToken":"refresh-secret-value"}]`, os.Getenv("KIRO_AUTH_TOKEN"))
+	assert.Equal(t, "10m0s", GetTokenCacheTTL().String())
+
+	sources := make(map[string]EffectiveValue)
+	for _, ev := range Effective(f) {
+		sources[ev.Env] = ev
+	}
+	assert.Equal(t, SourceFile, sources["PORT"].Source)
+	assert.Equal(t, SourceEnv, sources["LOG_LEVEL"].Source)
+	assert.Equal(t, "[REDACTED]", sources["KIRO_AUTH_TOKEN"].DisplayValue())
+
+	require.NoError(t, Validate())
+}
+
+func TestLoadFile_TOML(t *testing.T) {
+	clearEnv(t, "INSPECTOR_BUFFER_SIZE", "OTEL_TRACES_SAMPLER_ARG", "OTEL_EXPORTER_OTLP_HEADERS")
+
+	path := writeConfigFile(t, "kiro2api.toml", `
+[inspector]
+buffer_size = 50
+
+[tracing]
+sample_ratio = 0.25
+headers = { authorization = "Bearer abc", tenant = "a" }
+`)
+	f, err := LoadFile(path)
+	require.NoError(t, err)
+	f.Apply()
+
+	assert.Equal(t, "50", os.Getenv("INSPECTOR_BUFFER_SIZE"))
+	assert.Equal(t, "0.25", os.Getenv("OTEL_TRACES_SAMPLER_ARG"))
+	assert.Equal(t, "authorization=Bearer abc,tenant=a", os.Getenv("OTEL_EXPORTER_OTLP_HEADERS"))
+}
+
+func TestLoadFile_ReportsUnknownKeysAndTypes(t *testing.T) {
+	path := writeConfigFile(t, "kiro2api.yaml", `
+server:
+  prot: 8080
+logging:
+  compress: [1, 2]
+`)
+	_, err := LoadFile(path)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "server.prot: 未知的配置项")
+	assert.Contains(t, err.Error(), "logging.compress: 类型不符")
+
+	_, err = LoadFile(writeConfigFile(t, "kiro2api.ini", "port=1"))
+	assert.ErrorContains(t, err, "不支持的配置文件格式")
+}
+
+func TestValidate_ReportsAllProblems(t *testing.T) {
+	clearEnv(t, "OIDC_ISSUER", "OIDC_CLIENT_ID", "OIDC_REDIRECT_URL", "OIDC_ADMIN_GROUPS", "METRICS_PORT")
+	t.Setenv("PORT", "70000")
+	t.Setenv("LOG_LEVEL", "verbose")
+	t.Setenv("TOKEN_CACHE_TTL", "5")
+	t.Setenv("KIRO_AUTH_TOKEN", "/nonexistent/auth.json")
+	t.Setenv("OIDC_ISSUER", "https://idp.example.com")
+	t.Setenv("LOG_REDACT_PATTERNS", "ok;;[broken")
+
+	err := Validate()
+	require.Error(t, err)
+	msg := err.Error()
+	assert.Contains(t, msg, "server.port (PORT): 取值范围 1 ~ 65535")
+	assert.Contains(t, msg, `logging.level (LOG_LEVEL): 无效的值 "verbose"`)
+	assert.Contains(t, msg, "tuning.token_cache_ttl (TOKEN_CACHE_TTL): 需要时间长度")
+	assert.Contains(t, msg, "auth.kiro_auth_token (KIRO_AUTH_TOKEN): 既不是存在的文件")
+	assert.Contains(t, msg, "OIDC_ISSUER 和 OIDC_CLIENT_ID 需同时设置")
+	assert.Contains(t, msg, "必须设置 OIDC_ADMIN_GROUPS")
+	assert.Contains(t, msg, "无效的正则")
+}
+
+func TestValidate_OIDCRequiresAdminGroups(t *testing.T) {
+	clearEnv(t, "OIDC_ADMIN_GROUPS")
+	t.Setenv("OIDC_ISSUER", "https://accounts.google.com")
+	t.Setenv("OIDC_CLIENT_ID", "client")
+	t.Setenv("OIDC_REDIRECT_URL", "https://kiro.example.com/api/oidc/callback")
+
+	assert.ErrorContains(t, Validate(), "必须设置 OIDC_ADMIN_GROUPS")
+
+	t.Setenv("OIDC_ADMIN_GROUPS", "kiro-admins")
+	if err := Validate(); err != nil {
+		assert.NotContains(t, err.Error(), "OIDC_ADMIN_GROUPS")
+	}
+}
+
+func TestOptions_UniqueKeysAndEnv(t *testing.T) {
+	keys := make(map[string]bool)
+	envs := make(map[string]bool)
+	for _, opt := range Options {
+		assert.False(t, keys[opt.Key], "重复的配置项 %s", opt.Key)
+		assert.False(t, envs[opt.Env], "重复的环境变量 %s", opt.Env)
+		keys[opt.Key] = true
+		envs[opt.Env] = true
+	}
+}
@@ -0,0 +1,85 @@
+package config
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// ImageOptions 图片预处理参数
+type ImageOptions struct {
+	MaxLongEdge  int  // 长边上限（像素），超出时等比缩小，0 不限制
+	MaxPixels    int  // 像素总数上限，超出时等比缩小，0 不限制
+	MaxBytes     int  // JPEG 编码后的大小上限（字节），超出时降低质量重新编码，0 不限制
+	MaxImages    int  // 单个请求的图片数量上限，0 不限制
+	TranscodeGIF bool // 将 GIF 转为 PNG（只保留第一帧）
+
+	Fetch ImageFetchOptions // URL 类型图片的获取参数
+}
+
+// ImageFetchOptions 远程图片获取参数
+type ImageFetchOptions struct {
+	Enabled      bool          // 是否获取 URL 类型的图片，未启用时拒绝此类图片
+	AllowedHosts []string      // 允许的主机，为空时不限制
+	DeniedHosts  []string      // 禁止的主机，优先于允许列表
+	AllowPrivate bool          // 允许内网、回环等私有地址
+	Timeout      time.Duration // 单次获取的超时时间
+	MaxBytes     int           // 图片大小上限（字节）
+	CacheSize    int           // 缓存的条目数，0 不缓存
+}
+
+var imageOptions atomic.Pointer[ImageOptions]
+
+func init() {
+	loadImageOptionsFromEnv()
+}
+
+// loadImageOptionsFromEnv 从环境变量读取图片预处理参数
+func loadImageOptionsFromEnv() {
+	transcodeGIF, _ := strconv.ParseBool(os.Getenv("IMAGE_TRANSCODE_GIF"))
+	imageOptions.Store(&ImageOptions{
+		MaxLongEdge:  getEnvIntWithDefault("IMAGE_MAX_LONG_EDGE", ImageMaxLongEdge),
+		MaxPixels:    getEnvIntWithDefault("IMAGE_MAX_PIXELS", ImageMaxPixels),
+		MaxBytes:     getEnvIntWithDefault("IMAGE_MAX_BYTES", ImageMaxBytes),
+		MaxImages:    getEnvIntWithDefault("IMAGE_MAX_PER_REQUEST", ImageMaxPerRequest),
+		TranscodeGIF: transcodeGIF,
+		Fetch:        loadImageFetchOptionsFromEnv(),
+	})
+}
+
+// loadImageFetchOptionsFromEnv 从环境变量读取远程图片获取参数
+func loadImageFetchOptionsFromEnv() ImageFetchOptions {
+	enabled, _ := strconv.ParseBool(os.Getenv("IMAGE_URL_FETCH_ENABLED"))
+	allowPrivate, _ := strconv.ParseBool(os.Getenv("IMAGE_URL_ALLOW_PRIVATE"))
+	timeout := ImageFetchTimeout
+	if d, err := time.ParseDuration(os.Getenv("IMAGE_URL_TIMEOUT")); err == nil && d > 0 {
+		timeout = d
+	}
+	return ImageFetchOptions{
+		Enabled:      enabled,
+		AllowedHosts: splitHostList(os.Getenv("IMAGE_URL_ALLOWED_HOSTS")),
+		DeniedHosts:  splitHostList(os.Getenv("IMAGE_URL_DENIED_HOSTS")),
+		AllowPrivate: allowPrivate,
+		Timeout:      timeout,
+		MaxBytes:     getEnvIntWithDefault("IMAGE_URL_MAX_BYTES", ImageFetchMaxBytes),
+		CacheSize:    getEnvIntWithDefault("IMAGE_URL_CACHE_SIZE", ImageFetchCacheSize),
+	}
+}
+
+// splitHostList 解析逗号分隔的主机列表（统一小写）
+func splitHostList(value string) []string {
+	var hosts []string
+	for _, host := range strings.Split(value, ",") {
+		if host = strings.ToLower(strings.TrimSpace(host)); host != "" {
+			hosts = append(hosts, host)
+		}
+	}
+	return hosts
+}
+
+// GetImageOptions 当前的图片预处理参数
+func GetImageOptions() ImageOptions {
+	return *imageOptions.Load()
+}
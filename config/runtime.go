@@ -0,0 +1,102 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// 账号选择策略
+const (
+	// SelectionSequential 顺序使用当前账号直到不可用再切换
+	SelectionSequential = "sequential"
+	// SelectionRoundRobin 每次请求后轮转到下一个账号
+	SelectionRoundRobin = "round_robin"
+)
+
+// SelectionStrategies 支持的账号选择策略
+var SelectionStrategies = []string{SelectionSequential, SelectionRoundRobin}
+
+// 运行时可调参数
+// 启动时从环境变量取初值，之后可通过管理API在线修改，读取方无需重启即可生效
+var (
+	maxToolDescriptionLength atomic.Int64
+	parserMaxErrors          atomic.Int64
+	tokenCacheTTL            atomic.Int64
+	selectionStrategy        atomic.Value
+)
+
+func init() {
+	loadRuntimeFromEnv()
+}
+
+// loadRuntimeFromEnv 从环境变量读取运行时参数初值
+func loadRuntimeFromEnv() {
+	maxToolDescriptionLength.Store(int64(getEnvIntWithDefault("MAX_TOOL_DESCRIPTION_LENGTH", 10000)))
+	parserMaxErrors.Store(int64(getEnvIntWithDefault("PARSER_MAX_ERRORS", ParserMaxErrors)))
+
+	ttl := TokenCacheTTL
+	if v := os.Getenv("TOKEN_CACHE_TTL"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d > 0 {
+			ttl = d
+		}
+	}
+	tokenCacheTTL.Store(int64(ttl))
+
+	strategy := getEnvWithDefault("TOKEN_SELECTION_STRATEGY", SelectionSequential)
+	if ValidateSelectionStrategy(strategy) != nil {
+		strategy = SelectionSequential
+	}
+	selectionStrategy.Store(strategy)
+}
+
+// GetMaxToolDescriptionLength 工具描述的最大长度（字符数），默认 10000
+func GetMaxToolDescriptionLength() int {
+	return int(maxToolDescriptionLength.Load())
+}
+
+// SetMaxToolDescriptionLength 设置工具描述的最大长度
+func SetMaxToolDescriptionLength(n int) {
+	maxToolDescriptionLength.Store(int64(n))
+}
+
+// GetParserMaxErrors 解析器容忍的最大错误次数
+func GetParserMaxErrors() int {
+	return int(parserMaxErrors.Load())
+}
+
+// SetParserMaxErrors 设置解析器容忍的最大错误次数（对之后创建的解析器生效）
+func SetParserMaxErrors(n int) {
+	parserMaxErrors.Store(int64(n))
+}
+
+// GetTokenCacheTTL Token缓存的生存时间
+func GetTokenCacheTTL() time.Duration {
+	return time.Duration(tokenCacheTTL.Load())
+}
+
+// SetTokenCacheTTL 设置Token缓存的生存时间
+func SetTokenCacheTTL(ttl time.Duration) {
+	tokenCacheTTL.Store(int64(ttl))
+}
+
+// GetSelectionStrategy 当前的账号选择策略
+func GetSelectionStrategy() string {
+	return selectionStrategy.Load().(string)
+}
+
+// SetSelectionStrategy 设置账号选择策略，调用方应先校验
+func SetSelectionStrategy(strategy string) {
+	selectionStrategy.Store(strategy)
+}
+
+// ValidateSelectionStrategy 校验账号选择策略名称
+func ValidateSelectionStrategy(strategy string) error {
+	for _, s := range SelectionStrategies {
+		if s == strategy {
+			return nil
+		}
+	}
+	return fmt.Errorf("未知的账号选择策略: %s", strategy)
+}
@@ -7,14 +7,14 @@ import "time"
 const (
 	// ========== 解析器配置 ==========
 
-	// ParserMaxErrors 解析器容忍的最大错误次数
-	// 用于所有解析器，防止死循环
+	// ParserMaxErrors 解析器容忍的最大错误次数（默认值）
+	// 用于所有解析器，防止死循环；运行时取值见 GetParserMaxErrors
 	ParserMaxErrors = 5
 
 	// ========== Token缓存配置 ==========
 
-	// TokenCacheTTL Token缓存的生存时间
-	// 过期后需要重新刷新
+	// TokenCacheTTL Token缓存的生存时间（默认值）
+	// 过期后需要重新刷新；运行时取值见 GetTokenCacheTTL
 	TokenCacheTTL = 5 * time.Minute
 
 	// HTTPClientKeepAlive HTTP客户端Keep-Alive间隔
@@ -22,4 +22,38 @@ const (
 
 	// HTTPClientTLSHandshakeTimeout HTTP客户端TLS握手超时
 	HTTPClientTLSHandshakeTimeout = 15 * time.Second
+
+	// ========== 图片预处理配置 ==========
+
+	// ImageMaxLongEdge 图片长边上限（像素，默认值）
+	ImageMaxLongEdge = 1568
+
+	// ImageMaxPixels 图片像素总数上限（默认值）
+	ImageMaxPixels = 1150000
+
+	// ImageMaxBytes JPEG 编码后的大小上限（字节，默认值）
+	ImageMaxBytes = 5 * 1024 * 1024
+
+	// ImageMaxPerRequest 单个请求的图片数量上限（默认值）
+	ImageMaxPerRequest = 20
+
+	// ImageFetchTimeout 获取远程图片的超时时间（默认值）
+	ImageFetchTimeout = 10 * time.Second
+
+	// ImageFetchMaxBytes 远程图片的大小上限（字节，默认值）
+	ImageFetchMaxBytes = 20 * 1024 * 1024
+
+	// ImageFetchCacheSize 远程图片缓存的条目数（默认值）
+	ImageFetchCacheSize = 32
+
+	// ========== web_search 模拟配置 ==========
+
+	// WebSearchMaxResults 每次搜索返回的结果数（默认值）
+	WebSearchMaxResults = 5
+
+	// WebSearchMaxUses 单个请求的最大搜索次数（默认值）
+	WebSearchMaxUses = 5
+
+	// WebSearchTimeout 单次搜索的超时时间（默认值）
+	WebSearchTimeout = 10 * time.Second
 )
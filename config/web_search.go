@@ -0,0 +1,60 @@
+package config
+
+import (
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// 网络搜索后端
+const (
+	WebSearchBackendNone    = "none"    // 不模拟 web_search，工具定义被过滤
+	WebSearchBackendSearXNG = "searxng" // SearXNG 兼容的 JSON 搜索接口
+	WebSearchBackendStatic  = "static"  // 从本地 JSON 文件返回固定结果（离线环境、测试）
+)
+
+// WebSearchBackends 可选的搜索后端
+var WebSearchBackends = []string{WebSearchBackendNone, WebSearchBackendSearXNG, WebSearchBackendStatic}
+
+// WebSearchOptions web_search 模拟参数
+type WebSearchOptions struct {
+	Backend    string        // 搜索后端，none 表示不模拟
+	SearXNGURL string        // SearXNG 服务地址
+	StaticFile string        // static 后端的结果文件
+	MaxResults int           // 每次搜索返回的结果数
+	MaxUses    int           // 单个请求的最大搜索次数（工具定义中的 max_uses 更小时以其为准）
+	Timeout    time.Duration // 单次搜索的超时时间
+}
+
+// Enabled 是否配置了搜索后端
+func (o WebSearchOptions) Enabled() bool {
+	return o.Backend != "" && o.Backend != WebSearchBackendNone
+}
+
+var webSearchOptions atomic.Pointer[WebSearchOptions]
+
+func init() {
+	loadWebSearchOptionsFromEnv()
+}
+
+// loadWebSearchOptionsFromEnv 从环境变量读取 web_search 模拟参数
+func loadWebSearchOptionsFromEnv() {
+	timeout := WebSearchTimeout
+	if d, err := time.ParseDuration(os.Getenv("WEB_SEARCH_TIMEOUT")); err == nil && d > 0 {
+		timeout = d
+	}
+	webSearchOptions.Store(&WebSearchOptions{
+		Backend:    strings.ToLower(getEnvWithDefault("WEB_SEARCH_BACKEND", WebSearchBackendNone)),
+		SearXNGURL: os.Getenv("WEB_SEARCH_SEARXNG_URL"),
+		StaticFile: os.Getenv("WEB_SEARCH_STATIC_FILE"),
+		MaxResults: getEnvIntWithDefault("WEB_SEARCH_MAX_RESULTS", WebSearchMaxResults),
+		MaxUses:    getEnvIntWithDefault("WEB_SEARCH_MAX_USES", WebSearchMaxUses),
+		Timeout:    timeout,
+	})
+}
+
+// GetWebSearchOptions 当前的 web_search 模拟参数
+func GetWebSearchOptions() WebSearchOptions {
+	return *webSearchOptions.Load()
+}
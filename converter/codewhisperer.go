@@ -21,22 +21,9 @@ import (
 
 // determineChatTriggerType 智能确定聊天触发类型 (SOLID-SRP: 单一责任)
 func determineChatTriggerType(anthropicReq types.AnthropicRequest) string {
-	// 如果有工具调用，通常是自动触发的
-	if len(anthropicReq.Tools) > 0 {
-		// 检查tool_choice是否强制要求使用工具
-		if anthropicReq.ToolChoice != nil {
-			if tc, ok := anthropicReq.ToolChoice.(*types.ToolChoice); ok && tc != nil {
-				if tc.Type == "any" || tc.Type == "tool" {
-					return "AUTO" // 自动工具调用
-				}
-			} else if tcMap, ok := anthropicReq.ToolChoice.(map[string]any); ok {
-				if tcType, exists := tcMap["type"].(string); exists {
-					if tcType == "any" || tcType == "tool" {
-						return "AUTO" // 自动工具调用
-					}
-				}
-			}
-		}
+	// tool_choice 强制要求使用工具（any 或指定工具）时为自动触发
+	if ForcedToolChoice(anthropicReq) != nil {
+		return "AUTO" // 自动工具调用
 	}
 
 	// 默认为手动触发
@@ -87,13 +74,21 @@ func validateCodeWhispererRequest(cwReq *types.CodeWhispererRequest) error {
 }
 
 // extractToolResultsFromMessage 从消息内容中提取工具结果
-func extractToolResultsFromMessage(content any) []types.ToolResult {
+// 工具结果中的图片替换为文本占位（图片本身由 processMessageContent 转为消息图片），
+// imageOffset 为该消息之前已加入同一用户消息图片列表的图片数量，用于占位中的图片序号（与 processMessageContent 一致）
+// 工具结果中的图片无效时返回错误（与 processMessageContent 的处理一致）
+func extractToolResultsFromMessage(content any, imageOffset int) ([]types.ToolResult, error) {
 	var toolResults []types.ToolResult
+	imageCount := imageOffset
 
 	switch v := content.(type) {
 	case []any:
 		for _, item := range v {
 			if block, ok := item.(map[string]any); ok {
+				if isMessageImageBlock(block) {
+					imageCount++
+					continue
+				}
 				if blockType, exists := block["type"]; exists {
 					if typeStr, ok := blockType.(string); ok && typeStr == "tool_result" {
 						toolResult := types.ToolResult{}
@@ -105,6 +100,12 @@ func extractToolResultsFromMessage(content any) []types.ToolResult {
 
 						// 提取 content - 转换为数组格式
 						if content, exists := block["content"]; exists {
+							content, toolImages, err := splitToolResultImages(content, imageCount+1)
+							if err != nil {
+								return nil, err
+							}
+							imageCount += len(toolImages)
+
 							// 将 content 转换为 []map[string]any 格式
 							var contentArray []map[string]any
 
@@ -154,6 +155,10 @@ func extractToolResultsFromMessage(content any) []types.ToolResult {
 		}
 	case []types.ContentBlock:
 		for _, block := range v {
+			if block.Type == "image" && block.Source != nil {
+				imageCount++
+				continue
+			}
 			if block.Type == "tool_result" {
 				toolResult := types.ToolResult{}
 
@@ -164,8 +169,13 @@ func extractToolResultsFromMessage(content any) []types.ToolResult {
 				// 处理 content
 				if block.Content != nil {
 					var contentArray []map[string]any
+					content, toolImages, err := splitToolResultImages(block.Content, imageCount+1)
+					if err != nil {
+						return nil, err
+					}
+					imageCount += len(toolImages)
 
-					switch c := block.Content.(type) {
+					switch c := content.(type) {
 					case string:
 						contentArray = []map[string]any{
 							{"text": c},
@@ -199,7 +209,21 @@ func extractToolResultsFromMessage(content any) []types.ToolResult {
 		}
 	}
 
-	return toolResults
+	return toolResults, nil
+}
+
+// processHistoryUserMessage 处理合并前的一条历史用户消息，返回文本、图片和工具结果
+// imageOffset 为合并后的用户消息中已有的图片数量；任一部分处理失败时返回错误，由调用方跳过整条消息
+func processHistoryUserMessage(content any, imageOffset int) (string, []types.CodeWhispererImage, []types.ToolResult, error) {
+	messageContent, messageImages, err := processMessageContent(content, imageOffset)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	toolResults, err := extractToolResultsFromMessage(content, imageOffset)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	return messageContent, messageImages, toolResults, nil
 }
 
 // BuildCodeWhispererRequest 构建 CodeWhisperer 请求
@@ -251,9 +275,9 @@ func BuildCodeWhispererRequest(anthropicReq types.AnthropicRequest, ctx *gin.Con
 	// 	logger.String("role", lastMessage.Role),
 	// 	logger.String("content_type", fmt.Sprintf("%T", lastMessage.Content)))
 
-	textContent, images, err := processMessageContent(lastMessage.Content)
+	textContent, images, err := processMessageContent(lastMessage.Content, 0)
 	if err != nil {
-		return cwReq, fmt.Errorf("处理消息内容失败: %v", err)
+		return cwReq, fmt.Errorf("处理消息内容失败: %w", err)
 	}
 
 	cwReq.ConversationState.CurrentMessage.UserInputMessage.Content = textContent
@@ -266,7 +290,10 @@ func BuildCodeWhispererRequest(anthropicReq types.AnthropicRequest, ctx *gin.Con
 
 	// 新增：检查并处理 ToolResults
 	if lastMessage.Role == "user" {
-		toolResults := extractToolResultsFromMessage(lastMessage.Content)
+		toolResults, err := extractToolResultsFromMessage(lastMessage.Content, 0)
+		if err != nil {
+			return cwReq, fmt.Errorf("处理消息内容失败: %w", err)
+		}
 		if len(toolResults) > 0 {
 			cwReq.ConversationState.CurrentMessage.UserInputMessage.UserInputMessageContext.ToolResults = toolResults
 
@@ -294,7 +321,9 @@ func BuildCodeWhispererRequest(anthropicReq types.AnthropicRequest, ctx *gin.Con
 	cwReq.ConversationState.CurrentMessage.UserInputMessage.Origin = "AI_EDITOR" // v0.4兼容性：固定使用AI_EDITOR
 
 	// 处理 tools 信息 - 根据req.json实际结构优化工具转换
-	if len(anthropicReq.Tools) > 0 {
+	// tool_choice 为 none 时不发送工具定义
+	forcedToolChoice := ForcedToolChoice(anthropicReq)
+	if len(anthropicReq.Tools) > 0 && !toolsDisabled(anthropicReq) {
 		// logger.Debug("开始处理工具配置",
 		// 	logger.Int("tools_count", len(anthropicReq.Tools)),
 		// 	logger.String("conversation_id", cwReq.ConversationState.ConversationId))
@@ -312,6 +341,11 @@ func BuildCodeWhispererRequest(anthropicReq types.AnthropicRequest, ctx *gin.Con
 				continue
 			}
 
+			// 指定工具时只发送该工具
+			if !allowedByToolChoice(forcedToolChoice, tool.Name) {
+				continue
+			}
+
 			// logger.Debug("转换工具定义",
 			// 	logger.Int("tool_index", i),
 			// 	logger.String("tool_name", tool.Name),
@@ -322,13 +356,14 @@ func BuildCodeWhispererRequest(anthropicReq types.AnthropicRequest, ctx *gin.Con
 			cwTool := types.CodeWhispererTool{}
 			cwTool.ToolSpecification.Name = tool.Name
 			
-			// 限制 description 长度为 10000 字符
-			if len(tool.Description) > config.MaxToolDescriptionLength {
-				cwTool.ToolSpecification.Description = tool.Description[:config.MaxToolDescriptionLength]
+			// 限制 description 长度（默认 10000 字符，可在运行时调整）
+			maxDescLen := config.GetMaxToolDescriptionLength()
+			if len(tool.Description) > maxDescLen {
+				cwTool.ToolSpecification.Description = tool.Description[:maxDescLen]
 				logger.Debug("工具描述超长已截断",
 					logger.String("tool_name", tool.Name),
 					logger.Int("original_length", len(tool.Description)),
-					logger.Int("max_length", config.MaxToolDescriptionLength))
+					logger.Int("max_length", maxDescLen))
 			} else {
 				cwTool.ToolSpecification.Description = tool.Description
 			}
@@ -345,12 +380,20 @@ func BuildCodeWhispererRequest(anthropicReq types.AnthropicRequest, ctx *gin.Con
 	}
 
 	// 构建历史消息
-	if len(anthropicReq.System) > 0 || len(anthropicReq.Messages) > 1 || len(anthropicReq.Tools) > 0 {
+	thinkingPrompt := buildThinkingPrompt(anthropicReq.Thinking)
+	toolChoicePrompt := buildToolChoicePrompt(forcedToolChoice)
+	if len(anthropicReq.System) > 0 || len(anthropicReq.Messages) > 1 || len(anthropicReq.Tools) > 0 || thinkingPrompt != "" {
 		var history []any
 
 		// 构建综合系统提示
 		var systemContentBuilder strings.Builder
 
+		// 扩展思考指令放在最前面
+		if thinkingPrompt != "" {
+			systemContentBuilder.WriteString(thinkingPrompt)
+			systemContentBuilder.WriteString("\n")
+		}
+
 		// 添加原有的 system 消息
 		if len(anthropicReq.System) > 0 {
 			for _, sysMsg := range anthropicReq.System {
@@ -362,6 +405,12 @@ func BuildCodeWhispererRequest(anthropicReq types.AnthropicRequest, ctx *gin.Con
 			}
 		}
 
+		// tool_choice 要求调用工具时追加引导指令
+		if toolChoicePrompt != "" {
+			systemContentBuilder.WriteString(toolChoicePrompt)
+			systemContentBuilder.WriteString("\n")
+		}
+
 		// 如果有系统内容，添加到历史记录 (恢复v0.4结构化类型)
 		if systemContentBuilder.Len() > 0 {
 			userMsg := types.HistoryUserMessage{}
@@ -397,6 +446,9 @@ func BuildCodeWhispererRequest(anthropicReq types.AnthropicRequest, ctx *gin.Con
 				continue
 			}
 			if msg.Role == "assistant" {
+				// 历史中的思考块不发送给上游
+				msg.Content = stripThinkingBlocks(msg.Content)
+
 				// 遇到assistant，只有当有对应的user消息时才处理（忽略孤立assistant）
 				if len(userMessagesBuffer) > 0 {
 					// 合并所有累积的user消息
@@ -406,17 +458,18 @@ func BuildCodeWhispererRequest(anthropicReq types.AnthropicRequest, ctx *gin.Con
 					var allToolResults []types.ToolResult
 
 					for _, userMsg := range userMessagesBuffer {
-						// 处理每个user消息的内容和图片
-						messageContent, messageImages, err := processMessageContent(userMsg.Content)
-						if err == nil && messageContent != "" {
+						// 处理每个user消息的内容、图片和工具结果（图片序号在合并后的消息中连续编号）
+						messageContent, messageImages, toolResults, err := processHistoryUserMessage(userMsg.Content, len(allImages))
+						if err != nil {
+							logger.Warn("历史用户消息处理失败，跳过", logger.Err(err))
+							continue
+						}
+						if messageContent != "" {
 							contentParts = append(contentParts, messageContent)
-							if len(messageImages) > 0 {
-								allImages = append(allImages, messageImages...)
-							}
 						}
+						allImages = append(allImages, messageImages...)
 
 						// 收集工具结果
-						toolResults := extractToolResultsFromMessage(userMsg.Content)
 						if len(toolResults) > 0 {
 							allToolResults = append(allToolResults, toolResults...)
 						}
@@ -445,7 +498,7 @@ func BuildCodeWhispererRequest(anthropicReq types.AnthropicRequest, ctx *gin.Con
 
 					// 添加assistant消息（只在有配对的user时添加）
 					assistantMsg := types.HistoryAssistantMessage{}
-					assistantContent, err := utils.GetMessageContent(msg.Content)
+					assistantContent, err := getAssistantContent(msg.Content)
 					if err == nil {
 						assistantMsg.AssistantResponseMessage.Content = assistantContent
 					} else {
@@ -477,15 +530,16 @@ func BuildCodeWhispererRequest(anthropicReq types.AnthropicRequest, ctx *gin.Con
 			var allToolResults []types.ToolResult
 
 			for _, userMsg := range userMessagesBuffer {
-				messageContent, messageImages, err := processMessageContent(userMsg.Content)
-				if err == nil && messageContent != "" {
+				messageContent, messageImages, toolResults, err := processHistoryUserMessage(userMsg.Content, len(allImages))
+				if err != nil {
+					logger.Warn("历史用户消息处理失败，跳过", logger.Err(err))
+					continue
+				}
+				if messageContent != "" {
 					contentParts = append(contentParts, messageContent)
-					if len(messageImages) > 0 {
-						allImages = append(allImages, messageImages...)
-					}
 				}
+				allImages = append(allImages, messageImages...)
 
-				toolResults := extractToolResultsFromMessage(userMsg.Content)
 				if len(toolResults) > 0 {
 					allToolResults = append(allToolResults, toolResults...)
 				}
@@ -1,12 +1,16 @@
 package converter
 
 import (
+	"errors"
+	"strings"
 	"testing"
 
 	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"kiro2api/types"
+	"kiro2api/utils"
+	"kiro2api/websearch"
 )
 
 func init() {
@@ -254,6 +258,16 @@ func TestDetermineChatTriggerType(t *testing.T) {
 			},
 			expected: "AUTO",
 		},
+		{
+			name: "有工具且tool_choice=none - MANUAL",
+			req: types.AnthropicRequest{
+				Tools: []types.AnthropicTool{
+					{Name: "test_tool"},
+				},
+				ToolChoice: &types.ToolChoice{Type: "none"},
+			},
+			expected: "MANUAL",
+		},
 		{
 			name: "无工具，无历史 - MANUAL",
 			req: types.AnthropicRequest{
@@ -340,7 +354,8 @@ func TestExtractToolResultsFromMessage(t *testing.T) {
 			},
 		}
 
-		toolResults := extractToolResultsFromMessage(content)
+		toolResults, err := extractToolResultsFromMessage(content, 0)
+		require.NoError(t, err)
 
 		require.Len(t, toolResults, 1)
 		assert.Equal(t, "tool_123", toolResults[0].ToolUseId)
@@ -361,7 +376,8 @@ func TestExtractToolResultsFromMessage(t *testing.T) {
 			},
 		}
 
-		toolResults := extractToolResultsFromMessage(content)
+		toolResults, err := extractToolResultsFromMessage(content, 0)
+		require.NoError(t, err)
 
 		require.Len(t, toolResults, 1)
 		assert.Equal(t, "error", toolResults[0].Status)
@@ -645,3 +661,294 @@ func TestBuildCodeWhispererRequest_OrphanUserMessages(t *testing.T) {
 	})
 }
 
+
+func TestBuildCodeWhispererRequest_ThinkingEnabled(t *testing.T) {
+	anthropicReq := types.AnthropicRequest{
+		Model:     "claude-sonnet-4-20250514",
+		MaxTokens: 8000,
+		Thinking:  &types.Thinking{Type: "enabled", BudgetTokens: 4000},
+		Messages: []types.AnthropicRequestMessage{
+			{Role: "user", Content: "What is 2+2?"},
+			{Role: "assistant", Content: []any{
+				map[string]any{"type": "thinking", "thinking": "secret reasoning", "signature": "sig"},
+				map[string]any{"type": "redacted_thinking", "data": "opaque"},
+				map[string]any{"type": "text", "text": "4"},
+			}},
+			{Role: "user", Content: "And 3+3?"},
+		},
+	}
+
+	cwReq, err := BuildCodeWhispererRequest(anthropicReq, nil)
+	require.NoError(t, err)
+
+	history := cwReq.ConversationState.History
+	require.Len(t, history, 4) // 系统提示 + OK + user + assistant
+
+	systemMsg, ok := history[0].(types.HistoryUserMessage)
+	require.True(t, ok)
+	assert.Equal(t, "<thinking_mode>enabled</thinking_mode>\n<max_thinking_length>4000</max_thinking_length>",
+		systemMsg.UserInputMessage.Content)
+
+	assistantMsg, ok := history[3].(types.HistoryAssistantMessage)
+	require.True(t, ok)
+	assert.Equal(t, "4", assistantMsg.AssistantResponseMessage.Content)
+	assert.NotContains(t, assistantMsg.AssistantResponseMessage.Content, "secret reasoning")
+}
+
+func TestBuildCodeWhispererRequest_ThinkingDisabled(t *testing.T) {
+	anthropicReq := types.AnthropicRequest{
+		Model:     "claude-sonnet-4-20250514",
+		MaxTokens: 1024,
+		Thinking:  &types.Thinking{Type: "disabled"},
+		Messages:  []types.AnthropicRequestMessage{{Role: "user", Content: "Hello"}},
+	}
+
+	cwReq, err := BuildCodeWhispererRequest(anthropicReq, nil)
+	require.NoError(t, err)
+	assert.Empty(t, cwReq.ConversationState.History)
+}
+
+func TestBuildCodeWhispererRequest_ToolChoiceNone(t *testing.T) {
+	anthropicReq := types.AnthropicRequest{
+		Model:      "claude-sonnet-4-20250514",
+		MaxTokens:  1024,
+		Messages:   []types.AnthropicRequestMessage{{Role: "user", Content: "Hello"}},
+		Tools:      []types.AnthropicTool{{Name: "read", InputSchema: map[string]any{"type": "object"}}},
+		ToolChoice: map[string]any{"type": "none"},
+	}
+
+	cwReq, err := BuildCodeWhispererRequest(anthropicReq, nil)
+	require.NoError(t, err)
+	assert.Empty(t, cwReq.ConversationState.CurrentMessage.UserInputMessage.UserInputMessageContext.Tools)
+	assert.Equal(t, "MANUAL", cwReq.ConversationState.ChatTriggerType)
+}
+
+func TestBuildCodeWhispererRequest_ToolChoiceTool(t *testing.T) {
+	anthropicReq := types.AnthropicRequest{
+		Model:     "claude-sonnet-4-20250514",
+		MaxTokens: 1024,
+		Messages:  []types.AnthropicRequestMessage{{Role: "user", Content: "Hello"}},
+		Tools: []types.AnthropicTool{
+			{Name: "read", InputSchema: map[string]any{"type": "object"}},
+			{Name: "write", InputSchema: map[string]any{"type": "object"}},
+		},
+		ToolChoice: map[string]any{"type": "tool", "name": "write"},
+	}
+
+	cwReq, err := BuildCodeWhispererRequest(anthropicReq, nil)
+	require.NoError(t, err)
+
+	tools := cwReq.ConversationState.CurrentMessage.UserInputMessage.UserInputMessageContext.Tools
+	require.Len(t, tools, 1)
+	assert.Equal(t, "write", tools[0].ToolSpecification.Name)
+	assert.Equal(t, "AUTO", cwReq.ConversationState.ChatTriggerType)
+
+	require.NotEmpty(t, cwReq.ConversationState.History)
+	systemMsg, ok := cwReq.ConversationState.History[0].(types.HistoryUserMessage)
+	require.True(t, ok)
+	assert.Contains(t, systemMsg.UserInputMessage.Content, "You must respond by calling the tool `write`")
+}
+
+func TestBuildCodeWhispererRequest_DocumentBlock(t *testing.T) {
+	anthropicReq := types.AnthropicRequest{
+		Model:     "claude-sonnet-4-20250514",
+		MaxTokens: 1024,
+		Messages: []types.AnthropicRequestMessage{{Role: "user", Content: []any{
+			map[string]any{
+				"type":    "document",
+				"source":  map[string]any{"type": "text", "media_type": "text/plain", "data": "The sky is blue."},
+				"title":   "Facts",
+				"context": "Reference material",
+			},
+			map[string]any{"type": "text", "text": "What color is the sky?"},
+		}}},
+	}
+
+	cwReq, err := BuildCodeWhispererRequest(anthropicReq, nil)
+	require.NoError(t, err)
+	assert.Equal(t,
+		"<document>\n<title>Facts</title>\n<context>Reference material</context>\n<document_content>\nThe sky is blue.\n</document_content>\n</document>\nWhat color is the sky?",
+		cwReq.ConversationState.CurrentMessage.UserInputMessage.Content)
+}
+
+func TestBuildCodeWhispererRequest_DocumentUnsupportedSource(t *testing.T) {
+	anthropicReq := types.AnthropicRequest{
+		Model:     "claude-sonnet-4-20250514",
+		MaxTokens: 1024,
+		Messages: []types.AnthropicRequestMessage{{Role: "user", Content: []any{
+			map[string]any{"type": "document", "source": map[string]any{"type": "url", "url": "https://example.com/a.pdf"}},
+		}}},
+	}
+
+	_, err := BuildCodeWhispererRequest(anthropicReq, nil)
+	var docErr *utils.DocumentError
+	require.True(t, errors.As(err, &docErr))
+}
+
+// testPNGBase64 1x1 PNG
+const testPNGBase64 = "iVBORw0KGgoAAAANSUhEUgAAAAEAAAABCAYAAAAfFcSJAAAADUlEQVR42mNkYPhfDwAChwGA60e6kgAAAABJRU5ErkJggg=="
+
+func testImageBlock() map[string]any {
+	return map[string]any{
+		"type":   "image",
+		"source": map[string]any{"type": "base64", "media_type": "image/png", "data": testPNGBase64},
+	}
+}
+
+func TestBuildCodeWhispererRequest_ToolResultImages(t *testing.T) {
+	anthropicReq := types.AnthropicRequest{
+		Model:     "claude-sonnet-4-20250514",
+		MaxTokens: 1024,
+		Messages: []types.AnthropicRequestMessage{
+			{Role: "user", Content: "Take a screenshot"},
+			{Role: "assistant", Content: []any{
+				map[string]any{"type": "tool_use", "id": "toolu_1", "name": "screenshot", "input": map[string]any{}},
+			}},
+			{Role: "user", Content: []any{
+				testImageBlock(),
+				map[string]any{"type": "tool_result", "tool_use_id": "toolu_1", "content": []any{
+					map[string]any{"type": "text", "text": "Screenshot taken"},
+					testImageBlock(),
+				}},
+			}},
+		},
+	}
+
+	cwReq, err := BuildCodeWhispererRequest(anthropicReq, nil)
+	require.NoError(t, err)
+
+	userInput := cwReq.ConversationState.CurrentMessage.UserInputMessage
+	require.Len(t, userInput.Images, 2)
+	assert.Equal(t, "png", userInput.Images[1].Format)
+	require.Len(t, userInput.UserInputMessageContext.ToolResults, 1)
+	assert.Equal(t, []map[string]any{
+		{"type": "text", "text": "Screenshot taken"},
+		{"type": "text", "text": "[图片: 见本条消息的第 2 张图片]"},
+	}, userInput.UserInputMessageContext.ToolResults[0].Content)
+}
+
+func TestBuildCodeWhispererRequest_ToolResultImagesInHistory(t *testing.T) {
+	anthropicReq := types.AnthropicRequest{
+		Model:     "claude-sonnet-4-20250514",
+		MaxTokens: 1024,
+		Messages: []types.AnthropicRequestMessage{
+			{Role: "user", Content: "Take a screenshot"},
+			{Role: "assistant", Content: []any{
+				map[string]any{"type": "tool_use", "id": "toolu_1", "name": "screenshot", "input": map[string]any{}},
+			}},
+			{Role: "user", Content: []any{
+				map[string]any{"type": "tool_result", "tool_use_id": "toolu_1", "content": []any{testImageBlock()}},
+			}},
+			{Role: "assistant", Content: "The screen shows a login form."},
+			{Role: "user", Content: "Thanks"},
+		},
+	}
+
+	cwReq, err := BuildCodeWhispererRequest(anthropicReq, nil)
+	require.NoError(t, err)
+
+	var found bool
+	for _, msg := range cwReq.ConversationState.History {
+		userMsg, ok := msg.(types.HistoryUserMessage)
+		if !ok || len(userMsg.UserInputMessage.UserInputMessageContext.ToolResults) == 0 {
+			continue
+		}
+		found = true
+		assert.Len(t, userMsg.UserInputMessage.Images, 1)
+		assert.Equal(t, []map[string]any{
+			{"type": "text", "text": "[图片: 见本条消息的第 1 张图片]"},
+		}, userMsg.UserInputMessage.UserInputMessageContext.ToolResults[0].Content)
+	}
+	assert.True(t, found)
+}
+
+func TestBuildCodeWhispererRequest_WebSearchBlocksInHistory(t *testing.T) {
+	anthropicReq := types.AnthropicRequest{
+		Model:     "claude-sonnet-4-20250514",
+		MaxTokens: 1024,
+		Messages: []types.AnthropicRequestMessage{
+			{Role: "user", Content: "What's new in Go 1.23?"},
+			{Role: "assistant", Content: []any{
+				map[string]any{"type": "text", "text": "Let me search."},
+				map[string]any{"type": "server_tool_use", "id": "srvtoolu_1", "name": "web_search", "input": map[string]any{"query": "go 1.23 release"}},
+				map[string]any{"type": "web_search_tool_result", "tool_use_id": "srvtoolu_1", "content": []any{
+					map[string]any{"type": "web_search_result", "title": "Go 1.23 Release Notes", "url": "https://go.dev/doc/go1.23", "page_age": "2024-08-13", "encrypted_content": websearch.EncodeContent("Range over func.")},
+				}},
+				map[string]any{"type": "server_tool_use", "id": "srvtoolu_2", "name": "web_search", "input": map[string]any{"query": "go 1.23 iterators"}},
+				map[string]any{"type": "web_search_tool_result", "tool_use_id": "srvtoolu_2", "content": map[string]any{"type": "web_search_tool_result_error", "error_code": "max_uses_exceeded"}},
+				map[string]any{"type": "text", "text": "Go 1.23 adds range over func."},
+			}},
+			{Role: "user", Content: "Thanks"},
+		},
+	}
+
+	cwReq, err := BuildCodeWhispererRequest(anthropicReq, nil)
+	require.NoError(t, err)
+	require.Len(t, cwReq.ConversationState.History, 2)
+
+	assistant, ok := cwReq.ConversationState.History[1].(types.HistoryAssistantMessage)
+	require.True(t, ok)
+	text := assistant.AssistantResponseMessage.Content
+	assert.Contains(t, text, "Let me search.\n[Searched the web for \"go 1.23 release\"]")
+	assert.Contains(t, text, "URL: https://go.dev/doc/go1.23\nPublished: 2024-08-13\nRange over func.")
+	assert.Contains(t, text, "Web search failed: max_uses_exceeded")
+	assert.True(t, strings.HasSuffix(text, "Go 1.23 adds range over func."))
+}
+
+func TestBuildCodeWhispererRequest_MergedUserMessagesImageNumbering(t *testing.T) {
+	toolResultWithImage := []any{
+		map[string]any{"type": "tool_result", "tool_use_id": "toolu_1", "content": []any{
+			map[string]any{"type": "text", "text": "Screenshot taken"},
+			testImageBlock(),
+		}},
+	}
+	anthropicReq := types.AnthropicRequest{
+		Model:     "claude-sonnet-4-20250514",
+		MaxTokens: 1024,
+		Messages: []types.AnthropicRequestMessage{
+			{Role: "user", Content: []any{testImageBlock(), map[string]any{"type": "text", "text": "Compare with this"}}},
+			{Role: "user", Content: toolResultWithImage},
+			{Role: "assistant", Content: "They look the same."},
+			{Role: "user", Content: "Thanks"},
+		},
+	}
+
+	cwReq, err := BuildCodeWhispererRequest(anthropicReq, nil)
+	require.NoError(t, err)
+
+	userMsg, ok := cwReq.ConversationState.History[0].(types.HistoryUserMessage)
+	require.True(t, ok)
+	require.Len(t, userMsg.UserInputMessage.Images, 2)
+	require.Len(t, userMsg.UserInputMessage.UserInputMessageContext.ToolResults, 1)
+	assert.Equal(t, []map[string]any{
+		{"type": "text", "text": "Screenshot taken"},
+		{"type": "text", "text": "[图片: 见本条消息的第 2 张图片]"},
+	}, userMsg.UserInputMessage.UserInputMessageContext.ToolResults[0].Content)
+
+	// 文本中的占位与工具结果使用相同的序号
+	text, images, err := processMessageContent(toolResultWithImage, 1)
+	require.NoError(t, err)
+	assert.Len(t, images, 1)
+	assert.Contains(t, text, "[图片: 见本条消息的第 2 张图片]")
+}
+
+func TestBuildCodeWhispererRequest_InvalidToolResultImage(t *testing.T) {
+	anthropicReq := types.AnthropicRequest{
+		Model:     "claude-sonnet-4-20250514",
+		MaxTokens: 1024,
+		Messages: []types.AnthropicRequestMessage{
+			{Role: "user", Content: []any{
+				map[string]any{"type": "tool_result", "tool_use_id": "toolu_1", "content": []any{
+					map[string]any{"type": "image", "source": map[string]any{"type": "base64", "media_type": "image/png", "data": "not-base64!"}},
+				}},
+			}},
+		},
+	}
+
+	_, err := BuildCodeWhispererRequest(anthropicReq, nil)
+	assert.Error(t, err)
+
+	toolResults, err := extractToolResultsFromMessage(anthropicReq.Messages[0].Content, 0)
+	assert.Error(t, err)
+	assert.Nil(t, toolResults)
+}
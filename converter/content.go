@@ -12,7 +12,8 @@ import (
 // 消息内容处理器
 
 // processMessageContent 处理消息内容，提取文本和图片
-func processMessageContent(content any) (string, []types.CodeWhispererImage, error) {
+// imageOffset 为该消息之前已加入同一用户消息图片列表的图片数量（合并多条历史用户消息时），用于工具结果图片占位中的序号
+func processMessageContent(content any, imageOffset int) (string, []types.CodeWhispererImage, error) {
 	var textParts []string
 	var images []types.CodeWhispererImage
 
@@ -52,10 +53,21 @@ func processMessageContent(content any) (string, []types.CodeWhispererImage, err
 							images = append(images, *cwImage)
 						}
 					}
+				case "document":
+					docText, err := documentBlockText(contentBlock)
+					if err != nil {
+						return "", nil, fmt.Errorf("文档处理失败: %w", err)
+					}
+					textParts = appendDocumentText(textParts, docText)
 				case "tool_result":
-					// 处理工具结果，支持复杂的内容结构
+					// 处理工具结果，支持复杂的内容结构（其中的图片转为消息图片）
 					if contentBlock.Content != nil {
-						parsedContent := utils.ParseToolResultContent(contentBlock.Content)
+						toolContent, toolImages, err := splitToolResultImages(contentBlock.Content, imageOffset+len(images)+1)
+						if err != nil {
+							return "", nil, err
+						}
+						images = append(images, toolImages...)
+						parsedContent := utils.ParseToolResultContent(toolContent)
 						// 如果内容为空，提供默认值
 						if parsedContent == "" {
 							parsedContent = "Tool executed successfully"
@@ -93,10 +105,21 @@ func processMessageContent(content any) (string, []types.CodeWhispererImage, err
 						images = append(images, *cwImage)
 					}
 				}
+			case "document":
+				docText, err := documentBlockText(block)
+				if err != nil {
+					return "", nil, fmt.Errorf("文档处理失败: %w", err)
+				}
+				textParts = appendDocumentText(textParts, docText)
 			case "tool_result":
-				// 处理工具结果，支持复杂的内容结构
+				// 处理工具结果，支持复杂的内容结构（其中的图片转为消息图片）
 				if block.Content != nil {
-					parsedContent := utils.ParseToolResultContent(block.Content)
+					toolContent, toolImages, err := splitToolResultImages(block.Content, imageOffset+len(images)+1)
+					if err != nil {
+						return "", nil, err
+					}
+					images = append(images, toolImages...)
+					parsedContent := utils.ParseToolResultContent(toolContent)
 					// 如果内容为空，提供默认值
 					if parsedContent == "" {
 						parsedContent = "Tool executed successfully"
@@ -162,6 +185,9 @@ func parseContentBlock(block map[string]any) (types.ContentBlock, error) {
 			if data, ok := source["data"].(string); ok {
 				imageSource.Data = data
 			}
+			if url, ok := source["url"].(string); ok {
+				imageSource.URL = url
+			}
 
 			contentBlock.Source = imageSource
 		}
@@ -178,6 +204,17 @@ func parseContentBlock(block map[string]any) (types.ContentBlock, error) {
 			contentBlock.Source = imageSource
 		}
 
+	case "document":
+		if source, ok := block["source"].(map[string]any); ok {
+			contentBlock.Source = utils.DocumentSourceFromMap(source)
+		}
+		if title, ok := block["title"].(string); ok {
+			contentBlock.Title = &title
+		}
+		if context, ok := block["context"].(string); ok {
+			contentBlock.Context = &context
+		}
+
 	case "tool_result":
 		if toolUseId, ok := block["tool_use_id"].(string); ok {
 			contentBlock.ToolUseId = &toolUseId
@@ -203,3 +240,27 @@ func parseContentBlock(block map[string]any) (types.ContentBlock, error) {
 
 	return contentBlock, nil
 }
+
+// documentBlockText 提取文档块的文本并格式化为带分隔标签的上下文
+func documentBlockText(block types.ContentBlock) (string, error) {
+	text, err := utils.ExtractDocumentText(block.Source)
+	if err != nil {
+		return "", err
+	}
+	var title, context string
+	if block.Title != nil {
+		title = *block.Title
+	}
+	if block.Context != nil {
+		context = *block.Context
+	}
+	return utils.FormatDocumentContext(title, context, text), nil
+}
+
+// appendDocumentText 追加文档上下文，与之前的文本之间保留换行（文本片段直接拼接）
+func appendDocumentText(textParts []string, docText string) []string {
+	if n := len(textParts); n > 0 && !strings.HasSuffix(textParts[n-1], "\n") {
+		docText = "\n" + docText
+	}
+	return append(textParts, docText)
+}
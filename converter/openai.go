@@ -54,6 +54,9 @@ func ConvertOpenAIToAnthropic(openaiReq types.OpenAIRequest) types.AnthropicRequ
 		anthropicReq.Temperature = openaiReq.Temperature
 	}
 
+	// 转换 stop
+	anthropicReq.StopSequences = convertOpenAIStop(openaiReq.Stop)
+
 	// 转换 tools
 	if len(openaiReq.Tools) > 0 {
 		anthropicTools, err := validateAndProcessTools(openaiReq.Tools)
@@ -189,3 +192,30 @@ func ConvertAnthropicToOpenAI(anthropicResp map[string]any, model string, messag
 		},
 	}
 }
+
+// convertOpenAIStop 将OpenAI的stop参数（string 或 []string）转换为Anthropic的stop_sequences
+func convertOpenAIStop(stop any) []string {
+	switch v := stop.(type) {
+	case string:
+		if v != "" {
+			return []string{v}
+		}
+	case []any:
+		var sequences []string
+		for _, item := range v {
+			if s, ok := item.(string); ok && s != "" {
+				sequences = append(sequences, s)
+			}
+		}
+		return sequences
+	case []string:
+		var sequences []string
+		for _, s := range v {
+			if s != "" {
+				sequences = append(sequences, s)
+			}
+		}
+		return sequences
+	}
+	return nil
+}
@@ -239,3 +239,24 @@ func TestConvertAnthropicToOpenAI_EmptyContent(t *testing.T) {
 	assert.Len(t, openaiResp.Choices, 1)
 	assert.Empty(t, openaiResp.Choices[0].Message.Content)
 }
+
+func TestConvertOpenAIToAnthropic_Stop(t *testing.T) {
+	cases := []struct {
+		stop any
+		want []string
+	}{
+		{nil, nil},
+		{"", nil},
+		{"END", []string{"END"}},
+		{[]any{"\n\n", "", "Human:"}, []string{"\n\n", "Human:"}},
+		{[]string{"###"}, []string{"###"}},
+	}
+	for _, tc := range cases {
+		req := ConvertOpenAIToAnthropic(types.OpenAIRequest{
+			Model:    "claude-sonnet-4-20250514",
+			Messages: []types.OpenAIMessage{{Role: "user", Content: "hi"}},
+			Stop:     tc.stop,
+		})
+		assert.Equal(t, tc.want, req.StopSequences, "stop=%v", tc.stop)
+	}
+}
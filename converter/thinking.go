@@ -0,0 +1,55 @@
+package converter
+
+import (
+	"fmt"
+
+	"kiro2api/types"
+)
+
+// 上游不支持 thinking 参数，开启扩展思考时通过系统提示引导模型先在 <thinking> 标签内输出思考过程，
+// 再由响应处理将标签内容拆分为 thinking 内容块
+
+// buildThinkingPrompt 构建扩展思考的系统提示，未开启时返回空字符串
+func buildThinkingPrompt(thinking *types.Thinking) string {
+	if !thinking.IsEnabled() {
+		return ""
+	}
+	prompt := "<thinking_mode>enabled</thinking_mode>"
+	if thinking.BudgetTokens > 0 {
+		prompt += fmt.Sprintf("\n<max_thinking_length>%d</max_thinking_length>", thinking.BudgetTokens)
+	}
+	return prompt
+}
+
+// isThinkingBlock 判断是否为历史中的思考块（thinking/redacted_thinking），这类块不发送给上游
+func isThinkingBlock(blockType string) bool {
+	return blockType == "thinking" || blockType == "redacted_thinking"
+}
+
+// stripThinkingBlocks 移除消息内容中的思考块
+// 客户端会在后续请求的历史中原样带回 thinking 块，上游无法识别其签名，且重复发送思考过程会浪费上下文
+func stripThinkingBlocks(content any) any {
+	switch v := content.(type) {
+	case []any:
+		filtered := make([]any, 0, len(v))
+		for _, item := range v {
+			if block, ok := item.(map[string]any); ok {
+				if blockType, _ := block["type"].(string); isThinkingBlock(blockType) {
+					continue
+				}
+			}
+			filtered = append(filtered, item)
+		}
+		return filtered
+	case []types.ContentBlock:
+		filtered := make([]types.ContentBlock, 0, len(v))
+		for _, block := range v {
+			if !isThinkingBlock(block.Type) {
+				filtered = append(filtered, block)
+			}
+		}
+		return filtered
+	default:
+		return content
+	}
+}
@@ -0,0 +1,82 @@
+package converter
+
+import (
+	"fmt"
+
+	"kiro2api/types"
+)
+
+// tool_choice 处理
+// 上游没有对应参数：none 时不向上游发送工具定义；any 和指定工具时通过系统提示引导模型调用工具，
+// 指定工具时只发送该工具的定义。模型仍未调用工具时由服务层负责有限次数的重试
+
+// ResolveToolChoice 将请求中的 tool_choice 解析为统一结构，无法识别时返回 nil（等同 auto）
+// 支持 *types.ToolChoice、types.ToolChoice、JSON 解码后的 map 以及字符串形式（"auto"/"any"/"none"）
+func ResolveToolChoice(toolChoice any) *types.ToolChoice {
+	switch tc := toolChoice.(type) {
+	case *types.ToolChoice:
+		return tc
+	case types.ToolChoice:
+		return &tc
+	case map[string]any:
+		tcType, _ := tc["type"].(string)
+		if tcType == "" {
+			return nil
+		}
+		name, _ := tc["name"].(string)
+		return &types.ToolChoice{Type: tcType, Name: name}
+	case string:
+		if tc == "" {
+			return nil
+		}
+		return &types.ToolChoice{Type: tc}
+	default:
+		return nil
+	}
+}
+
+// ForcedToolChoice 返回要求模型必须调用工具的 tool_choice（any 或指定工具），否则返回 nil
+func ForcedToolChoice(anthropicReq types.AnthropicRequest) *types.ToolChoice {
+	if len(anthropicReq.Tools) == 0 {
+		return nil
+	}
+	tc := ResolveToolChoice(anthropicReq.ToolChoice)
+	if tc == nil || (tc.Type != "any" && tc.Type != "tool") {
+		return nil
+	}
+	return tc
+}
+
+// toolsDisabled tool_choice 为 none 时不向上游发送工具
+func toolsDisabled(anthropicReq types.AnthropicRequest) bool {
+	tc := ResolveToolChoice(anthropicReq.ToolChoice)
+	return tc != nil && tc.Type == "none"
+}
+
+// allowedByToolChoice 指定工具时只发送该工具的定义
+func allowedByToolChoice(tc *types.ToolChoice, toolName string) bool {
+	return tc == nil || tc.Type != "tool" || tc.Name == toolName
+}
+
+// buildToolChoicePrompt 生成引导模型调用工具的系统提示，不需要引导时返回空字符串
+func buildToolChoicePrompt(tc *types.ToolChoice) string {
+	if tc == nil {
+		return ""
+	}
+	switch tc.Type {
+	case "any":
+		return "You must respond by calling at least one of the available tools. Do not answer with plain text only."
+	case "tool":
+		return fmt.Sprintf("You must respond by calling the tool `%s`. Do not answer with plain text only.", tc.Name)
+	default:
+		return ""
+	}
+}
+
+// BuildToolChoiceReminder 生成重试时追加的用户提醒（模型上一次回复没有调用要求的工具）
+func BuildToolChoiceReminder(tc *types.ToolChoice) string {
+	if tc != nil && tc.Type == "tool" {
+		return fmt.Sprintf("Your previous reply did not call the required tool. Call the tool `%s` now.", tc.Name)
+	}
+	return "Your previous reply did not call any tool. Call one of the available tools now."
+}
@@ -0,0 +1,69 @@
+package converter
+
+import (
+	"fmt"
+
+	"kiro2api/types"
+	"kiro2api/utils"
+)
+
+// tool_result 中的图片
+// 上游的工具结果只支持文本和 JSON：截图、浏览器等工具返回的图片块转为所在用户消息的图片附件，
+// 与消息中的其他图片保持出现顺序，工具结果中原位置替换为引用该图片的文本占位
+
+// toolResultImagePlaceholder 工具结果中图片的文本占位，index 为图片在用户消息图片列表中的序号（从 1 开始）
+func toolResultImagePlaceholder(index int) string {
+	return fmt.Sprintf("[图片: 见本条消息的第 %d 张图片]", index)
+}
+
+// splitToolResultImages 拆分工具结果内容中的图片块，返回替换为文本占位后的内容和转换后的图片
+// firstIndex 为第一张图片在用户消息图片列表中的序号；内容中没有图片时原样返回
+func splitToolResultImages(content any, firstIndex int) (any, []types.CodeWhispererImage, error) {
+	var items []any
+	switch c := content.(type) {
+	case []any:
+		items = c
+	case map[string]any:
+		items = []any{c}
+	default:
+		return content, nil, nil
+	}
+
+	var images []types.CodeWhispererImage
+	var replaced []any
+	for i, item := range items {
+		block, ok := item.(map[string]any)
+		if !ok || block["type"] != "image" {
+			continue
+		}
+		contentBlock, err := parseContentBlock(block)
+		if err != nil || contentBlock.Source == nil {
+			continue
+		}
+		if err := utils.ValidateImageContent(contentBlock.Source); err != nil {
+			return content, nil, fmt.Errorf("工具结果中的图片验证失败: %v", err)
+		}
+		cwImage := utils.CreateCodeWhispererImage(contentBlock.Source)
+		if cwImage == nil {
+			continue
+		}
+		if replaced == nil {
+			replaced = append([]any(nil), items...)
+		}
+		replaced[i] = map[string]any{"type": "text", "text": toolResultImagePlaceholder(firstIndex + len(images))}
+		images = append(images, *cwImage)
+	}
+	if replaced == nil {
+		return content, nil, nil
+	}
+	return replaced, images, nil
+}
+
+// isMessageImageBlock 判断消息内容块是否会转换为用户消息的图片（与 processMessageContent 的处理一致）
+func isMessageImageBlock(block map[string]any) bool {
+	if block["type"] != "image" && block["type"] != "image_url" {
+		return false
+	}
+	contentBlock, err := parseContentBlock(block)
+	return err == nil && contentBlock.Type == "image" && contentBlock.Source != nil
+}
@@ -185,8 +185,8 @@ func convertOpenAIToolChoiceToAnthropic(openaiToolChoice any) any {
 		case "required", "any":
 			return &types.ToolChoice{Type: "any"}
 		case "none":
-			// Anthropic没有"none"选项，返回nil表示不强制使用工具
-			return nil
+			// 不使用工具：转换时不向上游发送工具定义
+			return &types.ToolChoice{Type: "none"}
 		default:
 			// 未知字符串，默认为auto
 			return &types.ToolChoice{Type: "auto"}
@@ -296,6 +296,10 @@ func convertContentBlock(block map[string]any) (map[string]any, error) {
 			"media_type": imageSource.MediaType,
 			"data":       imageSource.Data,
 		}
+		if imageSource.Type == "url" {
+			// 远程图片由预处理阶段下载
+			sourceMap = map[string]any{"type": "url", "url": imageSource.URL}
+		}
 
 		convertedBlock := map[string]any{
 			"type":   "image",
@@ -295,7 +295,9 @@ func TestConvertOpenAIToolChoiceToAnthropic_StringAny(t *testing.T) {
 func TestConvertOpenAIToolChoiceToAnthropic_StringNone(t *testing.T) {
 	result := convertOpenAIToolChoiceToAnthropic("none")
 
-	assert.Nil(t, result, "none应该返回nil")
+	toolChoice, ok := result.(*types.ToolChoice)
+	assert.True(t, ok)
+	assert.Equal(t, "none", toolChoice.Type)
 }
 
 func TestConvertOpenAIToolChoiceToAnthropic_StringUnknown(t *testing.T) {
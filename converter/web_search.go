@@ -0,0 +1,120 @@
+package converter
+
+import (
+	"fmt"
+
+	"kiro2api/types"
+	"kiro2api/utils"
+	"kiro2api/websearch"
+)
+
+// 历史消息中的网络搜索块
+// 代理执行 web_search 后返回给客户端的 server_tool_use 和 web_search_tool_result 块会随历史回传，
+// 上游不认识这两种块，转换前还原为模型当时看到的文本
+
+// getAssistantContent 提取助手历史消息的文本，网络搜索块还原为文本后与其他内容一同处理
+func getAssistantContent(content any) (string, error) {
+	return utils.GetMessageContent(replaceWebSearchBlocks(content))
+}
+
+// replaceWebSearchBlocks 将内容中的网络搜索块替换为文本块，没有搜索块时原样返回
+func replaceWebSearchBlocks(content any) any {
+	switch v := content.(type) {
+	case []any:
+		var replaced []any
+		for i, item := range v {
+			block, ok := item.(map[string]any)
+			if !ok || !isWebSearchBlock(block["type"]) {
+				if replaced != nil {
+					replaced = append(replaced, item)
+				}
+				continue
+			}
+			if replaced == nil {
+				replaced = append(make([]any, 0, len(v)), v[:i]...)
+			}
+			if text := webSearchBlockText(webSearchBlockFromMap(block)); text != "" {
+				replaced = append(replaced, map[string]any{"type": "text", "text": text})
+			}
+		}
+		if replaced == nil {
+			return content
+		}
+		return replaced
+	case []types.ContentBlock:
+		var replaced []types.ContentBlock
+		for i, block := range v {
+			if !isWebSearchBlock(block.Type) {
+				if replaced != nil {
+					replaced = append(replaced, block)
+				}
+				continue
+			}
+			if replaced == nil {
+				replaced = append(make([]types.ContentBlock, 0, len(v)), v[:i]...)
+			}
+			if text := webSearchBlockText(block); text != "" {
+				replaced = append(replaced, types.ContentBlock{Type: "text", Text: &text})
+			}
+		}
+		if replaced == nil {
+			return content
+		}
+		return replaced
+	}
+	return content
+}
+
+func isWebSearchBlock(blockType any) bool {
+	return blockType == "server_tool_use" || blockType == "web_search_tool_result"
+}
+
+// webSearchBlockFromMap 解析 map 格式的网络搜索块
+func webSearchBlockFromMap(block map[string]any) types.ContentBlock {
+	cb := types.ContentBlock{Content: block["content"]}
+	cb.Type, _ = block["type"].(string)
+	if input, ok := block["input"]; ok {
+		cb.Input = &input
+	}
+	return cb
+}
+
+// webSearchBlockText 将代理执行的网络搜索还原为文本
+// server_tool_use 还原为搜索关键词，web_search_tool_result 按回填给模型时的格式还原结果（摘要来自 encrypted_content）
+func webSearchBlockText(cb types.ContentBlock) string {
+	if cb.Type == "server_tool_use" {
+		if cb.Input == nil {
+			return ""
+		}
+		input, _ := (*cb.Input).(map[string]any)
+		query, _ := input["query"].(string)
+		if query == "" {
+			return ""
+		}
+		return fmt.Sprintf("[Searched the web for %q]", query)
+	}
+
+	items, ok := cb.Content.([]any)
+	if !ok {
+		if errBlock, ok := cb.Content.(map[string]any); ok {
+			return fmt.Sprintf("Web search failed: %v", errBlock["error_code"])
+		}
+		return ""
+	}
+	results := make([]websearch.Result, 0, len(items))
+	for _, item := range items {
+		m, ok := item.(map[string]any)
+		if !ok || m["type"] != "web_search_result" {
+			continue
+		}
+		var r websearch.Result
+		r.Title, _ = m["title"].(string)
+		r.URL, _ = m["url"].(string)
+		r.PageAge, _ = m["page_age"].(string)
+		if encrypted, ok := m["encrypted_content"].(string); ok {
+			r.Content = websearch.DecodeContent(encrypted)
+		}
+		results = append(results, r)
+	}
+	return websearch.FormatResults("", results)
+}
@@ -43,12 +43,15 @@ type Field struct {
 
 // Logger 优化的日志器
 type Logger struct {
-	level        int64       // 使用原子操作的日志级别
-	logger       *log.Logger // log.Logger本身线程安全，移除mutex
-	logFile      *os.File
-	writers      []io.Writer
-	enableCaller bool // 控制是否获取调用栈信息（包含文件与函数名）
-	callerSkip   int  // 调用栈深度
+	level         int64       // 使用原子操作的日志级别
+	logger        *log.Logger // log.Logger本身线程安全，移除mutex
+	logFile       *RotatingFile
+	accessFile    *RotatingFile // 访问日志文件（LOG_ACCESS_FILE），未配置时为nil
+	writers       []io.Writer
+	enableCaller  bool // 控制是否获取调用栈信息（包含文件与函数名）
+	callerSkip    int  // 调用栈深度
+	redact        bool // 输出前对字段脱敏（LOG_REDACT=false 关闭）
+	maxFieldBytes int  // 单个字段的长度上限，0 表示不限制
 }
 
 var (
@@ -63,11 +66,14 @@ func init() {
 // createLogger 创建并配置logger实例
 func createLogger() *Logger {
 	logger := &Logger{
-		level:        int64(INFO),
-		writers:      []io.Writer{os.Stdout}, // 默认输出到控制台
-		enableCaller: false,                  // 默认禁用调用栈获取（可通过LOG_ENABLE_CALLER开启）
-		callerSkip:   3,                      // 默认调用栈深度
+		level:         int64(INFO),
+		writers:       []io.Writer{os.Stdout}, // 默认输出到控制台
+		enableCaller:  false,                  // 默认禁用调用栈获取（可通过LOG_ENABLE_CALLER开启）
+		callerSkip:    3,                      // 默认调用栈深度
+		redact:        os.Getenv("LOG_REDACT") != "false",
+		maxFieldBytes: envInt("LOG_MAX_FIELD_BYTES", defaultMaxFieldBytes),
 	}
+	loadRedactPatternsFromEnv()
 
 	// 从环境变量设置级别
 	if debug := os.Getenv("DEBUG"); debug != "" && (debug == "true" || debug == "1") {
@@ -97,9 +103,10 @@ func createLogger() *Logger {
 		}
 	}
 
-	// 设置文件输出
+	// 设置文件输出（追加写入，按 LOG_MAX_SIZE_MB / LOG_ROTATE_INTERVAL 轮转）
+	rotateConfig := loadRotateConfigFromEnv()
 	if logFile := os.Getenv("LOG_FILE"); logFile != "" {
-		if file, err := os.OpenFile(logFile, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644); err == nil {
+		if file, err := OpenRotatingFile(logFile, rotateConfig); err == nil {
 			logger.logFile = file
 			// 检查是否禁用控制台输出
 			if os.Getenv("LOG_CONSOLE") == "false" {
@@ -112,6 +119,15 @@ func createLogger() *Logger {
 		}
 	}
 
+	// 访问日志单独写入文件（可选）
+	if accessFile := os.Getenv("LOG_ACCESS_FILE"); accessFile != "" {
+		if file, err := OpenRotatingFile(accessFile, rotateConfig); err == nil {
+			logger.accessFile = file
+		} else {
+			fmt.Fprintf(os.Stderr, "无法打开访问日志文件 %s: %v\n", accessFile, err)
+		}
+	}
+
 	// 创建多写入器
 	multiWriter := io.MultiWriter(logger.writers...)
 	logger.logger = log.New(multiWriter, "", 0)
@@ -159,6 +175,9 @@ func (l *Logger) log(level Level, msg string, fields []Field) {
 	}
 
 	// 构建标准日志条目
+	if l.redact {
+		msg = Redact(msg)
+	}
 	entry := &LogEntry{
 		Timestamp: time.Now().Format("2006-01-02T15:04:05.000Z07:00"),
 		Level:     levelNames[level],
@@ -194,7 +213,8 @@ func (l *Logger) log(level Level, msg string, fields []Field) {
 			field.Key == "func" {
 			continue
 		}
-		entry.Fields[field.Key] = field.Value
+		// 统一脱敏并限制长度，调试日志可直接用于问题反馈
+		entry.Fields[field.Key] = l.sanitizeField(field.Key, field.Value)
 	}
 
 	// 使用自定义序列化确保字段顺序
@@ -275,6 +295,19 @@ func SetLevel(level Level) {
 	atomic.StoreInt64(&defaultLogger.level, int64(level))
 }
 
+// GetLevel 获取当前日志级别
+func GetLevel() Level {
+	return Level(atomic.LoadInt64(&defaultLogger.level))
+}
+
+// String 返回级别名称
+func (l Level) String() string {
+	if name, ok := levelNames[l]; ok {
+		return name
+	}
+	return "UNKNOWN"
+}
+
 // 全局日志函数
 func Debug(msg string, fields ...Field) {
 	defaultLogger.log(DEBUG, msg, fields)
@@ -337,9 +370,44 @@ func Reinitialize() {
 	if defaultLogger.logFile != nil {
 		defaultLogger.logFile.Close()
 	}
+	if defaultLogger.accessFile != nil {
+		defaultLogger.accessFile.Close()
+	}
 	defaultLogger = createLogger()
 }
 
+// AccessWriter 返回访问日志的输出目标
+// 配置了 LOG_ACCESS_FILE 时写入独立文件，否则输出到控制台
+func AccessWriter() io.Writer {
+	if defaultLogger.accessFile != nil {
+		return defaultLogger.accessFile
+	}
+	return os.Stdout
+}
+
+// Reopen 重新打开日志文件（外部 logrotate 移走文件后调用）
+func Reopen() error {
+	for _, file := range []*RotatingFile{defaultLogger.logFile, defaultLogger.accessFile} {
+		if file == nil {
+			continue
+		}
+		if err := file.Reopen(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// envInt 读取整数类型环境变量，无效时返回默认值
+func envInt(key string, defaultValue int) int {
+	if v := os.Getenv(key); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n >= 0 {
+			return n
+		}
+	}
+	return defaultValue
+}
+
 // OptimizationConfig 优化配置结构（新增）
 type OptimizationConfig struct {
 	EnableCaller bool `json:"enable_caller"`
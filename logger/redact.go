@@ -0,0 +1,207 @@
+package logger
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"unicode/utf8"
+
+	"github.com/bytedance/sonic"
+)
+
+// RedactedPlaceholder 脱敏后的占位文本
+const RedactedPlaceholder = "[REDACTED]"
+
+const (
+	// defaultMaxFieldBytes 单个日志字段的默认长度上限（字节）
+	defaultMaxFieldBytes = 8192
+	// minSecretLength 登记的字面量密钥最短长度，过短的值容易误伤普通文本
+	minSecretLength = 8
+	// maxRegisteredSecrets 登记的字面量密钥数量上限（超出时淘汰最早登记的）
+	maxRegisteredSecrets = 4096
+)
+
+// 内置脱敏规则
+var (
+	// 键名包含 token/secret/password/authorization/api_key 的 JSON 字符串值
+	redactJSONSecretPattern = regexp.MustCompile(`(?i)("[a-z0-9_\-]*(?:token|secret|password|passwd|authorization|api[_\-]?key|cookie)[a-z0-9_\-]*"\s*:\s*)"(?:[^"\\]|\\.)*"`)
+	// Authorization: Bearer xxx
+	redactBearerPattern = regexp.MustCompile(`(?i)\bbearer\s+[a-z0-9._~+/=\-]{8,}`)
+	// 常见密钥格式：Anthropic/OpenAI key、AWS Access Key、JWT（含 IdC clientSecret）、Kiro access/refresh token
+	redactKeyPatterns = []*regexp.Regexp{
+		regexp.MustCompile(`\bsk-[A-Za-z0-9_\-]{16,}`),
+		regexp.MustCompile(`\bAKIA[0-9A-Z]{16}\b`),
+		regexp.MustCompile(`\beyJ[A-Za-z0-9_\-]{8,}\.[A-Za-z0-9_\-]{8,}\.[A-Za-z0-9_\-]{8,}`),
+		regexp.MustCompile(`\bao[ar][A-Za-z0-9_\-+/=:]{16,}`),
+	}
+	// 邮箱地址，保留首字符和顶级域名
+	redactEmailPattern = regexp.MustCompile(`([A-Za-z0-9])[A-Za-z0-9._%+\-]*@[A-Za-z0-9.\-]+\.([A-Za-z]{2,})\b`)
+	// 长 base64 数据（图片等），替换为长度说明
+	redactBase64Pattern = regexp.MustCompile(`[A-Za-z0-9+/]{512,}={0,2}`)
+)
+
+// sensitiveFieldKeys 值需要整体隐藏的日志字段名（小写并去掉 _ 和 -）
+var sensitiveFieldKeys = map[string]bool{
+	"accesstoken":   true,
+	"refreshtoken":  true,
+	"idtoken":       true,
+	"sessiontoken":  true,
+	"clientsecret":  true,
+	"secret":        true,
+	"password":      true,
+	"authorization": true,
+	"apikey":        true,
+	"xapikey":       true,
+	"cookie":        true,
+}
+
+var (
+	// customPatterns 通过 LOG_REDACT_PATTERNS 或 AddRedactPattern 追加的规则
+	customPatterns atomic.Pointer[[]*regexp.Regexp]
+
+	// 登记的字面量密钥（客户端令牌等无固定格式的值）
+	secretsMu       sync.Mutex
+	secretsList     []string
+	secretsSet      = make(map[string]bool)
+	secretsReplacer atomic.Pointer[strings.Replacer]
+)
+
+// RegisterSecret 登记需要在日志中隐藏的字面量密钥
+// 用于客户端API密钥等没有固定格式、无法用规则识别的值
+func RegisterSecret(secret string) {
+	if len(secret) < minSecretLength {
+		return
+	}
+
+	secretsMu.Lock()
+	defer secretsMu.Unlock()
+	if secretsSet[secret] {
+		return
+	}
+	secretsSet[secret] = true
+	secretsList = append(secretsList, secret)
+	if len(secretsList) > maxRegisteredSecrets {
+		delete(secretsSet, secretsList[0])
+		secretsList = secretsList[1:]
+	}
+
+	pairs := make([]string, 0, len(secretsList)*2)
+	for _, s := range secretsList {
+		pairs = append(pairs, s, RedactedPlaceholder)
+	}
+	secretsReplacer.Store(strings.NewReplacer(pairs...))
+}
+
+// AddRedactPattern 追加自定义脱敏规则，匹配内容替换为占位文本
+func AddRedactPattern(pattern string) error {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return fmt.Errorf("无效的脱敏规则 %q: %w", pattern, err)
+	}
+	var list []*regexp.Regexp
+	if current := customPatterns.Load(); current != nil {
+		list = append(list, *current...)
+	}
+	list = append(list, re)
+	customPatterns.Store(&list)
+	return nil
+}
+
+// loadRedactPatternsFromEnv 读取 LOG_REDACT_PATTERNS（多个规则用 ;; 分隔）
+func loadRedactPatternsFromEnv() {
+	empty := []*regexp.Regexp{}
+	customPatterns.Store(&empty)
+	for _, p := range strings.Split(os.Getenv("LOG_REDACT_PATTERNS"), ";;") {
+		if p = strings.TrimSpace(p); p == "" {
+			continue
+		}
+		if err := AddRedactPattern(p); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+		}
+	}
+}
+
+// Redact 对文本中的令牌、密钥、邮箱和大段 base64 数据脱敏
+// 日志输出、请求检查器等需要展示请求/响应内容的场景统一使用
+func Redact(s string) string {
+	if s == "" {
+		return s
+	}
+	if r := secretsReplacer.Load(); r != nil {
+		s = r.Replace(s)
+	}
+	s = redactJSONSecretPattern.ReplaceAllString(s, `${1}"`+RedactedPlaceholder+`"`)
+	s = redactBearerPattern.ReplaceAllString(s, "Bearer "+RedactedPlaceholder)
+	for _, pattern := range redactKeyPatterns {
+		s = pattern.ReplaceAllString(s, RedactedPlaceholder)
+	}
+	if custom := customPatterns.Load(); custom != nil {
+		for _, pattern := range *custom {
+			s = pattern.ReplaceAllString(s, RedactedPlaceholder)
+		}
+	}
+	s = redactEmailPattern.ReplaceAllString(s, "${1}***@***.${2}")
+	return redactBase64Pattern.ReplaceAllStringFunc(s, func(m string) string {
+		return fmt.Sprintf("[base64 %d bytes]", len(m))
+	})
+}
+
+// TruncatePayload 按字节上限截断文本（不拆分 UTF-8 字符），并注明原始长度
+// maxBytes <= 0 表示不限制
+func TruncatePayload(s string, maxBytes int) string {
+	if maxBytes <= 0 || len(s) <= maxBytes {
+		return s
+	}
+	cut := maxBytes
+	for cut > 0 && !utf8.RuneStart(s[cut]) {
+		cut--
+	}
+	return fmt.Sprintf("%s...[truncated, %d bytes total]", s[:cut], len(s))
+}
+
+// isSensitiveFieldKey 判断字段名是否表示密钥本身
+func isSensitiveFieldKey(key string) bool {
+	normalized := strings.NewReplacer("_", "", "-", "").Replace(strings.ToLower(key))
+	return sensitiveFieldKeys[normalized]
+}
+
+// sanitizeField 对单个日志字段脱敏并限制长度
+// 关闭脱敏（LOG_REDACT=false）时仍对字符串字段限制长度
+func (l *Logger) sanitizeField(key string, value any) any {
+	if !l.redact {
+		if v, ok := value.(string); ok {
+			return TruncatePayload(v, l.maxFieldBytes)
+		}
+		return value
+	}
+	if isSensitiveFieldKey(key) {
+		if value == nil {
+			return nil
+		}
+		return RedactedPlaceholder
+	}
+
+	switch v := value.(type) {
+	case nil, bool, int, int64, int32, uint, uint64, uint32, float64, float32:
+		return value
+	case string:
+		return TruncatePayload(Redact(v), l.maxFieldBytes)
+	case []byte:
+		return TruncatePayload(Redact(string(v)), l.maxFieldBytes)
+	default:
+		// 结构体、map 等先序列化再脱敏，超长时退化为截断的字符串
+		data, err := sonic.Marshal(v)
+		if err != nil {
+			return value
+		}
+		redacted := Redact(string(data))
+		if l.maxFieldBytes > 0 && len(redacted) > l.maxFieldBytes {
+			return TruncatePayload(redacted, l.maxFieldBytes)
+		}
+		return json.RawMessage(redacted)
+	}
+}
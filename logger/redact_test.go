@@ -0,0 +1,63 @@
+package logger
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// captureLogger 创建输出到缓冲区的 logger
+func captureLogger(buf *bytes.Buffer, maxFieldBytes int) *Logger {
+	return &Logger{
+		level:         int64(DEBUG),
+		logger:        log.New(buf, "", 0),
+		redact:        true,
+		maxFieldBytes: maxFieldBytes,
+	}
+}
+
+func TestRedact_TokensEmailsAndSecrets(t *testing.T) {
+	RegisterSecret("my-client-key-123")
+	RegisterSecret("short") // 过短的值不登记
+
+	out := Redact(`refresh=aorAAAAAGhlbG8td29ybGQtdG9rZW4 access=aoaAAAAAGhlbG8td29ybGQtdG9rZW4 key=my-client-key-123 user=alice.smith@example.com short`)
+	assert.NotContains(t, out, "aorAAAAA")
+	assert.NotContains(t, out, "aoaAAAAA")
+	assert.NotContains(t, out, "my-client-key-123")
+	assert.NotContains(t, out, "alice.smith")
+	assert.Contains(t, out, "a***@***.com")
+	assert.Contains(t, out, "short")
+}
+
+func TestRedact_CustomPatterns(t *testing.T) {
+	t.Setenv("LOG_REDACT_PATTERNS", `internal-\d{6};;[invalid`)
+	loadRedactPatternsFromEnv()
+	t.Cleanup(loadRedactPatternsFromEnv)
+
+	assert.Equal(t, "id="+RedactedPlaceholder, Redact("id=internal-123456"))
+}
+
+func TestLogger_SanitizesFields(t *testing.T) {
+	var buf bytes.Buffer
+	l := captureLogger(&buf, 64)
+
+	l.log(DEBUG, "调试", []Field{
+		String("refresh_token", "plain-value"),
+		String("request_body", `{"clientSecret":"abc","content":"`+strings.Repeat("x", 100)+`"}`),
+		Any("usage", map[string]any{"userInfo": map[string]string{"email": "bob@example.org"}}),
+		Int("count", 3),
+	})
+
+	var entry map[string]any
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &entry))
+	assert.Equal(t, RedactedPlaceholder, entry["refresh_token"])
+	assert.NotContains(t, entry["request_body"], `"abc"`)
+	assert.Contains(t, entry["request_body"], "truncated")
+	assert.Equal(t, map[string]any{"userInfo": map[string]any{"email": "b***@***.org"}}, entry["usage"])
+	assert.Equal(t, float64(3), entry["count"])
+}
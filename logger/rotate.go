@@ -0,0 +1,299 @@
+package logger
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// backupTimeFormat 轮转文件名中的时间戳格式（文件名中不能包含冒号）
+const backupTimeFormat = "20060102T150405.000"
+
+// RotateConfig 日志文件轮转配置
+type RotateConfig struct {
+	// MaxSizeMB 单个文件的最大大小（MB），0 表示不按大小轮转
+	MaxSizeMB int
+	// Interval 按时间轮转的周期（如 24h，按 UTC 对齐），0 表示不按时间轮转
+	Interval time.Duration
+	// MaxBackups 保留的轮转文件数量，0 表示不限制
+	MaxBackups int
+	// MaxAgeDays 轮转文件的最长保留天数，0 表示不限制
+	MaxAgeDays int
+	// Compress 是否 gzip 压缩轮转后的文件
+	Compress bool
+}
+
+// loadRotateConfigFromEnv 从环境变量读取轮转配置
+// 默认 100MB 轮转、保留 7 个文件并压缩，避免日志写满磁盘
+func loadRotateConfigFromEnv() RotateConfig {
+	cfg := RotateConfig{
+		MaxSizeMB:  envInt("LOG_MAX_SIZE_MB", 100),
+		MaxBackups: envInt("LOG_MAX_BACKUPS", 7),
+		MaxAgeDays: envInt("LOG_MAX_AGE_DAYS", 0),
+		Compress:   os.Getenv("LOG_COMPRESS") != "false",
+	}
+	if v := os.Getenv("LOG_ROTATE_INTERVAL"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d > 0 {
+			cfg.Interval = d
+		}
+	}
+	return cfg
+}
+
+// RotatingFile 支持按大小/时间轮转的日志文件
+// 轮转后的文件命名为 <name>-<时间戳><ext>，压缩和过期清理在后台执行
+type RotatingFile struct {
+	mu       sync.Mutex
+	path     string
+	cfg      RotateConfig
+	file     *os.File
+	size     int64
+	rotateAt time.Time // 下一次按时间轮转的时刻
+
+	millMu sync.Mutex     // 串行化压缩和清理
+	millWG sync.WaitGroup // Close 时等待后台任务结束
+	now    func() time.Time
+}
+
+// OpenRotatingFile 以追加模式打开日志文件
+func OpenRotatingFile(path string, cfg RotateConfig) (*RotatingFile, error) {
+	r := &RotatingFile{path: path, cfg: cfg, now: time.Now}
+	if err := r.openLocked(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Write 写入日志，必要时先轮转
+func (r *RotatingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.file == nil {
+		if err := r.openLocked(); err != nil {
+			return 0, err
+		}
+	}
+	if r.shouldRotateLocked(int64(len(p))) {
+		if err := r.rotateLocked(); err != nil {
+			// 轮转失败时继续写入原文件，避免丢日志
+			fmt.Fprintf(os.Stderr, "日志文件轮转失败 %s: %v\n", r.path, err)
+		}
+	}
+
+	n, err := r.file.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+// Rotate 立即轮转当前文件
+func (r *RotatingFile) Rotate() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.rotateLocked()
+}
+
+// Reopen 关闭并按原路径重新打开文件（配合外部 logrotate 的 SIGHUP 使用）
+func (r *RotatingFile) Reopen() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.file != nil {
+		r.file.Close()
+		r.file = nil
+	}
+	return r.openLocked()
+}
+
+// Close 关闭文件并等待后台压缩和清理结束
+func (r *RotatingFile) Close() error {
+	r.mu.Lock()
+	var err error
+	if r.file != nil {
+		err = r.file.Close()
+		r.file = nil
+	}
+	r.mu.Unlock()
+	r.millWG.Wait()
+	return err
+}
+
+// openLocked 打开（或创建）日志文件，调用者必须持有 r.mu
+func (r *RotatingFile) openLocked() error {
+	if dir := filepath.Dir(r.path); dir != "" && dir != "." {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("创建日志目录失败: %w", err)
+		}
+	}
+	file, err := os.OpenFile(r.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	r.file = file
+	r.size = info.Size()
+	if r.cfg.Interval > 0 {
+		r.rotateAt = r.now().Truncate(r.cfg.Interval).Add(r.cfg.Interval)
+	}
+	return nil
+}
+
+// shouldRotateLocked 判断写入 n 字节前是否需要轮转
+func (r *RotatingFile) shouldRotateLocked(n int64) bool {
+	if r.size == 0 {
+		return false
+	}
+	if r.cfg.MaxSizeMB > 0 && r.size+n > int64(r.cfg.MaxSizeMB)*1024*1024 {
+		return true
+	}
+	return r.cfg.Interval > 0 && !r.now().Before(r.rotateAt)
+}
+
+// rotateLocked 重命名当前文件并打开新文件，调用者必须持有 r.mu
+func (r *RotatingFile) rotateLocked() error {
+	if r.file != nil {
+		if err := r.file.Close(); err != nil {
+			return err
+		}
+		r.file = nil
+	}
+
+	if _, err := os.Stat(r.path); err == nil {
+		if err := os.Rename(r.path, r.backupName(r.now())); err != nil {
+			// 重命名失败时重新打开原文件继续写入
+			if openErr := r.openLocked(); openErr != nil {
+				return openErr
+			}
+			return err
+		}
+	}
+	if err := r.openLocked(); err != nil {
+		return err
+	}
+
+	r.millWG.Add(1)
+	go func() {
+		defer r.millWG.Done()
+		r.mill()
+	}()
+	return nil
+}
+
+// backupName 生成轮转文件名
+func (r *RotatingFile) backupName(t time.Time) string {
+	dir := filepath.Dir(r.path)
+	ext := filepath.Ext(r.path)
+	name := strings.TrimSuffix(filepath.Base(r.path), ext)
+	return filepath.Join(dir, name+"-"+t.UTC().Format(backupTimeFormat)+ext)
+}
+
+// logBackup 已轮转的日志文件
+type logBackup struct {
+	path string
+	time time.Time
+}
+
+// backups 列出已轮转的文件（按时间从新到旧）
+func (r *RotatingFile) backups() ([]logBackup, error) {
+	dir := filepath.Dir(r.path)
+	ext := filepath.Ext(r.path)
+	prefix := strings.TrimSuffix(filepath.Base(r.path), ext) + "-"
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var list []logBackup
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		stamp := strings.TrimSuffix(strings.TrimSuffix(name, ".gz"), ext)
+		t, err := time.Parse(backupTimeFormat, strings.TrimPrefix(stamp, prefix))
+		if err != nil {
+			continue
+		}
+		list = append(list, logBackup{path: filepath.Join(dir, name), time: t})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].time.After(list[j].time) })
+	return list, nil
+}
+
+// mill 清理超出数量或过期的轮转文件，并压缩其余未压缩的文件
+func (r *RotatingFile) mill() {
+	r.millMu.Lock()
+	defer r.millMu.Unlock()
+
+	list, err := r.backups()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "列出日志轮转文件失败: %v\n", err)
+		return
+	}
+
+	var cutoff time.Time
+	if r.cfg.MaxAgeDays > 0 {
+		cutoff = r.now().Add(-time.Duration(r.cfg.MaxAgeDays) * 24 * time.Hour)
+	}
+
+	for i, b := range list {
+		if (r.cfg.MaxBackups > 0 && i >= r.cfg.MaxBackups) || (!cutoff.IsZero() && b.time.Before(cutoff)) {
+			if err := os.Remove(b.path); err != nil && !os.IsNotExist(err) {
+				fmt.Fprintf(os.Stderr, "删除过期日志文件失败 %s: %v\n", b.path, err)
+			}
+			continue
+		}
+		if r.cfg.Compress && !strings.HasSuffix(b.path, ".gz") {
+			if err := gzipFile(b.path); err != nil {
+				fmt.Fprintf(os.Stderr, "压缩日志文件失败 %s: %v\n", b.path, err)
+			}
+		}
+	}
+}
+
+// gzipFile 将文件压缩为 <path>.gz 并删除原文件
+func gzipFile(path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	tmp := path + ".gz.tmp"
+	dst, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+
+	gz := gzip.NewWriter(dst)
+	if _, err := io.Copy(gz, src); err != nil {
+		gz.Close()
+		dst.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		dst.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, path+".gz"); err != nil {
+		return err
+	}
+	src.Close()
+	return os.Remove(path)
+}
@@ -0,0 +1,104 @@
+package logger
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRotatingFile_SizeRotationRetentionAndCompress(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "app.log")
+	now := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+
+	r, err := OpenRotatingFile(path, RotateConfig{MaxSizeMB: 1, MaxBackups: 2, Compress: true})
+	require.NoError(t, err)
+	r.now = func() time.Time { return now }
+
+	line := strings.Repeat("x", 600*1024) + "\n"
+	for i := 0; i < 4; i++ {
+		_, err := r.Write([]byte(line))
+		require.NoError(t, err)
+		now = now.Add(time.Second)
+	}
+	require.NoError(t, r.Close())
+
+	backups, err := r.backups()
+	require.NoError(t, err)
+	require.Len(t, backups, 2, "超出 MaxBackups 的旧文件被删除")
+	for _, b := range backups {
+		assert.True(t, strings.HasSuffix(b.path, ".log.gz"), b.path)
+	}
+
+	f, err := os.Open(backups[0].path)
+	require.NoError(t, err)
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	require.NoError(t, err)
+	data, err := io.ReadAll(gz)
+	require.NoError(t, err)
+	assert.Equal(t, line, string(data))
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(line)), info.Size())
+}
+
+func TestRotatingFile_IntervalAndAge(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "app.log")
+	now := time.Date(2026, 3, 1, 23, 59, 0, 0, time.UTC)
+
+	r, err := OpenRotatingFile(path, RotateConfig{Interval: 24 * time.Hour, MaxAgeDays: 1})
+	require.NoError(t, err)
+	r.now = func() time.Time { return now }
+	r.rotateAt = now.Truncate(24 * time.Hour).Add(24 * time.Hour)
+
+	// 过期的旧文件在下一次轮转后被清理
+	stale := r.backupName(now.Add(-72 * time.Hour))
+	require.NoError(t, os.WriteFile(stale, []byte("old\n"), 0644))
+
+	_, err = r.Write([]byte("day1\n"))
+	require.NoError(t, err)
+	now = now.Add(2 * time.Minute)
+	_, err = r.Write([]byte("day2\n"))
+	require.NoError(t, err)
+	require.NoError(t, r.Close())
+
+	backups, err := r.backups()
+	require.NoError(t, err)
+	require.Len(t, backups, 1)
+	data, err := os.ReadFile(backups[0].path)
+	require.NoError(t, err)
+	assert.Equal(t, "day1\n", string(data))
+	_, err = os.Stat(stale)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestRotatingFile_Reopen(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "app.log")
+
+	r, err := OpenRotatingFile(path, RotateConfig{})
+	require.NoError(t, err)
+	_, err = r.Write([]byte("before\n"))
+	require.NoError(t, err)
+
+	// 模拟外部 logrotate 移走文件
+	require.NoError(t, os.Rename(path, path+".1"))
+	require.NoError(t, r.Reopen())
+	_, err = r.Write([]byte("after\n"))
+	require.NoError(t, err)
+	require.NoError(t, r.Close())
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "after\n", string(data))
+}
@@ -0,0 +1,23 @@
+package logger
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// WatchReopenSignal 收到 SIGHUP 时重新打开日志文件
+// 配合外部 logrotate（copytruncate 以外的模式）使用，轮转后无需重启服务
+func WatchReopenSignal() {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGHUP)
+	go func() {
+		for range ch {
+			if err := Reopen(); err != nil {
+				Error("重新打开日志文件失败", Err(err))
+				continue
+			}
+			Info("已重新打开日志文件")
+		}
+	}()
+}
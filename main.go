@@ -1,61 +1,63 @@
 package main
 
 import (
+	"fmt"
 	"os"
-
-	"kiro2api/auth"
-	"kiro2api/logger"
-	"kiro2api/server"
-
-	"github.com/joho/godotenv"
+	"strconv"
 )
 
-func main() {
-	// 自动加载.env文件
-	if err := godotenv.Load(); err != nil {
-		logger.Info("未找到.env文件，使用环境变量")
-	}
+// usage 命令行帮助
+const usage = `kiro2api - Kiro 到 Anthropic/OpenAI API 的代理
+
+用法:
+  kiro2api [serve] [端口]                       启动服务（默认命令）
+  kiro2api accounts list [-json]                列出账号
+  kiro2api accounts add -refresh-token TOKEN [-auth Social|IdC -client-id ID -client-secret SECRET]
+  kiro2api accounts remove <序号>               删除账号
+  kiro2api accounts refresh [序号]              刷新账号访问令牌（不指定序号时刷新全部）
+  kiro2api accounts usage [序号] [-json]        查询账号剩余额度
+  kiro2api keys create [-name 名称] [-token 令牌] 创建客户端API密钥
+  kiro2api keys list [-json]                    列出客户端API密钥
+  kiro2api keys revoke <序号>                   吊销客户端API密钥
+  kiro2api import-sso [目录] [-dry-run]         从 Kiro IDE 的 SSO 缓存导入账号（默认 ~/.aws/sso/cache）
+  kiro2api convert -in request.json [-format anthropic|openai]
+                                                打印转换后的 CodeWhisperer 请求
+  kiro2api config check [-config 路径]          查看生效配置并校验
+
+除 serve 外的命令直接读写与服务相同的配置文件（auth_config.json、client_tokens.json 等），无需启动服务。
+运行中的服务不会感知命令行的修改，修改账号或密钥后请重启服务或通过管理面板操作。
+`
 
-	// 重新初始化logger以使用.env文件中的配置
-	logger.Reinitialize()
-
-	// 显示当前日志级别设置（仅在DEBUG级别时显示详细信息）
-	// 注意：移除重复的系统字段，这些信息已包含在日志结构中
-	logger.Debug("日志系统初始化完成",
-		logger.String("config_level", os.Getenv("LOG_LEVEL")),
-		logger.String("config_file", os.Getenv("LOG_FILE")))
-
-	// 🚀 创建AuthService实例（使用依赖注入）
-	logger.Info("正在创建AuthService...")
-	authService, err := auth.NewAuthService()
-	if err != nil {
-		logger.Error("AuthService创建失败", logger.Err(err))
-		logger.Error("请检查token配置后重新启动服务器")
-		os.Exit(1)
-	}
-
-	port := "8080" // 默认端口
-	if len(os.Args) > 1 {
-		port = os.Args[1]
-	}
-	// 从环境变量获取端口，覆盖命令行参数
-	if envPort := os.Getenv("PORT"); envPort != "" {
-		port = envPort
-	}
-
-	// 创建客户端令牌管理器（支持多令牌）
-	logger.Info("正在创建ClientTokenManager...")
-	clientTokenManager, err := auth.NewClientTokenManager()
-	if err != nil {
-		logger.Error("ClientTokenManager创建失败", logger.Err(err))
-		os.Exit(1)
+func main() {
+	args := os.Args[1:]
+
+	// 兼容旧用法：kiro2api [端口]
+	command := "serve"
+	if len(args) > 0 {
+		if _, err := strconv.Atoi(args[0]); err != nil {
+			command, args = args[0], args[1:]
+		}
 	}
 
-	// 检查是否有可用的客户端令牌
-	if !clientTokenManager.HasTokens() {
-		logger.Warn("未配置任何客户端令牌，API 端点将无法访问")
-		logger.Warn("请通过 Dashboard 添加客户端令牌，或在 client_tokens.json 中配置")
+	var code int
+	switch command {
+	case "serve":
+		runServe(args)
+	case "accounts":
+		code = runAccountsCommand(args, os.Stdout, os.Stderr)
+	case "keys":
+		code = runKeysCommand(args, os.Stdout, os.Stderr)
+	case "import-sso":
+		code = runImportSSOCommand(args, os.Stdout, os.Stderr)
+	case "convert":
+		code = runConvertCommand(args, os.Stdout, os.Stderr)
+	case "config":
+		code = runConfigCommand(args, os.Stdout, os.Stderr)
+	case "help", "-h", "-help", "--help":
+		fmt.Print(usage)
+	default:
+		fmt.Fprintf(os.Stderr, "未知命令: %s\n\n%s", command, usage)
+		code = 2
 	}
-
-	server.StartServer(port, clientTokenManager, authService)
+	os.Exit(code)
 }
@@ -0,0 +1,415 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// 轻量级 Prometheus 指标实现（文本暴露格式 0.0.4），仅依赖标准库
+// 设计原则：
+// - KISS: 只实现 counter / gauge / histogram 三种类型
+// - 各业务包直接引用本包的全局指标，本包不依赖任何业务包，避免循环引用
+
+// ContentType Prometheus 文本格式的 Content-Type
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// labelSeparator 拼接标签值作为 map key（不会出现在正常标签值中）
+const labelSeparator = "\xff"
+
+// Collector 可注册到 Registry 的指标
+type Collector interface {
+	Name() string
+	writeTo(w *bufio.Writer)
+}
+
+// Registry 指标注册表
+type Registry struct {
+	mu         sync.RWMutex
+	collectors map[string]Collector
+}
+
+// NewRegistry 创建指标注册表
+func NewRegistry() *Registry {
+	return &Registry{collectors: make(map[string]Collector)}
+}
+
+// Register 注册指标，名称重复时返回错误
+func (r *Registry) Register(c Collector) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, exists := r.collectors[c.Name()]; exists {
+		return fmt.Errorf("指标已注册: %s", c.Name())
+	}
+	r.collectors[c.Name()] = c
+	return nil
+}
+
+// MustRegister 注册指标，失败时 panic（用于包初始化）
+func (r *Registry) MustRegister(cs ...Collector) {
+	for _, c := range cs {
+		if err := r.Register(c); err != nil {
+			panic(err)
+		}
+	}
+}
+
+// Unregister 移除指标
+func (r *Registry) Unregister(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.collectors, name)
+}
+
+// WriteText 以 Prometheus 文本格式输出全部指标（按名称排序）
+func (r *Registry) WriteText(w io.Writer) error {
+	r.mu.RLock()
+	names := make([]string, 0, len(r.collectors))
+	for name := range r.collectors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	collectors := make([]Collector, 0, len(names))
+	for _, name := range names {
+		collectors = append(collectors, r.collectors[name])
+	}
+	r.mu.RUnlock()
+
+	bw := bufio.NewWriter(w)
+	for _, c := range collectors {
+		c.writeTo(bw)
+	}
+	return bw.Flush()
+}
+
+// Handler 返回暴露指标的 HTTP handler
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", ContentType)
+		r.WriteText(w)
+	})
+}
+
+// desc 指标元信息
+type desc struct {
+	name       string
+	help       string
+	metricType string
+	labelNames []string
+}
+
+func (d *desc) Name() string { return d.name }
+
+func (d *desc) writeHeader(w *bufio.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n", d.name, escapeHelp(d.help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", d.name, d.metricType)
+}
+
+// key 校验标签数量并生成 map key
+func (d *desc) key(labelValues []string) string {
+	if len(labelValues) != len(d.labelNames) {
+		panic(fmt.Sprintf("指标 %s 需要 %d 个标签值，实际 %d 个", d.name, len(d.labelNames), len(labelValues)))
+	}
+	return strings.Join(labelValues, labelSeparator)
+}
+
+// formatLabels 生成 {a="x",b="y"} 形式的标签串，extra 追加在末尾（如 histogram 的 le）
+func (d *desc) formatLabels(labelValues []string, extra ...string) string {
+	if len(d.labelNames) == 0 && len(extra) == 0 {
+		return ""
+	}
+	var sb strings.Builder
+	sb.WriteByte('{')
+	for i, name := range d.labelNames {
+		if i > 0 {
+			sb.WriteByte(',')
+		}
+		sb.WriteString(name)
+		sb.WriteString(`="`)
+		sb.WriteString(escapeLabelValue(labelValues[i]))
+		sb.WriteByte('"')
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		if sb.Len() > 1 {
+			sb.WriteByte(',')
+		}
+		sb.WriteString(extra[i])
+		sb.WriteString(`="`)
+		sb.WriteString(escapeLabelValue(extra[i+1]))
+		sb.WriteByte('"')
+	}
+	sb.WriteByte('}')
+	return sb.String()
+}
+
+// ==================== Counter / Gauge ====================
+
+// valueSeries 单个标签组合的数值
+type valueSeries struct {
+	labelValues []string
+	value       float64
+}
+
+// valueVec counter 与 gauge 的共用实现
+type valueVec struct {
+	desc
+	mu     sync.Mutex
+	series map[string]*valueSeries
+}
+
+func newValueVec(name, help, metricType string, labelNames []string) valueVec {
+	return valueVec{
+		desc:   desc{name: name, help: help, metricType: metricType, labelNames: labelNames},
+		series: make(map[string]*valueSeries),
+	}
+}
+
+func (v *valueVec) update(labelValues []string, fn func(float64) float64) {
+	key := v.key(labelValues)
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	s, ok := v.series[key]
+	if !ok {
+		s = &valueSeries{labelValues: append([]string(nil), labelValues...)}
+		v.series[key] = s
+	}
+	s.value = fn(s.value)
+}
+
+// Value 读取指定标签组合的当前值（不存在返回0）
+func (v *valueVec) Value(labelValues ...string) float64 {
+	key := v.key(labelValues)
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if s, ok := v.series[key]; ok {
+		return s.value
+	}
+	return 0
+}
+
+func (v *valueVec) writeTo(w *bufio.Writer) {
+	v.mu.Lock()
+	keys := make([]string, 0, len(v.series))
+	for k := range v.series {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	lines := make([]string, 0, len(keys))
+	for _, k := range keys {
+		s := v.series[k]
+		lines = append(lines, v.name+v.formatLabels(s.labelValues)+" "+formatFloat(s.value))
+	}
+	v.mu.Unlock()
+
+	v.writeHeader(w)
+	for _, line := range lines {
+		w.WriteString(line)
+		w.WriteByte('\n')
+	}
+}
+
+// CounterVec 单调递增计数器
+type CounterVec struct {
+	valueVec
+}
+
+// NewCounterVec 创建计数器
+func NewCounterVec(name, help string, labelNames ...string) *CounterVec {
+	return &CounterVec{valueVec: newValueVec(name, help, "counter", labelNames)}
+}
+
+// Inc 计数加一
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add 计数增加 delta（负数会被忽略）
+func (c *CounterVec) Add(delta float64, labelValues ...string) {
+	if delta < 0 {
+		return
+	}
+	c.update(labelValues, func(v float64) float64 { return v + delta })
+}
+
+// GaugeVec 可增可减的仪表
+type GaugeVec struct {
+	valueVec
+}
+
+// NewGaugeVec 创建仪表
+func NewGaugeVec(name, help string, labelNames ...string) *GaugeVec {
+	return &GaugeVec{valueVec: newValueVec(name, help, "gauge", labelNames)}
+}
+
+// Set 设置当前值
+func (g *GaugeVec) Set(value float64, labelValues ...string) {
+	g.update(labelValues, func(float64) float64 { return value })
+}
+
+// Add 增加 delta（可为负）
+func (g *GaugeVec) Add(delta float64, labelValues ...string) {
+	g.update(labelValues, func(v float64) float64 { return v + delta })
+}
+
+// ==================== GaugeFunc ====================
+
+// Sample 采集时计算的单个样本
+type Sample struct {
+	LabelValues []string
+	Value       float64
+}
+
+// GaugeFunc 抓取时才计算值的仪表（适合从现有状态派生的指标，如账号池）
+type GaugeFunc struct {
+	desc
+	collect func() []Sample
+}
+
+// NewGaugeFunc 创建采集时计算的仪表
+func NewGaugeFunc(name, help string, labelNames []string, collect func() []Sample) *GaugeFunc {
+	return &GaugeFunc{
+		desc:    desc{name: name, help: help, metricType: "gauge", labelNames: labelNames},
+		collect: collect,
+	}
+}
+
+func (g *GaugeFunc) writeTo(w *bufio.Writer) {
+	samples := g.collect()
+	sort.Slice(samples, func(i, j int) bool {
+		return strings.Join(samples[i].LabelValues, labelSeparator) < strings.Join(samples[j].LabelValues, labelSeparator)
+	})
+
+	g.writeHeader(w)
+	for _, s := range samples {
+		if len(s.LabelValues) != len(g.labelNames) {
+			continue
+		}
+		w.WriteString(g.name + g.formatLabels(s.LabelValues) + " " + formatFloat(s.Value) + "\n")
+	}
+}
+
+// ==================== Histogram ====================
+
+// DefaultLatencyBuckets 上游请求延迟的默认分桶（秒）
+var DefaultLatencyBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+
+// histogramSeries 单个标签组合的直方图数据
+type histogramSeries struct {
+	labelValues []string
+	counts      []uint64 // 每个桶的非累计计数
+	sum         float64
+	count       uint64
+}
+
+// HistogramVec 直方图
+type HistogramVec struct {
+	desc
+	buckets []float64
+	mu      sync.Mutex
+	series  map[string]*histogramSeries
+}
+
+// NewHistogramVec 创建直方图，buckets 为升序上界（不含 +Inf）
+func NewHistogramVec(name, help string, buckets []float64, labelNames ...string) *HistogramVec {
+	sorted := append([]float64(nil), buckets...)
+	sort.Float64s(sorted)
+	return &HistogramVec{
+		desc:    desc{name: name, help: help, metricType: "histogram", labelNames: labelNames},
+		buckets: sorted,
+		series:  make(map[string]*histogramSeries),
+	}
+}
+
+// Observe 记录一次观测值
+func (h *HistogramVec) Observe(value float64, labelValues ...string) {
+	key := h.key(labelValues)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s, ok := h.series[key]
+	if !ok {
+		s = &histogramSeries{
+			labelValues: append([]string(nil), labelValues...),
+			counts:      make([]uint64, len(h.buckets)),
+		}
+		h.series[key] = s
+	}
+	for i, upper := range h.buckets {
+		if value <= upper {
+			s.counts[i]++
+			break
+		}
+	}
+	s.sum += value
+	s.count++
+}
+
+// Count 读取指定标签组合的观测次数
+func (h *HistogramVec) Count(labelValues ...string) uint64 {
+	key := h.key(labelValues)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if s, ok := h.series[key]; ok {
+		return s.count
+	}
+	return 0
+}
+
+func (h *HistogramVec) writeTo(w *bufio.Writer) {
+	h.mu.Lock()
+	keys := make([]string, 0, len(h.series))
+	for k := range h.series {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var lines []string
+	for _, k := range keys {
+		s := h.series[k]
+		var cumulative uint64
+		for i, upper := range h.buckets {
+			cumulative += s.counts[i]
+			lines = append(lines, h.name+"_bucket"+h.formatLabels(s.labelValues, "le", formatFloat(upper))+" "+strconv.FormatUint(cumulative, 10))
+		}
+		lines = append(lines,
+			h.name+"_bucket"+h.formatLabels(s.labelValues, "le", "+Inf")+" "+strconv.FormatUint(s.count, 10),
+			h.name+"_sum"+h.formatLabels(s.labelValues)+" "+formatFloat(s.sum),
+			h.name+"_count"+h.formatLabels(s.labelValues)+" "+strconv.FormatUint(s.count, 10))
+	}
+	h.mu.Unlock()
+
+	h.writeHeader(w)
+	for _, line := range lines {
+		w.WriteString(line)
+		w.WriteByte('\n')
+	}
+}
+
+// ==================== 格式化 ====================
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func escapeHelp(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	return strings.ReplaceAll(s, "\n", `\n`)
+}
+
+func escapeLabelValue(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, `"`, `\"`)
+	return strings.ReplaceAll(s, "\n", `\n`)
+}
@@ -0,0 +1,66 @@
+package metrics
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegistry_WriteText(t *testing.T) {
+	r := NewRegistry()
+	requests := NewCounterVec("test_requests_total", "Requests.\nSecond line", "route", "status")
+	inflight := NewGaugeVec("test_inflight", "In-flight requests.")
+	pool := NewGaugeFunc("test_pool", "Pool size.", []string{"state"}, func() []Sample {
+		return []Sample{{LabelValues: []string{"usable"}, Value: 2}, {LabelValues: []string{"exhausted"}, Value: 1}}
+	})
+	r.MustRegister(requests, inflight, pool)
+	assert.Error(t, r.Register(NewCounterVec("test_requests_total", "dup")))
+
+	requests.Inc("/v1/messages", "200")
+	requests.Add(2, "/v1/messages", "200")
+	requests.Add(-5, "/v1/messages", "200") // counter 不允许减少
+	requests.Inc(`/a"b`, "500")
+	inflight.Add(3)
+	inflight.Add(-1)
+
+	var buf bytes.Buffer
+	require.NoError(t, r.WriteText(&buf))
+	out := buf.String()
+
+	assert.Contains(t, out, "# HELP test_requests_total Requests.\\nSecond line\n")
+	assert.Contains(t, out, "# TYPE test_requests_total counter\n")
+	assert.Contains(t, out, `test_requests_total{route="/v1/messages",status="200"} 3`+"\n")
+	assert.Contains(t, out, `test_requests_total{route="/a\"b",status="500"} 1`+"\n")
+	assert.Contains(t, out, "test_inflight 2\n")
+	assert.Contains(t, out, `test_pool{state="exhausted"} 1`+"\n")
+	// 按指标名排序输出
+	assert.Less(t, bytes.Index(buf.Bytes(), []byte("test_inflight")), bytes.Index(buf.Bytes(), []byte("test_pool")))
+
+	assert.Panics(t, func() { requests.Inc("only-one-label") })
+}
+
+func TestHistogramVec_CumulativeBuckets(t *testing.T) {
+	h := NewHistogramVec("test_latency_seconds", "Latency.", []float64{1, 0.1}, "model")
+	h.Observe(0.05, "m")
+	h.Observe(0.5, "m")
+	h.Observe(5, "m")
+
+	r := NewRegistry()
+	r.MustRegister(h)
+
+	w := httptest.NewRecorder()
+	r.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	out := w.Body.String()
+
+	assert.Equal(t, ContentType, w.Header().Get("Content-Type"))
+	assert.Contains(t, out, `test_latency_seconds_bucket{model="m",le="0.1"} 1`)
+	assert.Contains(t, out, `test_latency_seconds_bucket{model="m",le="1"} 2`)
+	assert.Contains(t, out, `test_latency_seconds_bucket{model="m",le="+Inf"} 3`)
+	assert.Contains(t, out, `test_latency_seconds_sum{model="m"} 5.55`)
+	assert.Contains(t, out, `test_latency_seconds_count{model="m"} 3`)
+	assert.Equal(t, uint64(3), h.Count("m"))
+}
@@ -0,0 +1,70 @@
+package metrics
+
+// Default 全局默认注册表，/metrics 端点输出该注册表的内容
+var Default = NewRegistry()
+
+// 请求链路指标
+// 标签约定：
+// - route: gin 路由模板（如 /v1/messages），未匹配路由统一为 unmatched
+// - model: 客户端请求的模型名，未解析到时为空
+// - client: 客户端令牌名称（未命名时为脱敏预览），未认证请求为空
+var (
+	// RequestsTotal 按路由、模型、客户端令牌和状态码统计的请求数
+	RequestsTotal = NewCounterVec("kiro2api_requests_total",
+		"Total HTTP requests by route, model, client token and status code.",
+		"route", "model", "client", "status")
+
+	// RequestDuration 请求处理总耗时（含流式响应）
+	RequestDuration = NewHistogramVec("kiro2api_request_duration_seconds",
+		"HTTP request duration in seconds, including streamed responses.",
+		DefaultLatencyBuckets, "route")
+
+	// UpstreamLatency 上游 CodeWhisperer 请求到收到响应头的耗时
+	UpstreamLatency = NewHistogramVec("kiro2api_upstream_latency_seconds",
+		"Latency until upstream response headers are received, in seconds.",
+		DefaultLatencyBuckets, "model", "status")
+
+	// UpstreamTTFB 上游请求到读取到第一个响应体字节的耗时
+	UpstreamTTFB = NewHistogramVec("kiro2api_upstream_ttfb_seconds",
+		"Time from sending the upstream request to the first response body byte, in seconds.",
+		DefaultLatencyBuckets, "model")
+
+	// StreamDuration 流式响应从开始到结束的耗时
+	StreamDuration = NewHistogramVec("kiro2api_stream_duration_seconds",
+		"Duration of streamed responses in seconds.",
+		[]float64{1, 2.5, 5, 10, 20, 30, 60, 120, 300, 600}, "api", "model")
+
+	// EstimatedTokens 本地估算的输入/输出 token 数
+	EstimatedTokens = NewCounterVec("kiro2api_estimated_tokens_total",
+		"Locally estimated tokens by model, client token and direction (input/output).",
+		"model", "client", "direction")
+
+	// TokenRefreshTotal 账号 token 刷新结果
+	TokenRefreshTotal = NewCounterVec("kiro2api_token_refresh_total",
+		"Account token refresh attempts by auth type and result (success/failure).",
+		"auth_type", "result")
+
+	// SSEStateViolations SSEStateManager 检测到的事件序列违规
+	SSEStateViolations = NewCounterVec("kiro2api_sse_state_violations_total",
+		"SSE event sequence violations detected by the state manager.",
+		"event", "rule")
+
+	// ParserErrors RobustEventStreamParser 的解析错误
+	ParserErrors = NewCounterVec("kiro2api_parser_errors_total",
+		"EventStream parser errors by kind.",
+		"kind")
+)
+
+func init() {
+	Default.MustRegister(
+		RequestsTotal,
+		RequestDuration,
+		UpstreamLatency,
+		UpstreamTTFB,
+		StreamDuration,
+		EstimatedTokens,
+		TokenRefreshTotal,
+		SSEStateViolations,
+		ParserErrors,
+	)
+}
@@ -7,6 +7,7 @@ import (
 	"hash/crc32"
 	"kiro2api/config"
 	"kiro2api/logger"
+	"kiro2api/metrics"
 	"strings"
 	"sync"
 )
@@ -26,7 +27,7 @@ type RobustEventStreamParser struct {
 func NewRobustEventStreamParser() *RobustEventStreamParser {
 	return &RobustEventStreamParser{
 		headerParser: NewHeaderParser(),
-		maxErrors:    config.ParserMaxErrors,
+		maxErrors:    config.GetParserMaxErrors(),
 		crcTable:     crc32.MakeTable(crc32.IEEE),
 		buffer:       &bytes.Buffer{},
 	}
@@ -374,6 +375,7 @@ func (rp *RobustEventStreamParser) parseStreamWithBuffer(data []byte) ([]*EventS
 			// 跳过无效数据（丢弃1字节）
 			rp.buffer.Next(1)
 			rp.errorCount++
+			metrics.ParserErrors.Inc("invalid_length")
 			logger.Warn("跳过无效消息头",
 				logger.Int("total_length", int(totalLength)))
 			continue
@@ -393,6 +395,7 @@ func (rp *RobustEventStreamParser) parseStreamWithBuffer(data []byte) ([]*EventS
 				logger.Int("expected", int(totalLength)),
 				logger.Int("actual", n),
 				logger.Err(err))
+			metrics.ParserErrors.Inc("read_failure")
 			break
 		}
 
@@ -401,6 +404,7 @@ func (rp *RobustEventStreamParser) parseStreamWithBuffer(data []byte) ([]*EventS
 		if err != nil {
 			logger.Warn("消息解析失败", logger.Err(err))
 			rp.errorCount++
+			metrics.ParserErrors.Inc("invalid_message")
 			continue
 		}
 
@@ -411,6 +415,7 @@ func (rp *RobustEventStreamParser) parseStreamWithBuffer(data []byte) ([]*EventS
 
 	// 检查错误计数
 	if rp.errorCount >= rp.maxErrors {
+		metrics.ParserErrors.Inc("too_many_errors")
 		return messages, fmt.Errorf("错误次数过多 (%d)，停止解析", rp.errorCount)
 	}
 
@@ -0,0 +1,381 @@
+package server
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"kiro2api/logger"
+	"kiro2api/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// adminAPIKeyPrefix 管理API密钥前缀，用于和客户端令牌区分
+	adminAPIKeyPrefix = "kak_"
+	// defaultAdminAPIKeyFile 默认管理API密钥存储文件
+	defaultAdminAPIKeyFile = "admin_api_keys.json"
+	// defaultAdminAPIKeyTTL 未指定有效期时的默认有效期
+	defaultAdminAPIKeyTTL = 90 * 24 * time.Hour
+	// maxAdminAPIKeyTTL 最长有效期
+	maxAdminAPIKeyTTL = 365 * 24 * time.Hour
+	// adminAPIKeyTouchInterval 最后使用时间的持久化间隔
+	adminAPIKeyTouchInterval = time.Minute
+
+	// adminAPIKeyIDKey context 中保存当前请求所用的密钥ID
+	adminAPIKeyIDKey = "admin_api_key_id"
+)
+
+// 管理API密钥权限范围
+// <资源>:read 允许 GET 请求，<资源>:write 允许所有请求；admin 允许所有资源
+const (
+	AdminScopeAll = "admin"
+)
+
+// adminAPIKeyResources API密钥可访问的资源（/api/ 后的第一段路径）
+// 会话、两步验证和密钥管理本身只允许登录会话访问，防止权限提升
+var adminAPIKeyResources = []string{"tokens", "client-tokens", "audit", "inspector", "usage", "settings", "debug"}
+
+// AdminAPIKey 管理API密钥（仅保存哈希，明文只在创建时返回一次）
+type AdminAPIKey struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"` // 明文前缀，便于识别
+	Hash       string     `json:"hash"`
+	Scopes     []string   `json:"scopes"`
+	CreatedBy  string     `json:"createdBy"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	ExpiresAt  time.Time  `json:"expiresAt"`
+	LastUsedAt *time.Time `json:"lastUsedAt,omitempty"`
+}
+
+// AdminAPIKeyInfo 返回给前端的密钥信息（不含哈希）
+type AdminAPIKeyInfo struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Scopes     []string   `json:"scopes"`
+	CreatedBy  string     `json:"createdBy"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	ExpiresAt  time.Time  `json:"expiresAt"`
+	LastUsedAt *time.Time `json:"lastUsedAt,omitempty"`
+	Expired    bool       `json:"expired"`
+}
+
+// AdminAPIKeyManager 管理API密钥管理器
+type AdminAPIKeyManager struct {
+	mu   sync.Mutex
+	path string
+	keys []AdminAPIKey
+}
+
+// NewAdminAPIKeyManager 创建管理API密钥管理器，并加载已有密钥
+func NewAdminAPIKeyManager(path string) (*AdminAPIKeyManager, error) {
+	if path == "" {
+		path = defaultAdminAPIKeyFile
+	}
+
+	m := &AdminAPIKeyManager{
+		path: path,
+		keys: make([]AdminAPIKey, 0),
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return m, nil
+		}
+		return nil, fmt.Errorf("读取管理API密钥失败: %w", err)
+	}
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &m.keys); err != nil {
+			return nil, fmt.Errorf("解析管理API密钥失败: %w", err)
+		}
+	}
+	return m, nil
+}
+
+// Create 创建新的管理API密钥，返回密钥信息和明文密钥
+func (m *AdminAPIKeyManager) Create(name string, scopes []string, ttl time.Duration, createdBy string) (AdminAPIKeyInfo, string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return AdminAPIKeyInfo{}, "", fmt.Errorf("名称不能为空")
+	}
+	if err := validateAdminScopes(scopes); err != nil {
+		return AdminAPIKeyInfo{}, "", err
+	}
+	if ttl <= 0 {
+		ttl = defaultAdminAPIKeyTTL
+	}
+	if ttl > maxAdminAPIKeyTTL {
+		return AdminAPIKeyInfo{}, "", fmt.Errorf("有效期不能超过 %d 天", int(maxAdminAPIKeyTTL.Hours()/24))
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return AdminAPIKeyInfo{}, "", fmt.Errorf("生成密钥失败: %w", err)
+	}
+	idBytes := make([]byte, 6)
+	if _, err := rand.Read(idBytes); err != nil {
+		return AdminAPIKeyInfo{}, "", fmt.Errorf("生成密钥ID失败: %w", err)
+	}
+
+	plaintext := adminAPIKeyPrefix + hex.EncodeToString(secret)
+	now := time.Now()
+	key := AdminAPIKey{
+		ID:        "ak_" + hex.EncodeToString(idBytes),
+		Name:      name,
+		Prefix:    plaintext[:len(adminAPIKeyPrefix)+8],
+		Hash:      hashAdminAPIKey(plaintext),
+		Scopes:    append([]string{}, scopes...),
+		CreatedBy: createdBy,
+		CreatedAt: now,
+		ExpiresAt: now.Add(ttl),
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.keys = append(m.keys, key)
+	if err := m.saveLocked(); err != nil {
+		m.keys = m.keys[:len(m.keys)-1]
+		return AdminAPIKeyInfo{}, "", err
+	}
+	return key.info(now), plaintext, nil
+}
+
+// Delete 删除（吊销）指定ID的密钥
+func (m *AdminAPIKeyManager) Delete(id string) (AdminAPIKeyInfo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, key := range m.keys {
+		if key.ID != id {
+			continue
+		}
+		prev := m.keys
+		m.keys = append(append([]AdminAPIKey{}, prev[:i]...), prev[i+1:]...)
+		if err := m.saveLocked(); err != nil {
+			m.keys = prev
+			return AdminAPIKeyInfo{}, err
+		}
+		return key.info(time.Now()), nil
+	}
+	return AdminAPIKeyInfo{}, fmt.Errorf("密钥不存在: %s", id)
+}
+
+// List 列出所有密钥（按创建时间倒序）
+func (m *AdminAPIKeyManager) List() []AdminAPIKeyInfo {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	result := make([]AdminAPIKeyInfo, 0, len(m.keys))
+	for _, key := range m.keys {
+		result = append(result, key.info(now))
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].CreatedAt.After(result[j].CreatedAt)
+	})
+	return result
+}
+
+// Authenticate 校验明文密钥，成功时返回密钥信息并更新最后使用时间
+func (m *AdminAPIKeyManager) Authenticate(plaintext string) (AdminAPIKeyInfo, error) {
+	hash := hashAdminAPIKey(plaintext)
+	now := time.Now()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i := range m.keys {
+		key := &m.keys[i]
+		if subtle.ConstantTimeCompare([]byte(key.Hash), []byte(hash)) != 1 {
+			continue
+		}
+		if !now.Before(key.ExpiresAt) {
+			return AdminAPIKeyInfo{}, fmt.Errorf("API密钥已过期")
+		}
+
+		// 限制写盘频率，最后使用时间允许有少量延迟
+		if key.LastUsedAt == nil || now.Sub(*key.LastUsedAt) > adminAPIKeyTouchInterval {
+			key.LastUsedAt = &now
+			if err := m.saveLocked(); err != nil {
+				logger.Warn("保存管理API密钥使用时间失败", logger.Err(err))
+			}
+		}
+		return key.info(now), nil
+	}
+	return AdminAPIKeyInfo{}, fmt.Errorf("API密钥无效")
+}
+
+// saveLocked 持久化到文件（调用时需持有锁）
+func (m *AdminAPIKeyManager) saveLocked() error {
+	data, err := json.MarshalIndent(m.keys, "", "  ")
+	if err != nil {
+		return fmt.Errorf("序列化管理API密钥失败: %w", err)
+	}
+	if err := utils.WriteFileAtomic(m.path, data); err != nil {
+		return fmt.Errorf("保存管理API密钥失败: %w", err)
+	}
+	return nil
+}
+
+// info 转换为对外展示的信息
+func (k AdminAPIKey) info(now time.Time) AdminAPIKeyInfo {
+	return AdminAPIKeyInfo{
+		ID:         k.ID,
+		Name:       k.Name,
+		Prefix:     k.Prefix,
+		Scopes:     k.Scopes,
+		CreatedBy:  k.CreatedBy,
+		CreatedAt:  k.CreatedAt,
+		ExpiresAt:  k.ExpiresAt,
+		LastUsedAt: k.LastUsedAt,
+		Expired:    !now.Before(k.ExpiresAt),
+	}
+}
+
+// AllowsRequest 判断密钥权限是否允许访问指定方法和路径
+func (k AdminAPIKeyInfo) AllowsRequest(method, path string) bool {
+	resource := adminAPIResource(path)
+	if !isAdminAPIKeyResource(resource) {
+		return false
+	}
+
+	write := resource + ":write"
+	read := resource + ":read"
+	for _, scope := range k.Scopes {
+		switch scope {
+		case AdminScopeAll, write:
+			return true
+		case read:
+			if method == http.MethodGet || method == http.MethodHead {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Role 返回密钥访问指定路径时等同的会话角色：只有读权限时视为只读角色
+func (k AdminAPIKeyInfo) Role(path string) string {
+	resource := adminAPIResource(path)
+	for _, scope := range k.Scopes {
+		if scope == AdminScopeAll || scope == resource+":write" {
+			return RoleAdmin
+		}
+	}
+	return RoleViewer
+}
+
+// adminAPIResource 提取 /api/ 后的第一段路径作为资源名
+func adminAPIResource(path string) string {
+	rest := strings.TrimPrefix(path, "/api/")
+	if i := strings.IndexByte(rest, '/'); i >= 0 {
+		rest = rest[:i]
+	}
+	return rest
+}
+
+// isAdminAPIKeyResource 判断资源是否允许API密钥访问
+func isAdminAPIKeyResource(resource string) bool {
+	for _, r := range adminAPIKeyResources {
+		if r == resource {
+			return true
+		}
+	}
+	return false
+}
+
+// validateAdminScopes 校验权限范围列表
+func validateAdminScopes(scopes []string) error {
+	if len(scopes) == 0 {
+		return fmt.Errorf("至少需要一个权限范围")
+	}
+	for _, scope := range scopes {
+		if scope == AdminScopeAll {
+			continue
+		}
+		resource, action, ok := strings.Cut(scope, ":")
+		if !ok || !isAdminAPIKeyResource(resource) || (action != "read" && action != "write") {
+			return fmt.Errorf("无效的权限范围: %s", scope)
+		}
+	}
+	return nil
+}
+
+// hashAdminAPIKey 计算密钥哈希
+func hashAdminAPIKey(plaintext string) string {
+	sum := sha256.Sum256([]byte(plaintext))
+	return hex.EncodeToString(sum[:])
+}
+
+// AdminAPIKeyMiddleware 使用 Authorization: Bearer 管理API密钥认证 /api/* 请求
+// 认证成功后请求被视为已登录（跳过 CSRF 校验），失败时直接拒绝，不再回退到会话认证
+func AdminAPIKeyMiddleware(manager *AdminAPIKeyManager) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		path := c.Request.URL.Path
+		if !strings.HasPrefix(path, "/api/") {
+			c.Next()
+			return
+		}
+
+		authHeader := c.GetHeader("Authorization")
+		token, ok := strings.CutPrefix(authHeader, "Bearer ")
+		if !ok || !strings.HasPrefix(token, adminAPIKeyPrefix) {
+			c.Next()
+			return
+		}
+
+		key, err := manager.Authenticate(strings.TrimSpace(token))
+		if err != nil {
+			logger.Warn("管理API密钥认证失败",
+				logger.String("path", path),
+				logger.String("ip", c.ClientIP()),
+				logger.Err(err))
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+				"success": false,
+				"error":   err.Error(),
+			})
+			return
+		}
+
+		if !key.AllowsRequest(c.Request.Method, path) {
+			logger.Warn("管理API密钥权限不足",
+				logger.String("key_id", key.ID),
+				logger.String("method", c.Request.Method),
+				logger.String("path", path))
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+				"success": false,
+				"error":   "API密钥无权访问该接口",
+			})
+			return
+		}
+
+		c.Set(sessionUserKey, "apikey:"+key.Name)
+		c.Set(sessionRoleKey, key.Role(path))
+		c.Set(adminAPIKeyIDKey, key.ID)
+		c.Next()
+	}
+}
+
+// GetAdminAPIKeyID 获取当前请求所用的管理API密钥ID（会话请求返回空）
+func GetAdminAPIKeyID(c *gin.Context) string {
+	if id, exists := c.Get(adminAPIKeyIDKey); exists {
+		if s, ok := id.(string); ok {
+			return s
+		}
+	}
+	return ""
+}
@@ -0,0 +1,124 @@
+package server
+
+import (
+	"net/http"
+	"time"
+
+	"kiro2api/logger"
+
+	"github.com/gin-gonic/gin"
+)
+
+// CreateAdminAPIKeyRequest 创建管理API密钥的请求结构
+type CreateAdminAPIKeyRequest struct {
+	Name          string   `json:"name"`
+	Scopes        []string `json:"scopes"`
+	ExpiresInDays int      `json:"expires_in_days"` // 0 表示使用默认有效期
+}
+
+// registerAdminAPIKeyRoutes 注册管理API密钥路由（仅允许登录会话访问）
+func registerAdminAPIKeyRoutes(r *gin.Engine, manager *AdminAPIKeyManager, auditLog *AuditLog) {
+	group := r.Group("/api/admin-keys")
+	group.Use(AdminAPIAuthGuard())
+
+	// 列出所有密钥
+	group.GET("", func(c *gin.Context) {
+		keys := manager.List()
+		c.JSON(http.StatusOK, gin.H{
+			"success": true,
+			"keys":    keys,
+			"total":   len(keys),
+			"scopes":  availableAdminScopes(),
+		})
+	})
+
+	// 创建密钥
+	group.POST("", func(c *gin.Context) {
+		handleCreateAdminAPIKey(c, manager, auditLog)
+	})
+
+	// 吊销密钥
+	group.DELETE("/:id", func(c *gin.Context) {
+		handleDeleteAdminAPIKey(c, manager, auditLog)
+	})
+}
+
+// handleCreateAdminAPIKey 创建管理API密钥，明文密钥只在响应中返回一次
+func handleCreateAdminAPIKey(c *gin.Context, manager *AdminAPIKeyManager, auditLog *AuditLog) {
+	var req CreateAdminAPIKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   "请求格式错误: " + err.Error(),
+		})
+		return
+	}
+
+	ttl := time.Duration(req.ExpiresInDays) * 24 * time.Hour
+	info, plaintext, err := manager.Create(req.Name, req.Scopes, ttl, GetSessionUser(c))
+	if err != nil {
+		auditLog.Record(c, AuditActionAdminKeyCreate, "admin_key:"+req.Name, nil, nil, err)
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+	auditLog.Record(c, AuditActionAdminKeyCreate, "admin_key:"+info.ID, nil, adminAPIKeyAuditState(info), nil)
+
+	logger.Info("已创建管理API密钥",
+		logger.String("key_id", info.ID),
+		logger.String("name", info.Name),
+		logger.String("created_by", info.CreatedBy))
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "密钥已创建，请立即保存，之后将无法再次查看",
+		"key":     info,
+		"secret":  plaintext,
+	})
+}
+
+// handleDeleteAdminAPIKey 吊销管理API密钥
+func handleDeleteAdminAPIKey(c *gin.Context, manager *AdminAPIKeyManager, auditLog *AuditLog) {
+	id := c.Param("id")
+
+	info, err := manager.Delete(id)
+	if err != nil {
+		auditLog.Record(c, AuditActionAdminKeyDelete, "admin_key:"+id, nil, nil, err)
+		c.JSON(http.StatusNotFound, gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+	auditLog.Record(c, AuditActionAdminKeyDelete, "admin_key:"+id, adminAPIKeyAuditState(info), nil, nil)
+
+	logger.Info("已吊销管理API密钥",
+		logger.String("key_id", id),
+		logger.String("operator", GetSessionUser(c)))
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "密钥已吊销",
+	})
+}
+
+// adminAPIKeyAuditState 生成密钥的审计快照（不含密钥内容）
+func adminAPIKeyAuditState(info AdminAPIKeyInfo) map[string]any {
+	return map[string]any{
+		"name":       info.Name,
+		"prefix":     info.Prefix,
+		"scopes":     info.Scopes,
+		"expires_at": info.ExpiresAt.Format(time.RFC3339),
+	}
+}
+
+// availableAdminScopes 返回可选的权限范围列表
+func availableAdminScopes() []string {
+	scopes := []string{AdminScopeAll}
+	for _, resource := range adminAPIKeyResources {
+		scopes = append(scopes, resource+":read", resource+":write")
+	}
+	return scopes
+}
@@ -0,0 +1,138 @@
+package server
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAdminAPIKeyManager_CreateAuthenticateDelete(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.json")
+	m, err := NewAdminAPIKeyManager(path)
+	require.NoError(t, err)
+
+	_, _, err = m.Create("ci", []string{"tokens:delete"}, 0, "admin")
+	assert.Error(t, err, "非法权限范围应被拒绝")
+	_, _, err = m.Create("ci", []string{"sessions:write"}, 0, "admin")
+	assert.Error(t, err, "会话管理不允许API密钥访问")
+	_, _, err = m.Create("ci", []string{"tokens:write"}, 400*24*time.Hour, "admin")
+	assert.Error(t, err)
+
+	info, secret, err := m.Create("ci", []string{"tokens:write"}, 0, "admin")
+	require.NoError(t, err)
+	assert.Contains(t, secret, adminAPIKeyPrefix)
+	assert.WithinDuration(t, time.Now().Add(defaultAdminAPIKeyTTL), info.ExpiresAt, time.Minute)
+
+	// 文件中只保存哈希
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), secret)
+
+	reloaded, err := NewAdminAPIKeyManager(path)
+	require.NoError(t, err)
+	got, err := reloaded.Authenticate(secret)
+	require.NoError(t, err)
+	assert.Equal(t, info.ID, got.ID)
+
+	_, err = reloaded.Authenticate(secret + "x")
+	assert.Error(t, err)
+
+	_, err = reloaded.Delete(info.ID)
+	require.NoError(t, err)
+	_, err = reloaded.Authenticate(secret)
+	assert.Error(t, err)
+}
+
+func TestAdminAPIKeyManager_Expired(t *testing.T) {
+	m, err := NewAdminAPIKeyManager(filepath.Join(t.TempDir(), "keys.json"))
+	require.NoError(t, err)
+
+	_, secret, err := m.Create("old", []string{AdminScopeAll}, time.Hour, "admin")
+	require.NoError(t, err)
+	m.keys[0].ExpiresAt = time.Now().Add(-time.Second)
+
+	_, err = m.Authenticate(secret)
+	assert.Error(t, err)
+	assert.True(t, m.List()[0].Expired)
+}
+
+func TestAdminAPIKeyInfo_AllowsRequest(t *testing.T) {
+	readOnly := AdminAPIKeyInfo{Scopes: []string{"client-tokens:read"}}
+	assert.True(t, readOnly.AllowsRequest(http.MethodGet, "/api/client-tokens"))
+	assert.False(t, readOnly.AllowsRequest(http.MethodPost, "/api/client-tokens"))
+	assert.False(t, readOnly.AllowsRequest(http.MethodGet, "/api/tokens"))
+
+	writer := AdminAPIKeyInfo{Scopes: []string{"tokens:write"}}
+	assert.True(t, writer.AllowsRequest(http.MethodDelete, "/api/tokens/1"))
+	assert.True(t, writer.AllowsRequest(http.MethodGet, "/api/tokens"))
+
+	all := AdminAPIKeyInfo{Scopes: []string{AdminScopeAll}}
+	assert.True(t, all.AllowsRequest(http.MethodGet, "/api/audit/export"))
+	assert.False(t, all.AllowsRequest(http.MethodPost, "/api/admin-keys"), "密钥不能管理密钥")
+	assert.False(t, all.AllowsRequest(http.MethodGet, "/api/sessions"))
+}
+
+func TestAdminAPIKeyInfo_Role(t *testing.T) {
+	readOnly := AdminAPIKeyInfo{Scopes: []string{"audit:read", "tokens:write"}}
+	assert.Equal(t, RoleViewer, readOnly.Role("/api/audit/export"))
+	assert.Equal(t, RoleAdmin, readOnly.Role("/api/tokens"))
+
+	all := AdminAPIKeyInfo{Scopes: []string{AdminScopeAll}}
+	assert.Equal(t, RoleAdmin, all.Role("/api/debug/captures/abc"))
+}
+
+func TestAdminAPIKeyMiddleware_BypassesCSRFAndAudits(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	t.Chdir(t.TempDir())
+
+	keys, err := NewAdminAPIKeyManager("keys.json")
+	require.NoError(t, err)
+	_, secret, err := keys.Create("ci", []string{"client-tokens:write"}, 0, "admin")
+	require.NoError(t, err)
+	_, readSecret, err := keys.Create("reader", []string{"client-tokens:read"}, 0, "admin")
+	require.NoError(t, err)
+
+	auditLog, err := NewAuditLog("audit.jsonl")
+	require.NoError(t, err)
+
+	r := gin.New()
+	r.Use(SessionMiddleware(NewSessionManager(time.Hour, time.Hour)))
+	r.Use(AdminAPIKeyMiddleware(keys))
+	r.Use(CSRFMiddleware(false))
+	registerClientTokenRoutes(r, createTestClientTokenManager(), auditLog, true)
+
+	post := func(bearer string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/api/client-tokens",
+			bytes.NewBufferString(`{"token":"automation-token-123456","name":"ci"}`))
+		req.Header.Set("Content-Type", "application/json")
+		if bearer != "" {
+			req.Header.Set("Authorization", "Bearer "+bearer)
+		}
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	// 无凭据时被 CSRF 拦截
+	assert.Equal(t, http.StatusForbidden, post("").Code)
+	// 无效密钥
+	assert.Equal(t, http.StatusUnauthorized, post(adminAPIKeyPrefix+"bogus").Code)
+	// 只读密钥无写权限
+	assert.Equal(t, http.StatusForbidden, post(readSecret).Code)
+	// 有效密钥无需 CSRF token
+	w := post(secret)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	entries := auditLog.Query(AuditFilter{AuthMethod: AuditAuthAPIKey})
+	require.Len(t, entries, 1)
+	assert.Equal(t, "apikey:ci", entries[0].User)
+	assert.NotEmpty(t, entries[0].APIKeyID)
+}
@@ -0,0 +1,519 @@
+package server
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"kiro2api/auth"
+	"kiro2api/logger"
+	"kiro2api/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 审计动作常量
+const (
+	AuditActionTokenAdd           = "token.add"
+	AuditActionTokenDelete        = "token.delete"
+	AuditActionTokenRefresh       = "token.refresh"
+	AuditActionTokenRefreshAll    = "token.refresh_all"
+	AuditActionClientTokenAdd     = "client_token.add"
+	AuditActionClientTokenDelete  = "client_token.delete"
+	AuditActionClientTokenToggle  = "client_token.toggle"
+	AuditActionTOTPEnable         = "auth.totp_enable"
+	AuditActionTOTPDisable        = "auth.totp_disable"
+	AuditActionTOTPRecoveryReset  = "auth.totp_recovery_reset"
+	AuditActionSessionRevoke      = "auth.session_revoke"
+	AuditActionAdminKeyCreate     = "admin_key.create"
+	AuditActionAdminKeyDelete     = "admin_key.delete"
+	AuditActionSettingsUpdate     = "settings.update"
+	AuditActionClientTokenDebug   = "client_token.debug_capture"
+	AuditActionDebugCaptureDelete = "debug_capture.delete"
+)
+
+// 审计条目的认证方式
+const (
+	AuditAuthSession = "session"
+	AuditAuthAPIKey  = "api_key"
+)
+
+const (
+	// defaultAuditLogFile 默认审计日志文件（JSON Lines 格式，追加写入）
+	defaultAuditLogFile = "audit_log.jsonl"
+	// auditMemoryLimit 内存中保留的最近审计条目数量（用于查询）
+	auditMemoryLimit = 10000
+	// auditDefaultQueryLimit 查询接口默认返回条数
+	auditDefaultQueryLimit = 100
+)
+
+// AuditChange 单个字段的变更记录
+type AuditChange struct {
+	Field  string `json:"field"`
+	Before any    `json:"before,omitempty"`
+	After  any    `json:"after,omitempty"`
+}
+
+// AuditEntry 审计日志条目
+// Before/After 中的敏感字段在写入前已脱敏
+type AuditEntry struct {
+	ID        string    `json:"id"`
+	Timestamp time.Time `json:"timestamp"`
+	User      string    `json:"user"`
+	IP        string    `json:"ip"`
+	Action    string    `json:"action"`
+	Entity    string    `json:"entity"`
+	Success   bool      `json:"success"`
+	Message   string    `json:"message,omitempty"`
+	RequestID string    `json:"request_id,omitempty"`
+	// AuthMethod 为 api_key 时 APIKeyID 记录所用的管理API密钥
+	AuthMethod string         `json:"auth_method,omitempty"`
+	APIKeyID   string         `json:"api_key_id,omitempty"`
+	Before     map[string]any `json:"before,omitempty"`
+	After      map[string]any `json:"after,omitempty"`
+	Changes    []AuditChange  `json:"changes,omitempty"`
+}
+
+// AuditFilter 审计日志查询条件
+type AuditFilter struct {
+	Action     string
+	User       string
+	Entity     string
+	AuthMethod string
+	Since      time.Time
+	Until      time.Time
+	Limit      int
+}
+
+// AuditLog 持久化的审计日志
+// 所有条目追加写入 JSON Lines 文件，同时在内存中保留最近的条目用于查询
+type AuditLog struct {
+	mu      sync.RWMutex
+	path    string
+	entries []AuditEntry
+}
+
+// NewAuditLog 创建审计日志，并加载文件中已有的历史条目
+func NewAuditLog(path string) (*AuditLog, error) {
+	if path == "" {
+		path = defaultAuditLogFile
+	}
+
+	a := &AuditLog{
+		path:    path,
+		entries: make([]AuditEntry, 0),
+	}
+
+	if err := a.load(); err != nil {
+		return nil, err
+	}
+
+	logger.Info("审计日志已启用",
+		logger.String("file", path),
+		logger.Int("loaded_entries", len(a.entries)))
+	return a, nil
+}
+
+// load 从文件加载历史审计条目
+func (a *AuditLog) load() error {
+	file, err := os.Open(a.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil // 文件不存在不是错误
+		}
+		return fmt.Errorf("打开审计日志失败: %w", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+		var entry AuditEntry
+		if err := json.Unmarshal(line, &entry); err != nil {
+			logger.Warn("跳过无法解析的审计条目", logger.Err(err))
+			continue
+		}
+		a.appendLocked(entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("读取审计日志失败: %w", err)
+	}
+	return nil
+}
+
+// appendLocked 追加到内存（调用时需持有锁或处于初始化阶段）
+func (a *AuditLog) appendLocked(entry AuditEntry) {
+	a.entries = append(a.entries, entry)
+	if len(a.entries) > auditMemoryLimit {
+		a.entries = a.entries[len(a.entries)-auditMemoryLimit:]
+	}
+}
+
+// Append 写入一条审计记录（自动补全ID、时间戳和变更列表）
+func (a *AuditLog) Append(entry AuditEntry) error {
+	if entry.ID == "" {
+		entry.ID = "audit_" + utils.GenerateUUID()
+	}
+	if entry.Timestamp.IsZero() {
+		entry.Timestamp = time.Now()
+	}
+	if entry.Changes == nil {
+		entry.Changes = diffAuditState(entry.Before, entry.After)
+	}
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("序列化审计条目失败: %w", err)
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if err := utils.EnsureParentDir(a.path); err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(a.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return fmt.Errorf("打开审计日志失败: %w", err)
+	}
+	defer file.Close()
+
+	if _, err := file.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("写入审计日志失败: %w", err)
+	}
+
+	a.appendLocked(entry)
+	return nil
+}
+
+// Record 基于请求上下文记录一次管理操作
+// opErr 为操作结果，nil 表示成功
+func (a *AuditLog) Record(c *gin.Context, action, entity string, before, after map[string]any, opErr error) {
+	if a == nil {
+		return
+	}
+
+	user := GetSessionUser(c)
+	if user == "" {
+		user = "anonymous"
+	}
+
+	entry := AuditEntry{
+		User:      user,
+		IP:        c.ClientIP(),
+		Action:    action,
+		Entity:    entity,
+		Success:   opErr == nil,
+		RequestID: GetRequestID(c),
+		Before:    before,
+		After:     after,
+	}
+	if keyID := GetAdminAPIKeyID(c); keyID != "" {
+		entry.AuthMethod = AuditAuthAPIKey
+		entry.APIKeyID = keyID
+	} else if GetSessionUser(c) != "" {
+		entry.AuthMethod = AuditAuthSession
+	}
+	if opErr != nil {
+		entry.Message = opErr.Error()
+	}
+
+	if err := a.Append(entry); err != nil {
+		logger.Error("写入审计日志失败",
+			logger.String("action", action),
+			logger.String("entity", entity),
+			logger.Err(err))
+	}
+}
+
+// Query 按条件查询审计条目（按时间倒序）
+func (a *AuditLog) Query(filter AuditFilter) []AuditEntry {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	result := make([]AuditEntry, 0)
+	for i := len(a.entries) - 1; i >= 0; i-- {
+		entry := a.entries[i]
+		if !filter.matches(entry) {
+			continue
+		}
+		result = append(result, entry)
+		if filter.Limit > 0 && len(result) >= filter.Limit {
+			break
+		}
+	}
+	return result
+}
+
+// Count 返回内存中的审计条目数量
+func (a *AuditLog) Count() int {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return len(a.entries)
+}
+
+// matches 判断条目是否满足过滤条件
+func (f AuditFilter) matches(entry AuditEntry) bool {
+	if f.Action != "" && !strings.HasPrefix(entry.Action, f.Action) {
+		return false
+	}
+	if f.User != "" && entry.User != f.User {
+		return false
+	}
+	if f.Entity != "" && !strings.Contains(entry.Entity, f.Entity) {
+		return false
+	}
+	if f.AuthMethod != "" && entry.AuthMethod != f.AuthMethod {
+		return false
+	}
+	if !f.Since.IsZero() && entry.Timestamp.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && entry.Timestamp.After(f.Until) {
+		return false
+	}
+	return true
+}
+
+// diffAuditState 计算前后状态的字段级差异
+func diffAuditState(before, after map[string]any) []AuditChange {
+	keys := make(map[string]struct{}, len(before)+len(after))
+	for k := range before {
+		keys[k] = struct{}{}
+	}
+	for k := range after {
+		keys[k] = struct{}{}
+	}
+
+	sortedKeys := make([]string, 0, len(keys))
+	for k := range keys {
+		sortedKeys = append(sortedKeys, k)
+	}
+	sort.Strings(sortedKeys)
+
+	var changes []AuditChange
+	for _, k := range sortedKeys {
+		b, hasBefore := before[k]
+		af, hasAfter := after[k]
+		if hasBefore && hasAfter && fmt.Sprint(b) == fmt.Sprint(af) {
+			continue
+		}
+		changes = append(changes, AuditChange{Field: k, Before: b, After: af})
+	}
+	return changes
+}
+
+// auditAuthConfigState 生成账号配置的脱敏快照
+func auditAuthConfigState(index int, cfg auth.AuthConfig) map[string]any {
+	state := map[string]any{
+		"index":         index,
+		"auth":          cfg.AuthType,
+		"refresh_token": createTokenPreview(cfg.RefreshToken),
+		"disabled":      cfg.Disabled,
+	}
+	if cfg.ClientID != "" {
+		state["client_id"] = maskClientID(cfg.ClientID)
+	}
+	if cfg.ClientSecret != "" {
+		state["client_secret"] = "***"
+	}
+	return state
+}
+
+// auditClientTokenState 生成客户端令牌的脱敏快照
+func auditClientTokenState(index int, token, name string, disabled bool) map[string]any {
+	return map[string]any{
+		"index":    index,
+		"name":     name,
+		"token":    createTokenPreview(token),
+		"disabled": disabled,
+	}
+}
+
+// tokenAuditEntity 上游账号的审计实体，按账号ID（refreshToken 哈希）标识，删除或重排后仍指向同一账号
+func tokenAuditEntity(refreshToken string) string {
+	return "token:" + auth.AccountID(refreshToken)
+}
+
+// tokenAuditEntityAt 按序号获取上游账号的审计实体，序号无效时为 token:#序号
+func tokenAuditEntityAt(configs []auth.AuthConfig, index int) string {
+	if index < 0 || index >= len(configs) {
+		return fmt.Sprintf("token:#%d", index)
+	}
+	return tokenAuditEntity(configs[index].RefreshToken)
+}
+
+// clientTokenAuditEntity 客户端令牌的审计实体，按令牌哈希标识，删除或重排后仍指向同一令牌
+func clientTokenAuditEntity(token string) string {
+	return "client_token:" + auth.AccountID(token)
+}
+
+// clientTokenAuditEntityAt 按序号获取客户端令牌的审计实体，序号无效时为 client_token:#序号
+func clientTokenAuditEntityAt(manager *auth.ClientTokenManager, index int) string {
+	stats := manager.GetAllStats()
+	if index < 0 || index >= len(stats) {
+		return fmt.Sprintf("client_token:#%d", index)
+	}
+	return clientTokenAuditEntity(stats[index].Token)
+}
+
+// maskClientID 对IdC客户端ID脱敏（保留前5位和后3位）
+func maskClientID(clientID string) string {
+	if len(clientID) > 10 {
+		return clientID[:5] + "***" + clientID[len(clientID)-3:]
+	}
+	return clientID
+}
+
+// registerAuditRoutes 注册审计日志查询和导出路由
+func registerAuditRoutes(r *gin.Engine, auditLog *AuditLog, requireAuth bool) {
+	group := r.Group("/api/audit")
+	if requireAuth {
+		group.Use(AdminAPIAuthGuard())
+	}
+
+	group.GET("", func(c *gin.Context) {
+		handleQueryAuditLog(c, auditLog)
+	})
+
+	group.GET("/export", AdminOnlyGuard(), func(c *gin.Context) {
+		handleExportAuditLog(c, auditLog)
+	})
+}
+
+// parseAuditFilter 从查询参数解析过滤条件
+func parseAuditFilter(c *gin.Context, defaultLimit int) (AuditFilter, error) {
+	filter := AuditFilter{
+		Action:     c.Query("action"),
+		User:       c.Query("user"),
+		Entity:     c.Query("entity"),
+		AuthMethod: c.Query("auth_method"),
+		Limit:      defaultLimit,
+	}
+
+	if limitStr := c.Query("limit"); limitStr != "" {
+		limit, err := strconv.Atoi(limitStr)
+		if err != nil || limit < 0 {
+			return filter, fmt.Errorf("无效的 limit: %s", limitStr)
+		}
+		filter.Limit = limit
+	}
+	if since := c.Query("since"); since != "" {
+		t, err := time.Parse(time.RFC3339, since)
+		if err != nil {
+			return filter, fmt.Errorf("无效的 since（需要 RFC3339 格式）: %s", since)
+		}
+		filter.Since = t
+	}
+	if until := c.Query("until"); until != "" {
+		t, err := time.Parse(time.RFC3339, until)
+		if err != nil {
+			return filter, fmt.Errorf("无效的 until（需要 RFC3339 格式）: %s", until)
+		}
+		filter.Until = t
+	}
+	return filter, nil
+}
+
+// handleQueryAuditLog 查询审计日志
+func handleQueryAuditLog(c *gin.Context, auditLog *AuditLog) {
+	filter, err := parseAuditFilter(c, auditDefaultQueryLimit)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	entries := auditLog.Query(filter)
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"entries": entries,
+		"count":   len(entries),
+		"total":   auditLog.Count(),
+	})
+}
+
+// handleExportAuditLog 导出审计日志（format=json|csv，默认json）
+func handleExportAuditLog(c *gin.Context, auditLog *AuditLog) {
+	filter, err := parseAuditFilter(c, 0)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	entries := auditLog.Query(filter)
+	filename := "audit_" + time.Now().Format("20060102150405")
+
+	switch c.DefaultQuery("format", "json") {
+	case "csv":
+		c.Header("Content-Disposition", "attachment; filename="+filename+".csv")
+		c.Header("Content-Type", "text/csv; charset=utf-8")
+		c.Status(http.StatusOK)
+		if err := writeAuditCSV(c.Writer, entries); err != nil {
+			logger.Error("导出审计日志CSV失败", logger.Err(err))
+		}
+	case "json":
+		c.Header("Content-Disposition", "attachment; filename="+filename+".json")
+		c.JSON(http.StatusOK, entries)
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   "format 必须是 json 或 csv",
+		})
+		return
+	}
+
+	logger.Info("导出审计日志",
+		logger.String("user", GetSessionUser(c)),
+		logger.Int("count", len(entries)))
+}
+
+// writeAuditCSV 以CSV格式写出审计条目
+func writeAuditCSV(w interface{ Write([]byte) (int, error) }, entries []AuditEntry) error {
+	writer := csv.NewWriter(w)
+	header := []string{"id", "timestamp", "user", "ip", "action", "entity", "success", "message", "changes", "auth_method", "api_key_id"}
+	if err := writer.Write(header); err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		changes, _ := json.Marshal(entry.Changes)
+		record := []string{
+			entry.ID,
+			entry.Timestamp.Format(time.RFC3339),
+			entry.User,
+			entry.IP,
+			entry.Action,
+			entry.Entity,
+			strconv.FormatBool(entry.Success),
+			entry.Message,
+			string(changes),
+			entry.AuthMethod,
+			entry.APIKeyID,
+		}
+		if err := writer.Write(record); err != nil {
+			return err
+		}
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
@@ -0,0 +1,189 @@
+package server
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"kiro2api/auth"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestAuditLog(t *testing.T) (*AuditLog, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	auditLog, err := NewAuditLog(path)
+	require.NoError(t, err)
+	return auditLog, path
+}
+
+func TestAuditLog_AppendPersistsAndReloads(t *testing.T) {
+	auditLog, path := newTestAuditLog(t)
+
+	require.NoError(t, auditLog.Append(AuditEntry{User: "admin", Action: AuditActionTokenAdd, Entity: "token:0", Success: true}))
+	require.NoError(t, auditLog.Append(AuditEntry{User: "bob", Action: AuditActionClientTokenToggle, Entity: "client_token:1", Success: true}))
+
+	reloaded, err := NewAuditLog(path)
+	require.NoError(t, err)
+	assert.Equal(t, 2, reloaded.Count())
+
+	// 最新的条目排在最前
+	entries := reloaded.Query(AuditFilter{})
+	require.Len(t, entries, 2)
+	assert.Equal(t, "bob", entries[0].User)
+	assert.NotEmpty(t, entries[0].ID)
+	assert.False(t, entries[0].Timestamp.IsZero())
+}
+
+func TestAuditLog_QueryFilters(t *testing.T) {
+	auditLog, _ := newTestAuditLog(t)
+
+	require.NoError(t, auditLog.Append(AuditEntry{User: "admin", Action: AuditActionTokenAdd, Entity: "token:0"}))
+	require.NoError(t, auditLog.Append(AuditEntry{User: "admin", Action: AuditActionTokenDelete, Entity: "token:0"}))
+	require.NoError(t, auditLog.Append(AuditEntry{User: "bob", Action: AuditActionClientTokenAdd, Entity: "client_token:0"}))
+
+	assert.Len(t, auditLog.Query(AuditFilter{Action: "token."}), 2)
+	assert.Len(t, auditLog.Query(AuditFilter{User: "bob"}), 1)
+	assert.Len(t, auditLog.Query(AuditFilter{Entity: "client_token"}), 1)
+	assert.Len(t, auditLog.Query(AuditFilter{Limit: 1}), 1)
+}
+
+func TestAuditLog_RedactsSecretsInDiff(t *testing.T) {
+	cfg := auth.AuthConfig{
+		AuthType:     auth.AuthMethodIdC,
+		RefreshToken: "refresh-token-very-secret-value-1234567890",
+		ClientID:     "client-id-abcdefghijk",
+		ClientSecret: "super-secret",
+	}
+
+	state := auditAuthConfigState(0, cfg)
+	data, err := json.Marshal(state)
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), "very-secret")
+	assert.NotContains(t, string(data), "super-secret")
+	assert.NotContains(t, string(data), "abcdefghijk")
+
+	changes := diffAuditState(nil, state)
+	assert.NotEmpty(t, changes)
+
+	toggled := auditClientTokenState(0, "client-token-secret-abcdef", "dev", true)
+	changes = diffAuditState(auditClientTokenState(0, "client-token-secret-abcdef", "dev", false), toggled)
+	require.Len(t, changes, 1)
+	assert.Equal(t, "disabled", changes[0].Field)
+}
+
+func TestAuditRoutes_RecordsClientTokenChangesAndExportsCSV(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	// 客户端令牌会持久化到工作目录，切换到临时目录避免污染
+	t.Chdir(t.TempDir())
+	auditLog, _ := newTestAuditLog(t)
+	manager := createTestClientTokenManager()
+
+	r := gin.New()
+	registerClientTokenRoutes(r, manager, auditLog, false)
+	registerAuditRoutes(r, auditLog, false)
+
+	body := `{"token":"new-client-token-abcdef123456","name":"ci"}`
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/api/client-tokens", bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/json")
+	r.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/audit?action=client_token.", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.NotContains(t, w.Body.String(), "new-client-token-abcdef123456")
+
+	var resp struct {
+		Entries []AuditEntry `json:"entries"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	require.Len(t, resp.Entries, 1)
+	assert.Equal(t, AuditActionClientTokenAdd, resp.Entries[0].Action)
+	assert.Equal(t, "anonymous", resp.Entries[0].User)
+	assert.True(t, resp.Entries[0].Success)
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/audit/export?format=csv", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.True(t, strings.HasPrefix(w.Header().Get("Content-Type"), "text/csv"))
+
+	records, err := csv.NewReader(w.Body).ReadAll()
+	require.NoError(t, err)
+	require.Len(t, records, 2)
+	assert.Equal(t, "action", records[0][4])
+	assert.Equal(t, AuditActionClientTokenAdd, records[1][4])
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/audit/export?format=xml", nil))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestAuditRoutes_ExportRequiresAdmin(t *testing.T) {
+	r, get := newRoleTestRouter(t)
+	keys, err := NewAdminAPIKeyManager(filepath.Join(t.TempDir(), "keys.json"))
+	require.NoError(t, err)
+	_, readSecret, err := keys.Create("reader", []string{"audit:read"}, 0, "admin")
+	require.NoError(t, err)
+	_, writeSecret, err := keys.Create("auditor", []string{"audit:write"}, 0, "admin")
+	require.NoError(t, err)
+	auditLog, _ := newTestAuditLog(t)
+
+	r.Use(AdminAPIKeyMiddleware(keys))
+	registerAuditRoutes(r, auditLog, true)
+
+	// 只读角色可查询，不能导出
+	assert.Equal(t, http.StatusOK, get(RoleViewer, "/api/audit"))
+	assert.Equal(t, http.StatusForbidden, get(RoleViewer, "/api/audit/export"))
+	assert.Equal(t, http.StatusOK, get(RoleAdmin, "/api/audit/export"))
+
+	// 只读权限的密钥等同只读角色
+	getWithKey := func(secret, path string) int {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("Authorization", "Bearer "+secret)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+	assert.Equal(t, http.StatusOK, getWithKey(readSecret, "/api/audit"))
+	assert.Equal(t, http.StatusForbidden, getWithKey(readSecret, "/api/audit/export"))
+	assert.Equal(t, http.StatusOK, getWithKey(writeSecret, "/api/audit/export"))
+}
+
+func TestAuditRoutes_ClientTokenEntityStableAcrossReorder(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	t.Chdir(t.TempDir())
+	auditLog, _ := newTestAuditLog(t)
+	manager := createTestClientTokenManager("first-client-token-0001", "second-client-token-0002")
+
+	r := gin.New()
+	registerClientTokenRoutes(r, manager, auditLog, false)
+
+	for _, path := range []string{"/api/client-tokens/0", "/api/client-tokens/0/toggle"} {
+		method := http.MethodDelete
+		if strings.HasSuffix(path, "/toggle") {
+			method = http.MethodPost
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(method, path, nil))
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	}
+
+	// 删除第一个令牌后序号 0 指向第二个令牌，审计实体按令牌区分
+	deleted := auditLog.Query(AuditFilter{Action: AuditActionClientTokenDelete})
+	toggled := auditLog.Query(AuditFilter{Action: AuditActionClientTokenToggle})
+	require.Len(t, deleted, 1)
+	require.Len(t, toggled, 1)
+	assert.Equal(t, clientTokenAuditEntity("first-client-token-0001"), deleted[0].Entity)
+	assert.Equal(t, clientTokenAuditEntity("second-client-token-0002"), toggled[0].Entity)
+	assert.NotContains(t, toggled[0].Entity, "second-client-token")
+}
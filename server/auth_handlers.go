@@ -25,17 +25,23 @@ type AuthHandlers struct {
 	secureCookie bool
 	idleTimeout  time.Duration
 	limiter      *loginRateLimiter
+	totp         *TOTPStore
+	audit        *AuditLog
+	oidc         *OIDCProvider // 为 nil 时不启用 SSO
 }
 
 // NewAuthHandlers 创建认证处理器
-func NewAuthHandlers(manager *SessionManager, adminUser, adminPass string, idleTimeout time.Duration, secureCookie bool) *AuthHandlers {
+// totp 为 nil 时不启用两步验证；store 为 nil 时登录锁定状态只保存在内存中
+func NewAuthHandlers(manager *SessionManager, adminUser, adminPass string, idleTimeout time.Duration, secureCookie bool, totp *TOTPStore, audit *AuditLog, store SessionStore) *AuthHandlers {
 	return &AuthHandlers{
 		manager:      manager,
 		adminUser:    adminUser,
 		adminPass:    adminPass,
 		secureCookie: secureCookie,
 		idleTimeout:  idleTimeout,
-		limiter:      newLoginRateLimiter(10, 10*time.Minute), // 10分钟内最多10次尝试
+		limiter:      newLoginRateLimiter(10, 10*time.Minute, store), // 10分钟内最多10次尝试
+		totp:         totp,
+		audit:        audit,
 	}
 }
 
@@ -43,6 +49,7 @@ func NewAuthHandlers(manager *SessionManager, adminUser, adminPass string, idleT
 type LoginRequest struct {
 	Username string `json:"username"`
 	Password string `json:"password"`
+	TOTPCode string `json:"totp_code,omitempty"` // 两步验证码或恢复码
 }
 
 // HandleLogin 处理登录请求
@@ -69,6 +76,15 @@ func (h *AuthHandlers) HandleLogin(c *gin.Context) {
 		return
 	}
 
+	// 未设置管理员密码时（仅启用 SSO）禁止本地登录
+	if h.adminPass == "" {
+		c.JSON(http.StatusForbidden, gin.H{
+			"success": false,
+			"error":   "本地登录未启用，请使用 SSO 登录",
+		})
+		return
+	}
+
 	// 验证凭据（使用常数时间比较防止时序攻击）
 	userMatch := subtle.ConstantTimeCompare([]byte(req.Username), []byte(h.adminUser)) == 1
 	passMatch := subtle.ConstantTimeCompare([]byte(req.Password), []byte(h.adminPass)) == 1
@@ -86,8 +102,40 @@ func (h *AuthHandlers) HandleLogin(c *gin.Context) {
 		return
 	}
 
+	// 两步验证（必须在创建会话之前完成）
+	if h.totp.IsEnabled(req.Username) {
+		if req.TOTPCode == "" {
+			c.JSON(http.StatusUnauthorized, gin.H{
+				"success":       false,
+				"totp_required": true,
+				"error":         "请输入两步验证码",
+			})
+			return
+		}
+
+		usedRecovery, err := h.totp.Verify(req.Username, req.TOTPCode)
+		if err != nil {
+			time.Sleep(failedLoginDelay)
+			logger.Warn("登录失败: 两步验证码无效",
+				logger.String("username", req.Username),
+				logger.String("ip", ip))
+			c.JSON(http.StatusUnauthorized, gin.H{
+				"success":       false,
+				"totp_required": true,
+				"error":         "两步验证码无效",
+			})
+			return
+		}
+		if usedRecovery {
+			logger.Warn("用户使用恢复码登录",
+				logger.String("username", req.Username),
+				logger.String("ip", ip),
+				logger.Int("remaining_codes", h.totp.RecoveryCodesRemaining(req.Username)))
+		}
+	}
+
 	// 创建会话
-	session, err := h.manager.CreateSession(req.Username)
+	session, err := h.manager.CreateSession(req.Username, ip, c.Request.UserAgent())
 	if err != nil {
 		logger.Error("创建会话失败",
 			logger.Err(err))
@@ -148,39 +196,55 @@ func (h *AuthHandlers) HandleSessionCheck(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{
 		"authenticated": authenticated,
 		"user":          user,
+		"role":          GetSessionRole(c),
 	})
 }
 
 // loginRateLimiter 简单的登录限流器（按IP）
+// 配置了 store 时锁定状态会持久化，重启后保持不变；只在IP进入或解除锁定时写入，且不阻塞其他登录请求
 type loginRateLimiter struct {
 	mu          sync.Mutex
 	limit       int
 	window      time.Duration
-	buckets     map[string]rateBucket
-	maxBuckets  int       // 最大桶数量
-	lastCleanup time.Time // 上次清理时间
-}
-
-type rateBucket struct {
-	count int
-	reset time.Time
+	buckets     map[string]LoginLockout
+	maxBuckets  int          // 最大桶数量
+	lastCleanup time.Time    // 上次清理时间
+	store       SessionStore // 为 nil 时仅保存在内存中
+	version     uint64       // 锁定状态变更次数（受 mu 保护）
+
+	persistMu    sync.Mutex // 串行化写入存储
+	savedVersion uint64     // 已写入存储的版本（受 persistMu 保护）
 }
 
-func newLoginRateLimiter(limit int, window time.Duration) *loginRateLimiter {
-	return &loginRateLimiter{
+func newLoginRateLimiter(limit int, window time.Duration, store SessionStore) *loginRateLimiter {
+	l := &loginRateLimiter{
 		limit:       limit,
 		window:      window,
-		buckets:     make(map[string]rateBucket),
+		buckets:     make(map[string]LoginLockout),
 		maxBuckets:  10000, // 最多保留10000个IP记录
 		lastCleanup: time.Now(),
+		store:       store,
+	}
+
+	if store != nil {
+		lockouts, err := store.LoadLockouts()
+		if err != nil {
+			logger.Warn("加载登录锁定状态失败", logger.Err(err))
+		}
+		now := time.Now()
+		for key, bucket := range lockouts {
+			if now.Before(bucket.Reset) {
+				l.buckets[key] = bucket
+			}
+		}
 	}
+	return l
 }
 
 func (l *loginRateLimiter) Allow(key string) bool {
 	now := time.Now()
 
 	l.mu.Lock()
-	defer l.mu.Unlock()
 
 	// 定期清理过期桶（每分钟检查一次）
 	if now.Sub(l.lastCleanup) > time.Minute {
@@ -189,19 +253,58 @@ func (l *loginRateLimiter) Allow(key string) bool {
 	}
 
 	bucket := l.buckets[key]
+	wasLocked := bucket.Count > l.limit
 
 	// 窗口已过期，重置计数
-	if now.After(bucket.reset) {
-		bucket = rateBucket{
-			count: 0,
-			reset: now.Add(l.window),
+	if now.After(bucket.Reset) {
+		bucket = LoginLockout{
+			Count: 0,
+			Reset: now.Add(l.window),
 		}
 	}
 
-	bucket.count++
+	bucket.Count++
 	l.buckets[key] = bucket
+	locked := bucket.Count > l.limit
+
+	var snapshot map[string]LoginLockout
+	var version uint64
+	if l.store != nil && locked != wasLocked {
+		l.version++
+		snapshot, version = l.lockoutsLocked(now), l.version
+	}
+	l.mu.Unlock()
+
+	if snapshot != nil {
+		l.persist(snapshot, version)
+	}
+	return !locked
+}
+
+// lockoutsLocked 返回当前处于锁定状态的IP快照（调用时需持有锁）
+func (l *loginRateLimiter) lockoutsLocked(now time.Time) map[string]LoginLockout {
+	snapshot := make(map[string]LoginLockout)
+	for key, bucket := range l.buckets {
+		if bucket.Count > l.limit && now.Before(bucket.Reset) {
+			snapshot[key] = bucket
+		}
+	}
+	return snapshot
+}
+
+// persist 写入锁定状态快照，并发写入时跳过比已保存版本更旧的快照
+func (l *loginRateLimiter) persist(snapshot map[string]LoginLockout, version uint64) {
+	l.persistMu.Lock()
+	defer l.persistMu.Unlock()
 
-	return bucket.count <= l.limit
+	if version <= l.savedVersion {
+		return
+	}
+	if err := l.store.SaveLockouts(snapshot); err != nil {
+		logger.Warn("持久化登录锁定状态失败", logger.Err(err))
+		return
+	}
+	l.savedVersion = version
 }
 
 // cleanupExpiredLocked 清理过期的桶（调用时需持有锁）
@@ -210,7 +313,7 @@ func (l *loginRateLimiter) cleanupExpiredLocked(now time.Time) {
 	if len(l.buckets) > l.maxBuckets {
 		// 清理所有过期桶
 		for key, bucket := range l.buckets {
-			if now.After(bucket.reset) {
+			if now.After(bucket.Reset) {
 				delete(l.buckets, key)
 			}
 		}
@@ -12,10 +12,17 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
+// 会话角色
+const (
+	RoleAdmin  = "admin"  // 完整管理权限
+	RoleViewer = "viewer" // 只读，禁止修改类请求和敏感内容的读取
+)
+
 const (
 	// Context keys
 	sessionUserKey = "session_user"
 	sessionIDKey   = "session_id"
+	sessionRoleKey = "session_role"
 
 	// CSRF 配置
 	csrfTokenCookieName = "csrf_token"
@@ -29,8 +36,13 @@ func SessionMiddleware(manager *SessionManager) gin.HandlerFunc {
 		cookie, err := c.Request.Cookie(sessionCookieName)
 		if err == nil && cookie.Value != "" {
 			if session, ok := manager.Validate(cookie.Value); ok {
+				role := session.Role
+				if role == "" {
+					role = RoleAdmin
+				}
 				c.Set(sessionUserKey, session.User)
 				c.Set(sessionIDKey, session.ID)
+				c.Set(sessionRoleKey, role)
 			}
 		}
 		c.Next()
@@ -50,6 +62,34 @@ func AdminAPIAuthGuard() gin.HandlerFunc {
 			})
 			return
 		}
+		if GetSessionRole(c) == RoleViewer && isUnsafeMethod(c.Request.Method) {
+			logger.Debug("管理API访问被拒绝: 只读角色",
+				logger.String("path", c.Request.URL.Path),
+				logger.String("user", GetSessionUser(c)))
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+				"success": false,
+				"error":   "当前账号为只读角色，无权执行此操作",
+			})
+			return
+		}
+		c.Next()
+	}
+}
+
+// AdminOnlyGuard 限制敏感的只读接口（调试捕获包、审计导出、请求详情等）仅管理员可访问
+// 需放在 AdminAPIAuthGuard 之后；只读角色和只读权限的API密钥即使是 GET 请求也会被拒绝
+func AdminOnlyGuard() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if GetSessionRole(c) == RoleViewer {
+			logger.Debug("管理API访问被拒绝: 仅管理员可访问",
+				logger.String("path", c.Request.URL.Path),
+				logger.String("user", GetSessionUser(c)))
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+				"success": false,
+				"error":   "当前账号为只读角色，无权访问此内容",
+			})
+			return
+		}
 		c.Next()
 	}
 }
@@ -76,6 +116,16 @@ func GetSessionUser(c *gin.Context) string {
 	return ""
 }
 
+// GetSessionRole 从context获取当前会话角色（未登录返回空）
+func GetSessionRole(c *gin.Context) string {
+	if role, exists := c.Get(sessionRoleKey); exists {
+		if r, ok := role.(string); ok {
+			return r
+		}
+	}
+	return ""
+}
+
 // GetSessionID 从context获取当前会话ID
 func GetSessionID(c *gin.Context) string {
 	if sid, exists := c.Get(sessionIDKey); exists {
@@ -89,11 +139,13 @@ func GetSessionID(c *gin.Context) string {
 // CSRFMiddleware 使用双提交 Cookie 模式验证 CSRF token
 // 保护所有非安全 HTTP 方法（POST, PUT, PATCH, DELETE）
 // 跳过 /v1 开头的 API 路由（外部客户端 API 使用 Authorization header）
+// 以及使用管理API密钥认证的请求（不依赖 cookie，不存在 CSRF 风险）
 func CSRFMiddleware(secureCookie bool) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		// 跳过外部 API 路由（使用 token 认证，不需要 CSRF）
 		if strings.HasPrefix(c.Request.URL.Path, "/v1") ||
-			strings.HasPrefix(c.Request.URL.Path, "/api/event_logging") {
+			strings.HasPrefix(c.Request.URL.Path, "/api/event_logging") ||
+			GetAdminAPIKeyID(c) != "" {
 			c.Next()
 			return
 		}
@@ -31,7 +31,7 @@ type ClientTokenListResponse struct {
 }
 
 // registerClientTokenRoutes 注册客户端令牌管理路由
-func registerClientTokenRoutes(r *gin.Engine, manager *auth.ClientTokenManager, requireAuth bool) {
+func registerClientTokenRoutes(r *gin.Engine, manager *auth.ClientTokenManager, auditLog *AuditLog, requireAuth bool) {
 	// 创建路由组
 	group := r.Group("/api/client-tokens")
 	if requireAuth {
@@ -45,17 +45,22 @@ func registerClientTokenRoutes(r *gin.Engine, manager *auth.ClientTokenManager,
 
 	// 添加客户端令牌
 	group.POST("", func(c *gin.Context) {
-		handleAddClientToken(c, manager)
+		handleAddClientToken(c, manager, auditLog)
 	})
 
 	// 删除客户端令牌
 	group.DELETE("/:index", func(c *gin.Context) {
-		handleDeleteClientToken(c, manager)
+		handleDeleteClientToken(c, manager, auditLog)
 	})
 
 	// 切换客户端令牌状态
 	group.POST("/:index/toggle", func(c *gin.Context) {
-		handleToggleClientToken(c, manager)
+		handleToggleClientToken(c, manager, auditLog)
+	})
+
+	// 设置客户端令牌调试捕获开关
+	group.POST("/:index/debug-capture", func(c *gin.Context) {
+		handleClientTokenDebugCapture(c, manager, auditLog)
 	})
 }
 
@@ -70,7 +75,7 @@ func handleGetClientTokens(c *gin.Context, manager *auth.ClientTokenManager) {
 }
 
 // handleAddClientToken 添加客户端令牌
-func handleAddClientToken(c *gin.Context, manager *auth.ClientTokenManager) {
+func handleAddClientToken(c *gin.Context, manager *auth.ClientTokenManager, auditLog *AuditLog) {
 	var req AddClientTokenRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		logger.Warn("解析添加客户端令牌请求失败", logger.Err(err))
@@ -91,8 +96,12 @@ func handleAddClientToken(c *gin.Context, manager *auth.ClientTokenManager) {
 	}
 
 	// 添加令牌
+	newIndex := manager.GetTokenCount()
+	entity := clientTokenAuditEntity(req.Token)
+	after := auditClientTokenState(newIndex, req.Token, req.Name, false)
 	if err := manager.AddToken(req.Token, req.Name); err != nil {
 		logger.Error("添加客户端令牌失败", logger.Err(err))
+		auditLog.Record(c, AuditActionClientTokenAdd, entity, nil, after, err)
 		c.JSON(http.StatusBadRequest, ClientTokenAPIResponse{
 			Success: false,
 			Message: err.Error(),
@@ -100,6 +109,8 @@ func handleAddClientToken(c *gin.Context, manager *auth.ClientTokenManager) {
 		return
 	}
 
+	auditLog.Record(c, AuditActionClientTokenAdd, entity, nil, after, nil)
+
 	logger.Info("成功添加客户端令牌",
 		logger.String("name", req.Name),
 		logger.Int("total_count", manager.GetTokenCount()))
@@ -112,7 +123,7 @@ func handleAddClientToken(c *gin.Context, manager *auth.ClientTokenManager) {
 }
 
 // handleDeleteClientToken 删除客户端令牌
-func handleDeleteClientToken(c *gin.Context, manager *auth.ClientTokenManager) {
+func handleDeleteClientToken(c *gin.Context, manager *auth.ClientTokenManager, auditLog *AuditLog) {
 	indexStr := c.Param("index")
 	index, err := strconv.Atoi(indexStr)
 	if err != nil {
@@ -123,11 +134,16 @@ func handleDeleteClientToken(c *gin.Context, manager *auth.ClientTokenManager) {
 		return
 	}
 
+	// 记录删除前的状态用于审计
+	entity := clientTokenAuditEntityAt(manager, index)
+	before := clientTokenAuditState(manager, index)
+
 	// 删除令牌
 	if err := manager.RemoveToken(index); err != nil {
 		logger.Warn("删除客户端令牌失败",
 			logger.Int("index", index),
 			logger.Err(err))
+		auditLog.Record(c, AuditActionClientTokenDelete, entity, before, nil, err)
 		c.JSON(http.StatusBadRequest, ClientTokenAPIResponse{
 			Success: false,
 			Message: err.Error(),
@@ -135,6 +151,8 @@ func handleDeleteClientToken(c *gin.Context, manager *auth.ClientTokenManager) {
 		return
 	}
 
+	auditLog.Record(c, AuditActionClientTokenDelete, entity, before, nil, nil)
+
 	logger.Info("成功删除客户端令牌",
 		logger.Int("removed_index", index),
 		logger.Int("remaining_count", manager.GetTokenCount()))
@@ -147,7 +165,7 @@ func handleDeleteClientToken(c *gin.Context, manager *auth.ClientTokenManager) {
 }
 
 // handleToggleClientToken 切换客户端令牌状态
-func handleToggleClientToken(c *gin.Context, manager *auth.ClientTokenManager) {
+func handleToggleClientToken(c *gin.Context, manager *auth.ClientTokenManager, auditLog *AuditLog) {
 	indexStr := c.Param("index")
 	index, err := strconv.Atoi(indexStr)
 	if err != nil {
@@ -159,10 +177,13 @@ func handleToggleClientToken(c *gin.Context, manager *auth.ClientTokenManager) {
 	}
 
 	// 切换状态
+	entity := clientTokenAuditEntityAt(manager, index)
+	before := clientTokenAuditState(manager, index)
 	if err := manager.ToggleToken(index); err != nil {
 		logger.Warn("切换客户端令牌状态失败",
 			logger.Int("index", index),
 			logger.Err(err))
+		auditLog.Record(c, AuditActionClientTokenToggle, entity, before, nil, err)
 		c.JSON(http.StatusBadRequest, ClientTokenAPIResponse{
 			Success: false,
 			Message: err.Error(),
@@ -170,6 +191,8 @@ func handleToggleClientToken(c *gin.Context, manager *auth.ClientTokenManager) {
 		return
 	}
 
+	auditLog.Record(c, AuditActionClientTokenToggle, entity, before, clientTokenAuditState(manager, index), nil)
+
 	logger.Info("成功切换客户端令牌状态",
 		logger.Int("index", index))
 
@@ -179,3 +202,64 @@ func handleToggleClientToken(c *gin.Context, manager *auth.ClientTokenManager) {
 		Count:   manager.GetTokenCount(),
 	})
 }
+
+// clientTokenAuditState 获取指定索引客户端令牌的脱敏快照（索引无效时返回nil）
+func clientTokenAuditState(manager *auth.ClientTokenManager, index int) map[string]any {
+	stats := manager.GetAllStats()
+	if index < 0 || index >= len(stats) {
+		return nil
+	}
+	state := auditClientTokenState(index, stats[index].Token, stats[index].Name, stats[index].Disabled)
+	state["debug_capture"] = stats[index].DebugCapture
+	return state
+}
+
+// handleClientTokenDebugCapture 设置客户端令牌的调试捕获开关
+func handleClientTokenDebugCapture(c *gin.Context, manager *auth.ClientTokenManager, auditLog *AuditLog) {
+	indexStr := c.Param("index")
+	index, err := strconv.Atoi(indexStr)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ClientTokenAPIResponse{
+			Success: false,
+			Message: "无效的索引: " + indexStr,
+		})
+		return
+	}
+
+	var req struct {
+		Enabled bool `json:"enabled"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, ClientTokenAPIResponse{
+			Success: false,
+			Message: "无效的请求格式: " + err.Error(),
+		})
+		return
+	}
+
+	entity := clientTokenAuditEntityAt(manager, index)
+	before := clientTokenAuditState(manager, index)
+	if err := manager.SetDebugCapture(index, req.Enabled); err != nil {
+		logger.Warn("设置客户端令牌调试捕获失败",
+			logger.Int("index", index),
+			logger.Err(err))
+		auditLog.Record(c, AuditActionClientTokenDebug, entity, before, nil, err)
+		c.JSON(http.StatusBadRequest, ClientTokenAPIResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	auditLog.Record(c, AuditActionClientTokenDebug, entity, before, clientTokenAuditState(manager, index), nil)
+
+	logger.Info("已设置客户端令牌调试捕获",
+		logger.Int("index", index),
+		logger.Bool("enabled", req.Enabled))
+
+	c.JSON(http.StatusOK, ClientTokenAPIResponse{
+		Success: true,
+		Message: "调试捕获设置成功",
+		Count:   manager.GetTokenCount(),
+	})
+}
@@ -2,14 +2,21 @@ package server
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 
+	"kiro2api/auth"
 	"kiro2api/config"
 	"kiro2api/converter"
 	"kiro2api/logger"
+	"kiro2api/metrics"
+	"kiro2api/tracing"
 	"kiro2api/types"
 	"kiro2api/utils"
 
@@ -19,9 +26,11 @@ import (
 // respondErrorWithCode 标准化的错误响应结构
 // 统一返回: {"error": {"message": string, "code": string}}
 func respondErrorWithCode(c *gin.Context, statusCode int, code string, format string, args ...any) {
+	message := fmt.Sprintf(format, args...)
+	inspectorEntryFrom(c).setError(message)
 	c.JSON(statusCode, gin.H{
 		"error": gin.H{
-			"message": fmt.Sprintf(format, args...),
+			"message": message,
 			"code":    code,
 		},
 	})
@@ -50,6 +59,12 @@ func respondError(c *gin.Context, statusCode int, format string, args ...any) {
 // 通用请求处理错误函数
 func handleRequestBuildError(c *gin.Context, err error) {
 	logger.Error("构建请求失败", addReqFields(c, logger.Err(err))...)
+	// 文档内容无效属于客户端错误
+	var docErr *utils.DocumentError
+	if errors.As(err, &docErr) {
+		respondError(c, http.StatusBadRequest, "%s", docErr.Error())
+		return
+	}
 	respondError(c, http.StatusInternalServerError, "构建请求失败: %v", err)
 }
 
@@ -87,7 +102,16 @@ func filterSupportedTools(tools []types.AnthropicTool) []types.AnthropicTool {
 	return filtered
 }
 
+// executeCodeWhispererRequest 执行CodeWhisperer请求，tool_choice 强制调用工具时按需重试，带 web_search 工具时由代理执行搜索
 func executeCodeWhispererRequest(c *gin.Context, anthropicReq types.AnthropicRequest, tokenInfo types.TokenInfo, isStream bool) (*http.Response, error) {
+	if ws := findWebSearchTool(anthropicReq); ws != nil {
+		return executeWithWebSearch(c, anthropicReq, ws, tokenInfo, isStream)
+	}
+	return executeWithToolChoice(c, anthropicReq, tokenInfo, isStream)
+}
+
+// sendCodeWhispererRequest 发送一次CodeWhisperer请求，失败时写入错误响应
+func sendCodeWhispererRequest(c *gin.Context, anthropicReq types.AnthropicRequest, tokenInfo types.TokenInfo, isStream bool) (*http.Response, error) {
 	req, err := buildCodeWhispererRequest(c, anthropicReq, tokenInfo, isStream)
 	if err != nil {
 		// 检查是否是模型未找到错误，如果是，则响应已经发送，不需要再次处理
@@ -98,17 +122,37 @@ func executeCodeWhispererRequest(c *gin.Context, anthropicReq types.AnthropicReq
 		return nil, err
 	}
 
+	modelLabel := metricsModelLabel(anthropicReq.Model)
+	_, span := startSpan(c, "upstream.request", tracing.SpanKindClient,
+		tracing.String("http.request.method", req.Method),
+		tracing.String("server.address", req.URL.Host),
+		tracing.String("gen_ai.request.model", anthropicReq.Model),
+		tracing.Bool("kiro.stream", isStream))
+	start := time.Now()
 	resp, err := utils.DoRequest(req)
 	if err != nil {
+		metrics.UpstreamLatency.Observe(time.Since(start).Seconds(), modelLabel, "error")
+		span.RecordError(err)
+		span.End()
 		handleRequestSendError(c, err)
 		return nil, err
 	}
+	metrics.UpstreamLatency.Observe(time.Since(start).Seconds(), modelLabel, strconv.Itoa(resp.StatusCode))
+	span.SetAttributes(tracing.Int("http.response.status_code", resp.StatusCode))
+	if resp.StatusCode >= http.StatusBadRequest {
+		span.SetError("HTTP %d", resp.StatusCode)
+	}
+	span.End()
+	resp.Body = &ttfbReader{ReadCloser: resp.Body, start: start, model: modelLabel}
+	resp.Body = debugCaptureFrom(c).wrapUpstream(resp.Body)
 
 	if handleCodeWhispererError(c, resp) {
 		resp.Body.Close()
 		return nil, fmt.Errorf("CodeWhisperer API error")
 	}
 
+	markUpstreamSuccess()
+
 	// 上游响应成功，记录方向与会话
 	logger.Debug("上游响应成功",
 		addReqFields(c,
@@ -124,7 +168,13 @@ var execCWRequest = executeCodeWhispererRequest
 
 // buildCodeWhispererRequest 构建通用的CodeWhisperer请求
 func buildCodeWhispererRequest(c *gin.Context, anthropicReq types.AnthropicRequest, tokenInfo types.TokenInfo, isStream bool) (*http.Request, error) {
+	_, span := startSpan(c, "converter.build_request", tracing.SpanKindInternal,
+		tracing.String("gen_ai.request.model", anthropicReq.Model),
+		tracing.Int("kiro.messages", len(anthropicReq.Messages)),
+		tracing.Int("kiro.tools", len(anthropicReq.Tools)))
 	cwReq, err := converter.BuildCodeWhispererRequest(anthropicReq, c)
+	span.RecordError(err)
+	span.End()
 	if err != nil {
 		// 检查是否是模型未找到错误
 		if modelNotFoundErr, ok := err.(*types.ModelNotFoundErrorType); ok {
@@ -132,13 +182,15 @@ func buildCodeWhispererRequest(c *gin.Context, anthropicReq types.AnthropicReque
 			c.JSON(http.StatusBadRequest, modelNotFoundErr.ErrorData)
 			return nil, err
 		}
-		return nil, fmt.Errorf("构建CodeWhisperer请求失败: %v", err)
+		return nil, fmt.Errorf("构建CodeWhisperer请求失败: %w", err)
 	}
 
 	cwReqBody, err := utils.SafeMarshal(cwReq)
 	if err != nil {
 		return nil, fmt.Errorf("序列化请求失败: %v", err)
 	}
+	inspectorEntryFrom(c).setUpstreamRequest(cwReqBody)
+	debugCaptureFrom(c).setUpstreamRequest(cwReqBody)
 
 	// 临时调试：记录发送给CodeWhisperer的请求内容
 	// 补充：当工具直传启用时输出工具名称预览
@@ -273,6 +325,8 @@ func (s *AnthropicStreamSender) SendEvent(c *gin.Context, data any) error {
 			logger.String("payload_preview", string(json)),
 		)...)
 
+	inspectorEntryFrom(c).recordEvent(eventType, json)
+
 	fmt.Fprintf(c.Writer, "event: %s\n", eventType)
 	fmt.Fprintf(c.Writer, "data: %s\n\n", string(json))
 	c.Writer.Flush()
@@ -306,6 +360,7 @@ func (s *OpenAIStreamSender) SendEvent(c *gin.Context, data any) error {
 			logger.String("direction", "downstream_send"),
 			logger.Int("payload_len", len(json)),
 		)...)
+	inspectorEntryFrom(c).recordEvent("chunk", json)
 
 	fmt.Fprintf(c.Writer, "data: %s\n\n", string(json))
 	c.Writer.Flush()
@@ -335,8 +390,8 @@ func (s *OpenAIStreamSender) SendError(c *gin.Context, message string, _ error)
 type RequestContext struct {
 	GinContext  *gin.Context
 	AuthService interface {
-		GetToken() (types.TokenInfo, error)
-		GetTokenWithUsage() (*types.TokenWithUsage, error)
+		GetTokenContext(ctx context.Context) (types.TokenInfo, error)
+		GetTokenWithUsageContext(ctx context.Context) (*types.TokenWithUsage, error)
 	}
 	RequestType string // "anthropic" 或 "openai"
 }
@@ -345,7 +400,9 @@ type RequestContext struct {
 // 返回: tokenInfo, requestBody, error
 func (rc *RequestContext) GetTokenAndBody() (types.TokenInfo, []byte, error) {
 	// 获取token
-	tokenInfo, err := rc.AuthService.GetToken()
+	ctx, span := startSpan(rc.GinContext, "account.select", tracing.SpanKindInternal)
+	tokenInfo, err := rc.AuthService.GetTokenContext(ctx)
+	rc.endAccountSpan(span, tokenInfo.RefreshToken, err)
 	if err != nil {
 		logger.Error("获取token失败", logger.Err(err))
 		respondError(rc.GinContext, http.StatusInternalServerError, "获取token失败: %v", err)
@@ -377,7 +434,14 @@ func (rc *RequestContext) GetTokenAndBody() (types.TokenInfo, []byte, error) {
 // 返回: tokenWithUsage, requestBody, error
 func (rc *RequestContext) GetTokenWithUsageAndBody() (*types.TokenWithUsage, []byte, error) {
 	// 获取token（包含使用信息）
-	tokenWithUsage, err := rc.AuthService.GetTokenWithUsage()
+	ctx, span := startSpan(rc.GinContext, "account.select", tracing.SpanKindInternal)
+	tokenWithUsage, err := rc.AuthService.GetTokenWithUsageContext(ctx)
+	if err == nil {
+		span.SetAttributes(tracing.Float64("kiro.account.available", tokenWithUsage.AvailableCount))
+		rc.endAccountSpan(span, tokenWithUsage.RefreshToken, nil)
+	} else {
+		rc.endAccountSpan(span, "", err)
+	}
 	if err != nil {
 		logger.Error("获取token失败", logger.Err(err))
 		respondError(rc.GinContext, http.StatusInternalServerError, "获取token失败: %v", err)
@@ -405,3 +469,16 @@ func (rc *RequestContext) GetTokenWithUsageAndBody() (*types.TokenWithUsage, []b
 
 	return tokenWithUsage, body, nil
 }
+
+// endAccountSpan 结束账号选择 span，并在请求根 span、请求检查器和用量统计中记录账号标识（哈希）
+func (rc *RequestContext) endAccountSpan(span *tracing.Span, refreshToken string, err error) {
+	if err == nil {
+		accountID := auth.AccountID(refreshToken)
+		span.SetAttributes(tracing.String("kiro.account.id", accountID))
+		setRequestSpanAttributes(rc.GinContext, tracing.String("kiro.account.id", accountID))
+		inspectorEntryFrom(rc.GinContext).setAccount(accountID)
+		rc.GinContext.Set(requestAccountKey, accountID)
+	}
+	span.RecordError(err)
+	span.End()
+}
@@ -2,6 +2,7 @@ package server
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"net/http"
@@ -25,11 +26,11 @@ type MockAuthService struct {
 	err        error
 }
 
-func (m *MockAuthService) GetToken() (types.TokenInfo, error) {
+func (m *MockAuthService) GetTokenContext(ctx context.Context) (types.TokenInfo, error) {
 	return m.token, m.err
 }
 
-func (m *MockAuthService) GetTokenWithUsage() (*types.TokenWithUsage, error) {
+func (m *MockAuthService) GetTokenWithUsageContext(ctx context.Context) (*types.TokenWithUsage, error) {
 	if m.tokenUsage != nil {
 		return m.tokenUsage, m.err
 	}
@@ -0,0 +1,553 @@
+package server
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"kiro2api/auth"
+	"kiro2api/logger"
+	"kiro2api/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 调试捕获：为单个请求保存完整的处理过程，用于排查转换或上游问题
+// 替代全局开启 LOG_LEVEL=debug，只记录指定请求，打包后可在管理面板按请求ID下载
+const (
+	// defaultDebugCaptureDir 默认捕获包存储目录
+	defaultDebugCaptureDir = "debug_captures"
+
+	// debugCaptureHeader 客户端请求头，值为 capture 时触发捕获
+	debugCaptureHeader = "X-Kiro-Debug"
+	// debugCaptureKeyHeader 管理面板启用认证时，需携带具有 debug:write 权限的管理API密钥
+	debugCaptureKeyHeader = "X-Kiro-Debug-Key"
+	// debugCaptureIDHeader 响应头，返回捕获包ID（即请求ID）
+	debugCaptureIDHeader = "X-Kiro-Debug-Capture"
+
+	// debugCaptureKey context 中保存当前请求的捕获
+	debugCaptureKey = "debug_capture"
+)
+
+// 捕获触发方式
+const (
+	DebugCaptureTriggerHeader      = "header"
+	DebugCaptureTriggerClientToken = "client_token"
+)
+
+// 捕获包内的文件
+const (
+	debugFileMeta          = "meta.json"
+	debugFileOriginal      = "original_request.json"
+	debugFileAnthropic     = "anthropic_request.json"
+	debugFileCodeWhisperer = "codewhisperer_request.json"
+	debugFileUpstream      = "upstream.eventstream"
+	debugFileEmitted       = "emitted_response.txt"
+)
+
+// debugCaptureIDPattern 捕获ID只允许请求ID字符，防止路径穿越
+var debugCaptureIDPattern = regexp.MustCompile(`^[A-Za-z0-9_\-]{1,64}$`)
+
+// DebugCaptureConfig 调试捕获配置
+type DebugCaptureConfig struct {
+	Dir        string
+	MaxBundles int // 保留的捕获包数量，超出时删除最早的
+	MaxBytes   int // 每部分内容的记录上限（字节），超出部分丢弃并在 meta 中标注
+}
+
+// LoadDebugCaptureConfigFromEnv 从环境变量加载调试捕获配置
+func LoadDebugCaptureConfigFromEnv() DebugCaptureConfig {
+	return DebugCaptureConfig{
+		Dir:        utils.GetEnvWithDefault("DEBUG_CAPTURE_DIR", defaultDebugCaptureDir),
+		MaxBundles: utils.GetEnvIntWithDefault("DEBUG_CAPTURE_MAX_BUNDLES", 50),
+		MaxBytes:   utils.GetEnvIntWithDefault("DEBUG_CAPTURE_MAX_BYTES", 16<<20),
+	}
+}
+
+// DebugCaptureInfo 捕获包摘要（同时作为包内 meta.json）
+type DebugCaptureInfo struct {
+	ID         string            `json:"id"`
+	Trigger    string            `json:"trigger"`
+	Method     string            `json:"method"`
+	Path       string            `json:"path"`
+	Client     string            `json:"client,omitempty"`
+	Model      string            `json:"model,omitempty"`
+	Account    string            `json:"account,omitempty"`
+	StatusCode int               `json:"statusCode"`
+	StartedAt  time.Time         `json:"startedAt"`
+	DurationMs int64             `json:"durationMs"`
+	Headers    map[string]string `json:"headers,omitempty"`
+	Truncated  []string          `json:"truncated,omitempty"` // 超出 MaxBytes 被截断的部分
+	Size       int64             `json:"size,omitempty"`      // 捕获包文件大小（仅列表返回）
+}
+
+// DebugCaptureStore 捕获包存储（每个请求一个 zip 文件）
+type DebugCaptureStore struct {
+	mu  sync.Mutex
+	cfg DebugCaptureConfig
+}
+
+// NewDebugCaptureStore 创建捕获包存储
+func NewDebugCaptureStore(cfg DebugCaptureConfig) (*DebugCaptureStore, error) {
+	if cfg.Dir == "" {
+		cfg.Dir = defaultDebugCaptureDir
+	}
+	if err := os.MkdirAll(cfg.Dir, 0700); err != nil {
+		return nil, fmt.Errorf("创建调试捕获目录失败: %w", err)
+	}
+	return &DebugCaptureStore{cfg: cfg}, nil
+}
+
+// bundlePath 捕获包文件路径
+func (s *DebugCaptureStore) bundlePath(id string) string {
+	return filepath.Join(s.cfg.Dir, id+".zip")
+}
+
+// List 列出捕获包（按时间倒序）
+func (s *DebugCaptureStore) List() ([]DebugCaptureInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entries, err := os.ReadDir(s.cfg.Dir)
+	if err != nil {
+		return nil, fmt.Errorf("读取调试捕获目录失败: %w", err)
+	}
+
+	list := make([]DebugCaptureInfo, 0, len(entries))
+	for _, e := range entries {
+		id, ok := strings.CutSuffix(e.Name(), ".zip")
+		if e.IsDir() || !ok || !debugCaptureIDPattern.MatchString(id) {
+			continue
+		}
+		info, err := readDebugCaptureMeta(s.bundlePath(id))
+		if err != nil {
+			logger.Warn("读取调试捕获包失败", logger.String("id", id), logger.Err(err))
+			continue
+		}
+		if fi, err := e.Info(); err == nil {
+			info.Size = fi.Size()
+		}
+		info.Headers = nil
+		list = append(list, info)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].StartedAt.After(list[j].StartedAt) })
+	return list, nil
+}
+
+// Path 返回捕获包路径，不存在时返回错误
+func (s *DebugCaptureStore) Path(id string) (string, error) {
+	if !debugCaptureIDPattern.MatchString(id) {
+		return "", fmt.Errorf("无效的捕获ID: %s", id)
+	}
+	path := s.bundlePath(id)
+	if _, err := os.Stat(path); err != nil {
+		return "", fmt.Errorf("调试捕获不存在: %s", id)
+	}
+	return path, nil
+}
+
+// Delete 删除捕获包
+func (s *DebugCaptureStore) Delete(id string) error {
+	path, err := s.Path(id)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return os.Remove(path)
+}
+
+// save 将捕获写入 zip 包，并清理超出数量的旧包
+func (s *DebugCaptureStore) save(capture *debugCapture) error {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+
+	meta, err := json.MarshalIndent(capture.info, "", "  ")
+	if err != nil {
+		return fmt.Errorf("序列化调试捕获信息失败: %w", err)
+	}
+	files := []struct {
+		name string
+		data []byte
+	}{
+		{debugFileMeta, meta},
+		{debugFileOriginal, capture.original.Bytes()},
+		{debugFileAnthropic, capture.anthropic},
+		{debugFileCodeWhisperer, capture.codewhisperer},
+		{debugFileUpstream, capture.upstream.Bytes()},
+		{debugFileEmitted, capture.emitted.Bytes()},
+	}
+	for _, f := range files {
+		if len(f.data) == 0 && f.name != debugFileMeta {
+			continue
+		}
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: f.name, Method: zip.Deflate, Modified: capture.info.StartedAt})
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(f.data); err != nil {
+			return err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return fmt.Errorf("打包调试捕获失败: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	path := s.bundlePath(capture.info.ID)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, buf.Bytes(), 0600); err != nil {
+		return fmt.Errorf("写入调试捕获失败: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("保存调试捕获失败: %w", err)
+	}
+	s.pruneLocked()
+	return nil
+}
+
+// pruneLocked 删除超出 MaxBundles 的最早捕获包
+func (s *DebugCaptureStore) pruneLocked() {
+	if s.cfg.MaxBundles <= 0 {
+		return
+	}
+	entries, err := os.ReadDir(s.cfg.Dir)
+	if err != nil {
+		return
+	}
+
+	type bundle struct {
+		path    string
+		modTime time.Time
+	}
+	var bundles []bundle
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), ".zip") {
+			continue
+		}
+		if fi, err := e.Info(); err == nil {
+			bundles = append(bundles, bundle{filepath.Join(s.cfg.Dir, e.Name()), fi.ModTime()})
+		}
+	}
+	if len(bundles) <= s.cfg.MaxBundles {
+		return
+	}
+	sort.Slice(bundles, func(i, j int) bool { return bundles[i].modTime.After(bundles[j].modTime) })
+	for _, b := range bundles[s.cfg.MaxBundles:] {
+		if err := os.Remove(b.path); err != nil {
+			logger.Warn("删除旧调试捕获失败", logger.String("path", b.path), logger.Err(err))
+		}
+	}
+}
+
+// readDebugCaptureMeta 读取捕获包中的 meta.json
+func readDebugCaptureMeta(path string) (DebugCaptureInfo, error) {
+	var info DebugCaptureInfo
+	zr, err := zip.OpenReader(path)
+	if err != nil {
+		return info, err
+	}
+	defer zr.Close()
+
+	f, err := zr.Open(debugFileMeta)
+	if err != nil {
+		return info, err
+	}
+	defer f.Close()
+	err = json.NewDecoder(f).Decode(&info)
+	return info, err
+}
+
+// cappedBuffer 有上限的缓冲区，超出部分丢弃
+type cappedBuffer struct {
+	bytes.Buffer
+	limit     int
+	truncated bool
+}
+
+func (b *cappedBuffer) write(p []byte) {
+	if b.limit > 0 && b.Len()+len(p) > b.limit {
+		p = p[:max(0, b.limit-b.Len())]
+		b.truncated = true
+	}
+	b.Buffer.Write(p)
+}
+
+// debugCapture 单个请求的捕获内容
+type debugCapture struct {
+	mu            sync.Mutex
+	info          DebugCaptureInfo
+	maxBytes      int
+	original      cappedBuffer
+	anthropic     []byte
+	codewhisperer []byte
+	upstream      cappedBuffer
+	emitted       cappedBuffer
+}
+
+// debugCaptureFrom 获取当前请求的捕获（未捕获时返回 nil，方法均可安全调用）
+func debugCaptureFrom(c *gin.Context) *debugCapture {
+	if v, ok := c.Get(debugCaptureKey); ok {
+		if capture, ok := v.(*debugCapture); ok {
+			return capture
+		}
+	}
+	return nil
+}
+
+// setAnthropicRequest 记录标准化后的 Anthropic 请求
+func (d *debugCapture) setAnthropicRequest(req any) {
+	if d == nil {
+		return
+	}
+	data, err := json.MarshalIndent(req, "", "  ")
+	if err != nil {
+		return
+	}
+	d.mu.Lock()
+	d.anthropic = d.capped("anthropic_request", data)
+	d.mu.Unlock()
+}
+
+// setUpstreamRequest 记录发送给上游的 CodeWhispererRequest
+func (d *debugCapture) setUpstreamRequest(body []byte) {
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	d.codewhisperer = d.capped("codewhisperer_request", body)
+	d.mu.Unlock()
+}
+
+// capped 按上限截断单个负载，调用者必须持有 d.mu
+func (d *debugCapture) capped(part string, data []byte) []byte {
+	if d.maxBytes > 0 && len(data) > d.maxBytes {
+		d.info.Truncated = append(d.info.Truncated, part)
+		return data[:d.maxBytes]
+	}
+	return data
+}
+
+// wrapUpstream 包装上游响应体，读取时同时记录原始 event-stream 字节
+func (d *debugCapture) wrapUpstream(body io.ReadCloser) io.ReadCloser {
+	if d == nil {
+		return body
+	}
+	return &captureReadCloser{ReadCloser: body, capture: d}
+}
+
+// captureReadCloser 记录读取到的上游字节
+type captureReadCloser struct {
+	io.ReadCloser
+	capture *debugCapture
+}
+
+func (r *captureReadCloser) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	if n > 0 {
+		r.capture.mu.Lock()
+		r.capture.upstream.write(p[:n])
+		r.capture.mu.Unlock()
+	}
+	return n, err
+}
+
+// captureResponseWriter 记录发送给客户端的响应（SSE 事件或 JSON）
+type captureResponseWriter struct {
+	gin.ResponseWriter
+	capture *debugCapture
+}
+
+func (w *captureResponseWriter) Write(b []byte) (int, error) {
+	w.capture.mu.Lock()
+	w.capture.emitted.write(b)
+	w.capture.mu.Unlock()
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *captureResponseWriter) WriteString(s string) (int, error) {
+	w.capture.mu.Lock()
+	w.capture.emitted.write([]byte(s))
+	w.capture.mu.Unlock()
+	return w.ResponseWriter.WriteString(s)
+}
+
+// debugCaptureTrigger 判断请求是否需要捕获，返回触发方式（空串表示不捕获）
+// 请求头方式在管理面板启用认证时需要携带具有 debug 写权限的管理API密钥
+func debugCaptureTrigger(c *gin.Context, keys *AdminAPIKeyManager, clientTokens *auth.ClientTokenManager, requireAuth bool) string {
+	if strings.EqualFold(strings.TrimSpace(c.GetHeader(debugCaptureHeader)), "capture") {
+		if !requireAuth {
+			return DebugCaptureTriggerHeader
+		}
+		if keys != nil {
+			info, err := keys.Authenticate(c.GetHeader(debugCaptureKeyHeader))
+			if err == nil && info.AllowsRequest(http.MethodPost, "/api/debug/captures") {
+				return DebugCaptureTriggerHeader
+			}
+		}
+		logger.Warn("忽略未授权的调试捕获请求",
+			logger.String("path", c.Request.URL.Path),
+			logger.String("ip", c.ClientIP()))
+	}
+
+	if clientTokens != nil && clientTokens.DebugCaptureEnabled(extractAPIKey(c)) {
+		return DebugCaptureTriggerClientToken
+	}
+	return ""
+}
+
+// DebugCaptureMiddleware 为触发捕获的代理请求记录完整处理过程
+// 需放在客户端令牌认证之后，未认证请求不捕获
+func DebugCaptureMiddleware(store *DebugCaptureStore, keys *AdminAPIKeyManager, clientTokens *auth.ClientTokenManager, requireAuth bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !proxyPaths[c.Request.URL.Path] {
+			c.Next()
+			return
+		}
+		trigger := debugCaptureTrigger(c, keys, clientTokens, requireAuth)
+		if trigger == "" {
+			c.Next()
+			return
+		}
+
+		id := GetRequestID(c)
+		if !debugCaptureIDPattern.MatchString(id) {
+			id = utils.GenerateUUID()
+		}
+		capture := &debugCapture{
+			info: DebugCaptureInfo{
+				ID:        id,
+				Trigger:   trigger,
+				Method:    c.Request.Method,
+				Path:      c.Request.URL.Path,
+				StartedAt: time.Now(),
+				Headers:   debugCaptureHeaders(c.Request.Header),
+			},
+			maxBytes: store.cfg.MaxBytes,
+			original: cappedBuffer{limit: store.cfg.MaxBytes},
+			upstream: cappedBuffer{limit: store.cfg.MaxBytes},
+			emitted:  cappedBuffer{limit: store.cfg.MaxBytes},
+		}
+
+		// 读取原始请求体后放回，供后续处理使用
+		if c.Request.Body != nil {
+			body, err := io.ReadAll(c.Request.Body)
+			c.Request.Body.Close()
+			c.Request.Body = io.NopCloser(bytes.NewReader(body))
+			if err == nil {
+				capture.original.write(body)
+			}
+		}
+
+		c.Set(debugCaptureKey, capture)
+		c.Writer = &captureResponseWriter{ResponseWriter: c.Writer, capture: capture}
+		c.Header(debugCaptureIDHeader, id)
+
+		c.Next()
+
+		capture.mu.Lock()
+		capture.info.StatusCode = c.Writer.Status()
+		capture.info.DurationMs = time.Since(capture.info.StartedAt).Milliseconds()
+		capture.info.Model = c.GetString(requestModelKey)
+		capture.info.Account = c.GetString(requestAccountKey)
+		capture.info.Client = c.GetString(clientTokenLabelKey)
+		for part, buf := range map[string]*cappedBuffer{
+			"original_request": &capture.original,
+			"upstream":         &capture.upstream,
+			"emitted_response": &capture.emitted,
+		} {
+			if buf.truncated {
+				capture.info.Truncated = append(capture.info.Truncated, part)
+			}
+		}
+		sort.Strings(capture.info.Truncated)
+		capture.mu.Unlock()
+
+		if err := store.save(capture); err != nil {
+			logger.Error("保存调试捕获失败", addReqFields(c, logger.Err(err))...)
+			return
+		}
+		logger.Info("已保存调试捕获",
+			addReqFields(c,
+				logger.String("capture_id", id),
+				logger.String("trigger", trigger))...)
+	}
+}
+
+// debugCaptureHeaders 记录请求头（认证相关的值脱敏）
+func debugCaptureHeaders(header http.Header) map[string]string {
+	result := make(map[string]string, len(header))
+	for name, values := range header {
+		value := strings.Join(values, ", ")
+		switch strings.ToLower(name) {
+		case "authorization", "x-api-key", "cookie", strings.ToLower(debugCaptureKeyHeader):
+			value = logger.RedactedPlaceholder
+		}
+		result[name] = value
+	}
+	return result
+}
+
+// registerDebugCaptureRoutes 注册调试捕获管理路由
+func registerDebugCaptureRoutes(r *gin.Engine, store *DebugCaptureStore, auditLog *AuditLog, requireAuth bool) {
+	group := r.Group("/api/debug/captures")
+	if requireAuth {
+		group.Use(AdminAPIAuthGuard())
+	}
+
+	group.GET("", func(c *gin.Context) {
+		list, err := store.List()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"success": false,
+				"error":   err.Error(),
+			})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"success":  true,
+			"captures": list,
+			"total":    len(list),
+		})
+	})
+
+	// 下载捕获包（zip）
+	group.GET("/:id", AdminOnlyGuard(), func(c *gin.Context) {
+		path, err := store.Path(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{
+				"success": false,
+				"error":   err.Error(),
+			})
+			return
+		}
+		c.FileAttachment(path, "kiro-debug-"+c.Param("id")+".zip")
+	})
+
+	group.DELETE("/:id", func(c *gin.Context) {
+		id := c.Param("id")
+		entity := "debug_capture:" + id
+		if err := store.Delete(id); err != nil {
+			auditLog.Record(c, AuditActionDebugCaptureDelete, entity, nil, nil, err)
+			c.JSON(http.StatusNotFound, gin.H{
+				"success": false,
+				"error":   err.Error(),
+			})
+			return
+		}
+		auditLog.Record(c, AuditActionDebugCaptureDelete, entity, nil, nil, nil)
+		c.JSON(http.StatusOK, gin.H{"success": true})
+	})
+}
@@ -0,0 +1,181 @@
+package server
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"kiro2api/auth"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newDebugCaptureTestRouter 创建带调试捕获的测试路由，/v1/messages 模拟一次流式代理
+func newDebugCaptureTestRouter(t *testing.T, store *DebugCaptureStore, keys *AdminAPIKeyManager, manager *auth.ClientTokenManager, requireAuth bool) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+
+	r := gin.New()
+	r.Use(RequestIDMiddleware())
+	r.Use(PathBasedAuthMiddleware(manager, []string{"/v1"}))
+	r.Use(DebugCaptureMiddleware(store, keys, manager, requireAuth))
+	r.POST("/v1/messages", func(c *gin.Context) {
+		body, _ := io.ReadAll(c.Request.Body)
+		require.Contains(t, string(body), "hello")
+
+		capture := debugCaptureFrom(c)
+		capture.setAnthropicRequest(map[string]any{"model": "claude-sonnet-4-20250514"})
+		capture.setUpstreamRequest([]byte(`{"conversationState":{}}`))
+		upstream := capture.wrapUpstream(io.NopCloser(strings.NewReader("\x00\x00\x00\x10event-bytes")))
+		_, _ = io.ReadAll(upstream)
+
+		sender := &AnthropicStreamSender{}
+		_ = sender.SendEvent(c, map[string]any{"type": "message_stop"})
+	})
+	registerDebugCaptureRoutes(r, store, nil, false)
+	return r
+}
+
+func postDebugCapture(r http.Handler, token string, headers map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(`{"messages":"hello"}`))
+	req.Header.Set("Authorization", "Bearer "+token)
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func newTestDebugCaptureStore(t *testing.T, maxBundles int) *DebugCaptureStore {
+	store, err := NewDebugCaptureStore(DebugCaptureConfig{
+		Dir:        filepath.Join(t.TempDir(), "captures"),
+		MaxBundles: maxBundles,
+		MaxBytes:   1 << 20,
+	})
+	require.NoError(t, err)
+	return store
+}
+
+func TestDebugCapture_HeaderRequiresAdminKey(t *testing.T) {
+	t.Chdir(t.TempDir())
+	store := newTestDebugCaptureStore(t, 10)
+	keys, err := NewAdminAPIKeyManager(filepath.Join(t.TempDir(), "keys.json"))
+	require.NoError(t, err)
+	_, readOnly, err := keys.Create("ro", []string{"debug:read"}, 0, "admin")
+	require.NoError(t, err)
+	_, writer, err := keys.Create("dbg", []string{"debug:write"}, 0, "admin")
+	require.NoError(t, err)
+
+	r := newDebugCaptureTestRouter(t, store, keys, createTestClientTokenManager("debug-client-token-1"), true)
+
+	// 缺少密钥或权限不足时不捕获
+	w := postDebugCapture(r, "debug-client-token-1", map[string]string{debugCaptureHeader: "capture"})
+	assert.Empty(t, w.Header().Get(debugCaptureIDHeader))
+	w = postDebugCapture(r, "debug-client-token-1", map[string]string{debugCaptureHeader: "capture", debugCaptureKeyHeader: readOnly})
+	assert.Empty(t, w.Header().Get(debugCaptureIDHeader))
+
+	list, err := store.List()
+	require.NoError(t, err)
+	assert.Empty(t, list)
+
+	w = postDebugCapture(r, "debug-client-token-1", map[string]string{debugCaptureHeader: "capture", debugCaptureKeyHeader: writer})
+	id := w.Header().Get(debugCaptureIDHeader)
+	require.NotEmpty(t, id)
+
+	list, err = store.List()
+	require.NoError(t, err)
+	require.Len(t, list, 1)
+	assert.Equal(t, id, list[0].ID)
+	assert.Equal(t, DebugCaptureTriggerHeader, list[0].Trigger)
+	assert.Equal(t, "test", list[0].Client)
+	assert.Equal(t, http.StatusOK, list[0].StatusCode)
+}
+
+func TestDebugCapture_ClientTokenFlagAndBundle(t *testing.T) {
+	t.Chdir(t.TempDir())
+	store := newTestDebugCaptureStore(t, 10)
+	manager := createTestClientTokenManager("debug-client-token-1")
+	r := newDebugCaptureTestRouter(t, store, nil, manager, true)
+
+	w := postDebugCapture(r, "debug-client-token-1", nil)
+	assert.Empty(t, w.Header().Get(debugCaptureIDHeader))
+
+	require.NoError(t, manager.SetDebugCapture(0, true))
+	w = postDebugCapture(r, "debug-client-token-1", nil)
+	id := w.Header().Get(debugCaptureIDHeader)
+	require.NotEmpty(t, id)
+
+	// 下载捕获包并检查内容
+	dl := httptest.NewRecorder()
+	r.ServeHTTP(dl, httptest.NewRequest(http.MethodGet, "/api/debug/captures/"+id, nil))
+	require.Equal(t, http.StatusOK, dl.Code)
+	assert.Contains(t, dl.Header().Get("Content-Disposition"), "kiro-debug-"+id+".zip")
+
+	zr, err := zip.NewReader(bytes.NewReader(dl.Body.Bytes()), int64(dl.Body.Len()))
+	require.NoError(t, err)
+	files := make(map[string]string)
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		require.NoError(t, err)
+		data, _ := io.ReadAll(rc)
+		rc.Close()
+		files[f.Name] = string(data)
+	}
+
+	assert.Equal(t, `{"messages":"hello"}`, files[debugFileOriginal])
+	assert.Contains(t, files[debugFileAnthropic], "claude-sonnet-4-20250514")
+	assert.Equal(t, `{"conversationState":{}}`, files[debugFileCodeWhisperer])
+	assert.Equal(t, "\x00\x00\x00\x10event-bytes", files[debugFileUpstream])
+	assert.Contains(t, files[debugFileEmitted], "message_stop")
+
+	var meta DebugCaptureInfo
+	require.NoError(t, json.Unmarshal([]byte(files[debugFileMeta]), &meta))
+	assert.Equal(t, DebugCaptureTriggerClientToken, meta.Trigger)
+	// 认证头不写入捕获包
+	assert.NotContains(t, files[debugFileMeta], "debug-client-token-1")
+
+	// 删除后无法下载
+	del := httptest.NewRecorder()
+	r.ServeHTTP(del, httptest.NewRequest(http.MethodDelete, "/api/debug/captures/"+id, nil))
+	assert.Equal(t, http.StatusOK, del.Code)
+	dl = httptest.NewRecorder()
+	r.ServeHTTP(dl, httptest.NewRequest(http.MethodGet, "/api/debug/captures/"+id, nil))
+	assert.Equal(t, http.StatusNotFound, dl.Code)
+}
+
+func TestDebugCaptureStore_PruneAndInvalidID(t *testing.T) {
+	t.Chdir(t.TempDir())
+	store := newTestDebugCaptureStore(t, 2)
+	r := newDebugCaptureTestRouter(t, store, nil, createTestClientTokenManager("debug-client-token-1"), false)
+
+	for i := 0; i < 4; i++ {
+		w := postDebugCapture(r, "debug-client-token-1", map[string]string{debugCaptureHeader: "capture"})
+		require.NotEmpty(t, w.Header().Get(debugCaptureIDHeader))
+	}
+
+	list, err := store.List()
+	require.NoError(t, err)
+	assert.Len(t, list, 2)
+
+	_, err = store.Path("../settings")
+	assert.Error(t, err)
+}
+
+func TestDebugCaptureRoutes_DownloadRequiresAdmin(t *testing.T) {
+	r, get := newRoleTestRouter(t)
+	auditLog, _ := newTestAuditLog(t)
+	registerDebugCaptureRoutes(r, newTestDebugCaptureStore(t, 10), auditLog, true)
+
+	// 捕获包含完整请求和上游响应，只读角色只能查看列表
+	assert.Equal(t, http.StatusOK, get(RoleViewer, "/api/debug/captures"))
+	assert.Equal(t, http.StatusForbidden, get(RoleViewer, "/api/debug/captures/missing"))
+	assert.Equal(t, http.StatusNotFound, get(RoleAdmin, "/api/debug/captures/missing"))
+}
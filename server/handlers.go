@@ -12,6 +12,7 @@ import (
 	"kiro2api/config"
 	"kiro2api/logger"
 	"kiro2api/parser"
+	"kiro2api/tracing"
 	"kiro2api/types"
 	"kiro2api/utils"
 
@@ -89,25 +90,43 @@ func handleGenericStreamRequest(c *gin.Context, anthropicReq types.AnthropicRequ
 		return
 	}
 	defer resp.Body.Close()
+	defer observeStreamDuration("anthropic", anthropicReq.Model, time.Now())
 
 	// 创建流处理上下文
 	ctx := NewStreamProcessorContext(c, anthropicReq, token, sender, messageID, inputTokens)
 	defer ctx.Cleanup()
 
 	// 发送初始事件
-	if err := ctx.sendInitialEvents(eventCreator); err != nil {
+	_, emitSpan := startSpan(c, "sse.emit", tracing.SpanKindInternal, tracing.String("kiro.sse.phase", "initial"))
+	err = ctx.sendInitialEvents(eventCreator)
+	emitSpan.RecordError(err)
+	emitSpan.End()
+	if err != nil {
 		return
 	}
 
-	// 处理事件流
-	processor := NewEventStreamProcessor(ctx)
-	if err := processor.ProcessEventStream(resp.Body); err != nil {
-		logger.Error("事件流处理失败", logger.Err(err))
-		return
+	// 处理事件流（网络搜索轮次的文本已匹配到停止序列或达到 max_tokens 时无需再读取）
+	if !ctx.stopped {
+		_, relaySpan := startSpan(c, "eventstream.relay", tracing.SpanKindInternal)
+		processor := NewEventStreamProcessor(ctx)
+		err = processor.ProcessEventStream(resp.Body)
+		relaySpan.SetAttributes(
+			tracing.Int("kiro.upstream.bytes", ctx.totalReadBytes),
+			tracing.Int("kiro.upstream.events", ctx.totalProcessedEvents))
+		relaySpan.RecordError(err)
+		relaySpan.End()
+		if err != nil {
+			logger.Error("事件流处理失败", logger.Err(err))
+			return
+		}
 	}
 
 	// 发送结束事件
-	if err := ctx.sendFinalEvents(); err != nil {
+	_, emitSpan = startSpan(c, "sse.emit", tracing.SpanKindInternal, tracing.String("kiro.sse.phase", "final"))
+	err = ctx.sendFinalEvents()
+	emitSpan.RecordError(err)
+	emitSpan.End()
+	if err != nil {
 		logger.Error("发送结束事件失败", logger.Err(err))
 		return
 	}
@@ -149,7 +168,7 @@ func createAnthropicStreamEvents(messageId string, inputTokens int, model string
 }
 
 // createAnthropicFinalEvents 创建Anthropic流式结束事件
-func createAnthropicFinalEvents(outputTokens, inputTokens int, stopReason string) []map[string]any {
+func createAnthropicFinalEvents(outputTokens, inputTokens int, stopReason, stopSequence string) []map[string]any {
 	// 构建符合Claude规范的完整usage信息
 	usage := map[string]any{
 		"output_tokens": outputTokens,
@@ -169,7 +188,7 @@ func createAnthropicFinalEvents(outputTokens, inputTokens int, stopReason string
 			"type": "message_delta",
 			"delta": map[string]any{
 				"stop_reason":   stopReason,
-				"stop_sequence": nil,
+				"stop_sequence": stopSequenceValue(stopSequence),
 			},
 			"usage": usage,
 		},
@@ -209,8 +228,10 @@ func handleNonStreamRequest(c *gin.Context, anthropicReq types.AnthropicRequest,
 	}
 
 	// 使用新的符合AWS规范的解析器，但在非流式模式下增加超时保护
+	_, parseSpan := startSpan(c, "eventstream.parse", tracing.SpanKindInternal,
+		tracing.Int("kiro.upstream.bytes", len(body)))
 	compliantParser := parser.NewCompliantEventStreamParser()
-	compliantParser.SetMaxErrors(config.ParserMaxErrors) // 限制最大错误次数以防死循环
+	compliantParser.SetMaxErrors(config.GetParserMaxErrors()) // 限制最大错误次数以防死循环
 
 	// 为非流式解析添加超时保护
 	result, err := func() (*parser.ParseResult, error) {
@@ -236,6 +257,11 @@ func handleNonStreamRequest(c *gin.Context, anthropicReq types.AnthropicRequest,
 			return nil, fmt.Errorf("解析超时")
 		}
 	}()
+	if err == nil {
+		parseSpan.SetAttributes(tracing.Int("kiro.upstream.events", len(result.Events)))
+	}
+	parseSpan.RecordError(err)
+	parseSpan.End()
 
 	if err != nil {
 		logger.Error("非流式解析失败",
@@ -264,8 +290,8 @@ func handleNonStreamRequest(c *gin.Context, anthropicReq types.AnthropicRequest,
 		return
 	}
 
-	// 转换为Anthropic格式
-	var contexts []map[string]any
+	// 转换为Anthropic格式（代理执行的网络搜索位于最前面，其中的模型文本同样匹配停止序列）
+	contexts, stopSequence := cutWebSearchBlocksAtStopSequence(webSearchContentBlocks(c, anthropicReq.Thinking.IsEnabled()), anthropicReq.StopSequences)
 	textAgg := result.GetCompletionText()
 
 	// 先获取工具管理器的所有工具，确保sawToolUse的判断基于实际工具
@@ -292,6 +318,30 @@ func handleNonStreamRequest(c *gin.Context, anthropicReq types.AnthropicRequest,
 	// 		logger.Bool("saw_tool_use", sawToolUse),
 	// 	)...)
 
+	// 开启扩展思考时，将回复开头 <thinking> 标签内的内容拆分为思考块（位于最前面）
+	if anthropicReq.Thinking.IsEnabled() && stopSequence == "" {
+		var thinkingText string
+		thinkingText, textAgg = splitThinkingText(textAgg)
+		if thinkingText != "" {
+			contexts = append(contexts, map[string]any{
+				"type":      "thinking",
+				"thinking":  thinkingText,
+				"signature": newThinkingSignature(),
+			})
+		}
+	}
+
+	// 停止序列：在匹配处截断文本，之后的工具调用不再下发（与流式响应一致）
+	if stopSequence != "" {
+		textAgg = ""
+	} else {
+		textAgg, stopSequence = cutAtStopSequence(textAgg, anthropicReq.StopSequences)
+	}
+	if stopSequence != "" {
+		allTools = nil
+		sawToolUse = false
+	}
+
 	// 添加文本内容
 	if textAgg != "" {
 		contexts = append(contexts, map[string]any{
@@ -347,6 +397,12 @@ func handleNonStreamRequest(c *gin.Context, anthropicReq types.AnthropicRequest,
 	// 使用新的stop_reason管理器，确保符合Claude官方规范
 	stopReasonManager := NewStopReasonManager(anthropicReq)
 
+	// 本地 max_tokens 限制：超出预算的内容被截断
+	contexts, maxTokensReached := truncateContentToMaxTokens(estimator, contexts, anthropicReq.MaxTokens)
+	if maxTokensReached {
+		stopReasonManager.SetMaxTokensReached()
+	}
+
 	// *** 关键修复：基于实际发送给客户端的内容计算 token ***
 	// 设计原则：token 计费应该基于实际下发的内容，而不是上游原始数据
 	// 原因：
@@ -364,12 +420,24 @@ func handleNonStreamRequest(c *gin.Context, anthropicReq types.AnthropicRequest,
 				outputTokens += estimator.EstimateTextTokens(text)
 			}
 		
+		case "thinking":
+			// 思考块：与文本相同按思考内容计算
+			if thinking, ok := contentBlock["thinking"].(string); ok {
+				outputTokens += estimator.EstimateTextTokens(thinking)
+			}
+
 		case "tool_use":
 			// 工具调用块：基于实际发送的工具名称和参数
 			// 这里使用与 SSE 响应相同的 token 计算逻辑
 			toolName, _ := contentBlock["name"].(string)
 			toolInput, _ := contentBlock["input"].(map[string]any)
 			outputTokens += estimator.EstimateToolUseTokens(toolName, toolInput)
+
+		case webSearchServerToolUseBlock:
+			// 代理执行的搜索调用：与工具调用相同计算，搜索结果块不计入输出
+			toolName, _ := contentBlock["name"].(string)
+			toolInput, _ := contentBlock["input"].(map[string]any)
+			outputTokens += estimator.EstimateToolUseTokens(toolName, toolInput)
 		}
 	}
 
@@ -379,6 +447,7 @@ func handleNonStreamRequest(c *gin.Context, anthropicReq types.AnthropicRequest,
 	}
 
 	stopReasonManager.UpdateToolCallStatus(sawToolUse, sawToolUse)
+	stopReasonManager.SetStopSequence(stopSequence)
 	stopReason := stopReasonManager.DetermineStopReason()
 
 	// logger.Debug("非流式响应stop_reason决策",
@@ -387,18 +456,24 @@ func handleNonStreamRequest(c *gin.Context, anthropicReq types.AnthropicRequest,
 	// 	logger.Bool("saw_tool_use", sawToolUse),
 	// 	logger.Int("output_tokens", outputTokens))
 
+	usage := map[string]any{
+		"input_tokens":  inputTokens,
+		"output_tokens": outputTokens,
+	}
+	if searches := webSearchRequestCount(c); searches > 0 {
+		usage["server_tool_use"] = map[string]any{"web_search_requests": searches}
+	}
 	anthropicResp := map[string]any{
 		"content":       contexts,
 		"model":         anthropicReq.Model,
 		"role":          "assistant",
 		"stop_reason":   stopReason,
-		"stop_sequence": nil,
+		"stop_sequence": stopSequenceValue(stopSequence),
 		"type":          "message",
-		"usage": map[string]any{
-			"input_tokens":  inputTokens,
-			"output_tokens": outputTokens,
-		},
+		"usage":         usage,
 	}
+	recordEstimatedTokens(c, anthropicReq.Model, inputTokens, outputTokens)
+	recordStopReason(c, stopReason)
 
 	// logger.Debug("非流式响应最终数据",
 	// 	logger.String("stop_reason", stopReason),
@@ -411,6 +486,7 @@ func handleNonStreamRequest(c *gin.Context, anthropicReq types.AnthropicRequest,
 			logger.Bool("saw_tool_use", sawToolUse),
 			logger.Int("content_count", len(contexts)),
 		)...)
+	recordInspectedResponse(c, "message", anthropicResp)
 	c.JSON(http.StatusOK, anthropicResp)
 }
 
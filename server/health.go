@@ -0,0 +1,270 @@
+package server
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"kiro2api/auth"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 健康检查状态
+const (
+	HealthStatusPass = "pass"
+	HealthStatusWarn = "warn"
+	HealthStatusFail = "fail"
+)
+
+// persistenceCheckInterval 持久化目录可写检查结果的缓存时间，避免频繁探测时反复创建临时文件
+const persistenceCheckInterval = 10 * time.Second
+
+// lastUpstreamSuccess 最近一次上游调用成功的时间（UnixNano，0 表示尚未成功过）
+var lastUpstreamSuccess atomic.Int64
+
+// markUpstreamSuccess 记录上游调用成功
+func markUpstreamSuccess() {
+	lastUpstreamSuccess.Store(time.Now().UnixNano())
+}
+
+// HealthCheck 单个子系统的检查结果
+type HealthCheck struct {
+	Name     string         `json:"name"`
+	Status   string         `json:"status"`
+	Critical bool           `json:"critical"` // 关键检查失败时 readyz 返回 503
+	Message  string         `json:"message,omitempty"`
+	Details  map[string]any `json:"details,omitempty"`
+}
+
+// accountPoolSource 健康检查所需的账号池信息（*auth.AuthService 实现，便于测试替换）
+type accountPoolSource interface {
+	GetConfigCount() int
+	GetAllCacheStatus() []auth.TokenCacheStatus
+}
+
+// HealthChecker 存活与就绪检查
+type HealthChecker struct {
+	authService        accountPoolSource
+	clientTokenManager *auth.ClientTokenManager
+	persistDirs        []string // 需要可写的持久化目录
+	startedAt          time.Time
+	publicVerbose      bool // 为 true 时未登录也可获取详细检查结果（HEALTH_VERBOSE_PUBLIC）
+
+	persistMu        sync.Mutex
+	persistCheck     HealthCheck // 最近一次持久化检查结果
+	persistCheckedAt time.Time
+}
+
+// NewHealthChecker 创建健康检查器，persistFiles 为各持久化文件路径（检查其所在目录是否可写）
+func NewHealthChecker(authService accountPoolSource, clientTokenManager *auth.ClientTokenManager, persistFiles ...string) *HealthChecker {
+	seen := make(map[string]bool)
+	dirs := make([]string, 0, len(persistFiles))
+	for _, file := range persistFiles {
+		if file == "" {
+			continue
+		}
+		dir := filepath.Dir(file)
+		if !seen[dir] {
+			seen[dir] = true
+			dirs = append(dirs, dir)
+		}
+	}
+	return &HealthChecker{
+		authService:        authService,
+		clientTokenManager: clientTokenManager,
+		persistDirs:        dirs,
+		startedAt:          time.Now(),
+	}
+}
+
+// registerHealthRoutes 注册健康检查路由（无需认证，供编排系统探测；详细结果仅管理员可见）
+func registerHealthRoutes(r *gin.Engine, checker *HealthChecker) {
+	r.GET("/healthz", checker.HandleHealthz)
+	r.GET("/readyz", checker.HandleReadyz)
+}
+
+// HandleHealthz 存活检查：进程能处理请求即视为存活
+func (h *HealthChecker) HandleHealthz(c *gin.Context) {
+	resp := gin.H{"status": "ok"}
+	if h.verbose(c) {
+		resp["started_at"] = h.startedAt
+		resp["uptime_seconds"] = int64(time.Since(h.startedAt).Seconds())
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// HandleReadyz 就绪检查：没有可用账号时返回 503
+// ?verbose=1 时向管理员返回每个子系统的检查详情
+func (h *HealthChecker) HandleReadyz(c *gin.Context) {
+	checks := h.RunChecks()
+
+	ready := true
+	failed := make([]string, 0)
+	for _, check := range checks {
+		if check.Critical && check.Status == HealthStatusFail {
+			ready = false
+			failed = append(failed, check.Name)
+		}
+	}
+
+	status, code := "ready", http.StatusOK
+	if !ready {
+		status, code = "not_ready", http.StatusServiceUnavailable
+	}
+
+	resp := gin.H{"status": status}
+	if len(failed) > 0 {
+		resp["failed"] = failed
+	}
+	if h.verbose(c) {
+		resp["checks"] = checks
+		resp["timestamp"] = time.Now()
+	}
+	c.JSON(code, resp)
+}
+
+// RunChecks 执行全部子系统检查
+func (h *HealthChecker) RunChecks() []HealthCheck {
+	return []HealthCheck{
+		h.checkConfig(),
+		h.checkAccountPool(),
+		h.checkUpstream(),
+		h.checkPersistence(),
+	}
+}
+
+// checkConfig 检查账号配置是否已加载
+func (h *HealthChecker) checkConfig() HealthCheck {
+	check := HealthCheck{Name: "config", Critical: true, Status: HealthStatusPass}
+
+	accounts := 0
+	if h.authService != nil {
+		accounts = h.authService.GetConfigCount()
+	}
+	clientTokens := 0
+	if h.clientTokenManager != nil {
+		clientTokens = h.clientTokenManager.GetTokenCount()
+	}
+	check.Details = map[string]any{
+		"accounts":      accounts,
+		"client_tokens": clientTokens,
+	}
+
+	switch {
+	case accounts == 0:
+		check.Status = HealthStatusFail
+		check.Message = "未配置任何上游账号"
+	case clientTokens == 0:
+		check.Status = HealthStatusWarn
+		check.Message = "未配置客户端令牌，API 端点无法访问"
+	}
+	return check
+}
+
+// checkAccountPool 检查账号池中是否有可用账号
+// 访问令牌在收到请求时才按需刷新，空闲实例的令牌过期或尚未加载属于正常情况，
+// 因此未禁用、未耗尽的账号（含过期、刷新中、未缓存）都计为可服务，避免空闲实例被摘除后再也得不到刷新
+func (h *HealthChecker) checkAccountPool() HealthCheck {
+	check := HealthCheck{Name: "account_pool", Critical: true, Status: HealthStatusPass}
+
+	counts := make(map[string]int, len(accountStates))
+	for _, state := range accountStates {
+		counts[state] = 0
+	}
+	ready := 0
+	if h.authService != nil {
+		for _, status := range h.authService.GetAllCacheStatus() {
+			state := accountState(status)
+			counts[state]++
+			if state != "disabled" && state != "exhausted" {
+				ready++
+			}
+		}
+	}
+	check.Details = map[string]any{"states": counts, "usable": counts["usable"], "ready": ready}
+
+	if ready == 0 {
+		check.Status = HealthStatusFail
+		check.Message = "没有可用的上游账号"
+	}
+	return check
+}
+
+// checkUpstream 报告最近一次成功的上游调用（仅供参考，不影响就绪状态）
+func (h *HealthChecker) checkUpstream() HealthCheck {
+	check := HealthCheck{Name: "upstream", Status: HealthStatusPass}
+
+	last := lastUpstreamSuccess.Load()
+	if last == 0 {
+		check.Status = HealthStatusWarn
+		check.Message = "启动后尚无成功的上游调用"
+		return check
+	}
+
+	lastTime := time.Unix(0, last)
+	check.Details = map[string]any{
+		"last_success":     lastTime,
+		"last_success_ago": strconv.FormatFloat(time.Since(lastTime).Seconds(), 'f', 0, 64) + "s",
+	}
+	return check
+}
+
+// checkPersistence 检查持久化目录是否可写（失败时管理操作无法保存，但不影响代理请求）
+// 结果缓存 persistenceCheckInterval
+func (h *HealthChecker) checkPersistence() HealthCheck {
+	h.persistMu.Lock()
+	defer h.persistMu.Unlock()
+
+	if !h.persistCheckedAt.IsZero() && time.Since(h.persistCheckedAt) < persistenceCheckInterval {
+		return h.persistCheck
+	}
+	h.persistCheck = h.runPersistenceCheck()
+	h.persistCheckedAt = time.Now()
+	return h.persistCheck
+}
+
+// runPersistenceCheck 逐个目录创建临时文件检查是否可写
+func (h *HealthChecker) runPersistenceCheck() HealthCheck {
+	check := HealthCheck{Name: "persistence", Status: HealthStatusPass}
+
+	results := make(map[string]string, len(h.persistDirs))
+	for _, dir := range h.persistDirs {
+		if err := checkDirWritable(dir); err != nil {
+			results[dir] = err.Error()
+			check.Status = HealthStatusFail
+			check.Message = "持久化目录不可写"
+			continue
+		}
+		results[dir] = "writable"
+	}
+	check.Details = map[string]any{"directories": results}
+	return check
+}
+
+// checkDirWritable 通过创建并删除临时文件验证目录可写
+func checkDirWritable(dir string) error {
+	f, err := os.CreateTemp(dir, ".kiro2api-healthcheck-*")
+	if err != nil {
+		return err
+	}
+	name := f.Name()
+	f.Close()
+	return os.Remove(name)
+}
+
+// verbose 是否返回详细检查结果：详情包含账号数量和目录路径，只返回给管理员会话
+// 或开启了 HEALTH_VERBOSE_PUBLIC 的部署
+func (h *HealthChecker) verbose(c *gin.Context) bool {
+	return isVerbose(c) && (h.publicVerbose || GetSessionRole(c) == RoleAdmin)
+}
+
+// isVerbose 是否请求详细检查结果
+func isVerbose(c *gin.Context) bool {
+	_, ok := c.GetQuery("verbose")
+	return ok && c.Query("verbose") != "0" && c.Query("verbose") != "false"
+}
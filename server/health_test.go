@@ -0,0 +1,181 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"kiro2api/auth"
+	"kiro2api/types"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeAccountPool 测试用账号池
+type fakeAccountPool struct {
+	statuses []auth.TokenCacheStatus
+}
+
+func (f *fakeAccountPool) GetConfigCount() int { return len(f.statuses) }
+
+func (f *fakeAccountPool) GetAllCacheStatus() []auth.TokenCacheStatus { return f.statuses }
+
+func newHealthTestRouter(checker *HealthChecker) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	registerHealthRoutes(r, checker)
+	return r
+}
+
+func getHealth(t *testing.T, r *gin.Engine, path string) (int, map[string]any) {
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+	var body map[string]any
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+	return w.Code, body
+}
+
+func TestReadyz_FailsWhenNoUsableAccount(t *testing.T) {
+	pool := &fakeAccountPool{statuses: []auth.TokenCacheStatus{
+		{Index: 0, Exhausted: true},
+		{Index: 1, Cached: true, Available: 0, Token: types.TokenInfo{ExpiresAt: time.Now().Add(time.Hour)}},
+	}}
+	r := newHealthTestRouter(NewHealthChecker(pool, nil, filepath.Join(t.TempDir(), "state.json")))
+
+	code, body := getHealth(t, r, "/healthz")
+	assert.Equal(t, http.StatusOK, code, "存活检查不受账号池影响")
+	assert.Equal(t, "ok", body["status"])
+
+	code, body = getHealth(t, r, "/readyz")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, "not_ready", body["status"])
+	assert.Equal(t, []any{"account_pool"}, body["failed"])
+	assert.Nil(t, body["checks"], "默认不返回详情")
+
+	// 账号恢复可用后就绪
+	pool.statuses[1].Available = 50
+	code, _ = getHealth(t, r, "/readyz")
+	assert.Equal(t, http.StatusOK, code)
+}
+
+func TestReadyz_ReadyWithExpiredOrUncachedAccount(t *testing.T) {
+	pool := &fakeAccountPool{statuses: []auth.TokenCacheStatus{
+		{Index: 0, Cached: true, Available: 10, Token: types.TokenInfo{ExpiresAt: time.Now().Add(-time.Minute)}},
+		{Index: 1, Disabled: true},
+	}}
+	checker := NewHealthChecker(pool, nil)
+	checker.publicVerbose = true
+	r := newHealthTestRouter(checker)
+
+	// 空闲实例的访问令牌过期后仍可在下次请求时刷新，不应被摘除
+	code, body := getHealth(t, r, "/readyz?verbose=1")
+	assert.Equal(t, http.StatusOK, code)
+	var poolDetails map[string]any
+	for _, raw := range body["checks"].([]any) {
+		if check := raw.(map[string]any); check["name"] == "account_pool" {
+			poolDetails = check["details"].(map[string]any)
+		}
+	}
+	require.NotNil(t, poolDetails)
+	assert.EqualValues(t, 0, poolDetails["usable"])
+	assert.EqualValues(t, 1, poolDetails["ready"])
+
+	// 尚未加载令牌的账号同样计为可服务
+	pool.statuses[0] = auth.TokenCacheStatus{Index: 0}
+	code, _ = getHealth(t, r, "/readyz")
+	assert.Equal(t, http.StatusOK, code)
+
+	// 只剩禁用账号时未就绪
+	pool.statuses = pool.statuses[1:]
+	code, _ = getHealth(t, r, "/readyz")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+}
+
+func TestReadyz_VerboseChecks(t *testing.T) {
+	pool := &fakeAccountPool{statuses: []auth.TokenCacheStatus{
+		{Index: 0, Cached: true, Available: 10, Token: types.TokenInfo{ExpiresAt: time.Now().Add(time.Hour)}},
+	}}
+	checker := NewHealthChecker(pool, nil,
+		filepath.Join(t.TempDir(), "audit.jsonl"),
+		filepath.Join(t.TempDir(), "missing", "sessions.json"))
+
+	sessions := NewSessionManager(time.Hour, time.Hour)
+	defer sessions.Close()
+	admin, err := sessions.CreateSession("admin", "10.0.0.1", "browser")
+	require.NoError(t, err)
+	viewer, err := sessions.CreateSessionWithRole("ops", RoleViewer, "10.0.0.2", "browser")
+	require.NoError(t, err)
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(SessionMiddleware(sessions))
+	registerHealthRoutes(r, checker)
+
+	getVerbose := func(sessionID string) (int, map[string]any) {
+		req := httptest.NewRequest(http.MethodGet, "/readyz?verbose=1", nil)
+		if sessionID != "" {
+			req.AddCookie(&http.Cookie{Name: sessionCookieName, Value: sessionID})
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		var body map[string]any
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+		return w.Code, body
+	}
+
+	// 未登录和只读角色不返回详情
+	_, body := getVerbose("")
+	assert.Nil(t, body["checks"])
+	_, body = getVerbose(viewer.ID)
+	assert.Nil(t, body["checks"])
+
+	markUpstreamSuccess()
+	code, body := getVerbose(admin.ID)
+	require.Equal(t, http.StatusOK, code, "持久化失败不影响就绪状态")
+
+	checks := map[string]map[string]any{}
+	for _, raw := range body["checks"].([]any) {
+		check := raw.(map[string]any)
+		checks[check["name"].(string)] = check
+	}
+	require.Len(t, checks, 4)
+	assert.Equal(t, HealthStatusWarn, checks["config"]["status"], "未配置客户端令牌时给出警告")
+	assert.Equal(t, HealthStatusPass, checks["account_pool"]["status"])
+	assert.EqualValues(t, 1, checks["account_pool"]["details"].(map[string]any)["usable"])
+	assert.Equal(t, HealthStatusPass, checks["upstream"]["status"])
+	assert.Contains(t, checks["upstream"]["details"], "last_success")
+	assert.Equal(t, HealthStatusFail, checks["persistence"]["status"])
+
+	// 开启 HEALTH_VERBOSE_PUBLIC 后未登录也可获取
+	checker.publicVerbose = true
+	_, body = getVerbose("")
+	assert.NotNil(t, body["checks"])
+}
+
+func TestHealthChecker_CachesPersistenceCheck(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "state")
+	checker := NewHealthChecker(&fakeAccountPool{}, nil, filepath.Join(dir, "audit.jsonl"))
+
+	assert.Equal(t, HealthStatusFail, checker.checkPersistence().Status)
+
+	// 缓存期内不重新探测
+	require.NoError(t, os.MkdirAll(dir, 0o755))
+	assert.Equal(t, HealthStatusFail, checker.checkPersistence().Status)
+
+	checker.persistCheckedAt = time.Now().Add(-persistenceCheckInterval)
+	assert.Equal(t, HealthStatusPass, checker.checkPersistence().Status)
+}
+
+func TestReadyz_FailsWithoutConfig(t *testing.T) {
+	r := newHealthTestRouter(NewHealthChecker(&fakeAccountPool{}, nil))
+
+	code, body := getHealth(t, r, "/readyz")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.ElementsMatch(t, []any{"config", "account_pool"}, body["failed"])
+}
@@ -0,0 +1,33 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+
+	"kiro2api/config"
+	"kiro2api/logger"
+	"kiro2api/types"
+	"kiro2api/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// imagePreprocessHeader 响应头，列出图片预处理所做的修改（未修改任何图片时不返回）
+const imagePreprocessHeader = "X-Kiro-Image-Preprocess"
+
+// preprocessRequestImages 预处理请求中的图片（下载 URL 图片、转码、缩小、去除元数据），失败时直接写入 400 错误响应
+// 响应头需在开始流式响应之前设置，因此在请求解析阶段执行
+func preprocessRequestImages(c *gin.Context, anthropicReq *types.AnthropicRequest) error {
+	report, err := utils.PreprocessRequestImages(c.Request.Context(), anthropicReq.Messages, config.GetImageOptions())
+	if err != nil {
+		logger.Error("图片预处理失败", addReqFields(c, logger.Err(err))...)
+		respondError(c, http.StatusBadRequest, "%s", err.Error())
+		return err
+	}
+	if len(report) > 0 {
+		summary := strings.Join(report, "; ")
+		c.Header(imagePreprocessHeader, summary)
+		logger.Debug("图片已预处理", addReqFields(c, logger.String("changes", summary))...)
+	}
+	return nil
+}
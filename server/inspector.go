@@ -0,0 +1,511 @@
+package server
+
+import (
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"kiro2api/logger"
+	"kiro2api/tracing"
+	"kiro2api/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 实时请求检查器：在 Dashboard 中查看进行中和最近完成的代理请求，
+// 替代在生产环境开启 LOG_LEVEL=debug 查看客户端发送内容的做法
+
+const (
+	// defaultInspectorBufferSize 默认保留的已完成请求数
+	defaultInspectorBufferSize = 200
+	// defaultInspectorMaxPayload 单个负载（上游请求体、单个事件）默认记录上限
+	defaultInspectorMaxPayload = 64 * 1024
+	// defaultInspectorMaxEvents 单个请求默认记录的事件数上限
+	defaultInspectorMaxEvents = 500
+	// inspectorSubscriberBuffer 订阅者通道缓冲，消费过慢时丢弃更新而不阻塞请求
+	inspectorSubscriberBuffer = 64
+	// inspectorHeartbeatInterval SSE 心跳间隔，防止代理断开空闲连接
+	inspectorHeartbeatInterval = 15 * time.Second
+
+	// inspectorEntryKey context 中保存当前请求的检查记录
+	inspectorEntryKey = "inspector_entry"
+)
+
+// 请求状态
+const (
+	InspectStateInFlight  = "in_flight"
+	InspectStateCompleted = "completed"
+	InspectStateFailed    = "failed"
+)
+
+// proxyPaths 代理端点（请求检查器和用量统计只记录这些请求）
+var proxyPaths = map[string]bool{
+	"/v1/messages":         true,
+	"/v1/chat/completions": true,
+}
+
+// InspectorConfig 请求检查器配置
+type InspectorConfig struct {
+	Enabled         bool
+	BufferSize      int // 保留的已完成请求数
+	MaxPayloadBytes int // 单个负载记录上限（超出截断）
+	MaxEvents       int // 单个请求记录的事件数上限（超出仅计数）
+}
+
+// LoadInspectorConfigFromEnv 从环境变量读取检查器配置
+func LoadInspectorConfigFromEnv() InspectorConfig {
+	return InspectorConfig{
+		Enabled:         utils.GetEnvBoolWithDefault("INSPECTOR_ENABLED", true),
+		BufferSize:      utils.GetEnvIntWithDefault("INSPECTOR_BUFFER_SIZE", defaultInspectorBufferSize),
+		MaxPayloadBytes: utils.GetEnvIntWithDefault("INSPECTOR_MAX_PAYLOAD_BYTES", defaultInspectorMaxPayload),
+		MaxEvents:       utils.GetEnvIntWithDefault("INSPECTOR_MAX_EVENTS", defaultInspectorMaxEvents),
+	}
+}
+
+// InspectedEvent 转发给客户端的单个事件（已脱敏、截断）
+type InspectedEvent struct {
+	OffsetMs int64  `json:"offsetMs"` // 相对请求开始的毫秒数
+	Type     string `json:"type"`
+	Data     string `json:"data"`
+}
+
+// InspectedRequest 请求检查记录快照
+// 列表和实时推送只包含摘要字段，详情接口额外返回上游请求和事件
+type InspectedRequest struct {
+	ID           string     `json:"id"`
+	Method       string     `json:"method"`
+	Path         string     `json:"path"`
+	Client       string     `json:"client"`
+	Model        string     `json:"model"`
+	Account      string     `json:"account"`
+	Stream       bool       `json:"stream"`
+	State        string     `json:"state"`
+	StatusCode   int        `json:"statusCode,omitempty"`
+	StartedAt    time.Time  `json:"startedAt"`
+	FinishedAt   *time.Time `json:"finishedAt,omitempty"`
+	ElapsedMs    int64      `json:"elapsedMs"`
+	InputTokens  int        `json:"inputTokens"`
+	OutputTokens int        `json:"outputTokens"`
+	StopReason   string     `json:"stopReason,omitempty"`
+	Error        string     `json:"error,omitempty"`
+	EventCount   int        `json:"eventCount"`
+
+	UpstreamRequest string           `json:"upstreamRequest,omitempty"`
+	Events          []InspectedEvent `json:"events,omitempty"`
+	EventsDropped   int              `json:"eventsDropped,omitempty"`
+}
+
+// inspectorEntry 单个请求的可变记录
+// 所有方法对 nil 安全，检查器未启用或请求未被记录时调用方无需判空
+type inspectorEntry struct {
+	inspector *RequestInspector
+
+	mu   sync.Mutex
+	data InspectedRequest
+}
+
+// RequestInspector 保存进行中的请求和最近完成请求的环形缓冲，并向订阅者推送变更
+type RequestInspector struct {
+	cfg InspectorConfig
+
+	mu          sync.Mutex
+	inFlight    map[string]*inspectorEntry
+	recent      []*inspectorEntry // 环形缓冲
+	next        int
+	subscribers map[chan InspectedRequest]struct{}
+}
+
+// NewRequestInspector 创建请求检查器
+func NewRequestInspector(cfg InspectorConfig) *RequestInspector {
+	if cfg.BufferSize <= 0 {
+		cfg.BufferSize = defaultInspectorBufferSize
+	}
+	if cfg.MaxPayloadBytes <= 0 {
+		cfg.MaxPayloadBytes = defaultInspectorMaxPayload
+	}
+	if cfg.MaxEvents <= 0 {
+		cfg.MaxEvents = defaultInspectorMaxEvents
+	}
+	return &RequestInspector{
+		cfg:         cfg,
+		inFlight:    make(map[string]*inspectorEntry),
+		recent:      make([]*inspectorEntry, 0, cfg.BufferSize),
+		subscribers: make(map[chan InspectedRequest]struct{}),
+	}
+}
+
+// InspectorMiddleware 为代理请求创建检查记录（需在认证中间件之后，未认证请求不记录）
+func InspectorMiddleware(inspector *RequestInspector) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if inspector == nil || !proxyPaths[c.FullPath()] {
+			c.Next()
+			return
+		}
+
+		entry := inspector.begin(c)
+		c.Set(inspectorEntryKey, entry)
+		c.Next()
+		entry.finish(c.Writer.Status())
+	}
+}
+
+// inspectorEntryFrom 获取当前请求的检查记录（未记录时返回 nil）
+func inspectorEntryFrom(c *gin.Context) *inspectorEntry {
+	if c == nil {
+		return nil
+	}
+	if v, exists := c.Get(inspectorEntryKey); exists {
+		if entry, ok := v.(*inspectorEntry); ok {
+			return entry
+		}
+	}
+	return nil
+}
+
+// recordStopReason 在追踪根 span 和请求检查器中记录 stop_reason
+func recordStopReason(c *gin.Context, reason string) {
+	setRequestSpanAttributes(c, tracing.String("gen_ai.response.finish_reason", reason))
+	inspectorEntryFrom(c).setStopReason(reason)
+}
+
+// recordInspectedResponse 记录非流式响应体（仅在请求被检查器记录时序列化）
+func recordInspectedResponse(c *gin.Context, eventType string, resp any) {
+	entry := inspectorEntryFrom(c)
+	if entry == nil {
+		return
+	}
+	if body, err := utils.SafeMarshal(resp); err == nil {
+		entry.recordEvent(eventType, body)
+	}
+}
+
+// begin 登记进行中的请求
+func (ri *RequestInspector) begin(c *gin.Context) *inspectorEntry {
+	id := GetRequestID(c)
+	if id == "" {
+		id = utils.GenerateUUID()
+	}
+	entry := &inspectorEntry{
+		inspector: ri,
+		data: InspectedRequest{
+			ID:        id,
+			Method:    c.Request.Method,
+			Path:      c.Request.URL.Path,
+			Client:    c.GetString(clientTokenLabelKey),
+			State:     InspectStateInFlight,
+			StartedAt: time.Now(),
+		},
+	}
+
+	ri.mu.Lock()
+	ri.inFlight[id] = entry
+	ri.mu.Unlock()
+
+	ri.publish(entry.summary())
+	return entry
+}
+
+// complete 将请求移入最近完成的环形缓冲
+func (ri *RequestInspector) complete(entry *inspectorEntry) {
+	ri.mu.Lock()
+	delete(ri.inFlight, entry.id())
+	if len(ri.recent) < ri.cfg.BufferSize {
+		ri.recent = append(ri.recent, entry)
+	} else {
+		ri.recent[ri.next] = entry
+	}
+	ri.next = (ri.next + 1) % ri.cfg.BufferSize
+	ri.mu.Unlock()
+
+	ri.publish(entry.summary())
+}
+
+// List 返回进行中和最近完成的请求摘要（按开始时间倒序）
+func (ri *RequestInspector) List() []InspectedRequest {
+	ri.mu.Lock()
+	entries := make([]*inspectorEntry, 0, len(ri.inFlight)+len(ri.recent))
+	for _, entry := range ri.inFlight {
+		entries = append(entries, entry)
+	}
+	entries = append(entries, ri.recent...)
+	ri.mu.Unlock()
+
+	out := make([]InspectedRequest, 0, len(entries))
+	for _, entry := range entries {
+		out = append(out, entry.summary())
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].StartedAt.After(out[j].StartedAt)
+	})
+	return out
+}
+
+// Get 返回单个请求的完整记录
+func (ri *RequestInspector) Get(id string) (InspectedRequest, bool) {
+	ri.mu.Lock()
+	entry, ok := ri.inFlight[id]
+	if !ok {
+		for _, e := range ri.recent {
+			if e.id() == id {
+				entry, ok = e, true
+				break
+			}
+		}
+	}
+	ri.mu.Unlock()
+
+	if !ok {
+		return InspectedRequest{}, false
+	}
+	return entry.detail(), true
+}
+
+// InFlightCount 进行中的请求数
+func (ri *RequestInspector) InFlightCount() int {
+	ri.mu.Lock()
+	defer ri.mu.Unlock()
+	return len(ri.inFlight)
+}
+
+// Subscribe 订阅请求变更，返回通道和取消函数
+func (ri *RequestInspector) Subscribe() (<-chan InspectedRequest, func()) {
+	ch := make(chan InspectedRequest, inspectorSubscriberBuffer)
+	ri.mu.Lock()
+	ri.subscribers[ch] = struct{}{}
+	ri.mu.Unlock()
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			ri.mu.Lock()
+			delete(ri.subscribers, ch)
+			ri.mu.Unlock()
+		})
+	}
+}
+
+// publish 向订阅者推送摘要，通道已满时丢弃
+func (ri *RequestInspector) publish(update InspectedRequest) {
+	ri.mu.Lock()
+	defer ri.mu.Unlock()
+	for ch := range ri.subscribers {
+		select {
+		case ch <- update:
+		default:
+		}
+	}
+}
+
+// payload 脱敏并截断负载
+func (ri *RequestInspector) payload(b []byte) string {
+	return utils.TruncatePayload(utils.RedactSecrets(string(b)), ri.cfg.MaxPayloadBytes)
+}
+
+func (e *inspectorEntry) id() string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.data.ID
+}
+
+// summary 摘要快照（不含负载）
+func (e *inspectorEntry) summary() InspectedRequest {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	s := e.data
+	s.UpstreamRequest = ""
+	s.Events = nil
+	s.EventsDropped = 0
+	if s.State == InspectStateInFlight {
+		s.ElapsedMs = time.Since(s.StartedAt).Milliseconds()
+	}
+	return s
+}
+
+// detail 完整快照
+func (e *inspectorEntry) detail() InspectedRequest {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	d := e.data
+	d.Events = append([]InspectedEvent(nil), e.data.Events...)
+	if d.State == InspectStateInFlight {
+		d.ElapsedMs = time.Since(d.StartedAt).Milliseconds()
+	}
+	return d
+}
+
+// setModel 记录请求模型和是否流式
+func (e *inspectorEntry) setModel(model string, stream bool) {
+	if e == nil {
+		return
+	}
+	e.mu.Lock()
+	e.data.Model = model
+	e.data.Stream = stream
+	e.mu.Unlock()
+	e.inspector.publish(e.summary())
+}
+
+// setAccount 记录所用账号（账号标识哈希）
+func (e *inspectorEntry) setAccount(account string) {
+	if e == nil {
+		return
+	}
+	e.mu.Lock()
+	e.data.Account = account
+	e.mu.Unlock()
+}
+
+// setUpstreamRequest 记录转换后的 CodeWhispererRequest
+func (e *inspectorEntry) setUpstreamRequest(body []byte) {
+	if e == nil {
+		return
+	}
+	payload := e.inspector.payload(body)
+	e.mu.Lock()
+	e.data.UpstreamRequest = payload
+	e.mu.Unlock()
+}
+
+// setTokens 记录估算的输入/输出 token
+func (e *inspectorEntry) setTokens(inputTokens, outputTokens int) {
+	if e == nil {
+		return
+	}
+	e.mu.Lock()
+	e.data.InputTokens = inputTokens
+	e.data.OutputTokens = outputTokens
+	e.mu.Unlock()
+}
+
+// setStopReason 记录 stop_reason / finish_reason
+func (e *inspectorEntry) setStopReason(reason string) {
+	if e == nil {
+		return
+	}
+	e.mu.Lock()
+	e.data.StopReason = reason
+	e.mu.Unlock()
+}
+
+// setError 记录返回给客户端的错误信息
+func (e *inspectorEntry) setError(message string) {
+	if e == nil {
+		return
+	}
+	e.mu.Lock()
+	e.data.Error = utils.TruncatePayload(utils.RedactSecrets(message), e.inspector.cfg.MaxPayloadBytes)
+	e.mu.Unlock()
+}
+
+// recordEvent 记录转发给客户端的事件（超出事件数上限时仅计数）
+func (e *inspectorEntry) recordEvent(eventType string, payload []byte) {
+	if e == nil {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.data.EventCount++
+	if len(e.data.Events) >= e.inspector.cfg.MaxEvents {
+		e.data.EventsDropped++
+		return
+	}
+	e.data.Events = append(e.data.Events, InspectedEvent{
+		OffsetMs: time.Since(e.data.StartedAt).Milliseconds(),
+		Type:     eventType,
+		Data:     e.inspector.payload(payload),
+	})
+}
+
+// finish 结束记录
+func (e *inspectorEntry) finish(statusCode int) {
+	if e == nil {
+		return
+	}
+	now := time.Now()
+	e.mu.Lock()
+	e.data.StatusCode = statusCode
+	e.data.FinishedAt = &now
+	e.data.ElapsedMs = now.Sub(e.data.StartedAt).Milliseconds()
+	if statusCode >= http.StatusBadRequest || e.data.Error != "" {
+		e.data.State = InspectStateFailed
+	} else {
+		e.data.State = InspectStateCompleted
+	}
+	e.mu.Unlock()
+	e.inspector.complete(e)
+}
+
+// registerInspectorRoutes 注册请求检查器 API
+func registerInspectorRoutes(r *gin.Engine, inspector *RequestInspector, requireAuth bool) {
+	group := r.Group("/api/inspector")
+	if requireAuth {
+		group.Use(AdminAPIAuthGuard())
+	}
+
+	group.GET("/requests", func(c *gin.Context) {
+		requests := inspector.List()
+		c.JSON(http.StatusOK, gin.H{
+			"success":  true,
+			"requests": requests,
+			"inFlight": inspector.InFlightCount(),
+			"capacity": inspector.cfg.BufferSize,
+		})
+	})
+
+	group.GET("/requests/:id", AdminOnlyGuard(), func(c *gin.Context) {
+		request, ok := inspector.Get(c.Param("id"))
+		if !ok {
+			c.JSON(http.StatusNotFound, gin.H{
+				"success": false,
+				"error":   "请求记录不存在或已被淘汰",
+			})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"success": true,
+			"request": request,
+		})
+	})
+
+	group.GET("/stream", func(c *gin.Context) {
+		handleInspectorStream(c, inspector)
+	})
+}
+
+// handleInspectorStream 通过 SSE 推送请求变更
+// 连接建立时先发送 snapshot 事件（当前列表），之后每次变更发送 request 事件
+func handleInspectorStream(c *gin.Context, inspector *RequestInspector) {
+	updates, cancel := inspector.Subscribe()
+	defer cancel()
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+
+	c.SSEvent("snapshot", inspector.List())
+	c.Writer.Flush()
+
+	logger.Debug("请求检查器订阅建立", logger.String("user", GetSessionUser(c)))
+
+	heartbeat := time.NewTicker(inspectorHeartbeatInterval)
+	defer heartbeat.Stop()
+
+	ctx := c.Request.Context()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case update := <-updates:
+			c.SSEvent("request", update)
+			c.Writer.Flush()
+		case <-heartbeat.C:
+			if _, err := c.Writer.WriteString(": ping\n\n"); err != nil {
+				return
+			}
+			c.Writer.Flush()
+		}
+	}
+}
@@ -0,0 +1,244 @@
+package server
+
+import (
+	"sort"
+	"unicode/utf8"
+
+	"kiro2api/logger"
+	"kiro2api/utils"
+)
+
+// 本地 max_tokens 限制
+// 上游不支持限制输出长度，代理按 TokenEstimator 估算已下发的输出 token，达到 max_tokens 后截断输出并以 max_tokens 结束
+// 计算口径与响应 usage.output_tokens 一致：文本按 EstimateTextTokens，工具参数按 JSON 字节数/4（进一法），
+// 工具调用结构固定开销 12 token 加工具名称
+
+// toolUseStartTokens 工具调用块的结构开销（type/id/name 字段和工具名称）
+func toolUseStartTokens(estimator *utils.TokenEstimator, toolName string) int {
+	return 12 + estimator.EstimateTextTokens(toolName)
+}
+
+// jsonBytesTokens 工具参数 JSON 字节数对应的 token 数（进一法）
+func jsonBytesTokens(jsonBytes int) int {
+	return (jsonBytes + 3) / 4
+}
+
+// truncateTextToTokens 截断文本，使估算的 token 数不超过 budget
+func truncateTextToTokens(estimator *utils.TokenEstimator, text string, budget int) string {
+	if budget <= 0 {
+		return ""
+	}
+	runes := []rune(text)
+	// 二分查找满足预算的最长前缀
+	lo, hi := 0, len(runes)
+	for lo < hi {
+		mid := (lo + hi + 1) / 2
+		if estimator.EstimateTextTokens(string(runes[:mid])) <= budget {
+			lo = mid
+		} else {
+			hi = mid - 1
+		}
+	}
+	return string(runes[:lo])
+}
+
+// truncateUTF8 按字节数截断字符串，不拆分多字节字符
+func truncateUTF8(s string, maxBytes int) string {
+	if maxBytes <= 0 {
+		return ""
+	}
+	if len(s) <= maxBytes {
+		return s
+	}
+	for maxBytes > 0 && !utf8.RuneStart(s[maxBytes]) {
+		maxBytes--
+	}
+	return s[:maxBytes]
+}
+
+// outputTokensSoFar 已下发的输出 token 数（包含未结束工具块已下发的参数）
+func (ctx *StreamProcessorContext) outputTokensSoFar() int {
+	tokens := ctx.totalOutputTokens
+	for _, jsonBytes := range ctx.jsonBytesByBlockIndex {
+		tokens += jsonBytesTokens(jsonBytes)
+	}
+	return tokens
+}
+
+// markMaxTokensReached 达到 max_tokens，停止输出
+func (ctx *StreamProcessorContext) markMaxTokensReached() {
+	if ctx.stopped {
+		return
+	}
+	logger.Debug("输出达到max_tokens，截断输出",
+		addReqFields(ctx.c,
+			logger.Int("max_tokens", ctx.maxTokens),
+			logger.Int("output_tokens", ctx.outputTokensSoFar()),
+		)...)
+	ctx.stopReasonManager.SetMaxTokensReached()
+	ctx.stopped = true
+}
+
+// fitOutputText 返回在 max_tokens 预算内可以下发的文本，超出时截断并停止输出
+func (ctx *StreamProcessorContext) fitOutputText(text string) string {
+	if ctx.maxTokens <= 0 || text == "" {
+		return text
+	}
+	remaining := ctx.maxTokens - ctx.outputTokensSoFar()
+	if ctx.tokenEstimator.EstimateTextTokens(text) <= remaining {
+		return text
+	}
+	text = truncateTextToTokens(ctx.tokenEstimator, text, remaining)
+	ctx.markMaxTokensReached()
+	return text
+}
+
+// fitToolUseStart 检查预算是否足够开始新的工具调用块，不足时停止输出
+func (ctx *StreamProcessorContext) fitToolUseStart(toolName string) bool {
+	if ctx.maxTokens <= 0 {
+		return true
+	}
+	if ctx.outputTokensSoFar()+toolUseStartTokens(ctx.tokenEstimator, toolName) > ctx.maxTokens {
+		ctx.markMaxTokensReached()
+		return false
+	}
+	return true
+}
+
+// pendingToolUse 暂存的工具调用块事件
+type pendingToolUse struct {
+	name      string
+	events    []map[string]any
+	jsonBytes int
+}
+
+// bufferToolUseEvent 设置了 max_tokens 时暂存工具调用块的事件，返回 true 表示事件已被暂存
+// 工具参数只有完整时才是合法 JSON：块结束后按完整参数计算开销，预算足够时整块下发，否则丢弃并停止输出
+// （与非流式响应丢弃放不下的工具调用一致，不下发不完整的工具参数）
+func (esp *EventStreamProcessor) bufferToolUseEvent(eventType string, dataMap map[string]any) bool {
+	ctx := esp.ctx
+	if ctx.maxTokens <= 0 {
+		return false
+	}
+	index := extractIndex(dataMap)
+	switch eventType {
+	case "content_block_start":
+		cb, ok := dataMap["content_block"].(map[string]any)
+		if !ok || cb["type"] != "tool_use" || index < 0 {
+			return false
+		}
+		if ctx.pendingToolUses == nil {
+			ctx.pendingToolUses = make(map[int]*pendingToolUse)
+		}
+		ctx.pendingToolUses[index] = &pendingToolUse{name: getStringField(cb, "name"), events: []map[string]any{dataMap}}
+		return true
+	case "content_block_delta":
+		pending, ok := ctx.pendingToolUses[index]
+		if !ok {
+			return false
+		}
+		if delta, ok := dataMap["delta"].(map[string]any); ok {
+			partialJSON, _ := delta["partial_json"].(string)
+			pending.jsonBytes += len(partialJSON)
+		}
+		pending.events = append(pending.events, dataMap)
+		return true
+	case "content_block_stop":
+		pending, ok := ctx.pendingToolUses[index]
+		if !ok {
+			return false
+		}
+		delete(ctx.pendingToolUses, index)
+		pending.events = append(pending.events, dataMap)
+		esp.releaseToolUse(pending)
+		return true
+	}
+	return false
+}
+
+// releaseToolUse 预算足够时下发暂存的工具调用块，否则丢弃并停止输出
+func (esp *EventStreamProcessor) releaseToolUse(pending *pendingToolUse) {
+	ctx := esp.ctx
+	if ctx.stopped {
+		return
+	}
+	cost := toolUseStartTokens(ctx.tokenEstimator, pending.name) + jsonBytesTokens(pending.jsonBytes)
+	if ctx.outputTokensSoFar()+cost > ctx.maxTokens {
+		ctx.markMaxTokensReached()
+		return
+	}
+	for _, event := range pending.events {
+		eventType, _ := event["type"].(string)
+		esp.forwardEvent(eventType, event)
+	}
+}
+
+// flushPendingToolUses 上游流结束时处理未结束的工具调用块（按索引顺序）
+func (esp *EventStreamProcessor) flushPendingToolUses() {
+	indexes := make([]int, 0, len(esp.ctx.pendingToolUses))
+	for index := range esp.ctx.pendingToolUses {
+		indexes = append(indexes, index)
+	}
+	sort.Ints(indexes)
+	for _, index := range indexes {
+		pending := esp.ctx.pendingToolUses[index]
+		delete(esp.ctx.pendingToolUses, index)
+		esp.releaseToolUse(pending)
+	}
+}
+
+// truncateContentToMaxTokens 按 max_tokens 截断非流式响应的内容块
+// 思考和文本内容按剩余预算截断，放不下的工具调用（包括代理执行的搜索调用）整块丢弃（不下发不完整的工具参数）
+// 返回截断后的内容块和是否发生了截断
+func truncateContentToMaxTokens(estimator *utils.TokenEstimator, contents []map[string]any, maxTokens int) ([]map[string]any, bool) {
+	if maxTokens <= 0 {
+		return contents, false
+	}
+
+	remaining := maxTokens
+	result := make([]map[string]any, 0, len(contents))
+	for _, block := range contents {
+		blockType, _ := block["type"].(string)
+		textField := ""
+		switch blockType {
+		case "text":
+			textField = "text"
+		case "thinking":
+			textField = "thinking"
+		case "tool_use", webSearchServerToolUseBlock:
+			toolName, _ := block["name"].(string)
+			toolInput, _ := block["input"].(map[string]any)
+			cost := estimator.EstimateToolUseTokens(toolName, toolInput)
+			if cost > remaining {
+				return result, true
+			}
+			remaining -= cost
+			result = append(result, block)
+			continue
+		default:
+			result = append(result, block)
+			continue
+		}
+
+		text, _ := block[textField].(string)
+		cost := estimator.EstimateTextTokens(text)
+		if cost <= remaining {
+			remaining -= cost
+			result = append(result, block)
+			continue
+		}
+
+		// 预算不足：截断当前块，丢弃之后的内容
+		text = truncateTextToTokens(estimator, text, remaining)
+		if text != "" {
+			truncated := make(map[string]any, len(block))
+			for k, v := range block {
+				truncated[k] = v
+			}
+			truncated[textField] = text
+			result = append(result, truncated)
+		}
+		return result, true
+	}
+	return result, false
+}
@@ -0,0 +1,142 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"kiro2api/parser"
+	"kiro2api/types"
+	"kiro2api/utils"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newMaxTokensTestContext 创建带 max_tokens 限制的流处理上下文
+func newMaxTokensTestContext(t *testing.T, maxTokens int) (*StreamProcessorContext, *recordingSender) {
+	gin.SetMode(gin.TestMode)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	sender := &recordingSender{}
+	req := types.AnthropicRequest{Model: "claude-sonnet-4-20250514", MaxTokens: maxTokens}
+	return NewStreamProcessorContext(c, req, nil, sender, "msg_test", 10), sender
+}
+
+func TestTruncateTextToTokens(t *testing.T) {
+	estimator := utils.NewTokenEstimator()
+	text := strings.Repeat("hello world ", 50)
+
+	truncated := truncateTextToTokens(estimator, text, 10)
+	assert.LessOrEqual(t, estimator.EstimateTextTokens(truncated), 10)
+	assert.True(t, strings.HasPrefix(text, truncated))
+	assert.Empty(t, truncateTextToTokens(estimator, text, 0))
+
+	assert.Equal(t, "你", truncateUTF8("你好", 4))
+}
+
+func TestStreamProcessor_MaxTokensTruncatesText(t *testing.T) {
+	ctx, sender := newMaxTokensTestContext(t, 5)
+	esp := NewEventStreamProcessor(ctx)
+
+	require.NoError(t, esp.processEvent(textDeltaEvent("Sure, here is a very long answer that keeps going")))
+	require.True(t, ctx.stopped)
+	require.NoError(t, esp.processEvent(textDeltaEvent("ignored")))
+	require.NoError(t, ctx.sendFinalEvents())
+
+	final := sender.events[len(sender.events)-2]
+	assert.Equal(t, "max_tokens", final["delta"].(map[string]any)["stop_reason"])
+	outputTokens := final["usage"].(map[string]any)["output_tokens"].(int)
+	assert.LessOrEqual(t, outputTokens, 5)
+	assert.Greater(t, outputTokens, 0)
+}
+
+// toolUseEvents 工具调用块的上游事件，参数按 chunks 分段下发
+func toolUseEvents(index int, name string, chunks ...string) []parser.SSEEvent {
+	events := []parser.SSEEvent{{Data: map[string]any{"type": "content_block_start", "index": index,
+		"content_block": map[string]any{"type": "tool_use", "id": "tooluse_" + name, "name": name, "input": map[string]any{}}}}}
+	for _, chunk := range chunks {
+		events = append(events, parser.SSEEvent{Data: map[string]any{"type": "content_block_delta", "index": index,
+			"delta": map[string]any{"type": "input_json_delta", "partial_json": chunk}}})
+	}
+	return append(events, parser.SSEEvent{Data: map[string]any{"type": "content_block_stop", "index": index}})
+}
+
+// streamedToolInputs 按块索引拼接下发的工具参数
+func streamedToolInputs(events []map[string]any) map[int]string {
+	inputs := make(map[int]string)
+	for _, e := range events {
+		if delta, ok := e["delta"].(map[string]any); ok && delta["type"] == "input_json_delta" {
+			inputs[e["index"].(int)] += delta["partial_json"].(string)
+		}
+	}
+	return inputs
+}
+
+func TestStreamProcessor_MaxTokensDropsToolUseThatDoesNotFit(t *testing.T) {
+	ctx, sender := newMaxTokensTestContext(t, 30)
+	esp := NewEventStreamProcessor(ctx)
+
+	events := append(toolUseEvents(0, "read", `{"path":`, `"/tmp/a"}`),
+		toolUseEvents(1, "write", `{"content":"`, strings.Repeat("x", 100), `"}`)...)
+	for _, event := range events {
+		require.NoError(t, esp.processEvent(event))
+	}
+	require.True(t, ctx.stopped)
+	require.NoError(t, ctx.sendFinalEvents())
+
+	assert.Equal(t, []string{
+		"content_block_start#0:tool_use",
+		"content_block_delta#0:input_json_delta",
+		"content_block_delta#0:input_json_delta",
+		"content_block_stop#0",
+		"message_delta",
+		"message_stop",
+	}, sseEventSequence(sender.events))
+
+	// 下发的工具参数都是完整的 JSON
+	inputs := streamedToolInputs(sender.events)
+	require.Len(t, inputs, 1)
+	for _, input := range inputs {
+		assert.True(t, json.Valid([]byte(input)), input)
+	}
+
+	final := sender.events[4]
+	assert.Equal(t, "max_tokens", final["delta"].(map[string]any)["stop_reason"])
+	assert.LessOrEqual(t, final["usage"].(map[string]any)["output_tokens"], 30)
+}
+
+func TestStreamProcessor_MaxTokensKeepsToolUseThatFits(t *testing.T) {
+	ctx, sender := newMaxTokensTestContext(t, 1000)
+	esp := NewEventStreamProcessor(ctx)
+
+	for _, event := range toolUseEvents(0, "write", `{"content":"`, strings.Repeat("x", 100), `"}`) {
+		require.NoError(t, esp.processEvent(event))
+	}
+	require.False(t, ctx.stopped)
+	require.NoError(t, ctx.sendFinalEvents())
+
+	inputs := streamedToolInputs(sender.events)
+	assert.Equal(t, `{"content":"`+strings.Repeat("x", 100)+`"}`, inputs[0])
+	final := sender.events[len(sender.events)-2]
+	assert.Equal(t, "tool_use", final["delta"].(map[string]any)["stop_reason"])
+}
+
+func TestTruncateContentToMaxTokens(t *testing.T) {
+	estimator := utils.NewTokenEstimator()
+	contents := []map[string]any{
+		{"type": "text", "text": strings.Repeat("word ", 40)},
+		{"type": "tool_use", "id": "tooluse_1", "name": "read", "input": map[string]any{"path": "/tmp/a"}},
+	}
+
+	result, truncated := truncateContentToMaxTokens(estimator, contents, 10)
+	assert.True(t, truncated)
+	require.Len(t, result, 1)
+	assert.LessOrEqual(t, estimator.EstimateTextTokens(result[0]["text"].(string)), 10)
+	assert.Len(t, contents[0]["text"], 200) // 不修改原内容块
+
+	result, truncated = truncateContentToMaxTokens(estimator, contents, 10000)
+	assert.False(t, truncated)
+	assert.Len(t, result, 2)
+}
@@ -0,0 +1,245 @@
+package server
+
+import (
+	"crypto/subtle"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"kiro2api/auth"
+	"kiro2api/config"
+	"kiro2api/logger"
+	"kiro2api/metrics"
+	"kiro2api/tracing"
+	"kiro2api/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// metricsPath Prometheus 抓取路径
+	metricsPath = "/metrics"
+	// clientTokenLabelKey context 中保存客户端令牌的指标标识（名称或脱敏预览）
+	clientTokenLabelKey = "client_token_label"
+	// requestModelKey context 中保存请求的模型（已归一化为指标标签）
+	requestModelKey = "request_model"
+	// unknownModelLabel 不在模型映射表中的模型统一归类，避免客户端随意传值导致标签基数爆炸
+	unknownModelLabel = "unknown"
+)
+
+// MetricsConfig 指标端点配置
+type MetricsConfig struct {
+	Enabled bool   // 是否启用 /metrics
+	Port    string // 独立监听端口（为空时挂在主服务上）
+	Token   string // 可选的抓取令牌（Authorization: Bearer <token>）
+}
+
+// LoadMetricsConfigFromEnv 从环境变量读取指标配置
+func LoadMetricsConfigFromEnv() MetricsConfig {
+	return MetricsConfig{
+		Enabled: utils.GetEnvBoolWithDefault("METRICS_ENABLED", true),
+		Port:    utils.GetEnvWithDefault("METRICS_PORT", ""),
+		Token:   utils.GetEnvWithDefault("METRICS_TOKEN", ""),
+	}
+}
+
+// MetricsMiddleware 按路由、模型、客户端令牌和状态码统计请求
+func MetricsMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		status := strconv.Itoa(c.Writer.Status())
+		metrics.RequestsTotal.Inc(route, c.GetString(requestModelKey), c.GetString(clientTokenLabelKey), status)
+		metrics.RequestDuration.Observe(time.Since(start).Seconds(), route)
+	}
+}
+
+// metricsModelLabel 将模型名归一化为指标标签
+func metricsModelLabel(model string) string {
+	if _, ok := config.ModelMap[model]; ok {
+		return model
+	}
+	return unknownModelLabel
+}
+
+// setRequestModel 记录本次请求的模型，供请求计数使用
+func setRequestModel(c *gin.Context, model string) {
+	c.Set(requestModelKey, metricsModelLabel(model))
+}
+
+// recordEstimatedTokens 累计估算的输入/输出 token
+func recordEstimatedTokens(c *gin.Context, model string, inputTokens, outputTokens int) {
+	label := metricsModelLabel(model)
+	client := c.GetString(clientTokenLabelKey)
+	metrics.EstimatedTokens.Add(float64(inputTokens), label, client, "input")
+	metrics.EstimatedTokens.Add(float64(outputTokens), label, client, "output")
+	setRequestSpanAttributes(c,
+		tracing.Int("gen_ai.usage.input_tokens", inputTokens),
+		tracing.Int("gen_ai.usage.output_tokens", outputTokens))
+	inspectorEntryFrom(c).setTokens(inputTokens, outputTokens)
+	c.Set(requestInputTokensKey, inputTokens)
+	c.Set(requestOutputTokensKey, outputTokens)
+}
+
+// observeStreamDuration 记录流式响应耗时（用于 defer）
+func observeStreamDuration(api, model string, start time.Time) {
+	metrics.StreamDuration.Observe(time.Since(start).Seconds(), api, metricsModelLabel(model))
+}
+
+// ttfbReader 包装上游响应体，在读取到第一个字节时记录 TTFB
+type ttfbReader struct {
+	io.ReadCloser
+	start time.Time
+	model string
+	once  sync.Once
+}
+
+func (r *ttfbReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	if n > 0 {
+		r.once.Do(func() {
+			metrics.UpstreamTTFB.Observe(time.Since(r.start).Seconds(), r.model)
+		})
+	}
+	return n, err
+}
+
+// registerAccountPoolMetrics 注册账号池仪表（抓取时从 TokenManager 实时计算）
+// 熔断状态：账号被标记为耗尽后会在轮换中被跳过，直到刷新成功才恢复，
+// 这里将该标记作为账号的熔断状态暴露（1=熔断）
+func registerAccountPoolMetrics(registry *metrics.Registry, authService *auth.AuthService) {
+	collectors := []metrics.Collector{
+		metrics.NewGaugeFunc("kiro2api_account_pool_accounts",
+			"Number of upstream accounts by state.",
+			[]string{"state"},
+			func() []metrics.Sample {
+				counts := map[string]float64{}
+				for _, state := range accountStates {
+					counts[state] = 0
+				}
+				for _, status := range authService.GetAllCacheStatus() {
+					counts[accountState(status)]++
+				}
+				samples := make([]metrics.Sample, 0, len(counts))
+				for state, count := range counts {
+					samples = append(samples, metrics.Sample{LabelValues: []string{state}, Value: count})
+				}
+				return samples
+			}),
+		metrics.NewGaugeFunc("kiro2api_account_pool_available_credit",
+			"Total remaining credit across usable accounts.",
+			nil,
+			func() []metrics.Sample {
+				total := 0.0
+				for _, status := range authService.GetAllCacheStatus() {
+					if accountState(status) == "usable" {
+						total += status.Available
+					}
+				}
+				return []metrics.Sample{{Value: total}}
+			}),
+		metrics.NewGaugeFunc("kiro2api_account_available_credit",
+			"Remaining credit per account (by config index).",
+			[]string{"account"},
+			func() []metrics.Sample {
+				statuses := authService.GetAllCacheStatus()
+				samples := make([]metrics.Sample, 0, len(statuses))
+				for _, status := range statuses {
+					samples = append(samples, metrics.Sample{
+						LabelValues: []string{strconv.Itoa(status.Index)},
+						Value:       status.Available,
+					})
+				}
+				return samples
+			}),
+		metrics.NewGaugeFunc("kiro2api_account_circuit_open",
+			"Whether the account is tripped (1) and skipped by rotation until a successful refresh.",
+			[]string{"account"},
+			func() []metrics.Sample {
+				statuses := authService.GetAllCacheStatus()
+				samples := make([]metrics.Sample, 0, len(statuses))
+				for _, status := range statuses {
+					open := 0.0
+					if status.Exhausted {
+						open = 1
+					}
+					samples = append(samples, metrics.Sample{
+						LabelValues: []string{strconv.Itoa(status.Index)},
+						Value:       open,
+					})
+				}
+				return samples
+			}),
+	}
+
+	for _, collector := range collectors {
+		// 重复注册时替换旧的采集函数
+		registry.Unregister(collector.Name())
+		registry.MustRegister(collector)
+	}
+}
+
+// accountStates 账号状态枚举（保证每个状态都有输出，便于告警规则编写）
+var accountStates = []string{"usable", "exhausted", "expired", "refreshing", "disabled", "unknown"}
+
+// accountState 将账号缓存状态归类为单一状态
+func accountState(status auth.TokenCacheStatus) string {
+	switch {
+	case status.Disabled:
+		return "disabled"
+	case status.Refreshing:
+		return "refreshing"
+	case status.Exhausted:
+		return "exhausted"
+	case !status.Cached:
+		return "unknown"
+	case time.Now().After(status.Token.ExpiresAt):
+		return "expired"
+	case status.Available <= 0:
+		return "exhausted"
+	default:
+		return "usable"
+	}
+}
+
+// metricsHandler 输出 Prometheus 指标，配置了令牌时要求 Bearer 认证
+func metricsHandler(registry *metrics.Registry, token string) gin.HandlerFunc {
+	handler := registry.Handler()
+	return func(c *gin.Context) {
+		if token != "" {
+			provided := extractAPIKey(c)
+			if subtle.ConstantTimeCompare([]byte(provided), []byte(token)) != 1 {
+				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "401"})
+				return
+			}
+		}
+		handler.ServeHTTP(c.Writer, c.Request)
+	}
+}
+
+// startMetricsServer 在独立端口上暴露 /metrics
+func startMetricsServer(cfg MetricsConfig, registry *metrics.Registry) {
+	r := gin.New()
+	r.Use(gin.Recovery())
+	r.GET(metricsPath, metricsHandler(registry, cfg.Token))
+
+	server := &http.Server{
+		Addr:              ":" + cfg.Port,
+		Handler:           r,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		logger.Info("启动指标服务器", logger.String("port", cfg.Port))
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			logger.Error("启动指标服务器失败", logger.Err(err), logger.String("port", cfg.Port))
+		}
+	}()
+}
@@ -72,14 +72,18 @@ func GetMessageID(c *gin.Context) string {
 func addReqFields(c *gin.Context, fields ...logger.Field) []logger.Field {
 	rid := GetRequestID(c)
 	mid := GetMessageID(c)
+	tid := getTraceID(c)
 	// 预留容量避免重复分配
-	out := make([]logger.Field, 0, len(fields)+2)
+	out := make([]logger.Field, 0, len(fields)+3)
 	if rid != "" {
 		out = append(out, logger.String("request_id", rid))
 	}
 	if mid != "" {
 		out = append(out, logger.String("message_id", mid))
 	}
+	if tid != "" {
+		out = append(out, logger.String("trace_id", tid))
+	}
 	out = append(out, fields...)
 	return out
 }
@@ -150,5 +154,12 @@ func validateAPIKeyWithManager(c *gin.Context, manager *auth.ClientTokenManager)
 		return false
 	}
 
+	// 记录客户端令牌标识，供指标按令牌维度统计（优先使用名称，避免暴露令牌）
+	label := manager.TokenName(providedApiKey)
+	if label == "" {
+		label = createTokenPreview(providedApiKey)
+	}
+	c.Set(clientTokenLabelKey, label)
+
 	return true
 }
@@ -0,0 +1,520 @@
+package server
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"kiro2api/utils"
+)
+
+const (
+	// oidcPendingTTL 授权请求（state）的有效期
+	oidcPendingTTL = 10 * time.Minute
+	// oidcClockSkew 校验 exp/iat/nbf 时允许的时钟偏差
+	oidcClockSkew = time.Minute
+	// oidcHTTPTimeout 访问 IdP 的超时时间
+	oidcHTTPTimeout = 10 * time.Second
+	// oidcJWKSRefreshInterval 遇到未知 kid 时重新拉取 JWKS 的最小间隔
+	oidcJWKSRefreshInterval = time.Minute
+)
+
+// OIDCConfig OpenID Connect 单点登录配置
+type OIDCConfig struct {
+	Issuer        string
+	ClientID      string
+	ClientSecret  string
+	RedirectURL   string
+	Scopes        []string
+	DisplayName   string   // 登录页按钮显示的名称
+	UsernameClaim string   // 作为用户名的 claim，默认 email
+	GroupsClaim   string   // 用户组 claim，默认 groups
+	AdminGroups   []string // 映射为 admin 角色的用户组
+	ViewerGroups  []string // 映射为 viewer 角色的用户组
+}
+
+// LoadOIDCConfigFromEnv 从环境变量加载OIDC配置，未配置 OIDC_ISSUER/OIDC_CLIENT_ID 时返回 false
+func LoadOIDCConfigFromEnv() (OIDCConfig, bool) {
+	cfg := OIDCConfig{
+		Issuer:        strings.TrimRight(os.Getenv("OIDC_ISSUER"), "/"),
+		ClientID:      os.Getenv("OIDC_CLIENT_ID"),
+		ClientSecret:  os.Getenv("OIDC_CLIENT_SECRET"),
+		RedirectURL:   os.Getenv("OIDC_REDIRECT_URL"),
+		Scopes:        splitCSV(utils.GetEnvWithDefault("OIDC_SCOPES", "openid,profile,email")),
+		DisplayName:   utils.GetEnvWithDefault("OIDC_DISPLAY_NAME", "SSO"),
+		UsernameClaim: utils.GetEnvWithDefault("OIDC_USERNAME_CLAIM", "email"),
+		GroupsClaim:   utils.GetEnvWithDefault("OIDC_GROUPS_CLAIM", "groups"),
+		AdminGroups:   splitCSV(os.Getenv("OIDC_ADMIN_GROUPS")),
+		ViewerGroups:  splitCSV(os.Getenv("OIDC_VIEWER_GROUPS")),
+	}
+	return cfg, cfg.Issuer != "" && cfg.ClientID != ""
+}
+
+// MapRole 根据用户组映射角色，无匹配时返回空字符串（拒绝登录）
+// 未配置用户组映射时同样拒绝，避免 IdP 能认证的任何账号（如公共的 Google 账号）成为管理员
+func (cfg OIDCConfig) MapRole(groups []string) string {
+	if containsAny(groups, cfg.AdminGroups) {
+		return RoleAdmin
+	}
+	if containsAny(groups, cfg.ViewerGroups) {
+		return RoleViewer
+	}
+	return ""
+}
+
+// OIDCIdentity 通过 IdP 认证后的用户身份
+type OIDCIdentity struct {
+	Subject  string
+	Username string
+	Groups   []string
+	Role     string
+}
+
+// oidcDiscovery OpenID Provider 元数据（仅包含使用到的字段）
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// oidcPending 等待回调的授权请求
+type oidcPending struct {
+	verifier  string
+	nonce     string
+	createdAt time.Time
+}
+
+// OIDCProvider OIDC 授权码 + PKCE 登录流程
+type OIDCProvider struct {
+	cfg    OIDCConfig
+	client *http.Client
+	now    func() time.Time
+
+	mu            sync.Mutex
+	discovery     *oidcDiscovery
+	keys          map[string]*rsa.PublicKey
+	keysFetchedAt time.Time
+	pending       map[string]oidcPending
+}
+
+// NewOIDCProvider 创建OIDC提供方（元数据在首次使用时发现并缓存）
+func NewOIDCProvider(cfg OIDCConfig, client *http.Client) *OIDCProvider {
+	if client == nil {
+		client = utils.SharedHTTPClient
+	}
+	return &OIDCProvider{
+		cfg:     cfg,
+		client:  client,
+		now:     time.Now,
+		keys:    make(map[string]*rsa.PublicKey),
+		pending: make(map[string]oidcPending),
+	}
+}
+
+// DisplayName 返回登录按钮显示名称
+func (p *OIDCProvider) DisplayName() string {
+	return p.cfg.DisplayName
+}
+
+// AuthCodeURL 生成授权地址，返回地址和 state
+func (p *OIDCProvider) AuthCodeURL(ctx context.Context) (string, string, error) {
+	disc, err := p.discover(ctx)
+	if err != nil {
+		return "", "", err
+	}
+
+	state, err := randomURLSafe(24)
+	if err != nil {
+		return "", "", err
+	}
+	nonce, err := randomURLSafe(24)
+	if err != nil {
+		return "", "", err
+	}
+	verifier, err := randomURLSafe(48)
+	if err != nil {
+		return "", "", err
+	}
+
+	p.mu.Lock()
+	p.prunePendingLocked()
+	p.pending[state] = oidcPending{verifier: verifier, nonce: nonce, createdAt: p.now()}
+	p.mu.Unlock()
+
+	challenge := sha256.Sum256([]byte(verifier))
+	params := url.Values{}
+	params.Set("response_type", "code")
+	params.Set("client_id", p.cfg.ClientID)
+	params.Set("redirect_uri", p.cfg.RedirectURL)
+	params.Set("scope", strings.Join(p.cfg.Scopes, " "))
+	params.Set("state", state)
+	params.Set("nonce", nonce)
+	params.Set("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:]))
+	params.Set("code_challenge_method", "S256")
+
+	sep := "?"
+	if strings.Contains(disc.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return disc.AuthorizationEndpoint + sep + params.Encode(), state, nil
+}
+
+// Exchange 使用授权码换取并校验 ID Token，返回用户身份
+func (p *OIDCProvider) Exchange(ctx context.Context, state, code string) (OIDCIdentity, error) {
+	p.mu.Lock()
+	pending, ok := p.pending[state]
+	delete(p.pending, state)
+	p.mu.Unlock()
+
+	if !ok || p.now().Sub(pending.createdAt) > oidcPendingTTL {
+		return OIDCIdentity{}, fmt.Errorf("登录请求已过期或无效，请重新登录")
+	}
+
+	disc, err := p.discover(ctx)
+	if err != nil {
+		return OIDCIdentity{}, err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.cfg.RedirectURL)
+	form.Set("client_id", p.cfg.ClientID)
+	form.Set("code_verifier", pending.verifier)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, disc.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return OIDCIdentity{}, fmt.Errorf("创建令牌请求失败: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.cfg.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.cfg.ClientID), url.QueryEscape(p.cfg.ClientSecret))
+	}
+
+	var tokenResp struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := p.doJSON(req, &tokenResp); err != nil {
+		return OIDCIdentity{}, fmt.Errorf("换取令牌失败: %w", err)
+	}
+	if tokenResp.Error != "" {
+		return OIDCIdentity{}, fmt.Errorf("换取令牌失败: %s %s", tokenResp.Error, tokenResp.ErrorDescription)
+	}
+	if tokenResp.IDToken == "" {
+		return OIDCIdentity{}, fmt.Errorf("令牌响应中缺少 id_token")
+	}
+
+	claims, err := p.verifyIDToken(ctx, tokenResp.IDToken, disc.Issuer, pending.nonce)
+	if err != nil {
+		return OIDCIdentity{}, err
+	}
+
+	identity := OIDCIdentity{
+		Subject: claimString(claims, "sub"),
+		Groups:  claimStrings(claims, p.cfg.GroupsClaim),
+	}
+	for _, name := range []string{p.cfg.UsernameClaim, "preferred_username", "email", "sub"} {
+		if v := claimString(claims, name); v != "" {
+			identity.Username = v
+			break
+		}
+	}
+	identity.Role = p.cfg.MapRole(identity.Groups)
+	if identity.Role == "" {
+		return identity, fmt.Errorf("用户 %s 不属于任何允许登录的用户组", identity.Username)
+	}
+	return identity, nil
+}
+
+// verifyIDToken 校验 ID Token 签名（RS256）和标准 claims
+func (p *OIDCProvider) verifyIDToken(ctx context.Context, token, issuer, nonce string) (map[string]any, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("id_token 格式无效")
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeJWTSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("解析 id_token 头失败: %w", err)
+	}
+	if header.Alg != "RS256" {
+		return nil, fmt.Errorf("不支持的 id_token 签名算法: %s", header.Alg)
+	}
+
+	key, err := p.publicKey(ctx, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("解析 id_token 签名失败: %w", err)
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], sig); err != nil {
+		return nil, fmt.Errorf("id_token 签名校验失败")
+	}
+
+	var claims map[string]any
+	if err := decodeJWTSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("解析 id_token 内容失败: %w", err)
+	}
+
+	now := p.now()
+	if claimString(claims, "iss") != issuer {
+		return nil, fmt.Errorf("id_token issuer 不匹配")
+	}
+	if !containsAny(claimStrings(claims, "aud"), []string{p.cfg.ClientID}) {
+		return nil, fmt.Errorf("id_token audience 不匹配")
+	}
+	exp, ok := claims["exp"].(float64)
+	if !ok || now.After(time.Unix(int64(exp), 0).Add(oidcClockSkew)) {
+		return nil, fmt.Errorf("id_token 已过期")
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Add(oidcClockSkew).Before(time.Unix(int64(nbf), 0)) {
+		return nil, fmt.Errorf("id_token 尚未生效")
+	}
+	if claimString(claims, "nonce") != nonce {
+		return nil, fmt.Errorf("id_token nonce 不匹配")
+	}
+	return claims, nil
+}
+
+// discover 获取并缓存 OpenID Provider 元数据
+func (p *OIDCProvider) discover(ctx context.Context) (*oidcDiscovery, error) {
+	p.mu.Lock()
+	if p.discovery != nil {
+		disc := p.discovery
+		p.mu.Unlock()
+		return disc, nil
+	}
+	p.mu.Unlock()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.cfg.Issuer+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return nil, fmt.Errorf("创建发现请求失败: %w", err)
+	}
+
+	var disc oidcDiscovery
+	if err := p.doJSON(req, &disc); err != nil {
+		return nil, fmt.Errorf("OIDC 发现失败: %w", err)
+	}
+	if strings.TrimRight(disc.Issuer, "/") != p.cfg.Issuer {
+		return nil, fmt.Errorf("OIDC 发现失败: issuer 不匹配 (%s)", disc.Issuer)
+	}
+	if disc.AuthorizationEndpoint == "" || disc.TokenEndpoint == "" || disc.JWKSURI == "" {
+		return nil, fmt.Errorf("OIDC 发现失败: 元数据不完整")
+	}
+
+	p.mu.Lock()
+	p.discovery = &disc
+	p.mu.Unlock()
+	return &disc, nil
+}
+
+// publicKey 按 kid 获取签名公钥，未命中时重新拉取 JWKS（处理密钥轮换）
+func (p *OIDCProvider) publicKey(ctx context.Context, kid string) (*rsa.PublicKey, error) {
+	p.mu.Lock()
+	key, ok := p.lookupKeyLocked(kid)
+	canRefresh := p.now().Sub(p.keysFetchedAt) > oidcJWKSRefreshInterval || len(p.keys) == 0
+	p.mu.Unlock()
+	if ok {
+		return key, nil
+	}
+	if !canRefresh {
+		return nil, fmt.Errorf("未找到 id_token 签名密钥: %s", kid)
+	}
+
+	disc, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := p.fetchJWKS(ctx, disc.JWKSURI)
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.keys = keys
+	p.keysFetchedAt = p.now()
+	if key, ok := p.lookupKeyLocked(kid); ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("未找到 id_token 签名密钥: %s", kid)
+}
+
+// lookupKeyLocked 查找公钥；kid 为空且只有一个密钥时直接使用（调用时需持有锁）
+func (p *OIDCProvider) lookupKeyLocked(kid string) (*rsa.PublicKey, bool) {
+	if key, ok := p.keys[kid]; ok {
+		return key, true
+	}
+	if kid == "" && len(p.keys) == 1 {
+		for _, key := range p.keys {
+			return key, true
+		}
+	}
+	return nil, false
+}
+
+// fetchJWKS 拉取并解析 JWKS 中的 RSA 公钥
+func (p *OIDCProvider) fetchJWKS(ctx context.Context, jwksURI string) (map[string]*rsa.PublicKey, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, jwksURI, nil)
+	if err != nil {
+		return nil, fmt.Errorf("创建 JWKS 请求失败: %w", err)
+	}
+
+	var jwks struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Use string `json:"use"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	if err := p.doJSON(req, &jwks); err != nil {
+		return nil, fmt.Errorf("获取 JWKS 失败: %w", err)
+	}
+
+	keys := make(map[string]*rsa.PublicKey)
+	for _, k := range jwks.Keys {
+		if k.Kty != "RSA" || (k.Use != "" && k.Use != "sig") {
+			continue
+		}
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			continue
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil || len(e) == 0 || len(e) > 4 {
+			continue
+		}
+		keys[k.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("JWKS 中没有可用的 RSA 签名密钥")
+	}
+	return keys, nil
+}
+
+// doJSON 发送请求并解析 JSON 响应（带超时）
+func (p *OIDCProvider) doJSON(req *http.Request, out any) error {
+	ctx, cancel := context.WithTimeout(req.Context(), oidcHTTPTimeout)
+	defer cancel()
+
+	resp, err := p.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	// 令牌端点的错误响应同样是 JSON，交由调用方处理 error 字段
+	if resp.StatusCode >= 300 && !(resp.StatusCode == http.StatusBadRequest && json.Valid(body)) {
+		return fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+	return json.Unmarshal(body, out)
+}
+
+// prunePendingLocked 清理过期的授权请求（调用时需持有锁）
+func (p *OIDCProvider) prunePendingLocked() {
+	now := p.now()
+	for state, pending := range p.pending {
+		if now.Sub(pending.createdAt) > oidcPendingTTL {
+			delete(p.pending, state)
+		}
+	}
+}
+
+// decodeJWTSegment 解码 JWT 的 base64url 段
+func decodeJWTSegment(segment string, out any) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, out)
+}
+
+// claimString 读取字符串类型的 claim
+func claimString(claims map[string]any, name string) string {
+	if v, ok := claims[name].(string); ok {
+		return v
+	}
+	return ""
+}
+
+// claimStrings 读取字符串或字符串数组类型的 claim
+func claimStrings(claims map[string]any, name string) []string {
+	switch v := claims[name].(type) {
+	case string:
+		return []string{v}
+	case []any:
+		result := make([]string, 0, len(v))
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				result = append(result, s)
+			}
+		}
+		return result
+	}
+	return nil
+}
+
+// containsAny 判断两个列表是否有交集
+func containsAny(values, candidates []string) bool {
+	for _, v := range values {
+		for _, c := range candidates {
+			if v == c {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// splitCSV 拆分逗号分隔的配置项，忽略空白项
+func splitCSV(s string) []string {
+	var result []string
+	for _, part := range strings.Split(s, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			result = append(result, part)
+		}
+	}
+	return result
+}
+
+// randomURLSafe 生成 base64url 编码的随机字符串
+func randomURLSafe(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("生成随机数失败: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -0,0 +1,132 @@
+package server
+
+import (
+	"net/http"
+	"net/url"
+
+	"kiro2api/logger"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// oidcStateCookieName 绑定授权请求和浏览器的 state cookie
+	oidcStateCookieName = "kiro_oidc_state"
+)
+
+// SetOIDCProvider 启用 OIDC 单点登录
+func (h *AuthHandlers) SetOIDCProvider(provider *OIDCProvider) {
+	h.oidc = provider
+}
+
+// registerOIDCRoutes 注册 OIDC 登录路由
+func registerOIDCRoutes(r *gin.Engine, h *AuthHandlers) {
+	r.GET("/api/oidc/status", h.HandleOIDCStatus)
+	r.GET("/api/oidc/login", h.HandleOIDCLogin)
+	r.GET("/api/oidc/callback", h.HandleOIDCCallback)
+}
+
+// HandleOIDCStatus 返回 SSO 是否可用（供登录页展示按钮）
+func (h *AuthHandlers) HandleOIDCStatus(c *gin.Context) {
+	if h.oidc == nil {
+		c.JSON(http.StatusOK, gin.H{
+			"enabled":       false,
+			"local_enabled": h.adminPass != "",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"enabled":       true,
+		"name":          h.oidc.DisplayName(),
+		"local_enabled": h.adminPass != "",
+	})
+}
+
+// HandleOIDCLogin 跳转到 IdP 授权页
+func (h *AuthHandlers) HandleOIDCLogin(c *gin.Context) {
+	if h.oidc == nil {
+		respondError(c, http.StatusNotFound, "%s", "SSO 未启用")
+		return
+	}
+
+	ip := c.ClientIP()
+	if !h.limiter.Allow(ip) {
+		h.redirectLoginError(c, "登录尝试过于频繁，请稍后再试")
+		return
+	}
+
+	authURL, state, err := h.oidc.AuthCodeURL(c.Request.Context())
+	if err != nil {
+		logger.Error("生成 OIDC 授权地址失败", logger.Err(err))
+		h.redirectLoginError(c, "SSO 服务暂不可用")
+		return
+	}
+
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(oidcStateCookieName, state, int(oidcPendingTTL.Seconds()), "/api/oidc", "", h.secureCookie, true)
+	c.Redirect(http.StatusFound, authURL)
+}
+
+// HandleOIDCCallback 处理 IdP 回调，校验通过后创建会话
+func (h *AuthHandlers) HandleOIDCCallback(c *gin.Context) {
+	if h.oidc == nil {
+		respondError(c, http.StatusNotFound, "%s", "SSO 未启用")
+		return
+	}
+
+	ip := c.ClientIP()
+	// state cookie 只使用一次
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(oidcStateCookieName, "", -1, "/api/oidc", "", h.secureCookie, true)
+
+	if errCode := c.Query("error"); errCode != "" {
+		logger.Warn("IdP 返回授权错误",
+			logger.String("error", errCode),
+			logger.String("description", c.Query("error_description")),
+			logger.String("ip", ip))
+		h.redirectLoginError(c, "SSO 授权失败: "+errCode)
+		return
+	}
+
+	state := c.Query("state")
+	cookieState, err := c.Cookie(oidcStateCookieName)
+	if err != nil || state == "" || cookieState != state {
+		logger.Warn("OIDC 回调 state 校验失败", logger.String("ip", ip))
+		h.redirectLoginError(c, "登录请求无效，请重新登录")
+		return
+	}
+
+	identity, err := h.oidc.Exchange(c.Request.Context(), state, c.Query("code"))
+	if err != nil {
+		logger.Warn("OIDC 登录失败",
+			logger.String("ip", ip),
+			logger.Err(err))
+		h.redirectLoginError(c, err.Error())
+		return
+	}
+
+	session, err := h.manager.CreateSessionWithRole(identity.Username, identity.Role, ip, c.Request.UserAgent())
+	if err != nil {
+		logger.Error("创建会话失败", logger.Err(err))
+		h.redirectLoginError(c, "服务器内部错误")
+		return
+	}
+
+	maxAge := int(h.idleTimeout.Seconds())
+	if maxAge <= 0 {
+		maxAge = 1800 // 默认30分钟
+	}
+	c.SetCookie(sessionCookieName, session.ID, maxAge, "/", "", h.secureCookie, true)
+
+	logger.Info("用户通过 SSO 登录成功",
+		logger.String("username", identity.Username),
+		logger.String("role", identity.Role),
+		logger.String("ip", ip))
+
+	c.Redirect(http.StatusFound, "/")
+}
+
+// redirectLoginError 重定向到登录页并携带错误信息
+func (h *AuthHandlers) redirectLoginError(c *gin.Context, message string) {
+	c.Redirect(http.StatusFound, "/static/login.html?error="+url.QueryEscape(message))
+}
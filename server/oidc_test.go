@@ -0,0 +1,295 @@
+package server
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// mockIdP 本地模拟的 OpenID Provider
+type mockIdP struct {
+	t      *testing.T
+	server *httptest.Server
+	key    *rsa.PrivateKey
+
+	mu        sync.Mutex
+	codes     map[string]mockAuthCode
+	groups    []string
+	audience  string
+	expiresIn time.Duration
+}
+
+type mockAuthCode struct {
+	challenge string
+	nonce     string
+}
+
+func newMockIdP(t *testing.T) *mockIdP {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	idp := &mockIdP{t: t, key: key, codes: make(map[string]mockAuthCode), expiresIn: time.Hour}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 idp.server.URL,
+			"authorization_endpoint": idp.server.URL + "/authorize",
+			"token_endpoint":         idp.server.URL + "/token",
+			"jwks_uri":               idp.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": "test-key",
+				"use": "sig",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("/token", idp.handleToken)
+	idp.server = httptest.NewServer(mux)
+	t.Cleanup(idp.server.Close)
+	return idp
+}
+
+// authorize 模拟用户在 IdP 完成登录，返回授权码
+func (idp *mockIdP) authorize(authURL string) string {
+	u, err := url.Parse(authURL)
+	require.NoError(idp.t, err)
+	q := u.Query()
+	assert.Equal(idp.t, "S256", q.Get("code_challenge_method"))
+
+	code := "code-" + q.Get("state")[:8]
+	idp.mu.Lock()
+	idp.codes[code] = mockAuthCode{challenge: q.Get("code_challenge"), nonce: q.Get("nonce")}
+	idp.mu.Unlock()
+	return code
+}
+
+func (idp *mockIdP) handleToken(w http.ResponseWriter, r *http.Request) {
+	require.NoError(idp.t, r.ParseForm())
+
+	idp.mu.Lock()
+	auth, ok := idp.codes[r.PostForm.Get("code")]
+	delete(idp.codes, r.PostForm.Get("code"))
+	groups, audience, expiresIn := idp.groups, idp.audience, idp.expiresIn
+	idp.mu.Unlock()
+
+	// 校验 PKCE
+	sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if !ok || base64.RawURLEncoding.EncodeToString(sum[:]) != auth.challenge {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	if audience == "" {
+		audience = "kiro-dashboard"
+	}
+	claims := map[string]any{
+		"iss":    idp.server.URL,
+		"sub":    "user-1",
+		"aud":    []string{audience},
+		"exp":    time.Now().Add(expiresIn).Unix(),
+		"iat":    time.Now().Unix(),
+		"nonce":  auth.nonce,
+		"email":  "alice@example.com",
+		"groups": groups,
+	}
+	json.NewEncoder(w).Encode(map[string]string{"id_token": idp.sign(claims), "token_type": "Bearer"})
+}
+
+func (idp *mockIdP) sign(claims map[string]any) string {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": "test-key", "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signingInput))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, idp.key, crypto.SHA256, digest[:])
+	require.NoError(idp.t, err)
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func newOIDCTestRouter(t *testing.T, idp *mockIdP) (*gin.Engine, *SessionManager) {
+	gin.SetMode(gin.TestMode)
+
+	sessions := NewSessionManager(time.Hour, time.Hour)
+	t.Cleanup(sessions.Close)
+
+	h := NewAuthHandlers(sessions, "admin", "", time.Hour, false, nil, nil, nil)
+	h.SetOIDCProvider(NewOIDCProvider(OIDCConfig{
+		Issuer:        idp.server.URL,
+		ClientID:      "kiro-dashboard",
+		ClientSecret:  "secret",
+		RedirectURL:   "http://localhost/api/oidc/callback",
+		Scopes:        []string{"openid", "email"},
+		UsernameClaim: "email",
+		GroupsClaim:   "groups",
+		AdminGroups:   []string{"kiro-admins"},
+		ViewerGroups:  []string{"kiro-viewers"},
+	}, idp.server.Client()))
+
+	r := gin.New()
+	r.Use(SessionMiddleware(sessions))
+	r.POST("/api/login", h.HandleLogin)
+	registerOIDCRoutes(r, h)
+	r.GET("/api/session", h.HandleSessionCheck)
+	r.POST("/api/protected", AdminAPIAuthGuard(), func(c *gin.Context) { c.Status(http.StatusOK) })
+	return r, sessions
+}
+
+// runOIDCLogin 执行完整的 SSO 登录流程，返回回调响应
+func runOIDCLogin(t *testing.T, r *gin.Engine, idp *mockIdP, tamperState bool) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/oidc/login", nil))
+	require.Equal(t, http.StatusFound, w.Code)
+	location := w.Header().Get("Location")
+	require.True(t, strings.HasPrefix(location, idp.server.URL+"/authorize?"))
+
+	var stateCookie *http.Cookie
+	for _, c := range w.Result().Cookies() {
+		if c.Name == oidcStateCookieName {
+			stateCookie = c
+		}
+	}
+	require.NotNil(t, stateCookie)
+
+	u, _ := url.Parse(location)
+	state := u.Query().Get("state")
+	code := idp.authorize(location)
+	if tamperState {
+		stateCookie.Value = "other"
+	}
+
+	w = httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/api/oidc/callback?code="+code+"&state="+url.QueryEscape(state), nil)
+	req.AddCookie(stateCookie)
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func sessionCookieFrom(w *httptest.ResponseRecorder) *http.Cookie {
+	for _, c := range w.Result().Cookies() {
+		if c.Name == sessionCookieName && c.Value != "" {
+			return c
+		}
+	}
+	return nil
+}
+
+func TestOIDCLogin_AdminGroup(t *testing.T) {
+	idp := newMockIdP(t)
+	idp.groups = []string{"kiro-admins"}
+	r, sessions := newOIDCTestRouter(t, idp)
+
+	w := runOIDCLogin(t, r, idp, false)
+	require.Equal(t, http.StatusFound, w.Code)
+	assert.Equal(t, "/", w.Header().Get("Location"))
+	cookie := sessionCookieFrom(w)
+	require.NotNil(t, cookie)
+	assert.Equal(t, 1, sessions.Count())
+
+	w = httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/api/session", nil)
+	req.AddCookie(cookie)
+	r.ServeHTTP(w, req)
+	assert.Contains(t, w.Body.String(), `"user":"alice@example.com"`)
+	assert.Contains(t, w.Body.String(), `"role":"admin"`)
+}
+
+func TestOIDCLogin_ViewerIsReadOnly(t *testing.T) {
+	idp := newMockIdP(t)
+	idp.groups = []string{"kiro-viewers"}
+	r, _ := newOIDCTestRouter(t, idp)
+
+	cookie := sessionCookieFrom(runOIDCLogin(t, r, idp, false))
+	require.NotNil(t, cookie)
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/api/protected", nil)
+	req.AddCookie(cookie)
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+}
+
+func TestOIDCLogin_Rejections(t *testing.T) {
+	t.Run("未映射的用户组", func(t *testing.T) {
+		idp := newMockIdP(t)
+		idp.groups = []string{"everyone"}
+		r, sessions := newOIDCTestRouter(t, idp)
+
+		w := runOIDCLogin(t, r, idp, false)
+		assert.Contains(t, w.Header().Get("Location"), "/static/login.html?error=")
+		assert.Equal(t, 0, sessions.Count())
+	})
+
+	t.Run("state cookie 不匹配", func(t *testing.T) {
+		idp := newMockIdP(t)
+		idp.groups = []string{"kiro-admins"}
+		r, sessions := newOIDCTestRouter(t, idp)
+
+		w := runOIDCLogin(t, r, idp, true)
+		assert.Contains(t, w.Header().Get("Location"), "/static/login.html?error=")
+		assert.Equal(t, 0, sessions.Count())
+	})
+
+	t.Run("audience 不匹配", func(t *testing.T) {
+		idp := newMockIdP(t)
+		idp.groups = []string{"kiro-admins"}
+		idp.audience = "another-client"
+		r, sessions := newOIDCTestRouter(t, idp)
+
+		runOIDCLogin(t, r, idp, false)
+		assert.Equal(t, 0, sessions.Count())
+	})
+
+	t.Run("id_token 已过期", func(t *testing.T) {
+		idp := newMockIdP(t)
+		idp.groups = []string{"kiro-admins"}
+		idp.expiresIn = -time.Hour
+		r, sessions := newOIDCTestRouter(t, idp)
+
+		runOIDCLogin(t, r, idp, false)
+		assert.Equal(t, 0, sessions.Count())
+	})
+}
+
+func TestHandleLogin_LocalDisabledWithoutPassword(t *testing.T) {
+	idp := newMockIdP(t)
+	r, sessions := newOIDCTestRouter(t, idp)
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/api/login", strings.NewReader(`{"username":"admin","password":""}`))
+	req.Header.Set("Content-Type", "application/json")
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Equal(t, 0, sessions.Count())
+}
+
+func TestOIDCConfig_MapRole(t *testing.T) {
+	unmapped := OIDCConfig{}
+	assert.Equal(t, "", unmapped.MapRole(nil), "未配置用户组映射时拒绝登录")
+	assert.Equal(t, "", unmapped.MapRole([]string{"admins"}))
+
+	cfg := OIDCConfig{AdminGroups: []string{"a"}, ViewerGroups: []string{"v"}}
+	assert.Equal(t, RoleAdmin, cfg.MapRole([]string{"v", "a"}))
+	assert.Equal(t, RoleViewer, cfg.MapRole([]string{"v"}))
+	assert.Equal(t, "", cfg.MapRole([]string{"x"}))
+}
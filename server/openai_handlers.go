@@ -4,12 +4,15 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"sort"
+	"strings"
 	"time"
 
 	"kiro2api/config"
 	"kiro2api/converter"
 	"kiro2api/logger"
 	"kiro2api/parser"
+	"kiro2api/tracing"
 	"kiro2api/types"
 	"kiro2api/utils"
 
@@ -42,7 +45,14 @@ func handleOpenAINonStreamRequest(c *gin.Context, anthropicReq types.AnthropicRe
 	// 转换为Anthropic格式
 	contexts := []map[string]any{}
 	allContent := result.GetCompletionText()
-	sawToolUse := len(result.GetToolCalls()) > 0
+	toolCalls := result.GetToolCalls()
+
+	// 停止序列：在匹配处截断文本，之后的工具调用不再下发
+	allContent, stopSequence := cutAtStopSequence(allContent, anthropicReq.StopSequences)
+	if stopSequence != "" {
+		toolCalls = nil
+	}
+	sawToolUse := len(toolCalls) > 0
 
 	// 添加文本内容
 	if allContent != "" {
@@ -53,7 +63,7 @@ func handleOpenAINonStreamRequest(c *gin.Context, anthropicReq types.AnthropicRe
 	}
 
 	// 添加工具调用
-	for _, tool := range result.GetToolCalls() {
+	for _, tool := range toolCalls {
 		contexts = append(contexts, map[string]any{
 			"type":  "tool_use",
 			"id":    tool.ID,
@@ -62,9 +72,18 @@ func handleOpenAINonStreamRequest(c *gin.Context, anthropicReq types.AnthropicRe
 		})
 	}
 
+	// 本地 max_tokens 限制：超出预算的内容被截断
+	contexts, maxTokensReached := truncateContentToMaxTokens(utils.NewTokenEstimator(), contexts, anthropicReq.MaxTokens)
+
 	// 构建Anthropic响应
 	inputContent, _ := utils.GetMessageContent(anthropicReq.Messages[0].Content)
 	stopReason := func() string {
+		if stopSequence != "" {
+			return "stop_sequence"
+		}
+		if maxTokensReached {
+			return "max_tokens"
+		}
 		if sawToolUse {
 			return "tool_use"
 		}
@@ -75,7 +94,7 @@ func handleOpenAINonStreamRequest(c *gin.Context, anthropicReq types.AnthropicRe
 		"model":         anthropicReq.Model,
 		"role":          "assistant",
 		"stop_reason":   stopReason,
-		"stop_sequence": nil,
+		"stop_sequence": stopSequenceValue(stopSequence),
 		"type":          "message",
 		"usage": map[string]any{
 			"input_tokens":  len(inputContent),
@@ -86,6 +105,9 @@ func handleOpenAINonStreamRequest(c *gin.Context, anthropicReq types.AnthropicRe
 	// 转换为OpenAI格式
 	openaiMessageId := fmt.Sprintf("chatcmpl-%s", time.Now().Format(config.MessageIDTimeFormat))
 	openaiResp := converter.ConvertAnthropicToOpenAI(anthropicResp, anthropicReq.Model, openaiMessageId)
+	if maxTokensReached && len(openaiResp.Choices) > 0 {
+		openaiResp.Choices[0].FinishReason = "length"
+	}
 
 	// 下发OpenAI兼容非流式响应
 	logger.Debug("下发OpenAI非流式响应",
@@ -93,6 +115,8 @@ func handleOpenAINonStreamRequest(c *gin.Context, anthropicReq types.AnthropicRe
 			logger.String("direction", "downstream_send"),
 			logger.Bool("saw_tool_use", sawToolUse),
 		)...)
+	recordStopReason(c, stopReason)
+	recordInspectedResponse(c, "chat.completion", openaiResp)
 	c.JSON(http.StatusOK, openaiResp)
 }
 
@@ -112,6 +136,7 @@ func handleOpenAIStreamRequest(c *gin.Context, anthropicReq types.AnthropicReque
 		return
 	}
 	defer resp.Body.Close()
+	defer observeStreamDuration("openai", anthropicReq.Model, time.Now())
 
 	// 立即刷新响应头
 	c.Writer.Flush()
@@ -146,6 +171,152 @@ func handleOpenAIStreamRequest(c *gin.Context, anthropicReq types.AnthropicReque
 	sawToolUse := false
 	sentFinal := false
 
+	// 停止序列匹配：可能构成停止序列开头的文本暂存，匹配后截断输出并结束流
+	stopMatcher := newStopSequenceMatcher(anthropicReq.StopSequences)
+	stopSequence := ""
+
+	// 本地 max_tokens 限制：按与Anthropic流式响应相同的口径估算已下发的输出token
+	estimator := utils.NewTokenEstimator()
+	outputTokens := 0 // 文本和工具调用结构的token
+	toolArgBytes := 0 // 已下发的工具参数字节数
+	maxTokensReached := false
+	usedTokens := func() int {
+		return outputTokens + jsonBytesTokens(toolArgBytes)
+	}
+
+	sendContent := func(text string) {
+		if maxTokensReached || text == "" {
+			return
+		}
+		if maxTokens := anthropicReq.MaxTokens; maxTokens > 0 {
+			if remaining := maxTokens - usedTokens(); estimator.EstimateTextTokens(text) > remaining {
+				text = truncateTextToTokens(estimator, text, remaining)
+				maxTokensReached = true
+			}
+		}
+		if text == "" {
+			return
+		}
+		outputTokens += estimator.EstimateTextTokens(text)
+		contentEvent := map[string]any{
+			"id":      messageId,
+			"object":  "chat.completion.chunk",
+			"created": time.Now().Unix(),
+			"model":   anthropicReq.Model,
+			"choices": []map[string]any{
+				{
+					"index": 0,
+					"delta": map[string]any{
+						"content": text,
+					},
+					"finish_reason": nil,
+				},
+			},
+		}
+		sender.SendEvent(c, contentEvent)
+	}
+	flushPendingContent := func() {
+		if stopMatcher != nil && stopSequence == "" {
+			sendContent(stopMatcher.Flush())
+		}
+	}
+
+	// 工具调用增量：tool_calls 开始事件和参数增量
+	startToolCall := func(blockIndex int, toolUseId, toolName string) {
+		outputTokens += toolUseStartTokens(estimator, toolName)
+		if _, exists := toolIndexByToolUseId[toolUseId]; !exists {
+			toolIndexByToolUseId[toolUseId] = nextToolIndex
+			nextToolIndex++
+		}
+		toolUseIdByBlockIndex[blockIndex] = toolUseId
+		sawToolUse = true
+		toolIdx := toolIndexByToolUseId[toolUseId]
+		// 发送OpenAI工具调用开始增量
+		toolStart := map[string]any{
+			"id":      messageId,
+			"object":  "chat.completion.chunk",
+			"created": time.Now().Unix(),
+			"model":   anthropicReq.Model,
+			"choices": []map[string]any{
+				{
+					"index": 0,
+					"delta": map[string]any{
+						"tool_calls": []map[string]any{
+							{
+								"index": toolIdx,
+								"id":    toolUseId,
+								"type":  "function",
+								"function": map[string]any{
+									"name":      toolName,
+									"arguments": "",
+								},
+							},
+						},
+					},
+					"finish_reason": nil,
+				},
+			},
+		}
+		sender.SendEvent(c, toolStart)
+	}
+	sendToolArguments := func(blockIndex int, partial string) {
+		toolUseId, ok := toolUseIdByBlockIndex[blockIndex]
+		if !ok {
+			return
+		}
+		toolIdx, ok := toolIndexByToolUseId[toolUseId]
+		if !ok {
+			return
+		}
+		toolArgBytes += len(partial)
+		if partial == "" {
+			return
+		}
+		toolDelta := map[string]any{
+			"id":      messageId,
+			"object":  "chat.completion.chunk",
+			"created": time.Now().Unix(),
+			"model":   anthropicReq.Model,
+			"choices": []map[string]any{
+				{
+					"index": 0,
+					"delta": map[string]any{
+						"tool_calls": []map[string]any{
+							{
+								"index": toolIdx,
+								"type":  "function",
+								"function": map[string]any{
+									"arguments": partial,
+								},
+							},
+						},
+					},
+					"finish_reason": nil,
+				},
+			},
+		}
+		sender.SendEvent(c, toolDelta)
+	}
+
+	// 设置了 max_tokens 时工具调用暂存到块结束：参数只有完整时才是合法 JSON，
+	// 预算足够时整块下发，否则丢弃并停止输出（与Anthropic流式和非流式响应一致）
+	type pendingToolCall struct {
+		id, name string
+		args     strings.Builder
+	}
+	pendingToolCalls := make(map[int]*pendingToolCall)
+	releaseToolCall := func(blockIndex int, pending *pendingToolCall) {
+		if maxTokensReached || stopSequence != "" {
+			return
+		}
+		if cost := toolUseStartTokens(estimator, pending.name) + jsonBytesTokens(pending.args.Len()); usedTokens()+cost > anthropicReq.MaxTokens {
+			maxTokensReached = true
+			return
+		}
+		startToolCall(blockIndex, pending.id, pending.name)
+		sendToolArguments(blockIndex, pending.args.String())
+	}
+
 	// 添加完整性跟踪
 	totalBytesRead := 0
 	messageCount := 0
@@ -153,6 +324,8 @@ func handleOpenAIStreamRequest(c *gin.Context, anthropicReq types.AnthropicReque
 	consecutiveErrors := 0
 	const maxConsecutiveErrors = 3
 
+	_, relaySpan := startSpan(c, "eventstream.relay", tracing.SpanKindInternal)
+
 	// 使用更大的缓冲区避免数据丢失
 	buf := make([]byte, 8192) // 增加到8KB
 	for hasMoreData {
@@ -176,24 +349,12 @@ func handleOpenAIStreamRequest(c *gin.Context, anthropicReq types.AnthropicReque
 								if deltaMap, ok := delta.(map[string]any); ok {
 									switch deltaMap["type"] {
 									case "text_delta":
-										if text, ok := deltaMap["text"]; ok {
+										if text, ok := deltaMap["text"].(string); ok {
 											// 发送文本内容的增量
-											contentEvent := map[string]any{
-												"id":      messageId,
-												"object":  "chat.completion.chunk",
-												"created": time.Now().Unix(),
-												"model":   anthropicReq.Model,
-												"choices": []map[string]any{
-													{
-														"index": 0,
-														"delta": map[string]any{
-															"content": text.(string),
-														},
-														"finish_reason": nil,
-													},
-												},
+											if stopMatcher != nil {
+												text, stopSequence = stopMatcher.Feed(text)
 											}
-											sender.SendEvent(c, contentEvent)
+											sendContent(text)
 										}
 									case "input_json_delta":
 										// 工具调用参数增量
@@ -211,47 +372,22 @@ func handleOpenAIStreamRequest(c *gin.Context, anthropicReq types.AnthropicReque
 												toolBlockIndex = int(v)
 											}
 										}
-										if toolUseId, ok := toolUseIdByBlockIndex[toolBlockIndex]; ok {
-											if toolIdx, ok := toolIndexByToolUseId[toolUseId]; ok {
-												var partial string
-												if pj, ok := deltaMap["partial_json"]; ok {
-													switch s := pj.(type) {
-													case string:
-														partial = s
-													case *string:
-														if s != nil {
-															partial = *s
-														}
-													}
-												}
-												if partial != "" {
-													toolDelta := map[string]any{
-														"id":      messageId,
-														"object":  "chat.completion.chunk",
-														"created": time.Now().Unix(),
-														"model":   anthropicReq.Model,
-														"choices": []map[string]any{
-															{
-																"index": 0,
-																"delta": map[string]any{
-																	"tool_calls": []map[string]any{
-																		{
-																			"index": toolIdx,
-																			"type":  "function",
-																			"function": map[string]any{
-																				"arguments": partial,
-																			},
-																		},
-																	},
-																},
-																"finish_reason": nil,
-															},
-														},
-													}
-													sender.SendEvent(c, toolDelta)
+										var partial string
+										if pj, ok := deltaMap["partial_json"]; ok {
+											switch s := pj.(type) {
+											case string:
+												partial = s
+											case *string:
+												if s != nil {
+													partial = *s
 												}
 											}
 										}
+										if pending, ok := pendingToolCalls[toolBlockIndex]; ok {
+											pending.args.WriteString(partial)
+										} else {
+											sendToolArguments(toolBlockIndex, partial)
+										}
 									}
 								}
 							}
@@ -276,40 +412,20 @@ func handleOpenAIStreamRequest(c *gin.Context, anthropicReq types.AnthropicReque
 											}
 										}
 										if toolUseId != "" {
-											if _, exists := toolIndexByToolUseId[toolUseId]; !exists {
-												toolIndexByToolUseId[toolUseId] = nextToolIndex
-												nextToolIndex++
+											flushPendingContent()
+											// 预算不足以开始新的工具调用时停止输出
+											if cost := toolUseStartTokens(estimator, toolName); anthropicReq.MaxTokens > 0 && usedTokens()+cost > anthropicReq.MaxTokens {
+												maxTokensReached = true
 											}
-											toolUseIdByBlockIndex[toolBlockIndex] = toolUseId
-											sawToolUse = true
-											toolIdx := toolIndexByToolUseId[toolUseId]
-											// 发送OpenAI工具调用开始增量
-											toolStart := map[string]any{
-												"id":      messageId,
-												"object":  "chat.completion.chunk",
-												"created": time.Now().Unix(),
-												"model":   anthropicReq.Model,
-												"choices": []map[string]any{
-													{
-														"index": 0,
-														"delta": map[string]any{
-															"tool_calls": []map[string]any{
-																{
-																	"index": toolIdx,
-																	"id":    toolUseId,
-																	"type":  "function",
-																	"function": map[string]any{
-																		"name":      toolName,
-																		"arguments": "",
-																	},
-																},
-															},
-														},
-														"finish_reason": nil,
-													},
-												},
+											if maxTokensReached {
+												break
 											}
-											sender.SendEvent(c, toolStart)
+											// 设置了 max_tokens 时暂存到块结束，按完整参数计算开销
+											if anthropicReq.MaxTokens > 0 {
+												pendingToolCalls[toolBlockIndex] = &pendingToolCall{id: toolUseId, name: toolName}
+												break
+											}
+											startToolCall(toolBlockIndex, toolUseId, toolName)
 										}
 									}
 								}
@@ -338,11 +454,30 @@ func handleOpenAIStreamRequest(c *gin.Context, anthropicReq types.AnthropicReque
 								}
 							}
 						case "content_block_stop":
-							// 忽略，最终结束由message_delta驱动
+							// 最终结束由message_delta驱动；暂存的工具调用在此整块下发或丢弃
+							toolBlockIndex := extractIndex(dataMap)
+							if pending, ok := pendingToolCalls[toolBlockIndex]; ok {
+								delete(pendingToolCalls, toolBlockIndex)
+								releaseToolCall(toolBlockIndex, pending)
+							}
 						}
 					}
 				}
 				c.Writer.Flush()
+				if stopSequence != "" || maxTokensReached {
+					break
+				}
+			}
+
+			// 匹配到停止序列或达到 max_tokens：关闭上游响应，不再读取剩余内容
+			if stopSequence != "" || maxTokensReached {
+				logger.Debug("本地结束输出，提前关闭上游响应",
+					addReqFields(c,
+						logger.String("stop_sequence", stopSequence),
+						logger.Bool("max_tokens_reached", maxTokensReached),
+					)...)
+				_ = resp.Body.Close()
+				break
 			}
 		}
 
@@ -379,12 +514,32 @@ func handleOpenAIStreamRequest(c *gin.Context, anthropicReq types.AnthropicReque
 		}
 	}
 
+	relaySpan.SetAttributes(
+		tracing.Int("kiro.upstream.bytes", totalBytesRead),
+		tracing.Int("kiro.upstream.events", messageCount))
+	relaySpan.End()
+
+	// 上游未结束的工具调用按已收到的参数下发或丢弃
+	pendingIndexes := make([]int, 0, len(pendingToolCalls))
+	for index := range pendingToolCalls {
+		pendingIndexes = append(pendingIndexes, index)
+	}
+	sort.Ints(pendingIndexes)
+	for _, index := range pendingIndexes {
+		releaseToolCall(index, pendingToolCalls[index])
+	}
+
 	// 确保发送了结束原因（如果还没有发送）
 	if !sentFinal && messageCount > 0 {
+		flushPendingContent()
 		finishReason := "stop"
-		if sawToolUse {
+		switch {
+		case maxTokensReached:
+			finishReason = "length"
+		case sawToolUse && stopSequence == "":
 			finishReason = "tool_calls"
 		}
+		recordStopReason(c, finishReason)
 
 		finalEvent := map[string]any{
 			"id":      messageId,
@@ -1,6 +1,7 @@
 package server
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
 	"os"
@@ -11,6 +12,8 @@ import (
 	"kiro2api/config"
 	"kiro2api/converter"
 	"kiro2api/logger"
+	"kiro2api/metrics"
+	"kiro2api/tracing"
 	"kiro2api/types"
 	"kiro2api/utils"
 
@@ -35,40 +38,137 @@ func StartServer(port string, clientTokenManager *auth.ClientTokenManager, authS
 		adminUser = "admin"
 	}
 
-	// 检查是否启用 Dashboard 认证
-	dashboardAuthEnabled := adminPass != ""
+	// OIDC 单点登录（配置 OIDC_ISSUER 和 OIDC_CLIENT_ID 后启用）
+	oidcConfig, oidcEnabled := LoadOIDCConfigFromEnv()
+
+	// 检查是否启用 Dashboard 认证（本地密码或 SSO 任一启用即可）
+	dashboardAuthEnabled := adminPass != "" || oidcEnabled
 	if dashboardAuthEnabled {
 		logger.Info("Dashboard 认证已启用")
 	} else {
-		logger.Warn("Dashboard 认证未启用，请设置 ADMIN_PASSWORD 环境变量以保护管理面板")
+		logger.Warn("Dashboard 认证未启用，请设置 ADMIN_PASSWORD 或 OIDC_ISSUER 环境变量以保护管理面板")
+	}
+
+	// 会话存储（SESSION_STORE=memory|file，默认 file，重启后会话和登录锁定状态保持）
+	sessionStore, err := NewSessionStore(os.Getenv("SESSION_STORE"), os.Getenv("SESSION_STORE_FILE"))
+	if err != nil {
+		logger.Error("初始化会话存储失败", logger.Err(err))
+		os.Exit(1)
 	}
 
 	// 创建会话管理器（30分钟空闲超时，24小时绝对超时）
-	sessionManager := NewSessionManager(30*time.Minute, 24*time.Hour)
+	sessionManager := NewSessionManagerWithStore(30*time.Minute, 24*time.Hour, sessionStore)
 
 	// 判断是否使用 Secure cookie
 	// 默认 false，仅当显式设置 SECURE_COOKIE=true 且使用 HTTPS 时启用
 	secureCookie := os.Getenv("SECURE_COOKIE") == "true"
 
+	// 审计日志（记录管理操作，文件路径可通过 AUDIT_LOG_FILE 配置）
+	auditLog, err := NewAuditLog(os.Getenv("AUDIT_LOG_FILE"))
+	if err != nil {
+		logger.Error("初始化审计日志失败", logger.Err(err))
+		os.Exit(1)
+	}
+
+	// 运行时设置（文件路径可通过 SETTINGS_FILE 配置），尽早应用以覆盖环境变量初值
+	settingsRegistry, err := NewSettingsRegistry(os.Getenv("SETTINGS_FILE"), authService)
+	if err != nil {
+		logger.Error("初始化运行时设置失败", logger.Err(err))
+		os.Exit(1)
+	}
+
+	// 两步验证存储（文件路径可通过 TOTP_CONFIG_FILE 配置）
+	totpStore, err := NewTOTPStore(os.Getenv("TOTP_CONFIG_FILE"), os.Getenv("TOTP_ISSUER"))
+	if err != nil {
+		logger.Error("初始化两步验证配置失败", logger.Err(err))
+		os.Exit(1)
+	}
+
+	// 管理API密钥（文件路径可通过 ADMIN_API_KEYS_FILE 配置）
+	adminKeyManager, err := NewAdminAPIKeyManager(os.Getenv("ADMIN_API_KEYS_FILE"))
+	if err != nil {
+		logger.Error("初始化管理API密钥失败", logger.Err(err))
+		os.Exit(1)
+	}
+
 	// 创建认证处理器
-	authHandlers := NewAuthHandlers(sessionManager, adminUser, adminPass, 30*time.Minute, secureCookie)
+	authHandlers := NewAuthHandlers(sessionManager, adminUser, adminPass, 30*time.Minute, secureCookie, totpStore, auditLog, sessionStore)
+	if oidcEnabled {
+		authHandlers.SetOIDCProvider(NewOIDCProvider(oidcConfig, nil))
+		logger.Info("OIDC 单点登录已启用",
+			logger.String("issuer", oidcConfig.Issuer),
+			logger.Bool("local_login", adminPass != ""))
+	}
+
+	// Prometheus 指标（METRICS_PORT 设置时在独立端口暴露）
+	metricsConfig := LoadMetricsConfigFromEnv()
+	if metricsConfig.Enabled {
+		registerAccountPoolMetrics(metrics.Default, authService)
+		if metricsConfig.Port != "" {
+			startMetricsServer(metricsConfig, metrics.Default)
+		}
+	}
+
+	// OpenTelemetry 追踪（配置 OTEL_EXPORTER_OTLP_ENDPOINT 时启用）
+	if tracingConfig, ok := tracing.LoadConfigFromEnv(); ok {
+		tracing.SetGlobal(tracing.NewTracer(tracingConfig))
+		logger.Info("OpenTelemetry 追踪已启用",
+			logger.String("endpoint", tracingConfig.Endpoint),
+			logger.String("service", tracingConfig.ServiceName),
+			logger.Float64("sample_ratio", tracingConfig.SampleRatio))
+	}
+
+	// 实时请求检查器（Dashboard 查看进行中和最近的代理请求）
+	inspectorConfig := LoadInspectorConfigFromEnv()
+	var inspector *RequestInspector
+	if inspectorConfig.Enabled {
+		inspector = NewRequestInspector(inspectorConfig)
+	}
+
+	// 用量时间序列统计（文件路径可通过 USAGE_STATS_FILE 配置）
+	usageStats, err := NewUsageStats(os.Getenv("USAGE_STATS_FILE"))
+	if err != nil {
+		logger.Error("初始化用量统计失败", logger.Err(err))
+		os.Exit(1)
+	}
+	usageStats.StartAutoSave(usageStatsSaveInterval)
+
+	// 调试捕获（X-Kiro-Debug: capture 或客户端令牌开启时保存完整请求过程）
+	debugCaptures, err := NewDebugCaptureStore(LoadDebugCaptureConfigFromEnv())
+	if err != nil {
+		logger.Error("初始化调试捕获失败", logger.Err(err))
+		os.Exit(1)
+	}
 
 	r := gin.New()
 
 	// 添加中间件
-	r.Use(gin.Logger())
+	r.Use(gin.LoggerWithWriter(logger.AccessWriter()))
 	r.Use(gin.Recovery())
 	// 注入请求ID，便于日志追踪
 	r.Use(RequestIDMiddleware())
+	if metricsConfig.Enabled {
+		r.Use(MetricsMiddleware())
+	}
+	// 追踪根 span（仅 /v1 代理请求）
+	r.Use(TracingMiddleware())
 	r.Use(corsMiddleware())
 	// 会话中间件（解析会话cookie）
 	r.Use(SessionMiddleware(sessionManager))
-	// CSRF 保护（跳过 /v1 API）
+	// 管理API密钥认证（Authorization: Bearer kak_...，需在 CSRF 之前）
+	// CSRF 保护（跳过 /v1 API 和管理API密钥请求）
 	if dashboardAuthEnabled {
+		r.Use(AdminAPIKeyMiddleware(adminKeyManager))
 		r.Use(CSRFMiddleware(secureCookie))
 	}
 	// 只对 /v1 开头的端点进行认证
 	r.Use(PathBasedAuthMiddleware(clientTokenManager, []string{"/v1"}))
+	// 请求检查器在认证之后，未认证请求不记录
+	if inspector != nil {
+		r.Use(InspectorMiddleware(inspector))
+	}
+	r.Use(UsageStatsMiddleware(usageStats))
+	r.Use(DebugCaptureMiddleware(debugCaptures, adminKeyManager, clientTokenManager, dashboardAuthEnabled))
 
 	// 静态资源服务 - 前后端完全分离
 	r.Static("/static", "./static")
@@ -88,6 +188,12 @@ func StartServer(port string, clientTokenManager *auth.ClientTokenManager, authS
 	r.POST("/api/login", authHandlers.HandleLogin)
 	r.POST("/api/logout", authHandlers.HandleLogout)
 	r.GET("/api/session", authHandlers.HandleSessionCheck)
+	registerOIDCRoutes(r, authHandlers)
+	if dashboardAuthEnabled {
+		registerTOTPRoutes(r, authHandlers)
+		registerSessionRoutes(r, sessionManager, auditLog)
+		registerAdminAPIKeyRoutes(r, adminKeyManager, auditLog)
+	}
 
 	// API端点 - 纯数据服务（需要认证保护）
 	apiGroup := r.Group("/api")
@@ -98,11 +204,23 @@ func StartServer(port string, clientTokenManager *auth.ClientTokenManager, authS
 		handleTokenPoolAPI(c, authService)
 	})
 
+	registerAuditRoutes(r, auditLog, dashboardAuthEnabled)
+
+	if inspector != nil {
+		registerInspectorRoutes(r, inspector, dashboardAuthEnabled)
+	}
+
+	registerUsageRoutes(r, usageStats, authService, dashboardAuthEnabled)
+
+	registerSettingsRoutes(r, settingsRegistry, auditLog, dashboardAuthEnabled)
+
+	registerDebugCaptureRoutes(r, debugCaptures, auditLog, dashboardAuthEnabled)
+
 	// Token 管理 API（动态添加/删除）
-	registerTokenManagementRoutes(r, authService, dashboardAuthEnabled)
+	registerTokenManagementRoutes(r, authService, auditLog, dashboardAuthEnabled)
 
 	// Client Token 管理 API
-	registerClientTokenRoutes(r, clientTokenManager, dashboardAuthEnabled)
+	registerClientTokenRoutes(r, clientTokenManager, auditLog, dashboardAuthEnabled)
 
 	// GET /v1/models 端点
 	r.GET("/v1/models", func(c *gin.Context) {
@@ -142,84 +260,24 @@ func StartServer(port string, clientTokenManager *auth.ClientTokenManager, authS
 			return // 错误已在GetTokenWithUsageAndBody中处理
 		}
 
-		// 先解析为通用map以便处理工具格式
-		var rawReq map[string]any
-		if err := utils.SafeUnmarshal(body, &rawReq); err != nil {
-			logger.Error("解析请求体失败", logger.Err(err))
-			respondError(c, http.StatusBadRequest, "解析请求体失败: %v", err)
-			return
+		_, parseSpan := startSpan(c, "request.parse", tracing.SpanKindInternal,
+			tracing.Int("http.request.body.size", len(body)))
+		anthropicReq, err := parseAnthropicRequest(c, body)
+		if err == nil {
+			parseSpan.SetAttributes(
+				tracing.String("gen_ai.request.model", anthropicReq.Model),
+				tracing.Bool("kiro.stream", anthropicReq.Stream),
+				tracing.Int("kiro.messages", len(anthropicReq.Messages)),
+				tracing.Int("kiro.tools", len(anthropicReq.Tools)))
 		}
-
-		// 标准化工具格式处理
-		if tools, exists := rawReq["tools"]; exists && tools != nil {
-			if toolsArray, ok := tools.([]any); ok {
-				normalizedTools := make([]map[string]any, 0, len(toolsArray))
-				for _, tool := range toolsArray {
-					if toolMap, ok := tool.(map[string]any); ok {
-						// 检查是否是简化的工具格式（直接包含name, description, input_schema）
-						if name, hasName := toolMap["name"]; hasName {
-							if description, hasDesc := toolMap["description"]; hasDesc {
-								if inputSchema, hasSchema := toolMap["input_schema"]; hasSchema {
-									// 转换为标准Anthropic工具格式
-									normalizedTool := map[string]any{
-										"name":         name,
-										"description":  description,
-										"input_schema": inputSchema,
-									}
-									normalizedTools = append(normalizedTools, normalizedTool)
-									continue
-								}
-							}
-						}
-						// 如果不是简化格式，保持原样
-						normalizedTools = append(normalizedTools, toolMap)
-					}
-				}
-				rawReq["tools"] = normalizedTools
-			}
-		}
-
-		// 重新序列化并解析为AnthropicRequest
-		normalizedBody, err := utils.SafeMarshal(rawReq)
+		parseSpan.RecordError(err)
+		parseSpan.End()
 		if err != nil {
-			logger.Error("重新序列化请求失败", logger.Err(err))
-			respondError(c, http.StatusBadRequest, "处理请求格式失败: %v", err)
-			return
-		}
-
-		var anthropicReq types.AnthropicRequest
-		if err := utils.SafeUnmarshal(normalizedBody, &anthropicReq); err != nil {
-			logger.Error("解析标准化请求体失败", logger.Err(err))
-			respondError(c, http.StatusBadRequest, "解析请求体失败: %v", err)
-			return
-		}
-
-		// 验证请求的有效性
-		if len(anthropicReq.Messages) == 0 {
-			logger.Error("请求中没有消息")
-			respondError(c, http.StatusBadRequest, "%s", "messages 数组不能为空")
-			return
-		}
-
-		// 验证最后一条消息有有效内容
-		lastMsg := anthropicReq.Messages[len(anthropicReq.Messages)-1]
-		content, err := utils.GetMessageContent(lastMsg.Content)
-		if err != nil {
-			logger.Error("获取消息内容失败",
-				logger.Err(err),
-				logger.String("raw_content", fmt.Sprintf("%v", lastMsg.Content)))
-			respondError(c, http.StatusBadRequest, "获取消息内容失败: %v", err)
-			return
-		}
-
-		trimmedContent := strings.TrimSpace(content)
-		if trimmedContent == "" || trimmedContent == "answer for user question" {
-			logger.Error("消息内容为空或无效",
-				logger.String("content", content),
-				logger.String("trimmed_content", trimmedContent))
-			respondError(c, http.StatusBadRequest, "%s", "消息内容不能为空")
-			return
+			return // 错误已在parseAnthropicRequest中处理
 		}
+		setRequestSpanAttributes(c, tracing.String("gen_ai.request.model", anthropicReq.Model))
+		inspectorEntryFrom(c).setModel(anthropicReq.Model, anthropicReq.Stream)
+		debugCaptureFrom(c).setAnthropicRequest(anthropicReq)
 
 		if anthropicReq.Stream {
 			handleStreamRequest(c, anthropicReq, tokenWithUsage)
@@ -246,12 +304,18 @@ func StartServer(port string, clientTokenManager *auth.ClientTokenManager, authS
 			return // 错误已在GetTokenAndBody中处理
 		}
 
+		_, parseSpan := startSpan(c, "request.parse", tracing.SpanKindInternal,
+			tracing.Int("http.request.body.size", len(body)))
 		var openaiReq types.OpenAIRequest
 		if err := utils.SafeUnmarshal(body, &openaiReq); err != nil {
+			parseSpan.RecordError(err)
+			parseSpan.End()
 			logger.Error("解析OpenAI请求体失败", logger.Err(err))
 			respondError(c, http.StatusBadRequest, "解析请求体失败: %v", err)
 			return
 		}
+		setRequestModel(c, openaiReq.Model)
+		setRequestSpanAttributes(c, tracing.String("gen_ai.request.model", openaiReq.Model))
 
 		logger.Debug("OpenAI请求解析成功",
 			logger.String("model", openaiReq.Model),
@@ -265,6 +329,26 @@ func StartServer(port string, clientTokenManager *auth.ClientTokenManager, authS
 
 		// 转换为Anthropic格式
 		anthropicReq := converter.ConvertOpenAIToAnthropic(openaiReq)
+		parseSpan.SetAttributes(
+			tracing.String("gen_ai.request.model", openaiReq.Model),
+			tracing.Bool("kiro.stream", anthropicReq.Stream),
+			tracing.Int("kiro.messages", len(anthropicReq.Messages)),
+			tracing.Int("kiro.tools", len(anthropicReq.Tools)))
+		parseSpan.End()
+		inspectorEntryFrom(c).setModel(openaiReq.Model, anthropicReq.Stream)
+		debugCaptureFrom(c).setAnthropicRequest(anthropicReq)
+
+		// 验证 tool_choice 参数
+		if err := validateToolChoice(anthropicReq); err != nil {
+			logger.Error("tool_choice参数无效", logger.Err(err))
+			respondError(c, http.StatusBadRequest, "%s", err.Error())
+			return
+		}
+
+		// 预处理图片（下载 URL 图片、转码、缩小、去除元数据）
+		if err := preprocessRequestImages(c, &anthropicReq); err != nil {
+			return
+		}
 
 		if anthropicReq.Stream {
 			handleOpenAIStreamRequest(c, anthropicReq, tokenInfo)
@@ -273,6 +357,24 @@ func StartServer(port string, clientTokenManager *auth.ClientTokenManager, authS
 		handleOpenAINonStreamRequest(c, anthropicReq, tokenInfo)
 	})
 
+	// 健康检查（存活 /healthz，就绪 /readyz，?verbose=1 向管理员返回子系统详情）
+	healthChecker := NewHealthChecker(authService, clientTokenManager,
+		utils.GetEnvWithDefault("AUDIT_LOG_FILE", defaultAuditLogFile),
+		utils.GetEnvWithDefault("SESSION_STORE_FILE", defaultSessionStoreFile),
+		utils.GetEnvWithDefault("TOTP_CONFIG_FILE", defaultTOTPFile),
+		utils.GetEnvWithDefault("ADMIN_API_KEYS_FILE", defaultAdminAPIKeyFile),
+		utils.GetEnvWithDefault("USAGE_STATS_FILE", defaultUsageStatsFile),
+		utils.GetEnvWithDefault("SETTINGS_FILE", defaultSettingsFile),
+		debugCaptures.bundlePath("*"), // 检查调试捕获目录
+	)
+	healthChecker.publicVerbose = utils.GetEnvBoolWithDefault("HEALTH_VERBOSE_PUBLIC", false)
+	registerHealthRoutes(r, healthChecker)
+
+	// Prometheus 指标端点（未配置独立端口时挂在主服务上）
+	if metricsConfig.Enabled && metricsConfig.Port == "" {
+		r.GET(metricsPath, metricsHandler(metrics.Default, metricsConfig.Token))
+	}
+
 	// 静默处理 Kiro 客户端的遥测请求
 	r.POST("/api/event_logging/batch", func(c *gin.Context) {
 		c.JSON(http.StatusOK, gin.H{"success": true})
@@ -298,6 +400,13 @@ func StartServer(port string, clientTokenManager *auth.ClientTokenManager, authS
 	logger.Info("  POST /api/login                 - 登录")
 	logger.Info("  POST /api/logout                - 登出")
 	logger.Info("  GET  /api/session               - 会话状态")
+	logger.Info("  GET  /api/oidc/login            - SSO 登录")
+	logger.Info("  POST /api/2fa/*                 - 两步验证管理")
+	logger.Info("  GET  /api/sessions              - 登录会话列表")
+	logger.Info("  DELETE /api/sessions/:key       - 吊销登录会话")
+	logger.Info("  GET  /api/admin-keys            - 管理API密钥列表")
+	logger.Info("  POST /api/admin-keys            - 创建管理API密钥")
+	logger.Info("  DELETE /api/admin-keys/:id      - 吊销管理API密钥")
 	logger.Info("  GET  /api/tokens                - Token池状态API")
 	logger.Info("  POST /api/tokens                - 添加Token")
 	logger.Info("  DELETE /api/tokens/:index       - 删除Token")
@@ -305,10 +414,34 @@ func StartServer(port string, clientTokenManager *auth.ClientTokenManager, authS
 	logger.Info("  POST /api/client-tokens         - 添加客户端令牌")
 	logger.Info("  DELETE /api/client-tokens/:index - 删除客户端令牌")
 	logger.Info("  POST /api/client-tokens/:index/toggle - 切换客户端令牌状态")
+	logger.Info("  POST /api/client-tokens/:index/debug-capture - 设置客户端令牌调试捕获")
+	logger.Info("  GET  /api/audit                 - 审计日志查询")
+	logger.Info("  GET  /api/audit/export          - 审计日志导出 (json/csv)")
+	logger.Info("  GET  /api/usage                 - 用量时间序列 (分钟/小时/天)")
+	logger.Info("  GET  /api/usage/forecast        - 账号池额度耗尽预测")
+	logger.Info("  GET  /api/settings              - 运行时设置")
+	logger.Info("  PUT  /api/settings              - 修改运行时设置 (无需重启)")
+	logger.Info("  GET  /api/debug/captures        - 调试捕获包列表")
+	logger.Info("  GET  /api/debug/captures/:id    - 下载调试捕获包 (zip)")
+	logger.Info("  DELETE /api/debug/captures/:id  - 删除调试捕获包")
+	if inspector != nil {
+		logger.Info("  GET  /api/inspector/requests    - 实时请求检查器列表")
+		logger.Info("  GET  /api/inspector/requests/:id - 请求详情 (上游请求与转发事件)")
+		logger.Info("  GET  /api/inspector/stream      - 请求变更推送 (SSE)")
+	}
 	logger.Info("  GET  /v1/models                 - 模型列表")
 	logger.Info("  POST /v1/messages               - Anthropic API代理")
 	logger.Info("  POST /v1/messages/count_tokens  - Token计数接口")
 	logger.Info("  POST /v1/chat/completions       - OpenAI API代理")
+	logger.Info("  GET  /healthz                   - 存活检查")
+	logger.Info("  GET  /readyz                    - 就绪检查 (无可用账号时返回503)")
+	if metricsConfig.Enabled {
+		if metricsConfig.Port != "" {
+			logger.Info("  GET  /metrics                   - Prometheus 指标 (端口 " + metricsConfig.Port + ")")
+		} else {
+			logger.Info("  GET  /metrics                   - Prometheus 指标")
+		}
+	}
 	logger.Info("按Ctrl+C停止服务器")
 
 	// 创建自定义HTTP服务器以支持长时间请求
@@ -325,6 +458,110 @@ func StartServer(port string, clientTokenManager *auth.ClientTokenManager, authS
 	}
 }
 
+// parseAnthropicRequest 解析并标准化 Anthropic 请求体，校验失败时直接写入错误响应
+func parseAnthropicRequest(c *gin.Context, body []byte) (types.AnthropicRequest, error) {
+	// 先解析为通用map以便处理工具格式
+	var rawReq map[string]any
+	if err := utils.SafeUnmarshal(body, &rawReq); err != nil {
+		logger.Error("解析请求体失败", logger.Err(err))
+		respondError(c, http.StatusBadRequest, "解析请求体失败: %v", err)
+		return types.AnthropicRequest{}, err
+	}
+
+	// 标准化工具格式处理
+	if tools, exists := rawReq["tools"]; exists && tools != nil {
+		if toolsArray, ok := tools.([]any); ok {
+			normalizedTools := make([]map[string]any, 0, len(toolsArray))
+			for _, tool := range toolsArray {
+				if toolMap, ok := tool.(map[string]any); ok {
+					// 检查是否是简化的工具格式（直接包含name, description, input_schema）
+					if name, hasName := toolMap["name"]; hasName {
+						if description, hasDesc := toolMap["description"]; hasDesc {
+							if inputSchema, hasSchema := toolMap["input_schema"]; hasSchema {
+								// 转换为标准Anthropic工具格式
+								normalizedTool := map[string]any{
+									"name":         name,
+									"description":  description,
+									"input_schema": inputSchema,
+								}
+								normalizedTools = append(normalizedTools, normalizedTool)
+								continue
+							}
+						}
+					}
+					// 如果不是简化格式，保持原样
+					normalizedTools = append(normalizedTools, toolMap)
+				}
+			}
+			rawReq["tools"] = normalizedTools
+		}
+	}
+
+	// 重新序列化并解析为AnthropicRequest
+	normalizedBody, err := utils.SafeMarshal(rawReq)
+	if err != nil {
+		logger.Error("重新序列化请求失败", logger.Err(err))
+		respondError(c, http.StatusBadRequest, "处理请求格式失败: %v", err)
+		return types.AnthropicRequest{}, err
+	}
+
+	var anthropicReq types.AnthropicRequest
+	if err := utils.SafeUnmarshal(normalizedBody, &anthropicReq); err != nil {
+		logger.Error("解析标准化请求体失败", logger.Err(err))
+		respondError(c, http.StatusBadRequest, "解析请求体失败: %v", err)
+		return types.AnthropicRequest{}, err
+	}
+	setRequestModel(c, anthropicReq.Model)
+
+	// 验证请求的有效性
+	if len(anthropicReq.Messages) == 0 {
+		logger.Error("请求中没有消息")
+		respondError(c, http.StatusBadRequest, "%s", "messages 数组不能为空")
+		return types.AnthropicRequest{}, errors.New("messages 数组不能为空")
+	}
+
+	// 验证扩展思考参数（与Anthropic API的限制保持一致）
+	if err := validateThinking(anthropicReq); err != nil {
+		logger.Error("thinking参数无效", logger.Err(err))
+		respondError(c, http.StatusBadRequest, "%s", err.Error())
+		return types.AnthropicRequest{}, err
+	}
+
+	// 验证 tool_choice 参数
+	if err := validateToolChoice(anthropicReq); err != nil {
+		logger.Error("tool_choice参数无效", logger.Err(err))
+		respondError(c, http.StatusBadRequest, "%s", err.Error())
+		return types.AnthropicRequest{}, err
+	}
+
+	// 预处理图片（下载 URL 图片、转码、缩小、去除元数据）
+	if err := preprocessRequestImages(c, &anthropicReq); err != nil {
+		return types.AnthropicRequest{}, err
+	}
+
+	// 验证最后一条消息有有效内容
+	lastMsg := anthropicReq.Messages[len(anthropicReq.Messages)-1]
+	content, err := utils.GetMessageContent(lastMsg.Content)
+	if err != nil {
+		logger.Error("获取消息内容失败",
+			logger.Err(err),
+			logger.String("raw_content", fmt.Sprintf("%v", lastMsg.Content)))
+		respondError(c, http.StatusBadRequest, "获取消息内容失败: %v", err)
+		return types.AnthropicRequest{}, err
+	}
+
+	trimmedContent := strings.TrimSpace(content)
+	if trimmedContent == "" || trimmedContent == "answer for user question" {
+		logger.Error("消息内容为空或无效",
+			logger.String("content", content),
+			logger.String("trimmed_content", trimmedContent))
+		respondError(c, http.StatusBadRequest, "%s", "消息内容不能为空")
+		return types.AnthropicRequest{}, errors.New("消息内容不能为空")
+	}
+
+	return anthropicReq, nil
+}
+
 // corsMiddleware CORS中间件
 func corsMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
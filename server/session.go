@@ -2,7 +2,9 @@ package server
 
 import (
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/hex"
+	"sort"
 	"sync"
 	"time"
 
@@ -12,39 +14,74 @@ import (
 const sessionCookieName = "kiro_sid"
 
 // Session 会话数据结构
+// ID 为原始会话ID，只存在于cookie中，不会被持久化；
+// Key 为ID的哈希，用于存储、列表展示和吊销
 type Session struct {
-	ID        string
-	User      string
-	CreatedAt time.Time
-	LastSeen  time.Time
+	ID        string    `json:"-"`
+	Key       string    `json:"key"`
+	User      string    `json:"user"`
+	Role      string    `json:"role,omitempty"` // 为空时视为 admin（兼容旧会话）
+	IP        string    `json:"ip,omitempty"`
+	UserAgent string    `json:"userAgent,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+	LastSeen  time.Time `json:"lastSeen"`
 }
 
 // SessionManager 会话管理器
 type SessionManager struct {
 	mu              sync.RWMutex
-	sessions        map[string]Session
+	sessions        map[string]Session // key: 会话ID哈希
 	idleTimeout     time.Duration
 	absoluteTimeout time.Duration
+	store           SessionStore // 为 nil 时仅保存在内存中
+	dirty           bool         // LastSeen 有未持久化的更新
 	stop            chan struct{}
 }
 
-// NewSessionManager 创建会话管理器
+// NewSessionManager 创建仅保存在内存中的会话管理器
 func NewSessionManager(idleTimeout, absoluteTimeout time.Duration) *SessionManager {
+	return NewSessionManagerWithStore(idleTimeout, absoluteTimeout, nil)
+}
+
+// NewSessionManagerWithStore 创建会话管理器，并从存储中恢复未过期的会话
+func NewSessionManagerWithStore(idleTimeout, absoluteTimeout time.Duration, store SessionStore) *SessionManager {
 	m := &SessionManager{
 		sessions:        make(map[string]Session),
 		idleTimeout:     idleTimeout,
 		absoluteTimeout: absoluteTimeout,
+		store:           store,
 		stop:            make(chan struct{}),
 	}
+
+	if store != nil {
+		sessions, err := store.LoadSessions()
+		if err != nil {
+			logger.Warn("加载持久化会话失败，将使用空会话表", logger.Err(err))
+		}
+		now := time.Now()
+		for _, s := range sessions {
+			if s.Key != "" && !m.isExpired(s, now) {
+				m.sessions[s.Key] = s
+			}
+		}
+	}
+
 	go m.cleanupLoop()
 	logger.Info("会话管理器已启动",
 		logger.String("idle_timeout", idleTimeout.String()),
-		logger.String("absolute_timeout", absoluteTimeout.String()))
+		logger.String("absolute_timeout", absoluteTimeout.String()),
+		logger.Bool("persistent", store != nil),
+		logger.Int("restored_sessions", len(m.sessions)))
 	return m
 }
 
-// CreateSession 创建会话
-func (m *SessionManager) CreateSession(user string) (Session, error) {
+// CreateSession 创建管理员会话
+func (m *SessionManager) CreateSession(user, ip, userAgent string) (Session, error) {
+	return m.CreateSessionWithRole(user, RoleAdmin, ip, userAgent)
+}
+
+// CreateSessionWithRole 创建指定角色的会话
+func (m *SessionManager) CreateSessionWithRole(user, role, ip, userAgent string) (Session, error) {
 	id, err := generateSessionID()
 	if err != nil {
 		return Session{}, err
@@ -53,13 +90,18 @@ func (m *SessionManager) CreateSession(user string) (Session, error) {
 	now := time.Now()
 	s := Session{
 		ID:        id,
+		Key:       sessionKey(id),
 		User:      user,
+		Role:      role,
+		IP:        ip,
+		UserAgent: userAgent,
 		CreatedAt: now,
 		LastSeen:  now,
 	}
 
 	m.mu.Lock()
-	m.sessions[id] = s
+	m.sessions[s.Key] = s
+	m.persistLocked()
 	m.mu.Unlock()
 
 	logger.Debug("创建会话",
@@ -72,36 +114,94 @@ func (m *SessionManager) Validate(id string) (Session, bool) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	s, ok := m.sessions[id]
+	key := sessionKey(id)
+	s, ok := m.sessions[key]
 	if !ok {
 		return Session{}, false
 	}
 
 	now := time.Now()
 	if m.isExpired(s, now) {
-		delete(m.sessions, id)
+		delete(m.sessions, key)
+		m.persistLocked()
 		logger.Debug("会话已过期")
 		return Session{}, false
 	}
 
+	// LastSeen 更新频繁，由清理循环批量持久化
 	s.LastSeen = now
-	m.sessions[id] = s
+	m.sessions[key] = s
+	m.dirty = true
+
+	s.ID = id
 	return s, true
 }
 
 // Delete 删除会话
 func (m *SessionManager) Delete(id string) {
-	m.mu.Lock()
-	delete(m.sessions, id)
-	m.mu.Unlock()
+	m.Revoke(sessionKey(id))
 	logger.Debug("删除会话")
 }
 
-// Close 关闭管理器
+// Revoke 按会话Key吊销会话，返回会话是否存在
+func (m *SessionManager) Revoke(key string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.sessions[key]; !ok {
+		return false
+	}
+	delete(m.sessions, key)
+	m.persistLocked()
+	return true
+}
+
+// List 列出所有有效会话（按最近活动时间倒序）
+func (m *SessionManager) List() []Session {
+	now := time.Now()
+
+	m.mu.RLock()
+	result := make([]Session, 0, len(m.sessions))
+	for _, s := range m.sessions {
+		if !m.isExpired(s, now) {
+			result = append(result, s)
+		}
+	}
+	m.mu.RUnlock()
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].LastSeen.After(result[j].LastSeen)
+	})
+	return result
+}
+
+// Close 关闭管理器，并持久化最新的会话状态
 func (m *SessionManager) Close() {
+	m.mu.Lock()
+	if m.dirty {
+		m.persistLocked()
+	}
+	m.mu.Unlock()
 	close(m.stop)
 }
 
+// persistLocked 将会话写入存储（调用时需持有写锁）
+func (m *SessionManager) persistLocked() {
+	if m.store == nil {
+		return
+	}
+
+	sessions := make([]Session, 0, len(m.sessions))
+	for _, s := range m.sessions {
+		sessions = append(sessions, s)
+	}
+	if err := m.store.SaveSessions(sessions); err != nil {
+		logger.Warn("持久化会话失败", logger.Err(err))
+		return
+	}
+	m.dirty = false
+}
+
 // Count 获取会话数量
 func (m *SessionManager) Count() int {
 	m.mu.RLock()
@@ -130,12 +230,15 @@ func (m *SessionManager) cleanupExpired() {
 	expired := 0
 
 	m.mu.Lock()
-	for id, s := range m.sessions {
+	for key, s := range m.sessions {
 		if m.isExpired(s, now) {
-			delete(m.sessions, id)
+			delete(m.sessions, key)
 			expired++
 		}
 	}
+	if expired > 0 || m.dirty {
+		m.persistLocked()
+	}
 	m.mu.Unlock()
 
 	if expired > 0 {
@@ -163,3 +266,9 @@ func generateSessionID() (string, error) {
 	}
 	return hex.EncodeToString(b), nil
 }
+
+// sessionKey 计算会话ID的哈希，避免在存储中保存原始会话ID
+func sessionKey(id string) string {
+	sum := sha256.Sum256([]byte(id))
+	return hex.EncodeToString(sum[:])
+}
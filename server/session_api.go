@@ -0,0 +1,108 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"kiro2api/logger"
+
+	"github.com/gin-gonic/gin"
+)
+
+// SessionInfo 会话列表中的单个会话
+type SessionInfo struct {
+	Key       string    `json:"key"`
+	User      string    `json:"user"`
+	IP        string    `json:"ip,omitempty"`
+	UserAgent string    `json:"userAgent,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+	LastSeen  time.Time `json:"lastSeen"`
+	Current   bool      `json:"current"` // 是否为当前请求所用的会话
+}
+
+// registerSessionRoutes 注册登录会话管理路由（需要已登录会话）
+func registerSessionRoutes(r *gin.Engine, manager *SessionManager, auditLog *AuditLog) {
+	group := r.Group("/api/sessions")
+	group.Use(AdminAPIAuthGuard())
+
+	// 列出所有有效会话
+	group.GET("", func(c *gin.Context) {
+		handleListSessions(c, manager)
+	})
+
+	// 吊销会话
+	group.DELETE("/:key", func(c *gin.Context) {
+		handleRevokeSession(c, manager, auditLog)
+	})
+}
+
+// handleListSessions 列出所有有效会话
+func handleListSessions(c *gin.Context, manager *SessionManager) {
+	currentKey := ""
+	if sid := GetSessionID(c); sid != "" {
+		currentKey = sessionKey(sid)
+	}
+
+	sessions := manager.List()
+	result := make([]SessionInfo, 0, len(sessions))
+	for _, s := range sessions {
+		result = append(result, SessionInfo{
+			Key:       s.Key,
+			User:      s.User,
+			IP:        s.IP,
+			UserAgent: s.UserAgent,
+			CreatedAt: s.CreatedAt,
+			LastSeen:  s.LastSeen,
+			Current:   s.Key == currentKey,
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":  true,
+		"sessions": result,
+		"total":    len(result),
+	})
+}
+
+// handleRevokeSession 吊销指定会话
+func handleRevokeSession(c *gin.Context, manager *SessionManager, auditLog *AuditLog) {
+	key := c.Param("key")
+
+	var before map[string]any
+	for _, s := range manager.List() {
+		if s.Key == key {
+			before = map[string]any{"user": s.User, "ip": s.IP}
+			break
+		}
+	}
+
+	entity := "session:" + shortSessionKey(key)
+	if !manager.Revoke(key) {
+		err := fmt.Errorf("会话不存在或已过期")
+		auditLog.Record(c, AuditActionSessionRevoke, entity, before, nil, err)
+		c.JSON(http.StatusNotFound, gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+	auditLog.Record(c, AuditActionSessionRevoke, entity, before, nil, nil)
+
+	logger.Info("已吊销登录会话",
+		logger.String("operator", GetSessionUser(c)),
+		logger.String("session", shortSessionKey(key)))
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "会话已吊销",
+	})
+}
+
+// shortSessionKey 截取会话Key前缀，用于日志和审计展示
+func shortSessionKey(key string) string {
+	if len(key) > 12 {
+		return key[:12]
+	}
+	return key
+}
@@ -0,0 +1,144 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"kiro2api/utils"
+)
+
+const defaultSessionStoreFile = "sessions.json"
+
+// SessionStore 会话与登录锁定状态的持久化接口
+// 实现需保证并发安全；返回的数据由调用方持有
+type SessionStore interface {
+	LoadSessions() ([]Session, error)
+	SaveSessions(sessions []Session) error
+	LoadLockouts() (map[string]LoginLockout, error)
+	SaveLockouts(lockouts map[string]LoginLockout) error
+}
+
+// LoginLockout 单个IP的登录尝试计数
+type LoginLockout struct {
+	Count int       `json:"count"`
+	Reset time.Time `json:"reset"`
+}
+
+// NewSessionStore 根据类型创建会话存储
+// storeType: memory（不持久化）或 file（默认）
+func NewSessionStore(storeType, path string) (SessionStore, error) {
+	switch storeType {
+	case "memory":
+		return nil, nil
+	case "", "file":
+		return NewFileSessionStore(path), nil
+	default:
+		return nil, fmt.Errorf("不支持的会话存储类型: %s（可选 memory、file）", storeType)
+	}
+}
+
+// fileSessionState 会话存储文件格式
+type fileSessionState struct {
+	Sessions []Session               `json:"sessions"`
+	Lockouts map[string]LoginLockout `json:"lockouts"`
+}
+
+// FileSessionStore 基于JSON文件的会话存储
+// 会话按ID哈希保存，文件中不包含可直接使用的会话cookie
+type FileSessionStore struct {
+	mu   sync.Mutex
+	path string
+}
+
+// NewFileSessionStore 创建文件会话存储
+func NewFileSessionStore(path string) *FileSessionStore {
+	if path == "" {
+		path = defaultSessionStoreFile
+	}
+	return &FileSessionStore{path: path}
+}
+
+// LoadSessions 加载会话
+func (s *FileSessionStore) LoadSessions() ([]Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	state, err := s.readLocked()
+	if err != nil {
+		return nil, err
+	}
+	return state.Sessions, nil
+}
+
+// SaveSessions 保存会话（保留已有的锁定状态）
+func (s *FileSessionStore) SaveSessions(sessions []Session) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	state, err := s.readLocked()
+	if err != nil {
+		return err
+	}
+	state.Sessions = sessions
+	return s.writeLocked(state)
+}
+
+// LoadLockouts 加载登录锁定状态
+func (s *FileSessionStore) LoadLockouts() (map[string]LoginLockout, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	state, err := s.readLocked()
+	if err != nil {
+		return nil, err
+	}
+	return state.Lockouts, nil
+}
+
+// SaveLockouts 保存登录锁定状态（保留已有的会话）
+func (s *FileSessionStore) SaveLockouts(lockouts map[string]LoginLockout) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	state, err := s.readLocked()
+	if err != nil {
+		return err
+	}
+	state.Lockouts = lockouts
+	return s.writeLocked(state)
+}
+
+// readLocked 读取存储文件（调用时需持有锁）
+func (s *FileSessionStore) readLocked() (fileSessionState, error) {
+	state := fileSessionState{Lockouts: make(map[string]LoginLockout)}
+
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return state, nil
+		}
+		return state, fmt.Errorf("读取会话存储失败: %w", err)
+	}
+	if len(data) == 0 {
+		return state, nil
+	}
+	if err := json.Unmarshal(data, &state); err != nil {
+		return state, fmt.Errorf("解析会话存储失败: %w", err)
+	}
+	if state.Lockouts == nil {
+		state.Lockouts = make(map[string]LoginLockout)
+	}
+	return state, nil
+}
+
+// writeLocked 原子写入存储文件（调用时需持有锁）
+func (s *FileSessionStore) writeLocked(state fileSessionState) error {
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return fmt.Errorf("序列化会话存储失败: %w", err)
+	}
+
+	if err := utils.WriteFileAtomic(s.path, data); err != nil {
+		return fmt.Errorf("写入会话存储失败: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,189 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSessionManager_PersistsAcrossRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sessions.json")
+	store := NewFileSessionStore(path)
+
+	m := NewSessionManagerWithStore(time.Hour, 24*time.Hour, store)
+	session, err := m.CreateSession("admin", "10.0.0.1", "curl/8.0")
+	require.NoError(t, err)
+	m.Close()
+
+	// 存储文件中只保存哈希，不包含原始会话ID
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), session.ID)
+
+	restarted := NewSessionManagerWithStore(time.Hour, 24*time.Hour, NewFileSessionStore(path))
+	defer restarted.Close()
+
+	restored, ok := restarted.Validate(session.ID)
+	require.True(t, ok)
+	assert.Equal(t, "admin", restored.User)
+	assert.Equal(t, "10.0.0.1", restored.IP)
+	assert.Equal(t, session.ID, restored.ID)
+
+	require.True(t, restarted.Revoke(session.Key))
+	_, ok = restarted.Validate(session.ID)
+	assert.False(t, ok)
+
+	// 吊销结果同样持久化
+	again := NewSessionManagerWithStore(time.Hour, 24*time.Hour, NewFileSessionStore(path))
+	defer again.Close()
+	assert.Equal(t, 0, again.Count())
+}
+
+func TestLoginRateLimiter_PersistsLockouts(t *testing.T) {
+	store := NewFileSessionStore(filepath.Join(t.TempDir(), "sessions.json"))
+
+	limiter := newLoginRateLimiter(2, time.Hour, store)
+	assert.True(t, limiter.Allow("1.2.3.4"))
+	assert.True(t, limiter.Allow("1.2.3.4"))
+	assert.False(t, limiter.Allow("1.2.3.4"))
+
+	restarted := newLoginRateLimiter(2, time.Hour, store)
+	assert.False(t, restarted.Allow("1.2.3.4"), "重启后锁定状态应保持")
+	assert.True(t, restarted.Allow("5.6.7.8"))
+}
+
+// countingLockoutStore 记录锁定状态的写入次数
+type countingLockoutStore struct {
+	SessionStore
+	saves int
+}
+
+func (s *countingLockoutStore) SaveLockouts(lockouts map[string]LoginLockout) error {
+	s.saves++
+	return s.SessionStore.SaveLockouts(lockouts)
+}
+
+func TestLoginRateLimiter_PersistsOnlyLockoutChanges(t *testing.T) {
+	store := &countingLockoutStore{SessionStore: NewFileSessionStore(filepath.Join(t.TempDir(), "sessions.json"))}
+
+	limiter := newLoginRateLimiter(3, time.Hour, store)
+	for i := 0; i < 3; i++ {
+		assert.True(t, limiter.Allow("1.2.3.4"))
+	}
+	assert.Equal(t, 0, store.saves, "未达到锁定前不写入")
+
+	for i := 0; i < 5; i++ {
+		assert.False(t, limiter.Allow("1.2.3.4"))
+	}
+	assert.Equal(t, 1, store.saves, "进入锁定时只写入一次")
+
+	// 锁定到期后解除，再写入一次
+	limiter.mu.Lock()
+	bucket := limiter.buckets["1.2.3.4"]
+	bucket.Reset = time.Now().Add(-time.Second)
+	limiter.buckets["1.2.3.4"] = bucket
+	limiter.mu.Unlock()
+	assert.True(t, limiter.Allow("1.2.3.4"))
+	assert.Equal(t, 2, store.saves)
+
+	lockouts, err := store.LoadLockouts()
+	require.NoError(t, err)
+	assert.Empty(t, lockouts)
+}
+
+func TestNewSessionStore_Types(t *testing.T) {
+	store, err := NewSessionStore("memory", "")
+	require.NoError(t, err)
+	assert.Nil(t, store)
+
+	store, err = NewSessionStore("", filepath.Join(t.TempDir(), "s.json"))
+	require.NoError(t, err)
+	assert.IsType(t, &FileSessionStore{}, store)
+
+	_, err = NewSessionStore("redis", "")
+	assert.Error(t, err)
+}
+
+func TestSessionRoutes_ListAndRevoke(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	m := NewSessionManager(time.Hour, 24*time.Hour)
+	defer m.Close()
+	current, err := m.CreateSession("admin", "10.0.0.1", "browser")
+	require.NoError(t, err)
+	other, err := m.CreateSession("admin", "10.0.0.2", "laptop")
+	require.NoError(t, err)
+
+	r := gin.New()
+	r.Use(SessionMiddleware(m))
+	registerSessionRoutes(r, m, nil)
+
+	newRequest := func(method, path string) *http.Request {
+		req := httptest.NewRequest(method, path, nil)
+		req.AddCookie(&http.Cookie{Name: sessionCookieName, Value: current.ID})
+		return req
+	}
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, newRequest(http.MethodGet, "/api/sessions"))
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"total":2`)
+	assert.Contains(t, w.Body.String(), `"current":true`)
+	assert.NotContains(t, w.Body.String(), current.ID)
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, newRequest(http.MethodDelete, "/api/sessions/"+other.Key))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, 1, m.Count())
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, newRequest(http.MethodDelete, "/api/sessions/"+other.Key))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	// 未登录时无法访问
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/sessions", nil))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+// newRoleTestRouter 创建带会话中间件的测试路由，返回以管理员或只读角色发起 GET 请求的函数
+func newRoleTestRouter(t *testing.T) (*gin.Engine, func(role, path string) int) {
+	gin.SetMode(gin.TestMode)
+
+	m := NewSessionManager(time.Hour, 24*time.Hour)
+	t.Cleanup(m.Close)
+	sessions := make(map[string]Session)
+	for _, role := range []string{RoleAdmin, RoleViewer} {
+		session, err := m.CreateSessionWithRole(role+"-user", role, "10.0.0.1", "browser")
+		require.NoError(t, err)
+		sessions[role] = session
+	}
+
+	r := gin.New()
+	r.Use(SessionMiddleware(m))
+	return r, func(role, path string) int {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.AddCookie(&http.Cookie{Name: sessionCookieName, Value: sessions[role].ID})
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+}
+
+func TestAdminOnlyGuard_RejectsViewer(t *testing.T) {
+	r, get := newRoleTestRouter(t)
+	group := r.Group("/api/sensitive", AdminAPIAuthGuard())
+	group.GET("", func(c *gin.Context) { c.Status(http.StatusOK) })
+	group.GET("/detail", AdminOnlyGuard(), func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	assert.Equal(t, http.StatusOK, get(RoleViewer, "/api/sensitive"))
+	assert.Equal(t, http.StatusForbidden, get(RoleViewer, "/api/sensitive/detail"))
+	assert.Equal(t, http.StatusOK, get(RoleAdmin, "/api/sensitive/detail"))
+}
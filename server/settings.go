@@ -0,0 +1,394 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"kiro2api/auth"
+	"kiro2api/config"
+	"kiro2api/logger"
+	"kiro2api/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// defaultSettingsFile 默认运行时设置存储文件（只保存被修改过的项）
+const defaultSettingsFile = "settings.json"
+
+// 设置项类型
+const (
+	SettingTypeInt      = "int"
+	SettingTypeDuration = "duration"
+	SettingTypeEnum     = "enum"
+)
+
+// SettingInfo 设置项描述（API 返回）
+type SettingInfo struct {
+	Key         string   `json:"key"`
+	Type        string   `json:"type"`
+	Description string   `json:"description"`
+	Value       any      `json:"value"`
+	Default     any      `json:"default"`
+	Overridden  bool     `json:"overridden"`
+	Options     []string `json:"options,omitempty"`
+	Min         any      `json:"min,omitempty"`
+	Max         any      `json:"max,omitempty"`
+}
+
+// settingDefinition 设置项定义
+// 值统一使用规范化后的 JSON 表示：int 为整数，duration 为 Go 时长字符串，enum 为字符串
+type settingDefinition struct {
+	key         string
+	typ         string
+	description string
+	options     []string
+	min, max    int64 // int 为数值范围，duration 为纳秒范围
+	get         func() any
+	apply       func(value any)
+}
+
+// parse 校验并规范化外部传入的值
+func (d *settingDefinition) parse(raw json.RawMessage) (any, error) {
+	switch d.typ {
+	case SettingTypeInt:
+		var n int64
+		if err := json.Unmarshal(raw, &n); err != nil {
+			return nil, fmt.Errorf("%s 需要整数", d.key)
+		}
+		if n < d.min || n > d.max {
+			return nil, fmt.Errorf("%s 超出范围 [%d, %d]", d.key, d.min, d.max)
+		}
+		return int(n), nil
+	case SettingTypeDuration:
+		var s string
+		if err := json.Unmarshal(raw, &s); err != nil {
+			return nil, fmt.Errorf("%s 需要时长字符串（如 5m、30s）", d.key)
+		}
+		v, err := time.ParseDuration(s)
+		if err != nil {
+			return nil, fmt.Errorf("%s 时长格式无效: %s", d.key, s)
+		}
+		if int64(v) < d.min || int64(v) > d.max {
+			return nil, fmt.Errorf("%s 超出范围 [%s, %s]", d.key, time.Duration(d.min), time.Duration(d.max))
+		}
+		return v.String(), nil
+	case SettingTypeEnum:
+		var s string
+		if err := json.Unmarshal(raw, &s); err != nil {
+			return nil, fmt.Errorf("%s 需要字符串", d.key)
+		}
+		s = strings.ToLower(strings.TrimSpace(s))
+		for _, opt := range d.options {
+			if opt == s {
+				return s, nil
+			}
+		}
+		return nil, fmt.Errorf("%s 可选值为 %s", d.key, strings.Join(d.options, "/"))
+	}
+	return nil, fmt.Errorf("未知的设置类型: %s", d.typ)
+}
+
+// info 生成 API 描述
+func (d *settingDefinition) info(defaultValue any, overridden bool) SettingInfo {
+	info := SettingInfo{
+		Key:         d.key,
+		Type:        d.typ,
+		Description: d.description,
+		Value:       d.get(),
+		Default:     defaultValue,
+		Overridden:  overridden,
+		Options:     d.options,
+	}
+	switch d.typ {
+	case SettingTypeInt:
+		info.Min, info.Max = d.min, d.max
+	case SettingTypeDuration:
+		info.Min, info.Max = time.Duration(d.min).String(), time.Duration(d.max).String()
+	}
+	return info
+}
+
+// builtinSettings 内置的运行时设置项
+// authService 用于把账号相关设置同步到当前的 TokenManager（可为 nil）
+func builtinSettings(authService *auth.AuthService) []*settingDefinition {
+	tokenManager := func() *auth.TokenManager {
+		if authService == nil {
+			return nil
+		}
+		return authService.GetTokenManager()
+	}
+
+	return []*settingDefinition{
+		{
+			key:         "log_level",
+			typ:         SettingTypeEnum,
+			description: "日志级别",
+			options:     []string{"debug", "info", "warn", "error"},
+			get:         func() any { return strings.ToLower(logger.GetLevel().String()) },
+			apply: func(v any) {
+				level, _ := logger.ParseLevel(v.(string))
+				logger.SetLevel(level)
+			},
+		},
+		{
+			key:         "max_tool_description_length",
+			typ:         SettingTypeInt,
+			description: "工具描述的最大长度（字符数，超出截断）",
+			min:         100,
+			max:         1000000,
+			get:         func() any { return config.GetMaxToolDescriptionLength() },
+			apply:       func(v any) { config.SetMaxToolDescriptionLength(v.(int)) },
+		},
+		{
+			key:         "parser_max_errors",
+			typ:         SettingTypeInt,
+			description: "事件流解析器容忍的最大错误次数",
+			min:         1,
+			max:         1000,
+			get:         func() any { return config.GetParserMaxErrors() },
+			apply:       func(v any) { config.SetParserMaxErrors(v.(int)) },
+		},
+		{
+			key:         "token_cache_ttl",
+			typ:         SettingTypeDuration,
+			description: "账号Token缓存的生存时间，过期后重新刷新",
+			min:         int64(10 * time.Second),
+			max:         int64(24 * time.Hour),
+			get:         func() any { return config.GetTokenCacheTTL().String() },
+			apply: func(v any) {
+				ttl, _ := time.ParseDuration(v.(string))
+				config.SetTokenCacheTTL(ttl)
+				if tm := tokenManager(); tm != nil {
+					tm.SetCacheTTL(ttl)
+				}
+			},
+		},
+		{
+			key:         "token_selection_strategy",
+			typ:         SettingTypeEnum,
+			description: "账号选择策略（sequential 用尽再切换，round_robin 每次请求轮转）",
+			options:     config.SelectionStrategies,
+			get:         func() any { return config.GetSelectionStrategy() },
+			apply: func(v any) {
+				config.SetSelectionStrategy(v.(string))
+				if tm := tokenManager(); tm != nil {
+					tm.SetSelectionStrategy(v.(string))
+				}
+			},
+		},
+	}
+}
+
+// SettingsRegistry 运行时设置注册表
+// 启动时记录环境变量决定的初值作为默认值，修改过的项持久化到文件并在重启后重新应用
+type SettingsRegistry struct {
+	mu        sync.Mutex
+	path      string
+	defs      []*settingDefinition
+	byKey     map[string]*settingDefinition
+	defaults  map[string]any
+	overrides map[string]any
+}
+
+// NewSettingsRegistry 创建设置注册表并应用已持久化的修改
+func NewSettingsRegistry(path string, authService *auth.AuthService) (*SettingsRegistry, error) {
+	if path == "" {
+		path = defaultSettingsFile
+	}
+
+	r := &SettingsRegistry{
+		path:      path,
+		defs:      builtinSettings(authService),
+		byKey:     make(map[string]*settingDefinition),
+		defaults:  make(map[string]any),
+		overrides: make(map[string]any),
+	}
+	for _, d := range r.defs {
+		r.byKey[d.key] = d
+		r.defaults[d.key] = d.get()
+	}
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// List 返回所有设置项
+func (r *SettingsRegistry) List() []SettingInfo {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	list := make([]SettingInfo, 0, len(r.defs))
+	for _, d := range r.defs {
+		_, overridden := r.overrides[d.key]
+		list = append(list, d.info(r.defaults[d.key], overridden))
+	}
+	return list
+}
+
+// Update 批量修改设置，全部校验通过后才生效
+// 值为 null 表示恢复默认值；返回实际发生变化的项修改前后的值
+func (r *SettingsRegistry) Update(changes map[string]json.RawMessage) (before, after map[string]any, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	parsed := make(map[string]any, len(changes))
+	keys := make([]string, 0, len(changes))
+	for key, raw := range changes {
+		d, ok := r.byKey[key]
+		if !ok {
+			return nil, nil, fmt.Errorf("未知的设置项: %s", key)
+		}
+		if string(raw) == "null" {
+			parsed[key] = nil
+		} else {
+			v, err := d.parse(raw)
+			if err != nil {
+				return nil, nil, err
+			}
+			parsed[key] = v
+		}
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	// 先写文件，失败时内存状态保持不变
+	overrides := make(map[string]any, len(r.overrides)+len(parsed))
+	for k, v := range r.overrides {
+		overrides[k] = v
+	}
+	for key, v := range parsed {
+		if v == nil {
+			delete(overrides, key)
+		} else {
+			overrides[key] = v
+		}
+	}
+	if err := r.save(overrides); err != nil {
+		return nil, nil, err
+	}
+	r.overrides = overrides
+
+	before = make(map[string]any)
+	after = make(map[string]any)
+	for _, key := range keys {
+		d := r.byKey[key]
+		value := parsed[key]
+		if value == nil {
+			value = r.defaults[key]
+		}
+		old := d.get()
+		if old == value {
+			continue
+		}
+		d.apply(value)
+		before[key] = old
+		after[key] = value
+		logger.Info("运行时设置已修改",
+			logger.String("key", key),
+			logger.Any("before", old),
+			logger.Any("after", value))
+	}
+	return before, after, nil
+}
+
+// load 读取并应用持久化的修改，无效的项记录警告后忽略
+func (r *SettingsRegistry) load() error {
+	data, err := os.ReadFile(r.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("读取设置文件失败: %w", err)
+	}
+	if len(data) == 0 {
+		return nil
+	}
+
+	var stored map[string]json.RawMessage
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return fmt.Errorf("解析设置文件失败: %w", err)
+	}
+
+	for key, raw := range stored {
+		d, ok := r.byKey[key]
+		if !ok {
+			logger.Warn("忽略未知的设置项", logger.String("key", key))
+			continue
+		}
+		v, err := d.parse(raw)
+		if err != nil {
+			logger.Warn("忽略无效的设置项", logger.String("key", key), logger.Err(err))
+			continue
+		}
+		d.apply(v)
+		r.overrides[key] = v
+	}
+	if len(r.overrides) > 0 {
+		logger.Info("已应用持久化的运行时设置", logger.Int("count", len(r.overrides)))
+	}
+	return nil
+}
+
+// save 原子写入修改过的设置项
+func (r *SettingsRegistry) save(overrides map[string]any) error {
+	data, err := json.MarshalIndent(overrides, "", "  ")
+	if err != nil {
+		return fmt.Errorf("序列化设置失败: %w", err)
+	}
+	if err := utils.WriteFileAtomic(r.path, data); err != nil {
+		return fmt.Errorf("保存设置文件失败: %w", err)
+	}
+	return nil
+}
+
+// registerSettingsRoutes 注册运行时设置路由
+func registerSettingsRoutes(r *gin.Engine, registry *SettingsRegistry, auditLog *AuditLog, requireAuth bool) {
+	group := r.Group("/api/settings")
+	if requireAuth {
+		group.Use(AdminAPIAuthGuard())
+	}
+
+	group.GET("", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{
+			"success":  true,
+			"settings": registry.List(),
+		})
+	})
+
+	// 请求体为 {"key": value, ...}，value 为 null 时恢复默认值
+	group.PUT("", func(c *gin.Context) {
+		var changes map[string]json.RawMessage
+		if err := c.ShouldBindJSON(&changes); err != nil || len(changes) == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"error":   "请求体需要为非空的设置项对象",
+			})
+			return
+		}
+
+		before, after, err := registry.Update(changes)
+		if err != nil {
+			auditLog.Record(c, AuditActionSettingsUpdate, "settings", nil, nil, err)
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"error":   err.Error(),
+			})
+			return
+		}
+		if len(after) > 0 {
+			auditLog.Record(c, AuditActionSettingsUpdate, "settings", before, after, nil)
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"success":  true,
+			"settings": registry.List(),
+		})
+	})
+}
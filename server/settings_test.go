@@ -0,0 +1,131 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"kiro2api/config"
+	"kiro2api/logger"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// restoreRuntimeSettings 测试结束后恢复全局运行时参数
+func restoreRuntimeSettings(t *testing.T) {
+	level := logger.GetLevel()
+	maxDesc := config.GetMaxToolDescriptionLength()
+	maxErrors := config.GetParserMaxErrors()
+	ttl := config.GetTokenCacheTTL()
+	strategy := config.GetSelectionStrategy()
+	t.Cleanup(func() {
+		logger.SetLevel(level)
+		config.SetMaxToolDescriptionLength(maxDesc)
+		config.SetParserMaxErrors(maxErrors)
+		config.SetTokenCacheTTL(ttl)
+		config.SetSelectionStrategy(strategy)
+	})
+}
+
+func TestSettingsRegistry_UpdateAppliesAndPersists(t *testing.T) {
+	restoreRuntimeSettings(t)
+	path := filepath.Join(t.TempDir(), "settings.json")
+
+	registry, err := NewSettingsRegistry(path, nil)
+	require.NoError(t, err)
+
+	before, after, err := registry.Update(map[string]json.RawMessage{
+		"log_level":                   json.RawMessage(`"WARN"`),
+		"max_tool_description_length": json.RawMessage(`500`),
+		"token_cache_ttl":             json.RawMessage(`"90s"`),
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "warn", after["log_level"])
+	assert.Equal(t, "1m30s", after["token_cache_ttl"])
+	assert.Contains(t, before, "max_tool_description_length")
+
+	assert.Equal(t, logger.WARN, logger.GetLevel())
+	assert.Equal(t, 500, config.GetMaxToolDescriptionLength())
+	assert.Equal(t, 90*time.Second, config.GetTokenCacheTTL())
+
+	// 模拟重启：恢复初值后重新加载持久化的修改
+	config.SetMaxToolDescriptionLength(10000)
+	_, err = NewSettingsRegistry(path, nil)
+	require.NoError(t, err)
+	assert.Equal(t, 500, config.GetMaxToolDescriptionLength())
+
+	// null 恢复默认值并从文件中移除
+	_, after, err = registry.Update(map[string]json.RawMessage{"max_tool_description_length": json.RawMessage(`null`)})
+	require.NoError(t, err)
+	assert.Equal(t, 10000, after["max_tool_description_length"])
+	for _, s := range registry.List() {
+		if s.Key == "max_tool_description_length" {
+			assert.False(t, s.Overridden)
+		}
+	}
+}
+
+func TestSettingsRegistry_RejectsInvalidValues(t *testing.T) {
+	restoreRuntimeSettings(t)
+	registry, err := NewSettingsRegistry(filepath.Join(t.TempDir(), "settings.json"), nil)
+	require.NoError(t, err)
+
+	cases := map[string]string{
+		"parser_max_errors":        `0`,
+		"token_cache_ttl":          `"1s"`,
+		"token_selection_strategy": `"random"`,
+		"log_level":                `3`,
+		"unknown_key":              `1`,
+	}
+	for key, raw := range cases {
+		_, _, err := registry.Update(map[string]json.RawMessage{
+			"parser_max_errors": json.RawMessage(`7`),
+			key:                 json.RawMessage(raw),
+		})
+		assert.Error(t, err, key)
+	}
+	assert.Equal(t, config.ParserMaxErrors, config.GetParserMaxErrors(), "校验失败时整批不生效")
+}
+
+func TestSettingsRoutes(t *testing.T) {
+	restoreRuntimeSettings(t)
+	gin.SetMode(gin.TestMode)
+	registry, err := NewSettingsRegistry(filepath.Join(t.TempDir(), "settings.json"), nil)
+	require.NoError(t, err)
+	auditLog, err := NewAuditLog(filepath.Join(t.TempDir(), "audit.jsonl"))
+	require.NoError(t, err)
+
+	r := gin.New()
+	registerSettingsRoutes(r, registry, auditLog, false)
+
+	req := httptest.NewRequest(http.MethodPut, "/api/settings", strings.NewReader(`{"token_selection_strategy":"round_robin"}`))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, config.SelectionRoundRobin, config.GetSelectionStrategy())
+
+	entries := auditLog.Query(AuditFilter{Action: AuditActionSettingsUpdate})
+	require.Len(t, entries, 1)
+	assert.Equal(t, "round_robin", entries[0].After["token_selection_strategy"])
+
+	req = httptest.NewRequest(http.MethodPut, "/api/settings", strings.NewReader(`{}`))
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/settings", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	var resp struct {
+		Settings []SettingInfo `json:"settings"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Len(t, resp.Settings, 5)
+}
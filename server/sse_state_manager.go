@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"kiro2api/logger"
+	"kiro2api/metrics"
 
 	"github.com/gin-gonic/gin"
 )
@@ -11,10 +12,11 @@ import (
 // BlockState 内容块状态
 type BlockState struct {
 	Index     int    `json:"index"`
-	Type      string `json:"type"` // "text" | "tool_use"
+	Type      string `json:"type"` // "text" | "tool_use" | "thinking"
 	Started   bool   `json:"started"`
 	Stopped   bool   `json:"stopped"`
 	ToolUseID string `json:"tool_use_id,omitempty"` // 仅用于工具块
+	Signed    bool   `json:"signed,omitempty"`      // 仅用于思考块：是否已发送signature_delta
 }
 
 // SSEStateManager SSE事件状态管理器，确保事件序列符合Claude规范
@@ -75,6 +77,7 @@ func (ssm *SSEStateManager) SendEvent(c *gin.Context, sender StreamEventSender,
 func (ssm *SSEStateManager) handleMessageStart(c *gin.Context, sender StreamEventSender, eventData map[string]any) error {
 	if ssm.messageStarted {
 		errMsg := "违规：message_start只能出现一次"
+		metrics.SSEStateViolations.Inc("message_start", "duplicate")
 		logger.Error(errMsg)
 		if ssm.strictMode {
 			return errors.New(errMsg)
@@ -90,6 +93,7 @@ func (ssm *SSEStateManager) handleMessageStart(c *gin.Context, sender StreamEven
 func (ssm *SSEStateManager) handleContentBlockStart(c *gin.Context, sender StreamEventSender, eventData map[string]any) error {
 	if !ssm.messageStarted {
 		errMsg := "违规：content_block_start必须在message_start之后"
+		metrics.SSEStateViolations.Inc("content_block_start", "before_message_start")
 		logger.Error(errMsg)
 		if ssm.strictMode {
 			return errors.New(errMsg)
@@ -98,6 +102,7 @@ func (ssm *SSEStateManager) handleContentBlockStart(c *gin.Context, sender Strea
 
 	if ssm.messageEnded {
 		errMsg := "违规：message已结束，不能发送content_block_start"
+		metrics.SSEStateViolations.Inc("content_block_start", "after_message_end")
 		logger.Error(errMsg)
 		if ssm.strictMode {
 			return errors.New(errMsg)
@@ -118,6 +123,7 @@ func (ssm *SSEStateManager) handleContentBlockStart(c *gin.Context, sender Strea
 	// 检查是否重复启动同一块
 	if block, exists := ssm.activeBlocks[index]; exists && block.Started && !block.Stopped {
 		errMsg := fmt.Sprintf("违规：索引%d的content_block已经started但未stopped", index)
+		metrics.SSEStateViolations.Inc("content_block_start", "duplicate")
 		logger.Error(errMsg, logger.Int("block_index", index))
 		if ssm.strictMode {
 			return errors.New(errMsg)
@@ -133,6 +139,18 @@ func (ssm *SSEStateManager) handleContentBlockStart(c *gin.Context, sender Strea
 		}
 	}
 
+	// 思考块必须在后续内容块开始前结束（Claude规范中思考块总是位于回复最前面）
+	if blockType != "thinking" {
+		for blockIndex, block := range ssm.activeBlocks {
+			if block.Type == "thinking" && block.Started && !block.Stopped {
+				logger.Debug("新内容块启动前自动关闭思考块",
+					logger.Int("thinking_block_index", blockIndex),
+					logger.Int("new_block_index", index))
+				ssm.closeBlock(c, sender, block)
+			}
+		}
+	}
+
 	// *** 关键修复：在启动新工具块前，自动关闭文本块 ***
 	// 问题场景：AWS上游在工具调用(index:1+)期间仍发送文本内容给index:0
 	// 如果不在此时关闭index:0，会导致事件序列混乱：
@@ -207,6 +225,7 @@ func (ssm *SSEStateManager) handleContentBlockDelta(c *gin.Context, sender Strea
 			index = int(indexFloat)
 		} else {
 			errMsg := "content_block_delta缺少有效索引"
+			metrics.SSEStateViolations.Inc("content_block_delta", "missing_index")
 			logger.Error(errMsg)
 			if ssm.strictMode {
 				return errors.New(errMsg)
@@ -225,8 +244,11 @@ func (ssm *SSEStateManager) handleContentBlockDelta(c *gin.Context, sender Strea
 		blockType := "text" // 默认为文本块
 		if delta, ok := eventData["delta"].(map[string]any); ok {
 			if deltaType, ok := delta["type"].(string); ok {
-				if deltaType == "input_json_delta" {
+				switch deltaType {
+				case "input_json_delta":
 					blockType = "tool_use"
+				case "thinking_delta", "signature_delta":
+					blockType = "thinking"
 				}
 			}
 		}
@@ -243,6 +265,8 @@ func (ssm *SSEStateManager) handleContentBlockDelta(c *gin.Context, sender Strea
 		switch blockType {
 		case "text":
 			startEvent["content_block"].(map[string]any)["text"] = ""
+		case "thinking":
+			startEvent["content_block"].(map[string]any)["thinking"] = ""
 		case "tool_use":
 			// 为工具使用块添加必要字段
 			startEvent["content_block"].(map[string]any)["id"] = fmt.Sprintf("tooluse_auto_%d", index)
@@ -261,6 +285,7 @@ func (ssm *SSEStateManager) handleContentBlockDelta(c *gin.Context, sender Strea
 
 	if block != nil && block.Stopped {
 		errMsg := fmt.Sprintf("违规：索引%d的content_block已停止，不能发送delta", index)
+		metrics.SSEStateViolations.Inc("content_block_delta", "block_stopped")
 		logger.Error(errMsg, logger.Int("block_index", index), logger.Any("eventData", eventData))
 		if ssm.strictMode {
 			return errors.New(errMsg)
@@ -268,9 +293,46 @@ func (ssm *SSEStateManager) handleContentBlockDelta(c *gin.Context, sender Strea
 		return nil
 	}
 
+	if block != nil && block.Type == "thinking" {
+		if delta, ok := eventData["delta"].(map[string]any); ok && delta["type"] == "signature_delta" {
+			block.Signed = true
+		}
+	}
+
 	return sender.SendEvent(c, eventData)
 }
 
+// closeBlock 关闭内容块，思考块在关闭前补发signature_delta
+func (ssm *SSEStateManager) closeBlock(c *gin.Context, sender StreamEventSender, block *BlockState) {
+	if block.Type == "thinking" && !block.Signed {
+		ssm.sendThinkingSignature(c, sender, block)
+	}
+	stopEvent := map[string]any{
+		"type":  "content_block_stop",
+		"index": block.Index,
+	}
+	if err := sender.SendEvent(c, stopEvent); err != nil {
+		logger.Error("自动关闭content_block失败", logger.Err(err), logger.Int("index", block.Index))
+	}
+	block.Stopped = true
+}
+
+// sendThinkingSignature 为思考块发送signature_delta（Claude规范要求思考块在结束前携带签名）
+func (ssm *SSEStateManager) sendThinkingSignature(c *gin.Context, sender StreamEventSender, block *BlockState) {
+	signatureEvent := map[string]any{
+		"type":  "content_block_delta",
+		"index": block.Index,
+		"delta": map[string]any{
+			"type":      "signature_delta",
+			"signature": newThinkingSignature(),
+		},
+	}
+	if err := sender.SendEvent(c, signatureEvent); err != nil {
+		logger.Error("发送signature_delta失败", logger.Err(err), logger.Int("index", block.Index))
+	}
+	block.Signed = true
+}
+
 // handleContentBlockStop 处理内容块停止事件
 func (ssm *SSEStateManager) handleContentBlockStop(c *gin.Context, sender StreamEventSender, eventData map[string]any) error {
 	index, ok := eventData["index"].(int)
@@ -279,6 +341,7 @@ func (ssm *SSEStateManager) handleContentBlockStop(c *gin.Context, sender Stream
 			index = int(indexFloat)
 		} else {
 			errMsg := "content_block_stop缺少有效索引"
+			metrics.SSEStateViolations.Inc("content_block_stop", "missing_index")
 			logger.Error(errMsg)
 			if ssm.strictMode {
 				return errors.New(errMsg)
@@ -291,6 +354,7 @@ func (ssm *SSEStateManager) handleContentBlockStop(c *gin.Context, sender Stream
 	block, exists := ssm.activeBlocks[index]
 	if !exists || !block.Started {
 		errMsg := fmt.Sprintf("违规：索引%d的content_block未启动就发送stop", index)
+		metrics.SSEStateViolations.Inc("content_block_stop", "not_started")
 		logger.Error(errMsg, logger.Int("block_index", index))
 		if ssm.strictMode {
 			return errors.New(errMsg)
@@ -300,6 +364,7 @@ func (ssm *SSEStateManager) handleContentBlockStop(c *gin.Context, sender Stream
 
 	if block.Stopped {
 		errMsg := fmt.Sprintf("违规：索引%d的content_block重复停止", index)
+		metrics.SSEStateViolations.Inc("content_block_stop", "duplicate")
 		logger.Error(errMsg, logger.Int("block_index", index))
 		if ssm.strictMode {
 			return errors.New(errMsg)
@@ -307,6 +372,10 @@ func (ssm *SSEStateManager) handleContentBlockStop(c *gin.Context, sender Stream
 		return nil
 	}
 
+	if block.Type == "thinking" && !block.Signed {
+		ssm.sendThinkingSignature(c, sender, block)
+	}
+
 	// 标记为已停止
 	block.Stopped = true
 
@@ -317,6 +386,7 @@ func (ssm *SSEStateManager) handleContentBlockStop(c *gin.Context, sender Stream
 func (ssm *SSEStateManager) handleMessageDelta(c *gin.Context, sender StreamEventSender, eventData map[string]any) error {
 	if !ssm.messageStarted {
 		errMsg := "违规：message_delta必须在message_start之后"
+		metrics.SSEStateViolations.Inc("message_delta", "before_message_start")
 		logger.Error(errMsg)
 		if ssm.strictMode {
 			return errors.New(errMsg)
@@ -327,6 +397,7 @@ func (ssm *SSEStateManager) handleMessageDelta(c *gin.Context, sender StreamEven
 	// 根据Claude规范，message_delta在一次消息中只能出现一次
 	if ssm.messageDeltaSent {
 		errMsg := "违规：message_delta只能出现一次"
+		metrics.SSEStateViolations.Inc("message_delta", "duplicate")
 		logger.Error(errMsg,
 			logger.Bool("message_started", ssm.messageStarted),
 			logger.Bool("message_delta_sent", ssm.messageDeltaSent),
@@ -353,12 +424,7 @@ func (ssm *SSEStateManager) handleMessageDelta(c *gin.Context, sender StreamEven
 		// 在非严格模式下，自动关闭未关闭的块
 		if !ssm.strictMode {
 			for _, index := range unclosedBlocks {
-				stopEvent := map[string]any{
-					"type":  "content_block_stop",
-					"index": index,
-				}
-				sender.SendEvent(c, stopEvent)
-				ssm.activeBlocks[index].Stopped = true
+				ssm.closeBlock(c, sender, ssm.activeBlocks[index])
 				logger.Debug("自动关闭未关闭的content_block（message_delta前）", logger.Int("index", index))
 			}
 		}
@@ -374,6 +440,7 @@ func (ssm *SSEStateManager) handleMessageDelta(c *gin.Context, sender StreamEven
 func (ssm *SSEStateManager) handleMessageStop(c *gin.Context, sender StreamEventSender, eventData map[string]any) error {
 	if !ssm.messageStarted {
 		errMsg := "违规：message_stop必须在message_start之后"
+		metrics.SSEStateViolations.Inc("message_stop", "before_message_start")
 		logger.Error(errMsg)
 		if ssm.strictMode {
 			return errors.New(errMsg)
@@ -382,6 +449,7 @@ func (ssm *SSEStateManager) handleMessageStop(c *gin.Context, sender StreamEvent
 
 	if ssm.messageEnded {
 		errMsg := "违规：message_stop只能出现一次"
+		metrics.SSEStateViolations.Inc("message_stop", "duplicate")
 		logger.Error(errMsg)
 		if ssm.strictMode {
 			return errors.New(errMsg)
@@ -9,6 +9,8 @@ import (
 type StopReasonManager struct {
 	hasActiveToolCalls bool
 	hasCompletedTools  bool
+	stopSequence       string // 本地匹配到的停止序列
+	maxTokensReached   bool   // 输出已在本地按 max_tokens 截断
 }
 
 // NewStopReasonManager 创建stop_reason管理器
@@ -29,8 +31,32 @@ func (srm *StopReasonManager) UpdateToolCallStatus(hasActiveCalls, hasCompleted
 		logger.Bool("has_completed_tools", hasCompleted))
 }
 
+// SetStopSequence 记录匹配到的停止序列，输出在此处被截断
+func (srm *StopReasonManager) SetStopSequence(sequence string) {
+	srm.stopSequence = sequence
+}
+
+// StopSequence 返回匹配到的停止序列，未匹配时为空
+func (srm *StopReasonManager) StopSequence() string {
+	return srm.stopSequence
+}
+
+// SetMaxTokensReached 记录输出已按 max_tokens 截断
+func (srm *StopReasonManager) SetMaxTokensReached() {
+	srm.maxTokensReached = true
+}
+
 // DetermineStopReason 根据Claude官方规范确定stop_reason
 func (srm *StopReasonManager) DetermineStopReason() string {
+	// 匹配到停止序列时输出已被截断，之后的工具调用不会下发
+	if srm.stopSequence != "" {
+		return "stop_sequence"
+	}
+
+	// 达到 max_tokens 时工具块可能不完整，优先于 tool_use
+	if srm.maxTokensReached {
+		return "max_tokens"
+	}
 
 	// 检查是否有工具调用（活跃或已完成）
 	// *** 关键修复：根据Claude规范，只要消息包含tool_use块，stop_reason就应该是tool_use ***
@@ -0,0 +1,127 @@
+package server
+
+import (
+	"strings"
+
+	"kiro2api/logger"
+)
+
+// stopSequenceMatcher 在流式文本中匹配停止序列
+// 停止序列可能被拆分在多个增量中，可能构成停止序列开头的尾部会暂存，确认不匹配后再输出
+type stopSequenceMatcher struct {
+	sequences []string
+	pending   string
+}
+
+// newStopSequenceMatcher 创建停止序列匹配器，没有有效停止序列时返回 nil
+func newStopSequenceMatcher(sequences []string) *stopSequenceMatcher {
+	var valid []string
+	for _, seq := range sequences {
+		if seq != "" {
+			valid = append(valid, seq)
+		}
+	}
+	if len(valid) == 0 {
+		return nil
+	}
+	return &stopSequenceMatcher{sequences: valid}
+}
+
+// Feed 输入一段文本增量，返回可以输出的文本和匹配到的停止序列（未匹配时为空）
+// 匹配后停止序列本身及之后的内容都不输出
+func (m *stopSequenceMatcher) Feed(text string) (string, string) {
+	buf := m.pending + text
+	m.pending = ""
+
+	// 以最先完整出现的停止序列为准（与模型生成到该序列即停止的行为一致）
+	cut, end, matched := -1, -1, ""
+	for _, seq := range m.sequences {
+		if i := strings.Index(buf, seq); i >= 0 && (end < 0 || i+len(seq) < end) {
+			cut, end, matched = i, i+len(seq), seq
+		}
+	}
+	if matched != "" {
+		return buf[:cut], matched
+	}
+
+	keep := 0
+	for _, seq := range m.sequences {
+		keep = max(keep, partialSuffixLen(buf, seq))
+	}
+	m.pending = buf[len(buf)-keep:]
+	return buf[:len(buf)-keep], ""
+}
+
+// Flush 输出暂存的文本（流结束或文本块结束时调用）
+func (m *stopSequenceMatcher) Flush() string {
+	pending := m.pending
+	m.pending = ""
+	return pending
+}
+
+// cutAtStopSequence 在完整文本中匹配停止序列（非流式响应使用）
+// 返回截断后的文本和匹配到的停止序列
+func cutAtStopSequence(text string, sequences []string) (string, string) {
+	matcher := newStopSequenceMatcher(sequences)
+	if matcher == nil {
+		return text, ""
+	}
+	out, matched := matcher.Feed(text)
+	if matched != "" {
+		return out, matched
+	}
+	return out + matcher.Flush(), ""
+}
+
+// stopSequenceValue 返回响应中 stop_sequence 字段的值，未匹配时为 null
+func stopSequenceValue(sequence string) any {
+	if sequence == "" {
+		return nil
+	}
+	return sequence
+}
+
+// sendTextDelta 下发文本增量
+// 配置了停止序列时先经过匹配，匹配后截断输出，并标记流在本地结束
+func (ctx *StreamProcessorContext) sendTextDelta(index int, text string) {
+	if ctx.stopped {
+		return
+	}
+	if ctx.stopMatcher != nil {
+		var matched string
+		text, matched = ctx.stopMatcher.Feed(text)
+		ctx.pendingTextIndex = index
+		if matched != "" {
+			logger.Debug("输出匹配到停止序列", addReqFields(ctx.c, logger.String("stop_sequence", matched))...)
+			ctx.stopReasonManager.SetStopSequence(matched)
+			ctx.stopped = true
+		}
+	}
+	ctx.emitTextDelta(index, text)
+}
+
+// emitTextDelta 通过状态管理器发送文本增量并累计输出 token，超出 max_tokens 的部分被截断
+func (ctx *StreamProcessorContext) emitTextDelta(index int, text string) {
+	text = ctx.fitOutputText(text)
+	if text == "" {
+		return
+	}
+	event := map[string]any{
+		"type":  "content_block_delta",
+		"index": index,
+		"delta": map[string]any{"type": "text_delta", "text": text},
+	}
+	if err := ctx.sseStateManager.SendEvent(ctx.c, ctx.sender, event); err != nil {
+		logger.Error("SSE事件发送违规", logger.Err(err))
+		return
+	}
+	ctx.totalOutputTokens += ctx.tokenEstimator.EstimateTextTokens(text)
+}
+
+// flushPendingText 输出思考拆分器和停止序列匹配器中暂存的文本
+func (ctx *StreamProcessorContext) flushPendingText() {
+	ctx.flushThinking()
+	if ctx.stopMatcher != nil && !ctx.stopped {
+		ctx.emitTextDelta(ctx.pendingTextIndex, ctx.stopMatcher.Flush())
+	}
+}
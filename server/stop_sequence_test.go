@@ -0,0 +1,101 @@
+package server
+
+import (
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"kiro2api/types"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStopSequenceMatcher_AcrossDeltas(t *testing.T) {
+	matcher := newStopSequenceMatcher([]string{"", "\n\nHuman:", "STOP"})
+	require.NotNil(t, matcher)
+
+	var out strings.Builder
+	var matched string
+	for _, chunk := range []string{"Hello\n", "\nHu", "man: ignored"} {
+		text, m := matcher.Feed(chunk)
+		out.WriteString(text)
+		if m != "" {
+			matched = m
+			break
+		}
+	}
+	assert.Equal(t, "Hello", out.String())
+	assert.Equal(t, "\n\nHuman:", matched)
+
+	// 部分匹配后未能构成停止序列的内容需要原样输出
+	matcher = newStopSequenceMatcher([]string{"STOP"})
+	text, m := matcher.Feed("go ST")
+	assert.Equal(t, "go ", text)
+	assert.Empty(t, m)
+	text, m = matcher.Feed("ART")
+	assert.Equal(t, "START", text)
+	assert.Empty(t, m)
+	text, _ = matcher.Feed("ST")
+	assert.Empty(t, text)
+	assert.Equal(t, "ST", matcher.Flush())
+
+	assert.Nil(t, newStopSequenceMatcher([]string{""}))
+}
+
+func TestCutAtStopSequence(t *testing.T) {
+	// 以最先完整出现的停止序列为准
+	text, matched := cutAtStopSequence("abcde", []string{"abcdX", "c"})
+	assert.Equal(t, "ab", text)
+	assert.Equal(t, "c", matched)
+
+	text, matched = cutAtStopSequence("no match", []string{"xyz"})
+	assert.Equal(t, "no match", text)
+	assert.Empty(t, matched)
+}
+
+// closeTrackingReader 记录是否被关闭的上游响应体
+type closeTrackingReader struct {
+	io.Reader
+	closed bool
+}
+
+func (r *closeTrackingReader) Close() error {
+	r.closed = true
+	return nil
+}
+
+func TestStreamProcessor_StopSequenceCutsStream(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	sender := &recordingSender{}
+	req := types.AnthropicRequest{Model: "claude-sonnet-4-20250514", StopSequences: []string{"</answer>"}}
+	ctx := NewStreamProcessorContext(c, req, nil, sender, "msg_test", 10)
+	esp := NewEventStreamProcessor(ctx)
+
+	for _, text := range []string{"<answer>42</ans", "wer> trailing"} {
+		require.NoError(t, esp.processEvent(textDeltaEvent(text)))
+	}
+	assert.True(t, ctx.stopped)
+	// 停止后剩余的上游事件被忽略
+	require.NoError(t, esp.processEvent(textDeltaEvent("more")))
+
+	reader := &closeTrackingReader{Reader: strings.NewReader("")}
+	require.NoError(t, esp.ProcessEventStream(reader))
+	assert.True(t, reader.closed)
+	require.NoError(t, ctx.sendFinalEvents())
+
+	var text strings.Builder
+	for _, e := range sender.events {
+		if delta, ok := e["delta"].(map[string]any); ok && delta["type"] == "text_delta" {
+			text.WriteString(delta["text"].(string))
+		}
+	}
+	assert.Equal(t, "<answer>42", text.String())
+
+	delta := sender.events[len(sender.events)-2]["delta"].(map[string]any)
+	assert.Equal(t, "stop_sequence", delta["stop_reason"])
+	assert.Equal(t, "</answer>", delta["stop_sequence"])
+}
@@ -46,6 +46,22 @@ type StreamProcessorContext struct {
 	// 问题：每个 input_json_delta 单独计算 len(partialJSON)/4 会导致小于4字节的分段被舍弃
 	// 解决：累加每个块的JSON字节数，在 content_block_stop 时一次性计算 token
 	jsonBytesByBlockIndex map[int]int // 每个工具块累积的JSON字节数
+
+	// 扩展思考：拆分上游文本中的思考标签，并重新分配下发的内容块索引（思考块位于最前面）
+	thinking *thinkingStream
+
+	// 代理执行的网络搜索块占用最前面的索引，上游内容块的下发索引依次顺延
+	indexOffset int
+
+	// 本地 max_tokens 限制（<=0 表示不限制）
+	maxTokens int
+	// 等待确认预算的工具调用块（按下发索引），结束时整块下发或丢弃
+	pendingToolUses map[int]*pendingToolUse
+
+	// 停止序列：文本增量经匹配后下发，匹配后截断输出并提前结束流
+	stopMatcher      *stopSequenceMatcher
+	pendingTextIndex int  // 匹配器暂存文本所属的内容块索引
+	stopped          bool // 已在本地结束输出，不再处理剩余的上游事件
 }
 
 // NewStreamProcessorContext 创建流处理上下文
@@ -71,6 +87,9 @@ func NewStreamProcessorContext(
 		toolUseIdByBlockIndex: make(map[int]string),
 		completedToolUseIds:   make(map[string]bool),
 		jsonBytesByBlockIndex: make(map[int]int), // *** 初始化JSON字节累加器 ***
+		thinking:              newThinkingStream(req.Thinking),
+		stopMatcher:           newStopSequenceMatcher(req.StopSequences),
+		maxTokens:             req.MaxTokens,
 	}
 }
 
@@ -138,7 +157,7 @@ func (ctx *StreamProcessorContext) sendInitialEvents(eventCreator func(string, i
 		}
 	}
 
-	return nil
+	return ctx.sendWebSearchBlocks()
 }
 
 // processToolUseStart 处理工具使用开始事件
@@ -212,6 +231,9 @@ func (ctx *StreamProcessorContext) processToolUseStop(dataMap map[string]any) {
 
 // sendFinalEvents 发送结束事件
 func (ctx *StreamProcessorContext) sendFinalEvents() error {
+	// 输出思考拆分器和停止序列匹配器中暂存的内容
+	ctx.flushPendingText()
+
 	// 关闭所有未关闭的content_block
 	activeBlocks := ctx.sseStateManager.GetActiveBlocks()
 	for index, block := range activeBlocks {
@@ -243,7 +265,8 @@ func (ctx *StreamProcessorContext) sendFinalEvents() error {
 	// *** 关键修复：使用累计的实际发送 token 数 ***
 	// 设计原则：token 计费应该基于实际发送给客户端的 SSE 事件内容
 	// totalOutputTokens 在每次发送事件时累计，确保与实际输出内容一致
-	outputTokens := ctx.totalOutputTokens
+	// 因 max_tokens 截断而未结束的工具块，其已下发的参数也计入
+	outputTokens := ctx.outputTokensSoFar()
 
 	// *** 完善的最小 token 保护机制 ***
 	// 问题：某些边缘情况（如只有空格、特殊字符等）可能导致 totalOutputTokens 为 0
@@ -272,7 +295,15 @@ func (ctx *StreamProcessorContext) sendFinalEvents() error {
 		logger.Int("output_tokens", outputTokens))
 
 	// 创建并发送结束事件
-	finalEvents := createAnthropicFinalEvents(outputTokens, ctx.inputTokens, stopReason)
+	recordEstimatedTokens(ctx.c, ctx.req.Model, ctx.inputTokens, outputTokens)
+	recordStopReason(ctx.c, stopReason)
+
+	finalEvents := createAnthropicFinalEvents(outputTokens, ctx.inputTokens, stopReason, ctx.stopReasonManager.StopSequence())
+	if searches := webSearchRequestCount(ctx.c); searches > 0 {
+		if usage, ok := finalEvents[0]["usage"].(map[string]any); ok {
+			usage["server_tool_use"] = map[string]any{"web_search_requests": searches}
+		}
+	}
 	for _, event := range finalEvents {
 		if err := ctx.sseStateManager.SendEvent(ctx.c, ctx.sender, event); err != nil {
 			logger.Error("结束事件发送违规", logger.Err(err))
@@ -345,7 +376,23 @@ func (esp *EventStreamProcessor) ProcessEventStream(reader io.Reader) error {
 				if err := esp.processEvent(event); err != nil {
 					return err
 				}
+				if esp.ctx.stopped {
+					break
+				}
+			}
+		}
+
+		// 已在本地结束输出（如匹配到停止序列）：关闭上游响应，不再读取剩余内容
+		if esp.ctx.stopped {
+			logger.Debug("本地结束输出，提前关闭上游响应",
+				addReqFields(esp.ctx.c,
+					logger.String("stop_reason", esp.ctx.stopReasonManager.DetermineStopReason()),
+					logger.Int("total_read_bytes", esp.ctx.totalReadBytes),
+				)...)
+			if closer, ok := reader.(io.Closer); ok {
+				_ = closer.Close()
 			}
+			return nil
 		}
 
 		if err != nil {
@@ -366,7 +413,8 @@ func (esp *EventStreamProcessor) ProcessEventStream(reader io.Reader) error {
 		}
 	}
 
-	// 直传模式：无需冲刷剩余文本
+	// 上游未结束的工具调用块按已收到的参数下发或丢弃
+	esp.flushPendingToolUses()
 	return nil
 }
 
@@ -380,6 +428,60 @@ func (esp *EventStreamProcessor) processEvent(event parser.SSEEvent) error {
 
 	eventType, _ := dataMap["type"].(string)
 
+	// 已在本地结束输出（停止序列、max_tokens）后忽略剩余事件
+	if esp.ctx.stopped {
+		return nil
+	}
+
+	// 开启扩展思考时，文本增量经拆分后由 processThinkingEvent 发送
+	if esp.ctx.thinking != nil && esp.ctx.processThinkingEvent(eventType, dataMap) {
+		esp.ctx.c.Writer.Flush()
+		return nil
+	}
+
+	// 网络搜索块之后的上游内容块索引顺延（开启扩展思考时已由 processThinkingEvent 分配）
+	if esp.ctx.thinking == nil && esp.ctx.indexOffset > 0 && strings.HasPrefix(eventType, "content_block_") {
+		if index := extractIndex(dataMap); index >= 0 {
+			dataMap["index"] = index + esp.ctx.indexOffset
+		}
+	}
+
+	switch eventType {
+	case "content_block_delta":
+		if delta, ok := dataMap["delta"].(map[string]any); ok {
+			switch delta["type"] {
+			case "text_delta":
+				// 文本增量统一由 sendTextDelta 下发（停止序列匹配、max_tokens 限制、token 统计）
+				text, _ := delta["text"].(string)
+				esp.ctx.sendTextDelta(extractIndex(dataMap), text)
+				esp.ctx.c.Writer.Flush()
+				return nil
+			}
+		}
+	case "content_block_start", "content_block_stop":
+		// 内容块边界前输出暂存的文本，保证文本增量位于所属块结束之前
+		esp.ctx.flushPendingText()
+		if esp.ctx.stopped {
+			return nil
+		}
+		// 预算不足以开始新的工具调用时停止输出
+		if cb, ok := dataMap["content_block"].(map[string]any); ok && cb["type"] == "tool_use" {
+			if !esp.ctx.fitToolUseStart(getStringField(cb, "name")) {
+				return nil
+			}
+		}
+	}
+
+	// 设置了 max_tokens 时工具调用块暂存到结束后整块下发或丢弃
+	if esp.bufferToolUseEvent(eventType, dataMap) {
+		return nil
+	}
+	esp.forwardEvent(eventType, dataMap)
+	return nil
+}
+
+// forwardEvent 转发上游事件并累计输出 token
+func (esp *EventStreamProcessor) forwardEvent(eventType string, dataMap map[string]any) {
 	// 处理不同类型的事件
 	switch eventType {
 	case "content_block_start":
@@ -397,7 +499,7 @@ func (esp *EventStreamProcessor) processEvent(event parser.SSEEvent) error {
 	case "exception":
 		// 处理上游异常事件，检查是否需要映射为max_tokens
 		if esp.handleExceptionEvent(dataMap) {
-			return nil // 已转换并发送，不转发原始exception事件
+			return // 已转换并发送，不转发原始exception事件
 		}
 	}
 
@@ -420,12 +522,6 @@ func (esp *EventStreamProcessor) processEvent(event parser.SSEEvent) error {
 			deltaType, _ := delta["type"].(string)
 			
 			switch deltaType {
-			case "text_delta":
-				// 文本内容增量
-				if text, ok := delta["text"].(string); ok {
-					esp.ctx.totalOutputTokens += esp.ctx.tokenEstimator.EstimateTextTokens(text)
-				}
-			
 			case "input_json_delta":
 				// *** 修复：累加JSON字节数，延迟到content_block_stop时统一计算 ***
 				// 问题：分段整除导致精度损失（例如 3字节/4=0, 2字节/4=0）
@@ -449,11 +545,7 @@ func (esp *EventStreamProcessor) processEvent(event parser.SSEEvent) error {
 				// - "id": "toolu_xxx" ≈ 8 tokens  
 				// - "name" 关键字 ≈ 1 token
 				// - 工具名称本身的 token（使用 estimateToolName 计算）
-				esp.ctx.totalOutputTokens += 12 // 结构字段固定开销
-				
-				if toolName, ok := contentBlock["name"].(string); ok {
-					esp.ctx.totalOutputTokens += esp.ctx.tokenEstimator.EstimateTextTokens(toolName)
-				}
+				esp.ctx.totalOutputTokens += toolUseStartTokens(esp.ctx.tokenEstimator, getStringField(contentBlock, "name"))
 			}
 		}
 	
@@ -462,7 +554,6 @@ func (esp *EventStreamProcessor) processEvent(event parser.SSEEvent) error {
 	}
 
 	esp.ctx.c.Writer.Flush()
-	return nil
 }
 
 // processContentBlockDelta 处理content_block_delta事件
@@ -485,6 +576,7 @@ func (esp *EventStreamProcessor) handleExceptionEvent(dataMap map[string]any) bo
 				logger.String("claude_stop_reason", "max_tokens"))...)
 
 		// 关闭所有活跃的content_block
+		esp.ctx.flushPendingText()
 		activeBlocks := esp.ctx.sseStateManager.GetActiveBlocks()
 		for index, block := range activeBlocks {
 			if block.Started && !block.Stopped {
@@ -505,7 +597,7 @@ func (esp *EventStreamProcessor) handleExceptionEvent(dataMap map[string]any) bo
 			},
 			"usage": map[string]any{
 				"input_tokens":  esp.ctx.inputTokens,
-				"output_tokens": esp.ctx.totalOutputTokens,
+				"output_tokens": esp.ctx.outputTokensSoFar(),
 			},
 		}
 
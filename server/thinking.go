@@ -0,0 +1,305 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"kiro2api/logger"
+	"kiro2api/types"
+)
+
+// 扩展思考响应处理
+// 上游不返回独立的思考事件，开启 thinking 后模型会在回复开头输出 <thinking>...</thinking>，
+// 这里将标签内的内容拆分为 thinking 内容块，标签之后的内容作为普通文本
+
+const (
+	thinkingOpenTag  = "<thinking>"
+	thinkingCloseTag = "</thinking>"
+)
+
+// thinkingSplitter 状态
+const (
+	thinkingStateDetect = iota // 回复开头，等待判断是否以 <thinking> 开始
+	thinkingStateInside        // 处于思考标签内
+	thinkingStateAfter         // 思考标签刚结束，跳过紧随其后的换行
+	thinkingStateText          // 普通文本，直接透传
+)
+
+// thinkingSegment 拆分后的一段内容
+type thinkingSegment struct {
+	Thinking bool // true 为思考内容，false 为普通文本
+	Text     string
+}
+
+// thinkingSplitter 按流式文本增量拆分思考内容和普通文本
+// 标签可能被拆分在多个增量中，无法确定的尾部内容会暂存到下一次 Feed 或 Flush
+type thinkingSplitter struct {
+	state       int
+	buf         string
+	trimLeading bool // 思考内容开头的换行尚未跳过
+}
+
+// newThinkingSplitter 创建思考内容拆分器
+func newThinkingSplitter() *thinkingSplitter {
+	return &thinkingSplitter{}
+}
+
+// Feed 输入一段文本增量，返回可以确定的内容片段
+func (s *thinkingSplitter) Feed(text string) []thinkingSegment {
+	var segments []thinkingSegment
+	s.buf += text
+
+	for s.buf != "" {
+		switch s.state {
+		case thinkingStateDetect:
+			trimmed := strings.TrimLeft(s.buf, " \t\r\n")
+			if len(trimmed) < len(thinkingOpenTag) && strings.HasPrefix(thinkingOpenTag, trimmed) {
+				return segments // 可能是被拆分的开始标签，等待更多内容
+			}
+			if strings.HasPrefix(trimmed, thinkingOpenTag) {
+				s.state = thinkingStateInside
+				s.trimLeading = true
+				s.buf = trimmed[len(thinkingOpenTag):]
+				continue
+			}
+			s.state = thinkingStateText
+
+		case thinkingStateInside:
+			if idx := strings.Index(s.buf, thinkingCloseTag); idx >= 0 {
+				segments = s.appendThinking(segments, strings.TrimRight(s.buf[:idx], " \t\r\n"))
+				s.buf = s.buf[idx+len(thinkingCloseTag):]
+				s.state = thinkingStateAfter
+				continue
+			}
+			// 保留可能是结束标签开头的尾部
+			keep := partialSuffixLen(s.buf, thinkingCloseTag)
+			segments = s.appendThinking(segments, s.buf[:len(s.buf)-keep])
+			s.buf = s.buf[len(s.buf)-keep:]
+			return segments
+
+		case thinkingStateAfter:
+			s.buf = strings.TrimLeft(s.buf, "\r\n")
+			if s.buf == "" {
+				return segments
+			}
+			s.state = thinkingStateText
+
+		case thinkingStateText:
+			segments = append(segments, thinkingSegment{Text: s.buf})
+			s.buf = ""
+		}
+	}
+	return segments
+}
+
+// Flush 流结束时输出暂存的内容，未闭合的思考标签内容仍作为思考内容
+func (s *thinkingSplitter) Flush() []thinkingSegment {
+	var segments []thinkingSegment
+	switch s.state {
+	case thinkingStateDetect:
+		if s.buf != "" {
+			segments = append(segments, thinkingSegment{Text: s.buf})
+		}
+	case thinkingStateInside:
+		segments = s.appendThinking(segments, s.buf)
+	}
+	s.state = thinkingStateText
+	s.buf = ""
+	return segments
+}
+
+// appendThinking 追加思考内容，跳过开头的换行和空内容
+func (s *thinkingSplitter) appendThinking(segments []thinkingSegment, text string) []thinkingSegment {
+	if s.trimLeading {
+		text = strings.TrimLeft(text, "\r\n")
+		if text == "" {
+			return segments
+		}
+		s.trimLeading = false
+	}
+	if text == "" {
+		return segments
+	}
+	return append(segments, thinkingSegment{Thinking: true, Text: text})
+}
+
+// partialSuffixLen 返回 s 的尾部与 tag 开头重合的最大长度
+func partialSuffixLen(s, tag string) int {
+	for n := min(len(s), len(tag)-1); n > 0; n-- {
+		if strings.HasSuffix(s, tag[:n]) {
+			return n
+		}
+	}
+	return 0
+}
+
+// splitThinkingText 拆分完整回复中的思考内容和普通文本（非流式响应使用）
+func splitThinkingText(text string) (thinking, rest string) {
+	splitter := newThinkingSplitter()
+	var thinkingBuilder, textBuilder strings.Builder
+	for _, seg := range append(splitter.Feed(text), splitter.Flush()...) {
+		if seg.Thinking {
+			thinkingBuilder.WriteString(seg.Text)
+		} else {
+			textBuilder.WriteString(seg.Text)
+		}
+	}
+	return thinkingBuilder.String(), textBuilder.String()
+}
+
+// minThinkingBudgetTokens 思考预算的最小值
+const minThinkingBudgetTokens = 1024
+
+// validateThinking 校验 thinking 参数
+func validateThinking(req types.AnthropicRequest) error {
+	if req.Thinking == nil {
+		return nil
+	}
+	switch req.Thinking.Type {
+	case "disabled":
+		return nil
+	case "enabled":
+	default:
+		return fmt.Errorf("thinking.type 无效: %q（可选 enabled、disabled）", req.Thinking.Type)
+	}
+	if req.Thinking.BudgetTokens < minThinkingBudgetTokens {
+		return fmt.Errorf("thinking.budget_tokens 不能小于 %d", minThinkingBudgetTokens)
+	}
+	if req.MaxTokens > 0 && req.Thinking.BudgetTokens >= req.MaxTokens {
+		return errors.New("thinking.budget_tokens 必须小于 max_tokens")
+	}
+	return nil
+}
+
+// newThinkingSignature 生成 thinking 块的签名
+// 上游不提供签名，客户端只需原样带回，后续请求中的思考块在转换时会被移除
+func newThinkingSignature() string {
+	buf := make([]byte, 48)
+	_, _ = rand.Read(buf)
+	return base64.StdEncoding.EncodeToString(buf)
+}
+
+// thinkingStream 流式响应中的扩展思考状态
+// 上游文本固定使用索引0、工具从索引1开始，思考块需要占用最前面的索引，因此下发时按出现顺序重新分配索引
+type thinkingStream struct {
+	splitter      *thinkingSplitter
+	blockIndexMap map[int]int // 上游块索引 -> 下发块索引
+	nextIndex     int
+	thinkingIndex int // 思考块的下发索引，-1 表示尚未开始
+}
+
+// newThinkingStream 未开启扩展思考时返回 nil
+func newThinkingStream(thinking *types.Thinking) *thinkingStream {
+	if !thinking.IsEnabled() {
+		return nil
+	}
+	return &thinkingStream{
+		splitter:      newThinkingSplitter(),
+		blockIndexMap: make(map[int]int),
+		thinkingIndex: -1,
+	}
+}
+
+// downstreamIndex 返回上游块索引对应的下发索引，首次出现时分配
+func (ts *thinkingStream) downstreamIndex(upstream int) int {
+	if index, ok := ts.blockIndexMap[upstream]; ok {
+		return index
+	}
+	index := ts.nextIndex
+	ts.nextIndex++
+	ts.blockIndexMap[upstream] = index
+	return index
+}
+
+// processThinkingEvent 处理开启扩展思考时的上游事件
+// 文本块事件由本方法拆分发送并返回 true；其他事件改写为下发索引后返回 false，继续按原流程转发
+func (ctx *StreamProcessorContext) processThinkingEvent(eventType string, dataMap map[string]any) bool {
+	upstream := extractIndex(dataMap)
+	if upstream < 0 {
+		return false
+	}
+
+	switch eventType {
+	case "content_block_start":
+		// 文本块按需由状态管理器自动启动，上游的文本块开始事件不再转发
+		if cb, ok := dataMap["content_block"].(map[string]any); ok && cb["type"] == "text" {
+			return true
+		}
+
+	case "content_block_delta":
+		if delta, ok := dataMap["delta"].(map[string]any); ok && delta["type"] == "text_delta" {
+			text, _ := delta["text"].(string)
+			ctx.emitThinkingSegments(ctx.thinking.splitter.Feed(text))
+			return true
+		}
+
+	case "content_block_stop":
+		if upstream == 0 {
+			// 文本块结束：先输出暂存内容，再关闭实际下发的块
+			ctx.flushPendingText()
+			index, ok := ctx.thinking.blockIndexMap[0]
+			if !ok {
+				index = ctx.thinking.thinkingIndex // 只有思考内容、没有文本
+			}
+			if block, exists := ctx.sseStateManager.GetActiveBlocks()[index]; exists && !block.Stopped {
+				stopEvent := map[string]any{"type": "content_block_stop", "index": index}
+				if err := ctx.sseStateManager.SendEvent(ctx.c, ctx.sender, stopEvent); err != nil {
+					logger.Error("SSE事件发送违规", logger.Err(err))
+				}
+			}
+			return true
+		}
+	}
+
+	dataMap["index"] = ctx.thinking.downstreamIndex(upstream)
+	return false
+}
+
+// flushThinking 输出思考拆分器中暂存的内容
+func (ctx *StreamProcessorContext) flushThinking() {
+	if ctx.thinking == nil {
+		return
+	}
+	ctx.emitThinkingSegments(ctx.thinking.splitter.Flush())
+}
+
+// emitThinkingSegments 发送拆分后的思考内容和文本内容
+// 思考块的开始、签名和结束事件由 SSEStateManager 自动补全
+func (ctx *StreamProcessorContext) emitThinkingSegments(segments []thinkingSegment) {
+	for _, seg := range segments {
+		if ctx.stopped {
+			return
+		}
+		if !seg.Thinking {
+			ctx.sendTextDelta(ctx.thinking.downstreamIndex(0), seg.Text)
+			continue
+		}
+
+		text := ctx.fitOutputText(seg.Text)
+		if text == "" {
+			continue
+		}
+		if ctx.thinking.thinkingIndex < 0 {
+			ctx.thinking.thinkingIndex = ctx.thinking.nextIndex
+			ctx.thinking.nextIndex++
+		}
+		ctx.emitThinkingDelta(ctx.thinking.thinkingIndex, text)
+	}
+}
+
+// emitThinkingDelta 通过状态管理器发送思考增量并累计输出 token（调用方已按 max_tokens 截断）
+func (ctx *StreamProcessorContext) emitThinkingDelta(index int, text string) {
+	event := map[string]any{
+		"type":  "content_block_delta",
+		"index": index,
+		"delta": map[string]any{"type": "thinking_delta", "thinking": text},
+	}
+	if err := ctx.sseStateManager.SendEvent(ctx.c, ctx.sender, event); err != nil {
+		logger.Error("SSE事件发送违规", logger.Err(err))
+		return
+	}
+	ctx.totalOutputTokens += ctx.tokenEstimator.EstimateTextTokens(text)
+}
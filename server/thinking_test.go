@@ -0,0 +1,141 @@
+package server
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"testing"
+
+	"kiro2api/parser"
+	"kiro2api/types"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recordingSender 记录发送的事件
+type recordingSender struct {
+	events []map[string]any
+}
+
+func (s *recordingSender) SendEvent(_ *gin.Context, data any) error {
+	s.events = append(s.events, data.(map[string]any))
+	return nil
+}
+
+func (s *recordingSender) SendError(_ *gin.Context, _ string, _ error) error {
+	return nil
+}
+
+// textDeltaEvent 构造上游文本增量事件
+func textDeltaEvent(text string) parser.SSEEvent {
+	return parser.SSEEvent{Data: map[string]any{
+		"type": "content_block_delta", "index": 0,
+		"delta": map[string]any{"type": "text_delta", "text": text},
+	}}
+}
+
+func TestThinkingSplitter_TagsSplitAcrossChunks(t *testing.T) {
+	splitter := newThinkingSplitter()
+	var segments []thinkingSegment
+	for _, chunk := range []string{"\n<thi", "nking>\nLet me", " think.</thin", "king>\n\nThe answer", " is 4."} {
+		segments = append(segments, splitter.Feed(chunk)...)
+	}
+	segments = append(segments, splitter.Flush()...)
+
+	var thinking, text string
+	for _, seg := range segments {
+		if seg.Thinking {
+			thinking += seg.Text
+		} else {
+			text += seg.Text
+		}
+	}
+	assert.Equal(t, "Let me think.", thinking)
+	assert.Equal(t, "The answer is 4.", text)
+}
+
+func TestSplitThinkingText(t *testing.T) {
+	thinking, rest := splitThinkingText("<thinking>reasoning</thinking>\nanswer")
+	assert.Equal(t, "reasoning", thinking)
+	assert.Equal(t, "answer", rest)
+
+	// 只识别回复开头的思考标签
+	thinking, rest = splitThinkingText("use <thinking> tags")
+	assert.Empty(t, thinking)
+	assert.Equal(t, "use <thinking> tags", rest)
+
+	thinking, rest = splitThinkingText("<thin")
+	assert.Empty(t, thinking)
+	assert.Equal(t, "<thin", rest)
+}
+
+func TestStreamProcessor_EmitsThinkingBlocks(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	sender := &recordingSender{}
+	req := types.AnthropicRequest{
+		Model:    "claude-sonnet-4-20250514",
+		Thinking: &types.Thinking{Type: "enabled", BudgetTokens: 2048},
+	}
+	ctx := NewStreamProcessorContext(c, req, nil, sender, "msg_test", 10)
+	esp := NewEventStreamProcessor(ctx)
+
+	upstream := []parser.SSEEvent{
+		{Data: map[string]any{"type": "message_start", "message": map[string]any{}}},
+		textDeltaEvent("<thinking>plan"),
+		textDeltaEvent("</thinking>\nCalling tool"),
+		{Data: map[string]any{"type": "content_block_start", "index": 1,
+			"content_block": map[string]any{"type": "tool_use", "id": "tooluse_1", "name": "read", "input": map[string]any{}}}},
+		{Data: map[string]any{"type": "content_block_delta", "index": 1,
+			"delta": map[string]any{"type": "input_json_delta", "partial_json": `{"path":"a"}`}}},
+		{Data: map[string]any{"type": "content_block_stop", "index": 1}},
+	}
+	for _, event := range upstream {
+		require.NoError(t, esp.processEvent(event))
+	}
+	require.NoError(t, ctx.sendFinalEvents())
+
+	var sequence []string
+	for _, e := range sender.events {
+		entry := e["type"].(string)
+		if idx, ok := e["index"].(int); ok {
+			entry += fmt.Sprintf("#%d", idx)
+		}
+		if cb, ok := e["content_block"].(map[string]any); ok {
+			entry += ":" + cb["type"].(string)
+		}
+		if delta, ok := e["delta"].(map[string]any); ok && delta["type"] != nil {
+			entry += ":" + delta["type"].(string)
+		}
+		sequence = append(sequence, entry)
+	}
+	assert.Equal(t, []string{
+		"message_start",
+		"content_block_start#0:thinking",
+		"content_block_delta#0:thinking_delta",
+		"content_block_delta#0:signature_delta",
+		"content_block_stop#0",
+		"content_block_start#1:text",
+		"content_block_delta#1:text_delta",
+		"content_block_stop#1",
+		"content_block_start#2:tool_use",
+		"content_block_delta#2:input_json_delta",
+		"content_block_stop#2",
+		"message_delta",
+		"message_stop",
+	}, sequence)
+
+	delta := sender.events[len(sender.events)-2]["delta"].(map[string]any)
+	assert.Equal(t, "tool_use", delta["stop_reason"])
+}
+
+func TestValidateThinking(t *testing.T) {
+	assert.NoError(t, validateThinking(types.AnthropicRequest{}))
+	assert.NoError(t, validateThinking(types.AnthropicRequest{Thinking: &types.Thinking{Type: "disabled"}}))
+	assert.NoError(t, validateThinking(types.AnthropicRequest{MaxTokens: 4096, Thinking: &types.Thinking{Type: "enabled", BudgetTokens: 2048}}))
+
+	assert.ErrorContains(t, validateThinking(types.AnthropicRequest{Thinking: &types.Thinking{Type: "auto"}}), "thinking.type")
+	assert.ErrorContains(t, validateThinking(types.AnthropicRequest{MaxTokens: 4096, Thinking: &types.Thinking{Type: "enabled", BudgetTokens: 100}}), "不能小于 1024")
+	assert.ErrorContains(t, validateThinking(types.AnthropicRequest{MaxTokens: 2048, Thinking: &types.Thinking{Type: "enabled", BudgetTokens: 2048}}), "必须小于 max_tokens")
+}
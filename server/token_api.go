@@ -29,7 +29,7 @@ type TokenAPIResponse struct {
 }
 
 // registerTokenManagementRoutes 注册 Token 管理路由
-func registerTokenManagementRoutes(r *gin.Engine, authService *auth.AuthService, requireAuth bool) {
+func registerTokenManagementRoutes(r *gin.Engine, authService *auth.AuthService, auditLog *AuditLog, requireAuth bool) {
 	// 创建路由组
 	tokenGroup := r.Group("/api/tokens")
 	if requireAuth {
@@ -38,27 +38,27 @@ func registerTokenManagementRoutes(r *gin.Engine, authService *auth.AuthService,
 
 	// 添加 Token
 	tokenGroup.POST("", func(c *gin.Context) {
-		handleAddToken(c, authService)
+		handleAddToken(c, authService, auditLog)
 	})
 
 	// 删除 Token
 	tokenGroup.DELETE("/:index", func(c *gin.Context) {
-		handleDeleteToken(c, authService)
+		handleDeleteToken(c, authService, auditLog)
 	})
 
 	// 刷新单个 Token
 	tokenGroup.POST("/:index/refresh", func(c *gin.Context) {
-		handleRefreshToken(c, authService)
+		handleRefreshToken(c, authService, auditLog)
 	})
 
 	// 刷新所有 Token
 	tokenGroup.POST("/refresh-all", func(c *gin.Context) {
-		handleRefreshAllTokens(c, authService)
+		handleRefreshAllTokens(c, authService, auditLog)
 	})
 }
 
 // handleAddToken 处理添加 Token 请求
-func handleAddToken(c *gin.Context, authService *auth.AuthService) {
+func handleAddToken(c *gin.Context, authService *auth.AuthService, auditLog *AuditLog) {
 	var req AddTokenRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		logger.Warn("解析添加Token请求失败", logger.Err(err))
@@ -113,8 +113,11 @@ func handleAddToken(c *gin.Context, authService *auth.AuthService) {
 	}
 
 	// 添加配置
+	newIndex := authService.GetConfigCount()
+	entity := tokenAuditEntity(config.RefreshToken)
 	if err := authService.AddConfig(config); err != nil {
 		logger.Error("添加Token配置失败", logger.Err(err))
+		auditLog.Record(c, AuditActionTokenAdd, entity, nil, auditAuthConfigState(newIndex, config), err)
 		c.JSON(http.StatusInternalServerError, TokenAPIResponse{
 			Success: false,
 			Message: "添加配置失败: " + err.Error(),
@@ -122,6 +125,8 @@ func handleAddToken(c *gin.Context, authService *auth.AuthService) {
 		return
 	}
 
+	auditLog.Record(c, AuditActionTokenAdd, entity, nil, auditAuthConfigState(newIndex, config), nil)
+
 	logger.Info("成功添加Token配置",
 		logger.String("auth_type", req.Auth),
 		logger.Int("total_count", authService.GetConfigCount()))
@@ -134,7 +139,7 @@ func handleAddToken(c *gin.Context, authService *auth.AuthService) {
 }
 
 // handleDeleteToken 处理删除 Token 请求
-func handleDeleteToken(c *gin.Context, authService *auth.AuthService) {
+func handleDeleteToken(c *gin.Context, authService *auth.AuthService, auditLog *AuditLog) {
 	indexStr := c.Param("index")
 	index, err := strconv.Atoi(indexStr)
 	if err != nil {
@@ -145,11 +150,20 @@ func handleDeleteToken(c *gin.Context, authService *auth.AuthService) {
 		return
 	}
 
+	// 记录删除前的状态用于审计
+	configs := authService.GetConfigs()
+	entity := tokenAuditEntityAt(configs, index)
+	var before map[string]any
+	if index >= 0 && index < len(configs) {
+		before = auditAuthConfigState(index, configs[index])
+	}
+
 	// 删除配置
 	if err := authService.RemoveConfig(index); err != nil {
 		logger.Warn("删除Token配置失败",
 			logger.Int("index", index),
 			logger.Err(err))
+		auditLog.Record(c, AuditActionTokenDelete, entity, before, nil, err)
 		c.JSON(http.StatusBadRequest, TokenAPIResponse{
 			Success: false,
 			Message: err.Error(),
@@ -157,6 +171,8 @@ func handleDeleteToken(c *gin.Context, authService *auth.AuthService) {
 		return
 	}
 
+	auditLog.Record(c, AuditActionTokenDelete, entity, before, nil, nil)
+
 	logger.Info("成功删除Token配置",
 		logger.Int("removed_index", index),
 		logger.Int("remaining_count", authService.GetConfigCount()))
@@ -169,7 +185,7 @@ func handleDeleteToken(c *gin.Context, authService *auth.AuthService) {
 }
 
 // handleRefreshToken 处理刷新单个 Token 请求
-func handleRefreshToken(c *gin.Context, authService *auth.AuthService) {
+func handleRefreshToken(c *gin.Context, authService *auth.AuthService, auditLog *AuditLog) {
 	indexStr := c.Param("index")
 	index, err := strconv.Atoi(indexStr)
 	if err != nil {
@@ -181,10 +197,12 @@ func handleRefreshToken(c *gin.Context, authService *auth.AuthService) {
 	}
 
 	// 刷新 Token
+	entity := tokenAuditEntityAt(authService.GetConfigs(), index)
 	if err := authService.RefreshToken(index); err != nil {
 		logger.Warn("刷新Token失败",
 			logger.Int("index", index),
 			logger.Err(err))
+		auditLog.Record(c, AuditActionTokenRefresh, entity, nil, nil, err)
 		c.JSON(http.StatusBadRequest, TokenAPIResponse{
 			Success: false,
 			Message: err.Error(),
@@ -192,6 +210,8 @@ func handleRefreshToken(c *gin.Context, authService *auth.AuthService) {
 		return
 	}
 
+	auditLog.Record(c, AuditActionTokenRefresh, entity, nil, nil, nil)
+
 	logger.Info("已触发Token刷新",
 		logger.Int("index", index))
 
@@ -202,7 +222,7 @@ func handleRefreshToken(c *gin.Context, authService *auth.AuthService) {
 }
 
 // handleRefreshAllTokens 处理刷新所有 Token 请求
-func handleRefreshAllTokens(c *gin.Context, authService *auth.AuthService) {
+func handleRefreshAllTokens(c *gin.Context, authService *auth.AuthService, auditLog *AuditLog) {
 	count := authService.GetConfigCount()
 	if count == 0 {
 		c.JSON(http.StatusBadRequest, TokenAPIResponse{
@@ -214,6 +234,7 @@ func handleRefreshAllTokens(c *gin.Context, authService *auth.AuthService) {
 
 	// 触发刷新所有 Token
 	authService.RefreshAllTokens()
+	auditLog.Record(c, AuditActionTokenRefreshAll, "token:*", nil, map[string]any{"count": count}, nil)
 
 	logger.Info("已触发刷新所有Token",
 		logger.Int("count", count))
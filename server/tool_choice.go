@@ -0,0 +1,174 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"kiro2api/converter"
+	"kiro2api/logger"
+	"kiro2api/parser"
+	"kiro2api/types"
+	"kiro2api/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxToolChoiceRetries tool_choice 要求调用工具但模型未调用时的最大重试次数
+const maxToolChoiceRetries = 2
+
+// toolChoiceKeepaliveInterval 流式请求缓冲上游响应期间向客户端发送心跳的间隔，防止代理断开空闲连接
+var toolChoiceKeepaliveInterval = 10 * time.Second
+
+// validateToolChoice 校验 tool_choice 参数（与Anthropic API的限制保持一致）
+func validateToolChoice(req types.AnthropicRequest) error {
+	tc := converter.ResolveToolChoice(req.ToolChoice)
+	if tc == nil {
+		return nil
+	}
+	switch tc.Type {
+	case "auto", "none":
+		return nil
+	case "any", "tool":
+	default:
+		return fmt.Errorf("tool_choice.type 无效: %q（可选 auto、any、tool、none）", tc.Type)
+	}
+
+	if len(req.Tools) == 0 {
+		return fmt.Errorf("tool_choice.type 为 %s 时必须提供 tools", tc.Type)
+	}
+	if req.Thinking.IsEnabled() {
+		return errors.New("开启扩展思考时 tool_choice 不能强制调用工具（仅支持 auto 或 none）")
+	}
+	if tc.Type == "tool" {
+		if tc.Name == "" {
+			return errors.New("tool_choice.type 为 tool 时必须指定 name")
+		}
+		for _, tool := range req.Tools {
+			if tool.Name == tc.Name {
+				return nil
+			}
+		}
+		return fmt.Errorf("tool_choice.name 指定的工具不存在: %s", tc.Name)
+	}
+	return nil
+}
+
+// executeWithRequiredTool 执行 tool_choice 强制调用工具的请求
+// 上游不保证调用工具：先完整读取响应检查是否调用了要求的工具，未调用时追加提醒后重试，
+// 超过重试次数后返回最后一次的响应。流式请求在这种模式下会等待上游响应完成后再开始下发，
+// 缓冲期间定期发送心跳保持连接
+func executeWithRequiredTool(c *gin.Context, anthropicReq types.AnthropicRequest, tc *types.ToolChoice, tokenInfo types.TokenInfo, isStream bool) (*http.Response, error) {
+	attemptReq := anthropicReq
+	for attempt := 0; ; attempt++ {
+		resp, err := sendCodeWhispererRequest(c, attemptReq, tokenInfo, isStream)
+		if err != nil {
+			return nil, err
+		}
+		stopKeepalive := func() {}
+		if isStream {
+			stopKeepalive = startStreamKeepalive(c, toolChoiceKeepaliveInterval)
+		}
+		body, err := utils.ReadHTTPResponse(resp.Body)
+		stopKeepalive()
+		_ = resp.Body.Close()
+		if err != nil {
+			handleResponseReadError(c, err)
+			return nil, err
+		}
+		resp.Body = io.NopCloser(bytes.NewReader(body))
+
+		text, called := inspectToolChoiceResponse(body, tc)
+		if called {
+			return resp, nil
+		}
+		if attempt >= maxToolChoiceRetries {
+			logger.Warn("模型未调用tool_choice要求的工具，已达到最大重试次数",
+				addReqFields(c,
+					logger.String("tool_choice", tc.Type),
+					logger.String("tool_name", tc.Name),
+					logger.Int("retries", attempt),
+				)...)
+			return resp, nil
+		}
+
+		logger.Info("模型未调用tool_choice要求的工具，追加提醒后重试",
+			addReqFields(c,
+				logger.String("tool_choice", tc.Type),
+				logger.String("tool_name", tc.Name),
+				logger.Int("attempt", attempt+1),
+			)...)
+		attemptReq = withToolChoiceReminder(anthropicReq, text, tc)
+	}
+}
+
+// startStreamKeepalive 定期向流式客户端写入 SSE 注释心跳，返回的函数停止心跳并等待写入结束
+// 使用注释而非 ping 事件，Anthropic 和 OpenAI 兼容客户端都会忽略；SSE 响应头已由流式处理入口写出
+func startStreamKeepalive(c *gin.Context, interval time.Duration) func() {
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-c.Request.Context().Done():
+				return
+			case <-ticker.C:
+				if _, err := c.Writer.WriteString(": ping\n\n"); err != nil {
+					return
+				}
+				c.Writer.Flush()
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
+
+// inspectToolChoiceResponse 解析上游响应，返回回复文本和是否调用了要求的工具
+// 响应无法解析时视为满足要求，交由后续流程按原有方式处理
+func inspectToolChoiceResponse(body []byte, tc *types.ToolChoice) (string, bool) {
+	result, err := parser.NewCompliantEventStreamParser().ParseResponse(body)
+	if err != nil || result == nil {
+		return "", true
+	}
+	for _, event := range result.Events {
+		dataMap, ok := event.Data.(map[string]any)
+		if !ok || dataMap["type"] != "content_block_start" {
+			continue
+		}
+		cb, ok := dataMap["content_block"].(map[string]any)
+		if !ok || cb["type"] != "tool_use" {
+			continue
+		}
+		if tc.Type != "tool" || cb["name"] == tc.Name {
+			return "", true
+		}
+	}
+	return result.GetCompletionText(), false
+}
+
+// withToolChoiceReminder 基于原始请求构造重试请求：附上模型上一次的文本回复和调用工具的提醒
+// 上一次没有文本回复时直接重发原始请求
+func withToolChoiceReminder(anthropicReq types.AnthropicRequest, previousText string, tc *types.ToolChoice) types.AnthropicRequest {
+	if previousText == "" {
+		return anthropicReq
+	}
+	messages := make([]types.AnthropicRequestMessage, 0, len(anthropicReq.Messages)+2)
+	messages = append(messages, anthropicReq.Messages...)
+	messages = append(messages,
+		types.AnthropicRequestMessage{Role: "assistant", Content: previousText},
+		types.AnthropicRequestMessage{Role: "user", Content: converter.BuildToolChoiceReminder(tc)},
+	)
+	anthropicReq.Messages = messages
+	return anthropicReq
+}
@@ -0,0 +1,152 @@
+package server
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"kiro2api/types"
+	"kiro2api/utils"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateToolChoice(t *testing.T) {
+	tools := []types.AnthropicTool{{Name: "read"}, {Name: "write"}}
+
+	assert.NoError(t, validateToolChoice(types.AnthropicRequest{}))
+	assert.NoError(t, validateToolChoice(types.AnthropicRequest{ToolChoice: map[string]any{"type": "none"}}))
+	assert.NoError(t, validateToolChoice(types.AnthropicRequest{Tools: tools, ToolChoice: map[string]any{"type": "any"}}))
+	assert.NoError(t, validateToolChoice(types.AnthropicRequest{Tools: tools, ToolChoice: &types.ToolChoice{Type: "tool", Name: "write"}}))
+
+	assert.ErrorContains(t, validateToolChoice(types.AnthropicRequest{Tools: tools, ToolChoice: map[string]any{"type": "required"}}), "tool_choice.type 无效")
+	assert.ErrorContains(t, validateToolChoice(types.AnthropicRequest{ToolChoice: map[string]any{"type": "any"}}), "必须提供 tools")
+	assert.ErrorContains(t, validateToolChoice(types.AnthropicRequest{Tools: tools, ToolChoice: map[string]any{"type": "tool"}}), "必须指定 name")
+	assert.ErrorContains(t, validateToolChoice(types.AnthropicRequest{Tools: tools, ToolChoice: map[string]any{"type": "tool", "name": "delete"}}), "工具不存在")
+	assert.ErrorContains(t, validateToolChoice(types.AnthropicRequest{
+		MaxTokens:  4096,
+		Tools:      tools,
+		ToolChoice: map[string]any{"type": "any"},
+		Thinking:   &types.Thinking{Type: "enabled", BudgetTokens: 2048},
+	}), "扩展思考")
+}
+
+func TestWithToolChoiceReminder(t *testing.T) {
+	original := types.AnthropicRequest{
+		Messages: []types.AnthropicRequestMessage{{Role: "user", Content: "Read a.txt"}},
+	}
+	tc := &types.ToolChoice{Type: "tool", Name: "read"}
+
+	retry := withToolChoiceReminder(original, "I can't do that.", tc)
+	require.Len(t, retry.Messages, 3)
+	assert.Equal(t, "assistant", retry.Messages[1].Role)
+	assert.Equal(t, "I can't do that.", retry.Messages[1].Content)
+	assert.Equal(t, "user", retry.Messages[2].Role)
+	assert.Contains(t, retry.Messages[2].Content, "`read`")
+	assert.Len(t, original.Messages, 1) // 不修改原始请求
+
+	assert.Len(t, withToolChoiceReminder(original, "", tc).Messages, 1)
+}
+
+// eventStreamFrame 构造一条 AWS EventStream 事件消息
+func eventStreamFrame(eventType, payload string) []byte {
+	var headers bytes.Buffer
+	for _, h := range [][2]string{{":message-type", "event"}, {":event-type", eventType}} {
+		headers.WriteByte(byte(len(h[0])))
+		headers.WriteString(h[0])
+		headers.WriteByte(7) // string
+		_ = binary.Write(&headers, binary.BigEndian, uint16(len(h[1])))
+		headers.WriteString(h[1])
+	}
+
+	total := 16 + headers.Len() + len(payload)
+	var msg bytes.Buffer
+	_ = binary.Write(&msg, binary.BigEndian, uint32(total))
+	_ = binary.Write(&msg, binary.BigEndian, uint32(headers.Len()))
+	_ = binary.Write(&msg, binary.BigEndian, crc32.ChecksumIEEE(msg.Bytes()))
+	msg.Write(headers.Bytes())
+	msg.WriteString(payload)
+	_ = binary.Write(&msg, binary.BigEndian, crc32.ChecksumIEEE(msg.Bytes()))
+	return msg.Bytes()
+}
+
+// slowReader 首次读取前等待一段时间，模拟上游逐步生成响应
+type slowReader struct {
+	io.Reader
+	delay  time.Duration
+	waited bool
+}
+
+func (r *slowReader) Read(p []byte) (int, error) {
+	if !r.waited {
+		r.waited = true
+		time.Sleep(r.delay)
+	}
+	return r.Reader.Read(p)
+}
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) { return f(req) }
+
+func TestExecuteWithRequiredTool_StreamRetrySendsKeepalive(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	textOnly := eventStreamFrame("assistantResponseEvent", `{"content":"I would rather not."}`)
+	toolCall := eventStreamFrame("toolUseEvent", `{"name":"read","toolUseId":"tooluse_1","input":"{\"path\":\"a.txt\"}","stop":true}`)
+
+	var attempts atomic.Int32
+	originalTransport := utils.SharedHTTPClient.Transport
+	utils.SharedHTTPClient.Transport = roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		body := textOnly
+		if attempts.Add(1) > 1 {
+			body = toolCall
+		}
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{"Content-Type": []string{"application/vnd.amazon.eventstream"}},
+			Body:       io.NopCloser(&slowReader{Reader: bytes.NewReader(body), delay: 60 * time.Millisecond}),
+			Request:    req,
+		}, nil
+	})
+	originalInterval := toolChoiceKeepaliveInterval
+	toolChoiceKeepaliveInterval = 10 * time.Millisecond
+	t.Cleanup(func() {
+		utils.SharedHTTPClient.Transport = originalTransport
+		toolChoiceKeepaliveInterval = originalInterval
+	})
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/messages", nil)
+	require.NoError(t, initializeSSEResponse(c))
+
+	req := types.AnthropicRequest{
+		Model:     "claude-sonnet-4-5",
+		MaxTokens: 1024,
+		Stream:    true,
+		Messages:  []types.AnthropicRequestMessage{{Role: "user", Content: "Read a.txt"}},
+		Tools:     []types.AnthropicTool{{Name: "read", InputSchema: map[string]any{"type": "object"}}},
+	}
+	tc := &types.ToolChoice{Type: "tool", Name: "read"}
+
+	resp, err := executeWithRequiredTool(c, req, tc, types.TokenInfo{AccessToken: "test-token"}, true)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	assert.Equal(t, int32(2), attempts.Load())
+	assert.Equal(t, "text/event-stream; charset=utf-8", w.Header().Get("Content-Type"))
+	assert.Contains(t, w.Body.String(), ": ping\n\n")
+
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, toolCall, body)
+}
@@ -0,0 +1,378 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"kiro2api/utils"
+)
+
+// TOTP 参数（RFC 6238 默认值，兼容主流认证器App）
+const (
+	totpDigits         = 6
+	totpPeriod         = 30 * time.Second
+	totpSkewSteps      = 1 // 允许前后各1个时间步的时钟偏差
+	totpSecretBytes    = 20
+	recoveryCodeCount  = 10
+	defaultTOTPFile    = "totp_config.json"
+	defaultTOTPIssuer  = "Kiro2API"
+	recoveryCodeLength = 10
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// TOTPUser 单个用户的两步验证配置
+// 恢复码只保存 SHA-256 哈希，明文仅在生成时返回一次
+type TOTPUser struct {
+	Secret        string    `json:"secret"`
+	Enabled       bool      `json:"enabled"`
+	RecoveryCodes []string  `json:"recoveryCodes,omitempty"`
+	LastUsedStep  int64     `json:"lastUsedStep,omitempty"` // 防止同一验证码重放
+	CreatedAt     time.Time `json:"createdAt"`
+	EnabledAt     time.Time `json:"enabledAt,omitempty"`
+}
+
+// TOTPEnrollment 开始绑定时返回给前端的信息
+type TOTPEnrollment struct {
+	Secret     string `json:"secret"`
+	OTPAuthURI string `json:"otpauth_uri"`
+	QRPayload  string `json:"qr_payload"` // 二维码内容（即 otpauth URI）
+	Issuer     string `json:"issuer"`
+	Account    string `json:"account"`
+}
+
+// TOTPStore 持久化的两步验证配置存储
+type TOTPStore struct {
+	mu     sync.Mutex
+	path   string
+	issuer string
+	users  map[string]*TOTPUser
+	now    func() time.Time
+}
+
+// NewTOTPStore 创建两步验证存储，并加载已有配置
+func NewTOTPStore(path, issuer string) (*TOTPStore, error) {
+	if path == "" {
+		path = defaultTOTPFile
+	}
+	if issuer == "" {
+		issuer = defaultTOTPIssuer
+	}
+
+	s := &TOTPStore{
+		path:   path,
+		issuer: issuer,
+		users:  make(map[string]*TOTPUser),
+		now:    time.Now,
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return s, nil
+		}
+		return nil, fmt.Errorf("读取两步验证配置失败: %w", err)
+	}
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &s.users); err != nil {
+			return nil, fmt.Errorf("解析两步验证配置失败: %w", err)
+		}
+	}
+	return s, nil
+}
+
+// IsEnabled 判断用户是否已启用两步验证
+func (s *TOTPStore) IsEnabled(user string) bool {
+	if s == nil {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, ok := s.users[user]
+	return ok && u.Enabled
+}
+
+// RecoveryCodesRemaining 返回用户剩余的恢复码数量
+func (s *TOTPStore) RecoveryCodesRemaining(user string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if u, ok := s.users[user]; ok {
+		return len(u.RecoveryCodes)
+	}
+	return 0
+}
+
+// BeginEnrollment 为用户生成新的密钥（确认前不生效）
+func (s *TOTPStore) BeginEnrollment(user string) (TOTPEnrollment, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if u, ok := s.users[user]; ok && u.Enabled {
+		return TOTPEnrollment{}, fmt.Errorf("两步验证已启用，请先停用后再重新绑定")
+	}
+
+	secret, err := generateTOTPSecret()
+	if err != nil {
+		return TOTPEnrollment{}, err
+	}
+
+	prev := s.users[user]
+	s.users[user] = &TOTPUser{
+		Secret:    secret,
+		CreatedAt: s.now(),
+	}
+	if err := s.saveLocked(); err != nil {
+		s.restoreLocked(user, prev)
+		return TOTPEnrollment{}, err
+	}
+
+	uri := buildOTPAuthURI(s.issuer, user, secret)
+	return TOTPEnrollment{
+		Secret:     secret,
+		OTPAuthURI: uri,
+		QRPayload:  uri,
+		Issuer:     s.issuer,
+		Account:    user,
+	}, nil
+}
+
+// ConfirmEnrollment 校验首个验证码并启用两步验证，返回一次性展示的恢复码
+func (s *TOTPStore) ConfirmEnrollment(user, code string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, ok := s.users[user]
+	if !ok || u.Secret == "" {
+		return nil, fmt.Errorf("请先开始绑定两步验证")
+	}
+	if u.Enabled {
+		return nil, fmt.Errorf("两步验证已启用")
+	}
+
+	step, ok := validateTOTP(u.Secret, code, s.now(), 0)
+	if !ok {
+		return nil, fmt.Errorf("验证码无效")
+	}
+
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+
+	prev := *u
+	u.Enabled = true
+	u.EnabledAt = s.now()
+	u.LastUsedStep = step
+	u.RecoveryCodes = hashes
+	if err := s.saveLocked(); err != nil {
+		*u = prev
+		return nil, err
+	}
+	return codes, nil
+}
+
+// Verify 校验TOTP验证码或恢复码（恢复码使用后即失效）
+// usedRecovery 表示本次是否消耗了恢复码
+func (s *TOTPStore) Verify(user, code string) (usedRecovery bool, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, ok := s.users[user]
+	if !ok || !u.Enabled {
+		return false, fmt.Errorf("未启用两步验证")
+	}
+
+	code = strings.TrimSpace(code)
+	if step, ok := validateTOTP(u.Secret, code, s.now(), u.LastUsedStep); ok {
+		prev := u.LastUsedStep
+		u.LastUsedStep = step
+		if err := s.saveLocked(); err != nil {
+			u.LastUsedStep = prev
+			return false, err
+		}
+		return false, nil
+	}
+
+	hash := hashRecoveryCode(code)
+	for i, stored := range u.RecoveryCodes {
+		if subtle.ConstantTimeCompare([]byte(stored), []byte(hash)) == 1 {
+			prev := u.RecoveryCodes
+			u.RecoveryCodes = append(append([]string{}, prev[:i]...), prev[i+1:]...)
+			if err := s.saveLocked(); err != nil {
+				u.RecoveryCodes = prev
+				return false, err
+			}
+			return true, nil
+		}
+	}
+
+	return false, fmt.Errorf("验证码无效")
+}
+
+// RegenerateRecoveryCodes 重新生成恢复码（需已启用两步验证）
+func (s *TOTPStore) RegenerateRecoveryCodes(user string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, ok := s.users[user]
+	if !ok || !u.Enabled {
+		return nil, fmt.Errorf("未启用两步验证")
+	}
+
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+
+	prev := u.RecoveryCodes
+	u.RecoveryCodes = hashes
+	if err := s.saveLocked(); err != nil {
+		u.RecoveryCodes = prev
+		return nil, err
+	}
+	return codes, nil
+}
+
+// Disable 停用用户的两步验证
+func (s *TOTPStore) Disable(user string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	prev, ok := s.users[user]
+	if !ok {
+		return nil
+	}
+	delete(s.users, user)
+	if err := s.saveLocked(); err != nil {
+		s.users[user] = prev
+		return err
+	}
+	return nil
+}
+
+// restoreLocked 保存失败时回滚用户配置
+func (s *TOTPStore) restoreLocked(user string, prev *TOTPUser) {
+	if prev == nil {
+		delete(s.users, user)
+		return
+	}
+	s.users[user] = prev
+}
+
+// saveLocked 持久化到文件（调用时需持有锁）
+func (s *TOTPStore) saveLocked() error {
+	data, err := json.MarshalIndent(s.users, "", "  ")
+	if err != nil {
+		return fmt.Errorf("序列化两步验证配置失败: %w", err)
+	}
+	if err := utils.WriteFileAtomic(s.path, data); err != nil {
+		return fmt.Errorf("保存两步验证配置失败: %w", err)
+	}
+	return nil
+}
+
+// generateTOTPSecret 生成 base32 编码的随机密钥
+func generateTOTPSecret() (string, error) {
+	b := make([]byte, totpSecretBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("生成密钥失败: %w", err)
+	}
+	return totpEncoding.EncodeToString(b), nil
+}
+
+// buildOTPAuthURI 生成认证器App可识别的 otpauth URI
+func buildOTPAuthURI(issuer, account, secret string) string {
+	label := url.PathEscape(issuer + ":" + account)
+	params := url.Values{}
+	params.Set("secret", secret)
+	params.Set("issuer", issuer)
+	params.Set("algorithm", "SHA1")
+	params.Set("digits", fmt.Sprintf("%d", totpDigits))
+	params.Set("period", fmt.Sprintf("%d", int(totpPeriod.Seconds())))
+	return "otpauth://totp/" + label + "?" + params.Encode()
+}
+
+// totpCodeAt 计算指定时间步的验证码（RFC 4226 HOTP）
+func totpCodeAt(secret string, step int64) (string, error) {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(strings.TrimRight(secret, "=")))
+	if err != nil {
+		return "", fmt.Errorf("无效的密钥: %w", err)
+	}
+
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	mod := uint32(1)
+	for i := 0; i < totpDigits; i++ {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", totpDigits, value%mod), nil
+}
+
+// validateTOTP 校验验证码，返回匹配的时间步
+// 只接受大于 lastStep 的时间步，防止验证码重放
+func validateTOTP(secret, code string, now time.Time, lastStep int64) (int64, bool) {
+	code = strings.ReplaceAll(strings.TrimSpace(code), " ", "")
+	if len(code) != totpDigits {
+		return 0, false
+	}
+
+	current := now.Unix() / int64(totpPeriod.Seconds())
+	for delta := int64(-totpSkewSteps); delta <= totpSkewSteps; delta++ {
+		step := current + delta
+		if step <= lastStep {
+			continue
+		}
+		expected, err := totpCodeAt(secret, step)
+		if err != nil {
+			return 0, false
+		}
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// generateRecoveryCodes 生成恢复码，返回明文和对应哈希
+func generateRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, 0, recoveryCodeCount)
+	hashes := make([]string, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		b := make([]byte, recoveryCodeLength/2)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, fmt.Errorf("生成恢复码失败: %w", err)
+		}
+		raw := hex.EncodeToString(b)
+		code := raw[:recoveryCodeLength/2] + "-" + raw[recoveryCodeLength/2:]
+		codes = append(codes, code)
+		hashes = append(hashes, hashRecoveryCode(code))
+	}
+	return codes, hashes, nil
+}
+
+// hashRecoveryCode 计算恢复码哈希（忽略大小写和分隔符）
+func hashRecoveryCode(code string) string {
+	normalized := strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+	sum := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(sum[:])
+}
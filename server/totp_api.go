@@ -0,0 +1,174 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+
+	"kiro2api/logger"
+
+	"github.com/gin-gonic/gin"
+)
+
+// TOTPCodeRequest 携带两步验证码的请求结构
+type TOTPCodeRequest struct {
+	Code string `json:"code"` // TOTP 验证码或恢复码
+}
+
+// registerTOTPRoutes 注册两步验证管理路由（需要已登录会话）
+func registerTOTPRoutes(r *gin.Engine, h *AuthHandlers) {
+	group := r.Group("/api/2fa")
+	group.Use(AdminAPIAuthGuard())
+
+	group.GET("/status", h.HandleTOTPStatus)
+	group.POST("/enroll", h.HandleTOTPEnroll)
+	group.POST("/confirm", h.HandleTOTPConfirm)
+	group.POST("/disable", h.HandleTOTPDisable)
+	group.POST("/recovery-codes", h.HandleTOTPRegenerateRecoveryCodes)
+}
+
+// HandleTOTPStatus 查询当前用户的两步验证状态
+func (h *AuthHandlers) HandleTOTPStatus(c *gin.Context) {
+	user := GetSessionUser(c)
+	c.JSON(http.StatusOK, gin.H{
+		"success":                  true,
+		"enabled":                  h.totp.IsEnabled(user),
+		"recovery_codes_remaining": h.totp.RecoveryCodesRemaining(user),
+	})
+}
+
+// HandleTOTPEnroll 开始绑定两步验证，返回密钥和 otpauth URI
+func (h *AuthHandlers) HandleTOTPEnroll(c *gin.Context) {
+	user := GetSessionUser(c)
+
+	enrollment, err := h.totp.BeginEnrollment(user)
+	if err != nil {
+		logger.Warn("开始绑定两步验证失败",
+			logger.String("username", user),
+			logger.Err(err))
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	logger.Info("开始绑定两步验证", logger.String("username", user))
+	c.JSON(http.StatusOK, gin.H{
+		"success":    true,
+		"enrollment": enrollment,
+	})
+}
+
+// HandleTOTPConfirm 校验首个验证码并启用两步验证
+func (h *AuthHandlers) HandleTOTPConfirm(c *gin.Context) {
+	user := GetSessionUser(c)
+
+	var req TOTPCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.Code == "" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   "请输入验证码",
+		})
+		return
+	}
+
+	codes, err := h.totp.ConfirmEnrollment(user, req.Code)
+	h.audit.Record(c, AuditActionTOTPEnable, "user:"+user, nil, map[string]any{"totp_enabled": err == nil}, err)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	logger.Info("两步验证已启用", logger.String("username", user))
+	c.JSON(http.StatusOK, gin.H{
+		"success":        true,
+		"message":        "两步验证已启用，请妥善保存恢复码",
+		"recovery_codes": codes,
+	})
+}
+
+// HandleTOTPDisable 停用两步验证（需要提供当前验证码或恢复码）
+func (h *AuthHandlers) HandleTOTPDisable(c *gin.Context) {
+	user := GetSessionUser(c)
+
+	var req TOTPCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.Code == "" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   "请输入验证码",
+		})
+		return
+	}
+
+	if _, err := h.totp.Verify(user, req.Code); err != nil {
+		h.audit.Record(c, AuditActionTOTPDisable, "user:"+user, nil, nil, err)
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	err := h.totp.Disable(user)
+	h.audit.Record(c, AuditActionTOTPDisable, "user:"+user,
+		map[string]any{"totp_enabled": true}, map[string]any{"totp_enabled": err != nil}, err)
+	if err != nil {
+		logger.Error("停用两步验证失败", logger.String("username", user), logger.Err(err))
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"error":   "停用失败: " + err.Error(),
+		})
+		return
+	}
+
+	logger.Info("两步验证已停用", logger.String("username", user))
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "两步验证已停用",
+	})
+}
+
+// HandleTOTPRegenerateRecoveryCodes 重新生成恢复码（旧恢复码全部失效）
+func (h *AuthHandlers) HandleTOTPRegenerateRecoveryCodes(c *gin.Context) {
+	user := GetSessionUser(c)
+
+	var req TOTPCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.Code == "" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   "请输入验证码",
+		})
+		return
+	}
+
+	if usedRecovery, err := h.totp.Verify(user, req.Code); err != nil || usedRecovery {
+		if err == nil {
+			err = fmt.Errorf("重新生成恢复码需要使用认证器App中的验证码")
+		}
+		h.audit.Record(c, AuditActionTOTPRecoveryReset, "user:"+user, nil, nil, err)
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	codes, err := h.totp.RegenerateRecoveryCodes(user)
+	h.audit.Record(c, AuditActionTOTPRecoveryReset, "user:"+user, nil, nil, err)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	logger.Info("已重新生成恢复码", logger.String("username", user))
+	c.JSON(http.StatusOK, gin.H{
+		"success":        true,
+		"recovery_codes": codes,
+	})
+}
@@ -0,0 +1,149 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// RFC 6238 附录B 测试向量（SHA1，取低6位）
+func TestTOTPCodeAt_RFC6238Vectors(t *testing.T) {
+	secret := totpEncoding.EncodeToString([]byte("12345678901234567890"))
+
+	vectors := map[int64]string{
+		59:          "287082",
+		1111111109:  "081804",
+		1111111111:  "050471",
+		1234567890:  "005924",
+		2000000000:  "279037",
+		20000000000: "353130",
+	}
+	for unix, want := range vectors {
+		code, err := totpCodeAt(secret, unix/30)
+		require.NoError(t, err)
+		assert.Equal(t, want, code, "unix=%d", unix)
+	}
+}
+
+func TestValidateTOTP_SkewAndReplay(t *testing.T) {
+	secret, err := generateTOTPSecret()
+	require.NoError(t, err)
+
+	now := time.Unix(1700000000, 0)
+	step := now.Unix() / 30
+	prevCode, _ := totpCodeAt(secret, step-1)
+
+	matched, ok := validateTOTP(secret, prevCode, now, 0)
+	assert.True(t, ok)
+	assert.Equal(t, step-1, matched)
+
+	// 已使用过的时间步不能再次使用
+	_, ok = validateTOTP(secret, prevCode, now, step-1)
+	assert.False(t, ok)
+
+	_, ok = validateTOTP(secret, "000000x", now, 0)
+	assert.False(t, ok)
+}
+
+func TestTOTPStore_EnrollVerifyAndRecovery(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "totp.json")
+	store, err := NewTOTPStore(path, "")
+	require.NoError(t, err)
+
+	now := time.Unix(1700000000, 0)
+	store.now = func() time.Time { return now }
+
+	enrollment, err := store.BeginEnrollment("admin")
+	require.NoError(t, err)
+	assert.Contains(t, enrollment.OTPAuthURI, "otpauth://totp/Kiro2API:admin?")
+	assert.Contains(t, enrollment.OTPAuthURI, "secret="+enrollment.Secret)
+	assert.Equal(t, enrollment.OTPAuthURI, enrollment.QRPayload)
+	assert.False(t, store.IsEnabled("admin"), "确认前不应启用")
+
+	code, _ := totpCodeAt(enrollment.Secret, now.Unix()/30)
+	_, err = store.ConfirmEnrollment("admin", "123")
+	assert.Error(t, err)
+	codes, err := store.ConfirmEnrollment("admin", code)
+	require.NoError(t, err)
+	assert.Len(t, codes, recoveryCodeCount)
+	assert.True(t, store.IsEnabled("admin"))
+
+	// 同一验证码不能重放
+	_, err = store.Verify("admin", code)
+	assert.Error(t, err)
+
+	now = now.Add(30 * time.Second)
+	next, _ := totpCodeAt(enrollment.Secret, now.Unix()/30)
+	usedRecovery, err := store.Verify("admin", next)
+	require.NoError(t, err)
+	assert.False(t, usedRecovery)
+
+	// 恢复码只能使用一次
+	usedRecovery, err = store.Verify("admin", codes[0])
+	require.NoError(t, err)
+	assert.True(t, usedRecovery)
+	_, err = store.Verify("admin", codes[0])
+	assert.Error(t, err)
+	assert.Equal(t, recoveryCodeCount-1, store.RecoveryCodesRemaining("admin"))
+
+	// 重新加载后状态保持，且文件中不含恢复码明文
+	reloaded, err := NewTOTPStore(path, "")
+	require.NoError(t, err)
+	assert.True(t, reloaded.IsEnabled("admin"))
+	assert.Equal(t, recoveryCodeCount-1, reloaded.RecoveryCodesRemaining("admin"))
+
+	require.NoError(t, store.Disable("admin"))
+	assert.False(t, store.IsEnabled("admin"))
+}
+
+func TestHandleLogin_RequiresTOTPBeforeSession(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	store, err := NewTOTPStore(filepath.Join(t.TempDir(), "totp.json"), "")
+	require.NoError(t, err)
+	enrollment, err := store.BeginEnrollment("admin")
+	require.NoError(t, err)
+	code, _ := totpCodeAt(enrollment.Secret, time.Now().Unix()/30)
+	_, err = store.ConfirmEnrollment("admin", code)
+	require.NoError(t, err)
+	// 允许登录时使用下一个时间步的验证码
+	store.now = func() time.Time { return time.Now().Add(30 * time.Second) }
+
+	sessions := NewSessionManager(time.Minute, time.Hour)
+	defer sessions.Close()
+	h := NewAuthHandlers(sessions, "admin", "secret", time.Minute, false, store, nil, nil)
+
+	r := gin.New()
+	r.POST("/api/login", h.HandleLogin)
+
+	login := func(body map[string]string) *httptest.ResponseRecorder {
+		data, _ := json.Marshal(body)
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/api/login", bytes.NewReader(data))
+		req.Header.Set("Content-Type", "application/json")
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	w := login(map[string]string{"username": "admin", "password": "secret"})
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Contains(t, w.Body.String(), `"totp_required":true`)
+	assert.Equal(t, 0, sessions.Count())
+
+	w = login(map[string]string{"username": "admin", "password": "secret", "totp_code": "000000"})
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Equal(t, 0, sessions.Count())
+
+	next, _ := totpCodeAt(enrollment.Secret, store.now().Unix()/30)
+	w = login(map[string]string{"username": "admin", "password": "secret", "totp_code": next})
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, 1, sessions.Count())
+}
@@ -0,0 +1,72 @@
+package server
+
+import (
+	"context"
+	"strings"
+
+	"kiro2api/tracing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// TracingMiddleware 为 /v1 代理请求创建根 span，并继承请求头中的 W3C traceparent
+func TracingMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !tracing.Enabled() || !strings.HasPrefix(c.Request.URL.Path, "/v1/") {
+			c.Next()
+			return
+		}
+
+		ctx := c.Request.Context()
+		if parent, ok := tracing.ParseTraceparent(c.GetHeader("traceparent")); ok {
+			ctx = tracing.ContextWithRemoteParent(ctx, parent)
+		}
+		ctx, span := tracing.Start(ctx, c.Request.Method+" "+c.Request.URL.Path, tracing.SpanKindServer,
+			tracing.String("http.request.method", c.Request.Method),
+			tracing.String("url.path", c.Request.URL.Path),
+			tracing.String("client.address", c.ClientIP()),
+			tracing.String("kiro.request_id", GetRequestID(c)))
+		c.Request = c.Request.WithContext(ctx)
+
+		c.Next()
+
+		if route := c.FullPath(); route != "" {
+			span.SetName(c.Request.Method + " " + route)
+			span.SetAttributes(tracing.String("http.route", route))
+		}
+		status := c.Writer.Status()
+		span.SetAttributes(tracing.Int("http.response.status_code", status))
+		if client := c.GetString(clientTokenLabelKey); client != "" {
+			span.SetAttributes(tracing.String("kiro.client", client))
+		}
+		if status >= 500 {
+			span.SetError("HTTP %d", status)
+		}
+		span.End()
+	}
+}
+
+// startSpan 在当前请求的追踪上下文中创建子 span（未启用追踪时返回 nil span，方法调用安全）
+func startSpan(c *gin.Context, name string, kind tracing.SpanKind, attrs ...tracing.Attribute) (context.Context, *tracing.Span) {
+	return tracing.Start(c.Request.Context(), name, kind, attrs...)
+}
+
+// setRequestSpanAttributes 在请求根 span 上设置属性（模型、账号、token 估算、stop_reason 等）
+func setRequestSpanAttributes(c *gin.Context, attrs ...tracing.Attribute) {
+	if c.Request == nil {
+		return
+	}
+	tracing.SpanFromContext(c.Request.Context()).SetAttributes(attrs...)
+}
+
+// getTraceID 返回当前请求的 trace-id（未追踪时返回空串）
+func getTraceID(c *gin.Context) string {
+	if c.Request == nil {
+		return ""
+	}
+	span := tracing.SpanFromContext(c.Request.Context())
+	if span == nil {
+		return ""
+	}
+	return span.SpanContext().TraceID.String()
+}
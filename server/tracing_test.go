@@ -0,0 +1,145 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"kiro2api/tracing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// collectedSpan 收集器收到的 span（仅解析断言需要的字段）
+type collectedSpan struct {
+	TraceID      string `json:"traceId"`
+	SpanID       string `json:"spanId"`
+	ParentSpanID string `json:"parentSpanId"`
+	Name         string `json:"name"`
+	Attributes   []struct {
+		Key   string         `json:"key"`
+		Value map[string]any `json:"value"`
+	} `json:"attributes"`
+}
+
+func (s collectedSpan) attr(key string) any {
+	for _, a := range s.Attributes {
+		if a.Key == key {
+			for _, v := range a.Value {
+				return v
+			}
+		}
+	}
+	return nil
+}
+
+// setupTestTracer 启动模拟 OTLP 收集器并设置全局追踪器
+func setupTestTracer(t *testing.T) (*tracing.Tracer, func() []collectedSpan) {
+	var mu sync.Mutex
+	var spans []collectedSpan
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			ResourceSpans []struct {
+				ScopeSpans []struct {
+					Spans []collectedSpan `json:"spans"`
+				} `json:"scopeSpans"`
+			} `json:"resourceSpans"`
+		}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		mu.Lock()
+		defer mu.Unlock()
+		for _, rs := range req.ResourceSpans {
+			for _, ss := range rs.ScopeSpans {
+				spans = append(spans, ss.Spans...)
+			}
+		}
+	}))
+	t.Cleanup(collector.Close)
+
+	tracer := tracing.NewTracer(tracing.Config{
+		Endpoint:      collector.URL + "/v1/traces",
+		SampleRatio:   1,
+		FlushInterval: time.Hour,
+	})
+	tracing.SetGlobal(tracer)
+	t.Cleanup(func() {
+		tracing.SetGlobal(nil)
+		tracer.Shutdown(context.Background())
+	})
+
+	return tracer, func() []collectedSpan {
+		tracer.ForceFlush(context.Background())
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]collectedSpan(nil), spans...)
+	}
+}
+
+func TestTracingMiddleware_PropagatesTraceparent(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	_, collected := setupTestTracer(t)
+
+	var traceID string
+	r := gin.New()
+	r.Use(RequestIDMiddleware())
+	r.Use(TracingMiddleware())
+	r.POST("/v1/messages", func(c *gin.Context) {
+		traceID = getTraceID(c)
+		_, span := startSpan(c, "upstream.request", tracing.SpanKindClient)
+		span.End()
+		setRequestSpanAttributes(c, tracing.String("gen_ai.request.model", "claude-sonnet-4-20250514"))
+		c.Status(http.StatusBadGateway)
+	})
+	r.GET("/api/tokens", func(c *gin.Context) {
+		assert.Empty(t, getTraceID(c), "非 /v1 请求不追踪")
+		c.Status(http.StatusOK)
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/messages", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	r.ServeHTTP(httptest.NewRecorder(), req)
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/tokens", nil))
+
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", traceID)
+
+	spans := collected()
+	require.Len(t, spans, 2)
+	byName := map[string]collectedSpan{}
+	for _, span := range spans {
+		byName[span.Name] = span
+	}
+	root, child := byName["POST /v1/messages"], byName["upstream.request"]
+	assert.Equal(t, "00f067aa0ba902b7", root.ParentSpanID)
+	assert.Equal(t, traceID, child.TraceID)
+	assert.Equal(t, root.SpanID, child.ParentSpanID)
+	assert.Equal(t, "/v1/messages", root.attr("http.route"))
+	assert.Equal(t, "502", root.attr("http.response.status_code"))
+	assert.Equal(t, "claude-sonnet-4-20250514", root.attr("gen_ai.request.model"))
+	assert.NotEmpty(t, root.attr("kiro.request_id"))
+}
+
+func TestTracingMiddleware_DisabledIsNoop(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	tracing.SetGlobal(nil)
+
+	r := gin.New()
+	r.Use(TracingMiddleware())
+	r.POST("/v1/messages", func(c *gin.Context) {
+		_, span := startSpan(c, "request.parse", tracing.SpanKindInternal)
+		assert.Nil(t, span)
+		span.End()
+		setRequestSpanAttributes(c, tracing.String("k", "v"))
+		assert.Empty(t, getTraceID(c))
+		c.Status(http.StatusOK)
+	})
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/v1/messages", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+}
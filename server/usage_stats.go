@@ -0,0 +1,541 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"kiro2api/logger"
+	"kiro2api/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 用量时间序列：按分钟/小时/天聚合请求数、token、错误和额度消耗，
+// 维度为模型、账号和客户端令牌，并根据近期消耗速度预测账号池额度耗尽时间
+
+const (
+	// defaultUsageStatsFile 默认用量统计存储文件
+	defaultUsageStatsFile = "usage_stats.json"
+	// usageStatsSaveInterval 用量统计持久化间隔
+	usageStatsSaveInterval = time.Minute
+
+	// context 中保存的用量维度和估算值（由请求处理流程写入，中间件在请求结束时汇总）
+	requestAccountKey      = "request_account"
+	requestInputTokensKey  = "request_input_tokens"
+	requestOutputTokensKey = "request_output_tokens"
+
+	// usageEmptyDimension 维度值缺失（如请求在选择账号前失败）时的分组名
+	usageEmptyDimension = "-"
+)
+
+// 时间粒度
+const (
+	UsageResolutionMinute = "minute"
+	UsageResolutionHour   = "hour"
+	UsageResolutionDay    = "day"
+)
+
+// 用量分组维度
+const (
+	UsageGroupNone    = "none"
+	UsageGroupModel   = "model"
+	UsageGroupAccount = "account"
+	UsageGroupClient  = "client"
+)
+
+// usageResolutionSpec 粒度的桶宽、保留桶数和默认查询范围（桶数）
+type usageResolutionSpec struct {
+	step         time.Duration
+	retention    int
+	defaultRange int
+}
+
+var usageResolutions = map[string]usageResolutionSpec{
+	UsageResolutionMinute: {step: time.Minute, retention: 24 * 60, defaultRange: 60},
+	UsageResolutionHour:   {step: time.Hour, retention: 30 * 24, defaultRange: 24},
+	UsageResolutionDay:    {step: 24 * time.Hour, retention: 365, defaultRange: 30},
+}
+
+// UsageCounters 单个桶内的累计值
+// Credit 为估算的额度消耗：每次派发到上游的请求按 1 计，与账号池本地扣减一致
+type UsageCounters struct {
+	Requests     int64   `json:"requests"`
+	Errors       int64   `json:"errors"`
+	InputTokens  int64   `json:"inputTokens"`
+	OutputTokens int64   `json:"outputTokens"`
+	Credit       float64 `json:"credit"`
+}
+
+func (u *UsageCounters) add(o UsageCounters) {
+	u.Requests += o.Requests
+	u.Errors += o.Errors
+	u.InputTokens += o.InputTokens
+	u.OutputTokens += o.OutputTokens
+	u.Credit += o.Credit
+}
+
+// UsageDimensions 用量维度
+type UsageDimensions struct {
+	Model   string `json:"model"`
+	Account string `json:"account"`
+	Client  string `json:"client"`
+}
+
+// group 返回指定分组维度的分组名
+func (d UsageDimensions) group(groupBy string) string {
+	var v string
+	switch groupBy {
+	case UsageGroupModel:
+		v = d.Model
+	case UsageGroupAccount:
+		v = d.Account
+	case UsageGroupClient:
+		v = d.Client
+	default:
+		return ""
+	}
+	if v == "" {
+		return usageEmptyDimension
+	}
+	return v
+}
+
+// matches 判断是否满足过滤条件（空字段不过滤）
+func (d UsageDimensions) matches(filter UsageDimensions) bool {
+	return (filter.Model == "" || filter.Model == d.Model) &&
+		(filter.Account == "" || filter.Account == d.Account) &&
+		(filter.Client == "" || filter.Client == d.Client)
+}
+
+// usageBucket 一个时间桶内各维度组合的累计值
+type usageBucket map[UsageDimensions]*UsageCounters
+
+// 持久化格式
+type usageSeriesRecord struct {
+	UsageDimensions
+	UsageCounters
+}
+
+type usageBucketRecord struct {
+	Start  time.Time           `json:"start"`
+	Series []usageSeriesRecord `json:"series"`
+}
+
+// UsageStats 用量时间序列存储
+type UsageStats struct {
+	path string
+	now  func() time.Time
+
+	mu      sync.Mutex
+	buckets map[string]map[int64]usageBucket // 粒度 -> 桶开始时间(unix) -> 桶
+	dirty   bool
+
+	stopOnce sync.Once
+	stop     chan struct{}
+}
+
+// NewUsageStats 创建用量统计并加载已持久化的数据
+func NewUsageStats(path string) (*UsageStats, error) {
+	if path == "" {
+		path = defaultUsageStatsFile
+	}
+
+	s := &UsageStats{
+		path:    path,
+		now:     time.Now,
+		buckets: make(map[string]map[int64]usageBucket, len(usageResolutions)),
+		stop:    make(chan struct{}),
+	}
+	for resolution := range usageResolutions {
+		s.buckets[resolution] = make(map[int64]usageBucket)
+	}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// StartAutoSave 启动后台定期持久化
+func (s *UsageStats) StartAutoSave(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := s.Save(); err != nil {
+					logger.Warn("保存用量统计失败", logger.Err(err))
+				}
+			case <-s.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Close 停止后台持久化并保存
+func (s *UsageStats) Close() error {
+	s.stopOnce.Do(func() { close(s.stop) })
+	return s.Save()
+}
+
+// Record 将一次请求的用量计入各粒度的当前桶
+func (s *UsageStats) Record(dims UsageDimensions, delta UsageCounters) {
+	now := s.now().UTC()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for resolution, spec := range usageResolutions {
+		start := now.Truncate(spec.step).Unix()
+		buckets := s.buckets[resolution]
+		bucket, ok := buckets[start]
+		if !ok {
+			bucket = make(usageBucket)
+			buckets[start] = bucket
+			s.pruneLocked(resolution, now)
+		}
+		counters, ok := bucket[dims]
+		if !ok {
+			counters = &UsageCounters{}
+			bucket[dims] = counters
+		}
+		counters.add(delta)
+	}
+	s.dirty = true
+}
+
+// pruneLocked 删除超出保留期的桶（调用时需持有锁）
+func (s *UsageStats) pruneLocked(resolution string, now time.Time) {
+	spec := usageResolutions[resolution]
+	cutoff := now.Truncate(spec.step).Add(-time.Duration(spec.retention-1) * spec.step).Unix()
+	for start := range s.buckets[resolution] {
+		if start < cutoff {
+			delete(s.buckets[resolution], start)
+		}
+	}
+}
+
+// UsageQuery 用量查询条件
+type UsageQuery struct {
+	Resolution string
+	GroupBy    string
+	Since      time.Time // 为零时按粒度的默认范围
+	Until      time.Time // 为零时为当前时间
+	Filter     UsageDimensions
+}
+
+// UsagePoint 图表中的一个时间点
+type UsagePoint struct {
+	Start  time.Time                `json:"start"`
+	Total  UsageCounters            `json:"total"`
+	Groups map[string]UsageCounters `json:"groups,omitempty"`
+}
+
+// UsageQueryResult 用量查询结果（时间点连续，无数据的桶补零）
+type UsageQueryResult struct {
+	Resolution string        `json:"resolution"`
+	GroupBy    string        `json:"groupBy"`
+	Since      time.Time     `json:"since"`
+	Until      time.Time     `json:"until"`
+	Points     []UsagePoint  `json:"points"`
+	Totals     UsageCounters `json:"totals"`
+	Groups     []string      `json:"groups,omitempty"` // 按请求数降序
+}
+
+// Query 按粒度、时间范围、过滤条件和分组查询用量
+func (s *UsageStats) Query(q UsageQuery) (UsageQueryResult, error) {
+	spec, ok := usageResolutions[q.Resolution]
+	if !ok {
+		return UsageQueryResult{}, fmt.Errorf("无效的粒度: %s（可选 minute、hour、day）", q.Resolution)
+	}
+	switch q.GroupBy {
+	case "":
+		q.GroupBy = UsageGroupNone
+	case UsageGroupNone, UsageGroupModel, UsageGroupAccount, UsageGroupClient:
+	default:
+		return UsageQueryResult{}, fmt.Errorf("无效的分组维度: %s（可选 none、model、account、client）", q.GroupBy)
+	}
+
+	until := q.Until
+	if until.IsZero() {
+		until = s.now()
+	}
+	until = until.UTC().Truncate(spec.step)
+	since := q.Since
+	if since.IsZero() {
+		since = until.Add(-time.Duration(spec.defaultRange-1) * spec.step)
+	}
+	since = since.UTC().Truncate(spec.step)
+	if since.After(until) {
+		return UsageQueryResult{}, fmt.Errorf("since 不能晚于 until")
+	}
+	if earliest := until.Add(-time.Duration(spec.retention-1) * spec.step); since.Before(earliest) {
+		since = earliest
+	}
+
+	result := UsageQueryResult{
+		Resolution: q.Resolution,
+		GroupBy:    q.GroupBy,
+		Since:      since,
+		Until:      until,
+	}
+	groupTotals := make(map[string]int64)
+
+	s.mu.Lock()
+	for t := since; !t.After(until); t = t.Add(spec.step) {
+		point := UsagePoint{Start: t}
+		if q.GroupBy != UsageGroupNone {
+			point.Groups = make(map[string]UsageCounters)
+		}
+		for dims, counters := range s.buckets[q.Resolution][t.Unix()] {
+			if !dims.matches(q.Filter) {
+				continue
+			}
+			point.Total.add(*counters)
+			if point.Groups != nil {
+				key := dims.group(q.GroupBy)
+				group := point.Groups[key]
+				group.add(*counters)
+				point.Groups[key] = group
+				groupTotals[key] += counters.Requests
+			}
+		}
+		result.Totals.add(point.Total)
+		result.Points = append(result.Points, point)
+	}
+	s.mu.Unlock()
+
+	for key := range groupTotals {
+		result.Groups = append(result.Groups, key)
+	}
+	sort.Slice(result.Groups, func(i, j int) bool {
+		a, b := result.Groups[i], result.Groups[j]
+		if groupTotals[a] != groupTotals[b] {
+			return groupTotals[a] > groupTotals[b]
+		}
+		return a < b
+	})
+	return result, nil
+}
+
+// UsageForecast 账号池额度耗尽预测
+type UsageForecast struct {
+	RemainingCredit float64    `json:"remainingCredit"`
+	UsableAccounts  int        `json:"usableAccounts"`
+	BurnRatePerHour float64    `json:"burnRatePerHour"`
+	RateWindow      string     `json:"rateWindow,omitempty"` // 计算消耗速度所用的窗口
+	HoursRemaining  *float64   `json:"hoursRemaining,omitempty"`
+	ExhaustAt       *time.Time `json:"exhaustAt,omitempty"`
+}
+
+// Forecast 根据近 1 小时（无消耗时退回近 24 小时）的额度消耗速度预测耗尽时间
+func (s *UsageStats) Forecast(pool accountPoolSource) UsageForecast {
+	var forecast UsageForecast
+	for _, status := range pool.GetAllCacheStatus() {
+		if accountState(status) == "usable" {
+			forecast.UsableAccounts++
+			forecast.RemainingCredit += status.Available
+		}
+	}
+
+	now := s.now()
+	if credit := s.creditSince(UsageResolutionMinute, now.Add(-time.Hour)); credit > 0 {
+		forecast.BurnRatePerHour = credit
+		forecast.RateWindow = "1h"
+	} else if credit := s.creditSince(UsageResolutionHour, now.Add(-24*time.Hour)); credit > 0 {
+		forecast.BurnRatePerHour = credit / 24
+		forecast.RateWindow = "24h"
+	}
+
+	if forecast.BurnRatePerHour > 0 {
+		hours := forecast.RemainingCredit / forecast.BurnRatePerHour
+		exhaustAt := now.Add(time.Duration(hours * float64(time.Hour)))
+		forecast.HoursRemaining = &hours
+		forecast.ExhaustAt = &exhaustAt
+	}
+	return forecast
+}
+
+// creditSince 统计指定粒度下某时间之后（不含该时间所在的桶）的额度消耗
+func (s *UsageStats) creditSince(resolution string, since time.Time) float64 {
+	after := since.UTC().Truncate(usageResolutions[resolution].step).Unix()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	total := 0.0
+	for start, bucket := range s.buckets[resolution] {
+		if start <= after {
+			continue
+		}
+		for _, counters := range bucket {
+			total += counters.Credit
+		}
+	}
+	return total
+}
+
+// Save 持久化用量统计（无变化时跳过）
+func (s *UsageStats) Save() error {
+	s.mu.Lock()
+	if !s.dirty {
+		s.mu.Unlock()
+		return nil
+	}
+	state := make(map[string][]usageBucketRecord, len(s.buckets))
+	for resolution, buckets := range s.buckets {
+		records := make([]usageBucketRecord, 0, len(buckets))
+		for start, bucket := range buckets {
+			record := usageBucketRecord{Start: time.Unix(start, 0).UTC()}
+			for dims, counters := range bucket {
+				record.Series = append(record.Series, usageSeriesRecord{UsageDimensions: dims, UsageCounters: *counters})
+			}
+			records = append(records, record)
+		}
+		sort.Slice(records, func(i, j int) bool { return records[i].Start.Before(records[j].Start) })
+		state[resolution] = records
+	}
+	s.dirty = false
+	s.mu.Unlock()
+
+	data, err := json.Marshal(state)
+	if err != nil {
+		return fmt.Errorf("序列化用量统计失败: %w", err)
+	}
+	if err := utils.WriteFileAtomic(s.path, data); err != nil {
+		return fmt.Errorf("写入用量统计失败: %w", err)
+	}
+	return nil
+}
+
+// load 加载持久化的用量统计（文件不存在时忽略）
+func (s *UsageStats) load() error {
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("读取用量统计失败: %w", err)
+	}
+	if len(data) == 0 {
+		return nil
+	}
+
+	var state map[string][]usageBucketRecord
+	if err := json.Unmarshal(data, &state); err != nil {
+		return fmt.Errorf("解析用量统计失败: %w", err)
+	}
+
+	now := s.now().UTC()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for resolution, records := range state {
+		buckets, ok := s.buckets[resolution]
+		if !ok {
+			continue
+		}
+		for _, record := range records {
+			bucket := make(usageBucket, len(record.Series))
+			for _, series := range record.Series {
+				counters := series.UsageCounters
+				bucket[series.UsageDimensions] = &counters
+			}
+			buckets[record.Start.Unix()] = bucket
+		}
+		s.pruneLocked(resolution, now)
+	}
+	return nil
+}
+
+// UsageStatsMiddleware 在代理请求结束时记录用量（需在认证中间件之后，未认证请求不计入）
+func UsageStatsMiddleware(stats *UsageStats) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if stats == nil || !proxyPaths[c.FullPath()] {
+			c.Next()
+			return
+		}
+
+		c.Next()
+
+		account := c.GetString(requestAccountKey)
+		delta := UsageCounters{
+			Requests:     1,
+			InputTokens:  int64(c.GetInt(requestInputTokensKey)),
+			OutputTokens: int64(c.GetInt(requestOutputTokensKey)),
+		}
+		if c.Writer.Status() >= http.StatusBadRequest {
+			delta.Errors = 1
+		}
+		if account != "" {
+			delta.Credit = 1
+		}
+		stats.Record(UsageDimensions{
+			Model:   c.GetString(requestModelKey),
+			Account: account,
+			Client:  c.GetString(clientTokenLabelKey),
+		}, delta)
+	}
+}
+
+// registerUsageRoutes 注册用量统计 API
+func registerUsageRoutes(r *gin.Engine, stats *UsageStats, pool accountPoolSource, requireAuth bool) {
+	group := r.Group("/api/usage")
+	if requireAuth {
+		group.Use(AdminAPIAuthGuard())
+	}
+
+	group.GET("", func(c *gin.Context) {
+		handleQueryUsage(c, stats)
+	})
+
+	group.GET("/forecast", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{
+			"success":  true,
+			"forecast": stats.Forecast(pool),
+		})
+	})
+}
+
+// handleQueryUsage 查询用量时间序列
+// 参数: resolution=minute|hour|day, group_by=none|model|account|client, since/until (RFC3339), model/account/client 过滤
+func handleQueryUsage(c *gin.Context, stats *UsageStats) {
+	query := UsageQuery{
+		Resolution: c.DefaultQuery("resolution", UsageResolutionHour),
+		GroupBy:    c.Query("group_by"),
+		Filter: UsageDimensions{
+			Model:   c.Query("model"),
+			Account: c.Query("account"),
+			Client:  c.Query("client"),
+		},
+	}
+	for name, target := range map[string]*time.Time{"since": &query.Since, "until": &query.Until} {
+		if value := c.Query(name); value != "" {
+			t, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{
+					"success": false,
+					"error":   fmt.Sprintf("无效的 %s（需要 RFC3339 格式）: %s", name, value),
+				})
+				return
+			}
+			*target = t
+		}
+	}
+
+	result, err := stats.Query(query)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"usage":   result,
+	})
+}
@@ -0,0 +1,166 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"kiro2api/auth"
+	"kiro2api/types"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestUsageStats 创建使用可控时钟的用量统计
+func newTestUsageStats(t *testing.T, now *time.Time) *UsageStats {
+	stats, err := NewUsageStats(filepath.Join(t.TempDir(), "usage.json"))
+	require.NoError(t, err)
+	stats.now = func() time.Time { return *now }
+	return stats
+}
+
+func TestUsageStats_QueryGroupsAndFillsGaps(t *testing.T) {
+	now := time.Date(2026, 3, 1, 10, 30, 0, 0, time.UTC)
+	stats := newTestUsageStats(t, &now)
+
+	sonnet := UsageDimensions{Model: "claude-sonnet-4-20250514", Account: "acc1", Client: "dev"}
+	haiku := UsageDimensions{Model: "claude-3-5-haiku-20241022", Account: "acc2", Client: "dev"}
+	stats.Record(sonnet, UsageCounters{Requests: 1, InputTokens: 100, OutputTokens: 50, Credit: 1})
+	stats.Record(haiku, UsageCounters{Requests: 1, Errors: 1})
+	now = now.Add(2 * time.Minute)
+	stats.Record(sonnet, UsageCounters{Requests: 1, InputTokens: 10, OutputTokens: 5, Credit: 1})
+
+	result, err := stats.Query(UsageQuery{
+		Resolution: UsageResolutionMinute,
+		GroupBy:    UsageGroupModel,
+		Since:      now.Add(-2 * time.Minute),
+	})
+	require.NoError(t, err)
+	require.Len(t, result.Points, 3, "无数据的分钟补零")
+	assert.Equal(t, int64(2), result.Points[0].Total.Requests)
+	assert.Equal(t, int64(1), result.Points[0].Total.Errors)
+	assert.Equal(t, int64(0), result.Points[1].Total.Requests)
+	assert.Equal(t, int64(110), result.Totals.InputTokens)
+	assert.Equal(t, 2.0, result.Totals.Credit)
+	assert.Equal(t, []string{"claude-sonnet-4-20250514", "claude-3-5-haiku-20241022"}, result.Groups)
+	assert.Equal(t, int64(1), result.Points[0].Groups["claude-3-5-haiku-20241022"].Errors)
+
+	// 小时粒度合并到同一个桶，按账号过滤
+	result, err = stats.Query(UsageQuery{Resolution: UsageResolutionHour, Filter: UsageDimensions{Account: "acc1"}})
+	require.NoError(t, err)
+	assert.Len(t, result.Points, 24)
+	assert.Equal(t, int64(2), result.Points[23].Total.Requests)
+	assert.Nil(t, result.Points[23].Groups)
+
+	_, err = stats.Query(UsageQuery{Resolution: "week"})
+	assert.Error(t, err)
+	_, err = stats.Query(UsageQuery{Resolution: UsageResolutionDay, GroupBy: "region"})
+	assert.Error(t, err)
+}
+
+func TestUsageStats_RetentionAndPersistence(t *testing.T) {
+	now := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	path := filepath.Join(t.TempDir(), "usage.json")
+	stats, err := NewUsageStats(path)
+	require.NoError(t, err)
+	stats.now = func() time.Time { return now }
+
+	dims := UsageDimensions{Model: "m", Client: "c"}
+	stats.Record(dims, UsageCounters{Requests: 1})
+	now = now.Add(25 * time.Hour)
+	stats.Record(dims, UsageCounters{Requests: 1})
+
+	stats.mu.Lock()
+	assert.Len(t, stats.buckets[UsageResolutionMinute], 1, "超过24小时的分钟桶被清理")
+	assert.Len(t, stats.buckets[UsageResolutionHour], 2)
+	stats.mu.Unlock()
+
+	require.NoError(t, stats.Close())
+
+	reloaded, err := NewUsageStats(path)
+	require.NoError(t, err)
+	reloaded.now = func() time.Time { return now }
+	result, err := reloaded.Query(UsageQuery{Resolution: UsageResolutionDay, Since: now.Add(-48 * time.Hour)})
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), result.Totals.Requests)
+}
+
+func TestUsageStats_Forecast(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	stats := newTestUsageStats(t, &now)
+	pool := &fakeAccountPool{statuses: []auth.TokenCacheStatus{
+		{Index: 0, Cached: true, Available: 80, Token: types.TokenInfo{ExpiresAt: time.Now().Add(time.Hour)}},
+		{Index: 1, Cached: true, Available: 20, Token: types.TokenInfo{ExpiresAt: time.Now().Add(time.Hour)}},
+		{Index: 2, Disabled: true, Available: 500},
+	}}
+
+	forecast := stats.Forecast(pool)
+	assert.Equal(t, 100.0, forecast.RemainingCredit)
+	assert.Equal(t, 2, forecast.UsableAccounts)
+	assert.Nil(t, forecast.ExhaustAt, "没有消耗时无法预测")
+
+	for i := 0; i < 10; i++ {
+		stats.Record(UsageDimensions{Account: "acc1"}, UsageCounters{Requests: 1, Credit: 1})
+	}
+	now = now.Add(time.Minute)
+	forecast = stats.Forecast(pool)
+	assert.Equal(t, 10.0, forecast.BurnRatePerHour)
+	assert.Equal(t, "1h", forecast.RateWindow)
+	require.NotNil(t, forecast.HoursRemaining)
+	assert.InDelta(t, 10.0, *forecast.HoursRemaining, 0.001)
+	assert.Equal(t, now.Add(10*time.Hour), *forecast.ExhaustAt)
+
+	// 近1小时无消耗时退回24小时平均
+	now = now.Add(3 * time.Hour)
+	forecast = stats.Forecast(pool)
+	assert.Equal(t, "24h", forecast.RateWindow)
+	assert.InDelta(t, 10.0/24, forecast.BurnRatePerHour, 0.001)
+}
+
+func TestUsageStatsMiddleware_RecordsProxyRequests(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	t.Chdir(t.TempDir())
+	now := time.Now()
+	stats := newTestUsageStats(t, &now)
+
+	manager := createTestClientTokenManager("usage-token-123456")
+	r := gin.New()
+	r.Use(PathBasedAuthMiddleware(manager, []string{"/v1"}))
+	r.Use(UsageStatsMiddleware(stats))
+	r.POST("/v1/messages", func(c *gin.Context) {
+		setRequestModel(c, "claude-sonnet-4-20250514")
+		c.Set(requestAccountKey, "acc1")
+		recordEstimatedTokens(c, "claude-sonnet-4-20250514", 30, 12)
+		c.Status(http.StatusOK)
+	})
+	r.POST("/v1/messages/count_tokens", func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+	registerUsageRoutes(r, stats, &fakeAccountPool{}, false)
+
+	for _, path := range []string{"/v1/messages", "/v1/messages/count_tokens"} {
+		req := httptest.NewRequest(http.MethodPost, path, nil)
+		req.Header.Set("Authorization", "Bearer usage-token-123456")
+		r.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/usage?resolution=minute&group_by=client", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var resp struct {
+		Usage UsageQueryResult `json:"usage"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, UsageCounters{Requests: 1, InputTokens: 30, OutputTokens: 12, Credit: 1}, resp.Usage.Totals, "只统计代理端点")
+	assert.Equal(t, []string{"test"}, resp.Usage.Groups)
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/usage?since=yesterday", nil))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
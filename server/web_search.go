@@ -0,0 +1,521 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"kiro2api/config"
+	"kiro2api/converter"
+	"kiro2api/logger"
+	"kiro2api/parser"
+	"kiro2api/tracing"
+	"kiro2api/types"
+	"kiro2api/utils"
+	"kiro2api/websearch"
+
+	"github.com/gin-gonic/gin"
+)
+
+// web_search 服务端工具模拟
+// 上游不支持 web_search：配置搜索后端（WEB_SEARCH_BACKEND）后，请求中的 web_search 工具被替换为普通工具发送给上游，
+// 模型调用该工具时由代理执行搜索，把结果作为工具结果回填后继续请求，直到模型不再搜索。
+// 最终响应前插入 Anthropic 格式的 server_tool_use 和 web_search_tool_result 块（每轮都需要完整读取上游响应）
+
+const (
+	// webSearchUpstreamTool 发送给上游的搜索工具名（web_search/websearch 会被转换器过滤）
+	webSearchUpstreamTool = "internet_search"
+	// webSearchRoundsKey gin 上下文中保存已执行搜索的键
+	webSearchRoundsKey = "web_search_rounds"
+	// maxWebSearchQueryLength 搜索关键词的最大字符数
+	maxWebSearchQueryLength = 500
+)
+
+// web_search_tool_result_error 的错误码（与 Anthropic API 一致）
+const (
+	webSearchErrorInvalidInput = "invalid_input"
+	webSearchErrorMaxUses      = "max_uses_exceeded"
+	webSearchErrorQueryTooLong = "query_too_long"
+	webSearchErrorUnavailable  = "unavailable"
+)
+
+// 响应中的 web_search 内容块类型
+const (
+	webSearchServerToolUseBlock = "server_tool_use"
+	webSearchToolResultBlock    = "web_search_tool_result"
+)
+
+// webSearchCall 模型发起的一次搜索调用
+type webSearchCall struct {
+	ID    string
+	Query string
+}
+
+// webSearchRound 代理执行的一次搜索及其之前的模型文本
+type webSearchRound struct {
+	Text      string
+	ID        string
+	Name      string
+	Query     string
+	Results   []websearch.Result
+	ErrorCode string
+	// Searched 是否实际请求了搜索后端（计入 web_search_requests）
+	Searched bool
+}
+
+// isWebSearchTool 判断是否为 web_search 服务端工具（web_search_20250305 等版本或同名工具）
+func isWebSearchTool(tool types.AnthropicTool) bool {
+	return tool.Name == "web_search" || tool.Name == "websearch" || strings.HasPrefix(tool.Type, "web_search")
+}
+
+// findWebSearchTool 返回请求中的 web_search 工具，未配置搜索后端或请求中没有该工具时返回 nil
+func findWebSearchTool(anthropicReq types.AnthropicRequest) *types.AnthropicTool {
+	if !config.GetWebSearchOptions().Enabled() {
+		return nil
+	}
+	for i := range anthropicReq.Tools {
+		if isWebSearchTool(anthropicReq.Tools[i]) {
+			return &anthropicReq.Tools[i]
+		}
+	}
+	return nil
+}
+
+// webSearchMaxUses 单次请求允许的最大搜索次数（工具的 max_uses 不能超过全局配置）
+func webSearchMaxUses(ws *types.AnthropicTool) int {
+	maxUses := config.GetWebSearchOptions().MaxUses
+	if ws.MaxUses > 0 && ws.MaxUses < maxUses {
+		maxUses = ws.MaxUses
+	}
+	return maxUses
+}
+
+// withUpstreamWebSearchTool 将 web_search 工具替换为发送给上游的普通搜索工具
+func withUpstreamWebSearchTool(anthropicReq types.AnthropicRequest, ws *types.AnthropicTool) types.AnthropicRequest {
+	description := "Search the web for up-to-date information. Returns the title, URL and a snippet of each matching page."
+	if len(ws.AllowedDomains) > 0 {
+		description += " Only results from these domains are returned: " + strings.Join(ws.AllowedDomains, ", ") + "."
+	}
+	if len(ws.BlockedDomains) > 0 {
+		description += " Results from these domains are never returned: " + strings.Join(ws.BlockedDomains, ", ") + "."
+	}
+
+	tools := make([]types.AnthropicTool, 0, len(anthropicReq.Tools))
+	for _, tool := range anthropicReq.Tools {
+		if !isWebSearchTool(tool) {
+			tools = append(tools, tool)
+		}
+	}
+	tools = append(tools, types.AnthropicTool{
+		Name:        webSearchUpstreamTool,
+		Description: description,
+		InputSchema: map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"query": map[string]any{
+					"type":        "string",
+					"description": "The search query",
+				},
+			},
+			"required": []any{"query"},
+		},
+	})
+	anthropicReq.Tools = tools
+
+	if tc := converter.ResolveToolChoice(anthropicReq.ToolChoice); tc != nil && tc.Type == "tool" && tc.Name == ws.Name {
+		anthropicReq.ToolChoice = &types.ToolChoice{Type: "tool", Name: webSearchUpstreamTool}
+	}
+	return anthropicReq
+}
+
+// executeWithToolChoice 发送请求，tool_choice 强制调用工具时按需重试
+func executeWithToolChoice(c *gin.Context, anthropicReq types.AnthropicRequest, tokenInfo types.TokenInfo, isStream bool) (*http.Response, error) {
+	if tc := converter.ForcedToolChoice(anthropicReq); tc != nil {
+		return executeWithRequiredTool(c, anthropicReq, tc, tokenInfo, isStream)
+	}
+	return sendCodeWhispererRequest(c, anthropicReq, tokenInfo, isStream)
+}
+
+// withoutUpstreamWebSearchTool 移除发送给上游的搜索工具（搜索次数用尽后的最后一轮），其他工具保持不变
+func withoutUpstreamWebSearchTool(anthropicReq types.AnthropicRequest) types.AnthropicRequest {
+	tools := make([]types.AnthropicTool, 0, len(anthropicReq.Tools))
+	for _, tool := range anthropicReq.Tools {
+		if tool.Name != webSearchUpstreamTool {
+			tools = append(tools, tool)
+		}
+	}
+	anthropicReq.Tools = tools
+	if tc := converter.ResolveToolChoice(anthropicReq.ToolChoice); tc != nil && tc.Name == webSearchUpstreamTool {
+		anthropicReq.ToolChoice = nil
+	}
+	return anthropicReq
+}
+
+// executeWithWebSearch 执行带 web_search 工具的请求
+// 每轮完整读取上游响应：模型调用了搜索工具时执行搜索并回填结果后继续请求，否则返回该响应。
+// 搜索次数用尽（或达到 max_uses 轮）后的最后一轮不再提供搜索工具，因此单次请求最多 max_uses+1 轮上游请求
+// （第一轮 tool_choice 强制调用工具时另有有限次数的重试）。
+// 已执行的搜索保存在 gin 上下文中，由响应处理器在最终回复前下发
+func executeWithWebSearch(c *gin.Context, anthropicReq types.AnthropicRequest, ws *types.AnthropicTool, tokenInfo types.TokenInfo, isStream bool) (*http.Response, error) {
+	maxUses := webSearchMaxUses(ws)
+	backend, backendErr := websearch.Current()
+	if backendErr != nil {
+		logger.Warn("搜索后端不可用", addReqFields(c, logger.Err(backendErr))...)
+	}
+
+	attemptReq := withUpstreamWebSearchTool(anthropicReq, ws)
+	var rounds []webSearchRound
+	searches := 0
+	for iteration := 0; ; iteration++ {
+		final := searches >= maxUses || iteration >= maxUses
+		if final {
+			attemptReq = withoutUpstreamWebSearchTool(attemptReq)
+		}
+		resp, err := executeWithToolChoice(c, attemptReq, tokenInfo, isStream)
+		if err != nil {
+			return nil, err
+		}
+		body, err := utils.ReadHTTPResponse(resp.Body)
+		_ = resp.Body.Close()
+		if err != nil {
+			handleResponseReadError(c, err)
+			return nil, err
+		}
+		resp.Body = io.NopCloser(bytes.NewReader(body))
+
+		text, calls := inspectWebSearchResponse(body)
+		if len(calls) == 0 || final {
+			if len(calls) > 0 {
+				logger.Warn("搜索次数已用尽，模型仍调用搜索工具",
+					addReqFields(c, logger.Int("rounds", iteration+1), logger.Int("max_uses", maxUses))...)
+			}
+			c.Set(webSearchRoundsKey, rounds)
+			return resp, nil
+		}
+
+		newRounds := make([]webSearchRound, 0, len(calls))
+		for i, call := range calls {
+			round := webSearchRound{ID: "srvtoolu_" + strings.ReplaceAll(utils.GenerateUUID(), "-", ""), Name: ws.Name, Query: call.Query}
+			if i == 0 {
+				round.Text = text
+			}
+			switch {
+			case strings.TrimSpace(call.Query) == "":
+				round.ErrorCode = webSearchErrorInvalidInput
+			case utf8.RuneCountInString(call.Query) > maxWebSearchQueryLength:
+				round.ErrorCode = webSearchErrorQueryTooLong
+			case searches >= maxUses:
+				round.ErrorCode = webSearchErrorMaxUses
+			case backendErr != nil:
+				round.ErrorCode = webSearchErrorUnavailable
+			default:
+				searches++
+				round.Searched = true
+				results, err := runWebSearch(c, backend, ws, call.Query)
+				if err != nil {
+					logger.Warn("网络搜索失败",
+						addReqFields(c, logger.String("backend", backend.Name()), logger.String("query", call.Query), logger.Err(err))...)
+					round.ErrorCode = webSearchErrorUnavailable
+				}
+				round.Results = results
+			}
+			newRounds = append(newRounds, round)
+		}
+		rounds = append(rounds, newRounds...)
+
+		logger.Info("已执行网络搜索，回填结果后继续请求",
+			addReqFields(c, logger.Int("searches", searches), logger.Int("round", iteration+1))...)
+		attemptReq = withWebSearchResults(attemptReq, text, calls, newRounds)
+		if converter.ForcedToolChoice(attemptReq) != nil {
+			// 强制调用只约束第一轮，否则模型无法给出最终回复
+			attemptReq.ToolChoice = nil
+		}
+	}
+}
+
+// runWebSearch 使用搜索后端执行一次搜索
+func runWebSearch(c *gin.Context, backend websearch.Backend, ws *types.AnthropicTool, query string) ([]websearch.Result, error) {
+	opts := config.GetWebSearchOptions()
+	ctx, cancel := context.WithTimeout(c.Request.Context(), opts.Timeout)
+	defer cancel()
+
+	_, span := startSpan(c, "web_search", tracing.SpanKindClient,
+		tracing.String("kiro.web_search.backend", backend.Name()))
+	results, err := websearch.Search(ctx, backend, websearch.Query{
+		Query:          query,
+		MaxResults:     opts.MaxResults,
+		AllowedDomains: ws.AllowedDomains,
+		BlockedDomains: ws.BlockedDomains,
+	})
+	span.SetAttributes(tracing.Int("kiro.web_search.results", len(results)))
+	span.RecordError(err)
+	span.End()
+	return results, err
+}
+
+// inspectWebSearchResponse 解析上游响应，返回回复文本和其中的搜索工具调用（按出现顺序）
+func inspectWebSearchResponse(body []byte) (string, []webSearchCall) {
+	result, err := parser.NewCompliantEventStreamParser().ParseResponse(body)
+	if err != nil || result == nil {
+		return "", nil
+	}
+	var tools []*parser.ToolExecution
+	for _, tool := range result.GetToolCalls() {
+		if tool.Name == webSearchUpstreamTool {
+			tools = append(tools, tool)
+		}
+	}
+	if len(tools) == 0 {
+		return "", nil
+	}
+	sort.Slice(tools, func(i, j int) bool { return tools[i].BlockIndex < tools[j].BlockIndex })
+
+	calls := make([]webSearchCall, 0, len(tools))
+	for _, tool := range tools {
+		query, _ := tool.Arguments["query"].(string)
+		calls = append(calls, webSearchCall{ID: tool.ID, Query: query})
+	}
+	return result.GetCompletionText(), calls
+}
+
+// withWebSearchResults 追加模型的搜索调用和搜索结果，构造下一轮请求
+// 同一轮中对其他工具的调用不回填（模型会在拿到搜索结果后重新决定）
+func withWebSearchResults(anthropicReq types.AnthropicRequest, text string, calls []webSearchCall, rounds []webSearchRound) types.AnthropicRequest {
+	assistant := make([]any, 0, len(calls)+1)
+	if strings.TrimSpace(text) != "" {
+		assistant = append(assistant, map[string]any{"type": "text", "text": text})
+	}
+	results := make([]any, 0, len(calls))
+	for i, call := range calls {
+		assistant = append(assistant, map[string]any{
+			"type":  "tool_use",
+			"id":    call.ID,
+			"name":  webSearchUpstreamTool,
+			"input": map[string]any{"query": call.Query},
+		})
+		results = append(results, map[string]any{
+			"type":        "tool_result",
+			"tool_use_id": call.ID,
+			"content":     webSearchResultText(rounds[i]),
+			"is_error":    rounds[i].ErrorCode != "",
+		})
+	}
+
+	messages := make([]types.AnthropicRequestMessage, 0, len(anthropicReq.Messages)+2)
+	messages = append(messages, anthropicReq.Messages...)
+	messages = append(messages,
+		types.AnthropicRequestMessage{Role: "assistant", Content: assistant},
+		types.AnthropicRequestMessage{Role: "user", Content: results},
+	)
+	anthropicReq.Messages = messages
+	return anthropicReq
+}
+
+// webSearchResultText 回填给模型的搜索结果文本
+func webSearchResultText(round webSearchRound) string {
+	if round.ErrorCode != "" {
+		return "Web search failed: " + round.ErrorCode
+	}
+	return websearch.FormatResults(round.Query, round.Results)
+}
+
+// webSearchRoundsFromContext 读取本次请求已执行的搜索
+func webSearchRoundsFromContext(c *gin.Context) []webSearchRound {
+	if v, ok := c.Get(webSearchRoundsKey); ok {
+		if rounds, ok := v.([]webSearchRound); ok {
+			return rounds
+		}
+	}
+	return nil
+}
+
+// webSearchRequestCount 实际执行的搜索次数（usage.server_tool_use.web_search_requests）
+func webSearchRequestCount(c *gin.Context) int {
+	count := 0
+	for _, round := range webSearchRoundsFromContext(c) {
+		if round.Searched {
+			count++
+		}
+	}
+	return count
+}
+
+// webSearchContentBlocks 已执行的搜索对应的响应内容块，位于最终回复之前
+// 每次搜索依次为：模型文本（开启扩展思考时拆分出思考块）、server_tool_use、web_search_tool_result
+func webSearchContentBlocks(c *gin.Context, thinkingEnabled bool) []map[string]any {
+	var blocks []map[string]any
+	for _, round := range webSearchRoundsFromContext(c) {
+		text := round.Text
+		if thinkingEnabled {
+			var thinkingText string
+			thinkingText, text = splitThinkingText(text)
+			if thinkingText != "" {
+				blocks = append(blocks, map[string]any{
+					"type":      "thinking",
+					"thinking":  thinkingText,
+					"signature": newThinkingSignature(),
+				})
+			}
+		}
+		if strings.TrimSpace(text) != "" {
+			blocks = append(blocks, map[string]any{"type": "text", "text": text})
+		}
+
+		blocks = append(blocks, map[string]any{
+			"type":  webSearchServerToolUseBlock,
+			"id":    round.ID,
+			"name":  round.Name,
+			"input": map[string]any{"query": round.Query},
+		})
+
+		var content any
+		if round.ErrorCode != "" {
+			content = map[string]any{"type": "web_search_tool_result_error", "error_code": round.ErrorCode}
+		} else {
+			items := make([]any, 0, len(round.Results))
+			for _, r := range round.Results {
+				item := map[string]any{
+					"type":              "web_search_result",
+					"title":             r.Title,
+					"url":               r.URL,
+					"encrypted_content": websearch.EncodeContent(r.Content),
+				}
+				if r.PageAge != "" {
+					item["page_age"] = r.PageAge
+				}
+				items = append(items, item)
+			}
+			content = items
+		}
+		blocks = append(blocks, map[string]any{
+			"type":        webSearchToolResultBlock,
+			"tool_use_id": round.ID,
+			"content":     content,
+		})
+	}
+	return blocks
+}
+
+// webSearchBlockEvents 将搜索调用和搜索结果块转换为 content_block_start/delta/stop 事件
+// 模型文本和思考块经 sendWebSearchBlocks 按增量下发，不在此处转换
+func webSearchBlockEvents(index int, block map[string]any) []map[string]any {
+	start := map[string]any{"type": "content_block_start", "index": index}
+	var delta map[string]any
+	switch block["type"] {
+	case webSearchServerToolUseBlock:
+		start["content_block"] = map[string]any{"type": webSearchServerToolUseBlock, "id": block["id"], "name": block["name"], "input": map[string]any{}}
+		inputJSON, _ := utils.SafeMarshal(block["input"])
+		delta = map[string]any{"type": "input_json_delta", "partial_json": string(inputJSON)}
+	default:
+		start["content_block"] = block
+	}
+
+	events := []map[string]any{start}
+	if delta != nil {
+		events = append(events, map[string]any{"type": "content_block_delta", "index": index, "delta": delta})
+	}
+	return append(events, map[string]any{"type": "content_block_stop", "index": index})
+}
+
+// webSearchBlockTokens 估算内容块的输出 token（搜索结果块由服务端生成，不计入输出）
+func webSearchBlockTokens(estimator *utils.TokenEstimator, block map[string]any) int {
+	switch block["type"] {
+	case "text":
+		text, _ := block["text"].(string)
+		return estimator.EstimateTextTokens(text)
+	case "thinking":
+		thinking, _ := block["thinking"].(string)
+		return estimator.EstimateTextTokens(thinking)
+	case webSearchServerToolUseBlock:
+		name, _ := block["name"].(string)
+		input, _ := block["input"].(map[string]any)
+		return estimator.EstimateToolUseTokens(name, input)
+	}
+	return 0
+}
+
+// sendWebSearchBlocks 流式响应开始时下发已执行搜索对应的内容块，之后的上游内容块索引依次顺延
+// 模型文本与上游文本增量相同，经过停止序列匹配和 max_tokens 限制；匹配到停止序列或达到 max_tokens 时不再下发后续内容
+func (ctx *StreamProcessorContext) sendWebSearchBlocks() error {
+	blocks := webSearchContentBlocks(ctx.c, ctx.req.Thinking.IsEnabled())
+	if len(blocks) == 0 {
+		return nil
+	}
+	index := 0
+	for _, block := range blocks {
+		if ctx.stopped {
+			break
+		}
+		switch block["type"] {
+		case "text":
+			text, _ := block["text"].(string)
+			ctx.sendTextDelta(index, text)
+			ctx.flushPendingText()
+		case "thinking":
+			thinking, _ := block["thinking"].(string)
+			if thinking = ctx.fitOutputText(thinking); thinking != "" {
+				ctx.emitThinkingDelta(index, thinking)
+			}
+		case webSearchServerToolUseBlock:
+			tokens := webSearchBlockTokens(ctx.tokenEstimator, block)
+			if ctx.maxTokens > 0 && ctx.outputTokensSoFar()+tokens > ctx.maxTokens {
+				ctx.markMaxTokensReached()
+				continue
+			}
+			fallthrough
+		default:
+			for _, event := range webSearchBlockEvents(index, block) {
+				if err := ctx.sseStateManager.SendEvent(ctx.c, ctx.sender, event); err != nil {
+					logger.Error("网络搜索块发送失败", logger.Err(err))
+					return err
+				}
+			}
+			ctx.totalOutputTokens += webSearchBlockTokens(ctx.tokenEstimator, block)
+			index++
+			continue
+		}
+
+		// 文本和思考块按需由状态管理器启动，内容全部被截断时不占用索引
+		if state, ok := ctx.sseStateManager.GetActiveBlocks()[index]; ok && state.Started {
+			if !state.Stopped {
+				stopEvent := map[string]any{"type": "content_block_stop", "index": index}
+				if err := ctx.sseStateManager.SendEvent(ctx.c, ctx.sender, stopEvent); err != nil {
+					logger.Error("网络搜索块发送失败", logger.Err(err))
+					return err
+				}
+			}
+			index++
+		}
+	}
+	ctx.indexOffset = index
+	if ctx.thinking != nil {
+		ctx.thinking.nextIndex = index
+	}
+	ctx.c.Writer.Flush()
+	return nil
+}
+
+// cutWebSearchBlocksAtStopSequence 在已执行搜索的模型文本中匹配停止序列（非流式响应使用）
+// 匹配时截断该文本块并丢弃之后的内容块，返回截断后的内容块和匹配到的停止序列
+func cutWebSearchBlocksAtStopSequence(blocks []map[string]any, sequences []string) ([]map[string]any, string) {
+	for i, block := range blocks {
+		if block["type"] != "text" {
+			continue
+		}
+		text, _ := block["text"].(string)
+		cut, matched := cutAtStopSequence(text, sequences)
+		if matched == "" {
+			continue
+		}
+		result := blocks[:i]
+		if cut != "" {
+			result = append(result, map[string]any{"type": "text", "text": cut})
+		}
+		return result, matched
+	}
+	return blocks, ""
+}
@@ -0,0 +1,247 @@
+package server
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"kiro2api/parser"
+	"kiro2api/types"
+	"kiro2api/websearch"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testWebSearchRounds 一次成功搜索和一次超出次数的搜索
+func testWebSearchRounds() []webSearchRound {
+	return []webSearchRound{
+		{
+			Text:     "Let me search.",
+			ID:       "srvtoolu_1",
+			Name:     "web_search",
+			Query:    "go 1.23 release",
+			Results:  []websearch.Result{{Title: "Go 1.23 Release Notes", URL: "https://go.dev/doc/go1.23", Content: "Range over func.", PageAge: "2024-08-13"}},
+			Searched: true,
+		},
+		{ID: "srvtoolu_2", Name: "web_search", Query: "go 1.24 release", ErrorCode: webSearchErrorMaxUses},
+	}
+}
+
+func TestIsWebSearchTool(t *testing.T) {
+	assert.True(t, isWebSearchTool(types.AnthropicTool{Type: "web_search_20250305", Name: "search"}))
+	assert.True(t, isWebSearchTool(types.AnthropicTool{Name: "web_search"}))
+	assert.True(t, isWebSearchTool(types.AnthropicTool{Name: "websearch"}))
+	assert.False(t, isWebSearchTool(types.AnthropicTool{Name: "read"}))
+}
+
+func TestWithUpstreamWebSearchTool(t *testing.T) {
+	ws := types.AnthropicTool{Type: "web_search_20250305", Name: "web_search", MaxUses: 2, AllowedDomains: []string{"go.dev"}}
+	req := types.AnthropicRequest{
+		Tools:      []types.AnthropicTool{{Name: "read"}, ws},
+		ToolChoice: map[string]any{"type": "tool", "name": "web_search"},
+	}
+
+	upstream := withUpstreamWebSearchTool(req, &ws)
+	require.Len(t, upstream.Tools, 2)
+	assert.Equal(t, "read", upstream.Tools[0].Name)
+	assert.Equal(t, webSearchUpstreamTool, upstream.Tools[1].Name)
+	assert.Contains(t, upstream.Tools[1].Description, "go.dev")
+	assert.Equal(t, &types.ToolChoice{Type: "tool", Name: webSearchUpstreamTool}, upstream.ToolChoice)
+	assert.Len(t, req.Tools, 2)
+	assert.Equal(t, "web_search", req.Tools[1].Name) // 不修改原始请求
+}
+
+func TestWithWebSearchResults(t *testing.T) {
+	req := types.AnthropicRequest{Messages: []types.AnthropicRequestMessage{{Role: "user", Content: "What's new in Go?"}}}
+	rounds := testWebSearchRounds()
+	calls := []webSearchCall{{ID: "tooluse_a", Query: rounds[0].Query}, {ID: "tooluse_b", Query: rounds[1].Query}}
+
+	next := withWebSearchResults(req, "Let me search.", calls, rounds)
+	require.Len(t, next.Messages, 3)
+	assert.Len(t, req.Messages, 1)
+
+	assistant := next.Messages[1].Content.([]any)
+	require.Len(t, assistant, 3)
+	assert.Equal(t, "text", assistant[0].(map[string]any)["type"])
+	assert.Equal(t, "tooluse_b", assistant[2].(map[string]any)["id"])
+
+	results := next.Messages[2].Content.([]any)
+	require.Len(t, results, 2)
+	first := results[0].(map[string]any)
+	assert.Equal(t, "tooluse_a", first["tool_use_id"])
+	assert.Contains(t, first["content"], "https://go.dev/doc/go1.23")
+	assert.Equal(t, false, first["is_error"])
+	assert.Equal(t, "Web search failed: max_uses_exceeded", results[1].(map[string]any)["content"])
+	assert.Equal(t, true, results[1].(map[string]any)["is_error"])
+}
+
+func TestWebSearchContentBlocks(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	assert.Empty(t, webSearchContentBlocks(c, false))
+
+	c.Set(webSearchRoundsKey, testWebSearchRounds())
+	blocks := webSearchContentBlocks(c, false)
+	require.Len(t, blocks, 5)
+	assert.Equal(t, "text", blocks[0]["type"])
+	assert.Equal(t, map[string]any{"type": "server_tool_use", "id": "srvtoolu_1", "name": "web_search", "input": map[string]any{"query": "go 1.23 release"}}, blocks[1])
+	assert.Equal(t, "srvtoolu_1", blocks[2]["tool_use_id"])
+	result := blocks[2]["content"].([]any)[0].(map[string]any)
+	assert.Equal(t, "web_search_result", result["type"])
+	assert.Equal(t, "Range over func.", websearch.DecodeContent(result["encrypted_content"].(string)))
+	assert.Equal(t, map[string]any{"type": "web_search_tool_result_error", "error_code": "max_uses_exceeded"}, blocks[4]["content"])
+	assert.Equal(t, 1, webSearchRequestCount(c))
+}
+
+func TestStreamProcessor_EmitsWebSearchBlocks(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Set(webSearchRoundsKey, testWebSearchRounds()[:1])
+	sender := &recordingSender{}
+	ctx := NewStreamProcessorContext(c, types.AnthropicRequest{Model: "claude-sonnet-4-20250514"}, nil, sender, "msg_test", 10)
+	esp := NewEventStreamProcessor(ctx)
+
+	require.NoError(t, ctx.sendInitialEvents(createAnthropicStreamEvents))
+	for _, event := range []parser.SSEEvent{
+		textDeltaEvent("Go 1.23 adds range over func."),
+		{Data: map[string]any{"type": "content_block_stop", "index": 0}},
+	} {
+		require.NoError(t, esp.processEvent(event))
+	}
+	require.NoError(t, ctx.sendFinalEvents())
+
+	assert.Equal(t, []string{
+		"message_start",
+		"ping",
+		"content_block_start#0:text",
+		"content_block_delta#0:text_delta",
+		"content_block_stop#0",
+		"content_block_start#1:server_tool_use",
+		"content_block_delta#1:input_json_delta",
+		"content_block_stop#1",
+		"content_block_start#2:web_search_tool_result",
+		"content_block_stop#2",
+		"content_block_start#3:text",
+		"content_block_delta#3:text_delta",
+		"content_block_stop#3",
+		"message_delta",
+		"message_stop",
+	}, sseEventSequence(sender.events))
+
+	messageDelta := sender.events[len(sender.events)-2]
+	assert.Equal(t, "end_turn", messageDelta["delta"].(map[string]any)["stop_reason"])
+	assert.Equal(t, map[string]any{"web_search_requests": 1}, messageDelta["usage"].(map[string]any)["server_tool_use"])
+}
+
+// sseEventSequence 将事件转换为 "类型#索引:块类型" 形式，便于断言事件顺序
+func sseEventSequence(events []map[string]any) []string {
+	var sequence []string
+	for _, e := range events {
+		entry := e["type"].(string)
+		if idx, ok := e["index"].(int); ok {
+			entry += fmt.Sprintf("#%d", idx)
+		}
+		if cb, ok := e["content_block"].(map[string]any); ok {
+			entry += ":" + cb["type"].(string)
+		}
+		if delta, ok := e["delta"].(map[string]any); ok && delta["type"] != nil {
+			entry += ":" + delta["type"].(string)
+		}
+		sequence = append(sequence, entry)
+	}
+	return sequence
+}
+
+func TestStreamProcessor_WebSearchTextStopSequence(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	rounds := testWebSearchRounds()
+	rounds[0].Text = "Let me search. ###ignored"
+	c.Set(webSearchRoundsKey, rounds)
+	sender := &recordingSender{}
+	req := types.AnthropicRequest{Model: "claude-sonnet-4-20250514", StopSequences: []string{"###"}}
+	ctx := NewStreamProcessorContext(c, req, nil, sender, "msg_test", 10)
+
+	require.NoError(t, ctx.sendInitialEvents(createAnthropicStreamEvents))
+	assert.True(t, ctx.stopped)
+	require.NoError(t, ctx.sendFinalEvents())
+
+	assert.Equal(t, []string{
+		"message_start",
+		"ping",
+		"content_block_start#0:text",
+		"content_block_delta#0:text_delta",
+		"content_block_stop#0",
+		"message_delta",
+		"message_stop",
+	}, sseEventSequence(sender.events))
+	assert.Equal(t, "Let me search. ", sender.events[3]["delta"].(map[string]any)["text"])
+
+	delta := sender.events[5]["delta"].(map[string]any)
+	assert.Equal(t, "stop_sequence", delta["stop_reason"])
+	assert.Equal(t, "###", delta["stop_sequence"])
+}
+
+func TestStreamProcessor_WebSearchTextMaxTokens(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	rounds := testWebSearchRounds()
+	rounds[0].Text = strings.Repeat("search the web for the latest release notes ", 20)
+	c.Set(webSearchRoundsKey, rounds)
+	sender := &recordingSender{}
+	req := types.AnthropicRequest{Model: "claude-sonnet-4-20250514", MaxTokens: 5}
+	ctx := NewStreamProcessorContext(c, req, nil, sender, "msg_test", 10)
+
+	require.NoError(t, ctx.sendInitialEvents(createAnthropicStreamEvents))
+	assert.True(t, ctx.stopped)
+	require.NoError(t, ctx.sendFinalEvents())
+
+	sequence := sseEventSequence(sender.events)
+	assert.NotContains(t, sequence, "content_block_start#1:server_tool_use")
+	text := sender.events[3]["delta"].(map[string]any)["text"].(string)
+	assert.True(t, strings.HasPrefix(rounds[0].Text, text))
+	assert.Less(t, len(text), len(rounds[0].Text))
+
+	messageDelta := sender.events[len(sender.events)-2]
+	assert.Equal(t, "max_tokens", messageDelta["delta"].(map[string]any)["stop_reason"])
+	assert.LessOrEqual(t, messageDelta["usage"].(map[string]any)["output_tokens"], 5)
+}
+
+func TestCutWebSearchBlocksAtStopSequence(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Set(webSearchRoundsKey, testWebSearchRounds())
+	blocks := webSearchContentBlocks(c, false)
+
+	kept, matched := cutWebSearchBlocksAtStopSequence(blocks, []string{"nothing"})
+	assert.Empty(t, matched)
+	assert.Len(t, kept, 5)
+
+	kept, matched = cutWebSearchBlocksAtStopSequence(webSearchContentBlocks(c, false), []string{"search"})
+	assert.Equal(t, "search", matched)
+	assert.Equal(t, []map[string]any{{"type": "text", "text": "Let me "}}, kept)
+
+	kept, matched = cutWebSearchBlocksAtStopSequence(webSearchContentBlocks(c, false), []string{"Let"})
+	assert.Equal(t, "Let", matched)
+	assert.Empty(t, kept)
+}
+
+func TestWithoutUpstreamWebSearchTool(t *testing.T) {
+	ws := types.AnthropicTool{Type: "web_search_20250305", Name: "web_search"}
+	req := withUpstreamWebSearchTool(types.AnthropicRequest{
+		Tools:      []types.AnthropicTool{{Name: "read"}, ws},
+		ToolChoice: map[string]any{"type": "tool", "name": "web_search"},
+	}, &ws)
+
+	final := withoutUpstreamWebSearchTool(req)
+	require.Len(t, final.Tools, 1)
+	assert.Equal(t, "read", final.Tools[0].Name)
+	assert.Nil(t, final.ToolChoice)
+	assert.Len(t, req.Tools, 2) // 不修改原始请求
+
+	req.ToolChoice = map[string]any{"type": "any"}
+	assert.Equal(t, req.ToolChoice, withoutUpstreamWebSearchTool(req).ToolChoice)
+}
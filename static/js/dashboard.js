@@ -11,6 +11,11 @@ class TokenDashboard {
         this.pendingDeleteIndex = null;
         this.pendingDeleteClientTokenIndex = null;
         this.currentMainTab = 'auth-tokens';
+        this.inspectorSource = null;
+        this.inspectorRequests = new Map();
+        this.inspectorPaused = false;
+        this.inspectorTimer = null;
+        this.usageData = null;
 
         this.init();
     }
@@ -43,6 +48,12 @@ class TokenDashboard {
                 if (logoutBtn) {
                     logoutBtn.style.display = data.authenticated ? 'inline-block' : 'none';
                 }
+                ['totpBtn', 'adminKeyBtn'].forEach(id => {
+                    const btn = document.getElementById(id);
+                    if (btn) {
+                        btn.style.display = data.authenticated ? 'inline-block' : 'none';
+                    }
+                });
             }
         } catch (error) {
             // 会话检查失败，可能未启用登录系统
@@ -609,18 +620,62 @@ class TokenDashboard {
         document.querySelectorAll('.main-tab-btn').forEach((btn, index) => {
             btn.classList.toggle('active',
                 (tabName === 'auth-tokens' && index === 0) ||
-                (tabName === 'client-tokens' && index === 1)
+                (tabName === 'client-tokens' && index === 1) ||
+                (tabName === 'audit-log' && index === 2) ||
+                (tabName === 'sessions' && index === 3) ||
+                (tabName === 'inspector' && index === 4) ||
+                (tabName === 'usage' && index === 5) ||
+                (tabName === 'settings' && index === 6) ||
+                (tabName === 'debug-captures' && index === 7)
             );
         });
 
         // 更新面板显示
         document.getElementById('authTokensPanel').classList.toggle('active', tabName === 'auth-tokens');
         document.getElementById('clientTokensPanel').classList.toggle('active', tabName === 'client-tokens');
+        document.getElementById('auditLogPanel').classList.toggle('active', tabName === 'audit-log');
+        document.getElementById('sessionsPanel').classList.toggle('active', tabName === 'sessions');
+        document.getElementById('inspectorPanel').classList.toggle('active', tabName === 'inspector');
+        document.getElementById('usagePanel').classList.toggle('active', tabName === 'usage');
+        document.getElementById('settingsPanel').classList.toggle('active', tabName === 'settings');
+        document.getElementById('debugCapturesPanel').classList.toggle('active', tabName === 'debug-captures');
 
         // 切换到客户端令牌时自动刷新
         if (tabName === 'client-tokens') {
             this.refreshClientTokens();
         }
+
+        // 切换到审计日志时自动刷新
+        if (tabName === 'audit-log') {
+            this.refreshAuditLog();
+        }
+
+        // 切换到登录会话时自动刷新
+        if (tabName === 'sessions') {
+            this.refreshSessions();
+        }
+
+        // 切换到用量统计时自动刷新
+        if (tabName === 'usage') {
+            this.refreshUsage();
+        }
+
+        // 切换到运行设置时自动刷新
+        if (tabName === 'settings') {
+            this.refreshSettings();
+        }
+
+        // 切换到调试捕获时自动刷新
+        if (tabName === 'debug-captures') {
+            this.refreshDebugCaptures();
+        }
+
+        // 实时请求仅在面板可见时保持 SSE 连接
+        if (tabName === 'inspector') {
+            this.startInspectorStream();
+        } else {
+            this.stopInspectorStream();
+        }
     }
 
     // ==================== 客户端令牌管理 ====================
@@ -672,6 +727,7 @@ class TokenDashboard {
         const statusText = token.disabled ? '已禁用' : '正常';
         const toggleBtnClass = token.disabled ? 'btn-toggle disabled' : 'btn-toggle';
         const toggleBtnText = token.disabled ? '启用' : '禁用';
+        const debugBtnText = token.debugCapture ? '停止捕获' : '调试捕获';
 
         // 脱敏令牌显示
         const maskedToken = this.maskToken(token.token);
@@ -686,6 +742,7 @@ class TokenDashboard {
                 <td><span class="status-badge ${statusClass}">${statusText}</span></td>
                 <td>
                     <button class="${toggleBtnClass}" onclick="dashboard.toggleClientToken(${index})">${toggleBtnText}</button>
+                    <button class="btn-toggle" onclick="dashboard.setClientTokenDebugCapture(${index}, ${!token.debugCapture})">${debugBtnText}</button>
                     <button class="btn-delete-small" onclick="dashboard.showDeleteClientTokenConfirmModal(${index})">删除</button>
                 </td>
             </tr>
@@ -907,6 +964,1048 @@ class TokenDashboard {
             this.showToast('网络错误: ' + error.message, 'error');
         }
     }
+
+    /**
+     * 开启/关闭客户端令牌的调试捕获（开启后该令牌的每个请求都会保存捕获包）
+     */
+    async setClientTokenDebugCapture(index, enabled) {
+        try {
+            const response = await fetch(`${this.apiBaseUrl}/client-tokens/${index}/debug-capture`, {
+                method: 'POST',
+                headers: {
+                    'Content-Type': 'application/json',
+                    'X-CSRF-Token': this.getCsrfToken()
+                },
+                body: JSON.stringify({ enabled })
+            });
+
+            const result = await response.json();
+
+            if (result.success) {
+                this.refreshClientTokens();
+                this.showToast(enabled ? '已开启调试捕获' : '已关闭调试捕获');
+            } else {
+                this.showToast(result.message || '设置失败', 'error');
+            }
+        } catch (error) {
+            console.error('设置调试捕获失败:', error);
+            this.showToast('网络错误: ' + error.message, 'error');
+        }
+    }
+
+    // ==================== 审计日志 ====================
+
+    /**
+     * 刷新审计日志列表
+     */
+    async refreshAuditLog() {
+        const tbody = document.getElementById('auditTableBody');
+        this.showClientTokenLoading(tbody, '正在加载审计日志...');
+
+        const action = document.getElementById('auditActionFilter').value;
+        const params = new URLSearchParams({ limit: '200' });
+        if (action) {
+            params.set('action', action);
+        }
+
+        try {
+            const response = await fetch(`${this.apiBaseUrl}/audit?${params.toString()}`);
+            if (!response.ok) {
+                throw new Error(`HTTP ${response.status}: ${response.statusText}`);
+            }
+
+            const data = await response.json();
+            this.updateAuditTable(data);
+            this.updateElement('totalAuditEntries', data.total || 0);
+            this.updateElement('auditLastUpdate', new Date().toLocaleTimeString('zh-CN', { hour12: false }));
+
+        } catch (error) {
+            console.error('加载审计日志失败:', error);
+            this.showClientTokenError(tbody, `加载失败: ${error.message}`);
+        }
+    }
+
+    /**
+     * 更新审计日志表格
+     */
+    updateAuditTable(data) {
+        const tbody = document.getElementById('auditTableBody');
+
+        if (!data.entries || data.entries.length === 0) {
+            tbody.innerHTML = `
+                <tr>
+                    <td colspan="7" class="empty-state">
+                        <div class="empty-icon">📋</div>
+                        <p>暂无审计记录</p>
+                    </td>
+                </tr>
+            `;
+            return;
+        }
+
+        tbody.innerHTML = data.entries.map(entry => this.createAuditRow(entry)).join('');
+    }
+
+    /**
+     * 创建单个审计日志行
+     */
+    createAuditRow(entry) {
+        const statusClass = entry.success ? 'status-active' : 'status-error';
+        const statusText = entry.success ? '成功' : '失败';
+        const changes = (entry.changes || [])
+            .map(ch => `${this.escapeHtml(ch.field)}: ${this.escapeHtml(this.formatAuditValue(ch.before))} → ${this.escapeHtml(this.formatAuditValue(ch.after))}`)
+            .join('<br>');
+
+        return `
+            <tr>
+                <td>${this.formatDateTime(entry.timestamp)}</td>
+                <td>${this.escapeHtml(entry.user)}</td>
+                <td>${this.escapeHtml(entry.ip)}</td>
+                <td>${this.escapeHtml(entry.action)}</td>
+                <td>${this.escapeHtml(entry.entity)}</td>
+                <td class="audit-changes">${changes || '-'}</td>
+                <td><span class="status-badge ${statusClass}" title="${this.escapeHtml(entry.message || '')}">${statusText}</span></td>
+            </tr>
+        `;
+    }
+
+    /**
+     * 格式化审计变更值
+     */
+    formatAuditValue(value) {
+        if (value === undefined || value === null) {
+            return '-';
+        }
+        return typeof value === 'object' ? JSON.stringify(value) : String(value);
+    }
+
+    /**
+     * HTML 转义
+     */
+    escapeHtml(str) {
+        return String(str ?? '')
+            .replace(/&/g, '&amp;')
+            .replace(/</g, '&lt;')
+            .replace(/>/g, '&gt;')
+            .replace(/"/g, '&quot;');
+    }
+
+    /**
+     * 导出审计日志
+     */
+    exportAuditLog(format) {
+        const action = document.getElementById('auditActionFilter').value;
+        const params = new URLSearchParams({ format });
+        if (action) {
+            params.set('action', action);
+        }
+        window.location.href = `${this.apiBaseUrl}/audit/export?${params.toString()}`;
+    }
+
+    // ==================== 登录会话 ====================
+
+    /**
+     * 刷新登录会话列表
+     */
+    async refreshSessions() {
+        const tbody = document.getElementById('sessionTableBody');
+        this.showClientTokenLoading(tbody, '正在加载会话数据...');
+
+        try {
+            const response = await fetch(`${this.apiBaseUrl}/sessions`);
+            if (!response.ok) {
+                throw new Error(`HTTP ${response.status}: ${response.statusText}`);
+            }
+
+            const data = await response.json();
+            this.updateElement('totalSessions', data.total || 0);
+
+            if (!data.sessions || data.sessions.length === 0) {
+                tbody.innerHTML = `
+                    <tr>
+                        <td colspan="6" class="empty-state">
+                            <p>暂无有效会话</p>
+                        </td>
+                    </tr>
+                `;
+                return;
+            }
+            tbody.innerHTML = data.sessions.map(session => this.createSessionRow(session)).join('');
+
+        } catch (error) {
+            console.error('加载会话数据失败:', error);
+            this.showClientTokenError(tbody, `加载失败: ${error.message}`);
+        }
+    }
+
+    /**
+     * 创建单个会话行
+     */
+    createSessionRow(session) {
+        const action = session.current
+            ? '<span class="status-badge status-active">当前会话</span>'
+            : `<button class="btn-delete-small" onclick="dashboard.revokeSession('${this.escapeHtml(session.key)}')">吊销</button>`;
+
+        return `
+            <tr>
+                <td>${this.escapeHtml(session.user)}</td>
+                <td>${this.escapeHtml(session.ip || '-')}</td>
+                <td title="${this.escapeHtml(session.userAgent || '')}">${this.escapeHtml((session.userAgent || '-').substring(0, 40))}</td>
+                <td>${this.formatDateTime(session.createdAt)}</td>
+                <td>${this.formatDateTime(session.lastSeen)}</td>
+                <td>${action}</td>
+            </tr>
+        `;
+    }
+
+    /**
+     * 吊销登录会话
+     */
+    async revokeSession(key) {
+        try {
+            const response = await fetch(`${this.apiBaseUrl}/sessions/${encodeURIComponent(key)}`, {
+                method: 'DELETE',
+                headers: {
+                    'X-CSRF-Token': this.getCsrfToken()
+                }
+            });
+
+            const result = await response.json();
+
+            if (result.success) {
+                this.refreshSessions();
+                this.showToast('会话已吊销');
+            } else {
+                this.showToast(result.error || '吊销失败', 'error');
+            }
+        } catch (error) {
+            console.error('吊销会话失败:', error);
+            this.showToast('网络错误: ' + error.message, 'error');
+        }
+    }
+
+    // ==================== 实时请求检查器 ====================
+
+    /**
+     * 建立 SSE 连接，接收请求变更
+     */
+    startInspectorStream() {
+        if (this.inspectorSource) {
+            return;
+        }
+        const source = new EventSource(`${this.apiBaseUrl}/inspector/stream`);
+        this.inspectorSource = source;
+        this.updateElement('inspectorConnection', '连接中');
+
+        source.addEventListener('snapshot', event => {
+            this.inspectorRequests.clear();
+            JSON.parse(event.data).forEach(req => this.inspectorRequests.set(req.id, req));
+            this.updateElement('inspectorConnection', '已连接');
+            this.renderInspectorTable();
+        });
+        source.addEventListener('request', event => {
+            const req = JSON.parse(event.data);
+            this.inspectorRequests.set(req.id, req);
+            if (!this.inspectorPaused) {
+                this.renderInspectorTable();
+            }
+        });
+        source.onerror = () => {
+            // EventSource 会自动重连
+            this.updateElement('inspectorConnection', '重连中');
+        };
+
+        // 进行中请求的耗时每秒刷新
+        this.inspectorTimer = setInterval(() => {
+            if (!this.inspectorPaused) {
+                this.renderInspectorTable();
+            }
+        }, 1000);
+    }
+
+    /**
+     * 关闭 SSE 连接
+     */
+    stopInspectorStream() {
+        if (this.inspectorSource) {
+            this.inspectorSource.close();
+            this.inspectorSource = null;
+        }
+        if (this.inspectorTimer) {
+            clearInterval(this.inspectorTimer);
+            this.inspectorTimer = null;
+        }
+    }
+
+    /**
+     * 重新建立连接（获取最新快照）
+     */
+    refreshInspector() {
+        this.stopInspectorStream();
+        this.startInspectorStream();
+    }
+
+    /**
+     * 暂停/恢复列表刷新（暂停期间仍接收数据）
+     */
+    toggleInspectorPause() {
+        this.inspectorPaused = !this.inspectorPaused;
+        document.getElementById('inspectorPauseBtn').textContent = this.inspectorPaused ? '继续' : '暂停';
+        if (!this.inspectorPaused) {
+            this.renderInspectorTable();
+        }
+    }
+
+    /**
+     * 渲染请求列表（按开始时间倒序）
+     */
+    renderInspectorTable() {
+        const tbody = document.getElementById('inspectorTableBody');
+        const requests = Array.from(this.inspectorRequests.values())
+            .sort((a, b) => new Date(b.startedAt) - new Date(a.startedAt));
+
+        this.updateElement('inspectorInFlight', requests.filter(r => r.state === 'in_flight').length);
+        this.updateElement('inspectorTotal', requests.length);
+
+        if (requests.length === 0) {
+            tbody.innerHTML = `
+                <tr>
+                    <td colspan="8" class="empty-state">
+                        <p>暂无请求记录</p>
+                    </td>
+                </tr>
+            `;
+            return;
+        }
+        tbody.innerHTML = requests.map(req => this.createInspectorRow(req)).join('');
+    }
+
+    /**
+     * 创建单个请求行
+     */
+    createInspectorRow(req) {
+        const elapsed = req.state === 'in_flight'
+            ? Date.now() - new Date(req.startedAt).getTime()
+            : req.elapsedMs;
+        const stateClass = {
+            in_flight: 'status-pending',
+            completed: 'status-active',
+            failed: 'status-expired'
+        }[req.state] || '';
+        const stateText = {
+            in_flight: '进行中',
+            completed: `${req.statusCode} ${req.stopReason || ''}`,
+            failed: `${req.statusCode || ''} 失败`
+        }[req.state] || req.state;
+
+        return `
+            <tr>
+                <td>${this.formatDateTime(req.startedAt)}</td>
+                <td>${this.escapeHtml(req.client || '-')}</td>
+                <td>${this.escapeHtml(req.model || '-')}${req.stream ? ' <small>(流式)</small>' : ''}</td>
+                <td><span class="token-preview">${this.escapeHtml(req.account || '-')}</span></td>
+                <td>${(elapsed / 1000).toFixed(1)}s</td>
+                <td>${req.inputTokens || 0} / ${req.outputTokens || 0}</td>
+                <td><span class="status-badge ${stateClass}" title="${this.escapeHtml(req.error || '')}">${this.escapeHtml(stateText)}</span></td>
+                <td><button class="btn-toggle" onclick="dashboard.showInspectorDetail('${this.escapeHtml(req.id)}')">详情</button></td>
+            </tr>
+        `;
+    }
+
+    /**
+     * 显示请求详情（上游请求和转发事件）
+     */
+    async showInspectorDetail(id) {
+        try {
+            const response = await fetch(`${this.apiBaseUrl}/inspector/requests/${encodeURIComponent(id)}`);
+            const result = await response.json();
+            if (!result.success) {
+                this.showToast(result.error || '加载失败', 'error');
+                return;
+            }
+
+            const req = result.request;
+            const summary = `${req.method} ${req.path} · ${req.model || '-'} · ${req.client || '-'} · ${req.eventCount} 个事件`;
+            document.getElementById('inspectorDetailSummary').textContent = req.error ? `${summary} · 错误: ${req.error}` : summary;
+            document.getElementById('inspectorDetailRequest').textContent = this.formatPayload(req.upstreamRequest) || '(无)';
+
+            const events = (req.events || []).map(e => `+${e.offsetMs}ms ${e.type}\n${e.data}`).join('\n\n');
+            document.getElementById('inspectorDetailEvents').textContent = events || '(无)';
+            document.getElementById('inspectorDetailEventsLabel').textContent = req.eventsDropped
+                ? `转发事件（超出上限，另有 ${req.eventsDropped} 个未记录）`
+                : '转发事件';
+
+            document.getElementById('inspectorDetailModal').style.display = 'flex';
+        } catch (error) {
+            console.error('加载请求详情失败:', error);
+            this.showToast('网络错误: ' + error.message, 'error');
+        }
+    }
+
+    /**
+     * 隐藏请求详情
+     */
+    hideInspectorDetail() {
+        document.getElementById('inspectorDetailModal').style.display = 'none';
+    }
+
+    /**
+     * 尝试格式化 JSON 负载（截断后的负载原样显示）
+     */
+    formatPayload(payload) {
+        if (!payload) {
+            return '';
+        }
+        try {
+            return JSON.stringify(JSON.parse(payload), null, 2);
+        } catch (e) {
+            return payload;
+        }
+    }
+
+    // ==================== 用量统计 ====================
+
+    /**
+     * 刷新用量时间序列和耗尽预测
+     */
+    async refreshUsage() {
+        const resolution = document.getElementById('usageResolution').value;
+        const groupBy = document.getElementById('usageGroupBy').value;
+
+        try {
+            const [usageResp, forecastResp] = await Promise.all([
+                fetch(`${this.apiBaseUrl}/usage?resolution=${resolution}&group_by=${groupBy}`),
+                fetch(`${this.apiBaseUrl}/usage/forecast`)
+            ]);
+            if (!usageResp.ok) {
+                throw new Error(`HTTP ${usageResp.status}: ${usageResp.statusText}`);
+            }
+
+            this.usageData = (await usageResp.json()).usage;
+            this.renderUsageChart();
+
+            if (forecastResp.ok) {
+                this.updateUsageForecast((await forecastResp.json()).forecast);
+            }
+        } catch (error) {
+            console.error('加载用量统计失败:', error);
+            this.showToast(`加载用量统计失败: ${error.message}`, 'error');
+        }
+    }
+
+    /**
+     * 更新额度耗尽预测
+     */
+    updateUsageForecast(forecast) {
+        this.updateElement('usageRemainingCredit',
+            `${forecast.remainingCredit.toFixed(1)} (${forecast.usableAccounts} 个账号)`);
+        this.updateElement('usageBurnRate', forecast.burnRatePerHour > 0
+            ? `${forecast.burnRatePerHour.toFixed(1)} /小时 (近${forecast.rateWindow})`
+            : '-');
+        this.updateElement('usageExhaustAt', forecast.exhaustAt
+            ? `${this.formatDateTime(forecast.exhaustAt)} (约 ${forecast.hoursRemaining.toFixed(1)} 小时)`
+            : '暂无消耗');
+    }
+
+    /**
+     * 取单个计数器中选中指标的值
+     */
+    usageMetricValue(counters, metric) {
+        if (!counters) {
+            return 0;
+        }
+        switch (metric) {
+            case 'tokens':
+                return counters.inputTokens + counters.outputTokens;
+            case 'errors':
+                return counters.errors;
+            case 'credit':
+                return counters.credit;
+            default:
+                return counters.requests;
+        }
+    }
+
+    /**
+     * 绘制堆叠柱状图（分组最多显示6个，其余合并为"其他"）
+     */
+    renderUsageChart() {
+        const data = this.usageData;
+        const canvas = document.getElementById('usageChart');
+        if (!data || !canvas) {
+            return;
+        }
+
+        const metric = document.getElementById('usageMetric').value;
+        const colors = ['#4caf50', '#2196f3', '#ff9800', '#9c27b0', '#f44336', '#00bcd4', '#9e9e9e'];
+        const topGroups = (data.groups || []).slice(0, 6);
+        const hasOther = (data.groups || []).length > topGroups.length;
+        const series = data.groupBy === 'none' ? ['总计'] : topGroups.concat(hasOther ? ['其他'] : []);
+
+        // 每个时间点按分组拆分数值
+        const stacks = data.points.map(point => {
+            if (data.groupBy === 'none') {
+                return [this.usageMetricValue(point.total, metric)];
+            }
+            const values = topGroups.map(g => this.usageMetricValue((point.groups || {})[g], metric));
+            if (hasOther) {
+                const shown = values.reduce((a, b) => a + b, 0);
+                values.push(this.usageMetricValue(point.total, metric) - shown);
+            }
+            return values;
+        });
+
+        const totals = data.totals;
+        this.updateElement('usageTotals',
+            `${totals.requests} 请求 / ${totals.inputTokens + totals.outputTokens} Token / ${totals.errors} 错误`);
+
+        // 按设备像素比绘制，避免模糊
+        const ratio = window.devicePixelRatio || 1;
+        const width = canvas.clientWidth;
+        const height = canvas.clientHeight;
+        canvas.width = width * ratio;
+        canvas.height = height * ratio;
+        const ctx = canvas.getContext('2d');
+        ctx.scale(ratio, ratio);
+        ctx.clearRect(0, 0, width, height);
+
+        const padding = { left: 50, right: 10, top: 10, bottom: 30 };
+        const chartWidth = width - padding.left - padding.right;
+        const chartHeight = height - padding.top - padding.bottom;
+        const max = Math.max(1, ...stacks.map(values => values.reduce((a, b) => a + b, 0)));
+
+        // 坐标轴刻度
+        ctx.fillStyle = '#ffffff';
+        ctx.strokeStyle = 'rgba(255,255,255,0.2)';
+        ctx.font = '11px sans-serif';
+        ctx.textAlign = 'right';
+        for (let i = 0; i <= 4; i++) {
+            const y = padding.top + chartHeight - (chartHeight * i) / 4;
+            const label = (max * i) / 4;
+            ctx.fillText(metric === 'credit' ? label.toFixed(1) : Math.round(label), padding.left - 6, y + 4);
+            ctx.beginPath();
+            ctx.moveTo(padding.left, y);
+            ctx.lineTo(width - padding.right, y);
+            ctx.stroke();
+        }
+
+        // 柱子
+        const slot = chartWidth / Math.max(1, stacks.length);
+        const barWidth = Math.max(1, slot * 0.7);
+        stacks.forEach((values, i) => {
+            let y = padding.top + chartHeight;
+            values.forEach((value, j) => {
+                const h = (value / max) * chartHeight;
+                ctx.fillStyle = colors[j % colors.length];
+                ctx.fillRect(padding.left + i * slot + (slot - barWidth) / 2, y - h, barWidth, h);
+                y -= h;
+            });
+        });
+
+        // 时间标签（最多约8个）
+        ctx.fillStyle = '#ffffff';
+        ctx.textAlign = 'center';
+        const step = Math.max(1, Math.ceil(data.points.length / 8));
+        data.points.forEach((point, i) => {
+            if (i % step === 0) {
+                ctx.fillText(this.formatUsageTime(point.start, data.resolution),
+                    padding.left + i * slot + slot / 2, height - 10);
+            }
+        });
+
+        document.getElementById('usageLegend').innerHTML = series.map((name, j) => `
+            <span class="usage-legend-item">
+                <span class="usage-legend-color" style="background: ${colors[j % colors.length]}"></span>
+                ${this.escapeHtml(name)}
+            </span>
+        `).join('');
+    }
+
+    /**
+     * 格式化图表时间标签
+     */
+    formatUsageTime(dateStr, resolution) {
+        const date = new Date(dateStr);
+        const pad = n => String(n).padStart(2, '0');
+        if (resolution === 'day') {
+            return `${pad(date.getMonth() + 1)}-${pad(date.getDate())}`;
+        }
+        return `${pad(date.getHours())}:${pad(date.getMinutes())}`;
+    }
+
+    // ==================== 运行设置 ====================
+
+    /**
+     * 刷新运行时设置列表
+     */
+    async refreshSettings() {
+        const tbody = document.getElementById('settingsTableBody');
+        this.showClientTokenLoading(tbody, '正在加载设置...');
+
+        try {
+            const response = await fetch(`${this.apiBaseUrl}/settings`);
+            if (!response.ok) {
+                throw new Error(`HTTP ${response.status}: ${response.statusText}`);
+            }
+
+            const data = await response.json();
+            tbody.innerHTML = data.settings.map(setting => this.createSettingRow(setting)).join('');
+        } catch (error) {
+            console.error('加载运行设置失败:', error);
+            this.showClientTokenError(tbody, `加载失败: ${error.message}`);
+        }
+    }
+
+    /**
+     * 创建单个设置行（枚举用下拉框，其余用输入框）
+     */
+    createSettingRow(setting) {
+        const id = `setting-${setting.key}`;
+        let input;
+        if (setting.type === 'enum') {
+            input = `<select id="${id}">${setting.options.map(opt =>
+                `<option value="${this.escapeHtml(opt)}" ${opt === setting.value ? 'selected' : ''}>${this.escapeHtml(opt)}</option>`
+            ).join('')}</select>`;
+        } else {
+            const range = setting.min !== undefined ? `${setting.min} ~ ${setting.max}` : '';
+            input = `<input id="${id}" type="${setting.type === 'int' ? 'number' : 'text'}"
+                value="${this.escapeHtml(String(setting.value))}" title="${this.escapeHtml(range)}">`;
+        }
+
+        const reset = setting.overridden
+            ? `<button class="btn-delete-small" onclick="dashboard.saveSetting('${setting.key}', null)">恢复默认</button>`
+            : '';
+
+        return `
+            <tr>
+                <td><code>${this.escapeHtml(setting.key)}</code></td>
+                <td>${this.escapeHtml(setting.description)}</td>
+                <td>${input}</td>
+                <td>${this.escapeHtml(String(setting.default))}</td>
+                <td>
+                    <button class="btn-toggle" onclick="dashboard.saveSetting('${setting.key}')">保存</button>
+                    ${reset}
+                </td>
+            </tr>
+        `;
+    }
+
+    /**
+     * 保存单个设置项，value 为 null 时恢复默认值
+     */
+    async saveSetting(key, value) {
+        if (value === undefined) {
+            const input = document.getElementById(`setting-${key}`);
+            value = input.type === 'number' ? Number(input.value) : input.value;
+        }
+
+        try {
+            const response = await fetch(`${this.apiBaseUrl}/settings`, {
+                method: 'PUT',
+                headers: {
+                    'Content-Type': 'application/json',
+                    'X-CSRF-Token': this.getCsrfToken()
+                },
+                body: JSON.stringify({ [key]: value })
+            });
+
+            const result = await response.json();
+
+            if (result.success) {
+                this.refreshSettings();
+                this.showToast(value === null ? '已恢复默认值' : '设置已保存');
+            } else {
+                this.showToast(result.error || '保存失败', 'error');
+            }
+        } catch (error) {
+            console.error('保存设置失败:', error);
+            this.showToast('网络错误: ' + error.message, 'error');
+        }
+    }
+
+    // ==================== 调试捕获 ====================
+
+    /**
+     * 刷新调试捕获包列表
+     */
+    async refreshDebugCaptures() {
+        const tbody = document.getElementById('debugCaptureTableBody');
+        this.showClientTokenLoading(tbody, '正在加载调试捕获...');
+
+        try {
+            const response = await fetch(`${this.apiBaseUrl}/debug/captures`);
+            if (!response.ok) {
+                throw new Error(`HTTP ${response.status}: ${response.statusText}`);
+            }
+
+            const data = await response.json();
+            this.updateElement('totalDebugCaptures', data.total || 0);
+            if (!data.captures || data.captures.length === 0) {
+                tbody.innerHTML = '<tr><td colspan="8" class="loading">暂无调试捕获，可在请求中添加 X-Kiro-Debug: capture 或为客户端令牌开启调试捕获</td></tr>';
+                return;
+            }
+            tbody.innerHTML = data.captures.map(capture => this.createDebugCaptureRow(capture)).join('');
+        } catch (error) {
+            console.error('加载调试捕获失败:', error);
+            this.showClientTokenError(tbody, `加载失败: ${error.message}`);
+        }
+    }
+
+    /**
+     * 创建单个调试捕获行
+     */
+    createDebugCaptureRow(capture) {
+        const id = this.escapeHtml(capture.id);
+        const trigger = capture.trigger === 'client_token' ? '客户端令牌' : '请求头';
+        const truncated = capture.truncated && capture.truncated.length > 0
+            ? ` <span class="status-badge status-disabled" title="${this.escapeHtml(capture.truncated.join(', '))}">已截断</span>`
+            : '';
+
+        return `
+            <tr>
+                <td>${this.formatDateTime(capture.startedAt)}</td>
+                <td><code>${id}</code></td>
+                <td>${this.escapeHtml(capture.path)}</td>
+                <td>${this.escapeHtml(capture.model || '-')}</td>
+                <td>${this.escapeHtml(capture.client || '-')} (${trigger})</td>
+                <td>${capture.statusCode}</td>
+                <td>${capture.durationMs} ms / ${(capture.size / 1024).toFixed(1)} KB${truncated}</td>
+                <td>
+                    <a class="btn-toggle" href="${this.apiBaseUrl}/debug/captures/${id}" download>下载</a>
+                    <button class="btn-delete-small" onclick="dashboard.deleteDebugCapture('${id}')">删除</button>
+                </td>
+            </tr>
+        `;
+    }
+
+    /**
+     * 删除调试捕获包
+     */
+    async deleteDebugCapture(id) {
+        if (!confirm(`确定删除调试捕获 ${id} 吗？`)) {
+            return;
+        }
+
+        try {
+            const response = await fetch(`${this.apiBaseUrl}/debug/captures/${encodeURIComponent(id)}`, {
+                method: 'DELETE',
+                headers: {
+                    'X-CSRF-Token': this.getCsrfToken()
+                }
+            });
+
+            const result = await response.json();
+
+            if (result.success) {
+                this.refreshDebugCaptures();
+                this.showToast('调试捕获已删除');
+            } else {
+                this.showToast(result.error || '删除失败', 'error');
+            }
+        } catch (error) {
+            console.error('删除调试捕获失败:', error);
+            this.showToast('网络错误: ' + error.message, 'error');
+        }
+    }
+
+    // ==================== 管理API密钥 ====================
+
+    /**
+     * 显示管理API密钥模态框
+     */
+    showAdminKeyModal() {
+        document.getElementById('adminKeyModal').style.display = 'flex';
+        document.getElementById('adminKeyName').value = '';
+        document.getElementById('adminKeySecretPanel').style.display = 'none';
+        this.showAdminKeyError('');
+        this.refreshAdminKeys();
+    }
+
+    /**
+     * 隐藏管理API密钥模态框
+     */
+    hideAdminKeyModal() {
+        document.getElementById('adminKeyModal').style.display = 'none';
+        document.getElementById('adminKeySecret').value = '';
+    }
+
+    /**
+     * 刷新管理API密钥列表
+     */
+    async refreshAdminKeys() {
+        try {
+            const response = await fetch(`${this.apiBaseUrl}/admin-keys`);
+            const data = await response.json();
+            if (!response.ok) {
+                throw new Error(data.error || `HTTP ${response.status}`);
+            }
+
+            this.renderAdminKeyScopes(data.scopes || []);
+            const tbody = document.getElementById('adminKeyTableBody');
+            if (!data.keys || data.keys.length === 0) {
+                tbody.innerHTML = '<tr><td colspan="6" class="empty-state">暂无API密钥</td></tr>';
+                return;
+            }
+            tbody.innerHTML = data.keys.map(key => `
+                <tr>
+                    <td>${this.escapeHtml(key.name)}</td>
+                    <td><span class="token-preview">${this.escapeHtml(key.prefix)}…</span></td>
+                    <td>${this.escapeHtml(key.scopes.join(', '))}</td>
+                    <td>${key.expired ? '<span class="status-badge status-error">已过期</span>' : this.formatDateTime(key.expiresAt)}</td>
+                    <td>${this.formatDateTime(key.lastUsedAt)}</td>
+                    <td><button class="btn-delete-small" onclick="dashboard.deleteAdminKey('${this.escapeHtml(key.id)}')">吊销</button></td>
+                </tr>
+            `).join('');
+        } catch (error) {
+            console.error('加载API密钥失败:', error);
+            this.showAdminKeyError(`加载失败: ${error.message}`);
+        }
+    }
+
+    /**
+     * 渲染权限范围复选框（保留已勾选状态）
+     */
+    renderAdminKeyScopes(scopes) {
+        const container = document.getElementById('adminKeyScopes');
+        if (container.childElementCount > 0) {
+            return;
+        }
+        container.innerHTML = scopes.map(scope => `
+            <label class="scope-option">
+                <input type="checkbox" value="${this.escapeHtml(scope)}"> ${this.escapeHtml(scope)}
+            </label>
+        `).join('');
+    }
+
+    /**
+     * 创建管理API密钥
+     */
+    async createAdminKey() {
+        this.showAdminKeyError('');
+        const name = document.getElementById('adminKeyName').value.trim();
+        const scopes = Array.from(document.querySelectorAll('#adminKeyScopes input:checked')).map(el => el.value);
+        const expiresInDays = parseInt(document.getElementById('adminKeyExpires').value, 10) || 0;
+
+        if (!name || scopes.length === 0) {
+            this.showAdminKeyError('请填写名称并至少选择一个权限范围');
+            return;
+        }
+
+        try {
+            const response = await fetch(`${this.apiBaseUrl}/admin-keys`, {
+                method: 'POST',
+                headers: {
+                    'Content-Type': 'application/json',
+                    'X-CSRF-Token': this.getCsrfToken()
+                },
+                body: JSON.stringify({ name, scopes, expires_in_days: expiresInDays })
+            });
+            const result = await response.json();
+            if (!response.ok || !result.success) {
+                this.showAdminKeyError(result.error || '创建失败');
+                return;
+            }
+
+            document.getElementById('adminKeySecret').value = result.secret;
+            document.getElementById('adminKeySecretPanel').style.display = 'block';
+            document.getElementById('adminKeyName').value = '';
+            this.showToast('API密钥已创建');
+            this.refreshAdminKeys();
+        } catch (error) {
+            console.error('创建API密钥失败:', error);
+            this.showAdminKeyError('网络错误: ' + error.message);
+        }
+    }
+
+    /**
+     * 吊销管理API密钥
+     */
+    async deleteAdminKey(id) {
+        try {
+            const response = await fetch(`${this.apiBaseUrl}/admin-keys/${encodeURIComponent(id)}`, {
+                method: 'DELETE',
+                headers: {
+                    'X-CSRF-Token': this.getCsrfToken()
+                }
+            });
+            const result = await response.json();
+            if (result.success) {
+                this.showToast('API密钥已吊销');
+                this.refreshAdminKeys();
+            } else {
+                this.showToast(result.error || '吊销失败', 'error');
+            }
+        } catch (error) {
+            console.error('吊销API密钥失败:', error);
+            this.showToast('网络错误: ' + error.message, 'error');
+        }
+    }
+
+    /**
+     * 显示API密钥错误信息
+     */
+    showAdminKeyError(message) {
+        const errorEl = document.getElementById('adminKeyError');
+        errorEl.textContent = message;
+        errorEl.style.display = message ? 'block' : 'none';
+    }
+
+    // ==================== 两步验证 ====================
+
+    /**
+     * 显示两步验证模态框
+     */
+    showTotpModal() {
+        document.getElementById('totpModal').style.display = 'flex';
+        document.getElementById('totpEnrollPanel').style.display = 'none';
+        document.getElementById('totpRecoveryPanel').style.display = 'none';
+        document.getElementById('totpCodeInput').value = '';
+        this.showTotpError('');
+        this.loadTotpStatus();
+    }
+
+    /**
+     * 隐藏两步验证模态框
+     */
+    hideTotpModal() {
+        document.getElementById('totpModal').style.display = 'none';
+        document.getElementById('totpRecoveryCodes').value = '';
+    }
+
+    /**
+     * 加载两步验证状态并更新按钮
+     */
+    async loadTotpStatus() {
+        try {
+            const response = await fetch(`${this.apiBaseUrl}/2fa/status`);
+            const data = await response.json();
+            if (!response.ok) {
+                throw new Error(data.error || `HTTP ${response.status}`);
+            }
+
+            const statusText = data.enabled
+                ? `已启用（剩余恢复码 ${data.recovery_codes_remaining} 个）`
+                : '未启用';
+            this.updateElement('totpStatusText', statusText);
+            this.setTotpButtons(data.enabled ? 'enabled' : 'disabled');
+        } catch (error) {
+            console.error('获取两步验证状态失败:', error);
+            this.showTotpError(`加载失败: ${error.message}`);
+        }
+    }
+
+    /**
+     * 根据状态切换模态框按钮
+     */
+    setTotpButtons(state) {
+        const show = (id, visible) => {
+            document.getElementById(id).style.display = visible ? 'inline-block' : 'none';
+        };
+        show('totpEnrollBtn', state === 'disabled');
+        show('totpConfirmBtn', state === 'enrolling');
+        show('totpRegenerateBtn', state === 'enabled');
+        show('totpDisableBtn', state === 'enabled');
+        document.getElementById('totpCodeGroup').style.display = state === 'disabled' ? 'none' : 'block';
+    }
+
+    /**
+     * 开始绑定两步验证
+     */
+    async beginTotpEnroll() {
+        const data = await this.postTotp('enroll', {});
+        if (!data) {
+            return;
+        }
+        document.getElementById('totpUri').value = data.enrollment.qr_payload;
+        this.updateElement('totpSecret', data.enrollment.secret);
+        document.getElementById('totpEnrollPanel').style.display = 'block';
+        this.setTotpButtons('enrolling');
+    }
+
+    /**
+     * 确认绑定并显示恢复码
+     */
+    async confirmTotpEnroll() {
+        const data = await this.postTotp('confirm', { code: document.getElementById('totpCodeInput').value.trim() });
+        if (!data) {
+            return;
+        }
+        document.getElementById('totpEnrollPanel').style.display = 'none';
+        this.showRecoveryCodes(data.recovery_codes);
+        this.showToast('两步验证已启用');
+        this.loadTotpStatus();
+    }
+
+    /**
+     * 重新生成恢复码
+     */
+    async regenerateRecoveryCodes() {
+        const data = await this.postTotp('recovery-codes', { code: document.getElementById('totpCodeInput').value.trim() });
+        if (!data) {
+            return;
+        }
+        this.showRecoveryCodes(data.recovery_codes);
+        this.showToast('恢复码已重新生成');
+        this.loadTotpStatus();
+    }
+
+    /**
+     * 停用两步验证
+     */
+    async disableTotp() {
+        const data = await this.postTotp('disable', { code: document.getElementById('totpCodeInput').value.trim() });
+        if (!data) {
+            return;
+        }
+        document.getElementById('totpRecoveryPanel').style.display = 'none';
+        this.showToast('两步验证已停用');
+        this.loadTotpStatus();
+    }
+
+    /**
+     * 显示恢复码
+     */
+    showRecoveryCodes(codes) {
+        document.getElementById('totpRecoveryCodes').value = (codes || []).join('\n');
+        document.getElementById('totpRecoveryPanel').style.display = 'block';
+        document.getElementById('totpCodeInput').value = '';
+    }
+
+    /**
+     * 发送两步验证请求，失败时显示错误并返回 null
+     */
+    async postTotp(path, body) {
+        this.showTotpError('');
+        try {
+            const response = await fetch(`${this.apiBaseUrl}/2fa/${path}`, {
+                method: 'POST',
+                headers: {
+                    'Content-Type': 'application/json',
+                    'X-CSRF-Token': this.getCsrfToken()
+                },
+                body: JSON.stringify(body)
+            });
+            const data = await response.json();
+            if (!response.ok || !data.success) {
+                this.showTotpError(data.error || '操作失败');
+                return null;
+            }
+            return data;
+        } catch (error) {
+            console.error('两步验证请求失败:', error);
+            this.showTotpError('网络错误: ' + error.message);
+            return null;
+        }
+    }
+
+    /**
+     * 显示两步验证错误信息
+     */
+    showTotpError(message) {
+        const errorEl = document.getElementById('totpError');
+        errorEl.textContent = message;
+        errorEl.style.display = message ? 'block' : 'none';
+    }
 }
 
 // DOM加载完成后初始化 (依赖注入原则)
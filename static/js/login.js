@@ -6,6 +6,8 @@
 
     // 页面加载时检查会话状态
     checkSession();
+    loadSsoStatus();
+    showRedirectError();
 
     // 获取登录表单并添加提交事件监听
     const form = document.getElementById('loginForm');
@@ -38,6 +40,38 @@
         }
     }
 
+    /**
+     * 查询 SSO 状态，启用时显示 SSO 登录按钮
+     */
+    async function loadSsoStatus() {
+        try {
+            const response = await fetch('/api/oidc/status');
+            if (!response.ok) {
+                return;
+            }
+            const data = await response.json();
+            if (data.enabled) {
+                document.getElementById('ssoBtn').textContent = `使用 ${data.name || 'SSO'} 登录`;
+                document.getElementById('ssoSection').style.display = 'block';
+            }
+            if (data.local_enabled === false) {
+                document.getElementById('loginForm').style.display = 'none';
+            }
+        } catch (error) {
+            console.debug('获取 SSO 状态出错:', error);
+        }
+    }
+
+    /**
+     * 显示 SSO 回调重定向携带的错误信息
+     */
+    function showRedirectError() {
+        const message = new URLSearchParams(window.location.search).get('error');
+        if (message) {
+            showError(message);
+        }
+    }
+
     /**
      * 处理登录表单提交
      */
@@ -46,6 +80,7 @@
 
         const username = document.getElementById('username').value.trim();
         const password = document.getElementById('password').value;
+        const totpCode = document.getElementById('totpCode').value.trim();
         const loginBtn = document.getElementById('loginBtn');
         const btnText = loginBtn.querySelector('.btn-text');
         const btnLoading = loginBtn.querySelector('.btn-loading');
@@ -71,7 +106,7 @@
                     'Content-Type': 'application/json',
                     'X-CSRF-Token': csrfToken
                 },
-                body: JSON.stringify({ username, password })
+                body: JSON.stringify({ username, password, totp_code: totpCode })
             });
 
             const data = await response.json();
@@ -80,6 +115,10 @@
                 // 登录成功，重定向到首页
                 window.location.href = '/';
             } else {
+                // 需要两步验证时显示验证码输入框
+                if (data.totp_required) {
+                    showTotpInput();
+                }
                 // 显示错误信息
                 showError(data.error || '登录失败，请重试');
             }
@@ -91,6 +130,17 @@
         }
     }
 
+    /**
+     * 显示两步验证码输入框
+     */
+    function showTotpInput() {
+        const group = document.getElementById('totpGroup');
+        const input = document.getElementById('totpCode');
+        group.style.display = 'block';
+        input.value = '';
+        input.focus();
+    }
+
     /**
      * 显示错误信息
      */
@@ -0,0 +1,281 @@
+package tracing
+
+import (
+	"context"
+	"encoding/binary"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"kiro2api/logger"
+)
+
+// 轻量级 OpenTelemetry 追踪实现，仅依赖标准库
+// - W3C traceparent 传播
+// - 批量导出到 OTLP/HTTP (JSON 编码) 收集器，如 otel-collector、Jaeger、Tempo
+
+const (
+	defaultServiceName   = "kiro2api"
+	defaultQueueSize     = 2048
+	defaultBatchSize     = 256
+	defaultFlushInterval = 5 * time.Second
+	defaultExportTimeout = 10 * time.Second
+)
+
+// Config 追踪配置
+type Config struct {
+	Endpoint      string            // 完整的 traces 接收地址，如 http://localhost:4318/v1/traces
+	Headers       map[string]string // 导出时附加的请求头（如认证）
+	ServiceName   string
+	SampleRatio   float64 // 根 span 采样率 (0~1)，子 span 跟随父 span
+	BatchSize     int
+	FlushInterval time.Duration
+	ExportTimeout time.Duration
+}
+
+// LoadConfigFromEnv 按 OpenTelemetry 标准环境变量读取配置
+// 未配置导出地址或 OTEL_SDK_DISABLED=true 时返回 false
+func LoadConfigFromEnv() (Config, bool) {
+	if strings.EqualFold(os.Getenv("OTEL_SDK_DISABLED"), "true") {
+		return Config{}, false
+	}
+
+	endpoint := os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT")
+	if endpoint == "" {
+		if base := os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"); base != "" {
+			endpoint = strings.TrimRight(base, "/") + "/v1/traces"
+		}
+	}
+	if endpoint == "" {
+		return Config{}, false
+	}
+
+	headers := make(map[string]string)
+	for _, pair := range strings.Split(os.Getenv("OTEL_EXPORTER_OTLP_HEADERS"), ",") {
+		key, value, ok := strings.Cut(pair, "=")
+		if ok && strings.TrimSpace(key) != "" {
+			headers[strings.TrimSpace(key)] = strings.TrimSpace(value)
+		}
+	}
+
+	serviceName := os.Getenv("OTEL_SERVICE_NAME")
+	if serviceName == "" {
+		serviceName = defaultServiceName
+	}
+
+	ratio := 1.0
+	if arg := os.Getenv("OTEL_TRACES_SAMPLER_ARG"); arg != "" {
+		if v, err := strconv.ParseFloat(arg, 64); err == nil && v >= 0 && v <= 1 {
+			ratio = v
+		}
+	}
+
+	return Config{
+		Endpoint:    endpoint,
+		Headers:     headers,
+		ServiceName: serviceName,
+		SampleRatio: ratio,
+	}, true
+}
+
+// Tracer 创建 span 并批量导出
+type Tracer struct {
+	cfg      Config
+	exporter *otlpExporter
+	queue    chan *Span
+	flushReq chan chan struct{}
+	stopOnce sync.Once
+	done     chan struct{}
+	dropped  atomic.Int64
+}
+
+// NewTracer 创建追踪器并启动后台导出协程
+func NewTracer(cfg Config) *Tracer {
+	if cfg.ServiceName == "" {
+		cfg.ServiceName = defaultServiceName
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = defaultBatchSize
+	}
+	if cfg.FlushInterval <= 0 {
+		cfg.FlushInterval = defaultFlushInterval
+	}
+	if cfg.ExportTimeout <= 0 {
+		cfg.ExportTimeout = defaultExportTimeout
+	}
+
+	t := &Tracer{
+		cfg: cfg,
+		exporter: &otlpExporter{
+			endpoint:    cfg.Endpoint,
+			headers:     cfg.Headers,
+			serviceName: cfg.ServiceName,
+			client:      &http.Client{Timeout: cfg.ExportTimeout},
+		},
+		queue:    make(chan *Span, defaultQueueSize),
+		flushReq: make(chan chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go t.run()
+	return t
+}
+
+// Start 创建子 span；ctx 中没有父 span 时创建根 span
+func (t *Tracer) Start(ctx context.Context, name string, kind SpanKind, attrs ...Attribute) (context.Context, *Span) {
+	if t == nil {
+		return ctx, nil
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	span := &Span{
+		tracer: t,
+		name:   name,
+		kind:   kind,
+		start:  time.Now(),
+	}
+	if parent, ok := parentFromContext(ctx); ok {
+		span.sc = SpanContext{TraceID: parent.TraceID, SpanID: newSpanID(), Sampled: parent.Sampled}
+		span.parentID = parent.SpanID
+	} else {
+		traceID := newTraceID()
+		span.sc = SpanContext{TraceID: traceID, SpanID: newSpanID(), Sampled: t.shouldSample(traceID)}
+	}
+	if len(attrs) > 0 {
+		span.attrs = append(span.attrs, attrs...)
+	}
+	return ContextWithSpan(ctx, span), span
+}
+
+// shouldSample 根据 trace-id 低 8 字节做确定性比例采样
+func (t *Tracer) shouldSample(id TraceID) bool {
+	switch {
+	case t.cfg.SampleRatio >= 1:
+		return true
+	case t.cfg.SampleRatio <= 0:
+		return false
+	}
+	bound := uint64(t.cfg.SampleRatio * (1 << 63))
+	return binary.BigEndian.Uint64(id[8:])>>1 < bound
+}
+
+// enqueue 提交已结束的 span，队列满时丢弃，避免阻塞请求
+func (t *Tracer) enqueue(span *Span) {
+	select {
+	case t.queue <- span:
+	default:
+		if t.dropped.Add(1)%100 == 1 {
+			logger.Warn("追踪队列已满，丢弃span", logger.Int64("dropped", t.dropped.Load()))
+		}
+	}
+}
+
+// ForceFlush 立即导出队列中的 span（测试和退出时使用）
+func (t *Tracer) ForceFlush(ctx context.Context) {
+	if t == nil {
+		return
+	}
+	ack := make(chan struct{})
+	select {
+	case t.flushReq <- ack:
+	case <-t.done:
+		return
+	case <-ctx.Done():
+		return
+	}
+	select {
+	case <-ack:
+	case <-ctx.Done():
+	}
+}
+
+// Shutdown 导出剩余 span 并停止后台协程
+func (t *Tracer) Shutdown(ctx context.Context) {
+	if t == nil {
+		return
+	}
+	t.ForceFlush(ctx)
+	t.stopOnce.Do(func() { close(t.done) })
+}
+
+// run 后台批量导出
+func (t *Tracer) run() {
+	ticker := time.NewTicker(t.cfg.FlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]*Span, 0, t.cfg.BatchSize)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := t.exporter.export(batch); err != nil {
+			logger.Warn("导出追踪数据失败",
+				logger.String("endpoint", t.cfg.Endpoint),
+				logger.Int("spans", len(batch)),
+				logger.Err(err))
+		}
+		batch = make([]*Span, 0, t.cfg.BatchSize)
+	}
+	drain := func() {
+		for {
+			select {
+			case span := <-t.queue:
+				batch = append(batch, span)
+				if len(batch) >= t.cfg.BatchSize {
+					flush()
+				}
+			default:
+				return
+			}
+		}
+	}
+
+	for {
+		select {
+		case span := <-t.queue:
+			batch = append(batch, span)
+			if len(batch) >= t.cfg.BatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		case ack := <-t.flushReq:
+			drain()
+			flush()
+			close(ack)
+		case <-t.done:
+			drain()
+			flush()
+			return
+		}
+	}
+}
+
+// ==================== 全局追踪器 ====================
+
+var globalTracer atomic.Pointer[Tracer]
+
+// SetGlobal 设置全局追踪器（nil 表示关闭追踪）
+func SetGlobal(t *Tracer) {
+	globalTracer.Store(t)
+}
+
+// Global 返回全局追踪器（未启用时为 nil）
+func Global() *Tracer {
+	return globalTracer.Load()
+}
+
+// Enabled 是否启用了追踪
+func Enabled() bool {
+	return globalTracer.Load() != nil
+}
+
+// Start 使用全局追踪器创建 span；未启用时返回原 ctx 和 nil span
+func Start(ctx context.Context, name string, kind SpanKind, attrs ...Attribute) (context.Context, *Span) {
+	return globalTracer.Load().Start(ctx, name, kind, attrs...)
+}
@@ -0,0 +1,174 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	sc, ok := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	require.True(t, ok)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", sc.TraceID.String())
+	assert.Equal(t, "00f067aa0ba902b7", sc.SpanID.String())
+	assert.True(t, sc.Sampled)
+	assert.True(t, sc.Remote)
+	assert.Equal(t, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", sc.Traceparent())
+
+	invalid := []string{
+		"",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+	}
+	for _, header := range invalid {
+		_, ok := ParseTraceparent(header)
+		assert.False(t, ok, header)
+	}
+
+	// 未来版本允许追加字段
+	_, ok = ParseTraceparent("01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00-extra")
+	assert.True(t, ok)
+}
+
+// mockCollector 记录收到的 OTLP 请求
+type mockCollector struct {
+	mu    sync.Mutex
+	spans []map[string]any
+	auth  string
+}
+
+func newMockCollector(t *testing.T) (*mockCollector, *httptest.Server) {
+	mc := &mockCollector{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v1/traces", r.URL.Path)
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+
+		var req struct {
+			ResourceSpans []struct {
+				ScopeSpans []struct {
+					Spans []map[string]any `json:"spans"`
+				} `json:"scopeSpans"`
+			} `json:"resourceSpans"`
+		}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		mc.mu.Lock()
+		mc.auth = r.Header.Get("Authorization")
+		for _, rs := range req.ResourceSpans {
+			for _, ss := range rs.ScopeSpans {
+				mc.spans = append(mc.spans, ss.Spans...)
+			}
+		}
+		mc.mu.Unlock()
+	}))
+	t.Cleanup(server.Close)
+	return mc, server
+}
+
+func TestTracer_ExportsSpansWithRemoteParent(t *testing.T) {
+	collector, server := newMockCollector(t)
+	tracer := NewTracer(Config{
+		Endpoint:      server.URL + "/v1/traces",
+		Headers:       map[string]string{"Authorization": "Bearer collector"},
+		SampleRatio:   1,
+		FlushInterval: time.Hour,
+	})
+	t.Cleanup(func() { tracer.Shutdown(context.Background()) })
+
+	remote, ok := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	require.True(t, ok)
+	ctx := ContextWithRemoteParent(context.Background(), remote)
+
+	ctx, root := tracer.Start(ctx, "POST /v1/messages", SpanKindServer, String("gen_ai.request.model", "claude"))
+	_, child := tracer.Start(ctx, "upstream.request", SpanKindClient)
+	child.SetAttributes(Int("http.status_code", 200), Int("http.status_code", 502))
+	child.RecordError(errors.New("bad gateway"))
+	child.End()
+	root.End()
+	root.End() // 重复结束无效
+
+	tracer.ForceFlush(context.Background())
+
+	collector.mu.Lock()
+	defer collector.mu.Unlock()
+	require.Len(t, collector.spans, 2)
+	assert.Equal(t, "Bearer collector", collector.auth)
+
+	byName := map[string]map[string]any{}
+	for _, span := range collector.spans {
+		byName[span["name"].(string)] = span
+	}
+	rootSpan, childSpan := byName["POST /v1/messages"], byName["upstream.request"]
+	assert.Equal(t, remote.TraceID.String(), rootSpan["traceId"])
+	assert.Equal(t, remote.SpanID.String(), rootSpan["parentSpanId"])
+	assert.EqualValues(t, SpanKindServer, rootSpan["kind"])
+	assert.Equal(t, rootSpan["spanId"], childSpan["parentSpanId"])
+	assert.Equal(t, remote.TraceID.String(), childSpan["traceId"])
+	assert.EqualValues(t, statusError, childSpan["status"].(map[string]any)["code"])
+
+	attrs := childSpan["attributes"].([]any)
+	require.Len(t, attrs, 1, "同名属性覆盖")
+	assert.Equal(t, "502", attrs[0].(map[string]any)["value"].(map[string]any)["intValue"])
+}
+
+func TestTracer_UnsampledSpansAreNotExported(t *testing.T) {
+	collector, server := newMockCollector(t)
+	tracer := NewTracer(Config{Endpoint: server.URL + "/v1/traces", SampleRatio: 0, FlushInterval: time.Hour})
+	t.Cleanup(func() { tracer.Shutdown(context.Background()) })
+
+	ctx, root := tracer.Start(context.Background(), "root", SpanKindServer)
+	_, child := tracer.Start(ctx, "child", SpanKindInternal)
+	assert.False(t, child.SpanContext().Sampled, "子 span 跟随父 span 的采样决定")
+	child.End()
+	root.End()
+	tracer.ForceFlush(context.Background())
+
+	collector.mu.Lock()
+	defer collector.mu.Unlock()
+	assert.Empty(t, collector.spans)
+}
+
+func TestGlobalStart_DisabledIsNoop(t *testing.T) {
+	SetGlobal(nil)
+	ctx := context.Background()
+	got, span := Start(ctx, "noop", SpanKindInternal)
+	assert.Equal(t, ctx, got)
+	assert.Nil(t, span)
+	// nil span 方法安全
+	span.SetAttributes(String("k", "v"))
+	span.RecordError(errors.New("x"))
+	span.End()
+}
+
+func TestLoadConfigFromEnv(t *testing.T) {
+	t.Setenv("OTEL_SDK_DISABLED", "")
+	t.Setenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT", "")
+	t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", "")
+	_, ok := LoadConfigFromEnv()
+	assert.False(t, ok)
+
+	t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", "http://collector:4318/")
+	t.Setenv("OTEL_EXPORTER_OTLP_HEADERS", "x-token=abc, tenant = t1")
+	t.Setenv("OTEL_TRACES_SAMPLER_ARG", "0.25")
+	cfg, ok := LoadConfigFromEnv()
+	require.True(t, ok)
+	assert.Equal(t, "http://collector:4318/v1/traces", cfg.Endpoint)
+	assert.Equal(t, map[string]string{"x-token": "abc", "tenant": "t1"}, cfg.Headers)
+	assert.Equal(t, 0.25, cfg.SampleRatio)
+	assert.Equal(t, defaultServiceName, cfg.ServiceName)
+
+	t.Setenv("OTEL_SDK_DISABLED", "true")
+	_, ok = LoadConfigFromEnv()
+	assert.False(t, ok)
+}
@@ -5,6 +5,12 @@ type AnthropicTool struct {
 	Name        string         `json:"name"`
 	Description string         `json:"description"`
 	InputSchema map[string]any `json:"input_schema"`
+
+	// 服务端工具（如 web_search_20250305）的字段
+	Type           string   `json:"type,omitempty"`
+	MaxUses        int      `json:"max_uses,omitempty"`
+	AllowedDomains []string `json:"allowed_domains,omitempty"`
+	BlockedDomains []string `json:"blocked_domains,omitempty"`
 }
 
 // ToolChoice 表示工具选择策略
@@ -13,17 +19,30 @@ type ToolChoice struct {
 	Name string `json:"name,omitempty"` // 当type为"tool"时指定的工具名称
 }
 
+// Thinking 表示扩展思考配置
+type Thinking struct {
+	Type         string `json:"type"`                    // "enabled" | "disabled"
+	BudgetTokens int    `json:"budget_tokens,omitempty"` // 思考过程可使用的最大token数
+}
+
+// IsEnabled 是否开启扩展思考
+func (t *Thinking) IsEnabled() bool {
+	return t != nil && t.Type == "enabled"
+}
+
 // AnthropicRequest 表示 Anthropic API 的请求结构
 type AnthropicRequest struct {
-	Model       string                    `json:"model"`
-	MaxTokens   int                       `json:"max_tokens"`
-	Messages    []AnthropicRequestMessage `json:"messages"`
-	System      []AnthropicSystemMessage  `json:"system,omitempty"`
-	Tools       []AnthropicTool           `json:"tools,omitempty"`
-	ToolChoice  any                       `json:"tool_choice,omitempty"` // 可以是string或ToolChoice对象
-	Stream      bool                      `json:"stream"`
-	Temperature *float64                  `json:"temperature,omitempty"`
-	Metadata    map[string]any            `json:"metadata,omitempty"`
+	Model         string                    `json:"model"`
+	MaxTokens     int                       `json:"max_tokens"`
+	Messages      []AnthropicRequestMessage `json:"messages"`
+	System        []AnthropicSystemMessage  `json:"system,omitempty"`
+	Tools         []AnthropicTool           `json:"tools,omitempty"`
+	ToolChoice    any                       `json:"tool_choice,omitempty"` // 可以是string或ToolChoice对象
+	Stream        bool                      `json:"stream"`
+	Temperature   *float64                  `json:"temperature,omitempty"`
+	Metadata      map[string]any            `json:"metadata,omitempty"`
+	Thinking      *Thinking                 `json:"thinking,omitempty"`
+	StopSequences []string                  `json:"stop_sequences,omitempty"` // 输出文本匹配任一序列时截断并以 stop_sequence 结束
 }
 
 // AnthropicStreamResponse 表示 Anthropic 流式响应的结构
@@ -59,17 +78,23 @@ type ContentBlock struct {
 	Type      string       `json:"type"`
 	Text      *string      `json:"text,omitempty"`
 	ToolUseId *string      `json:"tool_use_id,omitempty"`
-	Content   any          `json:"content,omitempty"`  // tool_result的内容，可以是string、[]any或map[string]any
-	Name      *string      `json:"name,omitempty"`     // tool_use的名称
-	Input     *any         `json:"input,omitempty"`    // tool_use的输入参数
-	ID        *string      `json:"id,omitempty"`       // tool_use的唯一标识符
-	IsError   *bool        `json:"is_error,omitempty"` // tool_result是否表示错误
-	Source    *ImageSource `json:"source,omitempty"`   // 图片数据源
+	Content   any          `json:"content,omitempty"`   // tool_result的内容，可以是string、[]any或map[string]any
+	Name      *string      `json:"name,omitempty"`      // tool_use的名称
+	Input     *any         `json:"input,omitempty"`     // tool_use的输入参数
+	ID        *string      `json:"id,omitempty"`        // tool_use的唯一标识符
+	IsError   *bool        `json:"is_error,omitempty"`  // tool_result是否表示错误
+	Source    *ImageSource `json:"source,omitempty"`    // 图片或文档数据源
+	Thinking  *string      `json:"thinking,omitempty"`  // thinking块的思考内容
+	Signature *string      `json:"signature,omitempty"` // thinking块的签名
+	Title     *string      `json:"title,omitempty"`     // document块的标题
+	Context   *string      `json:"context,omitempty"`   // document块的上下文说明
 }
 
-// ImageSource 表示图片数据源的结构
+// ImageSource 表示图片（以及document块）数据源的结构
 type ImageSource struct {
-	Type      string `json:"type"`       // "base64"
-	MediaType string `json:"media_type"` // "image/jpeg", "image/png", "image/gif", "image/webp"
-	Data      string `json:"data"`       // base64编码的图片数据
+	Type      string `json:"type"`              // "base64"；document块还支持 "text"、"content"、"url"
+	MediaType string `json:"media_type"`        // "image/jpeg", "image/png", "image/gif", "image/webp"；document块为 "application/pdf", "text/plain"
+	Data      string `json:"data"`              // base64编码的图片数据（text来源为纯文本）
+	URL       string `json:"url,omitempty"`     // url来源的地址
+	Content   any    `json:"content,omitempty"` // content来源的内容（字符串或内容块数组）
 }
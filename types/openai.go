@@ -48,6 +48,7 @@ type OpenAIRequest struct {
 	Stream      *bool           `json:"stream,omitempty"`
 	Tools       []OpenAITool    `json:"tools,omitempty"`
 	ToolChoice  any             `json:"tool_choice,omitempty"` // 可以是 "auto", "none", "required" 或 OpenAIToolChoice
+	Stop        any             `json:"stop,omitempty"`        // 停止序列，可以是 string 或 []string
 }
 
 type OpenAIChoice struct {
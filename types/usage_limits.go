@@ -135,7 +135,7 @@ func (t *TokenWithUsage) NeedsUsageRefresh() bool {
 	}
 
 	// 如果上次检查超过TokenCacheTTL
-	if time.Since(t.LastUsageCheck) > config.TokenCacheTTL {
+	if time.Since(t.LastUsageCheck) > config.GetTokenCacheTTL() {
 		return true
 	}
 
@@ -0,0 +1,153 @@
+package utils
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"math/bits"
+)
+
+// BMP 解码
+// 标准库不支持 BMP，上游也不接受 BMP，这里实现预处理转码所需的最小解码器：
+// 支持 1/4/8 位调色板、24 位和 32 位（BI_RGB / BI_BITFIELDS）的未压缩位图
+
+const (
+	bmpCompressionRGB       = 0
+	bmpCompressionBitfields = 3
+)
+
+// decodeBMPConfig 读取 BMP 的尺寸
+func decodeBMPConfig(data []byte) (int, int, error) {
+	if len(data) < 26 || data[0] != 'B' || data[1] != 'M' {
+		return 0, 0, errors.New("不是有效的 BMP 文件")
+	}
+	width := int(int32(binary.LittleEndian.Uint32(data[18:22])))
+	height := int(int32(binary.LittleEndian.Uint32(data[22:26])))
+	if height < 0 {
+		height = -height
+	}
+	if width <= 0 || height <= 0 {
+		return 0, 0, fmt.Errorf("BMP 尺寸无效: %dx%d", width, height)
+	}
+	return width, height, nil
+}
+
+// decodeBMP 解码 BMP 图片
+func decodeBMP(data []byte) (image.Image, error) {
+	if len(data) < 54 {
+		return nil, errors.New("不是有效的 BMP 文件")
+	}
+	width, height, err := decodeBMPConfig(data)
+	if err != nil {
+		return nil, err
+	}
+	pixelOffset := int(binary.LittleEndian.Uint32(data[10:14]))
+	headerSize := int(binary.LittleEndian.Uint32(data[14:18]))
+	topDown := int32(binary.LittleEndian.Uint32(data[22:26])) < 0
+	bpp := int(binary.LittleEndian.Uint16(data[28:30]))
+	compression := binary.LittleEndian.Uint32(data[30:34])
+	colorsUsed := int(binary.LittleEndian.Uint32(data[46:50]))
+
+	if headerSize < 40 || 14+headerSize > len(data) {
+		return nil, errors.New("不支持的 BMP 头部格式")
+	}
+
+	stride := ((bpp*width + 31) / 32) * 4
+	if pixelOffset < 0 || pixelOffset+stride*height > len(data) {
+		return nil, errors.New("BMP 像素数据不完整")
+	}
+
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	rowAt := func(y int) []byte {
+		srcRow := height - 1 - y
+		if topDown {
+			srcRow = y
+		}
+		start := pixelOffset + srcRow*stride
+		return data[start : start+stride]
+	}
+
+	switch {
+	case bpp <= 8 && compression == bmpCompressionRGB && (bpp == 1 || bpp == 4 || bpp == 8):
+		if colorsUsed == 0 || colorsUsed > 1<<bpp {
+			colorsUsed = 1 << bpp
+		}
+		paletteStart := 14 + headerSize
+		if paletteStart+colorsUsed*4 > len(data) {
+			return nil, errors.New("BMP 调色板不完整")
+		}
+		palette := make([]color.NRGBA, colorsUsed)
+		for i := range palette {
+			p := data[paletteStart+i*4:]
+			palette[i] = color.NRGBA{R: p[2], G: p[1], B: p[0], A: 0xFF}
+		}
+		perByte := 8 / bpp
+		mask := byte(1<<bpp - 1)
+		for y := 0; y < height; y++ {
+			row := rowAt(y)
+			for x := 0; x < width; x++ {
+				shift := uint(8 - bpp*(x%perByte+1))
+				idx := int(row[x/perByte] >> shift & mask)
+				if idx < len(palette) {
+					img.SetNRGBA(x, y, palette[idx])
+				}
+			}
+		}
+
+	case bpp == 24 && compression == bmpCompressionRGB:
+		for y := 0; y < height; y++ {
+			row := rowAt(y)
+			for x := 0; x < width; x++ {
+				p := row[x*3:]
+				img.SetNRGBA(x, y, color.NRGBA{R: p[2], G: p[1], B: p[0], A: 0xFF})
+			}
+		}
+
+	case bpp == 32 && (compression == bmpCompressionRGB || compression == bmpCompressionBitfields):
+		rMask, gMask, bMask, aMask := uint32(0x00FF0000), uint32(0x0000FF00), uint32(0x000000FF), uint32(0)
+		if compression == bmpCompressionBitfields {
+			// 掩码位于 V4/V5 头部内，或紧跟 40 字节的 BITMAPINFOHEADER 之后
+			if 14+40+12 > len(data) {
+				return nil, errors.New("BMP 位域掩码不完整")
+			}
+			masks := data[14+40:]
+			rMask = binary.LittleEndian.Uint32(masks[0:4])
+			gMask = binary.LittleEndian.Uint32(masks[4:8])
+			bMask = binary.LittleEndian.Uint32(masks[8:12])
+			if headerSize >= 56 {
+				aMask = binary.LittleEndian.Uint32(masks[12:16])
+			}
+		}
+		for y := 0; y < height; y++ {
+			row := rowAt(y)
+			for x := 0; x < width; x++ {
+				v := binary.LittleEndian.Uint32(row[x*4:])
+				a := uint8(0xFF)
+				if aMask != 0 {
+					a = bmpChannel(v, aMask)
+				}
+				img.SetNRGBA(x, y, color.NRGBA{R: bmpChannel(v, rMask), G: bmpChannel(v, gMask), B: bmpChannel(v, bMask), A: a})
+			}
+		}
+
+	default:
+		return nil, fmt.Errorf("不支持的 BMP 格式: %d 位，压缩方式 %d", bpp, compression)
+	}
+	return img, nil
+}
+
+// bmpChannel 按位域掩码提取通道值并扩展到 8 位
+func bmpChannel(v, mask uint32) uint8 {
+	if mask == 0 {
+		return 0
+	}
+	shift := bits.TrailingZeros32(mask)
+	width := bits.OnesCount32(mask)
+	value := (v & mask) >> shift
+	if width >= 8 {
+		return uint8(value >> (width - 8))
+	}
+	return uint8(value * 255 / (1<<width - 1))
+}
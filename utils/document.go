@@ -0,0 +1,181 @@
+package utils
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"kiro2api/types"
+)
+
+// 文档内容块（document）处理
+// 上游不支持文档输入，代理在本地提取文本（PDF、纯文本或内容数组），以带分隔标签的上下文注入消息
+
+const (
+	// MaxDocumentSize 单个文档解码后的最大大小 (32MB)
+	MaxDocumentSize = 32 * 1024 * 1024
+	// MaxDocumentPages PDF 文档的最大页数
+	MaxDocumentPages = 100
+	// MaxDocumentTextLength 单个文档提取文本的最大字符数
+	MaxDocumentTextLength = 500000
+)
+
+// DocumentError 文档内容无效（来源不支持、超出大小限制、无法提取文本等），属于客户端请求错误
+type DocumentError struct {
+	Message string
+}
+
+// Error 实现 error 接口
+func (e *DocumentError) Error() string {
+	return e.Message
+}
+
+func documentErrorf(format string, args ...any) error {
+	return &DocumentError{Message: fmt.Sprintf(format, args...)}
+}
+
+// DocumentSourceFromMap 从请求中的 source 字段解析文档数据源
+func DocumentSourceFromMap(source map[string]any) *types.ImageSource {
+	if source == nil {
+		return nil
+	}
+	docSource := &types.ImageSource{}
+	docSource.Type, _ = source["type"].(string)
+	docSource.MediaType, _ = source["media_type"].(string)
+	docSource.Data, _ = source["data"].(string)
+	docSource.URL, _ = source["url"].(string)
+	docSource.Content = source["content"]
+	return docSource
+}
+
+// ExtractDocumentText 提取文档文本
+// 支持的来源：base64（application/pdf、text/plain）、text（纯文本）、content（字符串或文本块数组）
+func ExtractDocumentText(source *types.ImageSource) (string, error) {
+	if source == nil {
+		return "", documentErrorf("文档缺少source字段")
+	}
+
+	var text string
+	switch source.Type {
+	case "base64":
+		data, err := base64.StdEncoding.DecodeString(source.Data)
+		if err != nil {
+			return "", documentErrorf("文档的 base64 编码无效: %v", err)
+		}
+		if len(data) > MaxDocumentSize {
+			return "", documentErrorf("文档数据过大: %d 字节，最大支持 %d 字节", len(data), MaxDocumentSize)
+		}
+		switch source.MediaType {
+		case "application/pdf":
+			if text, err = extractPDFTextCached(data); err != nil {
+				return "", err
+			}
+		case "text/plain", "":
+			if !utf8.Valid(data) {
+				return "", documentErrorf("纯文本文档不是有效的 UTF-8 编码")
+			}
+			text = string(data)
+		default:
+			return "", documentErrorf("不支持的文档格式: %s（支持 application/pdf、text/plain）", source.MediaType)
+		}
+
+	case "text":
+		if len(source.Data) > MaxDocumentSize {
+			return "", documentErrorf("文档数据过大: %d 字节，最大支持 %d 字节", len(source.Data), MaxDocumentSize)
+		}
+		text = source.Data
+
+	case "content":
+		text = documentContentText(source.Content)
+
+	case "url":
+		return "", documentErrorf("不支持 URL 类型的文档来源，请使用 base64 或 text")
+
+	default:
+		return "", documentErrorf("不支持的文档来源类型: %s", source.Type)
+	}
+
+	if n := utf8.RuneCountInString(text); n > MaxDocumentTextLength {
+		return "", documentErrorf("文档文本过长: %d 字符，最大支持 %d 字符", n, MaxDocumentTextLength)
+	}
+	return text, nil
+}
+
+// documentContentText 拼接 content 来源中的文本块（图片等非文本块忽略）
+func documentContentText(content any) string {
+	switch v := content.(type) {
+	case string:
+		return v
+	case []any:
+		var parts []string
+		for _, item := range v {
+			if block, ok := item.(map[string]any); ok && block["type"] == "text" {
+				if text, ok := block["text"].(string); ok {
+					parts = append(parts, text)
+				}
+			}
+		}
+		return strings.Join(parts, "\n")
+	}
+	return ""
+}
+
+// FormatDocumentContext 将文档文本格式化为带分隔标签的上下文，保留标题和上下文说明
+func FormatDocumentContext(title, context, text string) string {
+	var sb strings.Builder
+	sb.WriteString("<document>\n")
+	if title != "" {
+		sb.WriteString("<title>" + title + "</title>\n")
+	}
+	if context != "" {
+		sb.WriteString("<context>" + context + "</context>\n")
+	}
+	sb.WriteString("<document_content>\n")
+	sb.WriteString(strings.TrimSpace(text))
+	sb.WriteString("\n</document_content>\n</document>\n")
+	return sb.String()
+}
+
+// pdfTextCacheSize PDF 提取结果缓存的条目数
+// 同一文档会随对话历史在每轮请求中重复出现，并且在 token 估算和请求转换中各处理一次
+const pdfTextCacheSize = 16
+
+var pdfTextCache = struct {
+	sync.Mutex
+	entries map[[sha256.Size]byte]string
+	order   [][sha256.Size]byte
+}{entries: make(map[[sha256.Size]byte]string)}
+
+// extractPDFTextCached 提取 PDF 文本，按内容哈希缓存结果
+func extractPDFTextCached(data []byte) (string, error) {
+	key := sha256.Sum256(data)
+	pdfTextCache.Lock()
+	text, ok := pdfTextCache.entries[key]
+	pdfTextCache.Unlock()
+	if ok {
+		return text, nil
+	}
+
+	text, _, err := ExtractPDFText(data, MaxDocumentPages)
+	if err != nil {
+		return "", documentErrorf("PDF 文本提取失败: %v", err)
+	}
+	if strings.TrimSpace(text) == "" {
+		return "", documentErrorf("PDF 中没有可提取的文本（可能是扫描件或图片）")
+	}
+
+	pdfTextCache.Lock()
+	defer pdfTextCache.Unlock()
+	if _, exists := pdfTextCache.entries[key]; !exists {
+		if len(pdfTextCache.order) >= pdfTextCacheSize {
+			delete(pdfTextCache.entries, pdfTextCache.order[0])
+			pdfTextCache.order = pdfTextCache.order[1:]
+		}
+		pdfTextCache.entries[key] = text
+		pdfTextCache.order = append(pdfTextCache.order, key)
+	}
+	return text, nil
+}
@@ -0,0 +1,73 @@
+package utils
+
+import (
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+
+	"kiro2api/types"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExtractDocumentText_Sources(t *testing.T) {
+	text, err := ExtractDocumentText(&types.ImageSource{Type: "text", MediaType: "text/plain", Data: "plain text"})
+	require.NoError(t, err)
+	assert.Equal(t, "plain text", text)
+
+	text, err = ExtractDocumentText(&types.ImageSource{Type: "base64", MediaType: "text/plain", Data: base64.StdEncoding.EncodeToString([]byte("encoded"))})
+	require.NoError(t, err)
+	assert.Equal(t, "encoded", text)
+
+	text, err = ExtractDocumentText(&types.ImageSource{Type: "content", Content: []any{
+		map[string]any{"type": "text", "text": "first"},
+		map[string]any{"type": "image"},
+		map[string]any{"type": "text", "text": "second"},
+	}})
+	require.NoError(t, err)
+	assert.Equal(t, "first\nsecond", text)
+
+	pdf := buildTestPDF(
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+		"<< /Type /Page /Parent 2 0 R /Contents 4 0 R >>",
+		streamObject("", "BT (Quarterly report) Tj ET", true),
+	)
+	text, err = ExtractDocumentText(&types.ImageSource{Type: "base64", MediaType: "application/pdf", Data: base64.StdEncoding.EncodeToString(pdf)})
+	require.NoError(t, err)
+	assert.Equal(t, "Quarterly report", text)
+}
+
+func TestExtractDocumentText_Errors(t *testing.T) {
+	var docErr *DocumentError
+
+	_, err := ExtractDocumentText(&types.ImageSource{Type: "url", URL: "https://example.com/a.pdf"})
+	assert.True(t, errors.As(err, &docErr))
+	assert.Contains(t, err.Error(), "URL")
+
+	_, err = ExtractDocumentText(&types.ImageSource{Type: "base64", MediaType: "application/msword", Data: base64.StdEncoding.EncodeToString([]byte("doc"))})
+	assert.ErrorContains(t, err, "不支持的文档格式")
+
+	_, err = ExtractDocumentText(&types.ImageSource{Type: "text", Data: strings.Repeat("a", MaxDocumentTextLength+1)})
+	assert.ErrorContains(t, err, "文档文本过长")
+
+	scanned := buildTestPDF(
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+		"<< /Type /Page /Parent 2 0 R >>",
+	)
+	_, err = ExtractDocumentText(&types.ImageSource{Type: "base64", MediaType: "application/pdf", Data: base64.StdEncoding.EncodeToString(scanned)})
+	assert.True(t, errors.As(err, &docErr))
+	assert.Contains(t, err.Error(), "没有可提取的文本")
+}
+
+func TestFormatDocumentContext(t *testing.T) {
+	assert.Equal(t,
+		"<document>\n<title>Report</title>\n<context>Q3 numbers</context>\n<document_content>\nbody\n</document_content>\n</document>\n",
+		FormatDocumentContext("Report", "Q3 numbers", "body\n"))
+	assert.Equal(t,
+		"<document>\n<document_content>\nbody\n</document_content>\n</document>\n",
+		FormatDocumentContext("", "", "body"))
+}
@@ -1,11 +1,23 @@
 package utils
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/binary"
 	"fmt"
+	"image"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"math"
 	"regexp"
 	"strings"
+	"sync"
 
+	"kiro2api/config"
 	"kiro2api/types"
 )
 
@@ -203,9 +215,14 @@ func ConvertImageURLToImageSource(imageURL map[string]any) (*types.ImageSource,
 		return nil, fmt.Errorf("image_url的url字段必须是字符串")
 	}
 
+	// http(s) URL 转为 url 类型的数据源，由预处理阶段下载
+	if strings.HasPrefix(urlStr, "http://") || strings.HasPrefix(urlStr, "https://") {
+		return &types.ImageSource{Type: "url", URL: urlStr}, nil
+	}
+
 	// 检查是否是data URL
 	if !strings.HasPrefix(urlStr, "data:") {
-		return nil, fmt.Errorf("目前仅支持data URL格式的图片")
+		return nil, fmt.Errorf("仅支持 data URL 和 http(s) URL 格式的图片")
 	}
 
 	// 解析data URL
@@ -220,3 +237,559 @@ func ConvertImageURLToImageSource(imageURL map[string]any) (*types.ImageSource,
 		Data:      base64Data,
 	}, nil
 }
+
+// ========== 图片预处理 ==========
+// 上游对大图片和 BMP 等格式只返回笼统的错误，这里在转换前统一预处理：
+// BMP（可选 GIF）转为 PNG，按长边和像素总数等比缩小，应用 EXIF 方向后去除元数据，超出大小的 JPEG 降低质量重新编码。
+// WebP 无法在本地解码，原样透传
+
+// maxDecodePixels 允许解码的最大像素数，防止超大图片耗尽内存
+const maxDecodePixels = 100 * 1000 * 1000
+
+// jpegQualities 重新编码 JPEG 时依次尝试的质量
+var jpegQualities = []int{85, 75, 60, 45}
+
+// PreprocessImage 预处理单张 base64 图片，返回处理后的数据源和变更说明（未修改时返回原数据源和空说明）
+func PreprocessImage(source *types.ImageSource, opts config.ImageOptions) (*types.ImageSource, []string, error) {
+	if source == nil || source.Type != "base64" {
+		return source, nil, nil // 其他来源类型由后续校验处理
+	}
+	data, err := base64.StdEncoding.DecodeString(source.Data)
+	if err != nil {
+		return nil, nil, fmt.Errorf("无效的 base64 编码: %v", err)
+	}
+	if len(data) > MaxImageSize {
+		return nil, nil, fmt.Errorf("图片数据过大: %d 字节，最大支持 %d 字节", len(data), MaxImageSize)
+	}
+	mediaType, err := DetectImageFormat(data)
+	if err != nil {
+		return source, nil, nil // 无法识别的格式由后续校验报错
+	}
+
+	cacheKey := imageCacheKey(data, source.MediaType, opts)
+	if cached, ok := imageCache.get(cacheKey); ok {
+		return cached.source, cached.changes, nil
+	}
+
+	var changes []string
+	if mediaType != source.MediaType {
+		changes = append(changes, fmt.Sprintf("media_type %s→%s", source.MediaType, mediaType))
+	}
+	if mediaType == "image/webp" {
+		return imageResult(source, mediaType, data, changes), changes, nil
+	}
+
+	width, height, err := imageDimensions(data, mediaType)
+	if err != nil {
+		return nil, nil, fmt.Errorf("读取图片尺寸失败: %v", err)
+	}
+	if width*height > maxDecodePixels {
+		return nil, nil, fmt.Errorf("图片分辨率过大: %dx%d", width, height)
+	}
+
+	orientation := 1
+	if mediaType == "image/jpeg" {
+		orientation = jpegOrientation(data)
+	}
+	orientedW, orientedH := width, height
+	if orientation >= 5 {
+		orientedW, orientedH = height, width
+	}
+	targetW, targetH := fitImageDimensions(orientedW, orientedH, opts)
+
+	outType := mediaType
+	if mediaType == "image/bmp" || (mediaType == "image/gif" && opts.TranscodeGIF) {
+		outType = "image/png"
+	}
+
+	var img image.Image
+	if outType != mediaType || orientation != 1 || targetW != orientedW || targetH != orientedH {
+		img, err = decodeImage(data, mediaType)
+		if err != nil {
+			return nil, nil, fmt.Errorf("解码图片失败: %v", err)
+		}
+		if orientation != 1 {
+			img = applyOrientation(img, orientation)
+			changes = append(changes, fmt.Sprintf("applied EXIF orientation %d", orientation))
+		}
+		if targetW != orientedW || targetH != orientedH {
+			img = resizeImage(img, targetW, targetH)
+			changes = append(changes, fmt.Sprintf("resized %dx%d→%dx%d", orientedW, orientedH, targetW, targetH))
+		}
+		if outType != mediaType {
+			changes = append(changes, fmt.Sprintf("%s→%s", GetImageFormatFromMediaType(mediaType), GetImageFormatFromMediaType(outType)))
+		}
+		if mediaType == "image/gif" && outType == "image/gif" {
+			outType = "image/png" // 缩小后的 GIF 以 PNG 输出，避免重新量化调色板
+			changes = append(changes, "gif→png")
+		}
+		if data, err = encodeImage(img, outType, jpegQualities[0]); err != nil {
+			return nil, nil, fmt.Errorf("编码图片失败: %v", err)
+		}
+	} else {
+		var stripped bool
+		switch mediaType {
+		case "image/jpeg":
+			data, stripped = stripJPEGMetadata(data)
+		case "image/png":
+			data, stripped = stripPNGMetadata(data)
+		}
+		if stripped {
+			changes = append(changes, "metadata stripped")
+		}
+	}
+
+	if outType == "image/jpeg" && opts.MaxBytes > 0 && len(data) > opts.MaxBytes {
+		if img == nil {
+			if img, err = decodeImage(data, outType); err != nil {
+				return nil, nil, fmt.Errorf("解码图片失败: %v", err)
+			}
+		}
+		originalSize := len(data)
+		for _, quality := range jpegQualities[1:] {
+			if data, err = encodeImage(img, outType, quality); err != nil {
+				return nil, nil, fmt.Errorf("编码图片失败: %v", err)
+			}
+			if len(data) <= opts.MaxBytes {
+				break
+			}
+		}
+		if len(data) > opts.MaxBytes {
+			return nil, nil, fmt.Errorf("图片重新编码后仍然过大: %d 字节，最大支持 %d 字节", len(data), opts.MaxBytes)
+		}
+		changes = append(changes, fmt.Sprintf("re-encoded JPEG %d→%d bytes", originalSize, len(data)))
+	}
+
+	if len(changes) == 0 {
+		return source, nil, nil
+	}
+	result := imageResult(source, outType, data, changes)
+	imageCache.put(cacheKey, cachedImage{source: result, changes: changes})
+	return result, changes, nil
+}
+
+// imageResult 构造处理后的数据源，数据未变化时沿用原始 base64 字符串
+func imageResult(source *types.ImageSource, mediaType string, data []byte, changes []string) *types.ImageSource {
+	if len(changes) == 0 {
+		return source
+	}
+	return &types.ImageSource{
+		Type:      "base64",
+		MediaType: mediaType,
+		Data:      base64.StdEncoding.EncodeToString(data),
+	}
+}
+
+// fitImageDimensions 按长边和像素总数上限计算等比缩小后的尺寸
+func fitImageDimensions(width, height int, opts config.ImageOptions) (int, int) {
+	scale := 1.0
+	if long := max(width, height); opts.MaxLongEdge > 0 && long > opts.MaxLongEdge {
+		scale = float64(opts.MaxLongEdge) / float64(long)
+	}
+	if pixels := float64(width) * float64(height) * scale * scale; opts.MaxPixels > 0 && pixels > float64(opts.MaxPixels) {
+		scale *= math.Sqrt(float64(opts.MaxPixels) / pixels)
+	}
+	if scale >= 1 {
+		return width, height
+	}
+	return max(1, int(float64(width)*scale)), max(1, int(float64(height)*scale))
+}
+
+// imageDimensions 读取图片尺寸（只解析头部）
+func imageDimensions(data []byte, mediaType string) (int, int, error) {
+	if mediaType == "image/bmp" {
+		return decodeBMPConfig(data)
+	}
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return 0, 0, err
+	}
+	return cfg.Width, cfg.Height, nil
+}
+
+// decodeImage 解码图片，GIF 只取第一帧
+func decodeImage(data []byte, mediaType string) (image.Image, error) {
+	switch mediaType {
+	case "image/bmp":
+		return decodeBMP(data)
+	case "image/jpeg":
+		return jpeg.Decode(bytes.NewReader(data))
+	case "image/png":
+		return png.Decode(bytes.NewReader(data))
+	case "image/gif":
+		return gif.Decode(bytes.NewReader(data))
+	}
+	return nil, fmt.Errorf("不支持解码的图片格式: %s", mediaType)
+}
+
+// encodeImage 编码图片（编码器不写入任何元数据）
+func encodeImage(img image.Image, mediaType string, quality int) ([]byte, error) {
+	var buf bytes.Buffer
+	var err error
+	if mediaType == "image/jpeg" {
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality})
+	} else {
+		err = png.Encode(&buf, img)
+	}
+	return buf.Bytes(), err
+}
+
+// toRGBA 转换为 RGBA 图片以便直接访问像素
+func toRGBA(img image.Image) *image.RGBA {
+	if rgba, ok := img.(*image.RGBA); ok && rgba.Rect.Min == (image.Point{}) {
+		return rgba
+	}
+	b := img.Bounds()
+	rgba := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(rgba, rgba.Rect, img, b.Min, draw.Src)
+	return rgba
+}
+
+// resizeImage 按区域平均（盒式滤波）缩小图片
+func resizeImage(img image.Image, width, height int) *image.RGBA {
+	src := toRGBA(img)
+	srcW, srcH := src.Rect.Dx(), src.Rect.Dy()
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		sy0 := y * srcH / height
+		sy1 := max(sy0+1, (y+1)*srcH/height)
+		for x := 0; x < width; x++ {
+			sx0 := x * srcW / width
+			sx1 := max(sx0+1, (x+1)*srcW/width)
+			var sum [4]int
+			for sy := sy0; sy < sy1; sy++ {
+				row := src.Pix[sy*src.Stride:]
+				for sx := sx0; sx < sx1; sx++ {
+					p := row[sx*4 : sx*4+4]
+					sum[0] += int(p[0])
+					sum[1] += int(p[1])
+					sum[2] += int(p[2])
+					sum[3] += int(p[3])
+				}
+			}
+			n := (sy1 - sy0) * (sx1 - sx0)
+			d := dst.Pix[y*dst.Stride+x*4:]
+			for i := 0; i < 4; i++ {
+				d[i] = uint8(sum[i] / n)
+			}
+		}
+	}
+	return dst
+}
+
+// applyOrientation 按 EXIF 方向（2-8）旋转或翻转图片
+func applyOrientation(img image.Image, orientation int) *image.RGBA {
+	src := toRGBA(img)
+	w, h := src.Rect.Dx(), src.Rect.Dy()
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var dx, dy int
+			switch orientation {
+			case 2: // 水平翻转
+				dx, dy = w-1-x, y
+			case 3: // 旋转 180°
+				dx, dy = w-1-x, h-1-y
+			case 4: // 垂直翻转
+				dx, dy = x, h-1-y
+			case 5: // 沿主对角线翻转
+				dx, dy = y, x
+			case 6: // 顺时针旋转 90°
+				dx, dy = h-1-y, x
+			case 7: // 沿副对角线翻转
+				dx, dy = h-1-y, w-1-x
+			case 8: // 逆时针旋转 90°
+				dx, dy = y, w-1-x
+			default:
+				dx, dy = x, y
+			}
+			copy(dst.Pix[dy*dst.Stride+dx*4:dy*dst.Stride+dx*4+4], src.Pix[y*src.Stride+x*4:y*src.Stride+x*4+4])
+		}
+	}
+	return dst
+}
+
+// jpegSegments 遍历 JPEG 扫描数据之前的标记段，fn 返回 false 时停止
+// 返回扫描数据（SOS）开始的位置，格式无效时返回 -1
+func jpegSegments(data []byte, fn func(marker byte, start, end int) bool) int {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return -1
+	}
+	pos := 2
+	for pos+4 <= len(data) {
+		if data[pos] != 0xFF {
+			return -1
+		}
+		marker := data[pos+1]
+		if marker == 0xFF {
+			pos++ // 填充字节
+			continue
+		}
+		if marker == 0xDA {
+			return pos
+		}
+		length := int(binary.BigEndian.Uint16(data[pos+2 : pos+4]))
+		end := pos + 2 + length
+		if length < 2 || end > len(data) {
+			return -1
+		}
+		if !fn(marker, pos, end) {
+			return pos
+		}
+		pos = end
+	}
+	return -1
+}
+
+// jpegOrientation 读取 JPEG EXIF 中的方向标签，没有或无效时返回 1
+func jpegOrientation(data []byte) int {
+	orientation := 1
+	jpegSegments(data, func(marker byte, start, end int) bool {
+		segment := data[start+4 : end]
+		if marker != 0xE1 || !bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return true
+		}
+		tiff := segment[6:]
+		if len(tiff) < 8 {
+			return false
+		}
+		var order binary.ByteOrder
+		switch string(tiff[:2]) {
+		case "II":
+			order = binary.LittleEndian
+		case "MM":
+			order = binary.BigEndian
+		default:
+			return false
+		}
+		ifd := int(order.Uint32(tiff[4:8]))
+		if ifd+2 > len(tiff) {
+			return false
+		}
+		count := int(order.Uint16(tiff[ifd : ifd+2]))
+		for i := 0; i < count; i++ {
+			entry := ifd + 2 + i*12
+			if entry+12 > len(tiff) {
+				break
+			}
+			if order.Uint16(tiff[entry:entry+2]) == 0x0112 {
+				if v := int(order.Uint16(tiff[entry+8 : entry+10])); v >= 1 && v <= 8 {
+					orientation = v
+				}
+				break
+			}
+		}
+		return false
+	})
+	return orientation
+}
+
+// stripJPEGMetadata 去除 JPEG 中的 EXIF/XMP（APP1）、IPTC（APP13）和注释段
+// 保留 JFIF（APP0）、ICC 配置（APP2）和 Adobe（APP14）等影响解码的段
+func stripJPEGMetadata(data []byte) ([]byte, bool) {
+	var drop [][2]int
+	sos := jpegSegments(data, func(marker byte, start, end int) bool {
+		if marker == 0xE1 || marker == 0xED || marker == 0xFE {
+			drop = append(drop, [2]int{start, end})
+		}
+		return true
+	})
+	if sos < 0 || len(drop) == 0 {
+		return data, false
+	}
+	out := make([]byte, 0, len(data))
+	pos := 0
+	for _, seg := range drop {
+		out = append(out, data[pos:seg[0]]...)
+		pos = seg[1]
+	}
+	return append(out, data[pos:]...), true
+}
+
+// pngMetadataChunks 去除的 PNG 元数据块
+var pngMetadataChunks = map[string]bool{"tEXt": true, "zTXt": true, "iTXt": true, "eXIf": true, "tIME": true}
+
+// stripPNGMetadata 去除 PNG 中的文本、EXIF 和时间戳块
+func stripPNGMetadata(data []byte) ([]byte, bool) {
+	const signatureLen = 8
+	out := make([]byte, 0, len(data))
+	out = append(out, data[:signatureLen]...)
+	stripped := false
+	for pos := signatureLen; pos < len(data); {
+		if pos+12 > len(data) {
+			return data, false
+		}
+		length := int(binary.BigEndian.Uint32(data[pos : pos+4]))
+		end := pos + 12 + length
+		if length < 0 || end > len(data) {
+			return data, false
+		}
+		if pngMetadataChunks[string(data[pos+4:pos+8])] {
+			stripped = true
+		} else {
+			out = append(out, data[pos:end]...)
+		}
+		pos = end
+	}
+	if !stripped {
+		return data, false
+	}
+	return out, true
+}
+
+// PreprocessRequestImages 预处理请求消息中的所有图片（包括 tool_result 中的图片），原地替换图片数据源
+// URL 类型的图片先下载为 base64；返回按图片顺序编号的变更说明，图片数量超过上限或图片无效时返回错误
+func PreprocessRequestImages(ctx context.Context, messages []types.AnthropicRequestMessage, opts config.ImageOptions) ([]string, error) {
+	var report []string
+	count := 0
+	process := func(source *types.ImageSource) (*types.ImageSource, error) {
+		count++
+		if opts.MaxImages > 0 && count > opts.MaxImages {
+			return nil, fmt.Errorf("图片数量过多，单个请求最多 %d 张", opts.MaxImages)
+		}
+		var fetched []string
+		if source != nil && source.Type == "url" {
+			var err error
+			if source, err = FetchImageURL(ctx, source.URL, opts.Fetch); err != nil {
+				return nil, fmt.Errorf("第 %d 张图片获取失败: %v", count, err)
+			}
+			fetched = []string{"fetched from URL"}
+		}
+		result, changes, err := PreprocessImage(source, opts)
+		if err != nil {
+			return nil, fmt.Errorf("第 %d 张图片预处理失败: %v", count, err)
+		}
+		changes = append(fetched, changes...)
+		if len(changes) > 0 {
+			report = append(report, fmt.Sprintf("image %d: %s", count, strings.Join(changes, ", ")))
+		}
+		return result, nil
+	}
+
+	var walkBlocks func(blocks []any) error
+	walkBlocks = func(blocks []any) error {
+		for _, item := range blocks {
+			block, ok := item.(map[string]any)
+			if !ok {
+				continue
+			}
+			switch block["type"] {
+			case "image":
+				sourceMap, ok := block["source"].(map[string]any)
+				if !ok {
+					continue
+				}
+				source := &types.ImageSource{}
+				source.Type, _ = sourceMap["type"].(string)
+				source.MediaType, _ = sourceMap["media_type"].(string)
+				source.Data, _ = sourceMap["data"].(string)
+				source.URL, _ = sourceMap["url"].(string)
+				result, err := process(source)
+				if err != nil {
+					return err
+				}
+				if result != source {
+					block["source"] = map[string]any{"type": result.Type, "media_type": result.MediaType, "data": result.Data}
+				}
+			case "image_url":
+				// 非标准的 OpenAI 图片块，转换为 image 块后处理
+				imageURL, ok := block["image_url"].(map[string]any)
+				if !ok {
+					continue
+				}
+				source, err := ConvertImageURLToImageSource(imageURL)
+				if err != nil {
+					continue // 由请求转换阶段报告
+				}
+				result, err := process(source)
+				if err != nil {
+					return err
+				}
+				delete(block, "image_url")
+				block["type"] = "image"
+				block["source"] = map[string]any{"type": result.Type, "media_type": result.MediaType, "data": result.Data}
+			case "tool_result":
+				if nested, ok := block["content"].([]any); ok {
+					if err := walkBlocks(nested); err != nil {
+						return err
+					}
+				}
+			}
+		}
+		return nil
+	}
+
+	for _, msg := range messages {
+		switch content := msg.Content.(type) {
+		case []any:
+			if err := walkBlocks(content); err != nil {
+				return nil, err
+			}
+		case []types.ContentBlock:
+			for i := range content {
+				if content[i].Type != "image" || content[i].Source == nil {
+					continue
+				}
+				result, err := process(content[i].Source)
+				if err != nil {
+					return nil, err
+				}
+				content[i].Source = result
+			}
+		}
+	}
+	return report, nil
+}
+
+// imageCacheSize 预处理结果缓存的条目数
+// 同一图片会随对话历史在每轮请求中重复出现，只缓存发生了修改的图片
+const imageCacheSize = 16
+
+type cachedImage struct {
+	source  *types.ImageSource
+	changes []string
+}
+
+type imageResultCache struct {
+	mu      sync.Mutex
+	entries map[[sha256.Size]byte]cachedImage
+	order   [][sha256.Size]byte
+}
+
+var imageCache = &imageResultCache{entries: make(map[[sha256.Size]byte]cachedImage)}
+
+// imageCacheKey 缓存键包含图片内容、声明的格式和预处理参数
+func imageCacheKey(data []byte, declaredType string, opts config.ImageOptions) [sha256.Size]byte {
+	opts.Fetch = config.ImageFetchOptions{} // 获取参数不影响预处理结果
+	h := sha256.New()
+	h.Write(data)
+	fmt.Fprintf(h, "|%s|%+v", declaredType, opts)
+	var key [sha256.Size]byte
+	copy(key[:], h.Sum(nil))
+	return key
+}
+
+func (c *imageResultCache) get(key [sha256.Size]byte) (cachedImage, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[key]
+	return entry, ok
+}
+
+func (c *imageResultCache) put(key [sha256.Size]byte, entry cachedImage) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, exists := c.entries[key]; exists {
+		return
+	}
+	if len(c.order) >= imageCacheSize {
+		delete(c.entries, c.order[0])
+		c.order = c.order[1:]
+	}
+	c.entries[key] = entry
+	c.order = append(c.order, key)
+}
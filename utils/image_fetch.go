@@ -0,0 +1,234 @@
+package utils
+
+import (
+	"container/list"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"syscall"
+
+	"kiro2api/config"
+	"kiro2api/types"
+)
+
+// 远程图片获取
+// 上游只接受 base64 图片：URL 类型的图片（Anthropic source.type=url、OpenAI http(s) image_url）由代理下载后按普通图片处理。
+// 默认关闭；开启后按主机允许/禁止列表过滤，拒绝连接内网、回环等私有地址（在 DNS 解析后的连接阶段检查，重定向同样适用），
+// 限制大小和超时，按内容识别图片格式（不信任 Content-Type），结果缓存在内存 LRU 中
+
+const (
+	// maxImageFetchRedirects 获取图片时允许的最大重定向次数
+	maxImageFetchRedirects = 3
+	// imageFetchCacheMaxBytes 远程图片缓存的总大小上限（base64 字节）
+	imageFetchCacheMaxBytes = 128 * 1024 * 1024
+)
+
+// privateNetworks IsPrivate/IsLoopback 等方法未覆盖的保留地址段
+var privateNetworks = func() []*net.IPNet {
+	var nets []*net.IPNet
+	for _, cidr := range []string{
+		"0.0.0.0/8",     // 本网络
+		"100.64.0.0/10", // 运营商级 NAT
+		"192.0.0.0/24",  // IETF 协议分配
+		"198.18.0.0/15", // 基准测试
+		"64:ff9b::/96",  // NAT64（可映射到内网 IPv4）
+	} {
+		_, n, _ := net.ParseCIDR(cidr)
+		nets = append(nets, n)
+	}
+	return nets
+}()
+
+// isPrivateIP 判断地址是否属于内网、回环、链路本地等不允许访问的范围
+func isPrivateIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() || ip.IsMulticast() {
+		return true
+	}
+	for _, n := range privateNetworks {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// hostMatches 判断主机是否匹配列表中的任一项（精确匹配，*.example.com 或 .example.com 匹配子域名）
+func hostMatches(host string, patterns []string) bool {
+	for _, pattern := range patterns {
+		switch {
+		case pattern == host:
+			return true
+		case strings.HasPrefix(pattern, "*.") && strings.HasSuffix(host, pattern[1:]):
+			return true
+		case strings.HasPrefix(pattern, ".") && strings.HasSuffix(host, pattern):
+			return true
+		}
+	}
+	return false
+}
+
+// checkImageURL 校验图片 URL 的协议和主机
+func checkImageURL(rawURL string, opts config.ImageFetchOptions) (*url.URL, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("无效的图片 URL: %v", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("图片 URL 仅支持 http 和 https 协议: %s", u.Scheme)
+	}
+	if u.User != nil {
+		return nil, errors.New("图片 URL 不能包含用户信息")
+	}
+	host := strings.ToLower(u.Hostname())
+	if host == "" {
+		return nil, errors.New("图片 URL 缺少主机")
+	}
+	if hostMatches(host, opts.DeniedHosts) {
+		return nil, fmt.Errorf("图片 URL 的主机在禁止列表中: %s", host)
+	}
+	if len(opts.AllowedHosts) > 0 && !hostMatches(host, opts.AllowedHosts) {
+		return nil, fmt.Errorf("图片 URL 的主机不在允许列表中: %s", host)
+	}
+	return u, nil
+}
+
+// newImageFetchClient 创建获取图片的 HTTP 客户端
+// 不使用代理（代理会绕过地址检查），在建立连接时检查解析后的地址，防止 DNS 重绑定
+func newImageFetchClient(opts config.ImageFetchOptions) *http.Client {
+	dialer := &net.Dialer{Timeout: opts.Timeout}
+	if !opts.AllowPrivate {
+		dialer.Control = func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || isPrivateIP(ip) {
+				return fmt.Errorf("禁止访问私有地址: %s", host)
+			}
+			return nil
+		}
+	}
+	return &http.Client{
+		Timeout: opts.Timeout,
+		Transport: &http.Transport{
+			DialContext:           dialer.DialContext,
+			TLSHandshakeTimeout:   opts.Timeout,
+			ResponseHeaderTimeout: opts.Timeout,
+			DisableKeepAlives:     true,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) > maxImageFetchRedirects {
+				return fmt.Errorf("重定向次数过多（最多 %d 次）", maxImageFetchRedirects)
+			}
+			_, err := checkImageURL(req.URL.String(), opts)
+			return err
+		},
+	}
+}
+
+// FetchImageURL 下载 URL 指向的图片并转换为 base64 数据源
+func FetchImageURL(ctx context.Context, rawURL string, opts config.ImageFetchOptions) (*types.ImageSource, error) {
+	if !opts.Enabled {
+		return nil, errors.New("未启用 URL 类型图片的获取（IMAGE_URL_FETCH_ENABLED）")
+	}
+	u, err := checkImageURL(rawURL, opts)
+	if err != nil {
+		return nil, err
+	}
+	cacheKey := u.String()
+	if source, ok := imageFetchCache.get(cacheKey); ok {
+		return source, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, cacheKey, nil)
+	if err != nil {
+		return nil, fmt.Errorf("创建图片请求失败: %v", err)
+	}
+	req.Header.Set("Accept", "image/*")
+	resp, err := newImageFetchClient(opts).Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("下载图片失败: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("下载图片失败: HTTP %d", resp.StatusCode)
+	}
+	if resp.ContentLength > int64(opts.MaxBytes) {
+		return nil, fmt.Errorf("远程图片过大: %d 字节，最大支持 %d 字节", resp.ContentLength, opts.MaxBytes)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, int64(opts.MaxBytes)+1))
+	if err != nil {
+		return nil, fmt.Errorf("读取图片失败: %v", err)
+	}
+	if len(data) > opts.MaxBytes {
+		return nil, fmt.Errorf("远程图片过大: 超过 %d 字节", opts.MaxBytes)
+	}
+
+	mediaType, err := DetectImageFormat(data)
+	if err != nil || !IsSupportedImageFormat(mediaType) {
+		return nil, fmt.Errorf("URL 内容不是支持的图片格式（Content-Type: %s）", resp.Header.Get("Content-Type"))
+	}
+
+	source := &types.ImageSource{
+		Type:      "base64",
+		MediaType: mediaType,
+		Data:      base64.StdEncoding.EncodeToString(data),
+	}
+	imageFetchCache.put(cacheKey, source, opts.CacheSize)
+	return source, nil
+}
+
+// imageFetchLRU 远程图片缓存（按 URL，限制条目数和总大小）
+type imageFetchLRU struct {
+	mu    sync.Mutex
+	ll    *list.List
+	items map[string]*list.Element
+	bytes int
+}
+
+type imageFetchEntry struct {
+	url    string
+	source *types.ImageSource
+}
+
+var imageFetchCache = &imageFetchLRU{ll: list.New(), items: make(map[string]*list.Element)}
+
+func (c *imageFetchLRU) get(key string) (*types.ImageSource, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	c.ll.MoveToFront(elem)
+	return elem.Value.(*imageFetchEntry).source, true
+}
+
+func (c *imageFetchLRU) put(key string, source *types.ImageSource, capacity int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if capacity <= 0 || len(source.Data) > imageFetchCacheMaxBytes {
+		return
+	}
+	if _, exists := c.items[key]; exists {
+		return
+	}
+	c.items[key] = c.ll.PushFront(&imageFetchEntry{url: key, source: source})
+	c.bytes += len(source.Data)
+	for c.ll.Len() > capacity || c.bytes > imageFetchCacheMaxBytes {
+		oldest := c.ll.Back()
+		entry := oldest.Value.(*imageFetchEntry)
+		c.ll.Remove(oldest)
+		delete(c.items, entry.url)
+		c.bytes -= len(entry.source.Data)
+	}
+}
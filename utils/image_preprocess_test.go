@@ -0,0 +1,220 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+
+	"kiro2api/config"
+	"kiro2api/types"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testImageOptions() config.ImageOptions {
+	return config.ImageOptions{MaxLongEdge: 1568, MaxPixels: 1150000, MaxBytes: 5 * 1024 * 1024, MaxImages: 20}
+}
+
+func encodeTestPNG(t *testing.T, w, h int) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.Set(x, y, color.RGBA{R: uint8(x), G: uint8(y), B: 0x80, A: 0xFF})
+		}
+	}
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, img))
+	return buf.Bytes()
+}
+
+func encodeTestJPEG(t *testing.T, w, h int) []byte {
+	var buf bytes.Buffer
+	require.NoError(t, jpeg.Encode(&buf, image.NewGray(image.Rect(0, 0, w, h)), nil))
+	return buf.Bytes()
+}
+
+// buildTestBMP 构造 24 位自底向上存储的 BMP
+func buildTestBMP(w, h int, pixel func(x, y int) color.RGBA) []byte {
+	stride := (w*3 + 3) &^ 3
+	data := make([]byte, 54+stride*h)
+	copy(data, "BM")
+	binary.LittleEndian.PutUint32(data[2:], uint32(len(data)))
+	binary.LittleEndian.PutUint32(data[10:], 54)
+	binary.LittleEndian.PutUint32(data[14:], 40)
+	binary.LittleEndian.PutUint32(data[18:], uint32(w))
+	binary.LittleEndian.PutUint32(data[22:], uint32(h))
+	binary.LittleEndian.PutUint16(data[26:], 1)
+	binary.LittleEndian.PutUint16(data[28:], 24)
+	for y := 0; y < h; y++ {
+		row := data[54+(h-1-y)*stride:]
+		for x := 0; x < w; x++ {
+			c := pixel(x, y)
+			row[x*3], row[x*3+1], row[x*3+2] = c.B, c.G, c.R
+		}
+	}
+	return data
+}
+
+func base64Source(mediaType string, data []byte) *types.ImageSource {
+	return &types.ImageSource{Type: "base64", MediaType: mediaType, Data: base64.StdEncoding.EncodeToString(data)}
+}
+
+func decodeSource(t *testing.T, source *types.ImageSource) image.Image {
+	data, err := base64.StdEncoding.DecodeString(source.Data)
+	require.NoError(t, err)
+	img, _, err := image.Decode(bytes.NewReader(data))
+	require.NoError(t, err)
+	return img
+}
+
+func TestPreprocessImage_BMPToPNG(t *testing.T) {
+	bmp := buildTestBMP(3, 2, func(x, y int) color.RGBA {
+		if x == 0 && y == 0 {
+			return color.RGBA{R: 0xFF, A: 0xFF}
+		}
+		return color.RGBA{B: 0xFF, A: 0xFF}
+	})
+
+	result, changes, err := PreprocessImage(base64Source("image/bmp", bmp), testImageOptions())
+
+	require.NoError(t, err)
+	assert.Equal(t, "image/png", result.MediaType)
+	assert.Contains(t, changes, "bmp→png")
+	img := decodeSource(t, result)
+	assert.Equal(t, image.Rect(0, 0, 3, 2), img.Bounds())
+	r, _, b, _ := img.At(0, 0).RGBA()
+	assert.Equal(t, uint32(0xFFFF), r)
+	assert.Equal(t, uint32(0), b)
+	_, _, b, _ = img.At(2, 1).RGBA()
+	assert.Equal(t, uint32(0xFFFF), b)
+}
+
+func TestPreprocessImage_Downscale(t *testing.T) {
+	opts := testImageOptions()
+	opts.MaxLongEdge = 100
+
+	result, changes, err := PreprocessImage(base64Source("image/png", encodeTestPNG(t, 400, 200)), opts)
+
+	require.NoError(t, err)
+	assert.Equal(t, []string{"resized 400x200→100x50"}, changes)
+	assert.Equal(t, image.Rect(0, 0, 100, 50), decodeSource(t, result).Bounds())
+}
+
+func TestPreprocessImage_PixelBudget(t *testing.T) {
+	opts := testImageOptions()
+	opts.MaxPixels = 100 * 100
+
+	result, _, err := PreprocessImage(base64Source("image/png", encodeTestPNG(t, 400, 400)), opts)
+
+	require.NoError(t, err)
+	bounds := decodeSource(t, result).Bounds()
+	assert.LessOrEqual(t, bounds.Dx()*bounds.Dy(), opts.MaxPixels)
+}
+
+func TestPreprocessImage_Unchanged(t *testing.T) {
+	source := base64Source("image/png", encodeTestPNG(t, 10, 10))
+
+	result, changes, err := PreprocessImage(source, testImageOptions())
+
+	require.NoError(t, err)
+	assert.Empty(t, changes)
+	assert.Same(t, source, result)
+}
+
+func TestPreprocessImage_FixesMediaType(t *testing.T) {
+	result, changes, err := PreprocessImage(base64Source("image/jpeg", encodeTestPNG(t, 10, 10)), testImageOptions())
+
+	require.NoError(t, err)
+	assert.Equal(t, "image/png", result.MediaType)
+	assert.Equal(t, []string{"media_type image/jpeg→image/png"}, changes)
+}
+
+// withJPEGSegment 在 SOI 之后插入一个标记段
+func withJPEGSegment(data []byte, marker byte, payload []byte) []byte {
+	segment := []byte{0xFF, marker, 0, 0}
+	binary.BigEndian.PutUint16(segment[2:], uint16(len(payload)+2))
+	out := append([]byte{}, data[:2]...)
+	out = append(out, segment...)
+	out = append(out, payload...)
+	return append(out, data[2:]...)
+}
+
+// exifOrientation 构造只包含方向标签的 EXIF 载荷（小端）
+func exifOrientation(orientation uint16) []byte {
+	payload := []byte("Exif\x00\x00II*\x00\x08\x00\x00\x00\x01\x00")
+	entry := make([]byte, 12)
+	binary.LittleEndian.PutUint16(entry[0:], 0x0112)
+	binary.LittleEndian.PutUint16(entry[2:], 3)
+	binary.LittleEndian.PutUint32(entry[4:], 1)
+	binary.LittleEndian.PutUint16(entry[8:], orientation)
+	return append(append(payload, entry...), 0, 0, 0, 0)
+}
+
+func TestPreprocessImage_StripsJPEGMetadata(t *testing.T) {
+	data := withJPEGSegment(encodeTestJPEG(t, 8, 8), 0xFE, []byte("secret comment"))
+	data = withJPEGSegment(data, 0xE1, exifOrientation(1))
+
+	result, changes, err := PreprocessImage(base64Source("image/jpeg", data), testImageOptions())
+
+	require.NoError(t, err)
+	assert.Equal(t, []string{"metadata stripped"}, changes)
+	out, _ := base64.StdEncoding.DecodeString(result.Data)
+	assert.NotContains(t, string(out), "secret comment")
+	assert.NotContains(t, string(out), "Exif")
+	assert.Equal(t, image.Rect(0, 0, 8, 8), decodeSource(t, result).Bounds())
+}
+
+func TestPreprocessImage_AppliesOrientation(t *testing.T) {
+	data := withJPEGSegment(encodeTestJPEG(t, 16, 8), 0xE1, exifOrientation(6))
+
+	result, changes, err := PreprocessImage(base64Source("image/jpeg", data), testImageOptions())
+
+	require.NoError(t, err)
+	assert.Contains(t, changes, "applied EXIF orientation 6")
+	assert.Equal(t, image.Rect(0, 0, 8, 16), decodeSource(t, result).Bounds())
+}
+
+func TestStripPNGMetadata(t *testing.T) {
+	data := encodeTestPNG(t, 4, 4)
+	chunk := []byte{0, 0, 0, 5, 't', 'E', 'X', 't', 'k', 0, 'v', 'a', 'l', 0, 0, 0, 0}
+	withText := append(append(append([]byte{}, data[:33]...), chunk...), data[33:]...) // 签名(8) + IHDR(25) 之后
+
+	stripped, ok := stripPNGMetadata(withText)
+
+	assert.True(t, ok)
+	assert.Equal(t, data, stripped)
+	_, ok = stripPNGMetadata(data)
+	assert.False(t, ok)
+}
+
+func TestPreprocessRequestImages_ToolResultAndLimit(t *testing.T) {
+	bmp := base64.StdEncoding.EncodeToString(buildTestBMP(2, 2, func(x, y int) color.RGBA { return color.RGBA{A: 0xFF} }))
+	imageBlock := func() map[string]any {
+		return map[string]any{"type": "image", "source": map[string]any{"type": "base64", "media_type": "image/bmp", "data": bmp}}
+	}
+	nested := imageBlock()
+	messages := []types.AnthropicRequestMessage{
+		{Role: "user", Content: []any{
+			map[string]any{"type": "tool_result", "tool_use_id": "t1", "content": []any{nested}},
+			imageBlock(),
+		}},
+	}
+
+	report, err := PreprocessRequestImages(context.Background(), messages, testImageOptions())
+
+	require.NoError(t, err)
+	assert.Len(t, report, 2)
+	assert.Equal(t, "image/png", nested["source"].(map[string]any)["media_type"])
+
+	opts := testImageOptions()
+	opts.MaxImages = 1
+	_, err = PreprocessRequestImages(context.Background(), messages, opts)
+	assert.ErrorContains(t, err, "图片数量过多")
+}
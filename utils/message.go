@@ -41,6 +41,9 @@ func ParseToolResultContent(content any) string {
 				} else if text, ok := itemVal["text"].(string); ok && text != "" {
 					// 处理包含text字段但没有type的对象
 					result.WriteString(text + "\n")
+				} else if itemType == "image" {
+					// 图片块只保留占位，不序列化图片数据
+					result.WriteString(imageBlockPlaceholder(itemVal) + "\n")
 				} else {
 					// 其他结构化数据序列化为JSON
 					if data, err := sonic.Marshal(itemVal); err == nil {
@@ -76,6 +79,10 @@ func ParseToolResultContent(content any) string {
 			}
 		}
 
+		if contentType, _ := v["type"].(string); contentType == "image" {
+			return imageBlockPlaceholder(v)
+		}
+
 		// 检查是否有直接的text字段
 		if text, ok := v["text"].(string); ok {
 			if text == "" {
@@ -142,6 +149,8 @@ func GetMessageContent(content any) (string, error) {
 							} else {
 								texts = append(texts, "[图片]")
 							}
+						case "document":
+							texts = append(texts, documentPlaceholder(cb))
 						}
 					}
 				}
@@ -186,6 +195,8 @@ func GetMessageContent(content any) (string, error) {
 				} else {
 					texts = append(texts, "[图片]")
 				}
+			case "document":
+				texts = append(texts, documentPlaceholder(cb))
 			}
 		}
 		if len(texts) == 0 && hasImage {
@@ -199,3 +210,21 @@ func GetMessageContent(content any) (string, error) {
 		return "", fmt.Errorf("unsupported content type: %T", v)
 	}
 }
+
+// imageBlockPlaceholder 图片块（map 格式）的文本占位
+func imageBlockPlaceholder(block map[string]any) string {
+	if source, ok := block["source"].(map[string]any); ok {
+		if mediaType, ok := source["media_type"].(string); ok && mediaType != "" {
+			return fmt.Sprintf("[图片: %s格式]", mediaType)
+		}
+	}
+	return "[图片]"
+}
+
+// documentPlaceholder 文档块的文本占位（文档内容在请求转换时单独提取）
+func documentPlaceholder(cb types.ContentBlock) string {
+	if cb.Title != nil && *cb.Title != "" {
+		return fmt.Sprintf("[文档: %s]", *cb.Title)
+	}
+	return "[文档]"
+}
@@ -0,0 +1,918 @@
+package utils
+
+import (
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+// PDF 文本提取
+// 仅依赖标准库的简化实现：解析文档对象（包括压缩对象流），按页面树顺序解码 FlateDecode 内容流，
+// 提取 Tj/TJ/'/" 文本操作符中的字符串。字体带 ToUnicode CMap 时按映射解码，否则按单字节编码解码。
+// 不支持加密文档、扫描件（图片中的文字）和表单 XObject 中的文本
+
+// maxPDFStreamSize 单个流解压后的最大字节数，防止压缩炸弹
+const maxPDFStreamSize = 64 * 1024 * 1024
+
+type (
+	pdfName    string
+	pdfKeyword string // 操作符、关键字和分隔符
+	pdfDict    map[string]any
+	pdfRef     struct{ num, gen int }
+	pdfStream  struct {
+		dict pdfDict
+		raw  []byte
+	}
+)
+
+// pdfLexer PDF 词法分析器
+type pdfLexer struct {
+	data []byte
+	pos  int
+}
+
+func isPDFSpace(c byte) bool {
+	return c == ' ' || c == '\n' || c == '\r' || c == '\t' || c == '\f' || c == 0
+}
+
+func isPDFDelimiter(c byte) bool {
+	return strings.IndexByte("()<>[]{}/%", c) >= 0
+}
+
+// skipSpace 跳过空白和注释
+func (lx *pdfLexer) skipSpace() {
+	// 越界的起始位置（来自文档中的偏移量）视为已到末尾
+	if lx.pos < 0 || lx.pos > len(lx.data) {
+		lx.pos = len(lx.data)
+	}
+	for lx.pos < len(lx.data) {
+		c := lx.data[lx.pos]
+		if isPDFSpace(c) {
+			lx.pos++
+			continue
+		}
+		if c == '%' {
+			for lx.pos < len(lx.data) && lx.data[lx.pos] != '\n' && lx.data[lx.pos] != '\r' {
+				lx.pos++
+			}
+			continue
+		}
+		return
+	}
+}
+
+// next 读取下一个词法单元，到达末尾时返回 io.EOF
+func (lx *pdfLexer) next() (any, error) {
+	lx.skipSpace()
+	if lx.pos >= len(lx.data) {
+		return nil, io.EOF
+	}
+	c := lx.data[lx.pos]
+	switch {
+	case c == '<' && lx.pos+1 < len(lx.data) && lx.data[lx.pos+1] == '<':
+		lx.pos += 2
+		return pdfKeyword("<<"), nil
+	case c == '>' && lx.pos+1 < len(lx.data) && lx.data[lx.pos+1] == '>':
+		lx.pos += 2
+		return pdfKeyword(">>"), nil
+	case c == '[' || c == ']' || c == '{' || c == '}':
+		lx.pos++
+		return pdfKeyword(string(c)), nil
+	case c == '/':
+		lx.pos++
+		return lx.readName(), nil
+	case c == '(':
+		lx.pos++
+		return lx.readLiteralString(), nil
+	case c == '<':
+		lx.pos++
+		return lx.readHexString(), nil
+	case c == ')' || c == '>':
+		lx.pos++
+		return pdfKeyword(string(c)), nil
+	}
+
+	start := lx.pos
+	for lx.pos < len(lx.data) && !isPDFSpace(lx.data[lx.pos]) && !isPDFDelimiter(lx.data[lx.pos]) {
+		lx.pos++
+	}
+	word := string(lx.data[start:lx.pos])
+	if n, err := strconv.ParseFloat(word, 64); err == nil && (c == '+' || c == '-' || c == '.' || (c >= '0' && c <= '9')) {
+		return n, nil
+	}
+	return pdfKeyword(word), nil
+}
+
+func (lx *pdfLexer) readName() pdfName {
+	var sb strings.Builder
+	for lx.pos < len(lx.data) {
+		c := lx.data[lx.pos]
+		if isPDFSpace(c) || isPDFDelimiter(c) {
+			break
+		}
+		if c == '#' && lx.pos+2 < len(lx.data) {
+			if v, err := strconv.ParseUint(string(lx.data[lx.pos+1:lx.pos+3]), 16, 8); err == nil {
+				sb.WriteByte(byte(v))
+				lx.pos += 3
+				continue
+			}
+		}
+		sb.WriteByte(c)
+		lx.pos++
+	}
+	return pdfName(sb.String())
+}
+
+func (lx *pdfLexer) readLiteralString() []byte {
+	var out []byte
+	depth := 1
+	for lx.pos < len(lx.data) {
+		c := lx.data[lx.pos]
+		lx.pos++
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return out
+			}
+		case '\\':
+			if lx.pos >= len(lx.data) {
+				return out
+			}
+			e := lx.data[lx.pos]
+			lx.pos++
+			switch e {
+			case 'n':
+				out = append(out, '\n')
+			case 'r':
+				out = append(out, '\r')
+			case 't':
+				out = append(out, '\t')
+			case 'b':
+				out = append(out, '\b')
+			case 'f':
+				out = append(out, '\f')
+			case '\r':
+				// 反斜杠续行
+				if lx.pos < len(lx.data) && lx.data[lx.pos] == '\n' {
+					lx.pos++
+				}
+			case '\n':
+			default:
+				if e >= '0' && e <= '7' {
+					v := int(e - '0')
+					for i := 0; i < 2 && lx.pos < len(lx.data) && lx.data[lx.pos] >= '0' && lx.data[lx.pos] <= '7'; i++ {
+						v = v*8 + int(lx.data[lx.pos]-'0')
+						lx.pos++
+					}
+					out = append(out, byte(v))
+				} else {
+					out = append(out, e)
+				}
+			}
+			continue
+		}
+		out = append(out, c)
+	}
+	return out
+}
+
+func (lx *pdfLexer) readHexString() []byte {
+	var digits []byte
+	for lx.pos < len(lx.data) && lx.data[lx.pos] != '>' {
+		c := lx.data[lx.pos]
+		if (c >= '0' && c <= '9') || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F') {
+			digits = append(digits, c)
+		}
+		lx.pos++
+	}
+	if lx.pos < len(lx.data) {
+		lx.pos++ // 跳过 '>'
+	}
+	if len(digits)%2 == 1 {
+		digits = append(digits, '0')
+	}
+	out := make([]byte, len(digits)/2)
+	for i := range out {
+		v, _ := strconv.ParseUint(string(digits[2*i:2*i+2]), 16, 8)
+		out[i] = byte(v)
+	}
+	return out
+}
+
+// parseObject 解析一个完整的 PDF 对象
+func (lx *pdfLexer) parseObject() (any, error) {
+	tok, err := lx.next()
+	if err != nil {
+		return nil, err
+	}
+	return lx.parseObjectFrom(tok, 0)
+}
+
+// parseObjectFrom 从已读取的词法单元开始解析对象（数组、字典、间接引用）
+func (lx *pdfLexer) parseObjectFrom(tok any, depth int) (any, error) {
+	if depth > 64 {
+		return nil, errors.New("PDF 对象嵌套过深")
+	}
+	switch t := tok.(type) {
+	case pdfKeyword:
+		switch t {
+		case "<<":
+			dict := pdfDict{}
+			for {
+				keyTok, err := lx.next()
+				if err != nil {
+					return dict, err
+				}
+				if keyTok == pdfKeyword(">>") {
+					return dict, nil
+				}
+				key, ok := keyTok.(pdfName)
+				if !ok {
+					continue // 容错：跳过无效的键
+				}
+				valTok, err := lx.next()
+				if err != nil {
+					return dict, err
+				}
+				if valTok == pdfKeyword(">>") {
+					return dict, nil
+				}
+				val, err := lx.parseObjectFrom(valTok, depth+1)
+				if err != nil {
+					return dict, err
+				}
+				dict[string(key)] = val
+			}
+		case "[":
+			var arr []any
+			for {
+				itemTok, err := lx.next()
+				if err != nil {
+					return arr, err
+				}
+				if itemTok == pdfKeyword("]") {
+					return arr, nil
+				}
+				item, err := lx.parseObjectFrom(itemTok, depth+1)
+				if err != nil {
+					return arr, err
+				}
+				arr = append(arr, item)
+			}
+		case "true":
+			return true, nil
+		case "false":
+			return false, nil
+		case "null":
+			return nil, nil
+		}
+		return t, nil
+
+	case float64:
+		// 尝试识别间接引用 "num gen R"
+		saved := lx.pos
+		if gen, err := lx.next(); err == nil {
+			if genNum, ok := gen.(float64); ok {
+				if r, err := lx.next(); err == nil && r == pdfKeyword("R") {
+					return pdfRef{num: int(t), gen: int(genNum)}, nil
+				}
+			}
+		}
+		lx.pos = saved
+		return t, nil
+	}
+	return tok, nil
+}
+
+// pdfDocument 解析后的 PDF 文档
+type pdfDocument struct {
+	objects  map[int]any
+	trailers []pdfDict
+}
+
+var pdfObjectHeader = regexp.MustCompile(`(\d+)\s+(\d+)\s+obj\b`)
+
+// loadPDF 扫描并解析文档中的所有对象
+func loadPDF(data []byte) (*pdfDocument, error) {
+	if !bytes.Contains(data[:min(len(data), 1024)], []byte("%PDF-")) {
+		return nil, errors.New("不是有效的 PDF 文件")
+	}
+
+	doc := &pdfDocument{objects: make(map[int]any)}
+	pos := 0
+	for pos < len(data) {
+		loc := pdfObjectHeader.FindSubmatchIndex(data[pos:])
+		if loc == nil {
+			break
+		}
+		num, _ := strconv.Atoi(string(data[pos+loc[2] : pos+loc[3]]))
+		lx := &pdfLexer{data: data, pos: pos + loc[1]}
+		obj, err := lx.parseObject()
+		if err != nil {
+			pos += loc[1]
+			continue
+		}
+
+		if dict, ok := obj.(pdfDict); ok {
+			lx.skipSpace()
+			if bytes.HasPrefix(data[lx.pos:], []byte("stream")) {
+				raw, end := readPDFStreamData(data, lx.pos+len("stream"), dict)
+				obj = &pdfStream{dict: dict, raw: raw}
+				lx.pos = end
+				if dict["Type"] == pdfName("XRef") {
+					doc.trailers = append(doc.trailers, dict)
+				}
+			}
+		}
+		doc.objects[num] = obj
+		pos = lx.pos
+	}
+
+	// 传统 trailer 字典
+	for idx := 0; ; {
+		i := bytes.Index(data[idx:], []byte("trailer"))
+		if i < 0 {
+			break
+		}
+		lx := &pdfLexer{data: data, pos: idx + i + len("trailer")}
+		if obj, err := lx.parseObject(); err == nil {
+			if dict, ok := obj.(pdfDict); ok {
+				doc.trailers = append(doc.trailers, dict)
+			}
+		}
+		idx += i + len("trailer")
+	}
+
+	for _, trailer := range doc.trailers {
+		if _, encrypted := trailer["Encrypt"]; encrypted {
+			return nil, errors.New("不支持加密的 PDF 文件")
+		}
+	}
+
+	doc.expandObjectStreams()
+	return doc, nil
+}
+
+// readPDFStreamData 读取 stream 关键字之后的流数据，返回数据和 endstream 之后的位置
+func readPDFStreamData(data []byte, start int, dict pdfDict) ([]byte, int) {
+	if start < len(data) && data[start] == '\r' {
+		start++
+	}
+	if start < len(data) && data[start] == '\n' {
+		start++
+	}
+
+	// 优先使用直接给出的 Length，校验其后确实是 endstream
+	if length, ok := pdfInt(dict["Length"]); ok && length <= len(data)-start {
+		end := start + length
+		rest := bytes.TrimLeft(data[end:min(len(data), end+32)], " \r\n\t")
+		if bytes.HasPrefix(rest, []byte("endstream")) {
+			return data[start:end], end + bytes.Index(data[end:], []byte("endstream")) + len("endstream")
+		}
+	}
+
+	i := bytes.Index(data[start:], []byte("endstream"))
+	if i < 0 {
+		return data[start:], len(data)
+	}
+	raw := bytes.TrimRight(data[start:start+i], "\r\n")
+	return raw, start + i + len("endstream")
+}
+
+// expandObjectStreams 展开压缩对象流（PDF 1.5+）中的对象
+func (doc *pdfDocument) expandObjectStreams() {
+	nums := make([]int, 0, len(doc.objects))
+	for num := range doc.objects {
+		nums = append(nums, num)
+	}
+	sort.Ints(nums)
+
+	for _, num := range nums {
+		stream, ok := doc.objects[num].(*pdfStream)
+		if !ok || stream.dict["Type"] != pdfName("ObjStm") {
+			continue
+		}
+		data, err := doc.decodeStream(stream)
+		if err != nil {
+			continue
+		}
+		n, okN := pdfInt(doc.resolve(stream.dict["N"]))
+		first, okFirst := pdfInt(doc.resolve(stream.dict["First"]))
+		if !okN || !okFirst || first > len(data) {
+			continue
+		}
+
+		header := &pdfLexer{data: data[:first]}
+		for i := 0; i < n; i++ {
+			numTok, err1 := header.next()
+			offTok, err2 := header.next()
+			if err1 != nil || err2 != nil {
+				break
+			}
+			objNum, ok1 := pdfInt(numTok)
+			offset, ok2 := pdfInt(offTok)
+			if !ok1 || !ok2 || offset >= len(data)-first {
+				continue
+			}
+			if _, exists := doc.objects[objNum]; exists {
+				continue // 文件中直接定义的对象优先
+			}
+			lx := &pdfLexer{data: data, pos: first + offset}
+			if obj, err := lx.parseObject(); err == nil {
+				doc.objects[objNum] = obj
+			}
+		}
+	}
+}
+
+// pdfInt 将数值对象转换为非负整数，负数、小数和超出范围的值返回 false
+func pdfInt(v any) (int, bool) {
+	f, ok := v.(float64)
+	if !ok || f < 0 || f > math.MaxInt32 || f != math.Trunc(f) {
+		return 0, false
+	}
+	return int(f), true
+}
+
+// resolve 解析间接引用
+func (doc *pdfDocument) resolve(v any) any {
+	for i := 0; i < 32; i++ {
+		ref, ok := v.(pdfRef)
+		if !ok {
+			return v
+		}
+		v = doc.objects[ref.num]
+	}
+	return nil
+}
+
+// dict 解析为字典（流对象返回其字典）
+func (doc *pdfDocument) dict(v any) pdfDict {
+	switch d := doc.resolve(v).(type) {
+	case pdfDict:
+		return d
+	case *pdfStream:
+		return d.dict
+	}
+	return nil
+}
+
+// decodeStream 按 Filter 解码流数据，仅支持 FlateDecode
+func (doc *pdfDocument) decodeStream(stream *pdfStream) ([]byte, error) {
+	var filters []any
+	switch f := doc.resolve(stream.dict["Filter"]).(type) {
+	case pdfName:
+		filters = []any{f}
+	case []any:
+		filters = f
+	}
+
+	data := stream.raw
+	for _, f := range filters {
+		switch doc.resolve(f) {
+		case pdfName("FlateDecode"), pdfName("Fl"):
+			r, err := zlib.NewReader(bytes.NewReader(data))
+			if err != nil {
+				return nil, fmt.Errorf("解压PDF流失败: %v", err)
+			}
+			out, err := io.ReadAll(io.LimitReader(r, maxPDFStreamSize))
+			_ = r.Close()
+			if err != nil && len(out) == 0 {
+				return nil, fmt.Errorf("解压PDF流失败: %v", err)
+			}
+			data = out
+		default:
+			return nil, fmt.Errorf("不支持的PDF流编码: %v", f)
+		}
+	}
+	return data, nil
+}
+
+// pdfPage 页面及其（可继承的）资源字典
+type pdfPage struct {
+	dict      pdfDict
+	resources pdfDict
+}
+
+// pages 按页面树顺序返回所有页面，超过 maxPages 时返回错误
+func (doc *pdfDocument) pages(maxPages int) ([]pdfPage, error) {
+	var root any
+	for _, trailer := range doc.trailers {
+		if catalog := doc.dict(trailer["Root"]); catalog != nil && doc.dict(catalog["Pages"]) != nil {
+			root = catalog["Pages"]
+			break
+		}
+	}
+
+	var pages []pdfPage
+	visited := make(map[pdfRef]bool)
+	var walk func(node any, resources pdfDict, depth int) error
+	walk = func(node any, resources pdfDict, depth int) error {
+		if ref, ok := node.(pdfRef); ok {
+			if visited[ref] {
+				return nil // 防止页面树中的循环引用
+			}
+			visited[ref] = true
+		}
+		dict := doc.dict(node)
+		if dict == nil || depth > 64 {
+			return nil
+		}
+		if res := doc.dict(dict["Resources"]); res != nil {
+			resources = res
+		}
+		if kids, ok := doc.resolve(dict["Kids"]).([]any); ok {
+			for _, kid := range kids {
+				if err := walk(kid, resources, depth+1); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		if len(pages) >= maxPages {
+			return fmt.Errorf("PDF 页数过多，最多支持 %d 页", maxPages)
+		}
+		pages = append(pages, pdfPage{dict: dict, resources: resources})
+		return nil
+	}
+
+	if root != nil {
+		if err := walk(root, nil, 0); err != nil {
+			return nil, err
+		}
+		return pages, nil
+	}
+
+	// 找不到页面树时按对象编号顺序收集页面
+	nums := make([]int, 0, len(doc.objects))
+	for num := range doc.objects {
+		nums = append(nums, num)
+	}
+	sort.Ints(nums)
+	for _, num := range nums {
+		if d, ok := doc.objects[num].(pdfDict); ok && d["Type"] == pdfName("Page") {
+			resources := doc.dict(d["Resources"])
+			for parent := doc.dict(d["Parent"]); resources == nil && parent != nil; parent = doc.dict(parent["Parent"]) {
+				resources = doc.dict(parent["Resources"])
+			}
+			if len(pages) >= maxPages {
+				return nil, fmt.Errorf("PDF 页数过多，最多支持 %d 页", maxPages)
+			}
+			pages = append(pages, pdfPage{dict: d, resources: resources})
+		}
+	}
+	return pages, nil
+}
+
+// pdfFont 文本解码所需的字体信息
+type pdfFont struct {
+	toUnicode map[uint32]string
+	codeLen   int  // 字符编码字节数
+	composite bool // Type0 复合字体
+}
+
+// loadFonts 加载页面资源中的字体
+func (doc *pdfDocument) loadFonts(resources pdfDict) map[string]*pdfFont {
+	fonts := make(map[string]*pdfFont)
+	for name, ref := range doc.dict(resources["Font"]) {
+		fontDict := doc.dict(ref)
+		if fontDict == nil {
+			continue
+		}
+		font := &pdfFont{codeLen: 1, composite: fontDict["Subtype"] == pdfName("Type0")}
+		if font.composite {
+			font.codeLen = 2
+		}
+		if stream, ok := doc.resolve(fontDict["ToUnicode"]).(*pdfStream); ok {
+			if data, err := doc.decodeStream(stream); err == nil {
+				font.toUnicode, font.codeLen = parseToUnicodeCMap(data, font.codeLen)
+			}
+		}
+		fonts[name] = font
+	}
+	return fonts
+}
+
+// parseToUnicodeCMap 解析 ToUnicode CMap 的 bfchar/bfrange 映射
+func parseToUnicodeCMap(data []byte, defaultCodeLen int) (map[uint32]string, int) {
+	mapping := make(map[uint32]string)
+	codeLen := defaultCodeLen
+	lx := &pdfLexer{data: data}
+
+	readHexPair := func() ([]byte, []byte, bool) {
+		a, err1 := lx.next()
+		b, err2 := lx.next()
+		ab, ok1 := a.([]byte)
+		bb, ok2 := b.([]byte)
+		return ab, bb, err1 == nil && err2 == nil && ok1 && ok2
+	}
+
+	for {
+		tok, err := lx.next()
+		if err != nil {
+			break
+		}
+		switch tok {
+		case pdfKeyword("begincodespacerange"):
+			for {
+				lo, _, ok := readHexPair()
+				if !ok {
+					break
+				}
+				codeLen = len(lo)
+			}
+		case pdfKeyword("beginbfchar"):
+			for {
+				src, dst, ok := readHexPair()
+				if !ok {
+					break
+				}
+				mapping[pdfCode(src)] = decodeUTF16BE(dst)
+			}
+		case pdfKeyword("beginbfrange"):
+			for {
+				lo, hi, ok := readHexPair()
+				if !ok {
+					break
+				}
+				dstTok, err := lx.next()
+				if err != nil {
+					break
+				}
+				start, end := pdfCode(lo), pdfCode(hi)
+				if end < start || end-start > 0xFFFF {
+					continue
+				}
+				switch dst := dstTok.(type) {
+				case []byte:
+					for code := start; code <= end; code++ {
+						mapping[code] = decodeUTF16BE(incrementLastByte(dst, int(code-start)))
+					}
+				case pdfKeyword:
+					if dst != "[" {
+						continue
+					}
+					arr, _ := lx.parseObjectFrom(dst, 0)
+					items, _ := arr.([]any)
+					for i, item := range items {
+						if b, ok := item.([]byte); ok && start+uint32(i) <= end {
+							mapping[start+uint32(i)] = decodeUTF16BE(b)
+						}
+					}
+				}
+			}
+		}
+	}
+	return mapping, codeLen
+}
+
+// pdfCode 将字节序列按大端序转换为字符编码
+func pdfCode(b []byte) uint32 {
+	var code uint32
+	for _, c := range b {
+		code = code<<8 | uint32(c)
+	}
+	return code
+}
+
+// incrementLastByte bfrange 目标值按偏移递增（只作用于最后一个字节）
+func incrementLastByte(b []byte, offset int) []byte {
+	out := append([]byte(nil), b...)
+	if len(out) > 0 {
+		out[len(out)-1] += byte(offset)
+	}
+	return out
+}
+
+// decodeUTF16BE 解码 UTF-16BE 字节序列
+func decodeUTF16BE(b []byte) string {
+	units := make([]uint16, 0, len(b)/2)
+	for i := 0; i+1 < len(b); i += 2 {
+		units = append(units, uint16(b[i])<<8|uint16(b[i+1]))
+	}
+	return string(utf16.Decode(units))
+}
+
+// winAnsiExtras WinAnsiEncoding 中 0x80-0x9F 区间与 Latin-1 不同的常用字符
+var winAnsiExtras = map[byte]rune{
+	0x80: '€', 0x85: '…', 0x91: '‘', 0x92: '’', 0x93: '“', 0x94: '”',
+	0x95: '•', 0x96: '–', 0x97: '—', 0x99: '™',
+}
+
+// decode 按字体解码文本字符串
+func (f *pdfFont) decode(b []byte) string {
+	var sb strings.Builder
+	if f == nil || (f.toUnicode == nil && !f.composite) {
+		for _, c := range b {
+			if r, ok := winAnsiExtras[c]; ok {
+				sb.WriteRune(r)
+			} else {
+				sb.WriteRune(rune(c))
+			}
+		}
+		return sb.String()
+	}
+	if f.toUnicode == nil {
+		return "" // 没有 ToUnicode 的复合字体无法还原文本
+	}
+	for i := 0; i+f.codeLen <= len(b); i += f.codeLen {
+		code := pdfCode(b[i : i+f.codeLen])
+		if s, ok := f.toUnicode[code]; ok {
+			sb.WriteString(s)
+		} else if f.codeLen == 1 {
+			sb.WriteRune(rune(code))
+		}
+	}
+	return sb.String()
+}
+
+// pdfTextWriter 收集页面文本并处理换行
+type pdfTextWriter struct {
+	sb strings.Builder
+}
+
+func (w *pdfTextWriter) write(s string) {
+	w.sb.WriteString(s)
+}
+
+func (w *pdfTextWriter) space() {
+	s := w.sb.String()
+	if s != "" && !strings.HasSuffix(s, " ") && !strings.HasSuffix(s, "\n") {
+		w.sb.WriteByte(' ')
+	}
+}
+
+func (w *pdfTextWriter) newline() {
+	s := w.sb.String()
+	if s != "" && !strings.HasSuffix(s, "\n") {
+		w.sb.WriteByte('\n')
+	}
+}
+
+// extractPageText 解释页面内容流中的文本操作符
+func (doc *pdfDocument) extractPageText(page pdfPage) string {
+	var contents []byte
+	switch c := doc.resolve(page.dict["Contents"]).(type) {
+	case *pdfStream:
+		contents, _ = doc.decodeStream(c)
+	case []any:
+		for _, item := range c {
+			if stream, ok := doc.resolve(item).(*pdfStream); ok {
+				if data, err := doc.decodeStream(stream); err == nil {
+					contents = append(contents, data...)
+					contents = append(contents, '\n')
+				}
+			}
+		}
+	}
+	if len(contents) == 0 {
+		return ""
+	}
+
+	fonts := doc.loadFonts(page.resources)
+	var font *pdfFont
+	var out pdfTextWriter
+	var operands []any
+	lastY, hasY := 0.0, false
+	lx := &pdfLexer{data: contents}
+
+	show := func(v any) {
+		if b, ok := v.([]byte); ok {
+			out.write(font.decode(b))
+		}
+	}
+	number := func(i int) float64 {
+		if i < 0 || i >= len(operands) {
+			return 0
+		}
+		n, _ := operands[i].(float64)
+		return n
+	}
+
+	for {
+		tok, err := lx.next()
+		if err != nil {
+			break
+		}
+		op, isOp := tok.(pdfKeyword)
+		if !isOp || op == "[" || op == "<<" {
+			obj, _ := lx.parseObjectFrom(tok, 0)
+			operands = append(operands, obj)
+			continue
+		}
+
+		switch op {
+		case "BI":
+			// 跳过内联图片数据
+			skipInlineImage(lx)
+		case "Tf":
+			if len(operands) > 0 {
+				if name, ok := operands[0].(pdfName); ok {
+					font = fonts[string(name)]
+				}
+			}
+		case "Tj":
+			if len(operands) > 0 {
+				show(operands[len(operands)-1])
+			}
+		case "'", "\"":
+			out.newline()
+			if len(operands) > 0 {
+				show(operands[len(operands)-1])
+			}
+		case "TJ":
+			if len(operands) > 0 {
+				items, _ := operands[len(operands)-1].([]any)
+				for _, item := range items {
+					if n, ok := item.(float64); ok {
+						if n < -200 {
+							out.space() // 较大的字距调整通常表示单词间隔
+						}
+						continue
+					}
+					show(item)
+				}
+			}
+		case "Td", "TD":
+			if number(1) != 0 {
+				out.newline()
+			} else if number(0) > 0 {
+				out.space()
+			}
+		case "T*":
+			out.newline()
+		case "Tm":
+			if y := number(5); !hasY || y != lastY {
+				out.newline()
+				lastY, hasY = y, true
+			}
+		case "ET":
+			out.space()
+		}
+		operands = operands[:0]
+	}
+	return out.sb.String()
+}
+
+// skipInlineImage 跳过 BI ... ID <数据> EI 内联图片
+func skipInlineImage(lx *pdfLexer) {
+	i := bytes.Index(lx.data[lx.pos:], []byte("ID"))
+	if i < 0 {
+		lx.pos = len(lx.data)
+		return
+	}
+	pos := lx.pos + i + 2
+	for {
+		j := bytes.Index(lx.data[pos:], []byte("EI"))
+		if j < 0 {
+			lx.pos = len(lx.data)
+			return
+		}
+		end := pos + j
+		before := end > 0 && isPDFSpace(lx.data[end-1])
+		after := end+2 >= len(lx.data) || isPDFSpace(lx.data[end+2])
+		if before && after {
+			lx.pos = end + 2
+			return
+		}
+		pos = end + 2
+	}
+}
+
+var pdfBlankLines = regexp.MustCompile(`\n{3,}`)
+
+// ExtractPDFText 提取 PDF 文档中的文本，页面之间以空行分隔
+// 页数超过 maxPages 时返回错误；返回提取的文本和页数
+func ExtractPDFText(data []byte, maxPages int) (string, int, error) {
+	doc, err := loadPDF(data)
+	if err != nil {
+		return "", 0, err
+	}
+	pages, err := doc.pages(maxPages)
+	if err != nil {
+		return "", 0, err
+	}
+
+	texts := make([]string, 0, len(pages))
+	for _, page := range pages {
+		lines := strings.Split(doc.extractPageText(page), "\n")
+		for i, line := range lines {
+			lines[i] = strings.TrimSpace(line)
+		}
+		if text := strings.TrimSpace(strings.Join(lines, "\n")); text != "" {
+			texts = append(texts, text)
+		}
+	}
+	return pdfBlankLines.ReplaceAllString(strings.Join(texts, "\n\n"), "\n\n"), len(pages), nil
+}
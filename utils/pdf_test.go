@@ -0,0 +1,160 @@
+package utils
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// buildTestPDF 按对象列表构造最小 PDF（对象编号从1开始，1号为 Catalog）
+func buildTestPDF(objects ...string) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("%PDF-1.4\n")
+	for i, obj := range objects {
+		fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", i+1, obj)
+	}
+	buf.WriteString("trailer\n<< /Root 1 0 R >>\n%%EOF\n")
+	return buf.Bytes()
+}
+
+// streamObject 构造内容流对象，compress 为 true 时使用 FlateDecode
+func streamObject(dict, content string, compress bool) string {
+	data := []byte(content)
+	if compress {
+		var zbuf bytes.Buffer
+		w := zlib.NewWriter(&zbuf)
+		_, _ = w.Write(data)
+		_ = w.Close()
+		data = zbuf.Bytes()
+		dict += " /Filter /FlateDecode"
+	}
+	return fmt.Sprintf("<< %s /Length %d >>\nstream\n%s\nendstream", dict, len(data), data)
+}
+
+func TestExtractPDFText_PagesInOrder(t *testing.T) {
+	pdf := buildTestPDF(
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [4 0 R 3 0 R] /Count 2 /Resources << /Font << /F1 5 0 R >> >> >>",
+		"<< /Type /Page /Parent 2 0 R /Contents 6 0 R >>",
+		"<< /Type /Page /Parent 2 0 R /Contents 7 0 R >>",
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica >>",
+		streamObject("", "BT /F1 12 Tf 72 700 Td (Second page) Tj ET", true),
+		streamObject("", "BT /F1 12 Tf 72 700 Td [(Hello)-300(World)] TJ 0 -14 Td (Line \\(two\\)) Tj ET", false),
+	)
+
+	text, pages, err := ExtractPDFText(pdf, 10)
+	require.NoError(t, err)
+	assert.Equal(t, 2, pages)
+	assert.Equal(t, "Hello World\nLine (two)\n\nSecond page", text)
+}
+
+func TestExtractPDFText_ToUnicodeCMap(t *testing.T) {
+	cmap := strings.Join([]string{
+		"begincmap",
+		"1 begincodespacerange <0000> <FFFF> endcodespacerange",
+		"1 beginbfchar <0001> <4F60> endbfchar",
+		"1 beginbfrange <0002> <0003> <597D> endbfrange",
+		"endcmap",
+	}, "\n")
+	pdf := buildTestPDF(
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+		"<< /Type /Page /Parent 2 0 R /Resources << /Font << /F1 4 0 R >> >> /Contents 6 0 R >>",
+		"<< /Type /Font /Subtype /Type0 /Encoding /Identity-H /ToUnicode 5 0 R >>",
+		streamObject("", cmap, true),
+		streamObject("", "BT /F1 12 Tf <00010002> Tj ET", true),
+	)
+
+	text, _, err := ExtractPDFText(pdf, 10)
+	require.NoError(t, err)
+	assert.Equal(t, "你好", text)
+}
+
+func TestExtractPDFText_Errors(t *testing.T) {
+	_, _, err := ExtractPDFText([]byte("not a pdf"), 10)
+	assert.ErrorContains(t, err, "不是有效的 PDF")
+
+	pages := buildTestPDF(
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R 3 0 R 4 0 R] /Count 2 >>",
+		"<< /Type /Page /Parent 2 0 R >>",
+		"<< /Type /Page /Parent 2 0 R >>",
+	)
+	_, _, err = ExtractPDFText(pages, 1)
+	assert.ErrorContains(t, err, "页数过多")
+
+	encrypted := append(buildTestPDF("<< /Type /Catalog >>"), []byte("trailer\n<< /Encrypt 9 0 R >>\n")...)
+	_, _, err = ExtractPDFText(encrypted, 10)
+	assert.ErrorContains(t, err, "加密")
+}
+
+func TestExtractPDFText_ObjectStream(t *testing.T) {
+	// 页面树和页面对象位于压缩对象流中（PDF 1.5+）
+	pagesObj := "<< /Type /Pages /Kids [3 0 R] /Count 1 >>"
+	pageObj := "<< /Type /Page /Parent 2 0 R /Contents 5 0 R >>"
+	header := fmt.Sprintf("2 0 3 %d ", len(pagesObj)+1)
+	body := header + pagesObj + " " + pageObj
+
+	pdf := buildTestPDF(
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"null",
+		"null",
+		streamObject(fmt.Sprintf("/Type /ObjStm /N 2 /First %d", len(header)), body, true),
+		streamObject("", "BT (From object stream) Tj ET", true),
+	)
+	// 移除对象2、3的占位定义（文件中直接定义的对象优先于对象流）
+	pdf = bytes.Replace(pdf, []byte("2 0 obj\nnull\nendobj\n"), nil, 1)
+	pdf = bytes.Replace(pdf, []byte("3 0 obj\nnull\nendobj\n"), nil, 1)
+
+	text, pages, err := ExtractPDFText(pdf, 10)
+	require.NoError(t, err)
+	assert.Equal(t, 1, pages)
+	assert.Equal(t, "From object stream", text)
+}
+
+func TestExtractPDFText_MalformedObjectStream(t *testing.T) {
+	// 对象流头部中的非法 /First 和偏移量不能导致越界 panic
+	cases := []struct {
+		name   string
+		dict   string
+		header string
+	}{
+		{"负数 First", "/Type /ObjStm /N 1 /First -5", "2 0 "},
+		{"小数 First", "/Type /ObjStm /N 1 /First 2.5", "2 0 "},
+		{"负数偏移量", "/Type /ObjStm /N 1 /First 6", "5 -100"},
+		{"小数偏移量", "/Type /ObjStm /N 1 /First 6", "5 0.5 "},
+		{"超大 N", "/Type /ObjStm /N 1e300 /First 4", "5 0 "},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			pdf := buildTestPDF(
+				"<< /Type /Catalog /Pages 2 0 R >>",
+				"<< /Type /Pages /Kids [] /Count 0 >>",
+				streamObject(tc.dict, tc.header+"<< /Type /Page >>", true),
+			)
+			assert.NotPanics(t, func() {
+				_, _, _ = ExtractPDFText(pdf, 10)
+			})
+		})
+	}
+}
+
+func TestPDFLexer_OutOfRangePosition(t *testing.T) {
+	for _, pos := range []int{-10, 100} {
+		lx := &pdfLexer{data: []byte("1 0 obj"), pos: pos}
+		_, err := lx.next()
+		assert.ErrorIs(t, err, io.EOF)
+	}
+
+	// 未闭合的十六进制字符串不能让位置越过末尾
+	lx := &pdfLexer{data: []byte("<414")}
+	_, err := lx.next()
+	require.NoError(t, err)
+	assert.Equal(t, len(lx.data), lx.pos)
+}
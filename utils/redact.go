@@ -0,0 +1,18 @@
+package utils
+
+import "kiro2api/logger"
+
+// RedactedPlaceholder 脱敏后的占位文本
+const RedactedPlaceholder = logger.RedactedPlaceholder
+
+// RedactSecrets 对文本中的密钥、令牌、邮箱和大段 base64 数据脱敏
+// 规则由 logger 包统一维护，与日志输出保持一致
+func RedactSecrets(s string) string {
+	return logger.Redact(s)
+}
+
+// TruncatePayload 按字节上限截断文本（不拆分 UTF-8 字符），并注明原始长度
+// maxBytes <= 0 表示不限制
+func TruncatePayload(s string, maxBytes int) string {
+	return logger.TruncatePayload(s, maxBytes)
+}
@@ -347,7 +347,7 @@ func (e *TokenEstimator) EstimateToolUseTokens(toolName string, toolInput map[st
 // 支持的内容类型：
 // - text: 文本块
 // - image: 图片（固定1500 tokens估算）
-// - document: 文档（根据大小估算）
+// - document: 文档（按提取的文本估算）
 func (e *TokenEstimator) estimateContentBlock(block any) int {
 	blockMap, ok := block.(map[string]any)
 	if !ok {
@@ -370,8 +370,15 @@ func (e *TokenEstimator) estimateContentBlock(block any) int {
 		return 1500
 
 	case "document":
-		// 文档：根据大小估算（简化处理）
-		return 500
+		// 文档：按提取的文本估算（与转换时注入的上下文一致），无法提取时保守估算
+		source, _ := blockMap["source"].(map[string]any)
+		text, err := ExtractDocumentText(DocumentSourceFromMap(source))
+		if err != nil {
+			return 500
+		}
+		title, _ := blockMap["title"].(string)
+		context, _ := blockMap["context"].(string)
+		return e.EstimateTextTokens(FormatDocumentContext(title, context, text))
 
 	case "tool_use":
 		// 工具调用（在历史消息中的 assistant 消息可能包含）
@@ -417,6 +424,20 @@ func (e *TokenEstimator) estimateTypedContentBlock(block types.ContentBlock) int
 		// 图片：官方文档显示约1000-2000 tokens
 		return 1500
 
+	case "document":
+		text, err := ExtractDocumentText(block.Source)
+		if err != nil {
+			return 500
+		}
+		var title, context string
+		if block.Title != nil {
+			title = *block.Title
+		}
+		if block.Context != nil {
+			context = *block.Context
+		}
+		return e.EstimateTextTokens(FormatDocumentContext(title, context, text))
+
 	case "tool_use":
 		// 工具调用（在历史消息中的 assistant 消息可能包含）
 		toolName := ""
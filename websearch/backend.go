@@ -0,0 +1,157 @@
+package websearch
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+
+	"kiro2api/config"
+)
+
+// 网络搜索后端
+// 上游不支持 web_search 服务端工具：代理向上游声明一个普通的搜索工具，模型调用时由本包查询配置的后端，
+// 结果作为工具结果回填后继续生成。后端可替换：SearXNG 兼容的 JSON 接口，或返回本地文件内容的 static 后端
+// 本包只依赖 config，供 server 和 converter 引用
+
+// Result 单条搜索结果
+type Result struct {
+	Title   string `json:"title"`
+	URL     string `json:"url"`
+	Content string `json:"content,omitempty"`  // 摘要
+	PageAge string `json:"page_age,omitempty"` // 发布时间
+}
+
+// Query 搜索请求
+type Query struct {
+	Query          string
+	MaxResults     int
+	AllowedDomains []string // 只保留这些域名（含子域名）的结果，为空时不限制
+	BlockedDomains []string // 排除这些域名（含子域名）的结果
+}
+
+// Backend 搜索后端
+type Backend interface {
+	Name() string
+	Search(ctx context.Context, q Query) ([]Result, error)
+}
+
+// New 按配置创建搜索后端，未启用时返回错误
+func New(opts config.WebSearchOptions) (Backend, error) {
+	switch opts.Backend {
+	case config.WebSearchBackendSearXNG:
+		return newSearXNGBackend(opts)
+	case config.WebSearchBackendStatic:
+		return newStaticBackend(opts.StaticFile)
+	case "", config.WebSearchBackendNone:
+		return nil, errors.New("未配置搜索后端")
+	default:
+		return nil, fmt.Errorf("不支持的搜索后端: %s", opts.Backend)
+	}
+}
+
+var current struct {
+	sync.Mutex
+	opts    config.WebSearchOptions
+	backend Backend
+}
+
+// Current 返回当前配置对应的搜索后端（配置未变化时复用）
+func Current() (Backend, error) {
+	opts := config.GetWebSearchOptions()
+	current.Lock()
+	defer current.Unlock()
+	if current.backend != nil && current.opts == opts {
+		return current.backend, nil
+	}
+	backend, err := New(opts)
+	if err != nil {
+		return nil, err
+	}
+	current.opts, current.backend = opts, backend
+	return backend, nil
+}
+
+// Search 执行搜索，按域名过滤并截取结果数
+func Search(ctx context.Context, backend Backend, q Query) ([]Result, error) {
+	results, err := backend.Search(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	filtered := make([]Result, 0, len(results))
+	for _, r := range results {
+		if !domainAllowed(r.URL, q.AllowedDomains, q.BlockedDomains) {
+			continue
+		}
+		filtered = append(filtered, r)
+		if q.MaxResults > 0 && len(filtered) >= q.MaxResults {
+			break
+		}
+	}
+	return filtered, nil
+}
+
+// domainAllowed 判断结果 URL 的域名是否满足允许/排除列表
+func domainAllowed(rawURL string, allowed, blocked []string) bool {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Hostname() == "" {
+		return false
+	}
+	host := strings.ToLower(u.Hostname())
+	matches := func(domains []string) bool {
+		for _, d := range domains {
+			d = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(d), "*."))
+			if d != "" && (host == d || strings.HasSuffix(host, "."+d)) {
+				return true
+			}
+		}
+		return false
+	}
+	if matches(blocked) {
+		return false
+	}
+	return len(allowed) == 0 || matches(allowed)
+}
+
+// FormatResults 将搜索结果格式化为回填给模型的文本（query 为空时省略关键词）
+func FormatResults(query string, results []Result) string {
+	var sb strings.Builder
+	if query == "" {
+		sb.WriteString("Web search results:\n")
+	} else {
+		fmt.Fprintf(&sb, "Web search results for %q:\n", query)
+	}
+	if len(results) == 0 {
+		sb.WriteString("No results found.\n")
+		return sb.String()
+	}
+	for i, r := range results {
+		fmt.Fprintf(&sb, "\n[%d] %s\nURL: %s\n", i+1, r.Title, r.URL)
+		if r.PageAge != "" {
+			fmt.Fprintf(&sb, "Published: %s\n", r.PageAge)
+		}
+		if r.Content != "" {
+			sb.WriteString(r.Content + "\n")
+		}
+	}
+	sb.WriteString("\nCite the sources you use by URL.")
+	return sb.String()
+}
+
+// EncodeContent 将摘要编码为 web_search_result 的 encrypted_content
+// 客户端会在后续对话中原样回传，代理据此还原摘要（不做加密，仅保持与官方字段一致）
+func EncodeContent(content string) string {
+	return base64.StdEncoding.EncodeToString([]byte(content))
+}
+
+// DecodeContent 还原 EncodeContent 编码的摘要，无法解码时返回空字符串
+func DecodeContent(encoded string) string {
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
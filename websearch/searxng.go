@@ -0,0 +1,94 @@
+package websearch
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"kiro2api/config"
+)
+
+// maxSearXNGResponseBytes SearXNG 响应体的大小上限
+const maxSearXNGResponseBytes = 4 * 1024 * 1024
+
+// searxngBackend SearXNG 兼容的 JSON 搜索接口（GET /search?q=...&format=json）
+type searxngBackend struct {
+	endpoint string
+	client   *http.Client
+}
+
+func newSearXNGBackend(opts config.WebSearchOptions) (*searxngBackend, error) {
+	if opts.SearXNGURL == "" {
+		return nil, errors.New("未配置 WEB_SEARCH_SEARXNG_URL")
+	}
+	base, err := url.Parse(strings.TrimRight(opts.SearXNGURL, "/"))
+	if err != nil || (base.Scheme != "http" && base.Scheme != "https") {
+		return nil, fmt.Errorf("无效的 SearXNG 地址: %s", opts.SearXNGURL)
+	}
+	timeout := opts.Timeout
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+	return &searxngBackend{
+		endpoint: base.String() + "/search",
+		client:   &http.Client{Timeout: timeout},
+	}, nil
+}
+
+func (b *searxngBackend) Name() string {
+	return config.WebSearchBackendSearXNG
+}
+
+// searxngResponse SearXNG JSON 输出中用到的字段
+type searxngResponse struct {
+	Results []struct {
+		URL           string `json:"url"`
+		Title         string `json:"title"`
+		Content       string `json:"content"`
+		PublishedDate string `json:"publishedDate"`
+	} `json:"results"`
+}
+
+func (b *searxngBackend) Search(ctx context.Context, q Query) ([]Result, error) {
+	params := url.Values{}
+	params.Set("q", q.Query)
+	params.Set("format", "json")
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, b.endpoint+"?"+params.Encode(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("创建搜索请求失败: %v", err)
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("搜索请求失败: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("搜索请求失败: HTTP %d", resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxSearXNGResponseBytes))
+	if err != nil {
+		return nil, fmt.Errorf("读取搜索结果失败: %v", err)
+	}
+	var parsed searxngResponse
+	if err := json.Unmarshal(body, &parsed); err != nil {
+		return nil, fmt.Errorf("解析搜索结果失败（SearXNG 需在 settings.yml 中开启 json 格式）: %v", err)
+	}
+
+	results := make([]Result, 0, len(parsed.Results))
+	for _, r := range parsed.Results {
+		if r.URL == "" {
+			continue
+		}
+		results = append(results, Result{Title: r.Title, URL: r.URL, Content: r.Content, PageAge: r.PublishedDate})
+	}
+	return results, nil
+}
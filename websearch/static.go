@@ -0,0 +1,61 @@
+package websearch
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"kiro2api/config"
+)
+
+// staticBackend 从本地 JSON 文件返回固定结果，用于离线环境和测试
+// 文件格式：结果数组（所有查询返回相同结果），或以查询为键的对象（不区分大小写，"*" 为默认结果）
+type staticBackend struct {
+	byQuery  map[string][]Result
+	fallback []Result
+}
+
+func newStaticBackend(path string) (*staticBackend, error) {
+	if path == "" {
+		return nil, errors.New("未配置 WEB_SEARCH_STATIC_FILE")
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("读取搜索结果文件失败: %v", err)
+	}
+
+	backend := &staticBackend{byQuery: make(map[string][]Result)}
+	if err := json.Unmarshal(data, &backend.fallback); err == nil {
+		return backend, nil
+	}
+	var byQuery map[string][]Result
+	if err := json.Unmarshal(data, &byQuery); err != nil {
+		return nil, fmt.Errorf("解析搜索结果文件失败（应为结果数组或以查询为键的对象）: %v", err)
+	}
+	for query, results := range byQuery {
+		if query == "*" {
+			backend.fallback = results
+			continue
+		}
+		backend.byQuery[normalizeQuery(query)] = results
+	}
+	return backend, nil
+}
+
+func normalizeQuery(query string) string {
+	return strings.ToLower(strings.Join(strings.Fields(query), " "))
+}
+
+func (b *staticBackend) Name() string {
+	return config.WebSearchBackendStatic
+}
+
+func (b *staticBackend) Search(_ context.Context, q Query) ([]Result, error) {
+	if results, ok := b.byQuery[normalizeQuery(q.Query)]; ok {
+		return results, nil
+	}
+	return b.fallback, nil
+}
@@ -0,0 +1,105 @@
+package websearch
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"kiro2api/config"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSearXNGBackend(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/search", r.URL.Path)
+		assert.Equal(t, "golang 1.23", r.URL.Query().Get("q"))
+		assert.Equal(t, "json", r.URL.Query().Get("format"))
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"results":[
+			{"url":"https://go.dev/doc/go1.23","title":"Go 1.23 Release Notes","content":"Range over func.","publishedDate":"2024-08-13"},
+			{"url":"https://spam.example.com/go","title":"Spam","content":"..."},
+			{"url":"https://blog.go.dev/go1.23","title":"Go 1.23 is released","content":"Announcement."}
+		]}`))
+	}))
+	defer server.Close()
+
+	backend, err := New(config.WebSearchOptions{Backend: config.WebSearchBackendSearXNG, SearXNGURL: server.URL + "/", Timeout: time.Second})
+	require.NoError(t, err)
+	assert.Equal(t, "searxng", backend.Name())
+
+	results, err := Search(context.Background(), backend, Query{Query: "golang 1.23", MaxResults: 5, BlockedDomains: []string{"example.com"}})
+	require.NoError(t, err)
+	require.Len(t, results, 2)
+	assert.Equal(t, Result{Title: "Go 1.23 Release Notes", URL: "https://go.dev/doc/go1.23", Content: "Range over func.", PageAge: "2024-08-13"}, results[0])
+	assert.Equal(t, "https://blog.go.dev/go1.23", results[1].URL)
+}
+
+func TestSearXNGBackend_HTTPError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer server.Close()
+
+	backend, err := New(config.WebSearchOptions{Backend: config.WebSearchBackendSearXNG, SearXNGURL: server.URL, Timeout: time.Second})
+	require.NoError(t, err)
+	_, err = Search(context.Background(), backend, Query{Query: "x"})
+	assert.ErrorContains(t, err, "429")
+
+	_, err = New(config.WebSearchOptions{Backend: config.WebSearchBackendSearXNG})
+	assert.ErrorContains(t, err, "WEB_SEARCH_SEARXNG_URL")
+}
+
+func TestStaticBackend(t *testing.T) {
+	dir := t.TempDir()
+
+	listFile := filepath.Join(dir, "list.json")
+	require.NoError(t, os.WriteFile(listFile, []byte(`[{"title":"A","url":"https://a.com"},{"title":"B","url":"https://b.com"}]`), 0o600))
+	backend, err := New(config.WebSearchOptions{Backend: config.WebSearchBackendStatic, StaticFile: listFile})
+	require.NoError(t, err)
+	results, err := Search(context.Background(), backend, Query{Query: "anything", MaxResults: 1})
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	assert.Equal(t, "A", results[0].Title)
+
+	mapFile := filepath.Join(dir, "map.json")
+	require.NoError(t, os.WriteFile(mapFile, []byte(`{
+		"Weather  Paris": [{"title":"Paris weather","url":"https://weather.example/paris"}],
+		"*": [{"title":"Default","url":"https://default.example"}]
+	}`), 0o600))
+	backend, err = New(config.WebSearchOptions{Backend: config.WebSearchBackendStatic, StaticFile: mapFile})
+	require.NoError(t, err)
+	results, err = Search(context.Background(), backend, Query{Query: "weather paris"})
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	assert.Equal(t, "Paris weather", results[0].Title)
+	results, err = Search(context.Background(), backend, Query{Query: "other"})
+	require.NoError(t, err)
+	assert.Equal(t, "Default", results[0].Title)
+}
+
+func TestDomainAllowed(t *testing.T) {
+	assert.True(t, domainAllowed("https://docs.go.dev/x", []string{"go.dev"}, nil))
+	assert.True(t, domainAllowed("https://go.dev/x", []string{"go.dev"}, nil))
+	assert.False(t, domainAllowed("https://notgo.dev/x", []string{"go.dev"}, nil))
+	assert.False(t, domainAllowed("https://www.example.com/x", nil, []string{"example.com"}))
+	assert.True(t, domainAllowed("https://example.org/x", nil, []string{"example.com"}))
+	assert.False(t, domainAllowed("not a url", nil, nil))
+}
+
+func TestFormatResultsAndContentEncoding(t *testing.T) {
+	text := FormatResults("go generics", []Result{{Title: "Tutorial", URL: "https://go.dev/doc/tutorial/generics", Content: "Getting started.", PageAge: "2022-03-15"}})
+	assert.Contains(t, text, `Web search results for "go generics":`)
+	assert.Contains(t, text, "[1] Tutorial\nURL: https://go.dev/doc/tutorial/generics\nPublished: 2022-03-15\nGetting started.")
+	assert.Contains(t, FormatResults("", nil), "Web search results:\nNo results found.")
+
+	encoded := EncodeContent("snippet 摘要")
+	assert.NotEqual(t, "snippet 摘要", encoded)
+	assert.Equal(t, "snippet 摘要", DecodeContent(encoded))
+	assert.Empty(t, DecodeContent("%%%"))
+}